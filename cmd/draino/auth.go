@@ -0,0 +1,101 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/subtle"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/planetlabs/draino/internal/kubernetes"
+)
+
+// An authenticator protects HTTP handlers that change draino's state. A
+// request is allowed if it presents a client certificate that was verified
+// when its TLS connection was established, a bearer token read from a file,
+// or a bearer token that the Kubernetes API authorizes.
+type authenticator struct {
+	l *zap.Logger
+
+	clientCerts bool
+	tokens      []string
+	api         *kubernetes.APIAuthorizer
+}
+
+// readTokens reads bearer tokens from the supplied file, one per line.
+func readTokens(file string) ([]string, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read bearer tokens from %s", file)
+	}
+	tokens := []string{}
+	for _, t := range strings.Split(string(b), "\n") {
+		if t = strings.TrimSpace(t); t != "" {
+			tokens = append(tokens, t)
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, errors.Errorf("no bearer tokens in %s", file)
+	}
+	return tokens, nil
+}
+
+// Enabled returns true if any means of authentication is configured.
+func (a *authenticator) Enabled() bool {
+	return a.clientCerts || len(a.tokens) > 0 || a.api != nil
+}
+
+// Wrap the supplied handler such that it serves only authorized requests.
+func (a *authenticator) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.clientCerts && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || token == r.Header.Get("Authorization") {
+			r.Body.Close() // nolint:gosec
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+		for _, t := range a.tokens {
+			if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+				h.ServeHTTP(w, r)
+				return
+			}
+		}
+		if a.api != nil {
+			ok, err := a.api.Authorize(token, strings.ToLower(r.Method), r.URL.Path)
+			if err != nil {
+				a.l.Info("Cannot authorize request", zap.String("path", r.URL.Path), zap.Error(err))
+				r.Body.Close() // nolint:gosec
+				http.Error(w, "cannot authorize request", http.StatusInternalServerError)
+				return
+			}
+			if ok {
+				h.ServeHTTP(w, r)
+				return
+			}
+		}
+		r.Body.Close() // nolint:gosec
+		http.Error(w, "forbidden", http.StatusForbidden)
+	})
+}
@@ -18,23 +18,55 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
+	"io/ioutil"
 	"net/http"
+	"net/http/pprof"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	awssdk "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/julienschmidt/httprouter"
 	"github.com/oklog/run"
+	"github.com/pkg/errors"
 	"go.opencensus.io/exporter/prometheus"
 	"go.opencensus.io/stats/view"
 	"go.opencensus.io/tag"
+	"go.opencensus.io/trace"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"golang.org/x/time/rate"
 	"gopkg.in/alecthomas/kingpin.v2"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
 	client "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 
+	"github.com/planetlabs/draino/internal/aws"
+	"github.com/planetlabs/draino/internal/azure"
+	"github.com/planetlabs/draino/internal/gcp"
 	"github.com/planetlabs/draino/internal/kubernetes"
+	"github.com/planetlabs/draino/plugin"
 )
 
 // TODO(negz): Use leader election? We don't really want more than one draino
@@ -45,123 +77,1695 @@ func main() {
 		app = kingpin.New(filepath.Base(os.Args[0]), "Automatically cordons and drains nodes that match the supplied conditions.").DefaultEnvars()
 
 		debug            = app.Flag("debug", "Run with debug logging.").Short('d').Bool()
-		listen           = app.Flag("listen", "Address at which to expose /metrics and /healthz.").Default(":10002").String()
+		listen           = app.Flag("listen", "Address at which to expose /metrics, /healthz, /livez, and, unless --admin-listen is set, /status, /history, /exemplars, /loglevel, and /acknowledge-correlated-failure.").Default(":10002").String()
 		kubecfg          = app.Flag("kubeconfig", "Path to kubeconfig file. Leave unset to use in-cluster config.").String()
-		apiserver        = app.Flag("master", "Address of Kubernetes API server. Leave unset to use in-cluster config.").String()
+		apiservers       = app.Flag("master", "Address of Kubernetes API server. May be specified multiple times to fail over between the API servers of a highly available control plane. Leave unset to use in-cluster config.").Strings()
+		kubeContexts     = app.Flag("context", "Kubeconfig context of a cluster in which to cordon and drain nodes. May be specified multiple times to manage several clusters at once. Leave unset to use the current context.").Strings()
 		dryRun           = app.Flag("dry-run", "Emit an event without cordoning or draining matching nodes.").Bool()
+		dryRunTiming     = app.Flag("dry-run-drain-timing", "In --dry-run mode, estimate how long each drain would take from its pods' grace periods and disruption budgets, and take that long to finish each simulated drain, so that drains are scheduled as they would be.").Bool()
+		observeOnly      = app.Flag("observe-only", "Log and export every decision without making any changes to the cluster at all, not even recording events. Implies --dry-run.").Bool()
 		maxGracePeriod   = app.Flag("max-grace-period", "Maximum time evicted pods will be given to terminate gracefully.").Default(kubernetes.DefaultMaxGracePeriod.String()).Duration()
 		evictionHeadroom = app.Flag("eviction-headroom", "Additional time to wait after a pod's termination grace period for it to have been deleted.").Default(kubernetes.DefaultEvictionOverhead.String()).Duration()
 		drainBuffer      = app.Flag("drain-buffer", "Minimum time between starting each drain. Nodes are always cordoned immediately.").Default(kubernetes.DefaultDrainBuffer.String()).Duration()
 		nodeLabels       = app.Flag("node-label", "Only nodes with this label will be eligible for cordoning and draining. May be specified multiple times.").PlaceHolder("KEY=VALUE").StringMap()
 
-		evictDaemonSetPods    = app.Flag("evict-daemonset-pods", "Evict pods that were created by an extant DaemonSet.").Bool()
+		gracePeriodPolicy  = app.Flag("grace-period-policy", "How --max-grace-period determines the grace period with which pods are evicted; one of pod (use each pod's own termination grace period), cap (use the shorter of the two), or override (always use --max-grace-period).").Default(kubernetes.GracePeriodPolicyCap).Enum(kubernetes.GracePeriodPolicyPod, kubernetes.GracePeriodPolicyCap, kubernetes.GracePeriodPolicyOverride)
+		podDeletionTimeout = app.Flag("pod-deletion-timeout", "Maximum time to wait for each evicted pod to be deleted, regardless of its grace period and --eviction-headroom. Pods that are not deleted in time fail the drain and are counted by the pod_deletion_timeouts_total metric. Leave unset to wait for each pod's grace period plus --eviction-headroom.").Duration()
+
+		drainer               = app.Flag("drainer", "Strategy with which to cordon and drain nodes; one of "+strings.Join(kubernetes.CordonDrainers(), ", ")+". The api strategy evicts pods via the Kubernetes API, the webhook strategy delegates to --drainer-webhook, and the plugin strategy to the first --plugin that drains nodes.").Default(kubernetes.DrainerAPI).Enum(kubernetes.CordonDrainers()...)
+		drainerWebhook        = app.Flag("drainer-webhook", "POST to the /cordon and then the /drain path of this URL template, e.g. http://remediator:8080/nodes/{{.Name}}, to cordon and drain each node when --drainer=webhook.").PlaceHolder("URL").String()
+		drainerWebhookTimeout = app.Flag("drainer-webhook-timeout", "Maximum time to wait for each --drainer-webhook request to succeed.").Default(kubernetes.DefaultHookTimeout.String()).Duration()
+		plugins               = app.Flag("plugin", "Start the plugin at this path, which may filter pods, filter nodes, or act as the plugin drainer strategy. May be specified multiple times; pods and nodes must pass every plugin's filter.").PlaceHolder("PATH").Strings()
+		pluginTimeout         = app.Flag("plugin-timeout", "Maximum time to wait for a plugin to filter a pod or node, or to cordon a node.").Default(plugin.DefaultCallTimeout.String()).Duration()
+
+		podFilterExecs    = app.Flag("pod-filter-exec", "Run this command, with each pod as JSON on stdin, before evicting it. The pod is evicted only if the command exits 0 and does not write false to stdout. May be specified multiple times.").PlaceHolder("COMMAND").Strings()
+		nodeFilterExecs   = app.Flag("node-filter-exec", "Run this command, with each node as JSON on stdin, before cordoning it. The node is cordoned only if the command exits 0 and does not write false to stdout. May be specified multiple times.").PlaceHolder("COMMAND").Strings()
+		filterExecTimeout = app.Flag("filter-exec-timeout", "Maximum time to wait for each --pod-filter-exec or --node-filter-exec command, or --pod-filter-wasm or --node-filter-wasm module, to exit.").Default(kubernetes.DefaultExecFilterTimeout.String()).Duration()
+		podFilterWasm     = app.Flag("pod-filter-wasm", "Run each .wasm WebAssembly module in the binaryData of this NAMESPACE/NAME ConfigMap, or each WebAssembly layer of this oci://REGISTRY/REPOSITORY[:TAG|@DIGEST] artifact, with each pod as JSON on stdin, before evicting it. The pod is evicted only if every module exits 0 and does not write false to stdout. The ConfigMap is watched and the artifact polled, so modules may be updated without restarting draino.").PlaceHolder("SOURCE").String()
+		nodeFilterWasm    = app.Flag("node-filter-wasm", "Run each .wasm WebAssembly module in the binaryData of this NAMESPACE/NAME ConfigMap, or each WebAssembly layer of this oci://REGISTRY/REPOSITORY[:TAG|@DIGEST] artifact, with each node as JSON on stdin, before cordoning it. The node is cordoned only if every module exits 0 and does not write false to stdout. The ConfigMap is watched and the artifact polled, so modules may be updated without restarting draino.").PlaceHolder("SOURCE").String()
+		wasmRuntime       = app.Flag("wasm-runtime", "WASI runtime command with which to run --pod-filter-wasm and --node-filter-wasm modules. The path of each module is appended to the command.").Default(kubernetes.DefaultWasmRuntime).String()
+		wasmPollInterval  = app.Flag("wasm-poll-interval", "How often to check --pod-filter-wasm and --node-filter-wasm OCI artifacts for new modules.").Default(kubernetes.DefaultOCIWasmPollInterval.String()).Duration()
+
+		logFormat             = app.Flag("log-format", "Format in which to log. Defaults to json, or to console with --debug.").Enum("json", "console")
+		logLevels             = app.Flag("log-level", "Log at this level, e.g. debug or warn, for this subsystem; one of watcher, drainer, or scheduler. Other subsystems log at the default level. May be specified multiple times.").PlaceHolder("SUBSYSTEM=LEVEL").StringMap()
+		logSampling           = app.Flag("log-sampling", "Whether to sample repeated log entries, as configured by --log-sampling-initial and --log-sampling-thereafter. Defaults to true, or to false with --debug.").Enum("true", "false")
+		logSamplingInitial    = app.Flag("log-sampling-initial", "Number of entries with the same level and message to log each second before sampling them.").Default("100").Int()
+		logSamplingThereafter = app.Flag("log-sampling-thereafter", "Log every Nth entry with the same level and message each second once --log-sampling-initial entries have been logged.").Default("100").Int()
+
+		traceSampleProbability = app.Flag("trace-sample-probability", "Fraction of cordons and drains to trace, from 0 to 1. Sampled spans, for each cordon, drain, and eviction, are logged by the drainer subsystem, and drainer log entries within them are tagged with their trace and span IDs.").Default("0").Float64()
+
+		drainBufferJitter   = app.Flag("drain-buffer-jitter", "Maximum random time to add to --drain-buffer before starting each drain, so that several draino deployments do not drain in lockstep.").Default("0s").Duration()
+		drainBufferPerLabel = app.Flag("drain-buffer-per-label", "Apply --drain-buffer separately to each group of nodes with the same value of this label, rather than to all nodes.").PlaceHolder("KEY").String()
+		startupBacklogDelay = app.Flag("startup-backlog-delay", "Time to wait after listing nodes at startup before starting drains, so that drains of nodes that already match start in priority order.").Default(kubernetes.DefaultStartupBacklogDelay.String()).Duration()
+		shutdownGracePeriod = app.Flag("shutdown-grace-period", "Maximum time to wait for running drains to finish when shutting down. No new drains start once draino begins shutting down.").Default(kubernetes.DefaultShutdownGracePeriod.String()).Duration()
+		failedDrainCooldown = app.Flag("failed-drain-cooldown", "Do not cordon or drain a node again for this long after its drain fails, even if it is uncordoned and still matches the supplied conditions, or its drain is requested. Leave unset to allow retries at any time.").Duration()
+
+		groupDrainCooldown      = app.Flag("group-drain-cooldown", "Minimum time between successfully draining a node and starting a drain of another node with the same value of --group-drain-cooldown-label, regardless of --drain-buffer.").Duration()
+		groupDrainCooldownLabel = app.Flag("group-drain-cooldown-label", "Label that groups nodes for --group-drain-cooldown, e.g. a node pool label.").PlaceHolder("KEY").String()
+
+		keyPrefix         = app.Flag("key-prefix", "Prefix of the keys of the annotations, labels, and finalizers draino reads and writes, for example to prevent several draino deployments managing the same nodes from colliding.").Default(kubernetes.DefaultKeyPrefix).String()
+		eventReasonPrefix = app.Flag("event-reason-prefix", "Prefix of the reasons of the events draino emits, e.g. Draino. Leave unset for no prefix.").String()
+		eventComponent    = app.Flag("event-component", "Source component of the events draino emits, to distinguish the events of several draino deployments.").Default(kubernetes.Component).String()
+		eventHost         = app.Flag("event-host", "Source host of the events draino emits. Leave unset for no host.").String()
+		eventNamespace    = app.Flag("event-namespace", "Namespace in which to emit events, e.g. draino's own. Leave unset to emit node events in the default namespace.").String()
+
+		drainTimeoutBase   = app.Flag("drain-timeout-base", "Time to wait for a node's pods to be evicted, in addition to --drain-timeout-per-pod for each pod. Applies only if --drain-timeout-per-pod is set.").Default("1m").Duration()
+		drainTimeoutPerPod = app.Flag("drain-timeout-per-pod", "Additional time to wait for a node's pods to be evicted per pod to be evicted. Leave unset to wait --max-grace-period plus --eviction-headroom regardless of how many pods are evicted.").Duration()
+
+		cacheFullNodes = app.Flag("cache-full-nodes", "Cache nodes in full. By default the container images and volumes listed in each node's status, and its kubectl.kubernetes.io/last-applied-configuration annotation, are not cached, to reduce memory use. Plugins and exec filters receive cached nodes.").Bool()
+
+		nodeUpdateInterval = app.Flag("node-update-interval", "Minimum time between handling notifications about the same node. Updates to a node within this interval, such as status heartbeats, are coalesced and handled once it has elapsed. Set to 0 to handle each node as soon as it is updated.").Default(kubernetes.DefaultNodeQueueInterval.String()).Duration()
+
+		shardIndex = app.Flag("shard-index", "Index of the shard of nodes this replica cordons and drains, from zero to --shard-count minus one.").Default("0").Int()
+		shardCount = app.Flag("shard-count", "Number of shards amongst which nodes are divided. Run one replica per shard to divide nodes between several replicas.").Default("1").Int()
+		shardLabel = app.Flag("shard-label", "Assign nodes to shards by the value of this label, rather than by name, so that nodes with the same label value belong to the same shard.").String()
+
+		drainLabels       = app.Flag("drain-label", "Cordon and drain nodes with this label, regardless of their conditions. May be specified multiple times.").PlaceHolder("KEY=VALUE").StringMap()
+		urgentDrainLabels = app.Flag("urgent-drain-label", "Cordon and immediately drain nodes with this label, regardless of their conditions, ignoring the drain buffer and other drain limits. May be specified multiple times.").PlaceHolder("KEY=VALUE").StringMap()
+		drainRequests     = app.Flag("drain-requests", "Cordon and drain nodes selected by DrainRequest custom resources, regardless of their conditions.").Bool()
+		drainDeleting     = app.Flag("drain-deleting-nodes", "Immediately cordon and drain nodes that have been marked for deletion, regardless of their conditions.").Bool()
+
+		allowControlPlaneDrain = app.Flag("allow-control-plane-drain", "Drain nodes with a control plane role label, e.g. node-role.kubernetes.io/control-plane. Such nodes are otherwise at most cordoned, regardless of their pipeline or whether their drain was requested.").Bool()
+		cordonedNodePolicy     = app.Flag("cordoned-node-policy", "Whether to skip, or to drain if they match the supplied conditions or labels, nodes that were cordoned by something other than draino, for example kubectl cordon. Draino leaves such nodes cordoned once they are drained.").Default(kubernetes.CordonedNodePolicySkip).Enum(kubernetes.CordonedNodePolicySkip, kubernetes.CordonedNodePolicyDrain)
+
+		maxConcurrentDrains         = app.Flag("max-concurrent-drains", "Maximum number of nodes that may be drained at once. Leave unset for no limit.").Int()
+		maxConcurrentDrainsPerLabel = app.Flag("max-concurrent-drains-per-label", "Maximum number of nodes with the same value of this label that may be drained at once. May be specified multiple times.").PlaceHolder("KEY=MAX").StringMap()
+		maxDrainsPerHour            = app.Flag("max-drains-per-hour", "Maximum sustained rate at which drains may start. Leave unset for no limit.").Int()
+		maxDrainsBurst              = app.Flag("max-drains-burst", "Number of drains that may start in quick succession before --max-drains-per-hour applies.").Default("1").Int()
+		maxUnavailablePerLabel      = app.Flag("max-unavailable-per-label", "Maximum number of nodes with the same value of this label that may be unavailable at once, whether because they are being drained or are not ready. May be specified multiple times.").PlaceHolder("KEY=MAX").StringMap()
+
+		blackoutWindows   = app.Flag("blackout-window", "Do not start draining nodes during this weekly window or date range. Nodes are still cordoned. May be specified multiple times.").PlaceHolder("\"Fri 16:00 - Mon 08:00\"").Strings()
+		blackoutConfigMap = app.Flag("blackout-configmap", "ConfigMap containing additional blackout windows, one per data key.").PlaceHolder("NAMESPACE/NAME").String()
+		blackoutTimezone  = app.Flag("blackout-timezone", "IANA timezone in which to interpret blackout windows that do not specify one.").Default("UTC").String()
+
+		evictDaemonSetPods    = app.Flag("evict-daemonset-pods", "Evict pods that were created by an extant DaemonSet, respecting any pod disruption budgets that cover them.").Bool()
 		evictLocalStoragePods = app.Flag("evict-emptydir-pods", "Evict pods with local storage, i.e. with emptyDir volumes.").Bool()
 		evictUnreplicatedPods = app.Flag("evict-unreplicated-pods", "Evict pods that were not created by a replication controller.").Bool()
+		orphanedPodPolicy     = app.Flag("orphaned-pod-policy", "Whether to evict or protect from eviction pods whose controller no longer exists, regardless of --evict-unreplicated-pods.").Default(kubernetes.OrphanedPodPolicyEvict).Enum(kubernetes.OrphanedPodPolicyEvict, kubernetes.OrphanedPodPolicyProtect)
+
+		maxNamespaceEvictions   = app.Flag("max-namespace-evictions", "Maximum number of pods that may be evicted from any one namespace per --namespace-eviction-period, across all drains. Leave unset for no limit.").Int()
+		namespaceEvictionPeriod = app.Flag("namespace-eviction-period", "Period over which --max-namespace-evictions applies.").Default("10m").Duration()
 
+		maxEvictionRate = app.Flag("max-eviction-rate", "Maximum number of evictions per second, across all drains. The rate is halved each time the API server throttles an eviction, and recovers as evictions are not throttled. Leave unset for no limit; throttled evictions are still retried after the delay the API server suggests.").Float64()
+
+		apiRetries = app.Flag("api-retries", "Maximum number of times to retry each API request made while cordoning and draining a node, if it fails with a timeout, a server error, or a conflict. Retries back off exponentially.").Default(strconv.Itoa(kubernetes.DefaultAPIRetries)).Int()
+
+		maxPodsToEvict          = app.Flag("max-pods-to-evict", "Do not drain nodes that would require evicting more than this many pods, unless they are annotated with force-drain=true, with the key prefixed by --key-prefix. Leave unset for no limit.").Int()
 		protectedPodAnnotations = app.Flag("protected-pod-annotation", "Protect pods with this annotation from eviction. May be specified multiple times.").PlaceHolder("KEY[=VALUE]").Strings()
+		lastReadyReplicaPolicy  = app.Flag("last-ready-replica-policy", "Whether to evict, defer evicting until another replica is ready, or skip evicting pods that are the only ready replica of their controller, whether or not a pod disruption budget covers them.").Default(kubernetes.LastReadyReplicaPolicyEvict).Enum(kubernetes.LastReadyReplicaPolicyEvict, kubernetes.LastReadyReplicaPolicyDefer, kubernetes.LastReadyReplicaPolicySkip)
+		batchEvictionsByBudget  = app.Flag("batch-evictions-by-budget", "Evict the pods a pod disruption budget selects in batches as large as the budget currently allows, rather than all at once.").Bool()
+
+		surgeDeployments = app.Flag("surge-deployments", "Before draining each node, scale up the Deployments with pods on it by the number of their pods that will be evicted, and scale them back down once the drain finishes.").Bool()
+		surgeTimeout     = app.Flag("surge-timeout", "Maximum time to wait for the replicas added by --surge-deployments to become available before draining anyway.").Default(kubernetes.DefaultSurgeTimeout.String()).Duration()
+
+		protectSoleEndpoints   = app.Flag("protect-sole-endpoints", "Do not evict pods that are the only ready endpoint of a Service. An event naming the Service is recorded for each such pod.").Bool()
+		soleEndpointNamespaces = app.Flag("sole-endpoint-namespace", "Only protect pods that are the only ready endpoint of a Service in this namespace from eviction. May be specified multiple times. Leave unset to protect such pods in all namespaces.").Strings()
+
+		checkRescheduling = app.Flag("check-rescheduling", "Before draining a node, check that the pods it would evict fit on the remaining schedulable nodes, considering their resource requests, node selectors, node affinity, tolerations, pod anti-affinity, and topology spread constraints. Drains are deferred until they do.").Bool()
+
+		requiredHeadroomCPU    = app.Flag("required-headroom-cpu", "Do not start drains that would leave the cluster's schedulable nodes with less than this much unrequested CPU, either a quantity or a percentage of their allocatable CPU. Drains start once capacity returns.").PlaceHolder("QUANTITY|PERCENT%").String()
+		requiredHeadroomMemory = app.Flag("required-headroom-memory", "Do not start drains that would leave the cluster's schedulable nodes with less than this much unrequested memory, either a quantity or a percentage of their allocatable memory. Drains start once capacity returns.").PlaceHolder("QUANTITY|PERCENT%").String()
+
+		stormThreshold = app.Flag("correlated-failure-threshold", "Pause cordoning and draining when more than this percentage of matching nodes develop the same node condition within --correlated-failure-window, until the failure is acknowledged with a PUT to /acknowledge-correlated-failure. Leave unset to never pause.").PlaceHolder("PERCENT").Float64()
+		stormWindow    = app.Flag("correlated-failure-window", "Window within which nodes must develop the same condition to count towards --correlated-failure-threshold.").Default(kubernetes.DefaultStormWindow.String()).Duration()
+
+		maxCordonedNodes = app.Flag("max-cordoned-nodes", "Maximum number of matching nodes, or percentage of matching nodes, that draino may hold cordoned at once, regardless of --max-concurrent-drains. Matching nodes beyond this limit are recorded as candidates with a CordonDeferred event rather than cordoned. Leave unset for no limit.").PlaceHolder("MAX|PERCENT%").String()
+
+		maxUnschedulablePods  = app.Flag("max-unschedulable-pods", "Do not start drains while more than this many pods in the cluster are pending and unschedulable, a sign that previously evicted pods are not being rescheduled. Leave unset for no limit.").Int()
+		unschedulableInterval = app.Flag("unschedulable-pods-interval", "Time between counts of unschedulable pods for --max-unschedulable-pods.").Default(kubernetes.DefaultPendingPodsInterval.String()).Duration()
+
+		prometheusURL        = app.Flag("prometheus-url", "Address of a Prometheus server against which to evaluate --prometheus-condition queries.").String()
+		prometheusConditions = app.Flag("prometheus-condition", "Set this node condition on nodes for which this PromQL query returns a series. May be specified multiple times.").PlaceHolder("CONDITION=QUERY").StringMap()
+		prometheusNodeLabel  = app.Flag("prometheus-node-label", "Label of Prometheus series that identifies the node to which the series pertains.").Default(kubernetes.DefaultPrometheusNodeLabel).String()
+		prometheusInterval   = app.Flag("prometheus-interval", "Time between evaluations of --prometheus-condition queries.").Default(kubernetes.DefaultConditionProbeInterval.String()).Duration()
+
+		probeConditions       = app.Flag("probe-condition", "Set this node condition on nodes whose endpoint fails this probe. The endpoint is a http://, https://, or tcp:// URL template, e.g. http://{{.InternalIP}}:8080/healthz. May be specified multiple times.").PlaceHolder("CONDITION=URL").StringMap()
+		probeInterval         = app.Flag("probe-interval", "Time between --probe-condition probes.").Default(kubernetes.DefaultConditionProbeInterval.String()).Duration()
+		probeTimeout          = app.Flag("probe-timeout", "Time to wait for each --probe-condition probe.").Default(kubernetes.DefaultEndpointProbeTimeout.String()).Duration()
+		probeFailureThreshold = app.Flag("probe-failure-threshold", "Number of consecutive --probe-condition probes that must fail before the node condition is set.").Default(strconv.Itoa(kubernetes.DefaultEndpointProbeFailureThreshold)).Int()
+
+		awsRegion             = app.Flag("aws-region", "AWS region in which to call AWS APIs. Leave unset to use the AWS SDK's default configuration.").String()
+		awsLifecycleQueue     = app.Flag("aws-lifecycle-queue", "URL of an SQS queue that receives Auto Scaling lifecycle hook notifications. Nodes backed by terminating instances will be drained before termination proceeds.").String()
+		awsLifecycleHeartbeat = app.Flag("aws-lifecycle-heartbeat", "Time between lifecycle action heartbeats while a terminating instance is drained.").Default(aws.DefaultLifecycleHeartbeatInterval.String()).Duration()
+
+		eksNodegroupMaxConcurrentDrains = app.Flag("eks-nodegroup-max-concurrent-drains", "Maximum number of nodes in the same EKS managed node group that may be drained at once. Leave unset for no limit.").Int()
+		eksNodegroupMaxUnavailable      = app.Flag("eks-nodegroup-max-unavailable", "Maximum number of nodes in the same EKS managed node group that may be unavailable at once, whether because they are being drained or are not ready. Leave unset for no limit.").Int()
+		eksTerminateDrainedInstances    = app.Flag("eks-terminate-drained-instances", "Terminate the EC2 instance backing each drained node of an EKS managed node group. The node group replaces terminated instances.").Bool()
+
+		gkeNodePoolMaxConcurrentDrains = app.Flag("gke-nodepool-max-concurrent-drains", "Maximum number of nodes in the same GKE node pool that may be drained at once. Leave unset for no limit.").Int()
+		gkeNodePoolMaxUnavailable      = app.Flag("gke-nodepool-max-unavailable", "Maximum number of nodes in the same GKE node pool that may be unavailable at once, whether because they are being drained or are not ready. Leave unset for no limit.").Int()
+		gkeUpgradeAware                = app.Flag("gke-upgrade-aware", "Do not start draining nodes in GKE node pools that are being upgraded.").Bool()
+
+		azureScaleSetMaxConcurrentDrains = app.Flag("azure-scaleset-max-concurrent-drains", "Maximum number of nodes backed by the same Azure Virtual Machine Scale Set that may be drained at once. Leave unset for no limit.").Int()
+		azureScaleSetMaxUnavailable      = app.Flag("azure-scaleset-max-unavailable", "Maximum number of nodes backed by the same Azure Virtual Machine Scale Set that may be unavailable at once, whether because they are being drained or are not ready. Leave unset for no limit.").Int()
+		azurePostDrainAction             = app.Flag("azure-post-drain-action", "Delete or reimage the scale set instance backing each drained node.").Enum(azure.ActionDelete, azure.ActionReimage)
+		azureIdentityClientID            = app.Flag("azure-identity-client-id", "Client ID of the user assigned managed identity with which to call Azure APIs. Leave unset to use the system assigned identity.").String()
+		azureEndpoint                    = app.Flag("azure-endpoint", "Azure Resource Manager endpoint.").Default(azure.DefaultEndpoint).String()
+
+		kuredLock           = app.Flag("kured-lock", "DaemonSet whose kured reboot lock must be held to drain a node, typically kured's own. Prevents draino and kured from disrupting different nodes at once.").PlaceHolder("NAMESPACE/NAME").String()
+		kuredLockAnnotation = app.Flag("kured-lock-annotation", "Annotation in which --kured-lock stores its lock.").Default(kubernetes.DefaultKuredLockAnnotation).String()
+		kuredLockTTL        = app.Flag("kured-lock-ttl", "Time after which --kured-lock locks taken by draino expire, and stale locks left by draino are reclaimed. Must be longer than any drain. Leave unset for locks that never expire.").Duration()
+		clusterAPIMachines  = app.Flag("cluster-api-machines", "Act as the drain provider for Cluster API Machines. Registers a pre-drain hook on each Machine, and cordons and drains the node of each deleting Machine, regardless of its conditions.").Bool()
+		clusterAutoscaler   = app.Flag("cluster-autoscaler", "Prevent the cluster autoscaler from scaling down nodes while they are drained, and ignore nodes the cluster autoscaler is already draining.").Bool()
+
+		deferToOtherDrainers = app.Flag("defer-to-other-drainers", "Ignore nodes that another drain controller, e.g. the cluster autoscaler or Karpenter, is draining, as indicated by its taints or by --defer-to-annotation annotations. Nodes are checked again immediately before they are cordoned and drained.").Bool()
+		deferToTaints        = app.Flag("defer-to-taint", "Also defer to controllers that taint the nodes they are draining with this key. May be specified multiple times. Implies --defer-to-other-drainers.").PlaceHolder("KEY").Strings()
+		deferToAnnotations   = app.Flag("defer-to-annotation", "Also defer to controllers that annotate the nodes they are draining with this annotation. May be specified multiple times. Implies --defer-to-other-drainers.").PlaceHolder("KEY[=VALUE]").Strings()
+
+		nodeLease          = app.Flag("node-lease", "Hold a coordination Lease named after each node, in --node-lease-namespace, from before cordoning the node until its drain finishes. Nodes whose Lease is held by other tooling are not cordoned. The Lease's holder is this draino's hostname, typically its pod name, and a unique ID. Drains are aborted if their Lease is lost.").Bool()
+		nodeLeaseNamespace = app.Flag("node-lease-namespace", "Namespace of --node-lease Leases. Must not be kube-node-lease, whose Leases the kubelet holds.").Default("kube-system").String()
+		nodeLeaseDuration  = app.Flag("node-lease-duration", "Time for which each --node-lease Lease is valid unless renewed. Held Leases are renewed well before they expire.").Default(kubernetes.DefaultLeaseDuration.String()).Duration()
+
+		nodeMaintenance      = app.Flag("node-maintenance", "Cordon and drain the node of each NodeMaintenance custom resource, regardless of its conditions, and uncordon it once the NodeMaintenance is deleted.").Bool()
+		nodeMaintenanceGroup = app.Flag("node-maintenance-group", "API group of the NodeMaintenance custom resource, for example nodemaintenance.kubevirt.io.").Default(kubernetes.NodeMaintenanceResource.Group).String()
+
+		remediation         = app.Flag("remediation", "Act as an external remediation backend for node health check controllers. Cordons and drains the node of each remediation custom resource, and uncordons it once the remediation is deleted.").Bool()
+		remediationResource = app.Flag("remediation-resource", "Remediation custom resource to watch, as RESOURCE.VERSION.GROUP.").Default("drainremediations.v1alpha1.draino.planetlabs.com").String()
+
+		notifyWebhook = app.Flag("notify-webhook", "POST to this URL template, e.g. http://alerts:8080/nodes, before cordoning each node whose pipeline includes the notify step. Failures are logged, and do not prevent the node being cordoned.").PlaceHolder("URL").String()
+		notifyTimeout = app.Flag("notify-timeout", "Maximum time to wait for --notify-webhook to respond.").Default(kubernetes.DefaultNotifyTimeout.String()).Duration()
+
+		preDrainWebhook       = app.Flag("pre-drain-webhook", "POST to this URL template, e.g. http://{{.InternalIP}}:8080/drain, before evicting pods from each node. Pods are evicted only once it returns a 2xx status code.").PlaceHolder("URL").String()
+		preDrainJob           = app.Flag("pre-drain-job", "Path to a Job manifest template to run on each node before evicting its pods. Pods are evicted only once the Job completes.").PlaceHolder("PATH").String()
+		preDrainTimeout       = app.Flag("pre-drain-timeout", "Maximum time to wait for each pre-drain action to succeed.").Default(kubernetes.DefaultHookTimeout.String()).Duration()
+		preDrainFailurePolicy = app.Flag("pre-drain-failure-policy", "Whether to fail the drain, or ignore the failure and evict pods anyway, when a pre-drain action fails.").Default(kubernetes.HookFailurePolicyFail).Enum(kubernetes.HookFailurePolicyFail, kubernetes.HookFailurePolicyIgnore)
+
+		postDrainActions       = app.Flag("post-drain-action", "Act on each node once it has been drained; one of webhook=URL, job=PATH, annotation=KEY=VALUE, or taint=KEY[=VALUE]:EFFECT. May be specified multiple times; actions run in order.").PlaceHolder("TYPE=ARGUMENT").Strings()
+		postDrainTimeout       = app.Flag("post-drain-timeout", "Maximum time to wait for each post-drain webhook or Job to succeed.").Default(kubernetes.DefaultHookTimeout.String()).Duration()
+		postDrainFailurePolicy = app.Flag("post-drain-failure-policy", "Whether to fail the drain, or ignore the failure and run the remaining actions, when a post-drain action fails.").Default(kubernetes.HookFailurePolicyFail).Enum(kubernetes.HookFailurePolicyFail, kubernetes.HookFailurePolicyIgnore)
+
+		drainProgress         = app.Flag("drain-progress", "Record the progress of each drain, i.e. its phase, the number of pods remaining, the pod it is waiting on, and its elapsed time, as a JSON annotation on the node, updated every --drain-progress-interval.").Bool()
+		drainProgressInterval = app.Flag("drain-progress-interval", "Time between updates of the --drain-progress annotation.").Default(kubernetes.DefaultDrainProgressInterval.String()).Duration()
+
+		evictionEvents = app.Flag("eviction-events", "Record a PodEvicted event about each pod evicted from a node, and a DrainWaitingOnPDB event about each pod whose eviction a pod disruption budget refuses, in addition to the events recorded about the node as it is drained.").Bool()
+
+		reportMirrorPods = app.Flag("report-mirror-pods", "Name the mirror pods that remain on each drained node, which cannot be evicted, in its DrainSucceeded event.").Bool()
+		mirrorPodWebhook = app.Flag("mirror-pod-webhook", "POST to this URL template, e.g. http://{{.InternalIP}}:8080/stop-static-pods, once each node is drained if mirror pods remain on it. The drain succeeds only once the mirror pods are gone. Implies --report-mirror-pods.").PlaceHolder("URL").String()
+		mirrorPodTimeout = app.Flag("mirror-pod-timeout", "Maximum time to wait for --mirror-pod-webhook to succeed, and then for mirror pods to be gone.").Default(kubernetes.DefaultHookTimeout.String()).Duration()
+
+		removeStuckFinalizers      = app.Flag("remove-stuck-finalizer", "Remove this finalizer from evicted pods that are stuck terminating for --remove-stuck-finalizers-after. May be specified multiple times.").PlaceHolder("FINALIZER").Strings()
+		removeStuckFinalizersAfter = app.Flag("remove-stuck-finalizers-after", "Time after an evicted pod's termination grace period elapses before --remove-stuck-finalizer finalizers are removed from it. Must be shorter than --eviction-headroom for removal to happen within a drain.").Default(kubernetes.DefaultRemoveFinalizersAfter.String()).Duration()
 
-		conditions = app.Arg("node-conditions", "Nodes for which any of these conditions are true will be cordoned and drained.").Required().Strings()
+		deleteDrainedNodes = app.Flag("delete-drained-nodes", "Delete each Node object once it has been drained and its pods and volume attachments are gone, for clouds that recycle instances without deleting their Node objects.").Bool()
+
+		rebootConditions = app.Flag("reboot-condition", "Annotate nodes drained while this node condition is true with --reboot-annotation, so that reboot automation reboots them. May be specified multiple times.").Strings()
+		rebootAnnotation = app.Flag("reboot-annotation", "Annotation with which to mark drained nodes that need rebooting. Defaults to reboot-required=true, with the key prefixed by --key-prefix.").PlaceHolder("KEY=VALUE").String()
+
+		uncordon                = app.Flag("uncordon", "Uncordon nodes draino cordoned once they have been ready and have not matched any of the supplied conditions or labels for --uncordon-healthy-period.").Bool()
+		uncordonAfterReboot     = app.Flag("uncordon-after-reboot", "Uncordon nodes only once they have also been rebooted or reimaged since draino cordoned them, as indicated by a new boot or machine ID. Implies --uncordon.").Bool()
+		uncordonHealthyPeriod   = app.Flag("uncordon-healthy-period", "Time for which a node must be healthy before it is uncordoned.").Default(kubernetes.DefaultUncordonHealthyPeriod.String()).Duration()
+		uncordonCanaryImage     = app.Flag("uncordon-canary-image", "Require healthy nodes to run a pod using this container image to completion before they are uncordoned.").PlaceHolder("IMAGE").String()
+		uncordonCanaryNamespace = app.Flag("uncordon-canary-namespace", "Namespace in which to run --uncordon-canary-image pods.").Default("default").String()
+		uncordonCanaryTimeout   = app.Flag("uncordon-canary-timeout", "Maximum time to wait for each --uncordon-canary-image pod to succeed.").Default(kubernetes.DefaultCanaryTimeout.String()).Duration()
+
+		rebalance        = app.Flag("rebalance", "Request that workloads be rebalanced onto each node draino uncordons, by emitting a RebalanceRequested event about the node. Requires --uncordon.").Bool()
+		rebalanceActions = app.Flag("rebalance-action", "Also request rebalancing by running this action for each uncordoned node; one of webhook=URL or job=PATH, for example a Job that runs the descheduler. May be specified multiple times; actions run in order. Implies --rebalance.").PlaceHolder("TYPE=ARGUMENT").Strings()
+		rebalanceTimeout = app.Flag("rebalance-timeout", "Maximum time to wait for each rebalance webhook or Job to succeed.").Default(kubernetes.DefaultHookTimeout.String()).Duration()
+
+		auditDestination = app.Flag("audit-destination", "Periodically upload a record of each action draino takes to this object storage location; one of s3://BUCKET/PREFIX, gs://BUCKET/PREFIX, or https://ACCOUNT.blob.core.windows.net/CONTAINER/PREFIX.").PlaceHolder("URL").String()
+		auditInterval    = app.Flag("audit-interval", "Time between uploads of records to --audit-destination.").Default(kubernetes.DefaultAuditInterval.String()).Duration()
+
+		historySize      = app.Flag("history-size", "Maximum number of recent cordons, drains, and uncordons to describe at /history. Set to 0 to disable /history.").Default(strconv.Itoa(kubernetes.DefaultHistorySize)).Int()
+		historyRetention = app.Flag("history-retention", "Maximum age of the actions described at /history.").Default(kubernetes.DefaultHistoryRetention.String()).Duration()
+		historyFile      = app.Flag("history-file", "Persist the actions described at /history to this file, so that they survive a restart, for example on a persistent volume.").PlaceHolder("FILE").String()
+
+		tlsCertFile = app.Flag("tls-cert-file", "Serve /metrics, /healthz, and the other HTTP endpoints over HTTPS using the certificate in this file. The certificate is reloaded when the file changes.").PlaceHolder("FILE").String()
+		tlsKeyFile  = app.Flag("tls-key-file", "Private key of --tls-cert-file. The key is reloaded when the file changes.").PlaceHolder("FILE").String()
+
+		httpShutdownGrace = app.Flag("http-shutdown-grace", "Maximum time to wait for in-flight HTTP requests, for example metrics scrapes, to finish when shutting down.").Default("5s").Duration()
+		adminListen       = app.Flag("admin-listen", "Address at which to expose /status, /history, /exemplars, /loglevel, /acknowledge-correlated-failure, and /debug/pprof, for example localhost:10003, rather than at --listen. Profiling is available only at this address.").String()
+
+		metricsNodeName     = app.Flag("metrics-node-name", "Tag the cordoned_nodes_total and drained_nodes_total metrics with the name of each node. Produces a series per node; best suited to small clusters, or combined with --metrics-max-tag-values.").Bool()
+		metricsMaxTagValues = app.Flag("metrics-max-tag-values", "Maximum number of distinct node name, node pool, reason, owner kind, and finalizer values for which metrics are tagged. Further values are tagged as other. Leave unset for no limit.").Int()
+
+		httpTokenFile      = app.Flag("http-token-file", "Allow PUT requests, which change draino's state, and requests to /status, /history, and /exemplars, which describe it, that present one of the bearer tokens in this file, one per line.").PlaceHolder("FILE").String()
+		tlsClientCAFile    = app.Flag("tls-client-ca-file", "Allow PUT requests, and requests to /status, /history, and /exemplars, that present a client certificate signed by a CA in this file. Requires --tls-cert-file.").PlaceHolder("FILE").String()
+		httpKubernetesAuth = app.Flag("http-kubernetes-auth", "Allow PUT requests, and requests to /status, /history, and /exemplars, that present a bearer token whose user the Kubernetes API allows to use the requested path with the request's method, as a non-resource URL.").Bool()
+
+		livezTimeout      = app.Flag("livez-timeout", "Maximum time /livez waits for the Kubernetes API to respond.").Default(kubernetes.DefaultAPITimeout.String()).Duration()
+		livezWatchSilence = app.Flag("livez-watch-silence", "Maximum time the node watch may receive no events before /livez reports draino unhealthy.").Default(kubernetes.DefaultMaxWatchSilence.String()).Duration()
+
+		npdPreset           = app.Flag("npd-preset", "Cordon and drain nodes with the permanent problems reported by the default Node Problem Detector configuration, in addition to any supplied node conditions.").Bool()
+		conditionPriorities = app.Flag("condition-priority", "Drain nodes with this condition at this priority; one of low, normal, high, or critical. Higher priority nodes are drained first. May be specified multiple times.").PlaceHolder("CONDITION=PRIORITY").StringMap()
+		conditionActions    = app.Flag("condition-action", "Remediate nodes with this condition using this pipeline; one of cordon-only, cordon+drain, cordon+drain+terminate, or a --pipeline. Nodes with several conditions run the steps of all of their pipelines. Defaults to cordon+drain+terminate. May be specified multiple times.").PlaceHolder("CONDITION=PIPELINE").StringMap()
+		pipelines           = app.Flag("pipeline", "Define a pipeline for use with --condition-action as a comma separated list of steps, run in the order notify, cordon, soak=DURATION, pre-hook, drain, post-hook, replace, and uncordon. May be specified multiple times.").PlaceHolder("NAME=STEPS").StringMap()
+		nodeScorers         = app.Flag("node-scorer", "Of drains with the same priority, start those of nodes with the highest score first, weighing this scorer's score by this weight; one of severity, emptiest-first, or oldest-condition-first. May be specified multiple times to add the weighted scores.").PlaceHolder("SCORER=WEIGHT").StringMap()
+
+		runCmd     = app.Command("run", "Cordon and drain nodes that match the supplied conditions. This is the default command.").Default()
+		conditions = runCmd.Arg("node-conditions", "Nodes for which any of these conditions are true will be cordoned and drained. Conditions may be combined into expressions using AND, OR, NOT, and parentheses, and written as CONDITION[=STATUS][,DURATION].").Strings()
 	)
-	kingpin.MustParse(app.Parse(os.Args[1:]))
+	simulateCmd := app.Command("simulate", "Print how draino, configured by the supplied flags and conditions, would drain a node, without changing anything.")
+	simulateNode := simulateCmd.Flag("node", "Node to simulate draining.").Required().String()
+	simulateCmd.Arg("node-conditions", "Node conditions, as for the run command.").StringsVar(conditions)
+	evalCmd := app.Command("eval", "Print how draino, configured by the supplied flags and conditions, would handle the nodes in a file, without connecting to a cluster.")
+	evalFile := evalCmd.Flag("file", "YAML or JSON file of the nodes, pods, and pod disruption budgets to evaluate, for example the output of kubectl get -o yaml.").Required().PlaceHolder("FILE").ExistingFile()
+	evalTime := evalCmd.Flag("time", "Evaluate node condition durations as if at this RFC 3339 time. Leave unset to use the current time.").String()
+	evalCmd.Arg("node-conditions", "Node conditions, as for the run command.").StringsVar(conditions)
+
+	command := kingpin.MustParse(app.Parse(os.Args[1:]))
+	simulating := command == simulateCmd.FullCommand() || command == evalCmd.FullCommand()
+	keys := kubernetes.NewKeys(*keyPrefix)
+	if *metricsMaxTagValues < 0 {
+		kingpin.Fatalf("--metrics-max-tag-values must not be negative")
+	}
+	kubernetes.SetMaxTagValues(*metricsMaxTagValues)
+	if *rebootAnnotation == "" {
+		*rebootAnnotation = keys.RebootAnnotation
+	}
 	glogWorkaround()
 
+	if *npdPreset {
+		*conditions = append(*conditions, kubernetes.NodeProblemDetectorPreset.Expressions...)
+	}
+	if len(*conditions) == 0 && len(*drainLabels) == 0 && len(*urgentDrainLabels) == 0 && !*drainDeleting && !*drainRequests && !*clusterAPIMachines && !*nodeMaintenance && !*remediation {
+		kingpin.Fatalf("at least one node condition is required unless --npd-preset, --drain-label, --urgent-drain-label, --drain-requests, --drain-deleting-nodes, --cluster-api-machines, --node-maintenance, or --remediation is specified")
+	}
+	if *groupDrainCooldown > 0 && *groupDrainCooldownLabel == "" {
+		kingpin.Fatalf("--group-drain-cooldown-label is required when --group-drain-cooldown is specified")
+	}
+
 	var (
 		nodesCordoned = &view.View{
 			Name:        "cordoned_nodes_total",
 			Measure:     kubernetes.MeasureNodesCordoned,
 			Description: "Number of nodes cordoned.",
 			Aggregation: view.Count(),
-			TagKeys:     []tag.Key{kubernetes.TagResult},
+			TagKeys:     []tag.Key{kubernetes.TagResult, kubernetes.TagNodePool, kubernetes.TagCluster, kubernetes.TagShard, kubernetes.TagReason, kubernetes.TagErrorClass},
 		}
 		nodesDrained = &view.View{
 			Name:        "drained_nodes_total",
 			Measure:     kubernetes.MeasureNodesDrained,
 			Description: "Number of nodes drained.",
 			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{kubernetes.TagResult, kubernetes.TagNodePool, kubernetes.TagCluster, kubernetes.TagShard, kubernetes.TagErrorClass},
+		}
+		podsEvicted = &view.View{
+			Name:        "pods_evicted_total",
+			Measure:     kubernetes.MeasurePodsEvicted,
+			Description: "Number of pods evicted.",
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{kubernetes.TagResult, kubernetes.TagOwnerKind, kubernetes.TagErrorClass},
+		}
+		cordonedNodes = &view.View{
+			Name:        "cordoned_nodes",
+			Measure:     kubernetes.MeasureCordonedNodes,
+			Description: "Number of nodes currently cordoned by draino.",
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{kubernetes.TagNodePool, kubernetes.TagCluster, kubernetes.TagShard},
+		}
+		drainFailedNodes = &view.View{
+			Name:        "drain_failed_nodes",
+			Measure:     kubernetes.MeasureDrainFailedNodes,
+			Description: "Number of nodes currently cordoned by draino that it failed to drain.",
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{kubernetes.TagNodePool, kubernetes.TagCluster, kubernetes.TagShard},
+		}
+		stuckPodFinalizers = &view.View{
+			Name:        "stuck_pod_finalizers_total",
+			Measure:     kubernetes.MeasureStuckPodFinalizers,
+			Description: "Number of finalizers that blocked deletion of evicted pods.",
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{kubernetes.TagFinalizer, kubernetes.TagResult},
+		}
+		podDeletionTimeouts = &view.View{
+			Name:        "pod_deletion_timeouts_total",
+			Measure:     kubernetes.MeasurePodDeletionTimeouts,
+			Description: "Number of evicted pods that were not deleted in time.",
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{kubernetes.TagOwnerKind},
+		}
+		throttledRequests = &view.View{
+			Name:        "throttled_requests_total",
+			Measure:     kubernetes.MeasureThrottledRequests,
+			Description: "Number of API requests the API server throttled.",
+			Aggregation: view.Count(),
+		}
+		sinceDrained = &view.View{
+			Name:        "seconds_since_last_successful_drain",
+			Measure:     kubernetes.MeasureSinceDrained,
+			Description: "Seconds since draino last drained a node successfully.",
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{kubernetes.TagCluster, kubernetes.TagShard},
+		}
+		unschedulablePods = &view.View{
+			Name:        "unschedulable_pods",
+			Measure:     kubernetes.MeasureUnschedulablePods,
+			Description: "Number of pending pods that could not be scheduled.",
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{kubernetes.TagCluster},
+		}
+		pendingPodsTripped = &view.View{
+			Name:        "pending_pods_breaker_tripped",
+			Measure:     kubernetes.MeasurePendingPodsTripped,
+			Description: "Whether too many unschedulable pending pods have paused new drains.",
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{kubernetes.TagCluster},
+		}
+		stormDetected = &view.View{
+			Name:        "correlated_failure_detected",
+			Measure:     kubernetes.MeasureStormDetected,
+			Description: "Whether a correlated failure has paused remediation until it is acknowledged.",
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{kubernetes.TagCluster},
+		}
+		drainDuration = &view.View{
+			Name:        "drain_duration_seconds",
+			Measure:     kubernetes.MeasureDrainDuration,
+			Description: "Seconds taken to drain a node.",
+			Aggregation: view.Distribution(kubernetes.DrainDurationBuckets...),
 			TagKeys:     []tag.Key{kubernetes.TagResult},
 		}
+		evictionLatency = &view.View{
+			Name:        "eviction_latency_seconds",
+			Measure:     kubernetes.MeasureEvictionLatency,
+			Description: "Seconds taken to evict a pod, until it was deleted.",
+			Aggregation: view.Distribution(kubernetes.EvictionLatencyBuckets...),
+			TagKeys:     []tag.Key{kubernetes.TagResult, kubernetes.TagOwnerKind},
+		}
 	)
-	kingpin.FatalIfError(view.Register(nodesCordoned, nodesDrained), "cannot create metrics")
+	if *metricsNodeName {
+		nodesCordoned.TagKeys = append(nodesCordoned.TagKeys, kubernetes.TagNodeName)
+		nodesDrained.TagKeys = append(nodesDrained.TagKeys, kubernetes.TagNodeName)
+	}
+	kingpin.FatalIfError(view.Register(nodesCordoned, nodesDrained, podsEvicted, stuckPodFinalizers, podDeletionTimeouts, throttledRequests, cordonedNodes, drainFailedNodes, sinceDrained, unschedulablePods, pendingPodsTripped, stormDetected, drainDuration, evictionLatency), "cannot create metrics")
 	p, err := prometheus.NewExporter(prometheus.Options{Namespace: kubernetes.Component})
 	kingpin.FatalIfError(err, "cannot export metrics")
 	view.RegisterExporter(p)
 
-	web := &httpRunner{l: *listen, h: map[string]http.Handler{
+	// Each cluster adds a check of its API connectivity to livez.
+	var livez []*kubernetes.APIHealthCheck
+
+	// Each cluster may add its state to status.
+	var status []clusterStatus
+	web := &httpRunner{l: *listen, grace: *httpShutdownGrace, h: map[string]http.Handler{
 		"/metrics": p,
 		"/healthz": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { r.Body.Close() }), // nolint:gosec
+		"/livez": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body.Close() // nolint:gosec
+			for _, hc := range livez {
+				if err := hc.Check(); err != nil {
+					http.Error(w, err.Error(), http.StatusServiceUnavailable)
+					return
+				}
+			}
+		}),
 	}}
 
-	log, err := zap.NewProduction()
+	if (*tlsCertFile == "") != (*tlsKeyFile == "") {
+		kingpin.Fatalf("--tls-cert-file and --tls-key-file must be specified together")
+	}
+	if *tlsCertFile != "" {
+		cr, err := newCertReloader(*tlsCertFile, *tlsKeyFile)
+		kingpin.FatalIfError(err, "cannot configure TLS")
+		web.tls = &tls.Config{GetCertificate: cr.GetCertificate, MinVersion: tls.VersionTLS12}
+	}
+	if *tlsClientCAFile != "" {
+		if web.tls == nil {
+			kingpin.Fatalf("--tls-client-ca-file requires --tls-cert-file")
+		}
+		b, err := ioutil.ReadFile(*tlsClientCAFile)
+		kingpin.FatalIfError(err, "cannot read client CA file")
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			kingpin.Fatalf("no certificates in client CA file %s", *tlsClientCAFile)
+		}
+		// Client certificates are optional, so that scrapers need not
+		// present one, but are verified when presented.
+		web.tls.ClientCAs = pool
+		web.tls.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	// Endpoints that change or describe draino's state, or expose its
+	// internals, may be served by a separate listener, e.g. one bound only
+	// to localhost.
+	admin := web
+	if *adminListen != "" {
+		admin = &httpRunner{l: *adminListen, h: pprofHandlers(), tls: web.tls, grace: web.grace}
+	}
+	admin.h["/status"] = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body.Close() // nolint:gosec
+		w.Header().Set("Content-Type", "application/json")
+		statuses := make([]clusterStatus, 0, len(status))
+		for _, st := range status {
+			st.DrainCooldowns = st.drains.Cooldowns()
+			st.ScheduledDrains = st.scheduler.Scheduled()
+			if st.estimates != nil {
+				st.DrainEstimates = st.estimates.Estimates()
+			}
+			if st.breaker != nil {
+				bs := st.breaker.Status()
+				st.PendingPodsBreaker = &bs
+			}
+			if st.storm != nil {
+				ss := st.storm.Status()
+				st.CorrelatedFailure = &ss
+			}
+			statuses = append(statuses, st)
+		}
+		json.NewEncoder(w).Encode(statuses) // nolint:gosec
+	})
+
+	lc := zap.NewProductionConfig()
 	if *debug {
-		log, err = zap.NewDevelopment()
+		lc = zap.NewDevelopmentConfig()
+	}
+	if *logFormat != "" {
+		lc.Encoding = *logFormat
 	}
+	lc.Sampling = nil
+	if *logSampling == "true" || (*logSampling == "" && !*debug) {
+		if *logSamplingInitial < 1 || *logSamplingThereafter < 1 {
+			kingpin.Fatalf("--log-sampling-initial and --log-sampling-thereafter must be at least 1")
+		}
+		lc.Sampling = &zap.SamplingConfig{Initial: *logSamplingInitial, Thereafter: *logSamplingThereafter}
+	}
+	// Subsystems may log more verbosely than the default level, so the base
+	// logger logs everything and each subsystem's logger filters its entries.
+	level := lc.Level
+	lc.Level = zap.NewAtomicLevelAt(zapcore.DebugLevel)
+	base, err := lc.Build()
 	kingpin.FatalIfError(err, "cannot create log")
-	defer log.Sync()
+	defer base.Sync()
+	logs, err := newSubsystemLoggers(base, level, *logLevels)
+	kingpin.FatalIfError(err, "cannot configure log levels")
+	log := logs.Default()
+
+	if *traceSampleProbability < 0 || *traceSampleProbability > 1 {
+		kingpin.Fatalf("--trace-sample-probability must be between 0 and 1")
+	}
+	sampler := trace.NeverSample()
+	if *traceSampleProbability > 0 {
+		sampler = trace.ProbabilitySampler(*traceSampleProbability)
+		trace.RegisterExporter(kubernetes.NewSpanLogger(logs.For(subsystemDrainer)))
+
+		// Sampled drain and eviction spans are exemplars of the metrics
+		// that record their durations.
+		exemplars := kubernetes.NewExemplars(
+			kubernetes.ExemplarMetric{Name: kubernetes.Component + "_" + drainDuration.Name, Span: kubernetes.SpanDrain, Buckets: kubernetes.DrainDurationBuckets},
+			kubernetes.ExemplarMetric{Name: kubernetes.Component + "_" + evictionLatency.Name, Span: kubernetes.SpanEvict, Buckets: kubernetes.EvictionLatencyBuckets})
+		trace.RegisterExporter(exemplars)
+		admin.h["/exemplars"] = exemplars
+	}
+	trace.ApplyConfig(trace.Config{DefaultSampler: sampler})
 
-	c, err := kubernetes.BuildConfigFromFlags(*apiserver, *kubecfg)
-	kingpin.FatalIfError(err, "cannot create Kubernetes client configuration")
+	admin.h["/loglevel"] = level
+	admin.put = map[string]http.Handler{
+		"/loglevel": level,
+		// Acknowledges correlated failures in every cluster.
+		"/acknowledge-correlated-failure": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body.Close() // nolint:gosec
+			for _, st := range status {
+				if st.storm != nil {
+					st.storm.Acknowledge()
+				}
+			}
+		}),
+	}
 
-	cs, err := client.NewForConfig(c)
-	kingpin.FatalIfError(err, "cannot create Kubernetes client")
+	var sess *session.Session
+	if *awsLifecycleQueue != "" || *eksTerminateDrainedInstances || strings.HasPrefix(*auditDestination, "s3://") {
+		cfg := awssdk.NewConfig()
+		if *awsRegion != "" {
+			cfg = cfg.WithRegion(*awsRegion)
+		}
+		sess, err = session.NewSession(cfg)
+		kingpin.FatalIfError(err, "cannot create AWS session")
+	}
 
-	pf := []kubernetes.PodFilterFunc{kubernetes.MirrorPodFilter}
-	if !*evictLocalStoragePods {
-		pf = append(pf, kubernetes.LocalStoragePodFilter)
+	var audit *kubernetes.AuditExporter
+	if *auditDestination != "" {
+		store, prefix, err := auditStore(*auditDestination, sess, *azureIdentityClientID)
+		kingpin.FatalIfError(err, "cannot configure audit destination")
+		instance, err := os.Hostname()
+		kingpin.FatalIfError(err, "cannot determine hostname")
+		audit = kubernetes.NewAuditExporter(store,
+			kubernetes.WithAuditLogger(log),
+			kubernetes.WithAuditInterval(*auditInterval),
+			kubernetes.WithAuditKeyPrefix(prefix),
+			kubernetes.WithAuditInstance(instance))
 	}
-	if !*evictUnreplicatedPods {
-		pf = append(pf, kubernetes.UnreplicatedPodFilter)
+
+	if *historySize < 0 {
+		kingpin.Fatalf("--history-size must not be negative")
 	}
-	if !*evictDaemonSetPods {
-		pf = append(pf, kubernetes.NewDaemonSetPodFilter(cs))
+	var history *kubernetes.History
+	if *historySize > 0 {
+		history, err = kubernetes.NewHistory(
+			kubernetes.WithHistoryLogger(log),
+			kubernetes.WithHistorySize(*historySize),
+			kubernetes.WithHistoryRetention(*historyRetention),
+			kubernetes.WithHistoryFile(*historyFile))
+		kingpin.FatalIfError(err, "cannot load history")
+		admin.h["/history"] = history
 	}
-	if len(*protectedPodAnnotations) > 0 {
-		pf = append(pf, kubernetes.UnprotectedPodFilter(*protectedPodAnnotations...))
+
+	if *shardCount < 1 || *shardIndex < 0 || *shardIndex >= *shardCount {
+		kingpin.Fatalf("--shard-index must be at least zero and less than --shard-count")
 	}
-	var h cache.ResourceEventHandler = kubernetes.NewDrainingResourceEventHandler(
-		kubernetes.NewAPICordonDrainer(cs,
+	if *observeOnly {
+		if *awsLifecycleQueue != "" {
+			kingpin.Fatalf("--aws-lifecycle-queue cannot be used with --observe-only")
+		}
+		*dryRun = true
+	}
+	if *dryRunTiming && !*dryRun {
+		kingpin.Fatalf("--dry-run-drain-timing requires --dry-run")
+	}
+	if len(*kubeContexts) > 1 && *awsLifecycleQueue != "" {
+		kingpin.Fatalf("--aws-lifecycle-queue cannot be used with more than one --context")
+	}
+	if len(*kubeContexts) > 1 && len(*apiservers) > 1 {
+		kingpin.Fatalf("--master cannot be specified more than once with more than one --context")
+	}
+
+	loaded, err := startPlugins(log, *plugins, *pluginTimeout)
+	kingpin.FatalIfError(err, "cannot load plugin")
+	podExecs := make([]*kubernetes.ExecFilter, 0, len(*podFilterExecs))
+	for _, command := range *podFilterExecs {
+		f, err := kubernetes.NewExecFilter(command, *filterExecTimeout)
+		kingpin.FatalIfError(err, "cannot parse --pod-filter-exec")
+		podExecs = append(podExecs, f)
+	}
+	nodeExecs := make([]*kubernetes.ExecFilter, 0, len(*nodeFilterExecs))
+	for _, command := range *nodeFilterExecs {
+		f, err := kubernetes.NewExecFilter(command, *filterExecTimeout)
+		kingpin.FatalIfError(err, "cannot parse --node-filter-exec")
+		nodeExecs = append(nodeExecs, f)
+	}
+
+	// Each cluster is watched and drained independently.
+	// Evaluations use a fake client populated with the objects in the
+	// supplied file, and simulate draining each node in the file.
+	var (
+		evalClient    *fake.Clientset
+		simulateNodes = []string{*simulateNode}
+	)
+	if command == evalCmd.FullCommand() {
+		f, err := os.Open(*evalFile)
+		kingpin.FatalIfError(err, "cannot open %s", *evalFile)
+		objects, err := kubernetes.DecodeObjects(f)
+		f.Close() // nolint:gosec
+		kingpin.FatalIfError(err, "cannot decode %s", *evalFile)
+		evalClient = fake.NewSimpleClientset(objects...)
+		simulateNodes = nil
+		for _, o := range objects {
+			if n, ok := o.(*core.Node); ok {
+				simulateNodes = append(simulateNodes, n.GetName())
+			}
+		}
+		if *evalTime != "" {
+			t, err := time.Parse(time.RFC3339, *evalTime)
+			kingpin.FatalIfError(err, "cannot parse --time")
+			kubernetes.SetClock(func() time.Time { return t })
+		}
+	}
+
+	cluster := func(kubeContext string) []runner {
+		log := log
+		logFor := logs.For
+		if kubeContext != "" {
+			log = log.With(zap.String("cluster", kubeContext))
+			logFor = func(subsystem string) *zap.Logger {
+				return logs.For(subsystem).With(zap.String("cluster", kubeContext))
+			}
+		}
+
+		var (
+			c  *rest.Config
+			cs client.Interface
+		)
+		if evalClient != nil {
+			cs = evalClient
+		} else {
+			c, err = buildConfig(log, *apiservers, *kubecfg, kubeContext)
+			kingpin.FatalIfError(err, "cannot create Kubernetes client configuration")
+			if *observeOnly {
+				kubernetes.ConfigureReadOnly(c, log)
+			}
+			cs, err = client.NewForConfig(c)
+			kingpin.FatalIfError(err, "cannot create Kubernetes client")
+		}
+
+		hc := kubernetes.NewAPIHealthCheck(cs, kubernetes.WithAPITimeout(*livezTimeout), kubernetes.WithMaxWatchSilence(*livezWatchSilence))
+		livez = append(livez, hc)
+
+		// Node event handlers are added once they have been built below.
+		var transform kubernetes.NodeTransform = kubernetes.StripNode
+		if *cacheFullNodes {
+			transform = nil
+		}
+		nodes := kubernetes.NewTransformedNodeWatch(cs, transform, hc)
+
+		var er record.EventRecorder
+		if *observeOnly {
+			er = kubernetes.NewLoggingEventRecorder(log)
+		} else {
+			er = kubernetes.NewEventRecorder(cs,
+				kubernetes.WithEventSourceComponent(*eventComponent),
+				kubernetes.WithEventSourceHost(*eventHost),
+				kubernetes.WithEventNamespace(*eventNamespace))
+		}
+		er = kubernetes.NewPrefixedEventRecorder(er, *eventReasonPrefix)
+		if audit != nil {
+			er = kubernetes.NewAuditingEventRecorder(er, audit, kubeContext)
+		}
+		if history != nil {
+			er = kubernetes.NewAuditingEventRecorder(er, history, kubeContext)
+		}
+
+		headroom := *requiredHeadroomCPU != "" || *requiredHeadroomMemory != ""
+		protectReplicas := *lastReadyReplicaPolicy != kubernetes.LastReadyReplicaPolicyEvict
+		// Evaluations have no cluster to build a dynamic client for, and
+		// return before starting anything but the pod watch that needs one.
+		var dc dynamic.Interface
+		if evalClient == nil && (*drainRequests || *clusterAPIMachines || *nodeMaintenance || *remediation || *nodeLease || *checkRescheduling || headroom || protectReplicas) {
+			dc, err = dynamic.NewForConfig(c)
+			kingpin.FatalIfError(err, "cannot create Kubernetes dynamic client")
+		}
+		var pods *kubernetes.PodWatch
+		switch {
+		case !*checkRescheduling && !headroom && !protectReplicas:
+		case evalClient != nil:
+			pods = kubernetes.NewTypedPodWatch(cs)
+		default:
+			pods = kubernetes.NewPodWatch(dc)
+		}
+
+		pf := []kubernetes.NamedPodFilter{{Name: "mirror pod", Filter: kubernetes.MirrorPodFilter}}
+		if !*evictLocalStoragePods {
+			pf = append(pf, kubernetes.NamedPodFilter{Name: "uses local storage", Filter: kubernetes.LocalStoragePodFilter})
+		}
+		if !*evictUnreplicatedPods {
+			pf = append(pf, kubernetes.NamedPodFilter{Name: "not replicated", Filter: kubernetes.UnreplicatedPodFilter})
+		}
+		if !*evictDaemonSetPods {
+			pf = append(pf, kubernetes.NamedPodFilter{Name: "managed by a DaemonSet", Filter: kubernetes.NewDaemonSetPodFilter(cs)})
+		}
+		if *orphanedPodPolicy == kubernetes.OrphanedPodPolicyProtect {
+			pf = append(pf, kubernetes.NamedPodFilter{Name: "controller no longer exists", Filter: kubernetes.NewOrphanedPodFilter(cs)})
+		}
+		for _, p := range loaded {
+			if p.Capabilities().PodFilter {
+				pf = append(pf, kubernetes.NamedPodFilter{Name: "rejected by plugin " + p.Name(), Filter: p.FilterPod})
+			}
+		}
+		for _, f := range podExecs {
+			pf = append(pf, kubernetes.NamedPodFilter{Name: "rejected by filter " + f.Name(), Filter: f.FilterPod})
+		}
+		var wasms []*kubernetes.WasmFilter
+		if *podFilterWasm != "" {
+			f, err := wasmFilter(log, cs, *podFilterWasm, *wasmRuntime, *filterExecTimeout, *wasmPollInterval)
+			kingpin.FatalIfError(err, "cannot configure --pod-filter-wasm")
+			wasms = append(wasms, f)
+			pf = append(pf, kubernetes.NamedPodFilter{Name: "rejected by WebAssembly filter " + f.Name(), Filter: f.FilterPod})
+		}
+		if len(*protectedPodAnnotations) > 0 {
+			pf = append(pf, kubernetes.NamedPodFilter{Name: "protected by annotation", Filter: kubernetes.UnprotectedPodFilter(*protectedPodAnnotations...)})
+		}
+		if *lastReadyReplicaPolicy == kubernetes.LastReadyReplicaPolicySkip {
+			pf = append(pf, kubernetes.NamedPodFilter{Name: "last ready replica", Filter: kubernetes.NewLastReadyReplicaPodFilter(cs, pods)})
+		}
+		if *protectSoleEndpoints {
+			pf = append(pf, kubernetes.NamedPodFilter{Name: "only ready endpoint of a Service", Filter: kubernetes.NewSoleEndpointPodFilter(cs, *soleEndpointNamespaces...)})
+		}
+		filters := make([]kubernetes.PodFilterFunc, 0, len(pf))
+		for _, f := range pf {
+			filters = append(filters, f.Filter)
+		}
+
+		limits, err := parseConcurrencyLimits(*maxConcurrentDrains, *maxConcurrentDrainsPerLabel)
+		kingpin.FatalIfError(err, "cannot parse concurrency limits")
+		if *eksNodegroupMaxConcurrentDrains > 0 {
+			limits = append(limits, kubernetes.ConcurrencyLimit{LabelKey: aws.LabelEKSNodegroup, Max: *eksNodegroupMaxConcurrentDrains})
+		}
+		if *gkeNodePoolMaxConcurrentDrains > 0 {
+			limits = append(limits, kubernetes.ConcurrencyLimit{LabelKey: kubernetes.LabelGKENodePool, Max: *gkeNodePoolMaxConcurrentDrains})
+		}
+		if *azureScaleSetMaxConcurrentDrains > 0 {
+			limits = append(limits, kubernetes.ConcurrencyLimit{Group: azure.ScaleSetGroup, Max: *azureScaleSetMaxConcurrentDrains})
+		}
+		unavailable, err := parseConcurrencyLimits(0, *maxUnavailablePerLabel)
+		kingpin.FatalIfError(err, "cannot parse max unavailable limits")
+		if *eksNodegroupMaxUnavailable > 0 {
+			unavailable = append(unavailable, kubernetes.ConcurrencyLimit{LabelKey: aws.LabelEKSNodegroup, Max: *eksNodegroupMaxUnavailable})
+		}
+		if *gkeNodePoolMaxUnavailable > 0 {
+			unavailable = append(unavailable, kubernetes.ConcurrencyLimit{LabelKey: kubernetes.LabelGKENodePool, Max: *gkeNodePoolMaxUnavailable})
+		}
+		if *azureScaleSetMaxUnavailable > 0 {
+			unavailable = append(unavailable, kubernetes.ConcurrencyLimit{Group: azure.ScaleSetGroup, Max: *azureScaleSetMaxUnavailable})
+		}
+		priorities, err := parseConditionPriorities(*conditionPriorities)
+		kingpin.FatalIfError(err, "cannot parse condition priorities")
+		if *npdPreset {
+			for c, p := range kubernetes.NodeProblemDetectorPreset.Priorities {
+				if _, ok := priorities[c]; !ok {
+					priorities[c] = p
+				}
+			}
+		}
+		expressions := make([]kubernetes.ConditionExpression, 0, len(*conditions))
+		for _, c := range *conditions {
+			e, err := kubernetes.ParseConditionExpression(c)
+			kingpin.FatalIfError(err, "cannot parse node conditions")
+			expressions = append(expressions, e)
+		}
+
+		labels := map[string]string{}
+		for k, v := range *drainLabels {
+			labels[k] = v
+		}
+		for k, v := range *urgentDrainLabels {
+			labels[k] = v
+		}
+		reasons := kubernetes.NewCordonReasonFunc(expressions, labels)
+
+		shard := func(o interface{}) bool { return true }
+		if *shardCount > 1 {
+			shard = kubernetes.NewNodeShardFilter(*shardIndex, *shardCount, *shardLabel)
+		}
+		labelled := kubernetes.NewNodeLabelFilter(*nodeLabels)
+
+		so := []kubernetes.DrainSchedulerOption{
+			kubernetes.WithSchedulerKeys(keys),
+			kubernetes.WithDrainBuffer(*drainBuffer),
+			kubernetes.WithDrainBufferJitter(*drainBufferJitter),
+			kubernetes.WithDrainBufferPerLabel(*drainBufferPerLabel),
+			kubernetes.WithGroupCooldown(*groupDrainCooldownLabel, *groupDrainCooldown),
+			kubernetes.WithConcurrencyLimits(limits...),
+			kubernetes.WithNodePriority(kubernetes.NewDrainInProgressPriorityFunc(keys, kubernetes.NewConditionPriorityFunc(priorities))),
+			kubernetes.WithDrainGates(kubernetes.NewStartupBacklogGate(nodes.HasSynced, *startupBacklogDelay)),
+		}
+		if len(*nodeScorers) > 0 {
+			scorers, err := parseNodeScorers(*nodeScorers, priorities)
+			kingpin.FatalIfError(err, "cannot parse node scorers")
+			so = append(so, kubernetes.WithNodeScore(kubernetes.NewNodeScoreFunc(cs, kubernetes.NewPodFilters(filters...), reasons, scorers...)))
+		}
+		if *drainDeleting {
+			so = append(so, kubernetes.WithUrgentDrains(kubernetes.NodeDeletingFilter))
+		}
+		if len(*urgentDrainLabels) > 0 {
+			so = append(so, kubernetes.WithUrgentDrains(kubernetes.NewNodeAnyLabelFilter(*urgentDrainLabels)))
+		}
+		if len(unavailable) > 0 {
+			so = append(so, kubernetes.WithMaxUnavailable(nodes, unavailable...))
+		}
+		if *maxDrainsPerHour > 0 {
+			so = append(so, kubernetes.WithDrainRateLimit(rate.Every(time.Hour/time.Duration(*maxDrainsPerHour)), *maxDrainsBurst))
+		}
+		var blackouts []kubernetes.TimeWindowSource
+		if len(*blackoutWindows) > 0 || *blackoutConfigMap != "" {
+			sources, err := blackoutSources(cs, *blackoutWindows, *blackoutConfigMap, *blackoutTimezone)
+			kingpin.FatalIfError(err, "cannot parse blackout windows")
+			blackouts = sources
+			so = append(so, kubernetes.WithDrainGates(kubernetes.NewBlackoutGate(sources...)))
+		}
+		if *gkeUpgradeAware {
+			so = append(so, kubernetes.WithDrainGates(kubernetes.NewNodePoolUpgradeGate(nodes, kubernetes.LabelGKENodePool)))
+		}
+		var breaker *kubernetes.PendingPodsBreaker
+		if *maxUnschedulablePods > 0 {
+			breaker = kubernetes.NewPendingPodsBreaker(cs, *maxUnschedulablePods,
+				kubernetes.WithPendingPodsLogger(logFor(subsystemScheduler)),
+				kubernetes.WithPendingPodsInterval(*unschedulableInterval),
+				kubernetes.WithPendingPodsClusterName(kubeContext))
+			so = append(so, kubernetes.WithDrainGates(breaker.Gate))
+		}
+		var storm *kubernetes.StormDetector
+		if *stormThreshold > 0 {
+			storm = kubernetes.NewStormDetector(er, nodes, func(o interface{}) bool { return labelled(o) && shard(o) }, reasons, *stormThreshold,
+				kubernetes.WithStormLogger(logFor(subsystemWatcher)),
+				kubernetes.WithStormWindow(*stormWindow),
+				kubernetes.WithStormClusterName(kubeContext))
+			so = append(so, kubernetes.WithDrainGates(storm.Gate))
+		}
+		if *checkRescheduling {
+			so = append(so, kubernetes.WithDrainGates(kubernetes.NewReschedulingGate(pods, nodes, kubernetes.NewPodFilters(filters...), er)))
+		}
+		if headroom {
+			var cpu, memory *kubernetes.Headroom
+			if *requiredHeadroomCPU != "" {
+				cpu, err = kubernetes.ParseHeadroom(*requiredHeadroomCPU)
+				kingpin.FatalIfError(err, "cannot parse required CPU headroom")
+			}
+			if *requiredHeadroomMemory != "" {
+				memory, err = kubernetes.ParseHeadroom(*requiredHeadroomMemory)
+				kingpin.FatalIfError(err, "cannot parse required memory headroom")
+			}
+			so = append(so, kubernetes.WithDrainGates(kubernetes.NewHeadroomGate(pods, nodes, cpu, memory)))
+		}
+		if *kuredLock != "" {
+			parts := strings.SplitN(*kuredLock, "/", 2)
+			if len(parts) != 2 {
+				kingpin.Fatalf("kured lock %q must be of the form NAMESPACE/NAME", *kuredLock)
+			}
+			if *kuredLockTTL < 0 {
+				kingpin.Fatalf("--kured-lock-ttl must not be negative")
+			}
+			so = append(so, kubernetes.WithDrainLock(kubernetes.NewKuredLock(cs, parts[0], parts[1], *kuredLockAnnotation, kubernetes.WithKuredLockTTL(*kuredLockTTL))))
+		}
+		s := kubernetes.NewDrainScheduler(append(so,
+			kubernetes.WithSchedulerLogger(logFor(subsystemScheduler)),
+			kubernetes.WithShutdownGracePeriod(*shutdownGracePeriod))...)
+
+		var lease *kubernetes.NodeLeaseLock
+		if *nodeLease && !*dryRun {
+			if *nodeLeaseNamespace == "kube-node-lease" {
+				kingpin.Fatalf("--node-lease-namespace must not be kube-node-lease")
+			}
+			if *nodeLeaseDuration < time.Second {
+				kingpin.Fatalf("--node-lease-duration must be at least 1s")
+			}
+			holder, err := leaseHolder()
+			kingpin.FatalIfError(err, "cannot determine node lease holder")
+			lease = kubernetes.NewNodeLeaseLock(dc, *nodeLeaseNamespace, holder,
+				kubernetes.WithLeaseLogger(logFor(subsystemDrainer)),
+				kubernetes.WithLeaseDuration(*nodeLeaseDuration))
+		}
+
+		deferring := *deferToOtherDrainers || len(*deferToTaints) > 0 || len(*deferToAnnotations) > 0
+		foreign := kubernetes.NewNodeForeignDrainFilter(append(kubernetes.ForeignDrainTaints(), *deferToTaints...), *deferToAnnotations)
+		do := []kubernetes.APICordonDrainerOption{
+			kubernetes.WithDrainerLogger(logFor(subsystemDrainer)),
+			kubernetes.WithDrainerKeys(keys),
+			kubernetes.WithGracePeriodPolicy(*gracePeriodPolicy),
 			kubernetes.MaxGracePeriod(*maxGracePeriod),
 			kubernetes.EvictionHeadroom(*evictionHeadroom),
-			kubernetes.WithPodFilter(kubernetes.NewPodFilters(pf...))),
-		kubernetes.NewEventRecorder(cs),
-		kubernetes.WithLogger(log),
-		kubernetes.WithDrainBuffer(*drainBuffer))
+			kubernetes.WithPodFilter(kubernetes.NewPodFilters(filters...)),
+			kubernetes.WithDrainMarker(),
+			kubernetes.WithCordonReasonAnnotation(reasons),
+		}
+		if *clusterAutoscaler {
+			do = append(do, kubernetes.WithAutoscalerScaleDownDisabled())
+		}
+		if deferring {
+			do = append(do, kubernetes.WithDeferTo(foreign))
+		}
+		if *maxPodsToEvict > 0 {
+			do = append(do, kubernetes.MaxPodsToEvict(*maxPodsToEvict))
+		}
+		if *podDeletionTimeout > 0 {
+			do = append(do, kubernetes.PodDeletionTimeout(*podDeletionTimeout))
+		}
+		if *drainTimeoutPerPod > 0 {
+			do = append(do, kubernetes.DrainTimeout(*drainTimeoutBase, *drainTimeoutPerPod))
+		}
+		if *maxNamespaceEvictions > 0 {
+			do = append(do, kubernetes.WithNamespaceEvictionLimit(*maxNamespaceEvictions, *namespaceEvictionPeriod))
+		}
+		if *maxEvictionRate < 0 {
+			kingpin.Fatalf("--max-eviction-rate must not be negative")
+		}
+		if *maxEvictionRate > 0 {
+			do = append(do, kubernetes.WithAdaptiveEvictionRate(*maxEvictionRate))
+		}
+		if *apiRetries < 0 {
+			kingpin.Fatalf("--api-retries must not be negative")
+		}
+		do = append(do, kubernetes.WithAPIRetries(*apiRetries, kubernetes.DefaultAPIRetryDelay))
+		if *drainProgress {
+			if *drainProgressInterval <= 0 {
+				kingpin.Fatalf("--drain-progress-interval must be positive")
+			}
+			do = append(do, kubernetes.WithDrainProgress(*drainProgressInterval))
+		}
+		if *evictionEvents {
+			do = append(do, kubernetes.WithEvictionEvents(er))
+		}
+		if *protectSoleEndpoints {
+			do = append(do, kubernetes.WithEvictionRefusedEvents(er, kubernetes.NewSoleEndpointRefusal(cs, *soleEndpointNamespaces...)))
+		}
+
+		if len(*removeStuckFinalizers) > 0 {
+			do = append(do, kubernetes.WithFinalizerRemoval(*removeStuckFinalizersAfter, *removeStuckFinalizers...))
+		}
+		if *batchEvictionsByBudget {
+			do = append(do, kubernetes.WithBudgetBatching())
+		}
+		if *lastReadyReplicaPolicy == kubernetes.LastReadyReplicaPolicyDefer {
+			do = append(do, kubernetes.WithLastReadyReplicaDeferral(pods))
+		}
+		if lease != nil {
+			do = append(do, kubernetes.WithDrainAbort(lease.Lost))
+		}
+
+		// Drains are always simulated as the api strategy would perform them.
+		ad := kubernetes.NewAPICordonDrainer(cs, do...)
+		strategy := *drainer
+		if *dryRun {
+			strategy = kubernetes.DrainerNoop
+		}
+		var d kubernetes.CordonDrainer = ad
+		if strategy != kubernetes.DrainerAPI {
+			d, err = kubernetes.NewCordonDrainer(strategy, kubernetes.DrainerConfig{
+				Client:         cs,
+				APIOptions:     do,
+				Webhook:        *drainerWebhook,
+				WebhookTimeout: *drainerWebhookTimeout,
+			})
+			kingpin.FatalIfError(err, "cannot configure drainer")
+		}
+		var surging *kubernetes.SurgingCordonDrainer
+		if *surgeDeployments && !*dryRun {
+			surging = kubernetes.NewSurgingCordonDrainer(d, cs,
+				kubernetes.WithSurgeLogger(logFor(subsystemDrainer)),
+				kubernetes.WithSurgeKeys(keys),
+				kubernetes.WithSurgePodFilter(kubernetes.NewPodFilters(filters...)),
+				kubernetes.WithSurgeTimeout(*surgeTimeout),
+				kubernetes.WithSurgeReconciliation(nodes, nodes.HasSynced, func(o interface{}) bool { return labelled(o) && shard(o) }))
+			d = surging
+		}
+
+		var estimates *kubernetes.EstimatingCordonDrainer
+		if *dryRunTiming {
+			estimates = kubernetes.NewEstimatingCordonDrainer(d, ad, er, pf, kubernetes.WithEstimateLogger(logFor(subsystemDrainer)))
+			d = estimates
+		}
+
+		ho := []kubernetes.DrainingResourceEventHandlerOption{
+			kubernetes.WithLogger(logFor(subsystemDrainer)),
+			kubernetes.WithDrainScheduler(s),
+			kubernetes.WithNodePoolLabels(kubernetes.LabelGKENodePool, aws.LabelEKSNodegroup),
+			kubernetes.WithClusterName(kubeContext),
+			kubernetes.WithCordonReasons(reasons),
+			kubernetes.WithFailedDrainCooldown(*failedDrainCooldown),
+		}
+		if !*allowControlPlaneDrain {
+			ho = append(ho, kubernetes.WithProtectedNodes(kubernetes.NodeControlPlaneFilter))
+		}
+		for _, p := range loaded {
+			if p.Capabilities().NodeFilter {
+				ho = append(ho, kubernetes.WithCordonFilters(p.FilterNode))
+			}
+		}
+		for _, f := range nodeExecs {
+			ho = append(ho, kubernetes.WithCordonFilters(f.FilterNode))
+		}
+		if *nodeFilterWasm != "" {
+			f, err := wasmFilter(log, cs, *nodeFilterWasm, *wasmRuntime, *filterExecTimeout, *wasmPollInterval)
+			kingpin.FatalIfError(err, "cannot configure --node-filter-wasm")
+			wasms = append(wasms, f)
+			ho = append(ho, kubernetes.WithCordonFilters(f.FilterNode))
+		}
+		if *maxPodsToEvict > 0 {
+			ho = append(ho, kubernetes.WithDrainCheck(ad.CheckPods))
+		}
+		if len(*conditionActions) > 0 {
+			defined, err := kubernetes.NewPipelines(*pipelines)
+			kingpin.FatalIfError(err, "cannot parse pipelines")
+			assigned, err := parseConditionActions(*conditionActions, defined)
+			kingpin.FatalIfError(err, "cannot parse condition actions")
+			ho = append(ho, kubernetes.WithNodePipelines(kubernetes.NewReasonPipelineFunc(reasons, assigned)))
+		}
+		if !*dryRun {
+			ho = append(ho, kubernetes.WithUncordonFunc(kubernetes.NewNodeUncordonHook(cs, keys).Run))
+			if lease != nil {
+				ho = append(ho, kubernetes.WithNodeLock(lease))
+			}
+			if *notifyWebhook != "" {
+				hook, err := kubernetes.NewHTTPHook(*notifyWebhook, *notifyTimeout)
+				kingpin.FatalIfError(err, "cannot configure notify webhook")
+				ho = append(ho, kubernetes.WithNotifyFuncs(hook.Run))
+			}
+		}
+		if *shardCount > 1 {
+			ho = append(ho, kubernetes.WithShard(*shardIndex))
+		}
+		if *maxCordonedNodes != "" {
+			limit, err := kubernetes.ParseCordonLimit(*maxCordonedNodes)
+			kingpin.FatalIfError(err, "cannot parse maximum cordoned nodes")
+			ho = append(ho, kubernetes.WithCordonLimiter(kubernetes.NewCordonLimiter(nodes, keys, func(o interface{}) bool { return labelled(o) && shard(o) }, limit)))
+		}
+		if !*dryRun {
+			post, err := hookFuncs(cs, keys, "post-drain", *postDrainActions, *postDrainTimeout, "webhook", "job", "annotation", "taint")
+			kingpin.FatalIfError(err, "cannot configure post-drain actions")
+			for _, fn := range post {
+				if *postDrainFailurePolicy == kubernetes.HookFailurePolicyIgnore {
+					fn = kubernetes.IgnoreHookFailures(logFor(subsystemDrainer), fn)
+				}
+				ho = append(ho, kubernetes.WithPostDrainFuncs(fn))
+			}
+			if len(*rebootConditions) > 0 {
+				kv := strings.SplitN(*rebootAnnotation, "=", 2)
+				if len(kv) != 2 {
+					kingpin.Fatalf("reboot annotation %q must be of the form KEY=VALUE", *rebootAnnotation)
+				}
+				hook := kubernetes.NewNodeAnnotationHook(cs, kv[0], kv[1])
+				ho = append(ho, kubernetes.WithPostDrainFuncs(kubernetes.WhenConditions(*rebootConditions, hook.Run)))
+			}
+		}
+		if *eksTerminateDrainedInstances && !*dryRun {
+			ho = append(ho, kubernetes.WithReplaceFuncs(aws.NewInstanceTerminator(autoscaling.New(sess)).Terminate))
+		}
+		if *azurePostDrainAction != "" && !*dryRun {
+			vmss := azure.NewScaleSetClient(azure.NewManagedIdentityTokenSource(*azureIdentityClientID), azure.WithEndpoint(*azureEndpoint))
+			fn, err := vmss.PostDrainFunc(*azurePostDrainAction)
+			kingpin.FatalIfError(err, "cannot configure Azure post-drain action")
+			ho = append(ho, kubernetes.WithReplaceFuncs(fn))
+		}
+		if !*dryRun {
+			var pre []kubernetes.PreDrainFunc
+			if *preDrainWebhook != "" {
+				hook, err := kubernetes.NewHTTPHook(*preDrainWebhook, *preDrainTimeout)
+				kingpin.FatalIfError(err, "cannot configure pre-drain webhook")
+				pre = append(pre, hook.Run)
+			}
+			if *preDrainJob != "" {
+				manifest, err := ioutil.ReadFile(*preDrainJob)
+				kingpin.FatalIfError(err, "cannot read pre-drain Job")
+				hook, err := kubernetes.NewJobHook(cs, keys, string(manifest), *preDrainTimeout)
+				kingpin.FatalIfError(err, "cannot configure pre-drain Job")
+				pre = append(pre, hook.Run)
+			}
+			for _, fn := range pre {
+				if *preDrainFailurePolicy == kubernetes.HookFailurePolicyIgnore {
+					fn = kubernetes.IgnoreHookFailures(logFor(subsystemDrainer), fn)
+				}
+				ho = append(ho, kubernetes.WithPreDrainFuncs(fn))
+			}
+		}
+		if *reportMirrorPods || *mirrorPodWebhook != "" {
+			var mo []kubernetes.MirrorPodHandlerOption
+			if *mirrorPodWebhook != "" && !*dryRun {
+				hook, err := kubernetes.NewHTTPHook(*mirrorPodWebhook, *mirrorPodTimeout)
+				kingpin.FatalIfError(err, "cannot configure mirror pod webhook")
+				mo = append(mo, kubernetes.WithMirrorPodStopper(hook.Run, *mirrorPodTimeout))
+			}
+			ho = append(ho, kubernetes.WithMirrorPodHandler(kubernetes.NewMirrorPodHandler(cs, mo...)))
+		}
+		if *deleteDrainedNodes && !*dryRun {
+			ho = append(ho, kubernetes.WithReplaceFuncs(kubernetes.NewNodeDeleteHook(cs, *postDrainTimeout,
+				kubernetes.WithNodeDeletePodFilter(kubernetes.NewPodFilters(filters...))).Run))
+		}
+		dh := kubernetes.NewDrainingResourceEventHandler(d, er, ho...)
+		status = append(status, clusterStatus{Cluster: kubeContext, breaker: breaker, storm: storm, drains: dh, scheduler: s, estimates: estimates})
+
+		var h cache.ResourceEventHandler = dh
+		if *dryRun {
+			h = cache.FilteringResourceEventHandler{FilterFunc: kubernetes.NewNodeProcessed().Filter, Handler: dh}
+		}
 
-	if *dryRun {
-		h = cache.FilteringResourceEventHandler{
-			FilterFunc: kubernetes.NewNodeProcessed().Filter,
-			Handler: kubernetes.NewDrainingResourceEventHandler(
-				&kubernetes.NoopCordonDrainer{},
-				kubernetes.NewEventRecorder(cs),
-				kubernetes.WithLogger(log),
-				kubernetes.WithDrainBuffer(*drainBuffer)),
+		// Correlated failures pause the cordoning of newly matching nodes,
+		// but not the drains of nodes that were already cordoned.
+		var th cache.ResourceEventHandler = h
+		if storm != nil {
+			th = cache.FilteringResourceEventHandler{FilterFunc: storm.Filter, Handler: h}
 		}
+		var sf cache.ResourceEventHandler = cache.FilteringResourceEventHandler{FilterFunc: kubernetes.NodeSchedulableFilter, Handler: th}
+		if *cordonedNodePolicy == kubernetes.CordonedNodePolicyDrain {
+			// Draino leaves nodes that something else cordoned cordoned once
+			// they are drained, so they are handled once per set of
+			// conditions rather than drained again on every update.
+			var ch cache.ResourceEventHandler = cache.FilteringResourceEventHandler{FilterFunc: kubernetes.NewNodeProcessed().Filter, Handler: h}
+			if storm != nil {
+				ch = cache.FilteringResourceEventHandler{FilterFunc: storm.Filter, Handler: ch}
+			}
+			sf = kubernetes.ResourceEventHandlers{
+				sf,
+				cache.FilteringResourceEventHandler{FilterFunc: kubernetes.NewNodeCordonedElsewhereFilter(keys), Handler: ch},
+			}
+		}
+		triggers := []func(o interface{}) bool{}
+		if len(expressions) > 0 {
+			triggers = append(triggers, kubernetes.NewNodeConditionExpressionFilter(expressions...))
+		}
+		if len(*drainLabels) > 0 {
+			triggers = append(triggers, kubernetes.NewNodeAnyLabelFilter(*drainLabels))
+		}
+		if len(*urgentDrainLabels) > 0 {
+			triggers = append(triggers, kubernetes.NewNodeAnyLabelFilter(*urgentDrainLabels))
+		}
+		triggered := kubernetes.NewAnyFilter(triggers...)
+		var cf cache.ResourceEventHandler = cache.FilteringResourceEventHandler{FilterFunc: triggered, Handler: sf}
+		if *drainDeleting {
+			// Nodes marked for deletion are drained once, even if they were
+			// already cordoned, and never by virtue of their conditions.
+			cf = kubernetes.ResourceEventHandlers{
+				cache.FilteringResourceEventHandler{
+					FilterFunc: kubernetes.NodeDeletingFilter,
+					Handler:    cache.FilteringResourceEventHandler{FilterFunc: kubernetes.NewNodeProcessed().Filter, Handler: h},
+				},
+				cache.FilteringResourceEventHandler{
+					FilterFunc: func(o interface{}) bool { return !kubernetes.NodeDeletingFilter(o) },
+					Handler:    cf,
+				},
+			}
+		}
+		// Nodes draino cordoned but did not finish draining, for example because
+		// it was shut down mid-drain, are drained once regardless of their
+		// conditions. Nodes this replica is draining are annotated too, but
+		// must not be handled again.
+		inProgress := kubernetes.NewNodeDrainInProgressFilter(keys)
+		cf = kubernetes.ResourceEventHandlers{
+			cache.FilteringResourceEventHandler{
+				FilterFunc: inProgress,
+				Handler: cache.FilteringResourceEventHandler{
+					FilterFunc: kubernetes.NewNodeProcessed().Filter,
+					Handler:    cache.FilteringResourceEventHandler{FilterFunc: func(o interface{}) bool { return !dh.Draining(o) }, Handler: h},
+				},
+			},
+			cache.FilteringResourceEventHandler{
+				FilterFunc: func(o interface{}) bool { return !inProgress(o) },
+				Handler:    cf,
+			},
+		}
+		if *clusterAutoscaler {
+			cf = cache.FilteringResourceEventHandler{FilterFunc: func(o interface{}) bool { return !kubernetes.NodeAutoscalerDeletingFilter(o) }, Handler: cf}
+		}
+		if deferring {
+			cf = cache.FilteringResourceEventHandler{FilterFunc: func(o interface{}) bool { return !foreign(o) }, Handler: cf}
+		}
+		if *shardCount > 1 {
+			cf = cache.FilteringResourceEventHandler{FilterFunc: shard, Handler: cf}
+		}
+		lf := cache.FilteringResourceEventHandler{FilterFunc: labelled, Handler: cf}
+		if *nodeUpdateInterval < 0 {
+			kingpin.Fatalf("--node-update-interval must not be negative")
+		}
+		nq := kubernetes.NewNodeQueue(lf,
+			kubernetes.WithNodeQueueLogger(logFor(subsystemWatcher)),
+			kubernetes.WithNodeQueueInterval(*nodeUpdateInterval))
+		nodes.AddEventHandler(nq)
+
+		if simulating {
+			return []runner{&simulator{
+				w:         os.Stdout,
+				c:         cs,
+				d:         ad,
+				nodes:     simulateNodes,
+				filters:   pf,
+				pods:      pods,
+				managed:   func(o interface{}) bool { return labelled(o) && shard(o) },
+				triggered: triggered,
+				reasons:   reasons,
+				keys:      keys,
+				buffer:    *drainBuffer,
+			}}
+		}
+
+		no := []kubernetes.NodeStateRecorderOption{
+			kubernetes.WithNodeStatePoolLabels(kubernetes.LabelGKENodePool, aws.LabelEKSNodegroup),
+			kubernetes.WithNodeStateClusterName(kubeContext),
+			kubernetes.WithNodeStateKeys(keys),
+			kubernetes.WithLastDrained(dh.LastDrained),
+		}
+		if *shardCount > 1 {
+			no = append(no, kubernetes.WithNodeStateShard(*shardIndex))
+		}
+		ours := func(o interface{}) bool { return labelled(o) && shard(o) }
+
+		var nm *kubernetes.NodeMaintenanceController
+		if *nodeMaintenance {
+			nmo := []kubernetes.NodeMaintenanceControllerOption{
+				kubernetes.WithNodeMaintenanceLogger(logFor(subsystemWatcher)),
+				kubernetes.WithNodeMaintenanceGroup(*nodeMaintenanceGroup),
+				kubernetes.WithNodeMaintenanceKeys(keys),
+			}
+			if *dryRun {
+				nmo = append(nmo, kubernetes.WithNodeMaintenanceDryRun())
+			}
+			nm = kubernetes.NewNodeMaintenanceController(dc, cs, nodes, dh, nmo...)
+		}
+		var drc *kubernetes.DrainRequestController
+		if *drainRequests {
+			dro := []kubernetes.DrainRequestControllerOption{kubernetes.WithDrainRequestLogger(logFor(subsystemWatcher))}
+			if *dryRun {
+				dro = append(dro, kubernetes.WithDrainRequestDryRun())
+			}
+			drc = kubernetes.NewDrainRequestController(dc, nodes, dh, dro...)
+		}
+		var mc *kubernetes.MachineController
+		if *clusterAPIMachines {
+			mco := []kubernetes.MachineControllerOption{kubernetes.WithMachineLogger(logFor(subsystemWatcher))}
+			if *dryRun {
+				mco = append(mco, kubernetes.WithMachineDryRun())
+			}
+			mc = kubernetes.NewMachineController(dc, nodes, dh, mco...)
+		}
+		var rc *kubernetes.RemediationController
+		if *remediation {
+			gvr, _ := schema.ParseResourceArg(*remediationResource)
+			if gvr == nil {
+				kingpin.Fatalf("cannot parse --remediation-resource %s: must be RESOURCE.VERSION.GROUP", *remediationResource)
+			}
+			rco := []kubernetes.RemediationControllerOption{
+				kubernetes.WithRemediationLogger(logFor(subsystemWatcher)),
+				kubernetes.WithRemediationResource(*gvr),
+				kubernetes.WithRemediationKeys(keys),
+			}
+			if *dryRun {
+				rco = append(rco, kubernetes.WithRemediationDryRun())
+			}
+			rc = kubernetes.NewRemediationController(dc, cs, nodes, dh, rco...)
+		}
+
+		rs := []runner{nodes, nq, s, dh, kubernetes.NewNodeStateRecorder(nodes, ours, no...)}
+		if pods != nil {
+			rs = append(rs, pods)
+		}
+		if surging != nil {
+			rs = append(rs, surging)
+		}
+		if estimates != nil {
+			rs = append(rs, estimates)
+		}
+		if breaker != nil {
+			rs = append(rs, breaker)
+		}
+		for _, b := range blackouts {
+			// ConfigMap sources must watch their ConfigMap.
+			if r, ok := b.(runner); ok {
+				rs = append(rs, r)
+			}
+		}
+		for _, f := range wasms {
+			rs = append(rs, f)
+		}
+		if (*rebalance || len(*rebalanceActions) > 0) && !*uncordon && !*uncordonAfterReboot {
+			kingpin.Fatalf("--uncordon is required when --rebalance or --rebalance-action is specified")
+		}
+		if (*uncordon || *uncordonAfterReboot) && !*dryRun {
+			// Nodes this replica would not cordon are never uncordoned, nor
+			// are nodes under maintenance or remediation, nodes whose drain
+			// was requested, or nodes of deleting Machines.
+			unhealthy := func(o interface{}) bool {
+				return !labelled(o) || !shard(o) || triggered(o) ||
+					(nm != nil && nm.InMaintenance(o)) || (rc != nil && rc.Remediating(o)) ||
+					(drc != nil && drc.Requested(o)) || (mc != nil && mc.Deleting(o))
+			}
+			uo := []kubernetes.UncordonerOption{
+				kubernetes.WithUncordonerLogger(logFor(subsystemDrainer)),
+				kubernetes.WithUncordonerKeys(keys),
+				kubernetes.WithHealthyPeriod(*uncordonHealthyPeriod),
+			}
+			if *uncordonAfterReboot {
+				uo = append(uo, kubernetes.WithRebootRequired())
+			}
+			if len(*rebootConditions) > 0 {
+				uo = append(uo, kubernetes.WithClearedAnnotations(strings.SplitN(*rebootAnnotation, "=", 2)[0]))
+			}
+			if *uncordonCanaryImage != "" {
+				uo = append(uo, kubernetes.WithCanaryPod(*uncordonCanaryImage, *uncordonCanaryNamespace, *uncordonCanaryTimeout))
+			}
+			if *rebalance || len(*rebalanceActions) > 0 {
+				fns, err := hookFuncs(cs, keys, "rebalance", *rebalanceActions, *rebalanceTimeout, "webhook", "job")
+				kingpin.FatalIfError(err, "cannot configure rebalance actions")
+				uo = append(uo, kubernetes.WithRebalance(fns...))
+			}
+			rs = append(rs, kubernetes.NewUncordoner(cs, er, nodes, unhealthy, uo...))
+		}
+		if len(*prometheusConditions) > 0 && *prometheusURL == "" {
+			kingpin.Fatalf("--prometheus-url is required when --prometheus-condition is specified")
+		}
+		for c, q := range *prometheusConditions {
+			p := kubernetes.NewPrometheusProber(*prometheusURL, q, *prometheusNodeLabel)
+			rs = append(rs, kubernetes.NewConditionSource(cs, nodes, c, p,
+				kubernetes.WithConditionSourceLogger(logFor(subsystemWatcher)),
+				kubernetes.WithProbeInterval(*prometheusInterval)))
+		}
+		for c, u := range *probeConditions {
+			p, err := kubernetes.NewEndpointProber(u,
+				kubernetes.WithEndpointProbeTimeout(*probeTimeout),
+				kubernetes.WithEndpointProbeFailureThreshold(*probeFailureThreshold))
+			kingpin.FatalIfError(err, "cannot parse probe condition %s", c)
+			rs = append(rs, kubernetes.NewConditionSource(cs, nodes, c, p,
+				kubernetes.WithConditionSourceLogger(logFor(subsystemWatcher)),
+				kubernetes.WithProbeInterval(*probeInterval)))
+		}
+
+		if drc != nil {
+			rs = append(rs, drc)
+		}
+		if mc != nil {
+			rs = append(rs, mc)
+		}
+		if nm != nil {
+			rs = append(rs, nm)
+		}
+		if rc != nil {
+			rs = append(rs, rc)
+		}
+
+		if *awsLifecycleQueue != "" {
+			lo := []aws.LifecycleHookConsumerOption{
+				aws.WithLogger(logFor(subsystemWatcher)),
+				aws.WithHeartbeatInterval(*awsLifecycleHeartbeat),
+			}
+			if *dryRun {
+				lo = append(lo, aws.WithDryRun())
+			}
+			rs = append(rs, aws.NewLifecycleHookConsumer(sqs.New(sess), autoscaling.New(sess), *awsLifecycleQueue, nodes, dh, lo...))
+		}
+
+		return rs
 	}
 
-	sf := cache.FilteringResourceEventHandler{FilterFunc: kubernetes.NodeSchedulableFilter, Handler: h}
-	cf := cache.FilteringResourceEventHandler{FilterFunc: kubernetes.NewNodeConditionFilter(*conditions), Handler: sf}
-	lf := cache.FilteringResourceEventHandler{FilterFunc: kubernetes.NewNodeLabelFilter(*nodeLabels), Handler: cf}
-	nodes := kubernetes.NewNodeWatch(cs, lf)
+	contexts := *kubeContexts
+	if len(contexts) == 0 {
+		contexts = []string{""}
+	}
+	if simulating {
+		// Drains are simulated in the first cluster only.
+		kingpin.FatalIfError(await(cluster(contexts[0])...), "cannot simulate drain")
+		return
+	}
 
-	kingpin.FatalIfError(await(nodes, web), "error serving")
+	auth := &authenticator{l: log, clientCerts: *tlsClientCAFile != ""}
+	if *httpTokenFile != "" {
+		auth.tokens, err = readTokens(*httpTokenFile)
+		kingpin.FatalIfError(err, "cannot configure HTTP authentication")
+	}
+	if *httpKubernetesAuth {
+		// Requests are authorized by the cluster draino runs in, i.e. the
+		// first cluster it manages.
+		c, err := buildConfig(log, *apiservers, *kubecfg, contexts[0])
+		kingpin.FatalIfError(err, "cannot create Kubernetes client configuration")
+		cs, err := client.NewForConfig(c)
+		kingpin.FatalIfError(err, "cannot create Kubernetes client")
+		auth.api = kubernetes.NewAPIAuthorizer(cs)
+	}
+	if auth.Enabled() {
+		for path, h := range admin.put {
+			admin.put[path] = auth.Wrap(h)
+		}
+		// These describe draino's state, including the nodes it manages
+		// and what it has done to them.
+		for _, path := range []string{"/status", "/history", "/exemplars"} {
+			if h, ok := admin.h[path]; ok {
+				admin.h[path] = auth.Wrap(h)
+			}
+		}
+	} else {
+		log.Info("HTTP endpoints that change or describe draino's state are not authenticated")
+	}
+
+	rs := []runner{&signalRunner{l: log}, web}
+	for _, p := range loaded {
+		rs = append(rs, p)
+	}
+	if admin != web {
+		rs = append(rs, admin)
+	}
+	if audit != nil {
+		rs = append(rs, audit)
+	}
+	if history != nil {
+		rs = append(rs, history)
+	}
+	for _, kubeContext := range contexts {
+		rs = append(rs, cluster(kubeContext)...)
+	}
+	kingpin.FatalIfError(await(rs...), "error serving")
+}
+
+func parseConcurrencyLimits(cluster int, perLabel map[string]string) ([]kubernetes.ConcurrencyLimit, error) {
+	limits := make([]kubernetes.ConcurrencyLimit, 0, len(perLabel)+1)
+	if cluster > 0 {
+		limits = append(limits, kubernetes.ConcurrencyLimit{Max: cluster})
+	}
+	for k, v := range perLabel {
+		max, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot parse maximum concurrent drains for label %s", k)
+		}
+		if max < 1 {
+			return nil, errors.Errorf("maximum concurrent drains for label %s must be at least 1", k)
+		}
+		limits = append(limits, kubernetes.ConcurrencyLimit{LabelKey: k, Max: max})
+	}
+	return limits, nil
+}
+
+func parseNodeScorers(in map[string]string, priorities map[string]kubernetes.DrainPriority) ([]kubernetes.WeightedScorer, error) {
+	out := make([]kubernetes.WeightedScorer, 0, len(in))
+	for name, w := range in {
+		weight, err := strconv.ParseFloat(w, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot parse weight of node scorer %s", name)
+		}
+		scorer, err := kubernetes.NewScorer(name, priorities)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, kubernetes.WeightedScorer{Scorer: scorer, Weight: weight})
+	}
+	return out, nil
+}
+
+func parseConditionPriorities(in map[string]string) (map[string]kubernetes.DrainPriority, error) {
+	out := make(map[string]kubernetes.DrainPriority, len(in))
+	for c, p := range in {
+		priority, err := kubernetes.ParseDrainPriority(p)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot parse priority of condition %s", c)
+		}
+		out[c] = priority
+	}
+	return out, nil
+}
+
+func parseConditionActions(in map[string]string, pipelines map[string]kubernetes.Pipeline) (map[string]kubernetes.Pipeline, error) {
+	out := make(map[string]kubernetes.Pipeline, len(in))
+	for c, name := range in {
+		p, ok := pipelines[name]
+		if !ok {
+			return nil, errors.Errorf("unknown pipeline %q for condition %s", name, c)
+		}
+		out[c] = p
+	}
+	return out, nil
+}
+
+// auditStore returns the AuditStore, and the prefix of the keys within it, of
+// the supplied audit destination URL.
+func auditStore(destination string, sess *session.Session, azureClientID string) (kubernetes.AuditStore, string, error) {
+	u, err := url.Parse(destination)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "cannot parse audit destination %q", destination)
+	}
+	prefix := strings.Trim(u.Path, "/")
+	switch u.Scheme {
+	case "s3":
+		return aws.NewS3AuditStore(s3.New(sess), u.Host), prefix, nil
+	case "gs":
+		return gcp.NewGCSAuditStore(gcp.NewMetadataTokenSource(), u.Host), prefix, nil
+	case "https":
+		parts := strings.SplitN(prefix, "/", 2)
+		if parts[0] == "" {
+			return nil, "", errors.Errorf("audit destination %q must include a container", destination)
+		}
+		container := u.Scheme + "://" + u.Host + "/" + parts[0]
+		tokens := azure.NewManagedIdentityTokenSourceForResource(azureClientID, azure.StorageResource)
+		if len(parts) == 1 {
+			return azure.NewBlobAuditStore(tokens, container), "", nil
+		}
+		return azure.NewBlobAuditStore(tokens, container), parts[1], nil
+	default:
+		return nil, "", errors.Errorf("audit destination %q must be an s3://, gs://, or https:// URL", destination)
+	}
+}
+
+// hookFuncs returns functions that run the supplied actions, each of the form
+// TYPE=ARGUMENT, against a node. Only actions of the supplied types, i.e.
+// webhook, job, annotation, or taint, are allowed. The kind of action, for
+// example post-drain, is used to describe errors. Jobs are labelled using the
+// supplied keys.
+func hookFuncs(c client.Interface, k kubernetes.Keys, kind string, actions []string, timeout time.Duration, types ...string) ([]func(n *core.Node) error, error) {
+	allowed := make(map[string]bool, len(types))
+	for _, t := range types {
+		allowed[t] = true
+	}
+	fns := make([]func(n *core.Node) error, 0, len(actions))
+	for _, a := range actions {
+		parts := strings.SplitN(a, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("%s action %q must be of the form TYPE=ARGUMENT", kind, a)
+		}
+		if !allowed[parts[0]] {
+			return nil, errors.Errorf("unknown %s action type %q", kind, parts[0])
+		}
+		switch parts[0] {
+		case "webhook":
+			hook, err := kubernetes.NewHTTPHook(parts[1], timeout)
+			if err != nil {
+				return nil, err
+			}
+			fns = append(fns, hook.Run)
+		case "job":
+			manifest, err := ioutil.ReadFile(parts[1])
+			if err != nil {
+				return nil, errors.Wrapf(err, "cannot read %s Job %s", kind, parts[1])
+			}
+			hook, err := kubernetes.NewJobHook(c, k, string(manifest), timeout)
+			if err != nil {
+				return nil, err
+			}
+			fns = append(fns, hook.Run)
+		case "annotation":
+			kv := strings.SplitN(parts[1], "=", 2)
+			if len(kv) != 2 {
+				return nil, errors.Errorf("%s annotation %q must be of the form KEY=VALUE", kind, parts[1])
+			}
+			fns = append(fns, kubernetes.NewNodeAnnotationHook(c, kv[0], kv[1]).Run)
+		case "taint":
+			t, err := kubernetes.ParseTaint(parts[1])
+			if err != nil {
+				return nil, err
+			}
+			fns = append(fns, kubernetes.NewNodeTaintHook(c, t).Run)
+		}
+	}
+	return fns, nil
+}
+
+// leaseHolder returns an identity unique to this draino, so that replicas
+// sharing --event-component never both hold a node's Lease.
+func leaseHolder() (string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return "", errors.Wrap(err, "cannot determine hostname")
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", errors.Wrap(err, "cannot generate unique ID")
+	}
+	return host + "_" + hex.EncodeToString(id), nil
+}
+
+func blackoutSources(c client.Interface, windows []string, configMap, timezone string) ([]kubernetes.TimeWindowSource, error) {
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot load timezone %s", timezone)
+	}
+	static := make(kubernetes.StaticTimeWindows, 0, len(windows))
+	for _, spec := range windows {
+		w, err := kubernetes.ParseTimeWindow(spec, loc)
+		if err != nil {
+			return nil, err
+		}
+		static = append(static, w)
+	}
+	sources := []kubernetes.TimeWindowSource{static}
+	if configMap != "" {
+		parts := strings.SplitN(configMap, "/", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("blackout ConfigMap %q must be of the form NAMESPACE/NAME", configMap)
+		}
+		sources = append(sources, kubernetes.NewConfigMapTimeWindows(c, parts[0], parts[1], loc))
+	}
+	return sources, nil
+}
+
+// wasmFilter returns a filter that runs the WebAssembly modules of the supplied
+// NAMESPACE/NAME ConfigMap or oci:// artifact.
+func wasmFilter(log *zap.Logger, c client.Interface, source, runtime string, timeout, poll time.Duration) (*kubernetes.WasmFilter, error) {
+	if strings.HasPrefix(source, kubernetes.OCIWasmPrefix) {
+		s, err := kubernetes.NewOCIWasmModules(source, kubernetes.WithOCIWasmLogger(log), kubernetes.WithOCIWasmPollInterval(poll))
+		if err != nil {
+			return nil, err
+		}
+		return kubernetes.NewWasmFilter(s, runtime, timeout)
+	}
+	parts := strings.SplitN(source, "/", 2)
+	if len(parts) != 2 {
+		return nil, errors.Errorf("WebAssembly filter ConfigMap %q must be of the form NAMESPACE/NAME", source)
+	}
+	return kubernetes.NewWasmFilter(kubernetes.NewConfigMapWasmModules(c, parts[0], parts[1]), runtime, timeout)
+}
+
+// buildConfig returns the client config of the supplied kubeconfig context,
+// failing over between the supplied API servers if there are several.
+func buildConfig(log *zap.Logger, apiservers []string, kubecfg, kubeContext string) (*rest.Config, error) {
+	var apiserver string
+	if len(apiservers) > 0 {
+		apiserver = apiservers[0]
+	}
+	c, err := kubernetes.BuildConfigFromContext(apiserver, kubecfg, kubeContext)
+	if err != nil || len(apiservers) < 2 {
+		return c, err
+	}
+	return c, kubernetes.ConfigureAPIServerFailover(c, apiservers, log)
 }
 
 type runner interface {
 	Run(stop <-chan struct{})
 }
 
+// A failingRunner is a runner that may stop because it failed, for example
+// because it could not listen at its address.
+type failingRunner interface {
+	runner
+
+	// Err returns the error that stopped the runner, if any, once Run has
+	// returned.
+	Err() error
+}
+
 func await(rs ...runner) error {
 	stop := make(chan struct{})
+	var once sync.Once
 	g := &run.Group{}
 	for i := range rs {
 		r := rs[i] // https://golang.org/doc/faq#closures_and_goroutines
-		g.Add(func() error { r.Run(stop); return nil }, func(err error) { close(stop) })
+		g.Add(func() error {
+			r.Run(stop)
+			if f, ok := r.(failingRunner); ok {
+				return f.Err()
+			}
+			return nil
+		}, func(err error) { once.Do(func() { close(stop) }) })
 	}
 	return g.Run()
 }
 
+// A signalRunner runs until draino is asked to terminate, at which point all
+// other runners are stopped.
+type signalRunner struct {
+	l *zap.Logger
+}
+
+func (r *signalRunner) Run(stop <-chan struct{}) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(sig)
+	select {
+	case s := <-sig:
+		r.l.Info("Shutting down", zap.String("signal", s.String()))
+	case <-stop:
+	}
+}
+
+// A clusterStatus describes draino's state for one cluster, as served by
+// /status.
+type clusterStatus struct {
+	Cluster            string                               `json:"cluster,omitempty"`
+	PendingPodsBreaker *kubernetes.PendingPodsBreakerStatus `json:"pendingPodsBreaker,omitempty"`
+	CorrelatedFailure  *kubernetes.StormStatus              `json:"correlatedFailure,omitempty"`
+	DrainCooldowns     []kubernetes.DrainCooldown           `json:"drainCooldowns,omitempty"`
+	ScheduledDrains    []kubernetes.ScheduledDrain          `json:"scheduledDrains,omitempty"`
+	DrainEstimates     []kubernetes.DrainEstimate           `json:"drainEstimates,omitempty"`
+
+	breaker   *kubernetes.PendingPodsBreaker
+	storm     *kubernetes.StormDetector
+	drains    *kubernetes.DrainingResourceEventHandler
+	scheduler *kubernetes.DrainScheduler
+	estimates *kubernetes.EstimatingCordonDrainer
+}
+
 type httpRunner struct {
 	l string
 	h map[string]http.Handler
+
+	// put handles PUT requests, i.e. requests that change draino's state.
+	put map[string]http.Handler
+
+	// tls is used to serve HTTPS when set.
+	tls *tls.Config
+
+	// grace is how long to wait for in-flight requests when shutting down.
+	grace time.Duration
+
+	err error
 }
 
 func (r *httpRunner) Run(stop <-chan struct{}) {
@@ -169,15 +1773,58 @@ func (r *httpRunner) Run(stop <-chan struct{}) {
 	for path, handler := range r.h {
 		rt.Handler("GET", path, handler)
 	}
+	for path, handler := range r.put {
+		rt.Handler("PUT", path, handler)
+	}
 
-	s := &http.Server{Addr: r.l, Handler: rt}
-	ctx, cancel := context.WithTimeout(context.Background(), 0*time.Second)
+	s := &http.Server{Addr: r.l, Handler: rt, TLSConfig: r.tls}
+	shutdown := make(chan struct{})
 	go func() {
+		defer close(shutdown)
 		<-stop
-		s.Shutdown(ctx) // nolint:gosec
+		// In-flight requests, e.g. scrapes, may finish within the grace
+		// period. Connections are closed once it has passed.
+		ctx, cancel := context.WithTimeout(context.Background(), r.grace)
+		defer cancel()
+		if err := s.Shutdown(ctx); err != nil {
+			s.Close() // nolint:gosec
+		}
 	}()
-	s.ListenAndServe() // nolint:gosec
-	cancel()
+	var err error
+	if r.tls != nil {
+		// The certificate and key are supplied by the TLS config.
+		err = s.ListenAndServeTLS("", "")
+	} else {
+		err = s.ListenAndServe()
+	}
+	if err == http.ErrServerClosed {
+		<-shutdown
+		return
+	}
+	r.err = errors.Wrapf(err, "cannot serve HTTP at %s", r.l)
+}
+
+// Err returns the error that stopped the runner serving HTTP, if any.
+func (r *httpRunner) Err() error {
+	return r.err
+}
+
+// pprofHandlers returns handlers that serve runtime profiling data at
+// /debug/pprof, as described by https://golang.org/pkg/net/http/pprof/.
+func pprofHandlers() map[string]http.Handler {
+	return map[string]http.Handler{
+		"/debug/pprof/":             http.HandlerFunc(pprof.Index),
+		"/debug/pprof/cmdline":      http.HandlerFunc(pprof.Cmdline),
+		"/debug/pprof/profile":      http.HandlerFunc(pprof.Profile),
+		"/debug/pprof/symbol":       http.HandlerFunc(pprof.Symbol),
+		"/debug/pprof/trace":        http.HandlerFunc(pprof.Trace),
+		"/debug/pprof/allocs":       pprof.Handler("allocs"),
+		"/debug/pprof/block":        pprof.Handler("block"),
+		"/debug/pprof/goroutine":    pprof.Handler("goroutine"),
+		"/debug/pprof/heap":         pprof.Handler("heap"),
+		"/debug/pprof/mutex":        pprof.Handler("mutex"),
+		"/debug/pprof/threadcreate": pprof.Handler("threadcreate"),
+	}
 }
 
 // Many Kubernetes client things depend on glog. glog gets sad when flag.Parse()
@@ -0,0 +1,65 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// evalMainEnv is set when the test binary is re-executed to run main.
+const evalMainEnv = "DRAINO_TEST_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(evalMainEnv) != "" {
+		os.Args = append(os.Args[:1], strings.Fields(os.Getenv(evalMainEnv))...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestEval(t *testing.T) {
+	cases := []struct {
+		name  string
+		flags []string
+	}{
+		{name: "Defaults"},
+		{name: "CheckRescheduling", flags: []string{"--check-rescheduling"}},
+		{name: "RequiredHeadroom", flags: []string{"--required-headroom-cpu=1", "--required-headroom-memory=1Gi"}},
+		{name: "DeferLastReadyReplica", flags: []string{"--last-ready-replica-policy=defer"}},
+		{name: "SkipLastReadyReplica", flags: []string{"--last-ready-replica-policy=skip"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			args := append([]string{"eval", "--file=testdata/eval.yaml", "--time=2018-06-01T12:00:00Z"}, tc.flags...)
+			args = append(args, "KernelDeadlock,10m")
+			cmd := exec.Command(os.Args[0]) // nolint:gosec
+			cmd.Env = append(os.Environ(), evalMainEnv+"="+strings.Join(args, " "))
+			out, err := cmd.CombinedOutput()
+			if err != nil {
+				t.Fatalf("draino %s: %v\n%s", strings.Join(args, " "), err, out)
+			}
+			if !strings.Contains(string(out), "Node node-a") {
+				t.Errorf("draino %s: want evaluation of node-a, got\n%s", strings.Join(args, " "), out)
+			}
+		})
+	}
+}
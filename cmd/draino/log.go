@@ -0,0 +1,92 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package main
+
+import (
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Subsystems whose log level may be configured independently.
+const (
+	subsystemWatcher   = "watcher"
+	subsystemDrainer   = "drainer"
+	subsystemScheduler = "scheduler"
+)
+
+// levelledCore is a zapcore.Core that logs only entries its level enables.
+type levelledCore struct {
+	zapcore.Core
+	l zapcore.LevelEnabler
+}
+
+func (c levelledCore) Enabled(l zapcore.Level) bool {
+	return c.l.Enabled(l)
+}
+
+func (c levelledCore) With(f []zapcore.Field) zapcore.Core {
+	return levelledCore{Core: c.Core.With(f), l: c.l}
+}
+
+func (c levelledCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.l.Enabled(e.Level) {
+		return ce
+	}
+	return c.Core.Check(e, ce)
+}
+
+// subsystemLoggers returns loggers for each subsystem. Subsystems log at the
+// default level unless a level has been configured for them.
+type subsystemLoggers struct {
+	base   *zap.Logger
+	global zapcore.LevelEnabler
+	levels map[string]zapcore.Level
+}
+
+// newSubsystemLoggers returns loggers built from the supplied base logger,
+// which should log at its most verbose, for example debug, level.
+func newSubsystemLoggers(base *zap.Logger, global zapcore.LevelEnabler, levels map[string]string) (*subsystemLoggers, error) {
+	l := &subsystemLoggers{base: base, global: global, levels: make(map[string]zapcore.Level)}
+	for s, v := range levels {
+		switch s {
+		case subsystemWatcher, subsystemDrainer, subsystemScheduler:
+		default:
+			return nil, errors.Errorf("unknown subsystem %q: must be one of %s, %s, or %s", s, subsystemWatcher, subsystemDrainer, subsystemScheduler)
+		}
+		var lvl zapcore.Level
+		if err := lvl.UnmarshalText([]byte(v)); err != nil {
+			return nil, errors.Wrapf(err, "cannot parse log level of subsystem %s", s)
+		}
+		l.levels[s] = lvl
+	}
+	return l, nil
+}
+
+// Default returns a logger that logs at the default level.
+func (l *subsystemLoggers) Default() *zap.Logger {
+	return l.base.WithOptions(zap.WrapCore(func(c zapcore.Core) zapcore.Core { return levelledCore{Core: c, l: l.global} }))
+}
+
+// For returns a logger for the supplied subsystem.
+func (l *subsystemLoggers) For(subsystem string) *zap.Logger {
+	lvl, ok := l.levels[subsystem]
+	if !ok {
+		return l.Default()
+	}
+	return l.base.WithOptions(zap.WrapCore(func(c zapcore.Core) zapcore.Core { return levelledCore{Core: c, l: lvl} }))
+}
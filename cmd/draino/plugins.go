@@ -0,0 +1,69 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package main
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/planetlabs/draino/internal/kubernetes"
+	"github.com/planetlabs/draino/plugin"
+)
+
+// drainerPlugin is the name of the CordonDrainer strategy that delegates to a
+// plugin.
+const drainerPlugin = "plugin"
+
+// pluginDrainer is the first loaded plugin that implements the drainer
+// extension, if any.
+var pluginDrainer kubernetes.CordonDrainer
+
+func init() {
+	kubernetes.RegisterCordonDrainer(drainerPlugin, func(_ kubernetes.DrainerConfig) (kubernetes.CordonDrainer, error) {
+		if pluginDrainer == nil {
+			return nil, errors.New("no --plugin implements the drainer extension")
+		}
+		return pluginDrainer, nil
+	})
+}
+
+// startPlugins starts the plugins at the supplied paths, in order.
+func startPlugins(log *zap.Logger, paths []string, timeout time.Duration) ([]*plugin.Client, error) {
+	plugins := make([]*plugin.Client, 0, len(paths))
+	for _, path := range paths {
+		p, err := plugin.Start(path, nil, plugin.WithCallTimeout(timeout), plugin.WithLogger(log))
+		if err != nil {
+			for _, started := range plugins {
+				started.Kill()
+			}
+			return nil, err
+		}
+		caps := p.Capabilities()
+		log.Info("Loaded plugin",
+			zap.String("plugin", p.Name()),
+			zap.Bool("pod_filter", caps.PodFilter),
+			zap.Bool("node_filter", caps.NodeFilter),
+			zap.Bool("drainer", caps.Drainer))
+		if caps.Drainer && pluginDrainer == nil {
+			pluginDrainer = p
+		}
+		plugins = append(plugins, p)
+	}
+	return plugins, nil
+}
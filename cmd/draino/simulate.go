@@ -0,0 +1,129 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/alecthomas/kingpin.v2"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	client "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/planetlabs/draino/internal/kubernetes"
+)
+
+// A simulator prints how draino would handle nodes, without cordoning or
+// draining them.
+type simulator struct {
+	w       io.Writer
+	c       client.Interface
+	d       *kubernetes.APICordonDrainer
+	nodes   []string
+	filters []kubernetes.NamedPodFilter
+
+	// pods are watched while simulating, if required by the filters.
+	pods *kubernetes.PodWatch
+
+	// managed returns false if the node is not managed by this draino, for
+	// example because it does not have the required labels.
+	managed   func(o interface{}) bool
+	triggered func(o interface{}) bool
+	reasons   func(n *core.Node) []string
+	keys      kubernetes.Keys
+	buffer    time.Duration
+}
+
+func (s *simulator) Run(stop <-chan struct{}) {
+	if s.pods != nil {
+		go s.pods.Run(stop)
+		if !cache.WaitForCacheSync(stop, s.pods.HasSynced) {
+			kingpin.Fatalf("cannot simulate drain: pods were not cached")
+		}
+	}
+	for i, name := range s.nodes {
+		if i > 0 {
+			fmt.Fprintln(s.w)
+		}
+		kingpin.FatalIfError(s.simulate(name), "cannot simulate drain")
+	}
+}
+
+func (s *simulator) simulate(name string) error {
+	n, err := s.c.CoreV1().Nodes().Get(name, meta.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "cannot get node %s", name)
+	}
+	p, err := s.d.Plan(n, s.filters...)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(s.w, "Node %s\n", n.GetName())
+	switch {
+	case !s.managed(n):
+		fmt.Fprintln(s.w, "  Would not be cordoned: not managed by this draino (check --node-label and sharding)")
+	case !s.triggered(n):
+		fmt.Fprintln(s.w, "  Would not be cordoned: matches no drain conditions or labels")
+	default:
+		reason := strings.Join(s.reasons(n), ",")
+		if reason == "" {
+			reason = "matched"
+		}
+		fmt.Fprintf(s.w, "  Would be cordoned: %s\n", reason)
+	}
+	if n.Spec.Unschedulable {
+		fmt.Fprintln(s.w, "  Already cordoned")
+	}
+
+	fmt.Fprintf(s.w, "\nPods to evict (%d):\n", len(p.Evict))
+	for _, pod := range p.Evict {
+		owner := "no controller"
+		if o := meta.GetControllerOf(&pod); o != nil {
+			owner = o.Kind + " " + o.Name
+		}
+		if p.Orphaned[pod.GetNamespace()+"/"+pod.GetName()] {
+			owner += ", controller no longer exists"
+		}
+		fmt.Fprintf(s.w, "  %s/%s (%s)\n", pod.GetNamespace(), pod.GetName(), owner)
+	}
+	fmt.Fprintf(s.w, "\nPods that would not be evicted (%d):\n", len(p.Skip))
+	for _, sp := range p.Skip {
+		fmt.Fprintf(s.w, "  %s/%s: %s\n", sp.Pod.GetNamespace(), sp.Pod.GetName(), sp.Reason)
+	}
+	fmt.Fprintf(s.w, "\nPod disruption budgets (%d):\n", len(p.Budgets))
+	for _, b := range p.Budgets {
+		warning := ""
+		if int(b.DisruptionsAllowed) < b.Pods {
+			warning = " - evictions will be retried until the budget allows them"
+		}
+		fmt.Fprintf(s.w, "  %s/%s: covers %d pods to evict, allows %d disruptions%s\n", b.Namespace, b.Name, b.Pods, b.DisruptionsAllowed, warning)
+	}
+
+	fmt.Fprintln(s.w)
+	if p.TooManyPods {
+		fmt.Fprintf(s.w, "Drain would be skipped: too many pods to evict, unless the node is annotated %s=true\n", s.keys.ForceDrain)
+		return nil
+	}
+	fmt.Fprintf(s.w, "Drain would start at least %s after the previous drain, and take up to %s (timeout %s)\n", s.buffer, p.Estimate, p.Timeout)
+	return nil
+}
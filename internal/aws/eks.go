@@ -0,0 +1,73 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"strings"
+
+	awssdk "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+)
+
+// LabelEKSNodegroup is the label EKS adds to nodes of managed node groups. Its
+// value is the name of the node group.
+const LabelEKSNodegroup = "eks.amazonaws.com/nodegroup"
+
+// InstanceID returns the ID of the EC2 instance that backs the supplied node,
+// parsed from its provider ID, e.g. aws:///us-west-2a/i-0123456789abcdef0.
+func InstanceID(n *core.Node) (string, error) {
+	id := n.Spec.ProviderID[strings.LastIndex(n.Spec.ProviderID, "/")+1:]
+	if !strings.HasPrefix(n.Spec.ProviderID, "aws://") || !strings.HasPrefix(id, "i-") {
+		return "", errors.Errorf("cannot parse EC2 instance ID from provider ID %q", n.Spec.ProviderID)
+	}
+	return id, nil
+}
+
+// An InstanceTerminator terminates the EC2 instances that back drained nodes
+// of EKS managed node groups. The node group's Auto Scaling group launches a
+// replacement for each terminated instance.
+type InstanceTerminator struct {
+	asg autoscalingiface.AutoScalingAPI
+}
+
+// NewInstanceTerminator returns an InstanceTerminator that terminates
+// instances via the supplied Auto Scaling API.
+func NewInstanceTerminator(a autoscalingiface.AutoScalingAPI) *InstanceTerminator {
+	return &InstanceTerminator{asg: a}
+}
+
+// Terminate the instance that backs the supplied node. Nodes that are not part
+// of an EKS managed node group are ignored. Terminate may be used as a
+// kubernetes.PostDrainFunc.
+func (t *InstanceTerminator) Terminate(n *core.Node) error {
+	if _, ok := n.GetLabels()[LabelEKSNodegroup]; !ok {
+		return nil
+	}
+	id, err := InstanceID(n)
+	if err != nil {
+		return err
+	}
+	_, err = t.asg.TerminateInstanceInAutoScalingGroupWithContext(context.Background(), &autoscaling.TerminateInstanceInAutoScalingGroupInput{
+		InstanceId:                     awssdk.String(id),
+		ShouldDecrementDesiredCapacity: awssdk.Bool(false),
+	})
+	return errors.Wrapf(err, "cannot terminate instance %s", id)
+}
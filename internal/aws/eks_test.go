@@ -0,0 +1,112 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package aws
+
+import (
+	"testing"
+
+	awssdk "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/go-test/deep"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type terminatingAutoScaling struct {
+	autoscalingiface.AutoScalingAPI
+	terminated []string
+}
+
+func (f *terminatingAutoScaling) TerminateInstanceInAutoScalingGroupWithContext(_ awssdk.Context, in *autoscaling.TerminateInstanceInAutoScalingGroupInput, _ ...request.Option) (*autoscaling.TerminateInstanceInAutoScalingGroupOutput, error) {
+	f.terminated = append(f.terminated, awssdk.StringValue(in.InstanceId))
+	return &autoscaling.TerminateInstanceInAutoScalingGroupOutput{}, nil
+}
+
+func TestInstanceID(t *testing.T) {
+	cases := []struct {
+		name       string
+		providerID string
+		want       string
+		wantErr    bool
+	}{
+		{
+			name:       "EC2Instance",
+			providerID: "aws:///us-west-2a/" + instanceID,
+			want:       instanceID,
+		},
+		{
+			name:       "NotAWS",
+			providerID: "gce://coolProject/us-central1-a/coolNode",
+			wantErr:    true,
+		},
+		{
+			name:       "NoProviderID",
+			providerID: "",
+			wantErr:    true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := InstanceID(&core.Node{Spec: core.NodeSpec{ProviderID: tc.providerID}})
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("InstanceID(%q): want error %v, got %v", tc.providerID, tc.wantErr, err)
+			}
+			if got != tc.want {
+				t.Errorf("InstanceID(%q): want %v, got %v", tc.providerID, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestInstanceTerminator(t *testing.T) {
+	cases := []struct {
+		name string
+		node *core.Node
+		want []string
+	}{
+		{
+			name: "ManagedNodegroup",
+			node: &core.Node{
+				ObjectMeta: meta.ObjectMeta{Name: nodeName, Labels: map[string]string{LabelEKSNodegroup: "cool"}},
+				Spec:       core.NodeSpec{ProviderID: "aws:///us-west-2a/" + instanceID},
+			},
+			want: []string{instanceID},
+		},
+		{
+			name: "UnmanagedNode",
+			node: &core.Node{
+				ObjectMeta: meta.ObjectMeta{Name: nodeName},
+				Spec:       core.NodeSpec{ProviderID: "aws:///us-west-2a/" + instanceID},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			a := &terminatingAutoScaling{}
+			if err := NewInstanceTerminator(a).Terminate(tc.node); err != nil {
+				t.Fatalf("Terminate(%v): %v", tc.node.GetName(), err)
+			}
+			if diff := deep.Equal(tc.want, a.terminated); diff != nil {
+				t.Errorf("terminated instances: want != got: %v", diff)
+			}
+		})
+	}
+}
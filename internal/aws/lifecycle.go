@@ -0,0 +1,326 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+// Package aws integrates draino with Amazon Web Services.
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	awssdk "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	core "k8s.io/api/core/v1"
+
+	"github.com/planetlabs/draino/internal/kubernetes"
+)
+
+// DefaultLifecycleHeartbeatInterval is the default time between heartbeats of
+// lifecycle actions that are waiting for a drain to complete.
+const DefaultLifecycleHeartbeatInterval = 1 * time.Minute
+
+const (
+	transitionTerminating   = "autoscaling:EC2_INSTANCE_TERMINATING"
+	lifecycleResultContinue = "CONTINUE"
+	snsTypeNotification     = "Notification"
+
+	receiveWaitSeconds = 20
+	receiveMaxMessages = 10
+	receiveRetryDelay  = 10 * time.Second
+)
+
+// A LifecycleNotification is an Auto Scaling lifecycle hook notification.
+type LifecycleNotification struct {
+	AutoScalingGroupName string `json:"AutoScalingGroupName"`
+	LifecycleHookName    string `json:"LifecycleHookName"`
+	LifecycleActionToken string `json:"LifecycleActionToken"`
+	LifecycleTransition  string `json:"LifecycleTransition"`
+	EC2InstanceID        string `json:"EC2InstanceId"`
+}
+
+// snsEnvelope wraps notifications delivered to SQS via SNS.
+type snsEnvelope struct {
+	Type    string `json:"Type"`
+	Message string `json:"Message"`
+}
+
+// ParseLifecycleNotification parses an SQS message body as a lifecycle hook
+// notification, whether it was delivered directly or via SNS.
+func ParseLifecycleNotification(body string) (*LifecycleNotification, error) {
+	e := &snsEnvelope{}
+	if err := json.Unmarshal([]byte(body), e); err != nil {
+		return nil, errors.Wrap(err, "cannot decode message")
+	}
+	if e.Type == snsTypeNotification {
+		body = e.Message
+	}
+	n := &LifecycleNotification{}
+	if err := json.Unmarshal([]byte(body), n); err != nil {
+		return nil, errors.Wrap(err, "cannot decode lifecycle notification")
+	}
+	return n, nil
+}
+
+// A LifecycleHookConsumer consumes Auto Scaling lifecycle hook notifications
+// from an SQS queue. When an instance that backs a node is terminating it
+// requests that the node be cordoned and drained, then completes the
+// lifecycle action so that termination may proceed. It records lifecycle
+// action heartbeats while the drain is in progress to prevent the lifecycle
+// hook from timing out, and deletes each notification only once its lifecycle
+// action is complete so that it is redelivered if draino stops mid-drain.
+type LifecycleHookConsumer struct {
+	l         *zap.Logger
+	sqs       sqsiface.SQSAPI
+	asg       autoscalingiface.AutoScalingAPI
+	queue     string
+	nodes     kubernetes.NodeStore
+	r         kubernetes.DrainRequester
+	heartbeat time.Duration
+	dryRun    bool
+}
+
+// LifecycleHookConsumerOption configures a LifecycleHookConsumer.
+type LifecycleHookConsumerOption func(c *LifecycleHookConsumer)
+
+// WithLogger configures a LifecycleHookConsumer to use the supplied logger.
+func WithLogger(l *zap.Logger) LifecycleHookConsumerOption {
+	return func(c *LifecycleHookConsumer) {
+		c.l = l
+	}
+}
+
+// WithHeartbeatInterval configures the time between lifecycle action
+// heartbeats.
+func WithHeartbeatInterval(i time.Duration) LifecycleHookConsumerOption {
+	return func(c *LifecycleHookConsumer) {
+		c.heartbeat = i
+	}
+}
+
+// WithDryRun configures a LifecycleHookConsumer to neither record heartbeats
+// nor complete lifecycle actions, so that the lifecycle hook's default result
+// applies once its heartbeat timeout passes.
+func WithDryRun() LifecycleHookConsumerOption {
+	return func(c *LifecycleHookConsumer) {
+		c.dryRun = true
+	}
+}
+
+// NewLifecycleHookConsumer returns a LifecycleHookConsumer that consumes
+// notifications from the supplied SQS queue URL, and uses the supplied
+// DrainRequester to drain nodes.
+func NewLifecycleHookConsumer(s sqsiface.SQSAPI, a autoscalingiface.AutoScalingAPI, queue string, nodes kubernetes.NodeStore, r kubernetes.DrainRequester, co ...LifecycleHookConsumerOption) *LifecycleHookConsumer {
+	c := &LifecycleHookConsumer{
+		l:         zap.NewNop(),
+		sqs:       s,
+		asg:       a,
+		queue:     queue,
+		nodes:     nodes,
+		r:         r,
+		heartbeat: DefaultLifecycleHeartbeatInterval,
+	}
+	for _, o := range co {
+		o(c)
+	}
+	return c
+}
+
+// Run the consumer until the supplied channel is closed.
+func (c *LifecycleHookConsumer) Run(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	for {
+		rsp, err := c.sqs.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            awssdk.String(c.queue),
+			MaxNumberOfMessages: awssdk.Int64(receiveMaxMessages),
+			WaitTimeSeconds:     awssdk.Int64(receiveWaitSeconds),
+		})
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		if err != nil {
+			c.l.Info("Failed to receive lifecycle notifications", zap.Error(err))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(receiveRetryDelay):
+			}
+			continue
+		}
+		for _, m := range rsp.Messages {
+			c.handle(ctx, m)
+		}
+	}
+}
+
+func (c *LifecycleHookConsumer) handle(ctx context.Context, m *sqs.Message) {
+	n, err := ParseLifecycleNotification(awssdk.StringValue(m.Body))
+	if err != nil {
+		c.l.Info("Discarding malformed lifecycle notification", zap.Error(err))
+		c.delete(ctx, m)
+		return
+	}
+	if n.LifecycleTransition != transitionTerminating {
+		// Includes test notifications, which have no transition.
+		c.delete(ctx, m)
+		return
+	}
+
+	log := c.l.With(zap.String("instance", n.EC2InstanceID), zap.String("asg", n.AutoScalingGroupName))
+
+	node := c.node(n.EC2InstanceID)
+	if node == nil {
+		log.Debug("Instance is not a known node")
+		c.complete(ctx, log, n)
+		c.delete(ctx, m)
+		return
+	}
+	log = log.With(zap.String("node", node.GetName()))
+	go c.drain(log, m, n, node)
+}
+
+// drain the supplied node. Drains are not interrupted when the consumer stops,
+// so their lifecycle actions are not tied to the consumer's context.
+func (c *LifecycleHookConsumer) drain(log *zap.Logger, m *sqs.Message, n *LifecycleNotification, node *core.Node) {
+	ctx := context.Background()
+	done := make(chan struct{})
+	go func() {
+		// The notification must stay invisible for as long as the drain
+		// is in progress, lest it be redelivered and handled again.
+		c.extend(ctx, log, m)
+		t := time.NewTicker(c.heartbeat)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				c.recordHeartbeat(ctx, log, n)
+				c.extend(ctx, log, m)
+			}
+		}
+	}()
+
+	log.Info("Requesting drain of terminating instance")
+	result := make(chan error, 1)
+	for {
+		// Drains may be deferred, for example by concurrency limits. Keep
+		// asking until the drain is scheduled or the node is gone.
+		err := c.r.Request(node, time.Time{}, func(err error) { result <- err })
+		if err == nil {
+			break
+		}
+		if _, gerr := c.nodes.Get(node.GetName()); gerr != nil {
+			result <- err
+			break
+		}
+		log.Info("Cannot drain terminating instance yet", zap.Error(err))
+		time.Sleep(c.heartbeat)
+	}
+	if err := <-result; err != nil {
+		log.Info("Failed to drain terminating instance", zap.Error(err))
+	} else {
+		log.Info("Drained terminating instance")
+	}
+	close(done)
+
+	// The instance is terminating regardless of whether the drain
+	// succeeded; delaying termination further would not help.
+	c.complete(ctx, log, n)
+	c.delete(ctx, m)
+}
+
+func (c *LifecycleHookConsumer) node(instanceID string) *core.Node {
+	for _, n := range c.nodes.List() {
+		// AWS provider IDs are of the form aws:///us-west-2a/i-0123456789.
+		if strings.HasSuffix(n.Spec.ProviderID, "/"+instanceID) {
+			return n
+		}
+	}
+	return nil
+}
+
+func (c *LifecycleHookConsumer) recordHeartbeat(ctx context.Context, log *zap.Logger, n *LifecycleNotification) {
+	if c.dryRun {
+		return
+	}
+	_, err := c.asg.RecordLifecycleActionHeartbeatWithContext(ctx, &autoscaling.RecordLifecycleActionHeartbeatInput{
+		AutoScalingGroupName: awssdk.String(n.AutoScalingGroupName),
+		LifecycleHookName:    awssdk.String(n.LifecycleHookName),
+		LifecycleActionToken: awssdk.String(n.LifecycleActionToken),
+		InstanceId:           awssdk.String(n.EC2InstanceID),
+	})
+	if err != nil {
+		log.Info("Failed to record lifecycle action heartbeat", zap.Error(err))
+	}
+}
+
+func (c *LifecycleHookConsumer) complete(ctx context.Context, log *zap.Logger, n *LifecycleNotification) {
+	if c.dryRun {
+		log.Info("Not completing lifecycle action in dry-run mode")
+		return
+	}
+	_, err := c.asg.CompleteLifecycleActionWithContext(ctx, &autoscaling.CompleteLifecycleActionInput{
+		AutoScalingGroupName:  awssdk.String(n.AutoScalingGroupName),
+		LifecycleHookName:     awssdk.String(n.LifecycleHookName),
+		LifecycleActionToken:  awssdk.String(n.LifecycleActionToken),
+		InstanceId:            awssdk.String(n.EC2InstanceID),
+		LifecycleActionResult: awssdk.String(lifecycleResultContinue),
+	})
+	if err != nil {
+		log.Info("Failed to complete lifecycle action", zap.Error(err))
+	}
+}
+
+// extend the visibility timeout of the supplied message until after the next
+// heartbeat.
+func (c *LifecycleHookConsumer) extend(ctx context.Context, log *zap.Logger, m *sqs.Message) {
+	visibility := int64(2 * c.heartbeat / time.Second)
+	if visibility < 1 {
+		visibility = 1
+	}
+	_, err := c.sqs.ChangeMessageVisibilityWithContext(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          awssdk.String(c.queue),
+		ReceiptHandle:     m.ReceiptHandle,
+		VisibilityTimeout: awssdk.Int64(visibility),
+	})
+	if err != nil {
+		log.Info("Failed to extend lifecycle notification visibility timeout", zap.Error(err))
+	}
+}
+
+func (c *LifecycleHookConsumer) delete(ctx context.Context, m *sqs.Message) {
+	_, err := c.sqs.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      awssdk.String(c.queue),
+		ReceiptHandle: m.ReceiptHandle,
+	})
+	if err != nil {
+		c.l.Info("Failed to delete lifecycle notification", zap.Error(err))
+	}
+}
@@ -0,0 +1,308 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	awssdk "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/go-test/deep"
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	instanceID = "i-0123456789"
+	nodeName   = "coolNode"
+
+	terminatingNotification = `{"AutoScalingGroupName":"coolASG","LifecycleHookName":"drain","LifecycleActionToken":"token","LifecycleTransition":"autoscaling:EC2_INSTANCE_TERMINATING","EC2InstanceId":"i-0123456789"}`
+)
+
+type staticNodeStore []*core.Node
+
+func (s staticNodeStore) Get(name string) (*core.Node, error) {
+	for _, n := range s {
+		if n.GetName() == name {
+			return n, nil
+		}
+	}
+	return nil, errors.Errorf("node %s does not exist", name)
+}
+
+func (s staticNodeStore) List() []*core.Node {
+	return s
+}
+
+type recordingRequester struct {
+	mu      sync.Mutex
+	drained []string
+	release chan struct{}
+}
+
+func (r *recordingRequester) Request(n *core.Node, _ time.Time, done func(err error)) error {
+	go func() {
+		if r.release != nil {
+			<-r.release
+		}
+		r.mu.Lock()
+		r.drained = append(r.drained, n.GetName())
+		r.mu.Unlock()
+		done(nil)
+	}()
+	return nil
+}
+
+type fakeSQS struct {
+	sqsiface.SQSAPI
+	deleted chan string
+}
+
+func (f *fakeSQS) ChangeMessageVisibilityWithContext(_ awssdk.Context, _ *sqs.ChangeMessageVisibilityInput, _ ...request.Option) (*sqs.ChangeMessageVisibilityOutput, error) {
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+func (f *fakeSQS) DeleteMessageWithContext(_ awssdk.Context, in *sqs.DeleteMessageInput, _ ...request.Option) (*sqs.DeleteMessageOutput, error) {
+	f.deleted <- awssdk.StringValue(in.ReceiptHandle)
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+type fakeAutoScaling struct {
+	autoscalingiface.AutoScalingAPI
+	heartbeats chan string
+	completed  chan string
+}
+
+func (f *fakeAutoScaling) RecordLifecycleActionHeartbeatWithContext(_ awssdk.Context, in *autoscaling.RecordLifecycleActionHeartbeatInput, _ ...request.Option) (*autoscaling.RecordLifecycleActionHeartbeatOutput, error) {
+	select {
+	case f.heartbeats <- awssdk.StringValue(in.InstanceId):
+	default:
+	}
+	return &autoscaling.RecordLifecycleActionHeartbeatOutput{}, nil
+}
+
+func (f *fakeAutoScaling) CompleteLifecycleActionWithContext(_ awssdk.Context, in *autoscaling.CompleteLifecycleActionInput, _ ...request.Option) (*autoscaling.CompleteLifecycleActionOutput, error) {
+	f.completed <- awssdk.StringValue(in.InstanceId) + "=" + awssdk.StringValue(in.LifecycleActionResult)
+	return &autoscaling.CompleteLifecycleActionOutput{}, nil
+}
+
+func TestParseLifecycleNotification(t *testing.T) {
+	want := &LifecycleNotification{
+		AutoScalingGroupName: "coolASG",
+		LifecycleHookName:    "drain",
+		LifecycleActionToken: "token",
+		LifecycleTransition:  transitionTerminating,
+		EC2InstanceID:        instanceID,
+	}
+	cases := []struct {
+		name    string
+		body    string
+		want    *LifecycleNotification
+		wantErr bool
+	}{
+		{
+			name: "Direct",
+			body: terminatingNotification,
+			want: want,
+		},
+		{
+			name: "ViaSNS",
+			body: `{"Type":"Notification","Message":"{\"AutoScalingGroupName\":\"coolASG\",\"LifecycleHookName\":\"drain\",\"LifecycleActionToken\":\"token\",\"LifecycleTransition\":\"autoscaling:EC2_INSTANCE_TERMINATING\",\"EC2InstanceId\":\"i-0123456789\"}"}`,
+			want: want,
+		},
+		{
+			name: "TestNotification",
+			body: `{"AutoScalingGroupName":"coolASG","Event":"autoscaling:TEST_NOTIFICATION"}`,
+			want: &LifecycleNotification{AutoScalingGroupName: "coolASG"},
+		},
+		{
+			name:    "NotJSON",
+			body:    "nope",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseLifecycleNotification(tc.body)
+			if err != nil {
+				if tc.wantErr {
+					return
+				}
+				t.Fatalf("ParseLifecycleNotification(%q): %v", tc.body, err)
+			}
+			if tc.wantErr {
+				t.Fatalf("ParseLifecycleNotification(%q): want error", tc.body)
+			}
+			if diff := deep.Equal(tc.want, got); diff != nil {
+				t.Errorf("ParseLifecycleNotification(%q): want != got: %v", tc.body, diff)
+			}
+		})
+	}
+}
+
+func TestLifecycleHookConsumerHandle(t *testing.T) {
+	node := &core.Node{
+		ObjectMeta: meta.ObjectMeta{Name: nodeName},
+		Spec:       core.NodeSpec{ProviderID: "aws:///us-west-2a/" + instanceID},
+	}
+
+	cases := []struct {
+		name          string
+		body          string
+		nodes         staticNodeStore
+		wantDrained   []string
+		wantCompleted string
+	}{
+		{
+			name:          "TerminatingNode",
+			body:          terminatingNotification,
+			nodes:         staticNodeStore{node},
+			wantDrained:   []string{nodeName},
+			wantCompleted: instanceID + "=" + lifecycleResultContinue,
+		},
+		{
+			name:          "TerminatingUnknownInstance",
+			body:          terminatingNotification,
+			nodes:         staticNodeStore{},
+			wantCompleted: instanceID + "=" + lifecycleResultContinue,
+		},
+		{
+			name:  "TestNotification",
+			body:  `{"AutoScalingGroupName":"coolASG","Event":"autoscaling:TEST_NOTIFICATION"}`,
+			nodes: staticNodeStore{node},
+		},
+		{
+			name:  "Malformed",
+			body:  "nope",
+			nodes: staticNodeStore{node},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &fakeSQS{deleted: make(chan string, 1)}
+			a := &fakeAutoScaling{heartbeats: make(chan string, 1), completed: make(chan string, 1)}
+			d := &recordingRequester{}
+			c := NewLifecycleHookConsumer(s, a, "queue", tc.nodes, d)
+
+			c.handle(context.Background(), &sqs.Message{Body: awssdk.String(tc.body), ReceiptHandle: awssdk.String("receipt")})
+
+			if tc.wantCompleted != "" {
+				select {
+				case got := <-a.completed:
+					if got != tc.wantCompleted {
+						t.Errorf("completed lifecycle action: want %v, got %v", tc.wantCompleted, got)
+					}
+				case <-time.After(1 * time.Second):
+					t.Fatalf("lifecycle action was not completed")
+				}
+			}
+			select {
+			case got := <-s.deleted:
+				if got != "receipt" {
+					t.Errorf("deleted message: want receipt, got %v", got)
+				}
+			case <-time.After(1 * time.Second):
+				t.Fatalf("message was not deleted")
+			}
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			if diff := deep.Equal(tc.wantDrained, d.drained); diff != nil {
+				t.Errorf("drained nodes: want != got: %v", diff)
+			}
+		})
+	}
+}
+
+func TestLifecycleHookConsumerHeartbeat(t *testing.T) {
+	node := &core.Node{
+		ObjectMeta: meta.ObjectMeta{Name: nodeName},
+		Spec:       core.NodeSpec{ProviderID: "aws:///us-west-2a/" + instanceID},
+	}
+	s := &fakeSQS{deleted: make(chan string, 1)}
+	a := &fakeAutoScaling{heartbeats: make(chan string, 1), completed: make(chan string, 1)}
+	d := &recordingRequester{release: make(chan struct{})}
+	c := NewLifecycleHookConsumer(s, a, "queue", staticNodeStore{node}, d, WithHeartbeatInterval(10*time.Millisecond))
+
+	c.handle(context.Background(), &sqs.Message{Body: awssdk.String(terminatingNotification), ReceiptHandle: awssdk.String("receipt")})
+
+	select {
+	case got := <-a.heartbeats:
+		if got != instanceID {
+			t.Errorf("heartbeat: want %v, got %v", instanceID, got)
+		}
+	case <-time.After(1 * time.Second):
+		t.Errorf("no heartbeat recorded while draining")
+	}
+	select {
+	case got := <-a.completed:
+		t.Errorf("lifecycle action %v completed before drain finished", got)
+	case got := <-s.deleted:
+		t.Errorf("message %v deleted before drain finished", got)
+	default:
+	}
+
+	close(d.release)
+	select {
+	case <-a.completed:
+	case <-time.After(1 * time.Second):
+		t.Errorf("lifecycle action was not completed after drain finished")
+	}
+	select {
+	case <-s.deleted:
+	case <-time.After(1 * time.Second):
+		t.Errorf("message was not deleted after drain finished")
+	}
+}
+
+func TestLifecycleHookConsumerDryRun(t *testing.T) {
+	node := &core.Node{
+		ObjectMeta: meta.ObjectMeta{Name: nodeName},
+		Spec:       core.NodeSpec{ProviderID: "aws:///us-west-2a/" + instanceID},
+	}
+	s := &fakeSQS{deleted: make(chan string, 1)}
+	a := &fakeAutoScaling{heartbeats: make(chan string, 1), completed: make(chan string, 1)}
+	d := &recordingRequester{}
+	c := NewLifecycleHookConsumer(s, a, "queue", staticNodeStore{node}, d, WithDryRun())
+
+	c.handle(context.Background(), &sqs.Message{Body: awssdk.String(terminatingNotification), ReceiptHandle: awssdk.String("receipt")})
+
+	select {
+	case <-s.deleted:
+	case <-time.After(1 * time.Second):
+		t.Fatalf("message was not deleted after drain finished")
+	}
+	select {
+	case got := <-a.completed:
+		t.Errorf("lifecycle action %v completed in dry-run mode", got)
+	default:
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if diff := deep.Equal([]string{nodeName}, d.drained); diff != nil {
+		t.Errorf("drained nodes: want != got: %v", diff)
+	}
+}
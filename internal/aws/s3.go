@@ -0,0 +1,51 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package aws
+
+import (
+	"bytes"
+	"context"
+
+	awssdk "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/pkg/errors"
+)
+
+// An S3AuditStore stores audit records as objects in an S3 bucket. It
+// satisfies kubernetes.AuditStore.
+type S3AuditStore struct {
+	s3     s3iface.S3API
+	bucket string
+}
+
+// NewS3AuditStore returns an S3AuditStore that stores objects in the supplied
+// bucket via the supplied S3 API.
+func NewS3AuditStore(s s3iface.S3API, bucket string) *S3AuditStore {
+	return &S3AuditStore{s3: s, bucket: bucket}
+}
+
+// Put stores the supplied data as the object with the supplied key.
+func (s *S3AuditStore) Put(key string, data []byte) error {
+	_, err := s.s3.PutObjectWithContext(context.Background(), &s3.PutObjectInput{
+		Bucket:      awssdk.String(s.bucket),
+		Key:         awssdk.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: awssdk.String("application/x-ndjson"),
+	})
+	return errors.Wrapf(err, "cannot put object s3://%s/%s", s.bucket, key)
+}
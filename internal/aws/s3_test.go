@@ -0,0 +1,53 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package aws
+
+import (
+	"io/ioutil"
+	"testing"
+
+	awssdk "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+type fakeS3 struct {
+	s3iface.S3API
+	bucket string
+	key    string
+	body   string
+}
+
+func (f *fakeS3) PutObjectWithContext(_ awssdk.Context, in *s3.PutObjectInput, _ ...request.Option) (*s3.PutObjectOutput, error) {
+	b, err := ioutil.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.bucket, f.key, f.body = awssdk.StringValue(in.Bucket), awssdk.StringValue(in.Key), string(b)
+	return &s3.PutObjectOutput{}, nil
+}
+
+func TestS3AuditStore(t *testing.T) {
+	f := &fakeS3{}
+	if err := NewS3AuditStore(f, "audit").Put("2018/01/01/a.jsonl", []byte("{}\n")); err != nil {
+		t.Fatalf("Put(): %v", err)
+	}
+	if f.bucket != "audit" || f.key != "2018/01/01/a.jsonl" || f.body != "{}\n" {
+		t.Errorf("Put(): got bucket %q, key %q, body %q", f.bucket, f.key, f.body)
+	}
+}
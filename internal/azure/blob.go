@@ -0,0 +1,69 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package azure
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// StorageResource is the resource for which to request Azure Storage access
+// tokens.
+const StorageResource = "https://storage.azure.com/"
+
+// blobAPIVersion is the oldest Blob service version that supports OAuth.
+const blobAPIVersion = "2017-11-09"
+
+// A BlobAuditStore stores audit records as block blobs in an Azure Storage
+// container. It satisfies kubernetes.AuditStore.
+type BlobAuditStore struct {
+	c         *http.Client
+	container string
+	tokens    TokenSource
+}
+
+// NewBlobAuditStore returns a BlobAuditStore that stores blobs in the supplied
+// container, e.g. https://myaccount.blob.core.windows.net/mycontainer,
+// authenticated by the supplied TokenSource.
+func NewBlobAuditStore(t TokenSource, container string) *BlobAuditStore {
+	return &BlobAuditStore{c: &http.Client{Timeout: requestTimeout}, container: strings.TrimSuffix(container, "/"), tokens: t}
+}
+
+// Put stores the supplied data as the blob with the supplied name.
+func (s *BlobAuditStore) Put(name string, data []byte) error {
+	token, err := s.tokens.Token()
+	if err != nil {
+		return errors.Wrap(err, "cannot get access token")
+	}
+	req, err := http.NewRequest(http.MethodPut, s.container+"/"+name, bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrap(err, "cannot create request")
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("x-ms-version", blobAPIVersion)
+	req.Header.Set("x-ms-blob-type", "BlockBlob")
+	rsp, err := s.c.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "cannot put blob %s", name)
+	}
+	defer rsp.Body.Close()
+	return errors.Wrapf(checkResponse(rsp), "cannot put blob %s", name)
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package azure
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBlobAuditStore(t *testing.T) {
+	cases := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{name: "Succeeded", status: http.StatusCreated},
+		{name: "Failed", status: http.StatusForbidden, wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var path, blobType, body string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPut || r.Header.Get("Authorization") != "Bearer token" {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				b, _ := ioutil.ReadAll(r.Body)
+				path, blobType, body = r.URL.Path, r.Header.Get("x-ms-blob-type"), string(b)
+				w.WriteHeader(tc.status)
+			}))
+			defer srv.Close()
+
+			err := NewBlobAuditStore(staticTokenSource("token"), srv.URL+"/audit/").Put("2018/01/01/a.jsonl", []byte("{}\n"))
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Put(): want error %v, got %v", tc.wantErr, err)
+			}
+			if path != "/audit/2018/01/01/a.jsonl" || blobType != "BlockBlob" || body != "{}\n" {
+				t.Errorf("Put(): got path %q, blob type %q, body %q", path, blobType, body)
+			}
+		})
+	}
+}
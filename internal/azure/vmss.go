@@ -0,0 +1,272 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+// Package azure integrates draino with Microsoft Azure.
+package azure
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+
+	"github.com/planetlabs/draino/internal/kubernetes"
+)
+
+// DefaultEndpoint is the Azure Resource Manager endpoint of the public cloud.
+const DefaultEndpoint = "https://management.azure.com"
+
+// Post-drain actions.
+const (
+	// ActionDelete deletes the scale set instance backing a node.
+	ActionDelete = "delete"
+
+	// ActionReimage reimages the scale set instance backing a node.
+	ActionReimage = "reimage"
+)
+
+const (
+	computeAPIVersion = "2023-09-01"
+
+	imdsTokenURL    = "http://169.254.169.254/metadata/identity/oauth2/token"
+	imdsAPIVersion  = "2018-02-01"
+	tokenResource   = "https://management.azure.com/"
+	tokenExpiryLead = 5 * time.Minute
+
+	requestTimeout = 30 * time.Second
+	maxErrorBody   = 1024
+)
+
+// A ScaleSetInstance is a Virtual Machine Scale Set instance.
+type ScaleSetInstance struct {
+	SubscriptionID string
+	ResourceGroup  string
+	ScaleSet       string
+	InstanceID     string
+}
+
+func (i *ScaleSetInstance) path() string {
+	return "/subscriptions/" + url.PathEscape(i.SubscriptionID) +
+		"/resourceGroups/" + url.PathEscape(i.ResourceGroup) +
+		"/providers/Microsoft.Compute/virtualMachineScaleSets/" + url.PathEscape(i.ScaleSet) +
+		"/virtualMachines/" + url.PathEscape(i.InstanceID)
+}
+
+// ParseProviderID parses the provider ID of a node backed by a scale set
+// instance, e.g. azure:///subscriptions/SUB/resourceGroups/RG/providers/
+// Microsoft.Compute/virtualMachineScaleSets/VMSS/virtualMachines/ID.
+func ParseProviderID(id string) (*ScaleSetInstance, error) {
+	p := strings.TrimPrefix(id, "azure://")
+	if p == id {
+		return nil, errors.Errorf("provider ID %q is not an Azure provider ID", id)
+	}
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	want := map[int]string{0: "subscriptions", 2: "resourceGroups", 4: "providers", 5: "Microsoft.Compute", 6: "virtualMachineScaleSets", 8: "virtualMachines"}
+	if len(parts) != 10 {
+		return nil, errors.Errorf("provider ID %q is not a scale set instance", id)
+	}
+	for i, w := range want {
+		if !strings.EqualFold(parts[i], w) {
+			return nil, errors.Errorf("provider ID %q is not a scale set instance", id)
+		}
+	}
+	return &ScaleSetInstance{SubscriptionID: parts[1], ResourceGroup: parts[3], ScaleSet: parts[7], InstanceID: parts[9]}, nil
+}
+
+// ScaleSetGroup returns the resource group and name of the scale set that
+// backs the supplied node, and false if the node is not backed by a scale set
+// instance. ScaleSetGroup may be used as a kubernetes.NodeGroupFunc.
+func ScaleSetGroup(n *core.Node) (string, bool) {
+	i, err := ParseProviderID(n.Spec.ProviderID)
+	if err != nil {
+		return "", false
+	}
+	// Azure resource names are case insensitive.
+	return strings.ToLower(i.ResourceGroup + "/" + i.ScaleSet), true
+}
+
+// A TokenSource supplies Azure Resource Manager access tokens.
+type TokenSource interface {
+	Token() (string, error)
+}
+
+// A ManagedIdentityTokenSource supplies access tokens for the managed identity
+// of the VM on which it runs, via the Azure Instance Metadata Service.
+type ManagedIdentityTokenSource struct {
+	c        *http.Client
+	url      string
+	clientID string
+	resource string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// NewManagedIdentityTokenSource returns a TokenSource for the VM's managed
+// identity. The client ID selects a user assigned identity; leave it empty
+// to use the system assigned identity.
+func NewManagedIdentityTokenSource(clientID string) *ManagedIdentityTokenSource {
+	return NewManagedIdentityTokenSourceForResource(clientID, tokenResource)
+}
+
+// NewManagedIdentityTokenSourceForResource returns a TokenSource for the VM's
+// managed identity that supplies access tokens for the supplied resource,
+// e.g. StorageResource, rather than for Azure Resource Manager.
+func NewManagedIdentityTokenSourceForResource(clientID, resource string) *ManagedIdentityTokenSource {
+	return &ManagedIdentityTokenSource{c: &http.Client{Timeout: requestTimeout}, url: imdsTokenURL, clientID: clientID, resource: resource}
+}
+
+type imdsToken struct {
+	AccessToken string `json:"access_token"`
+	ExpiresOn   string `json:"expires_on"`
+}
+
+// Token returns an access token, reusing a cached token until shortly before
+// it expires.
+func (s *ManagedIdentityTokenSource) Token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Now().Before(s.expires.Add(-tokenExpiryLead)) {
+		return s.token, nil
+	}
+
+	q := url.Values{"api-version": {imdsAPIVersion}, "resource": {s.resource}}
+	if s.clientID != "" {
+		q.Set("client_id", s.clientID)
+	}
+	req, err := http.NewRequest(http.MethodGet, s.url+"?"+q.Encode(), nil)
+	if err != nil {
+		return "", errors.Wrap(err, "cannot create token request")
+	}
+	req.Header.Set("Metadata", "true")
+	rsp, err := s.c.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "cannot request token")
+	}
+	defer rsp.Body.Close()
+	if err := checkResponse(rsp); err != nil {
+		return "", errors.Wrap(err, "cannot request token")
+	}
+	t := &imdsToken{}
+	if err := json.NewDecoder(rsp.Body).Decode(t); err != nil {
+		return "", errors.Wrap(err, "cannot decode token")
+	}
+	expires, err := strconv.ParseInt(t.ExpiresOn, 10, 64)
+	if err != nil {
+		return "", errors.Wrapf(err, "cannot parse token expiry %q", t.ExpiresOn)
+	}
+	s.token, s.expires = t.AccessToken, time.Unix(expires, 0)
+	return s.token, nil
+}
+
+// A ScaleSetClient acts on scale set instances via the Azure Resource Manager
+// API.
+type ScaleSetClient struct {
+	c        *http.Client
+	endpoint string
+	tokens   TokenSource
+}
+
+// ScaleSetClientOption configures a ScaleSetClient.
+type ScaleSetClientOption func(c *ScaleSetClient)
+
+// WithEndpoint configures the Azure Resource Manager endpoint, for example to
+// use a sovereign cloud.
+func WithEndpoint(u string) ScaleSetClientOption {
+	return func(c *ScaleSetClient) {
+		c.endpoint = strings.TrimSuffix(u, "/")
+	}
+}
+
+// NewScaleSetClient returns a ScaleSetClient authenticated by the supplied
+// TokenSource.
+func NewScaleSetClient(t TokenSource, co ...ScaleSetClientOption) *ScaleSetClient {
+	c := &ScaleSetClient{c: &http.Client{Timeout: requestTimeout}, endpoint: DefaultEndpoint, tokens: t}
+	for _, o := range co {
+		o(c)
+	}
+	return c
+}
+
+// Delete the supplied scale set instance. Deletion is asynchronous.
+func (c *ScaleSetClient) Delete(i *ScaleSetInstance) error {
+	return errors.Wrapf(c.do(http.MethodDelete, i.path()), "cannot delete scale set instance %s/%s", i.ScaleSet, i.InstanceID)
+}
+
+// Reimage the supplied scale set instance. Reimaging is asynchronous.
+func (c *ScaleSetClient) Reimage(i *ScaleSetInstance) error {
+	return errors.Wrapf(c.do(http.MethodPost, i.path()+"/reimage"), "cannot reimage scale set instance %s/%s", i.ScaleSet, i.InstanceID)
+}
+
+// PostDrainFunc returns a kubernetes.PostDrainFunc that deletes or reimages
+// the scale set instance that backs each drained node, depending on the
+// supplied action. Nodes that are not backed by scale set instances are
+// ignored.
+func (c *ScaleSetClient) PostDrainFunc(action string) (kubernetes.PostDrainFunc, error) {
+	var act func(i *ScaleSetInstance) error
+	switch action {
+	case ActionDelete:
+		act = c.Delete
+	case ActionReimage:
+		act = c.Reimage
+	default:
+		return nil, errors.Errorf("unknown scale set action %q", action)
+	}
+	return func(n *core.Node) error {
+		i, err := ParseProviderID(n.Spec.ProviderID)
+		if err != nil {
+			return nil
+		}
+		return act(i)
+	}, nil
+}
+
+func (c *ScaleSetClient) do(method, path string) error {
+	token, err := c.tokens.Token()
+	if err != nil {
+		return errors.Wrap(err, "cannot get access token")
+	}
+	req, err := http.NewRequest(method, c.endpoint+path+"?api-version="+computeAPIVersion, nil)
+	if err != nil {
+		return errors.Wrap(err, "cannot create request")
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	rsp, err := c.c.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "cannot make request")
+	}
+	defer rsp.Body.Close()
+	return checkResponse(rsp)
+}
+
+// checkResponse returns an error including the start of the response body if
+// the supplied response does not indicate success.
+func checkResponse(rsp *http.Response) error {
+	if rsp.StatusCode >= 200 && rsp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(rsp.Body, maxErrorBody)) // nolint:gosec
+	return errors.Errorf("%s: %s", rsp.Status, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package azure
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	core "k8s.io/api/core/v1"
+)
+
+const providerID = "azure:///subscriptions/coolSub/resourceGroups/coolRG/providers/Microsoft.Compute/virtualMachineScaleSets/coolVMSS/virtualMachines/3"
+
+type staticTokenSource string
+
+func (s staticTokenSource) Token() (string, error) { return string(s), nil }
+
+func TestParseProviderID(t *testing.T) {
+	cases := []struct {
+		name       string
+		providerID string
+		want       *ScaleSetInstance
+		wantErr    bool
+	}{
+		{
+			name:       "ScaleSetInstance",
+			providerID: providerID,
+			want:       &ScaleSetInstance{SubscriptionID: "coolSub", ResourceGroup: "coolRG", ScaleSet: "coolVMSS", InstanceID: "3"},
+		},
+		{
+			name:       "LowercaseResourceGroups",
+			providerID: "azure:///subscriptions/coolSub/resourcegroups/coolRG/providers/Microsoft.Compute/virtualMachineScaleSets/coolVMSS/virtualMachines/3",
+			want:       &ScaleSetInstance{SubscriptionID: "coolSub", ResourceGroup: "coolRG", ScaleSet: "coolVMSS", InstanceID: "3"},
+		},
+		{
+			name:       "AvailabilitySetVM",
+			providerID: "azure:///subscriptions/coolSub/resourceGroups/coolRG/providers/Microsoft.Compute/virtualMachines/coolVM",
+			wantErr:    true,
+		},
+		{
+			name:       "NotAzure",
+			providerID: "aws:///us-west-2a/i-0123456789",
+			wantErr:    true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseProviderID(tc.providerID)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("ParseProviderID(%q): want error %v, got %v", tc.providerID, tc.wantErr, err)
+			}
+			if diff := deep.Equal(tc.want, got); diff != nil {
+				t.Errorf("ParseProviderID(%q): want != got: %v", tc.providerID, diff)
+			}
+		})
+	}
+}
+
+func TestScaleSetGroup(t *testing.T) {
+	got, ok := ScaleSetGroup(&core.Node{Spec: core.NodeSpec{ProviderID: providerID}})
+	if !ok || got != "coolrg/coolvmss" {
+		t.Errorf("ScaleSetGroup(...): want coolrg/coolvmss, true, got %v, %v", got, ok)
+	}
+	if _, ok := ScaleSetGroup(&core.Node{}); ok {
+		t.Errorf("ScaleSetGroup(...): want false for node without provider ID")
+	}
+}
+
+func TestPostDrainFunc(t *testing.T) {
+	path := "/subscriptions/coolSub/resourceGroups/coolRG/providers/Microsoft.Compute/virtualMachineScaleSets/coolVMSS/virtualMachines/3"
+	cases := []struct {
+		name       string
+		action     string
+		providerID string
+		status     int
+		want       []string
+		wantErr    bool
+	}{
+		{
+			name:       "Delete",
+			action:     ActionDelete,
+			providerID: providerID,
+			status:     http.StatusAccepted,
+			want:       []string{http.MethodDelete + " " + path},
+		},
+		{
+			name:       "Reimage",
+			action:     ActionReimage,
+			providerID: providerID,
+			status:     http.StatusAccepted,
+			want:       []string{http.MethodPost + " " + path + "/reimage"},
+		},
+		{
+			name:       "NotAScaleSetInstance",
+			action:     ActionDelete,
+			providerID: "aws:///us-west-2a/i-0123456789",
+		},
+		{
+			name:       "RequestFailed",
+			action:     ActionDelete,
+			providerID: providerID,
+			status:     http.StatusForbidden,
+			want:       []string{http.MethodDelete + " " + path},
+			wantErr:    true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Authorization") != "Bearer token" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				got = append(got, r.Method+" "+r.URL.Path)
+				w.WriteHeader(tc.status)
+			}))
+			defer srv.Close()
+
+			fn, err := NewScaleSetClient(staticTokenSource("token"), WithEndpoint(srv.URL)).PostDrainFunc(tc.action)
+			if err != nil {
+				t.Fatalf("PostDrainFunc(%v): %v", tc.action, err)
+			}
+			if err := fn(&core.Node{Spec: core.NodeSpec{ProviderID: tc.providerID}}); (err != nil) != tc.wantErr {
+				t.Errorf("fn(...): want error %v, got %v", tc.wantErr, err)
+			}
+			if diff := deep.Equal(tc.want, got); diff != nil {
+				t.Errorf("requests: want != got: %v", diff)
+			}
+		})
+	}
+}
+
+func TestManagedIdentityTokenSource(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Metadata") != "true" || r.URL.Query().Get("client_id") != "coolID" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		expires := strconv.FormatInt(time.Now().Add(1*time.Hour).Unix(), 10)
+		fmt.Fprintf(w, `{"access_token":"token","expires_on":"%s"}`, expires)
+	}))
+	defer srv.Close()
+
+	s := NewManagedIdentityTokenSource("coolID")
+	s.url = srv.URL
+	for i := 0; i < 2; i++ {
+		got, err := s.Token()
+		if err != nil {
+			t.Fatalf("s.Token(): %v", err)
+		}
+		if got != "token" {
+			t.Errorf("s.Token(): want token, got %v", got)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("token requests: want 1, got %v", requests)
+	}
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package gcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultStorageEndpoint is the Google Cloud Storage JSON API endpoint.
+const DefaultStorageEndpoint = "https://storage.googleapis.com"
+
+const (
+	metadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+	tokenExpiryLead  = 5 * time.Minute
+
+	requestTimeout = 30 * time.Second
+	maxErrorBody   = 1024
+)
+
+// A TokenSource supplies Google Cloud access tokens.
+type TokenSource interface {
+	Token() (string, error)
+}
+
+// A MetadataTokenSource supplies access tokens for the default service account
+// of the GCE instance on which it runs, or the Kubernetes service account's
+// Workload Identity, via the GCE metadata server.
+type MetadataTokenSource struct {
+	c   *http.Client
+	url string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// NewMetadataTokenSource returns a TokenSource for the default service
+// account.
+func NewMetadataTokenSource() *MetadataTokenSource {
+	return &MetadataTokenSource{c: &http.Client{Timeout: requestTimeout}, url: metadataTokenURL}
+}
+
+type metadataToken struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// Token returns an access token, reusing a cached token until shortly before
+// it expires.
+func (s *MetadataTokenSource) Token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Now().Before(s.expires.Add(-tokenExpiryLead)) {
+		return s.token, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, s.url, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "cannot create token request")
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	rsp, err := s.c.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "cannot request token")
+	}
+	defer rsp.Body.Close()
+	if err := checkResponse(rsp); err != nil {
+		return "", errors.Wrap(err, "cannot request token")
+	}
+	t := &metadataToken{}
+	if err := json.NewDecoder(rsp.Body).Decode(t); err != nil {
+		return "", errors.Wrap(err, "cannot decode token")
+	}
+	s.token, s.expires = t.AccessToken, time.Now().Add(time.Duration(t.ExpiresIn)*time.Second)
+	return s.token, nil
+}
+
+// A GCSAuditStore stores audit records as objects in a Google Cloud Storage
+// bucket. It satisfies kubernetes.AuditStore.
+type GCSAuditStore struct {
+	c        *http.Client
+	endpoint string
+	bucket   string
+	tokens   TokenSource
+}
+
+// A GCSAuditStoreOption configures a GCSAuditStore.
+type GCSAuditStoreOption func(s *GCSAuditStore)
+
+// WithStorageEndpoint configures the Google Cloud Storage JSON API endpoint.
+func WithStorageEndpoint(u string) GCSAuditStoreOption {
+	return func(s *GCSAuditStore) {
+		s.endpoint = strings.TrimSuffix(u, "/")
+	}
+}
+
+// NewGCSAuditStore returns a GCSAuditStore that stores objects in the supplied
+// bucket, authenticated by the supplied TokenSource.
+func NewGCSAuditStore(t TokenSource, bucket string, so ...GCSAuditStoreOption) *GCSAuditStore {
+	s := &GCSAuditStore{c: &http.Client{Timeout: requestTimeout}, endpoint: DefaultStorageEndpoint, bucket: bucket, tokens: t}
+	for _, o := range so {
+		o(s)
+	}
+	return s
+}
+
+// Put stores the supplied data as the object with the supplied name.
+func (s *GCSAuditStore) Put(name string, data []byte) error {
+	token, err := s.tokens.Token()
+	if err != nil {
+		return errors.Wrap(err, "cannot get access token")
+	}
+	q := url.Values{"uploadType": {"media"}, "name": {name}}
+	u := s.endpoint + "/upload/storage/v1/b/" + url.PathEscape(s.bucket) + "/o?" + q.Encode()
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrap(err, "cannot create request")
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	rsp, err := s.c.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "cannot put object gs://%s/%s", s.bucket, name)
+	}
+	defer rsp.Body.Close()
+	return errors.Wrapf(checkResponse(rsp), "cannot put object gs://%s/%s", s.bucket, name)
+}
+
+// checkResponse returns an error including the start of the response body if
+// the supplied response does not indicate success.
+func checkResponse(rsp *http.Response) error {
+	if rsp.StatusCode >= 200 && rsp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(rsp.Body, maxErrorBody)) // nolint:gosec
+	return errors.Errorf("%s: %s", rsp.Status, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package gcp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type staticTokenSource string
+
+func (s staticTokenSource) Token() (string, error) { return string(s), nil }
+
+func TestMetadataTokenSource(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `{"access_token":"token","expires_in":3599,"token_type":"Bearer"}`)
+	}))
+	defer srv.Close()
+
+	s := NewMetadataTokenSource()
+	s.url = srv.URL
+	for i := 0; i < 2; i++ {
+		got, err := s.Token()
+		if err != nil {
+			t.Fatalf("s.Token(): %v", err)
+		}
+		if got != "token" {
+			t.Errorf("s.Token(): want token, got %v", got)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("token requests: want 1, got %v", requests)
+	}
+}
+
+func TestGCSAuditStore(t *testing.T) {
+	cases := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{name: "Succeeded", status: http.StatusOK},
+		{name: "Failed", status: http.StatusForbidden, wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var path, object, body string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.Header.Get("Authorization") != "Bearer token" {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				b, _ := ioutil.ReadAll(r.Body)
+				path, object, body = r.URL.Path, r.URL.Query().Get("name"), string(b)
+				w.WriteHeader(tc.status)
+			}))
+			defer srv.Close()
+
+			err := NewGCSAuditStore(staticTokenSource("token"), "audit", WithStorageEndpoint(srv.URL)).Put("2018/01/01/a.jsonl", []byte("{}\n"))
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Put(): want error %v, got %v", tc.wantErr, err)
+			}
+			if path != "/upload/storage/v1/b/audit/o" || object != "2018/01/01/a.jsonl" || body != "{}\n" {
+				t.Errorf("Put(): got path %q, object %q, body %q", path, object, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+)
+
+// A NodeAction determines how far draino remediates a node it cordons. Each
+// action includes those before it.
+type NodeAction int
+
+// Node actions.
+const (
+	// ActionCordon cordons the node.
+	ActionCordon NodeAction = iota
+
+	// ActionDrain cordons and drains the node.
+	ActionDrain
+
+	// ActionTerminate cordons and drains the node, then runs any post-drain
+	// functions, for example to terminate the machine that backs it.
+	ActionTerminate
+)
+
+var actionNames = map[NodeAction]string{
+	ActionCordon:    "cordon-only",
+	ActionDrain:     "cordon+drain",
+	ActionTerminate: "cordon+drain+terminate",
+}
+
+func (a NodeAction) String() string {
+	return actionNames[a]
+}
+
+// ParseNodeAction parses a node action, i.e. one of cordon-only, cordon+drain,
+// or cordon+drain+terminate.
+func ParseNodeAction(s string) (NodeAction, error) {
+	for a, name := range actionNames {
+		if strings.EqualFold(s, name) {
+			return a, nil
+		}
+	}
+	return ActionTerminate, errors.Errorf("unknown node action %q", s)
+}
+
+// Pipeline returns the pipeline that takes the action. Every action notifies
+// and cordons. ActionDrain also runs the pre-hook and drain steps, and
+// ActionTerminate also runs the post-hook and replace steps.
+func (a NodeAction) Pipeline() Pipeline {
+	s := []string{StepNotify, StepCordon}
+	if a >= ActionDrain {
+		s = append(s, StepPreHook, StepDrain)
+	}
+	if a >= ActionTerminate {
+		s = append(s, StepPostHook, StepReplace)
+	}
+	return newPipeline(s...)
+}
+
+// A NodeActionFunc returns the action draino should take for the supplied
+// node.
+type NodeActionFunc func(n *core.Node) NodeAction
+
+// NewReasonActionFunc returns a NodeActionFunc that chooses an action for each
+// node according to the reasons the supplied function returns for cordoning
+// it, for example those returned by NewCordonReasonFunc. Nodes are given the
+// most thorough action of any of their reasons. Reasons that are not assigned
+// an action, and nodes with no reasons, are given ActionTerminate.
+func NewReasonActionFunc(reasons func(n *core.Node) []string, actions map[string]NodeAction) NodeActionFunc {
+	return func(n *core.Node) NodeAction {
+		rs := reasons(n)
+		if len(rs) == 0 {
+			return ActionTerminate
+		}
+		action := ActionCordon
+		for _, r := range rs {
+			a, ok := actions[r]
+			if !ok {
+				a = ActionTerminate
+			}
+			if a > action {
+				action = a
+			}
+		}
+		return action
+	}
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+
+	core "k8s.io/api/core/v1"
+)
+
+func TestParseNodeAction(t *testing.T) {
+	cases := []struct {
+		name    string
+		s       string
+		want    NodeAction
+		wantErr bool
+	}{
+		{name: "Cordon", s: "cordon-only", want: ActionCordon},
+		{name: "Drain", s: "Cordon+Drain", want: ActionDrain},
+		{name: "Terminate", s: "cordon+drain+terminate", want: ActionTerminate},
+		{name: "Unknown", s: "drain", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseNodeAction(tc.s)
+			if err != nil {
+				if tc.wantErr {
+					return
+				}
+				t.Fatalf("ParseNodeAction(%v): %v", tc.s, err)
+			}
+			if tc.wantErr {
+				t.Fatalf("ParseNodeAction(%v): want error", tc.s)
+			}
+			if got != tc.want {
+				t.Errorf("ParseNodeAction(%v): want %v, got %v", tc.s, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestReasonActionFunc(t *testing.T) {
+	actions := map[string]NodeAction{
+		"DiskPressure":   ActionCordon,
+		"MemoryPressure": ActionDrain,
+		"KernelDeadlock": ActionTerminate,
+	}
+
+	cases := []struct {
+		name    string
+		reasons []string
+		want    NodeAction
+	}{
+		{name: "CordonOnly", reasons: []string{"DiskPressure"}, want: ActionCordon},
+		{name: "Drain", reasons: []string{"MemoryPressure"}, want: ActionDrain},
+		{name: "MostThorough", reasons: []string{"DiskPressure", "KernelDeadlock", "MemoryPressure"}, want: ActionTerminate},
+		{name: "Unassigned", reasons: []string{"DiskPressure", "ReadonlyFilesystem"}, want: ActionTerminate},
+		{name: "NoReasons", want: ActionTerminate},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fn := NewReasonActionFunc(func(_ *core.Node) []string { return tc.reasons }, actions)
+			if got := fn(&core.Node{}); got != tc.want {
+				t.Errorf("fn(%v): want %v, got %v", tc.reasons, tc.want, got)
+			}
+		})
+	}
+}
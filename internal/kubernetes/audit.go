@@ -0,0 +1,221 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+)
+
+// Default audit export settings.
+const (
+	DefaultAuditInterval = 5 * time.Minute
+
+	// maxAuditRecords bounds how many records are retained while uploads
+	// are failing. The oldest records are dropped first.
+	maxAuditRecords = 100000
+)
+
+// An AuditRecord describes an action draino took, for example cordoning or
+// draining a node, and its result.
+type AuditRecord struct {
+	Time    time.Time `json:"time"`
+	Cluster string    `json:"cluster,omitempty"`
+	Kind    string    `json:"kind"`
+	Name    string    `json:"name"`
+	Type    string    `json:"type"`
+	Reason  string    `json:"reason"`
+	Message string    `json:"message"`
+}
+
+// An AuditStore stores batches of audit records, for example in an object
+// storage bucket.
+type AuditStore interface {
+	// Put stores the supplied data under the supplied key.
+	Put(key string, data []byte) error
+}
+
+// An AuditExporter periodically uploads the audit records it has accumulated
+// to an AuditStore, as newline delimited JSON.
+type AuditExporter struct {
+	l        *zap.Logger
+	store    AuditStore
+	prefix   string
+	instance string
+	interval time.Duration
+
+	mu      sync.Mutex
+	records []AuditRecord
+}
+
+// An AuditExporterOption configures an AuditExporter.
+type AuditExporterOption func(a *AuditExporter)
+
+// WithAuditLogger configures an AuditExporter to use the supplied logger.
+func WithAuditLogger(l *zap.Logger) AuditExporterOption {
+	return func(a *AuditExporter) {
+		a.l = l
+	}
+}
+
+// WithAuditInterval configures the time between uploads.
+func WithAuditInterval(i time.Duration) AuditExporterOption {
+	return func(a *AuditExporter) {
+		a.interval = i
+	}
+}
+
+// WithAuditKeyPrefix configures the prefix of the key under which each batch
+// of records is stored.
+func WithAuditKeyPrefix(p string) AuditExporterOption {
+	return func(a *AuditExporter) {
+		a.prefix = p
+	}
+}
+
+// WithAuditInstance configures the name of this draino instance, for example
+// its pod name, which is included in each key so that several instances may
+// upload to the same store.
+func WithAuditInstance(name string) AuditExporterOption {
+	return func(a *AuditExporter) {
+		a.instance = name
+	}
+}
+
+// NewAuditExporter returns an AuditExporter that uploads to the supplied
+// store.
+func NewAuditExporter(s AuditStore, ao ...AuditExporterOption) *AuditExporter {
+	a := &AuditExporter{l: zap.NewNop(), store: s, instance: Component, interval: DefaultAuditInterval}
+	for _, o := range ao {
+		o(a)
+	}
+	return a
+}
+
+// Record the supplied audit record. It is uploaded with the next batch.
+func (a *AuditExporter) Record(r AuditRecord) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.records = append(a.records, r)
+	if len(a.records) > maxAuditRecords {
+		a.records = a.records[len(a.records)-maxAuditRecords:]
+	}
+}
+
+// Run the exporter until the supplied channel is closed. Records accumulated
+// since the last upload are uploaded once more when the channel is closed.
+func (a *AuditExporter) Run(stop <-chan struct{}) {
+	wait.Until(func() { a.upload(time.Now()) }, a.interval, stop)
+	a.upload(time.Now())
+}
+
+func (a *AuditExporter) upload(now time.Time) {
+	a.mu.Lock()
+	records := a.records
+	a.records = nil
+	a.mu.Unlock()
+
+	if len(records) == 0 {
+		return
+	}
+	if err := a.put(now, records); err != nil {
+		a.l.Info("Failed to upload audit records", zap.Int("records", len(records)), zap.Error(err))
+		// Retry these records, ahead of any recorded since, next time.
+		a.mu.Lock()
+		a.records = append(records, a.records...)
+		if len(a.records) > maxAuditRecords {
+			a.records = a.records[len(a.records)-maxAuditRecords:]
+		}
+		a.mu.Unlock()
+		return
+	}
+	a.l.Debug("Uploaded audit records", zap.Int("records", len(records)))
+}
+
+func (a *AuditExporter) put(now time.Time, records []AuditRecord) error {
+	b := &bytes.Buffer{}
+	e := json.NewEncoder(b)
+	for _, r := range records {
+		if err := e.Encode(r); err != nil {
+			return errors.Wrap(err, "cannot encode audit record")
+		}
+	}
+	now = now.UTC()
+	key := path.Join(a.prefix, now.Format("2006/01/02"), fmt.Sprintf("%s-%s.jsonl", now.Format("20060102T150405.000000000Z"), a.instance))
+	return errors.Wrapf(a.store.Put(key, b.Bytes()), "cannot store audit records at %s", key)
+}
+
+// An AuditRecorder records audit records, for example an AuditExporter.
+type AuditRecorder interface {
+	Record(r AuditRecord)
+}
+
+// NewAuditingEventRecorder returns an EventRecorder that records events using
+// the supplied EventRecorder, and records an audit record of each event using
+// the supplied AuditRecorder.
+func NewAuditingEventRecorder(r record.EventRecorder, a AuditRecorder, cluster string) record.EventRecorder {
+	return &auditingEventRecorder{r: r, a: a, cluster: cluster}
+}
+
+type auditingEventRecorder struct {
+	r       record.EventRecorder
+	a       AuditRecorder
+	cluster string
+}
+
+func (r *auditingEventRecorder) audit(o runtime.Object, t time.Time, eventtype, reason, message string) {
+	ar := AuditRecord{Time: t.UTC(), Cluster: r.cluster, Type: eventtype, Reason: reason, Message: message}
+	switch o := o.(type) {
+	case *core.ObjectReference:
+		ar.Kind, ar.Name = o.Kind, o.Name
+	case *core.Node:
+		ar.Kind, ar.Name = "Node", o.GetName()
+	}
+	r.a.Record(ar)
+}
+
+func (r *auditingEventRecorder) Event(o runtime.Object, eventtype, reason, message string) {
+	r.audit(o, time.Now(), eventtype, reason, message)
+	r.r.Event(o, eventtype, reason, message)
+}
+
+func (r *auditingEventRecorder) Eventf(o runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.audit(o, time.Now(), eventtype, reason, fmt.Sprintf(messageFmt, args...))
+	r.r.Eventf(o, eventtype, reason, messageFmt, args...)
+}
+
+func (r *auditingEventRecorder) PastEventf(o runtime.Object, timestamp meta.Time, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.audit(o, timestamp.Time, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+	r.r.PastEventf(o, timestamp, eventtype, reason, messageFmt, args...)
+}
+
+func (r *auditingEventRecorder) AnnotatedEventf(o runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.audit(o, time.Now(), eventtype, reason, fmt.Sprintf(messageFmt, args...))
+	r.r.AnnotatedEventf(o, annotations, eventtype, reason, messageFmt, args...)
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+type memoryAuditStore struct {
+	err     error
+	objects map[string][]byte
+}
+
+func (s *memoryAuditStore) Put(key string, data []byte) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.objects[key] = data
+	return nil
+}
+
+func TestAuditExporter(t *testing.T) {
+	now := time.Date(2018, 1, 1, 12, 30, 0, 0, time.UTC)
+	want := []AuditRecord{
+		{Time: now, Cluster: "prod", Kind: "Node", Name: nodeName, Type: core.EventTypeWarning, Reason: eventReasonCordonSucceeded, Message: "Cordoned node"},
+		{Time: now, Cluster: "prod", Kind: "Node", Name: nodeName, Type: core.EventTypeWarning, Reason: eventReasonDrainFailed, Message: "Draining failed: boom"},
+	}
+
+	s := &memoryAuditStore{err: errors.New("boom"), objects: make(map[string][]byte)}
+	a := NewAuditExporter(s, WithAuditKeyPrefix("audit"), WithAuditInstance("draino-abcde"))
+	r := NewAuditingEventRecorder(record.NewFakeRecorder(10), a, "prod")
+	nr := &core.ObjectReference{Kind: "Node", Name: nodeName}
+	r.Event(nr, core.EventTypeWarning, eventReasonCordonSucceeded, "Cordoned node")
+	r.Eventf(nr, core.EventTypeWarning, eventReasonDrainFailed, "Draining failed: %v", "boom")
+	for i := range a.records {
+		a.records[i].Time = now
+	}
+
+	// Records are retained when they cannot be uploaded.
+	a.upload(now)
+	if len(s.objects) != 0 {
+		t.Fatalf("a.upload(): want no objects, got %d", len(s.objects))
+	}
+
+	s.err = nil
+	a.upload(now)
+	key := "audit/2018/01/01/20180101T123000.000000000Z-draino-abcde.jsonl"
+	data, ok := s.objects[key]
+	if !ok {
+		t.Fatalf("a.upload(): want object %s, got %v", key, s.objects)
+	}
+	got := []AuditRecord{}
+	d := json.NewDecoder(bytes.NewReader(data))
+	for d.More() {
+		ar := AuditRecord{}
+		if err := d.Decode(&ar); err != nil {
+			t.Fatalf("Decode(): %v", err)
+		}
+		got = append(got, ar)
+	}
+	if diff := deep.Equal(want, got); diff != nil {
+		t.Errorf("a.upload(): want != got: %v", diff)
+	}
+
+	// Nothing is uploaded when there are no new records.
+	a.upload(now.Add(time.Minute))
+	if len(s.objects) != 1 {
+		t.Errorf("a.upload(): want 1 object, got %d", len(s.objects))
+	}
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"sync"
+	"time"
+
+	core "k8s.io/api/core/v1"
+)
+
+// DefaultStartupBacklogDelay is the default time to wait after the initial
+// list of nodes before starting drains.
+const DefaultStartupBacklogDelay = 30 * time.Second
+
+// NewStartupBacklogGate returns a DrainGateFunc that prevents drains from
+// starting until the supplied function reports the initial list of nodes has
+// been observed, and the supplied delay has passed since. Nodes that match
+// when draino starts are then scheduled together, so their drains start in
+// priority order and are paced by the scheduler's buffer and limits rather
+// than starting in the arbitrary order in which they were listed.
+func NewStartupBacklogGate(synced func() bool, delay time.Duration) DrainGateFunc {
+	var (
+		mu     sync.Mutex
+		since  time.Time
+		opened bool
+	)
+	return func(_ *core.Node, now time.Time) (bool, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if opened {
+			return true, ""
+		}
+		if since.IsZero() {
+			if !synced() {
+				return false, "waiting for initial node list"
+			}
+			since = now
+		}
+		if now.Sub(since) < delay {
+			return false, "waiting for startup backlog to be scheduled"
+		}
+		opened = true
+		return true, ""
+	}
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestStartupBacklogGate(t *testing.T) {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	delay := 30 * time.Second
+
+	cases := []struct {
+		name   string
+		synced bool
+		now    time.Time
+		want   bool
+	}{
+		{name: "NotSynced", synced: false, now: start, want: false},
+		{name: "Synced", synced: true, now: start.Add(1 * time.Minute), want: false},
+		{name: "WithinDelay", synced: true, now: start.Add(1*time.Minute + delay/2), want: false},
+		{name: "DelayPassed", synced: true, now: start.Add(1*time.Minute + delay), want: true},
+		{name: "StaysOpen", synced: false, now: start.Add(1*time.Minute + delay/2), want: true},
+	}
+
+	// Cases run in order against the same gate.
+	synced := false
+	gate := NewStartupBacklogGate(func() bool { return synced }, delay)
+	n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			synced = tc.synced
+			if got, reason := gate(n, tc.now); got != tc.want {
+				t.Errorf("gate(%v, %v): want %v, got %v (%s)", n.GetName(), tc.now, tc.want, got, reason)
+			}
+		})
+	}
+}
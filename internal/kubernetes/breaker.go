@@ -0,0 +1,188 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"go.uber.org/zap"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DefaultPendingPodsInterval is the default time between counts of
+// unschedulable pending pods.
+const DefaultPendingPodsInterval = 30 * time.Second
+
+// PendingPodsBreakerStatus is the state of a PendingPodsBreaker.
+type PendingPodsBreakerStatus struct {
+	Tripped           bool      `json:"tripped"`
+	UnschedulablePods int       `json:"unschedulablePods"`
+	Threshold         int       `json:"threshold"`
+	LastChecked       time.Time `json:"lastChecked"`
+	Error             string    `json:"error,omitempty"`
+}
+
+// A PendingPodsBreaker periodically counts the pending pods in the cluster
+// that could not be scheduled. It trips, pausing new drains, while there are
+// more than its threshold; a sign that the pods evicted by previous drains
+// are not landing elsewhere.
+type PendingPodsBreaker struct {
+	l         *zap.Logger
+	c         kubernetes.Interface
+	threshold int
+	interval  time.Duration
+	cluster   string
+
+	mu     sync.Mutex
+	status PendingPodsBreakerStatus
+}
+
+// PendingPodsBreakerOption configures a PendingPodsBreaker.
+type PendingPodsBreakerOption func(b *PendingPodsBreaker)
+
+// WithPendingPodsLogger configures a PendingPodsBreaker to use the supplied
+// logger.
+func WithPendingPodsLogger(l *zap.Logger) PendingPodsBreakerOption {
+	return func(b *PendingPodsBreaker) {
+		b.l = l
+	}
+}
+
+// WithPendingPodsInterval configures the time between counts of
+// unschedulable pending pods.
+func WithPendingPodsInterval(i time.Duration) PendingPodsBreakerOption {
+	return func(b *PendingPodsBreaker) {
+		b.interval = i
+	}
+}
+
+// WithPendingPodsClusterName configures the cluster name with which the
+// breaker's metrics are tagged.
+func WithPendingPodsClusterName(name string) PendingPodsBreakerOption {
+	return func(b *PendingPodsBreaker) {
+		b.cluster = name
+	}
+}
+
+// NewPendingPodsBreaker returns a PendingPodsBreaker that trips when more
+// than the supplied number of pods are pending and unschedulable.
+func NewPendingPodsBreaker(c kubernetes.Interface, threshold int, bo ...PendingPodsBreakerOption) *PendingPodsBreaker {
+	b := &PendingPodsBreaker{
+		l:         zap.NewNop(),
+		c:         c,
+		threshold: threshold,
+		interval:  DefaultPendingPodsInterval,
+		status:    PendingPodsBreakerStatus{Threshold: threshold},
+	}
+	for _, o := range bo {
+		o(b)
+	}
+	return b
+}
+
+// Run the breaker until the supplied channel is closed.
+func (b *PendingPodsBreaker) Run(stop <-chan struct{}) {
+	wait.Until(b.check, b.interval, stop)
+}
+
+// Status returns the breaker's current state.
+func (b *PendingPodsBreaker) Status() PendingPodsBreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.status
+}
+
+// Gate is a DrainGateFunc that prevents drains from starting while the
+// breaker is tripped.
+func (b *PendingPodsBreaker) Gate(_ *core.Node, _ time.Time) (bool, string) {
+	s := b.Status()
+	if !s.Tripped {
+		return true, ""
+	}
+	return false, fmt.Sprintf("%d pods are pending and unschedulable, more than the threshold of %d", s.UnschedulablePods, s.Threshold)
+}
+
+func (b *PendingPodsBreaker) check() {
+	pending, err := b.unschedulable()
+
+	b.mu.Lock()
+	was := b.status.Tripped
+	b.status.LastChecked = time.Now()
+	b.status.Error = ""
+	if err != nil {
+		// The breaker remains in its previous state until pods can be
+		// counted again.
+		b.status.Error = err.Error()
+	} else {
+		b.status.UnschedulablePods = pending
+		b.status.Tripped = pending > b.threshold
+	}
+	s := b.status
+	b.mu.Unlock()
+
+	if err != nil {
+		b.l.Info("Cannot count unschedulable pods", zap.Error(err))
+		return
+	}
+	if s.Tripped != was {
+		b.l.Info("Pending pods breaker changed state", zap.Bool("tripped", s.Tripped), zap.Int("unschedulable_pods", s.UnschedulablePods), zap.Int("threshold", s.Threshold))
+	}
+
+	tags := context.Background()
+	if b.cluster != "" {
+		tags, _ = tag.New(tags, tag.Upsert(TagCluster, b.cluster)) // nolint:gosec
+	}
+	tripped := int64(0)
+	if s.Tripped {
+		tripped = 1
+	}
+	stats.Record(tags, MeasureUnschedulablePods.M(int64(s.UnschedulablePods)), MeasurePendingPodsTripped.M(tripped))
+}
+
+// unschedulable returns the number of pending pods that the scheduler could
+// not schedule.
+func (b *PendingPodsBreaker) unschedulable() (int, error) {
+	l, err := listPods(b.c.CoreV1().Pods(meta.NamespaceAll).List, meta.ListOptions{
+		FieldSelector: fields.SelectorFromSet(fields.Set{"status.phase": string(core.PodPending)}).String(),
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "cannot list pending pods")
+	}
+	n := 0
+	for _, p := range l.Items {
+		if p.Status.Phase != core.PodPending {
+			continue
+		}
+		for _, c := range p.Status.Conditions {
+			if c.Type == core.PodScheduled && c.Status == core.ConditionFalse && c.Reason == core.PodReasonUnschedulable {
+				n++
+				break
+			}
+		}
+	}
+	return n, nil
+}
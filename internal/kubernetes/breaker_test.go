@@ -0,0 +1,101 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newPendingPods(n int, reason string) []runtime.Object {
+	pods := make([]runtime.Object, 0, n)
+	for i := 0; i < n; i++ {
+		pods = append(pods, &core.Pod{
+			ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: fmt.Sprintf("%s-%d", reason, i)},
+			Status: core.PodStatus{
+				Phase:      core.PodPending,
+				Conditions: []core.PodCondition{{Type: core.PodScheduled, Status: core.ConditionFalse, Reason: reason}},
+			},
+		})
+	}
+	return pods
+}
+
+func TestPendingPodsBreaker(t *testing.T) {
+	cases := []struct {
+		name        string
+		pods        []runtime.Object
+		wantTripped bool
+	}{
+		{
+			name: "NoPendingPods",
+		},
+		{
+			name: "AtThreshold",
+			pods: newPendingPods(2, core.PodReasonUnschedulable),
+		},
+		{
+			name:        "OverThreshold",
+			pods:        newPendingPods(3, core.PodReasonUnschedulable),
+			wantTripped: true,
+		},
+		{
+			name: "PendingButNotUnschedulable",
+			pods: newPendingPods(3, "ContainerCreating"),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := NewPendingPodsBreaker(fake.NewSimpleClientset(tc.pods...), 2)
+			b.check()
+			if s := b.Status(); s.Tripped != tc.wantTripped {
+				t.Errorf("b.Status().Tripped: want %v, got %v", tc.wantTripped, s.Tripped)
+			}
+			if open, reason := b.Gate(&core.Node{}, time.Now()); open == tc.wantTripped {
+				t.Errorf("b.Gate(): want open %v, got %v: %s", !tc.wantTripped, open, reason)
+			}
+		})
+	}
+}
+
+func TestPendingPodsBreakerError(t *testing.T) {
+	l := &core.PodList{}
+	for _, o := range newPendingPods(2, core.PodReasonUnschedulable) {
+		l.Items = append(l.Items, *o.(*core.Pod))
+	}
+	c := newFakeClientSet(reactor{verb: "list", resource: "pods", ret: l})
+	b := NewPendingPodsBreaker(c, 1)
+	b.check()
+	if !b.Status().Tripped {
+		t.Fatalf("b.Status().Tripped: want true, got false")
+	}
+
+	// The breaker remains tripped while pods cannot be counted.
+	b.c = newFakeClientSet(reactor{verb: "list", resource: "pods", err: errors.New("nope")})
+	b.check()
+	if s := b.Status(); !s.Tripped || s.Error == "" {
+		t.Errorf("b.Status(): want tripped with error, got %+v", s)
+	}
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"sync"
+
+	"go.opencensus.io/tag"
+)
+
+// TagValueOther replaces the values of a limited tag beyond the first
+// permitted by SetMaxTagValues.
+const TagValueOther = "other"
+
+// tagValues limits the values recorded for the tags whose cardinality is
+// unbounded.
+var tagValues = newTagValueLimiter(0)
+
+// SetMaxTagValues limits the number of distinct values recorded for each of the
+// node name, node pool, reason, owner kind, and finalizer tags, so that large
+// fleets do not produce unbounded numbers of metric series. The first values
+// recorded for each tag are kept; later values are recorded as TagValueOther.
+// A limit of zero, the default, leaves tags unlimited.
+func SetMaxTagValues(n int) {
+	tagValues = newTagValueLimiter(n)
+}
+
+// upsertLimited returns a tag mutator that upserts the supplied value, subject
+// to the limit set by SetMaxTagValues.
+func upsertLimited(k tag.Key, v string) tag.Mutator {
+	return tag.Upsert(k, tagValues.value(k, v))
+}
+
+// A tagValueLimiter tracks the values recorded for each tag key.
+type tagValueLimiter struct {
+	max int
+
+	mu   sync.Mutex
+	seen map[tag.Key]map[string]bool
+}
+
+func newTagValueLimiter(max int) *tagValueLimiter {
+	return &tagValueLimiter{max: max, seen: make(map[tag.Key]map[string]bool)}
+}
+
+// value returns the supplied value if it may be recorded for the supplied
+// key, or TagValueOther if too many other values have already been recorded.
+func (l *tagValueLimiter) value(k tag.Key, v string) string {
+	if l.max <= 0 {
+		return v
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	seen, ok := l.seen[k]
+	if !ok {
+		seen = make(map[string]bool)
+		l.seen[k] = seen
+	}
+	if seen[v] {
+		return v
+	}
+	if len(seen) >= l.max {
+		return TagValueOther
+	}
+	seen[v] = true
+	return v
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+	"go.opencensus.io/tag"
+)
+
+func TestTagValueLimiter(t *testing.T) {
+	type record struct {
+		key   tag.Key
+		value string
+	}
+	cases := []struct {
+		name    string
+		max     int
+		records []record
+		want    []string
+	}{
+		{
+			name:    "Unlimited",
+			records: []record{{TagNodeName, "a"}, {TagNodeName, "b"}, {TagNodeName, "c"}},
+			want:    []string{"a", "b", "c"},
+		},
+		{
+			name:    "Limited",
+			max:     2,
+			records: []record{{TagNodeName, "a"}, {TagNodeName, "b"}, {TagNodeName, "c"}, {TagNodeName, "a"}},
+			want:    []string{"a", "b", TagValueOther, "a"},
+		},
+		{
+			name:    "LimitedPerKey",
+			max:     1,
+			records: []record{{TagNodeName, "a"}, {TagNodePool, "pool"}, {TagNodeName, "b"}, {TagNodePool, "pool"}},
+			want:    []string{"a", "pool", TagValueOther, "pool"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := newTagValueLimiter(tc.max)
+			got := make([]string, 0, len(tc.records))
+			for _, r := range tc.records {
+				got = append(got, l.value(r.key, r.value))
+			}
+			if diff := deep.Equal(tc.want, got); diff != nil {
+				t.Errorf("l.value(...): want != got: %v", diff)
+			}
+		})
+	}
+}
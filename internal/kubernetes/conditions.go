@@ -0,0 +1,378 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+)
+
+// Condition expression operators.
+const (
+	operatorAnd = "AND"
+	operatorOr  = "OR"
+	operatorNot = "NOT"
+)
+
+// now returns the time against which condition durations are measured.
+var now = time.Now
+
+// SetClock changes the function that returns the time against which condition
+// durations are measured, for example to evaluate conditions as of a fixed
+// time. It must be called before any conditions are evaluated.
+func SetClock(fn func() time.Time) {
+	now = fn
+}
+
+// A ConditionExpression is a boolean expression of node conditions, for
+// example "(KernelDeadlock OR ReadonlyFilesystem) AND NOT UnderMaintenance".
+type ConditionExpression interface {
+	// Evaluate the expression against the supplied node. A condition is
+	// true if the node has that condition with the expected status (true
+	// unless otherwise specified), and has done for at least the expected
+	// duration (if any).
+	Evaluate(n *core.Node) bool
+
+	// String returns the expression in its canonical form.
+	String() string
+}
+
+type conditionTerm struct {
+	condition core.NodeConditionType
+	status    core.ConditionStatus
+	duration  time.Duration
+}
+
+func (c conditionTerm) Evaluate(n *core.Node) bool {
+	for _, nc := range n.Status.Conditions {
+		if nc.Type != c.condition || nc.Status != c.status {
+			continue
+		}
+		return now().Sub(nc.LastTransitionTime.Time) >= c.duration
+	}
+	return false
+}
+
+func (c conditionTerm) String() string {
+	s := string(c.condition)
+	if c.status != core.ConditionTrue {
+		s += "=" + string(c.status)
+	}
+	if c.duration > 0 {
+		s += "," + c.duration.String()
+	}
+	return s
+}
+
+// parseConditionTerm parses a term of the form CONDITION[=STATUS][,DURATION].
+func parseConditionTerm(t string) (conditionTerm, error) {
+	c := conditionTerm{status: core.ConditionTrue}
+	if i := strings.Index(t, ","); i >= 0 {
+		d, err := time.ParseDuration(t[i+1:])
+		if err != nil {
+			return c, errors.Wrapf(err, "cannot parse duration of %q", t)
+		}
+		if d < 0 {
+			return c, errors.Errorf("duration of %q must not be negative", t)
+		}
+		c.duration, t = d, t[:i]
+	}
+	if i := strings.Index(t, "="); i >= 0 {
+		switch status := core.ConditionStatus(t[i+1:]); status {
+		case core.ConditionTrue, core.ConditionFalse, core.ConditionUnknown:
+			c.status, t = status, t[:i]
+		default:
+			return c, errors.Errorf("status of %q must be one of %s, %s, or %s", t, core.ConditionTrue, core.ConditionFalse, core.ConditionUnknown)
+		}
+	}
+	if t == "" {
+		return c, errors.New("condition name is empty")
+	}
+	c.condition = core.NodeConditionType(t)
+	return c, nil
+}
+
+type and []ConditionExpression
+
+func (a and) Evaluate(n *core.Node) bool {
+	for _, e := range a {
+		if !e.Evaluate(n) {
+			return false
+		}
+	}
+	return true
+}
+
+func (a and) String() string {
+	return join(a, operatorAnd)
+}
+
+type or []ConditionExpression
+
+func (o or) Evaluate(n *core.Node) bool {
+	for _, e := range o {
+		if e.Evaluate(n) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o or) String() string {
+	return join(o, operatorOr)
+}
+
+type not struct{ e ConditionExpression }
+
+func (n not) Evaluate(node *core.Node) bool {
+	return !n.e.Evaluate(node)
+}
+
+func (n not) String() string {
+	return operatorNot + " " + n.e.String()
+}
+
+func join(es []ConditionExpression, op string) string {
+	s := make([]string, len(es))
+	for i, e := range es {
+		s[i] = e.String()
+	}
+	return "(" + strings.Join(s, " "+op+" ") + ")"
+}
+
+// ParseConditionExpression parses a boolean expression of node conditions.
+// Conditions may be combined using the AND, OR, and NOT operators, and grouped
+// using parentheses. NOT binds more tightly than AND, which binds more tightly
+// than OR. A single condition name is also a valid expression. Each condition
+// may be written as CONDITION[=STATUS][,DURATION] in order to match a status
+// other than True, or to match only conditions that have had that status for
+// at least the supplied duration, e.g. "Ready=Unknown,10m".
+func ParseConditionExpression(s string) (ConditionExpression, error) {
+	p := &conditionParser{tokens: tokenize(s)}
+	if len(p.tokens) == 0 {
+		return nil, errors.Errorf("cannot parse condition expression %q: expression is empty", s)
+	}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot parse condition expression %q", s)
+	}
+	if t, ok := p.peek(); ok {
+		return nil, errors.Errorf("cannot parse condition expression %q: unexpected %q", s, t)
+	}
+	return e, nil
+}
+
+func tokenize(s string) []string {
+	tokens := make([]string, 0)
+	current := ""
+	flush := func() {
+		if current != "" {
+			tokens = append(tokens, current)
+			current = ""
+		}
+	}
+	for _, r := range s {
+		switch {
+		case unicode.IsSpace(r):
+			flush()
+		case r == '(' || r == ')':
+			flush()
+			tokens = append(tokens, string(r))
+		default:
+			current += string(r)
+		}
+	}
+	flush()
+	return tokens
+}
+
+type conditionParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *conditionParser) peek() (string, bool) {
+	if p.pos >= len(p.tokens) {
+		return "", false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *conditionParser) next() (string, bool) {
+	t, ok := p.peek()
+	if ok {
+		p.pos++
+	}
+	return t, ok
+}
+
+func (p *conditionParser) parseOr() (ConditionExpression, error) {
+	e, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	o := or{e}
+	for t, ok := p.peek(); ok && t == operatorOr; t, ok = p.peek() {
+		p.next()
+		e, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		o = append(o, e)
+	}
+	if len(o) == 1 {
+		return o[0], nil
+	}
+	return o, nil
+}
+
+func (p *conditionParser) parseAnd() (ConditionExpression, error) {
+	e, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	a := and{e}
+	for t, ok := p.peek(); ok && t == operatorAnd; t, ok = p.peek() {
+		p.next()
+		e, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, e)
+	}
+	if len(a) == 1 {
+		return a[0], nil
+	}
+	return a, nil
+}
+
+func (p *conditionParser) parseNot() (ConditionExpression, error) {
+	if t, ok := p.peek(); ok && t == operatorNot {
+		p.next()
+		e, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return not{e}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *conditionParser) parsePrimary() (ConditionExpression, error) {
+	t, ok := p.next()
+	switch {
+	case !ok:
+		return nil, errors.New("unexpected end of expression")
+	case t == "(":
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t, ok := p.next(); !ok || t != ")" {
+			return nil, errors.New("missing closing parenthesis")
+		}
+		return e, nil
+	case t == ")" || t == operatorAnd || t == operatorOr || t == operatorNot:
+		return nil, errors.Errorf("unexpected %q", t)
+	default:
+		c, err := parseConditionTerm(t)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+}
+
+// NewNodeConditionExpressionFilter returns a filter that returns true if the
+// supplied object is a node for which any of the supplied expressions are
+// true.
+func NewNodeConditionExpressionFilter(expressions ...ConditionExpression) func(o interface{}) bool {
+	return func(o interface{}) bool {
+		n, ok := o.(*core.Node)
+		if !ok {
+			return false
+		}
+		if len(expressions) == 0 {
+			return true
+		}
+		for _, e := range expressions {
+			if e.Evaluate(n) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// NewCordonReasonFunc returns a function that returns the reasons the supplied
+// node would be cordoned: the conditions that make any of the supplied
+// expressions true, and any of the supplied labels that the node has, in the
+// form KEY=VALUE. Negated conditions are never reasons.
+func NewCordonReasonFunc(expressions []ConditionExpression, labels map[string]string) func(n *core.Node) []string {
+	return func(n *core.Node) []string {
+		reasons := []string{}
+		seen := make(map[string]bool)
+		add := func(r string) {
+			if !seen[r] {
+				seen[r] = true
+				reasons = append(reasons, r)
+			}
+		}
+		for _, e := range expressions {
+			for _, r := range conditionReasons(e, n) {
+				add(r)
+			}
+		}
+		for k, v := range labels {
+			if n.GetLabels()[k] == v {
+				add(k + "=" + v)
+			}
+		}
+		sort.Strings(reasons)
+		return reasons
+	}
+}
+
+// conditionReasons returns the conditions that make the supplied expression
+// true for the supplied node.
+func conditionReasons(e ConditionExpression, n *core.Node) []string {
+	if !e.Evaluate(n) {
+		return nil
+	}
+	switch e := e.(type) {
+	case conditionTerm:
+		return []string{string(e.condition)}
+	case and:
+		return childReasons(e, n)
+	case or:
+		return childReasons(e, n)
+	}
+	return nil
+}
+
+func childReasons(es []ConditionExpression, n *core.Node) []string {
+	r := []string{}
+	for _, e := range es {
+		r = append(r, conditionReasons(e, n)...)
+	}
+	return r
+}
@@ -0,0 +1,286 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newConditionNode(conditions ...string) *core.Node {
+	n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}
+	for _, c := range conditions {
+		n.Status.Conditions = append(n.Status.Conditions, core.NodeCondition{Type: core.NodeConditionType(c), Status: core.ConditionTrue})
+	}
+	return n
+}
+
+func TestConditionExpression(t *testing.T) {
+	cases := []struct {
+		name       string
+		expression string
+		node       *core.Node
+		want       bool
+		wantString string
+		wantErr    bool
+	}{
+		{
+			name:       "SingleCondition",
+			expression: "KernelDeadlock",
+			node:       newConditionNode("KernelDeadlock"),
+			want:       true,
+			wantString: "KernelDeadlock",
+		},
+		{
+			name:       "SingleConditionNotTrue",
+			expression: "KernelDeadlock",
+			node: &core.Node{Status: core.NodeStatus{Conditions: []core.NodeCondition{
+				{Type: "KernelDeadlock", Status: core.ConditionFalse},
+			}}},
+			want:       false,
+			wantString: "KernelDeadlock",
+		},
+		{
+			name:       "OrAndNot",
+			expression: "(KernelDeadlock OR ReadonlyFilesystem) AND NOT UnderMaintenance",
+			node:       newConditionNode("ReadonlyFilesystem"),
+			want:       true,
+			wantString: "((KernelDeadlock OR ReadonlyFilesystem) AND NOT UnderMaintenance)",
+		},
+		{
+			name:       "OrAndNotUnderMaintenance",
+			expression: "(KernelDeadlock OR ReadonlyFilesystem) AND NOT UnderMaintenance",
+			node:       newConditionNode("ReadonlyFilesystem", "UnderMaintenance"),
+			want:       false,
+			wantString: "((KernelDeadlock OR ReadonlyFilesystem) AND NOT UnderMaintenance)",
+		},
+		{
+			name:       "AndBindsTighterThanOr",
+			expression: "A OR B AND C",
+			node:       newConditionNode("A"),
+			want:       true,
+			wantString: "(A OR (B AND C))",
+		},
+		{
+			name:       "DoubleNegative",
+			expression: "NOT NOT A",
+			node:       newConditionNode("A"),
+			want:       true,
+			wantString: "NOT NOT A",
+		},
+		{
+			name:       "ExplicitStatus",
+			expression: "Ready=Unknown",
+			node: &core.Node{Status: core.NodeStatus{Conditions: []core.NodeCondition{
+				{Type: "Ready", Status: core.ConditionUnknown},
+			}}},
+			want:       true,
+			wantString: "Ready=Unknown",
+		},
+		{
+			name:       "ExplicitStatusMismatch",
+			expression: "Ready=Unknown",
+			node:       newConditionNode("Ready"),
+			want:       false,
+			wantString: "Ready=Unknown",
+		},
+		{
+			name:       "DurationElapsed",
+			expression: "KernelDeadlock,10m",
+			node: &core.Node{Status: core.NodeStatus{Conditions: []core.NodeCondition{
+				{Type: "KernelDeadlock", Status: core.ConditionTrue, LastTransitionTime: meta.NewTime(time.Now().Add(-11 * time.Minute))},
+			}}},
+			want:       true,
+			wantString: "KernelDeadlock,10m0s",
+		},
+		{
+			name:       "DurationNotElapsed",
+			expression: "NOT Ready=Unknown,10m",
+			node: &core.Node{Status: core.NodeStatus{Conditions: []core.NodeCondition{
+				{Type: "Ready", Status: core.ConditionUnknown, LastTransitionTime: meta.NewTime(time.Now().Add(-9 * time.Minute))},
+			}}},
+			want:       true,
+			wantString: "NOT Ready=Unknown,10m0s",
+		},
+		{
+			name:       "InvalidDuration",
+			expression: "KernelDeadlock,soon",
+			wantErr:    true,
+		},
+		{
+			name:       "InvalidStatus",
+			expression: "Ready=Maybe",
+			wantErr:    true,
+		},
+		{
+			name:       "MissingConditionName",
+			expression: "=True",
+			wantErr:    true,
+		},
+		{
+			name:       "Empty",
+			expression: " ",
+			wantErr:    true,
+		},
+		{
+			name:       "UnclosedParenthesis",
+			expression: "(A OR B",
+			wantErr:    true,
+		},
+		{
+			name:       "UnexpectedParenthesis",
+			expression: "A OR B)",
+			wantErr:    true,
+		},
+		{
+			name:       "DanglingOperator",
+			expression: "A AND",
+			wantErr:    true,
+		},
+		{
+			name:       "MissingOperator",
+			expression: "A B",
+			wantErr:    true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			e, err := ParseConditionExpression(tc.expression)
+			if err != nil {
+				if tc.wantErr {
+					return
+				}
+				t.Fatalf("ParseConditionExpression(%v): %v", tc.expression, err)
+			}
+			if tc.wantErr {
+				t.Fatalf("ParseConditionExpression(%v): want error", tc.expression)
+			}
+			if got := e.String(); got != tc.wantString {
+				t.Errorf("e.String(): want %v, got %v", tc.wantString, got)
+			}
+			if got := e.Evaluate(tc.node); got != tc.want {
+				t.Errorf("e.Evaluate(): want %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestNodeConditionExpressionFilter(t *testing.T) {
+	a, err := ParseConditionExpression("A AND NOT B")
+	if err != nil {
+		t.Fatalf("ParseConditionExpression(): %v", err)
+	}
+	c, err := ParseConditionExpression("C")
+	if err != nil {
+		t.Fatalf("ParseConditionExpression(): %v", err)
+	}
+
+	cases := []struct {
+		name         string
+		obj          interface{}
+		expressions  []ConditionExpression
+		passesFilter bool
+	}{
+		{
+			name:         "FirstExpressionTrue",
+			obj:          newConditionNode("A"),
+			expressions:  []ConditionExpression{a, c},
+			passesFilter: true,
+		},
+		{
+			name:         "SecondExpressionTrue",
+			obj:          newConditionNode("A", "B", "C"),
+			expressions:  []ConditionExpression{a, c},
+			passesFilter: true,
+		},
+		{
+			name:         "NoExpressionsTrue",
+			obj:          newConditionNode("A", "B"),
+			expressions:  []ConditionExpression{a, c},
+			passesFilter: false,
+		},
+		{
+			name:         "NoExpressions",
+			obj:          newConditionNode(),
+			passesFilter: true,
+		},
+		{
+			name:         "NotANode",
+			obj:          &core.Pod{},
+			expressions:  []ConditionExpression{a, c},
+			passesFilter: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			filter := NewNodeConditionExpressionFilter(tc.expressions...)
+			if filter(tc.obj) != tc.passesFilter {
+				t.Errorf("filter(tc.obj): want %v, got %v", tc.passesFilter, !tc.passesFilter)
+			}
+		})
+	}
+}
+
+func TestCordonReasonFunc(t *testing.T) {
+	a, err := ParseConditionExpression("(A OR B) AND NOT C")
+	if err != nil {
+		t.Fatalf("ParseConditionExpression(): %v", err)
+	}
+	d, err := ParseConditionExpression("D")
+	if err != nil {
+		t.Fatalf("ParseConditionExpression(): %v", err)
+	}
+	labelled := newConditionNode("A", "D")
+	labelled.SetLabels(map[string]string{"cool": "very"})
+
+	cases := []struct {
+		name string
+		node *core.Node
+		want []string
+	}{
+		{
+			name: "SeveralExpressionsTrue",
+			node: newConditionNode("A", "B", "D"),
+			want: []string{"A", "B", "D"},
+		},
+		{
+			name: "NegatedConditionTrue",
+			node: newConditionNode("A", "C"),
+			want: []string{},
+		},
+		{
+			name: "LabelMatches",
+			node: labelled,
+			want: []string{"A", "D", "cool=very"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := NewCordonReasonFunc([]ConditionExpression{a, d}, map[string]string{"cool": "very"})(tc.node)
+			if diff := deep.Equal(tc.want, got); diff != nil {
+				t.Errorf("NewCordonReasonFunc(...)(n): want != got: %v", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// DefaultConditionProbeInterval is the default time between probes of
+	// a ConditionSource.
+	DefaultConditionProbeInterval = 1 * time.Minute
+
+	conditionReasonProbeFiring   = "DrainoProbeFiring"
+	conditionReasonProbeResolved = "DrainoProbeResolved"
+)
+
+// A ConditionProber determines which nodes currently exhibit a problem that
+// is not otherwise surfaced as a node condition.
+type ConditionProber interface {
+	// Probe the supplied nodes. Returns a map of the names of nodes that
+	// currently exhibit the problem to a message describing the problem.
+	Probe(nodes []*core.Node) (map[string]string, error)
+}
+
+// A ConditionSource periodically probes nodes for a problem and sets a node
+// condition on each node reflecting the result of the probe. This allows
+// draino (and anything else) to act on signals that never become node
+// conditions.
+type ConditionSource struct {
+	l         *zap.Logger
+	c         kubernetes.Interface
+	nodes     NodeStore
+	condition core.NodeConditionType
+	prober    ConditionProber
+	interval  time.Duration
+}
+
+// ConditionSourceOption configures a ConditionSource.
+type ConditionSourceOption func(s *ConditionSource)
+
+// WithConditionSourceLogger configures a ConditionSource to use the supplied
+// logger.
+func WithConditionSourceLogger(l *zap.Logger) ConditionSourceOption {
+	return func(s *ConditionSource) {
+		s.l = l
+	}
+}
+
+// WithProbeInterval configures the time between probes.
+func WithProbeInterval(i time.Duration) ConditionSourceOption {
+	return func(s *ConditionSource) {
+		s.interval = i
+	}
+}
+
+// NewConditionSource returns a ConditionSource that uses the supplied prober
+// to set the supplied condition on nodes.
+func NewConditionSource(c kubernetes.Interface, nodes NodeStore, condition string, p ConditionProber, so ...ConditionSourceOption) *ConditionSource {
+	s := &ConditionSource{
+		l:         zap.NewNop(),
+		c:         c,
+		nodes:     nodes,
+		condition: core.NodeConditionType(condition),
+		prober:    p,
+		interval:  DefaultConditionProbeInterval,
+	}
+	for _, o := range so {
+		o(s)
+	}
+	return s
+}
+
+// Run the condition source until the supplied channel is closed.
+func (s *ConditionSource) Run(stop <-chan struct{}) {
+	wait.Until(func() {
+		if err := s.probe(); err != nil {
+			s.l.Info("Failed to probe nodes", zap.String("condition", string(s.condition)), zap.Error(err))
+		}
+	}, s.interval, stop)
+}
+
+func (s *ConditionSource) probe() error {
+	nodes := s.nodes.List()
+	firing, err := s.prober.Probe(nodes)
+	if err != nil {
+		return errors.Wrap(err, "cannot probe nodes")
+	}
+	for _, n := range nodes {
+		msg, isFiring := firing[n.GetName()]
+		c := s.current(n)
+		wasFiring := c != nil && c.Status == core.ConditionTrue
+
+		switch {
+		case isFiring:
+			// Set the condition even if it was already firing in order
+			// to update its heartbeat and message.
+			s.l.Debug("Probe firing", zap.String("node", n.GetName()), zap.String("condition", string(s.condition)), zap.String("message", msg))
+			err = s.set(n, c, core.ConditionTrue, conditionReasonProbeFiring, msg)
+		case wasFiring:
+			s.l.Info("Probe resolved", zap.String("node", n.GetName()), zap.String("condition", string(s.condition)))
+			err = s.set(n, c, core.ConditionFalse, conditionReasonProbeResolved, "Probe no longer firing")
+		default:
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "cannot set condition %s on node %s", s.condition, n.GetName())
+		}
+	}
+	return nil
+}
+
+func (s *ConditionSource) current(n *core.Node) *core.NodeCondition {
+	for i := range n.Status.Conditions {
+		if n.Status.Conditions[i].Type == s.condition {
+			return &n.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+func (s *ConditionSource) set(n *core.Node, current *core.NodeCondition, status core.ConditionStatus, reason, msg string) error {
+	now := meta.Now()
+	c := core.NodeCondition{
+		Type:               s.condition,
+		Status:             status,
+		LastHeartbeatTime:  now,
+		LastTransitionTime: now,
+		Reason:             reason,
+		Message:            msg,
+	}
+	if current != nil && current.Status == status {
+		c.LastTransitionTime = current.LastTransitionTime
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{"conditions": []core.NodeCondition{c}},
+	})
+	if err != nil {
+		return errors.Wrap(err, "cannot marshal node status patch")
+	}
+	_, err = s.c.CoreV1().Nodes().PatchStatus(n.GetName(), patch)
+	return errors.Wrap(err, "cannot patch node status")
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+const conditionProbe = "ProbeFailed"
+
+// staticNodeStore is a NodeStore containing a fixed set of nodes.
+type staticNodeStore []*core.Node
+
+func (s staticNodeStore) Get(name string) (*core.Node, error) {
+	for _, n := range s {
+		if n.GetName() == name {
+			return n, nil
+		}
+	}
+	return nil, errors.Errorf("node %s does not exist", name)
+}
+
+func (s staticNodeStore) List() []*core.Node {
+	return s
+}
+
+type staticProber struct {
+	firing map[string]string
+	err    error
+}
+
+func (p staticProber) Probe(_ []*core.Node) (map[string]string, error) {
+	return p.firing, p.err
+}
+
+func TestConditionSource(t *testing.T) {
+	cases := []struct {
+		name    string
+		nodes   staticNodeStore
+		prober  ConditionProber
+		want    map[string]core.ConditionStatus
+		wantErr bool
+	}{
+		{
+			name: "ProbeFiring",
+			nodes: staticNodeStore{
+				&core.Node{ObjectMeta: meta.ObjectMeta{Name: "a"}},
+				&core.Node{ObjectMeta: meta.ObjectMeta{Name: "b"}},
+			},
+			prober: staticProber{firing: map[string]string{"a": "very broken"}},
+			want:   map[string]core.ConditionStatus{"a": core.ConditionTrue},
+		},
+		{
+			name: "ProbeResolved",
+			nodes: staticNodeStore{
+				&core.Node{
+					ObjectMeta: meta.ObjectMeta{Name: "a"},
+					Status: core.NodeStatus{Conditions: []core.NodeCondition{
+						{Type: conditionProbe, Status: core.ConditionTrue},
+					}},
+				},
+				&core.Node{
+					ObjectMeta: meta.ObjectMeta{Name: "b"},
+					Status: core.NodeStatus{Conditions: []core.NodeCondition{
+						{Type: conditionProbe, Status: core.ConditionFalse},
+					}},
+				},
+			},
+			prober: staticProber{firing: map[string]string{}},
+			want:   map[string]core.ConditionStatus{"a": core.ConditionFalse},
+		},
+		{
+			name:    "ProbeError",
+			nodes:   staticNodeStore{&core.Node{ObjectMeta: meta.ObjectMeta{Name: "a"}}},
+			prober:  staticProber{err: errors.New("nope")},
+			want:    map[string]core.ConditionStatus{},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := make(map[string]core.ConditionStatus)
+			c := &fake.Clientset{}
+			c.AddReactor("patch", "nodes", func(a clienttesting.Action) (bool, runtime.Object, error) {
+				pa := a.(clienttesting.PatchAction)
+				if pa.GetSubresource() != "status" {
+					return true, nil, errors.Errorf("incorrect subresource: %v", pa.GetSubresource())
+				}
+				patch := &core.Node{}
+				if err := json.Unmarshal(pa.GetPatch(), patch); err != nil {
+					return true, nil, err
+				}
+				for _, nc := range patch.Status.Conditions {
+					if nc.Type == conditionProbe {
+						got[pa.GetName()] = nc.Status
+					}
+				}
+				return true, patch, nil
+			})
+
+			s := NewConditionSource(c, tc.nodes, conditionProbe, tc.prober)
+			if err := s.probe(); err != nil {
+				if !tc.wantErr {
+					t.Errorf("s.probe(): %v", err)
+				}
+			}
+			if diff := deep.Equal(tc.want, got); diff != nil {
+				t.Errorf("s.probe(): want != got %v", diff)
+			}
+		})
+	}
+}
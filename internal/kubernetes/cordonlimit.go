@@ -0,0 +1,139 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+)
+
+// A CordonLimit is a maximum number of nodes, either an absolute count or a
+// percentage of matching nodes.
+type CordonLimit struct {
+	raw     string
+	count   int
+	percent float64
+}
+
+// ParseCordonLimit parses a cordon limit, either a count such as 5 or a
+// percentage such as 10%.
+func ParseCordonLimit(s string) (*CordonLimit, error) {
+	if strings.HasSuffix(s, "%") {
+		p, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil || p <= 0 || p > 100 {
+			return nil, errors.Errorf("cannot parse cordon limit %s: percentages must be greater than 0%% and at most 100%%", s)
+		}
+		return &CordonLimit{raw: s, percent: p}, nil
+	}
+	c, err := strconv.Atoi(s)
+	if err != nil || c < 1 {
+		return nil, errors.Errorf("cannot parse cordon limit %s: must be a positive integer or a percentage", s)
+	}
+	return &CordonLimit{raw: s, count: c}, nil
+}
+
+// String returns the limit as it was parsed.
+func (l *CordonLimit) String() string {
+	return l.raw
+}
+
+// max returns the maximum number of nodes of the supplied number of matching
+// nodes that may be cordoned. Percentages are rounded up, so that at least
+// one node may be cordoned.
+func (l *CordonLimit) max(matching int) int {
+	if l.percent > 0 {
+		return int(math.Ceil(float64(matching) * l.percent / 100))
+	}
+	return l.count
+}
+
+// A CordonLimiter limits how many of the matching nodes draino may hold
+// cordoned at once, regardless of how many of them are being drained.
+type CordonLimiter struct {
+	nodes    NodeStore
+	keys     Keys
+	matching func(o interface{}) bool
+	limit    *CordonLimit
+
+	mu sync.Mutex
+	// pending nodes have been reserved, but the node store does not yet
+	// reflect that they are cordoned.
+	pending map[string]bool
+}
+
+// NewCordonLimiter returns a CordonLimiter that allows no more than the
+// supplied limit of the nodes in the supplied store for which the supplied
+// filter returns true to be cordoned by draino at once. Nodes cordoned by
+// draino are determined using the supplied keys.
+func NewCordonLimiter(nodes NodeStore, k Keys, matching func(o interface{}) bool, limit *CordonLimit) *CordonLimiter {
+	return &CordonLimiter{nodes: nodes, keys: k, matching: matching, limit: limit, pending: make(map[string]bool)}
+}
+
+// Reserve returns true if the supplied node may be cordoned, in which case it
+// counts against the limit until it is released or uncordoned. Otherwise it
+// returns a description of why the node may not be cordoned. Nodes that are
+// already cordoned may always be cordoned.
+func (l *CordonLimiter) Reserve(n *core.Node) (bool, string) {
+	if n.Spec.Unschedulable {
+		return true, ""
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	matching, cordoned := 0, 0
+	stored := make(map[string]bool)
+	for _, o := range l.nodes.List() {
+		if !l.matching(o) {
+			continue
+		}
+		matching++
+		stored[o.GetName()] = l.keys.cordonedByDraino(o)
+		if stored[o.GetName()] {
+			cordoned++
+		}
+	}
+	for name := range l.pending {
+		c, ok := stored[name]
+		if !ok || c {
+			// The node is gone, or already counted.
+			delete(l.pending, name)
+			continue
+		}
+		cordoned++
+	}
+
+	if max := l.limit.max(matching); cordoned >= max {
+		return false, fmt.Sprintf("%d of %d matching nodes are already cordoned, the maximum of %s", cordoned, matching, l.limit)
+	}
+	l.pending[n.GetName()] = true
+	return true, ""
+}
+
+// Release the supplied node's reservation, for example because it could not
+// be cordoned.
+func (l *CordonLimiter) Release(n *core.Node) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.pending, n.GetName())
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestParseCordonLimit(t *testing.T) {
+	cases := []struct {
+		name    string
+		s       string
+		wantErr bool
+	}{
+		{name: "Count", s: "5"},
+		{name: "Percent", s: "10%"},
+		{name: "FractionalPercent", s: "2.5%"},
+		{name: "ZeroCount", s: "0", wantErr: true},
+		{name: "ZeroPercent", s: "0%", wantErr: true},
+		{name: "TooManyPercent", s: "101%", wantErr: true},
+		{name: "NotACount", s: "lots", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			l, err := ParseCordonLimit(tc.s)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("ParseCordonLimit(%q): want error %v, got %v", tc.s, tc.wantErr, err)
+			}
+			if err == nil && l.String() != tc.s {
+				t.Errorf("ParseCordonLimit(%q).String(): got %q", tc.s, l.String())
+			}
+		})
+	}
+}
+
+func newCordonedNode(name string, cordoned bool) *core.Node {
+	n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: name}}
+	if cordoned {
+		n.Spec.Unschedulable = true
+		n.Annotations = map[string]string{AnnotationCordoned: time.Now().Format(time.RFC3339)}
+	}
+	return n
+}
+
+func TestCordonLimiter(t *testing.T) {
+	cases := []struct {
+		name      string
+		limit     string
+		nodes     []*core.Node
+		candidate *core.Node
+		wantOK    bool
+	}{
+		{
+			name:      "UnderCount",
+			limit:     "2",
+			nodes:     []*core.Node{newCordonedNode("a", true), newCordonedNode("b", false), newCordonedNode(nodeName, false)},
+			candidate: newCordonedNode(nodeName, false),
+			wantOK:    true,
+		},
+		{
+			name:      "AtCount",
+			limit:     "1",
+			nodes:     []*core.Node{newCordonedNode("a", true), newCordonedNode("b", false), newCordonedNode(nodeName, false)},
+			candidate: newCordonedNode(nodeName, false),
+		},
+		{
+			name:      "AlreadyCordoned",
+			limit:     "1",
+			nodes:     []*core.Node{newCordonedNode("a", true), newCordonedNode(nodeName, true)},
+			candidate: newCordonedNode(nodeName, true),
+			wantOK:    true,
+		},
+		{
+			// 25% of four nodes is one node.
+			name:      "AtPercent",
+			limit:     "25%",
+			nodes:     []*core.Node{newCordonedNode("a", true), newCordonedNode("b", false), newCordonedNode("c", false), newCordonedNode(nodeName, false)},
+			candidate: newCordonedNode(nodeName, false),
+		},
+		{
+			// 10% of four nodes rounds up to one node.
+			name:      "PercentRoundsUp",
+			limit:     "10%",
+			nodes:     []*core.Node{newCordonedNode("a", false), newCordonedNode("b", false), newCordonedNode("c", false), newCordonedNode(nodeName, false)},
+			candidate: newCordonedNode(nodeName, false),
+			wantOK:    true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			limit, err := ParseCordonLimit(tc.limit)
+			if err != nil {
+				t.Fatalf("ParseCordonLimit(%q): %v", tc.limit, err)
+			}
+			l := NewCordonLimiter(staticNodeStore(tc.nodes), DefaultKeys(), func(_ interface{}) bool { return true }, limit)
+			ok, reason := l.Reserve(tc.candidate)
+			if ok != tc.wantOK {
+				t.Errorf("l.Reserve(%v): want %v, got %v: %s", tc.candidate.GetName(), tc.wantOK, ok, reason)
+			}
+		})
+	}
+}
+
+func TestCordonLimiterPending(t *testing.T) {
+	limit, _ := ParseCordonLimit("1")
+	nodes := staticNodeStore{newCordonedNode("a", false), newCordonedNode("b", false)}
+	l := NewCordonLimiter(nodes, DefaultKeys(), func(_ interface{}) bool { return true }, limit)
+
+	// The node store does not yet reflect the first node's cordon, but its
+	// reservation counts against the limit.
+	if ok, reason := l.Reserve(nodes[0]); !ok {
+		t.Fatalf("l.Reserve(%v): %s", nodes[0].GetName(), reason)
+	}
+	if ok, _ := l.Reserve(nodes[1]); ok {
+		t.Errorf("l.Reserve(%v): want false, got true", nodes[1].GetName())
+	}
+
+	l.Release(nodes[0])
+	if ok, reason := l.Reserve(nodes[1]); !ok {
+		t.Errorf("l.Reserve(%v) after release: %s", nodes[1].GetName(), reason)
+	}
+}
+
+func TestDrainingResourceEventHandlerCordonLimit(t *testing.T) {
+	limit, _ := ParseCordonLimit("1")
+	cordoned := newCordonedNode("a", true)
+	n := newCordonedNode(nodeName, false)
+	l := NewCordonLimiter(staticNodeStore{cordoned, n}, DefaultKeys(), func(_ interface{}) bool { return true }, limit)
+
+	e := record.NewFakeRecorder(10)
+	h := NewDrainingResourceEventHandler(&NoopCordonDrainer{}, e, WithDrainScheduler(NewDrainScheduler()), WithCordonLimiter(l))
+	for i := 0; i < 2; i++ {
+		if err := h.Request(n, time.Time{}, nil); err == nil {
+			t.Errorf("h.Request(%v): want error, got nil", n.GetName())
+		}
+	}
+
+	// A cordon deferred repeatedly for the same reason is recorded once.
+	if len(e.Events) != 1 {
+		t.Errorf("events: want 1, got %d", len(e.Events))
+	}
+}
@@ -17,9 +17,18 @@ and limitations under the License.
 package kubernetes
 
 import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"go.opencensus.io/trace"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
 	core "k8s.io/api/core/v1"
 	policy "k8s.io/api/policy/v1beta1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
@@ -27,6 +36,7 @@ import (
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 )
 
 // Default pod eviction settings.
@@ -34,9 +44,47 @@ const (
 	DefaultMaxGracePeriod   time.Duration = 8 * time.Minute
 	DefaultEvictionOverhead time.Duration = 30 * time.Second
 
+	// DefaultRemoveFinalizersAfter is shorter than DefaultEvictionOverhead,
+	// so that finalizers are removed while draino awaits the pod's deletion.
+	DefaultRemoveFinalizersAfter time.Duration = 10 * time.Second
+
 	kindDaemonSet = "DaemonSet"
 )
 
+// Grace period policies.
+const (
+	// GracePeriodPolicyPod evicts pods with their own termination grace
+	// period, regardless of MaxGracePeriod.
+	GracePeriodPolicyPod = "pod"
+
+	// GracePeriodPolicyCap evicts pods with their own termination grace
+	// period, or MaxGracePeriod if it is shorter.
+	GracePeriodPolicyCap = "cap"
+
+	// GracePeriodPolicyOverride evicts all pods with MaxGracePeriod.
+	GracePeriodPolicyOverride = "override"
+)
+
+// Cluster autoscaler coordination.
+const (
+	// AnnotationAutoscalerScaleDownDisabled prevents the cluster autoscaler
+	// from scaling down the annotated node.
+	AnnotationAutoscalerScaleDownDisabled = "cluster-autoscaler.kubernetes.io/scale-down-disabled"
+
+	// TaintAutoscalerToBeDeleted is added by the cluster autoscaler to nodes
+	// it is draining in order to scale them down.
+	TaintAutoscalerToBeDeleted = "ToBeDeletedByClusterAutoscaler"
+)
+
+const (
+	eventReasonPodEvicted        = "PodEvicted"
+	eventReasonDrainWaitingOnPDB = "DrainWaitingOnPDB"
+)
+
+// errEvictionAborted is returned when a pod's eviction is aborted because its
+// drain failed or timed out.
+var errEvictionAborted = errors.New("pod eviction aborted")
+
 type errTimeout struct{}
 
 func (e errTimeout) Error() string {
@@ -54,6 +102,24 @@ func IsTimeout(err error) bool {
 	return ok
 }
 
+type errTooManyPods struct{}
+
+func (e errTooManyPods) Error() string {
+	return "too many pods"
+}
+
+func (e errTooManyPods) TooManyPods() {}
+
+// IsTooManyPods returns true if the supplied error was caused by a node running
+// more pods than MaxPodsToEvict allows.
+func IsTooManyPods(err error) bool {
+	err = errors.Cause(err)
+	_, ok := err.(interface {
+		TooManyPods()
+	})
+	return ok
+}
+
 // A Cordoner cordons nodes.
 type Cordoner interface {
 	// Cordon the supplied node. Marks it unschedulable for new pods.
@@ -85,32 +151,137 @@ func (d *NoopCordonDrainer) Drain(n *core.Node) error { return nil }
 type APICordonDrainer struct {
 	c kubernetes.Interface
 
+	keys Keys
+
 	filter PodFilterFunc
 
-	maxGracePeriod   time.Duration
-	evictionHeadroom time.Duration
+	l *zap.Logger
+
+	gracePeriodPolicy string
+	maxGracePeriod    time.Duration
+	evictionHeadroom  time.Duration
+	drainTimeoutBase  time.Duration
+	drainTimeoutPod   time.Duration
+
+	podDeletionTimeout time.Duration
+
+	maxPods int
+
+	namespaceLimits *namespaceLimiter
+	throttle        *evictionThrottle
+
+	retries    int
+	retryDelay time.Duration
+
+	disableScaleDown bool
+	markDrains       bool
+	deferTo          func(o interface{}) bool
+	reasons          func(n *core.Node) []string
+
+	removeFinalizers     []string
+	removeFinalizerAfter time.Duration
+
+	replicaPods PodStore
+
+	batchByBudget bool
+
+	progressInterval time.Duration
+
+	aborted func(n *core.Node) <-chan struct{}
+
+	e record.EventRecorder
+
+	refusals record.EventRecorder
+	refused  func(p core.Pod) string
+}
+
+// namespaceLimiter limits the rate of evictions per namespace.
+type namespaceLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mu sync.Mutex
+	l  map[string]*rate.Limiter
+}
+
+func (n *namespaceLimiter) get(namespace string) *rate.Limiter {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.l[namespace]; !ok {
+		n.l[namespace] = rate.NewLimiter(n.limit, n.burst)
+	}
+	return n.l[namespace]
 }
 
 // APICordonDrainerOption configures an APICordonDrainer.
 type APICordonDrainerOption func(d *APICordonDrainer)
 
+// WithDrainerLogger configures a logger, which logs the grace period with which
+// each pod is evicted.
+func WithDrainerLogger(l *zap.Logger) APICordonDrainerOption {
+	return func(d *APICordonDrainer) {
+		d.l = l
+	}
+}
+
 // MaxGracePeriod configures the maximum time to wait for a pod eviction. Pod
 // containers will be allowed this much time to shutdown once they receive a
-// SIGTERM before they are sent a SIGKILL.
+// SIGTERM before they are sent a SIGKILL. Nodes may override it using the
+// AnnotationMaxGracePeriod annotation or label.
 func MaxGracePeriod(m time.Duration) APICordonDrainerOption {
 	return func(d *APICordonDrainer) {
 		d.maxGracePeriod = m
 	}
 }
 
+// WithGracePeriodPolicy configures how MaxGracePeriod determines the grace
+// period with which pods are evicted.
+func WithGracePeriodPolicy(policy string) APICordonDrainerOption {
+	return func(d *APICordonDrainer) {
+		d.gracePeriodPolicy = policy
+	}
+}
+
 // EvictionHeadroom configures an amount of time to wait in addition to the
-// MaxGracePeriod for the API server to report a pod deleted.
+// MaxGracePeriod for the API server to report a pod deleted. Nodes may override
+// it using the AnnotationEvictionHeadroom annotation or label.
 func EvictionHeadroom(h time.Duration) APICordonDrainerOption {
 	return func(d *APICordonDrainer) {
 		d.evictionHeadroom = h
 	}
 }
 
+// PodDeletionTimeout configures the time to wait for each evicted pod to be
+// deleted, rather than its grace period plus EvictionHeadroom. Pods that are
+// not deleted in time fail the drain, and are counted by
+// MeasurePodDeletionTimeouts.
+func PodDeletionTimeout(t time.Duration) APICordonDrainerOption {
+	return func(d *APICordonDrainer) {
+		d.podDeletionTimeout = t
+	}
+}
+
+// DrainTimeout configures the time to wait for all of a node's pods to be
+// evicted as a base duration plus an increment per pod to be evicted, rather
+// than MaxGracePeriod plus EvictionHeadroom, so that drains of dense nodes are
+// allowed longer than drains of nearly empty nodes. The base should allow for
+// the termination grace period of the node's pods.
+func DrainTimeout(base, perPod time.Duration) APICordonDrainerOption {
+	return func(d *APICordonDrainer) {
+		d.drainTimeoutBase = base
+		d.drainTimeoutPod = perPod
+	}
+}
+
+// MaxPodsToEvict configures the maximum number of pods that may be evicted in
+// order to drain a node. Drains that would evict more pods fail without
+// evicting any, unless the node is annotated with AnnotationForceDrain.
+func MaxPodsToEvict(n int) APICordonDrainerOption {
+	return func(d *APICordonDrainer) {
+		d.maxPods = n
+	}
+}
+
 // WithPodFilter configures a filter that may be used to exclude certain pods
 // from eviction when draining.
 func WithPodFilter(f PodFilterFunc) APICordonDrainerOption {
@@ -119,14 +290,168 @@ func WithPodFilter(f PodFilterFunc) APICordonDrainerOption {
 	}
 }
 
+// WithNamespaceEvictionLimit limits evictions of pods in any one namespace to
+// the supplied number per period, across all drains. Evictions that would
+// exceed the limit wait until they are permitted.
+func WithNamespaceEvictionLimit(evictions int, per time.Duration) APICordonDrainerOption {
+	return func(d *APICordonDrainer) {
+		d.namespaceLimits = &namespaceLimiter{
+			limit: rate.Every(per / time.Duration(evictions)),
+			burst: evictions,
+			l:     make(map[string]*rate.Limiter),
+		}
+	}
+}
+
+// WithAdaptiveEvictionRate limits evictions to the supplied number per second,
+// across all drains. The rate is reduced each time the API server throttles an
+// eviction, for example due to API Priority and Fairness, and recovers as
+// evictions are issued without being throttled.
+func WithAdaptiveEvictionRate(perSecond float64) APICordonDrainerOption {
+	return func(d *APICordonDrainer) {
+		d.throttle = newEvictionThrottle(perSecond)
+	}
+}
+
+// WithAutoscalerScaleDownDisabled prevents the cluster autoscaler from scaling
+// down nodes while they are being drained, by annotating them with
+// AnnotationAutoscalerScaleDownDisabled. The annotation is removed once the
+// drain finishes, unless it was present before the drain started.
+func WithAutoscalerScaleDownDisabled() APICordonDrainerOption {
+	return func(d *APICordonDrainer) {
+		d.disableScaleDown = true
+	}
+}
+
+// WithDeferTo prevents the drainer cordoning or draining nodes that pass the
+// supplied filter, for example a NewNodeForeignDrainFilter. Each node is
+// fetched from the API server and checked immediately before it is cordoned
+// and again before its drain starts, so that a node another controller began
+// draining after draino decided to act on it is left to that controller.
+func WithDeferTo(filter func(o interface{}) bool) APICordonDrainerOption {
+	return func(d *APICordonDrainer) {
+		d.deferTo = filter
+	}
+}
+
+// WithDrainMarker annotates nodes with AnnotationDrainInProgress from the time
+// they are cordoned until their drain finishes, successfully or otherwise. A
+// node that is still annotated was abandoned mid-drain, for example because
+// draino was shut down. Nodes whose drain failed are annotated with
+// AnnotationDrainFailed until they are drained. Cordoned nodes are also
+// annotated with AnnotationCordoned, AnnotationBootID, and AnnotationMachineID,
+// which remain until the node is uncordoned.
+func WithDrainMarker() APICordonDrainerOption {
+	return func(d *APICordonDrainer) {
+		d.markDrains = true
+	}
+}
+
+// WithCordonReasonAnnotation annotates nodes with AnnotationCordonReason when
+// they are cordoned, using the supplied function to determine why they were
+// cordoned. Nodes are not annotated if the function returns no reasons.
+func WithCordonReasonAnnotation(fn func(n *core.Node) []string) APICordonDrainerOption {
+	return func(d *APICordonDrainer) {
+		d.reasons = fn
+	}
+}
+
+// WithFinalizerRemoval configures an APICordonDrainer to remove any of the
+// supplied finalizers from evicted pods that still exist the supplied duration
+// after their termination grace period has elapsed, for example finalizers of
+// controllers known to leave pods stuck. Other finalizers are never removed.
+func WithFinalizerRemoval(after time.Duration, finalizers ...string) APICordonDrainerOption {
+	return func(d *APICordonDrainer) {
+		d.removeFinalizerAfter = after
+		d.removeFinalizers = finalizers
+	}
+}
+
+// WithDrainProgress configures the drainer to record the progress of each
+// drain in the node's AnnotationDrainProgress when the drain starts, and then
+// at the supplied interval until it finishes.
+func WithDrainProgress(interval time.Duration) APICordonDrainerOption {
+	return func(d *APICordonDrainer) {
+		d.progressInterval = interval
+	}
+}
+
+// WithEvictionEvents configures the drainer to record a PodEvicted event about
+// each pod it evicts, and a DrainWaitingOnPDB event about each pod whose
+// eviction a pod disruption budget refuses. Together with the events the
+// DrainingResourceEventHandler records about the node, these describe each
+// step of a drain. Events are recorded about pods rather than the node so that
+// they are not aggregated when many pods are evicted.
+func WithEvictionEvents(e record.EventRecorder) APICordonDrainerOption {
+	return func(d *APICordonDrainer) {
+		d.e = e
+	}
+}
+
+// WithEvictionRefusedEvents configures the drainer to record an
+// EvictionRefused event about each pod that its pod filter excludes from a
+// drain, if the supplied function describes why the pod was not evicted, for
+// example a NewSoleEndpointRefusal. Events are recorded only when pods are
+// actually being evicted; the pod filter itself has no side effects.
+func WithEvictionRefusedEvents(e record.EventRecorder, why func(p core.Pod) string) APICordonDrainerOption {
+	return func(d *APICordonDrainer) {
+		d.refusals = e
+		d.refused = why
+	}
+}
+
+// WithBudgetBatching configures the drainer to evict the pods of a node that
+// a pod disruption budget selects in batches, each as large as the budget
+// currently allows, rather than evicting them all at once and retrying those
+// the budget refuses.
+func WithBudgetBatching() APICordonDrainerOption {
+	return func(d *APICordonDrainer) {
+		d.batchByBudget = true
+	}
+}
+
+// WithLastReadyReplicaDeferral configures an APICordonDrainer to wait to
+// evict pods that are the last ready replica of their workload, per the
+// supplied PodStore, until another of the workload's replicas is ready, much
+// as evictions wait for a pod disruption budget to allow them. The drain fails
+// if no other replica becomes ready before it times out.
+func WithLastReadyReplicaDeferral(pods PodStore) APICordonDrainerOption {
+	return func(d *APICordonDrainer) {
+		d.replicaPods = pods
+	}
+}
+
+// WithDrainAbort configures an APICordonDrainer to abort the drain of each node
+// once the channel the supplied function returns for the node is closed, for
+// example because the node's NodeLeaseLock was lost. Evictions that have
+// already been requested are not undone.
+func WithDrainAbort(fn func(n *core.Node) <-chan struct{}) APICordonDrainerOption {
+	return func(d *APICordonDrainer) {
+		d.aborted = fn
+	}
+}
+
+// WithDrainerKeys configures the annotation keys an APICordonDrainer reads and
+// writes.
+func WithDrainerKeys(k Keys) APICordonDrainerOption {
+	return func(d *APICordonDrainer) {
+		d.keys = k
+	}
+}
+
 // NewAPICordonDrainer returns a CordonDrainer that cordons and drains nodes via
 // the Kubernetes API.
 func NewAPICordonDrainer(c kubernetes.Interface, ao ...APICordonDrainerOption) *APICordonDrainer {
 	d := &APICordonDrainer{
-		c:                c,
-		filter:           NewPodFilters(),
-		maxGracePeriod:   DefaultMaxGracePeriod,
-		evictionHeadroom: DefaultEvictionOverhead,
+		c:                 c,
+		keys:              DefaultKeys(),
+		l:                 zap.NewNop(),
+		filter:            NewPodFilters(),
+		gracePeriodPolicy: GracePeriodPolicyCap,
+		maxGracePeriod:    DefaultMaxGracePeriod,
+		evictionHeadroom:  DefaultEvictionOverhead,
+		retries:           DefaultAPIRetries,
+		retryDelay:        DefaultAPIRetryDelay,
 	}
 	for _, o := range ao {
 		o(d)
@@ -134,20 +459,159 @@ func NewAPICordonDrainer(c kubernetes.Interface, ao ...APICordonDrainerOption) *
 	return d
 }
 
-func (d *APICordonDrainer) deleteTimeout() time.Duration {
-	return d.maxGracePeriod + d.evictionHeadroom
+// evictionTiming is the grace period policy, maximum grace period, eviction
+// headroom, and deletion timeout of the pods evicted from a node, and the key
+// of the annotation with which pods may override their grace period.
+type evictionTiming struct {
+	gracePeriodKey     string
+	gracePeriodPolicy  string
+	maxGracePeriod     time.Duration
+	evictionHeadroom   time.Duration
+	podDeletionTimeout time.Duration
+}
+
+// gracePeriodFor returns the grace period with which the supplied pod is
+// evicted; the grace period it is annotated with using AnnotationGracePeriod,
+// or else its termination grace period. The maximum grace period bounds it per
+// GracePeriodPolicyCap, or replaces it per GracePeriodPolicyOverride. Pods that
+// specify neither are evicted with the maximum grace period, or the default
+// termination grace period per GracePeriodPolicyPod.
+func (t evictionTiming) gracePeriodFor(p core.Pod) time.Duration {
+	if t.gracePeriodPolicy == GracePeriodPolicyOverride {
+		return t.maxGracePeriod
+	}
+	grace := t.maxGracePeriod
+	if t.gracePeriodPolicy == GracePeriodPolicyPod {
+		grace = defaultTerminationGracePeriod
+	}
+	if p.Spec.TerminationGracePeriodSeconds != nil {
+		grace = time.Duration(*p.Spec.TerminationGracePeriodSeconds) * time.Second
+	}
+	if g, ok := gracePeriodOverride(p, t.gracePeriodKey); ok {
+		grace = g
+	}
+	if t.gracePeriodPolicy != GracePeriodPolicyPod && grace > t.maxGracePeriod {
+		grace = t.maxGracePeriod
+	}
+	return grace
+}
+
+// gracePeriodOverride returns the grace period the supplied pod is annotated
+// with using the supplied key, if any. Invalid grace periods are ignored.
+func gracePeriodOverride(p core.Pod, key string) (time.Duration, bool) {
+	v, ok := p.GetAnnotations()[key]
+	if !ok {
+		return 0, false
+	}
+	g, err := time.ParseDuration(v)
+	if err != nil || g < 0 {
+		return 0, false
+	}
+	return g, true
+}
+
+// deleteTimeout returns how long to wait for the supplied pod to be deleted
+// once it has been evicted; the pod deletion timeout if one is configured, or
+// else the maximum grace period, or the pod's grace period if it is longer,
+// plus the eviction headroom.
+func (t evictionTiming) deleteTimeout(p core.Pod) time.Duration {
+	if t.podDeletionTimeout > 0 {
+		return t.podDeletionTimeout
+	}
+	timeout := t.maxGracePeriod
+	if grace := t.gracePeriodFor(p); grace > timeout {
+		timeout = grace
+	}
+	return timeout + t.evictionHeadroom
+}
+
+// timingFor returns the eviction timing of the supplied node. Nodes may
+// override MaxGracePeriod and EvictionHeadroom using the
+// AnnotationMaxGracePeriod and AnnotationEvictionHeadroom annotations or
+// labels. Invalid overrides are ignored.
+func (d *APICordonDrainer) timingFor(n *core.Node) evictionTiming {
+	return evictionTiming{
+		gracePeriodKey:     d.keys.GracePeriod,
+		gracePeriodPolicy:  d.gracePeriodPolicy,
+		maxGracePeriod:     durationOverride(n, d.keys.MaxGracePeriod, d.maxGracePeriod),
+		evictionHeadroom:   durationOverride(n, d.keys.EvictionHeadroom, d.evictionHeadroom),
+		podDeletionTimeout: d.podDeletionTimeout,
+	}
+}
+
+// durationOverride returns the duration the supplied node is annotated or
+// labelled with using the supplied key, or the supplied default if it is not
+// annotated, labelled, or the duration is invalid.
+func durationOverride(n *core.Node, key string, def time.Duration) time.Duration {
+	v, ok := n.GetAnnotations()[key]
+	if !ok {
+		v, ok = n.GetLabels()[key]
+	}
+	if !ok {
+		return def
+	}
+	o, err := time.ParseDuration(v)
+	if err != nil || o < 0 {
+		return def
+	}
+	return o
+}
+
+func (d *APICordonDrainer) drainTimeout(t evictionTiming, pods []core.Pod) time.Duration {
+	if d.drainTimeoutPod > 0 {
+		return d.drainTimeoutBase + time.Duration(len(pods))*d.drainTimeoutPod
+	}
+	timeout := t.deleteTimeout(core.Pod{})
+	for _, p := range pods {
+		if dt := t.deleteTimeout(p); dt > timeout {
+			timeout = dt
+		}
+	}
+	return timeout
 }
 
 // Cordon the supplied node. Marks it unschedulable for new pods.
-func (d *APICordonDrainer) Cordon(n *core.Node) error {
+func (d *APICordonDrainer) Cordon(n *core.Node) (err error) {
+	_, span := trace.StartSpan(context.Background(), SpanCordon)
+	span.AddAttributes(trace.StringAttribute(attributeNode, n.GetName()))
+	defer func() { endSpan(span, err) }()
+	return d.retry(func() error { return d.cordon(n) })
+}
+
+func (d *APICordonDrainer) cordon(n *core.Node) error {
 	fresh, err := d.c.CoreV1().Nodes().Get(n.GetName(), meta.GetOptions{})
 	if err != nil {
 		return errors.Wrapf(err, "cannot get node %s", n.GetName())
 	}
+	if d.deferTo != nil && d.deferTo(fresh) {
+		return errors.Errorf("cannot cordon node %s: another controller is draining it", fresh.GetName())
+	}
 	if fresh.Spec.Unschedulable {
 		return nil
 	}
 	fresh.Spec.Unschedulable = true
+	if d.reasons != nil {
+		if r := d.reasons(fresh); len(r) > 0 {
+			if fresh.Annotations == nil {
+				fresh.Annotations = make(map[string]string)
+			}
+			fresh.Annotations[d.keys.CordonReason] = strings.Join(r, ",")
+		}
+	}
+	if d.markDrains {
+		if fresh.Annotations == nil {
+			fresh.Annotations = make(map[string]string)
+		}
+		now := time.Now().UTC().Format(time.RFC3339)
+		fresh.Annotations[d.keys.Cordoned] = now
+		fresh.Annotations[d.keys.DrainInProgress] = now
+		if id := fresh.Status.NodeInfo.BootID; id != "" {
+			fresh.Annotations[d.keys.BootID] = id
+		}
+		if id := fresh.Status.NodeInfo.MachineID; id != "" {
+			fresh.Annotations[d.keys.MachineID] = id
+		}
+	}
 	if _, err := d.c.CoreV1().Nodes().Update(fresh); err != nil {
 		return errors.Wrapf(err, "cannot cordon node %s", fresh.GetName())
 	}
@@ -155,95 +619,451 @@ func (d *APICordonDrainer) Cordon(n *core.Node) error {
 }
 
 // Drain the supplied node. Evicts the node of all but mirror and DaemonSet pods.
-func (d *APICordonDrainer) Drain(n *core.Node) error {
+func (d *APICordonDrainer) Drain(n *core.Node) (err error) {
+	ctx, span := trace.StartSpan(context.Background(), SpanDrain)
+	span.AddAttributes(trace.StringAttribute(attributeNode, n.GetName()))
+	start := time.Now()
+	defer func() {
+		endSpan(span, err)
+		recordDrain(time.Since(start), err)
+	}()
+	if d.deferTo != nil {
+		var fresh *core.Node
+		err := d.retry(func() (err error) {
+			fresh, err = d.c.CoreV1().Nodes().Get(n.GetName(), meta.GetOptions{})
+			return err
+		})
+		if err != nil {
+			return errors.Wrapf(err, "cannot get node %s", n.GetName())
+		}
+		if d.deferTo(fresh) {
+			return errors.Errorf("cannot drain node %s: another controller is draining it", fresh.GetName())
+		}
+	}
+	if d.markDrains {
+		if _, err := d.setAnnotation(n, d.keys.DrainInProgress, time.Now().UTC().Format(time.RFC3339)); err != nil {
+			return errors.Wrapf(err, "cannot mark drain of node %s in progress", n.GetName())
+		}
+		defer func() {
+			if rerr := d.finishDrain(n, err != nil && !IsTooManyPods(err)); rerr != nil && err == nil {
+				err = errors.Wrapf(rerr, "cannot mark drain of node %s finished", n.GetName())
+			}
+		}()
+	}
+	if !d.disableScaleDown {
+		return d.drain(ctx, n)
+	}
+	added, err := d.setAnnotation(n, AnnotationAutoscalerScaleDownDisabled, "true")
+	if err != nil {
+		return errors.Wrapf(err, "cannot disable cluster autoscaler scale down of node %s", n.GetName())
+	}
+	err = d.drain(ctx, n)
+	if !added {
+		return err
+	}
+	if rerr := d.removeAnnotation(n, AnnotationAutoscalerScaleDownDisabled); rerr != nil && err == nil {
+		return errors.Wrapf(rerr, "cannot reenable cluster autoscaler scale down of node %s", n.GetName())
+	}
+	return err
+}
+
+// setAnnotation sets the supplied annotation on the supplied node. It returns
+// false if the node was already annotated.
+func (d *APICordonDrainer) setAnnotation(n *core.Node, k, v string) (bool, error) {
+	var set bool
+	err := d.retry(func() (err error) {
+		set, err = d.trySetAnnotation(n, k, v)
+		return err
+	})
+	return set, err
+}
+
+func (d *APICordonDrainer) trySetAnnotation(n *core.Node, k, v string) (bool, error) {
+	fresh, err := d.c.CoreV1().Nodes().Get(n.GetName(), meta.GetOptions{})
+	if err != nil {
+		return false, errors.Wrapf(err, "cannot get node %s", n.GetName())
+	}
+	if _, ok := fresh.GetAnnotations()[k]; ok {
+		return false, nil
+	}
+	if fresh.Annotations == nil {
+		fresh.Annotations = make(map[string]string)
+	}
+	fresh.Annotations[k] = v
+	if _, err := d.c.CoreV1().Nodes().Update(fresh); err != nil {
+		return false, errors.Wrapf(err, "cannot annotate node %s", fresh.GetName())
+	}
+	return true, nil
+}
+
+func (d *APICordonDrainer) removeAnnotation(n *core.Node, k string) error {
+	return d.retry(func() error { return d.tryRemoveAnnotation(n, k) })
+}
+
+func (d *APICordonDrainer) tryRemoveAnnotation(n *core.Node, k string) error {
+	fresh, err := d.c.CoreV1().Nodes().Get(n.GetName(), meta.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "cannot get node %s", n.GetName())
+	}
+	if _, ok := fresh.GetAnnotations()[k]; !ok {
+		return nil
+	}
+	delete(fresh.Annotations, k)
+	if _, err := d.c.CoreV1().Nodes().Update(fresh); err != nil {
+		return errors.Wrapf(err, "cannot remove annotation from node %s", fresh.GetName())
+	}
+	return nil
+}
+
+// finishDrain removes AnnotationDrainInProgress from the supplied node, and
+// sets or removes AnnotationDrainFailed depending on whether its drain failed.
+func (d *APICordonDrainer) finishDrain(n *core.Node, failed bool) error {
+	return d.retry(func() error { return d.tryFinishDrain(n, failed) })
+}
+
+func (d *APICordonDrainer) tryFinishDrain(n *core.Node, failed bool) error {
+	fresh, err := d.c.CoreV1().Nodes().Get(n.GetName(), meta.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "cannot get node %s", n.GetName())
+	}
+	_, inProgress := fresh.GetAnnotations()[d.keys.DrainInProgress]
+	_, wasFailed := fresh.GetAnnotations()[d.keys.DrainFailed]
+	if !inProgress && wasFailed == failed {
+		return nil
+	}
+	delete(fresh.Annotations, d.keys.DrainInProgress)
+	delete(fresh.Annotations, d.keys.DrainFailed)
+	if failed {
+		if fresh.Annotations == nil {
+			fresh.Annotations = make(map[string]string)
+		}
+		fresh.Annotations[d.keys.DrainFailed] = time.Now().UTC().Format(time.RFC3339)
+	}
+	if _, err := d.c.CoreV1().Nodes().Update(fresh); err != nil {
+		return errors.Wrapf(err, "cannot annotate node %s", fresh.GetName())
+	}
+	return nil
+}
+
+// CheckPods returns an error for which IsTooManyPods is true if draining the
+// supplied node would evict more pods than MaxPodsToEvict allows, unless the
+// node is annotated with AnnotationForceDrain. It should be called before
+// anything is done to the node, so that such nodes are left untouched.
+func (d *APICordonDrainer) CheckPods(n *core.Node) error {
+	if d.maxPods <= 0 || n.GetAnnotations()[d.keys.ForceDrain] == "true" {
+		return nil
+	}
+	pods, _, err := d.evictable(n.GetName())
+	if err != nil {
+		return err
+	}
+	if len(pods) > d.maxPods {
+		return d.tooManyPods(len(pods))
+	}
+	return nil
+}
+
+// tooManyPods returns the error with which drains that would evict the
+// supplied number of pods are skipped.
+func (d *APICordonDrainer) tooManyPods(pods int) error {
+	return errors.Wrapf(errTooManyPods{}, "cannot evict %d pods, more than the maximum of %d, unless the node is annotated %s=true", pods, d.maxPods, d.keys.ForceDrain)
+}
+
+func (d *APICordonDrainer) drain(ctx context.Context, n *core.Node) error {
+	var aborted <-chan struct{}
+	if d.aborted != nil {
+		aborted = d.aborted(n)
+	}
+	select {
+	case <-aborted:
+		return errors.Errorf("drain of node %s aborted", n.GetName())
+	default:
+	}
+
 	pods, err := d.getPods(n.GetName())
 	if err != nil {
 		return errors.Wrapf(err, "cannot get pods for node %s", n.GetName())
 	}
+	if d.maxPods > 0 && len(pods) > d.maxPods && n.GetAnnotations()[d.keys.ForceDrain] != "true" {
+		return d.tooManyPods(len(pods))
+	}
 
+	progress := newDrainProgress(pods, time.Now())
+	if d.progressInterval > 0 {
+		if err := d.writeProgress(n, progress.progress(time.Now())); err != nil {
+			d.l.Info("Cannot record drain progress", zap.String("node", n.GetName()), zap.Error(err))
+		}
+		stop, stopped := make(chan struct{}), make(chan struct{})
+		go func() {
+			d.reportProgress(n, progress, stop)
+			close(stopped)
+		}()
+		defer func() {
+			close(stop)
+			<-stopped
+		}()
+	}
+
+	t := d.timingFor(n)
 	abort := make(chan struct{})
-	errs := make(chan error, 1)
-	for _, pod := range pods {
-		go d.evict(pod, abort, errs)
+	errs := make(chan eviction, 1)
+	batches := make(chan struct{})
+	unbatched := pods
+	if d.batchByBudget {
+		var groups []budgetGroup
+		groups, unbatched, err = d.budgetGroups(pods)
+		if err != nil {
+			return errors.Wrapf(err, "cannot batch evictions from node %s", n.GetName())
+		}
+		for _, g := range groups {
+			go d.evictBatches(ctx, g, t, abort, errs, batches)
+		}
+	}
+	for _, pod := range unbatched {
+		go d.evict(ctx, pod, t, abort, errs)
 	}
 	// This will _eventually_ abort evictions. Evictions may spend up to
-	// d.deleteTimeout() in d.awaitDeletion(), or 5 seconds in backoff before
+	// t.deleteTimeout(pod) in d.awaitDeletion(), or 5 seconds in backoff before
 	// noticing they've been aborted.
 	defer close(abort)
 
-	deadline := time.After(d.deleteTimeout())
-	for range pods {
+	timeout := d.drainTimeout(t, pods)
+	deadline := time.After(timeout)
+	for evicted := 0; evicted < len(pods); {
 		select {
-		case err := <-errs:
-			if err != nil {
-				return errors.Wrap(err, "cannot evict all pods")
+		case e := <-errs:
+			if e.err != nil {
+				return errors.Wrap(e.err, "cannot evict all pods")
 			}
+			progress.podEvicted(e.pod)
+			evicted++
+		case <-batches:
+			// Batches of a budget are evicted one after another, so the
+			// timeout applies to each batch rather than the whole drain.
+			deadline = time.After(timeout)
+		case <-aborted:
+			return errors.Errorf("drain of node %s aborted", n.GetName())
 		case <-deadline:
-			return errors.Wrap(errTimeout{}, "timed out waiting for evictions to complete")
+			stuck := d.stuckPods(pods)
+			if len(stuck) == 0 {
+				return errors.Wrap(errTimeout{}, "timed out waiting for evictions to complete")
+			}
+			for _, p := range stuck {
+				recordStuckFinalizers(p)
+			}
+			return errors.Wrapf(errTimeout{}, "timed out waiting for evictions to complete; pods stuck terminating: %s", describeStuckPods(stuck))
 		}
 	}
 	return nil
 }
 
 func (d *APICordonDrainer) getPods(node string) ([]core.Pod, error) {
-	l, err := d.c.CoreV1().Pods(meta.NamespaceAll).List(meta.ListOptions{
-		FieldSelector: fields.SelectorFromSet(fields.Set{"spec.nodeName": node}).String(),
+	include, exclude, err := d.evictable(node)
+	if err != nil {
+		return nil, err
+	}
+	if d.refused != nil {
+		for i := range exclude {
+			if why := d.refused(exclude[i]); why != "" {
+				d.refusals.Eventf(&exclude[i], core.EventTypeWarning, eventReasonEvictionRefused, "Pod not evicted; %s", why)
+			}
+		}
+	}
+	return include, nil
+}
+
+// evictable returns the pods on the supplied node that pass the drainer's
+// filters, and those that do not.
+func (d *APICordonDrainer) evictable(node string) ([]core.Pod, []core.Pod, error) {
+	var l *core.PodList
+	err := d.retry(func() (err error) {
+		l, err = listPods(d.c.CoreV1().Pods(meta.NamespaceAll).List, meta.ListOptions{
+			FieldSelector: fields.SelectorFromSet(fields.Set{"spec.nodeName": node}).String(),
+		})
+		return err
 	})
 	if err != nil {
-		return nil, errors.Wrapf(err, "cannot get pods for node %s", node)
+		return nil, nil, errors.Wrapf(err, "cannot get pods for node %s", node)
 	}
 
 	include := make([]core.Pod, 0, len(l.Items))
+	exclude := make([]core.Pod, 0)
 	for _, p := range l.Items {
 		passes, err := d.filter(p)
 		if err != nil {
-			return nil, errors.Wrap(err, "cannot filter pods")
+			return nil, nil, errors.Wrap(err, "cannot filter pods")
 		}
 		if passes {
 			include = append(include, p)
+			continue
 		}
+		exclude = append(exclude, p)
 	}
-	return include, nil
+	return include, exclude, nil
 }
 
-func (d *APICordonDrainer) evict(p core.Pod, abort <-chan struct{}, e chan<- error) {
-	gracePeriod := int64(d.maxGracePeriod.Seconds())
-	if p.Spec.TerminationGracePeriodSeconds != nil && *p.Spec.TerminationGracePeriodSeconds < gracePeriod {
-		gracePeriod = *p.Spec.TerminationGracePeriodSeconds
+// An eviction is the result of evicting a pod.
+type eviction struct {
+	pod core.Pod
+	err error
+}
+
+func (d *APICordonDrainer) evict(ctx context.Context, p core.Pod, t evictionTiming, abort <-chan struct{}, e chan<- eviction) {
+	ctx, span := trace.StartSpan(ctx, SpanEvict)
+	span.AddAttributes(
+		trace.StringAttribute(attributeNode, p.Spec.NodeName),
+		trace.StringAttribute(attributeNamespace, p.GetNamespace()),
+		trace.StringAttribute(attributePod, p.GetName()))
+	start := time.Now()
+	err := d.evictPod(ctx, p, t, abort)
+	endSpan(span, err)
+	recordEviction(p, time.Since(start), err)
+	if err == nil && d.e != nil {
+		d.e.Eventf(&p, core.EventTypeNormal, eventReasonPodEvicted, "Evicted pod from node %s", p.Spec.NodeName)
+	}
+	e <- eviction{pod: p, err: err}
+}
+
+// recordDrain records the duration and result of a drain.
+func recordDrain(d time.Duration, err error) {
+	result := tagResultSucceeded
+	switch {
+	case IsTooManyPods(err):
+		result = tagResultSkipped
+	case err != nil:
+		result = tagResultFailed
+	}
+	tags, _ := tag.New(context.Background(), tag.Upsert(TagResult, result)) // nolint:gosec
+	stats.Record(tags, MeasureDrainDuration.M(d.Seconds()))
+}
+
+// recordEviction records the latency and result of evicting the supplied pod.
+func recordEviction(p core.Pod, latency time.Duration, err error) {
+	result := tagResultSucceeded
+	switch {
+	case err == errEvictionAborted:
+		result = tagResultAborted
+	case err != nil:
+		result = tagResultFailed
+	}
+	tags, _ := tag.New(context.Background(), tag.Upsert(TagResult, result)) // nolint:gosec
+	if result == tagResultFailed {
+		tags, _ = tag.New(tags, tag.Upsert(TagErrorClass, ErrorClass(err))) // nolint:gosec
+	}
+	if o := meta.GetControllerOf(&p); o != nil {
+		tags, _ = tag.New(tags, upsertLimited(TagOwnerKind, o.Kind)) // nolint:gosec
 	}
+	stats.Record(tags, MeasurePodsEvicted.M(1), MeasureEvictionLatency.M(latency.Seconds()))
+}
+
+func (d *APICordonDrainer) evictPod(ctx context.Context, p core.Pod, t evictionTiming, abort <-chan struct{}) error {
+	grace := t.gracePeriodFor(p)
+	gracePeriod := int64(grace.Seconds())
+	if !d.awaitNamespaceLimit(p, abort) {
+		return errEvictionAborted
+	}
+	d.l.Info("Evicting pod", append([]zap.Field{
+		zap.String("node", p.Spec.NodeName),
+		zap.String("namespace", p.GetNamespace()),
+		zap.String("pod", p.GetName()),
+		zap.Duration("grace_period", grace),
+		zap.String("grace_period_policy", t.gracePeriodPolicy)}, traceFields(ctx)...)...)
+	var replica func(r *core.Pod) bool
+	if d.replicaPods != nil {
+		var err error
+		if replica, err = replicaOf(d.c, p); err != nil {
+			return errors.Wrapf(err, "cannot determine the replicas of pod %s/%s", p.GetNamespace(), p.GetName())
+		}
+	}
+	waiting := false
 	for {
 		select {
 		case <-abort:
-			e <- errors.New("pod eviction aborted")
-			return
+			return errEvictionAborted
 		default:
-			err := d.c.CoreV1().Pods(p.GetNamespace()).Evict(&policy.Eviction{
-				ObjectMeta:    meta.ObjectMeta{Namespace: p.GetNamespace(), Name: p.GetName()},
-				DeleteOptions: &meta.DeleteOptions{GracePeriodSeconds: &gracePeriod},
+			if replica != nil {
+				last, err := lastReadyReplica(d.replicaPods, p, replica)
+				if err != nil {
+					return errors.Wrapf(err, "cannot determine whether pod %s/%s is the last ready replica", p.GetNamespace(), p.GetName())
+				}
+				if last {
+					time.Sleep(5 * time.Second)
+					continue
+				}
+			}
+			if d.throttle != nil && !d.throttle.wait(abort) {
+				return errEvictionAborted
+			}
+			err := d.retry(func() error {
+				return d.c.CoreV1().Pods(p.GetNamespace()).Evict(&policy.Eviction{
+					ObjectMeta:    meta.ObjectMeta{Namespace: p.GetNamespace(), Name: p.GetName()},
+					DeleteOptions: &meta.DeleteOptions{GracePeriodSeconds: &gracePeriod},
+				})
 			})
 			switch {
+			case apiThrottled(err):
+				recordThrottled()
+				if d.throttle != nil {
+					d.throttle.throttled()
+				}
+				delay := throttledDelay(err)
+				d.l.Info("API server throttled eviction", append([]zap.Field{
+					zap.String("node", p.Spec.NodeName),
+					zap.String("namespace", p.GetNamespace()),
+					zap.String("pod", p.GetName()),
+					zap.Duration("retry_after", delay)}, traceFields(ctx)...)...)
+				select {
+				case <-abort:
+					return errEvictionAborted
+				case <-time.After(delay):
+				}
 			// The eviction API returns 429 Too Many Requests if a pod
 			// cannot currently be evicted, for example due to a pod
 			// disruption budget.
 			case apierrors.IsTooManyRequests(err):
+				if !waiting && d.e != nil {
+					d.e.Eventf(&p, core.EventTypeWarning, eventReasonDrainWaitingOnPDB, "Eviction from node %s refused by a pod disruption budget; retrying", p.Spec.NodeName)
+				}
+				waiting = true
 				time.Sleep(5 * time.Second)
 			case apierrors.IsNotFound(err):
-				e <- nil
-				return
+				return nil
 			case err != nil:
-				e <- errors.Wrapf(err, "cannot evict pod %s/%s", p.GetNamespace(), p.GetName())
-				return
+				return errors.Wrapf(err, "cannot evict pod %s/%s", p.GetNamespace(), p.GetName())
 			default:
-				e <- errors.Wrapf(d.awaitDeletion(p, d.deleteTimeout()), "cannot confirm pod %s/%s was deleted", p.GetNamespace(), p.GetName())
-				return
+				if d.throttle != nil {
+					d.throttle.succeeded()
+				}
+				return errors.Wrapf(d.awaitDeletion(p, t.deleteTimeout(p), abort), "cannot confirm pod %s/%s was deleted", p.GetNamespace(), p.GetName())
 			}
 		}
 	}
 }
 
-func (d *APICordonDrainer) awaitDeletion(p core.Pod, timeout time.Duration) error {
-	return wait.PollImmediate(1*time.Second, timeout, func() (bool, error) {
-		got, err := d.c.CoreV1().Pods(p.GetNamespace()).Get(p.GetName(), meta.GetOptions{})
+// awaitNamespaceLimit blocks until the supplied pod's namespace eviction limit
+// permits it to be evicted. It returns false if aborted while waiting.
+func (d *APICordonDrainer) awaitNamespaceLimit(p core.Pod, abort <-chan struct{}) bool {
+	if d.namespaceLimits == nil {
+		return true
+	}
+	r := d.namespaceLimits.get(p.GetNamespace()).Reserve()
+	select {
+	case <-abort:
+		r.Cancel()
+		return false
+	case <-time.After(r.Delay()):
+		return true
+	}
+}
+
+func (d *APICordonDrainer) awaitDeletion(p core.Pod, timeout time.Duration, abort <-chan struct{}) error {
+	var last *core.Pod
+	err := wait.PollImmediate(1*time.Second, timeout, func() (bool, error) {
+		var got *core.Pod
+		err := d.retry(func() (err error) {
+			got, err = d.c.CoreV1().Pods(p.GetNamespace()).Get(p.GetName(), meta.GetOptions{})
+			return err
+		})
 		if apierrors.IsNotFound(err) {
 			return true, nil
 		}
@@ -253,6 +1073,104 @@ func (d *APICordonDrainer) awaitDeletion(p core.Pod, timeout time.Duration) erro
 		if got.GetUID() != p.GetUID() {
 			return true, nil
 		}
-		return false, nil
+		last = got
+		return false, d.removeStuckFinalizers(got)
 	})
+	if err != wait.ErrWaitTimeout {
+		return err
+	}
+	aborted := false
+	select {
+	case <-abort:
+		aborted = true
+	default:
+		recordPodDeletionTimeout(p)
+	}
+	if last == nil || !stuckOnFinalizers(last) {
+		return errors.Wrapf(errTimeout{}, "pod %s/%s was not deleted within %s", p.GetNamespace(), p.GetName(), timeout)
+	}
+	// Stuck pods are recorded by the drain if it was aborted.
+	if !aborted {
+		recordStuckFinalizers(last)
+	}
+	return errors.Errorf("pod %s is stuck terminating", describeStuckPods([]*core.Pod{last}))
+}
+
+// stuckPods returns the supplied pods that are stuck terminating on
+// finalizers.
+func (d *APICordonDrainer) stuckPods(pods []core.Pod) []*core.Pod {
+	stuck := make([]*core.Pod, 0)
+	for _, p := range pods {
+		got, err := d.c.CoreV1().Pods(p.GetNamespace()).Get(p.GetName(), meta.GetOptions{})
+		if err != nil || got.GetUID() != p.GetUID() || !stuckOnFinalizers(got) {
+			continue
+		}
+		stuck = append(stuck, got)
+	}
+	return stuck
+}
+
+// stuckOnFinalizers returns true if the supplied pod is terminating, but
+// cannot be deleted until its finalizers are removed.
+func stuckOnFinalizers(p *core.Pod) bool {
+	return p.GetDeletionTimestamp() != nil && len(p.GetFinalizers()) > 0
+}
+
+func describeStuckPods(pods []*core.Pod) string {
+	s := make([]string, 0, len(pods))
+	for _, p := range pods {
+		s = append(s, fmt.Sprintf("%s/%s (finalizers %s)", p.GetNamespace(), p.GetName(), strings.Join(p.GetFinalizers(), ",")))
+	}
+	return strings.Join(s, ", ")
+}
+
+func recordStuckFinalizers(p *core.Pod) {
+	for _, f := range p.GetFinalizers() {
+		recordFinalizer(f, tagResultStuck)
+	}
+}
+
+// removeStuckFinalizers removes finalizers that may be removed from the
+// supplied pod, if it has been terminating for long enough.
+func (d *APICordonDrainer) removeStuckFinalizers(p *core.Pod) error {
+	if len(d.removeFinalizers) == 0 || p.GetDeletionTimestamp() == nil || time.Since(p.GetDeletionTimestamp().Time) < d.removeFinalizerAfter {
+		return nil
+	}
+	keep := make([]string, 0, len(p.GetFinalizers()))
+	removed := make([]string, 0)
+	for _, f := range p.GetFinalizers() {
+		if hasString(d.removeFinalizers, f) {
+			removed = append(removed, f)
+			continue
+		}
+		keep = append(keep, f)
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	fresh := p.DeepCopy()
+	fresh.SetFinalizers(keep)
+	if _, err := d.c.CoreV1().Pods(p.GetNamespace()).Update(fresh); err != nil {
+		return errors.Wrapf(err, "cannot remove finalizers %s from pod %s/%s", strings.Join(removed, ","), p.GetNamespace(), p.GetName())
+	}
+	for _, f := range removed {
+		recordFinalizer(f, tagResultRemoved)
+	}
+	return nil
+}
+
+// recordPodDeletionTimeout records that the supplied evicted pod was not
+// deleted in time.
+func recordPodDeletionTimeout(p core.Pod) {
+	tags, _ := tag.New(context.Background()) // nolint:gosec
+	if o := meta.GetControllerOf(&p); o != nil {
+		tags, _ = tag.New(tags, upsertLimited(TagOwnerKind, o.Kind)) // nolint:gosec
+	}
+	stats.Record(tags, MeasurePodDeletionTimeouts.M(1))
+}
+
+// recordFinalizer records a finalizer that blocked deletion of an evicted pod.
+func recordFinalizer(finalizer, result string) {
+	tags, _ := tag.New(context.Background(), upsertLimited(TagFinalizer, finalizer), tag.Upsert(TagResult, result)) // nolint:gosec
+	stats.Record(tags, MeasureStuckPodFinalizers.M(1))
 }
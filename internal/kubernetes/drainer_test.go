@@ -17,9 +17,11 @@ and limitations under the License.
 package kubernetes
 
 import (
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/go-test/deep"
 	"github.com/pkg/errors"
 	core "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
@@ -29,6 +31,7 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
 	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
 )
 
 const (
@@ -39,7 +42,6 @@ const (
 
 	daemonsetName  = "coolDaemonSet"
 	deploymentName = "coolDeployment"
-	kindDeployment = "Deployment"
 )
 
 var (
@@ -262,6 +264,52 @@ func TestDrain(t *testing.T) {
 			},
 			errFn: IsTimeout,
 		},
+		{
+			name:    "DrainTimeoutPerPod",
+			node:    &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}},
+			options: []APICordonDrainerOption{DrainTimeout(0, 500*time.Millisecond)},
+			reactions: []reactor{
+				reactor{
+					verb:     "list",
+					resource: "pods",
+					ret: &core.PodList{Items: []core.Pod{
+						core.Pod{ObjectMeta: meta.ObjectMeta{Name: podName}},
+					}},
+				},
+				reactor{
+					verb:        "create",
+					resource:    "pods",
+					subresource: "eviction",
+					err:         apierrors.NewTooManyRequests("nope", 5),
+				},
+			},
+			errFn: IsTimeout,
+		},
+		{
+			name:    "PodDeletionTimeout",
+			node:    &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}},
+			options: []APICordonDrainerOption{PodDeletionTimeout(1 * time.Second)},
+			reactions: []reactor{
+				reactor{
+					verb:     "list",
+					resource: "pods",
+					ret: &core.PodList{Items: []core.Pod{
+						core.Pod{ObjectMeta: meta.ObjectMeta{Name: podName}},
+					}},
+				},
+				reactor{
+					verb:        "create",
+					resource:    "pods",
+					subresource: "eviction",
+				},
+				reactor{
+					verb:     "get",
+					resource: "pods",
+					ret:      &core.Pod{ObjectMeta: meta.ObjectMeta{Name: podName}},
+				},
+			},
+			errFn: IsTimeout,
+		},
 		{
 			name: "EvictedPodReplacedWithDifferentUID",
 			node: &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}},
@@ -370,6 +418,61 @@ func TestDrain(t *testing.T) {
 			},
 			errFn: func(err error) bool { return errors.Cause(err) == errExploded },
 		},
+		{
+			name: "NamespaceEvictionLimitExceeded",
+			node: &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}},
+			options: []APICordonDrainerOption{
+				MaxGracePeriod(1 * time.Second),
+				EvictionHeadroom(1 * time.Second),
+				WithNamespaceEvictionLimit(1, 1*time.Hour),
+			},
+			reactions: []reactor{
+				reactor{
+					verb:     "list",
+					resource: "pods",
+					ret: &core.PodList{Items: []core.Pod{
+						core.Pod{ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: podName}},
+						core.Pod{ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: "anotherPod"}},
+					}},
+				},
+				reactor{
+					verb:        "create",
+					resource:    "pods",
+					subresource: "eviction",
+				},
+				reactor{
+					verb:     "get",
+					resource: "pods",
+					err:      apierrors.NewNotFound(schema.GroupResource{Resource: "pods"}, podName),
+				},
+			},
+			errFn: IsTimeout,
+		},
+		{
+			name:    "NamespaceEvictionLimitNotExceeded",
+			node:    &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}},
+			options: []APICordonDrainerOption{WithNamespaceEvictionLimit(1, 1*time.Hour)},
+			reactions: []reactor{
+				reactor{
+					verb:     "list",
+					resource: "pods",
+					ret: &core.PodList{Items: []core.Pod{
+						core.Pod{ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: podName}},
+						core.Pod{ObjectMeta: meta.ObjectMeta{Namespace: "anotherNamespace", Name: podName}},
+					}},
+				},
+				reactor{
+					verb:        "create",
+					resource:    "pods",
+					subresource: "eviction",
+				},
+				reactor{
+					verb:     "get",
+					resource: "pods",
+					err:      apierrors.NewNotFound(schema.GroupResource{Resource: "pods"}, podName),
+				},
+			},
+		},
 		{
 			name: "ErrorListingPods",
 			node: &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}},
@@ -401,3 +504,610 @@ func TestDrain(t *testing.T) {
 		})
 	}
 }
+
+func TestDrainDisablesAutoscalerScaleDown(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		wantUpdates []bool
+	}{
+		{
+			name:        "NotAnnotated",
+			wantUpdates: []bool{true, false},
+		},
+		{
+			name:        "AlreadyAnnotated",
+			annotations: map[string]string{AnnotationAutoscalerScaleDownDisabled: "true"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName, Annotations: tc.annotations}}
+			c := fake.NewSimpleClientset(n)
+			d := NewAPICordonDrainer(c, WithAutoscalerScaleDownDisabled())
+			if err := d.Drain(n); err != nil {
+				t.Fatalf("d.Drain(%v): %v", n.GetName(), err)
+			}
+
+			// Record whether the node was annotated after each update.
+			var got []bool
+			for _, a := range c.Actions() {
+				u, ok := a.(clienttesting.UpdateAction)
+				if !ok {
+					continue
+				}
+				_, annotated := u.GetObject().(*core.Node).GetAnnotations()[AnnotationAutoscalerScaleDownDisabled]
+				got = append(got, annotated)
+			}
+			if diff := deep.Equal(tc.wantUpdates, got); diff != nil {
+				t.Errorf("node updates: want != got: %v", diff)
+			}
+
+			fresh, err := c.CoreV1().Nodes().Get(nodeName, meta.GetOptions{})
+			if err != nil {
+				t.Fatalf("c.CoreV1().Nodes().Get(%v): %v", nodeName, err)
+			}
+			_, annotated := fresh.GetAnnotations()[AnnotationAutoscalerScaleDownDisabled]
+			_, want := tc.annotations[AnnotationAutoscalerScaleDownDisabled]
+			if annotated != want {
+				t.Errorf("node annotated after drain: want %v, got %v", want, annotated)
+			}
+		})
+	}
+}
+
+func TestDrainMarker(t *testing.T) {
+	n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}, Status: core.NodeStatus{NodeInfo: core.NodeSystemInfo{BootID: "boot"}}}
+	c := fake.NewSimpleClientset(n)
+	d := NewAPICordonDrainer(c, WithDrainMarker(), WithCordonReasonAnnotation(func(_ *core.Node) []string { return []string{"A", "B"} }))
+
+	marked := func() bool {
+		fresh, err := c.CoreV1().Nodes().Get(nodeName, meta.GetOptions{})
+		if err != nil {
+			t.Fatalf("c.CoreV1().Nodes().Get(%v): %v", nodeName, err)
+		}
+		_, ok := fresh.GetAnnotations()[AnnotationDrainInProgress]
+		return ok
+	}
+
+	if err := d.Cordon(n); err != nil {
+		t.Fatalf("d.Cordon(%v): %v", n.GetName(), err)
+	}
+	if !marked() {
+		t.Errorf("node not marked after cordon")
+	}
+	fresh, err := c.CoreV1().Nodes().Get(nodeName, meta.GetOptions{})
+	if err != nil {
+		t.Fatalf("c.CoreV1().Nodes().Get(%v): %v", nodeName, err)
+	}
+	if got := fresh.GetAnnotations()[AnnotationBootID]; got != "boot" {
+		t.Errorf("recorded boot ID: want %q, got %q", "boot", got)
+	}
+	if got := fresh.GetAnnotations()[AnnotationCordonReason]; got != "A,B" {
+		t.Errorf("recorded cordon reason: want %q, got %q", "A,B", got)
+	}
+	if err := d.Drain(n); err != nil {
+		t.Fatalf("d.Drain(%v): %v", n.GetName(), err)
+	}
+	if marked() {
+		t.Errorf("node still marked after drain")
+	}
+}
+
+func TestDrainFailedMarker(t *testing.T) {
+	n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}
+	c := fake.NewSimpleClientset(n)
+	listErr := errors.New("boom")
+	c.PrependReactor("list", "pods", func(_ clienttesting.Action) (bool, runtime.Object, error) {
+		return true, &core.PodList{}, listErr
+	})
+	d := NewAPICordonDrainer(c, WithDrainMarker())
+
+	failed := func() bool {
+		fresh, err := c.CoreV1().Nodes().Get(nodeName, meta.GetOptions{})
+		if err != nil {
+			t.Fatalf("c.CoreV1().Nodes().Get(%v): %v", nodeName, err)
+		}
+		_, ok := fresh.GetAnnotations()[AnnotationDrainFailed]
+		return ok
+	}
+
+	if err := d.Drain(n); err == nil {
+		t.Fatalf("d.Drain(%v): want error, got nil", n.GetName())
+	}
+	if !failed() {
+		t.Errorf("node not marked failed after failed drain")
+	}
+	listErr = nil
+	if err := d.Drain(n); err != nil {
+		t.Fatalf("d.Drain(%v): %v", n.GetName(), err)
+	}
+	if failed() {
+		t.Errorf("node still marked failed after drain")
+	}
+}
+
+func TestDeferTo(t *testing.T) {
+	foreign := NewNodeForeignDrainFilter(ForeignDrainTaints(), nil)
+	cases := []struct {
+		name    string
+		taints  []core.Taint
+		wantErr bool
+	}{
+		{name: "NotDraining"},
+		{
+			name:    "KarpenterDisrupting",
+			taints:  []core.Taint{{Key: TaintKarpenterDisrupted, Effect: core.TaintEffectNoSchedule}},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// The cached node does not yet show that another controller
+			// started draining it.
+			cached := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}
+			n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}, Spec: core.NodeSpec{Taints: tc.taints}}
+			c := fake.NewSimpleClientset(n)
+			d := NewAPICordonDrainer(c, WithDrainMarker(), WithDeferTo(foreign))
+			if err := d.Cordon(cached); (err != nil) != tc.wantErr {
+				t.Errorf("d.Cordon(%v): want error %v, got %v", cached.GetName(), tc.wantErr, err)
+			}
+			if err := d.Drain(cached); (err != nil) != tc.wantErr {
+				t.Errorf("d.Drain(%v): want error %v, got %v", cached.GetName(), tc.wantErr, err)
+			}
+			fresh, err := c.CoreV1().Nodes().Get(nodeName, meta.GetOptions{})
+			if err != nil {
+				t.Fatalf("c.CoreV1().Nodes().Get(%v): %v", nodeName, err)
+			}
+			if fresh.Spec.Unschedulable != !tc.wantErr {
+				t.Errorf("node cordoned: want %v, got %v", !tc.wantErr, fresh.Spec.Unschedulable)
+			}
+			if _, failed := fresh.GetAnnotations()[AnnotationDrainFailed]; failed {
+				t.Errorf("node marked failed")
+			}
+		})
+	}
+}
+
+func TestDrainMaxPodsToEvict(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		max         int
+		wantSkipped bool
+	}{
+		{name: "WithinMaximum", max: 2},
+		{name: "ExceedsMaximum", max: 1, wantSkipped: true},
+		{name: "ForcedDrain", max: 1, annotations: map[string]string{AnnotationForceDrain: "true"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newFakeClientSet(
+				reactor{
+					verb:     "list",
+					resource: "pods",
+					ret: &core.PodList{Items: []core.Pod{
+						core.Pod{ObjectMeta: meta.ObjectMeta{Name: "a"}},
+						core.Pod{ObjectMeta: meta.ObjectMeta{Name: "b"}},
+					}},
+				},
+				reactor{
+					verb:        "create",
+					resource:    "pods",
+					subresource: "eviction",
+				},
+				reactor{
+					verb:     "get",
+					resource: "pods",
+					err:      apierrors.NewNotFound(schema.GroupResource{Resource: "pods"}, podName),
+				},
+			)
+			n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName, Annotations: tc.annotations}}
+			d := NewAPICordonDrainer(c, MaxPodsToEvict(tc.max), WithPodFilter(func(_ core.Pod) (bool, error) { return true, nil }))
+			if got := IsTooManyPods(d.CheckPods(n)); got != tc.wantSkipped {
+				t.Errorf("IsTooManyPods(d.CheckPods(%v)): want %v, got %v", n.GetName(), tc.wantSkipped, got)
+			}
+			err := d.Drain(n)
+			if got := IsTooManyPods(err); got != tc.wantSkipped {
+				t.Errorf("IsTooManyPods(d.Drain(%v)): want %v, got %v: %v", n.GetName(), tc.wantSkipped, got, err)
+			}
+			if !tc.wantSkipped && err != nil {
+				t.Errorf("d.Drain(%v): %v", n.GetName(), err)
+			}
+		})
+	}
+}
+
+func TestTimingFor(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		labels      map[string]string
+		want        evictionTiming
+	}{
+		{
+			name: "Defaults",
+			want: evictionTiming{gracePeriodKey: AnnotationGracePeriod, gracePeriodPolicy: GracePeriodPolicyCap, maxGracePeriod: DefaultMaxGracePeriod, evictionHeadroom: DefaultEvictionOverhead},
+		},
+		{
+			name:        "Annotations",
+			annotations: map[string]string{AnnotationMaxGracePeriod: "1h", AnnotationEvictionHeadroom: "5m"},
+			want:        evictionTiming{gracePeriodKey: AnnotationGracePeriod, gracePeriodPolicy: GracePeriodPolicyCap, maxGracePeriod: time.Hour, evictionHeadroom: 5 * time.Minute},
+		},
+		{
+			name:   "Labels",
+			labels: map[string]string{AnnotationMaxGracePeriod: "1h"},
+			want:   evictionTiming{gracePeriodKey: AnnotationGracePeriod, gracePeriodPolicy: GracePeriodPolicyCap, maxGracePeriod: time.Hour, evictionHeadroom: DefaultEvictionOverhead},
+		},
+		{
+			name:        "AnnotationsOverrideLabels",
+			annotations: map[string]string{AnnotationEvictionHeadroom: "5m"},
+			labels:      map[string]string{AnnotationEvictionHeadroom: "1m"},
+			want:        evictionTiming{gracePeriodKey: AnnotationGracePeriod, gracePeriodPolicy: GracePeriodPolicyCap, maxGracePeriod: DefaultMaxGracePeriod, evictionHeadroom: 5 * time.Minute},
+		},
+		{
+			name:        "Invalid",
+			annotations: map[string]string{AnnotationMaxGracePeriod: "forever", AnnotationEvictionHeadroom: "-1m"},
+			want:        evictionTiming{gracePeriodKey: AnnotationGracePeriod, gracePeriodPolicy: GracePeriodPolicyCap, maxGracePeriod: DefaultMaxGracePeriod, evictionHeadroom: DefaultEvictionOverhead},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := NewAPICordonDrainer(fake.NewSimpleClientset())
+			n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName, Annotations: tc.annotations, Labels: tc.labels}}
+			if got := d.timingFor(n); got != tc.want {
+				t.Errorf("d.timingFor(%v): want %+v, got %+v", n.GetName(), tc.want, got)
+			}
+		})
+	}
+}
+
+func TestGracePeriodFor(t *testing.T) {
+	short := int64(30)
+	long := int64(3600)
+
+	cases := []struct {
+		name        string
+		policy      string
+		annotations map[string]string
+		terminate   *int64
+		want        time.Duration
+	}{
+		{name: "Unspecified", want: 10 * time.Minute},
+		{name: "TerminationGracePeriod", terminate: &short, want: 30 * time.Second},
+		{name: "TerminationGracePeriodBounded", terminate: &long, want: 10 * time.Minute},
+		{
+			name:        "AnnotationExtends",
+			annotations: map[string]string{AnnotationGracePeriod: "5m"},
+			terminate:   &short,
+			want:        5 * time.Minute,
+		},
+		{
+			name:        "AnnotationCaps",
+			annotations: map[string]string{AnnotationGracePeriod: "5s"},
+			terminate:   &short,
+			want:        5 * time.Second,
+		},
+		{
+			name:        "AnnotationBounded",
+			annotations: map[string]string{AnnotationGracePeriod: "1h"},
+			terminate:   &short,
+			want:        10 * time.Minute,
+		},
+		{
+			name:        "AnnotationInvalid",
+			annotations: map[string]string{AnnotationGracePeriod: "soon"},
+			terminate:   &short,
+			want:        30 * time.Second,
+		},
+		{name: "PodPolicyUnspecified", policy: GracePeriodPolicyPod, want: defaultTerminationGracePeriod},
+		{name: "PodPolicy", policy: GracePeriodPolicyPod, terminate: &long, want: time.Hour},
+		{
+			name:        "PodPolicyAnnotation",
+			policy:      GracePeriodPolicyPod,
+			annotations: map[string]string{AnnotationGracePeriod: "2h"},
+			terminate:   &long,
+			want:        2 * time.Hour,
+		},
+		{name: "OverridePolicy", policy: GracePeriodPolicyOverride, terminate: &short, want: 10 * time.Minute},
+		{
+			name:        "OverridePolicyAnnotation",
+			policy:      GracePeriodPolicyOverride,
+			annotations: map[string]string{AnnotationGracePeriod: "5s"},
+			want:        10 * time.Minute,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			policy := tc.policy
+			if policy == "" {
+				policy = GracePeriodPolicyCap
+			}
+			timing := evictionTiming{gracePeriodKey: AnnotationGracePeriod, gracePeriodPolicy: policy, maxGracePeriod: 10 * time.Minute}
+			p := core.Pod{
+				ObjectMeta: meta.ObjectMeta{Name: podName, Annotations: tc.annotations},
+				Spec:       core.PodSpec{TerminationGracePeriodSeconds: tc.terminate},
+			}
+			if got := timing.gracePeriodFor(p); got != tc.want {
+				t.Errorf("timing.gracePeriodFor(%v): want %v, got %v", p.GetName(), tc.want, got)
+			}
+		})
+	}
+}
+
+func TestDrainTimeoutPodPolicy(t *testing.T) {
+	long := int64(3600)
+	pods := []core.Pod{
+		{ObjectMeta: meta.ObjectMeta{Name: "short"}},
+		{ObjectMeta: meta.ObjectMeta{Name: "long"}, Spec: core.PodSpec{TerminationGracePeriodSeconds: &long}},
+	}
+	cases := []struct {
+		name   string
+		policy string
+		want   time.Duration
+	}{
+		{name: "Cap", policy: GracePeriodPolicyCap, want: DefaultMaxGracePeriod + DefaultEvictionOverhead},
+		{name: "Pod", policy: GracePeriodPolicyPod, want: time.Hour + DefaultEvictionOverhead},
+		{name: "Override", policy: GracePeriodPolicyOverride, want: DefaultMaxGracePeriod + DefaultEvictionOverhead},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := NewAPICordonDrainer(fake.NewSimpleClientset(), WithGracePeriodPolicy(tc.policy))
+			n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}
+			if got := d.drainTimeout(d.timingFor(n), pods); got != tc.want {
+				t.Errorf("d.drainTimeout(...): want %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestDrainDaemonSetPodRespectsBudget(t *testing.T) {
+	pod := &core.Pod{
+		ObjectMeta: meta.ObjectMeta{
+			Namespace: ns,
+			Name:      podName,
+			OwnerReferences: []meta.OwnerReference{meta.OwnerReference{
+				Controller: &isController,
+				Kind:       kindDaemonSet,
+				Name:       daemonsetName,
+			}},
+		},
+		Spec: core.PodSpec{NodeName: nodeName},
+	}
+	c := fake.NewSimpleClientset(pod)
+	evictions := 0
+	c.PrependReactor("create", "pods", func(a clienttesting.Action) (bool, runtime.Object, error) {
+		if a.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		// The first eviction is disallowed by a pod disruption budget.
+		evictions++
+		if evictions == 1 {
+			return true, nil, apierrors.NewTooManyRequests("nope", 5)
+		}
+		return true, nil, nil
+	})
+	c.PrependReactor("get", "pods", func(a clienttesting.Action) (bool, runtime.Object, error) {
+		if evictions < 2 {
+			return false, nil, nil
+		}
+		return true, nil, apierrors.NewNotFound(schema.GroupResource{Resource: "pods"}, podName)
+	})
+
+	// DaemonSet pods are evicted when no DaemonSet pod filter is configured,
+	// as with --evict-daemonset-pods.
+	d := NewAPICordonDrainer(c)
+	if err := d.Drain(&core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}); err != nil {
+		t.Fatalf("d.Drain(%v): %v", nodeName, err)
+	}
+	if evictions != 2 {
+		t.Errorf("evictions: want 2, got %d", evictions)
+	}
+	for _, a := range c.Actions() {
+		if a.GetVerb() == "delete" && a.GetResource().Resource == "pods" {
+			t.Errorf("pod deleted rather than evicted: %v", a)
+		}
+	}
+}
+
+func TestDrainDefersLastReadyReplica(t *testing.T) {
+	pod := newReplica(podName, kindDeployment, deploymentName, true)
+	c := fake.NewSimpleClientset(pod)
+	evictions := 0
+	c.PrependReactor("create", "pods", func(a clienttesting.Action) (bool, runtime.Object, error) {
+		if a.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		evictions++
+		return true, nil, nil
+	})
+
+	d := NewAPICordonDrainer(c, WithLastReadyReplicaDeferral(&staticPodStore{pods: []*core.Pod{pod}}), DrainTimeout(0, 500*time.Millisecond))
+	if err := d.Drain(&core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}); !IsTimeout(err) {
+		t.Errorf("d.Drain(%v): want timeout, got %v", nodeName, err)
+	}
+	if evictions != 0 {
+		t.Errorf("evictions: want 0, got %d", evictions)
+	}
+}
+
+func TestDrainAbort(t *testing.T) {
+	pod := &core.Pod{
+		ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: podName},
+		Spec:       core.PodSpec{NodeName: nodeName},
+	}
+	c := fake.NewSimpleClientset(pod)
+	c.PrependReactor("create", "pods", func(a clienttesting.Action) (bool, runtime.Object, error) {
+		return a.GetSubresource() == "eviction", nil, nil
+	})
+
+	lost := make(chan struct{})
+	time.AfterFunc(50*time.Millisecond, func() { close(lost) })
+	d := NewAPICordonDrainer(c,
+		DrainTimeout(0, 10*time.Second),
+		WithDrainAbort(func(_ *core.Node) <-chan struct{} { return lost }))
+
+	start := time.Now()
+	err := d.Drain(&core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}})
+	if err == nil || IsTimeout(err) {
+		t.Errorf("d.Drain(%v): want aborted, got %v", nodeName, err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("d.Drain(%v): want abort before timeout, took %v", nodeName, elapsed)
+	}
+}
+
+func TestDrainStuckFinalizers(t *testing.T) {
+	terminating := meta.NewTime(time.Now().Add(-1 * time.Hour))
+	cases := []struct {
+		name        string
+		finalizers  []string
+		options     []APICordonDrainerOption
+		wantRemoved []string
+		wantErr     bool
+	}{
+		{
+			name:       "Stuck",
+			finalizers: []string{"example.org/broken"},
+			wantErr:    true,
+		},
+		{
+			name:        "Removed",
+			finalizers:  []string{"example.org/broken"},
+			options:     []APICordonDrainerOption{WithFinalizerRemoval(10*time.Minute, "example.org/broken")},
+			wantRemoved: []string{"example.org/broken"},
+		},
+		{
+			name:       "NotTerminatingLongEnough",
+			finalizers: []string{"example.org/broken"},
+			options:    []APICordonDrainerOption{WithFinalizerRemoval(2*time.Hour, "example.org/broken")},
+			wantErr:    true,
+		},
+		{
+			name:       "NotAllowed",
+			finalizers: []string{"example.org/important"},
+			options:    []APICordonDrainerOption{WithFinalizerRemoval(10*time.Minute, "example.org/broken")},
+			wantErr:    true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			pod := &core.Pod{
+				ObjectMeta: meta.ObjectMeta{
+					Namespace:         ns,
+					Name:              podName,
+					DeletionTimestamp: &terminating,
+					Finalizers:        tc.finalizers,
+				},
+				Spec: core.PodSpec{NodeName: nodeName},
+			}
+			c := fake.NewSimpleClientset(pod)
+			c.PrependReactor("create", "pods", func(a clienttesting.Action) (bool, runtime.Object, error) {
+				return a.GetSubresource() == "eviction", nil, nil
+			})
+			var removed []string
+			c.PrependReactor("update", "pods", func(a clienttesting.Action) (bool, runtime.Object, error) {
+				got := a.(clienttesting.UpdateAction).GetObject().(*core.Pod)
+				for _, f := range pod.GetFinalizers() {
+					if !hasString(got.GetFinalizers(), f) {
+						removed = append(removed, f)
+					}
+				}
+				return true, got, nil
+			})
+			c.PrependReactor("get", "pods", func(a clienttesting.Action) (bool, runtime.Object, error) {
+				if len(removed) > 0 {
+					return true, nil, apierrors.NewNotFound(schema.GroupResource{Resource: "pods"}, podName)
+				}
+				return true, pod, nil
+			})
+
+			o := append([]APICordonDrainerOption{MaxGracePeriod(1 * time.Second), EvictionHeadroom(1 * time.Second)}, tc.options...)
+			d := NewAPICordonDrainer(c, o...)
+			err := d.Drain(&core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}})
+			if (err != nil) != tc.wantErr {
+				t.Errorf("d.Drain(%v): want error %v, got %v", nodeName, tc.wantErr, err)
+			}
+			if err != nil && !strings.Contains(err.Error(), tc.finalizers[0]) {
+				t.Errorf("d.Drain(%v): want error naming finalizer %s, got %v", nodeName, tc.finalizers[0], err)
+			}
+			if diff := deep.Equal(tc.wantRemoved, removed); diff != nil {
+				t.Errorf("removed finalizers: want != got: %v", diff)
+			}
+		})
+	}
+}
+
+func TestEvictionEvents(t *testing.T) {
+	pod := &core.Pod{
+		ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: podName},
+		Spec:       core.PodSpec{NodeName: nodeName},
+	}
+	c := fake.NewSimpleClientset(pod)
+	evictions := 0
+	c.PrependReactor("create", "pods", func(a clienttesting.Action) (bool, runtime.Object, error) {
+		if a.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		// The first eviction is disallowed by a pod disruption budget.
+		evictions++
+		if evictions == 1 {
+			return true, nil, apierrors.NewTooManyRequests("nope", 5)
+		}
+		return true, nil, nil
+	})
+	c.PrependReactor("get", "pods", func(a clienttesting.Action) (bool, runtime.Object, error) {
+		if evictions < 2 {
+			return false, nil, nil
+		}
+		return true, nil, apierrors.NewNotFound(schema.GroupResource{Resource: "pods"}, podName)
+	})
+
+	e := record.NewFakeRecorder(10)
+	d := NewAPICordonDrainer(c, WithEvictionEvents(e))
+	if err := d.Drain(&core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}); err != nil {
+		t.Fatalf("d.Drain(%v): %v", nodeName, err)
+	}
+	close(e.Events)
+	got := []string{}
+	for ev := range e.Events {
+		got = append(got, ev)
+	}
+	want := []string{
+		"Warning DrainWaitingOnPDB Eviction from node coolNode refused by a pod disruption budget; retrying",
+		"Normal PodEvicted Evicted pod from node coolNode",
+	}
+	if diff := deep.Equal(want, got); diff != nil {
+		t.Errorf("events: want != got: %v", diff)
+	}
+}
+
+func TestEvictionRefusedEvents(t *testing.T) {
+	pod := &core.Pod{
+		ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: podName},
+		Spec:       core.PodSpec{NodeName: nodeName},
+	}
+	c := fake.NewSimpleClientset(pod)
+	e := record.NewFakeRecorder(10)
+	d := NewAPICordonDrainer(c,
+		WithPodFilter(func(_ core.Pod) (bool, error) { return false, nil }),
+		WithEvictionRefusedEvents(e, func(_ core.Pod) string { return "it is special" }))
+	if err := d.Drain(&core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}); err != nil {
+		t.Fatalf("d.Drain(%v): %v", nodeName, err)
+	}
+	close(e.Events)
+	got := []string{}
+	for ev := range e.Events {
+		got = append(got, ev)
+	}
+	want := []string{"Warning EvictionRefused Pod not evicted; it is special"}
+	if diff := deep.Equal(want, got); diff != nil {
+		t.Errorf("events: want != got: %v", diff)
+	}
+}
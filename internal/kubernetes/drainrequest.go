@@ -0,0 +1,403 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+)
+
+// DrainRequestResource is the DrainRequest custom resource.
+var DrainRequestResource = schema.GroupVersionResource{
+	Group:    "draino.planetlabs.com",
+	Version:  "v1alpha1",
+	Resource: "drainrequests",
+}
+
+// DrainRequest phases.
+const (
+	DrainRequestPending    = "Pending"
+	DrainRequestInProgress = "InProgress"
+	DrainRequestSucceeded  = "Succeeded"
+	DrainRequestFailed     = "Failed"
+	DrainRequestExpired    = "Expired"
+)
+
+// A DrainRequest asks draino to cordon and drain one or more nodes.
+type DrainRequest struct {
+	meta.TypeMeta   `json:",inline"`
+	meta.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DrainRequestSpec   `json:"spec"`
+	Status DrainRequestStatus `json:"status,omitempty"`
+}
+
+// A DrainRequestSpec specifies which nodes to drain.
+type DrainRequestSpec struct {
+	// NodeName is the name of a node to drain.
+	NodeName string `json:"nodeName,omitempty"`
+
+	// NodeSelector selects nodes to drain by label.
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// Reason the nodes should be drained.
+	Reason string `json:"reason,omitempty"`
+
+	// Deadline by which drains must start. Drains that have not started by
+	// the deadline will not happen.
+	Deadline *meta.Time `json:"deadline,omitempty"`
+}
+
+// A DrainRequestStatus reports the progress of a DrainRequest.
+type DrainRequestStatus struct {
+	// Phase of the request as a whole.
+	Phase string `json:"phase,omitempty"`
+
+	// Message describing the phase.
+	Message string `json:"message,omitempty"`
+
+	// Nodes reports the progress of each requested node drain.
+	Nodes []DrainRequestNodeStatus `json:"nodes,omitempty"`
+}
+
+// A DrainRequestNodeStatus reports the progress of a node drain.
+type DrainRequestNodeStatus struct {
+	NodeName string `json:"nodeName"`
+	Phase    string `json:"phase"`
+	Message  string `json:"message,omitempty"`
+}
+
+func terminal(phase string) bool {
+	return phase == DrainRequestSucceeded || phase == DrainRequestFailed || phase == DrainRequestExpired
+}
+
+// A DrainRequester cordons and drains nodes on request.
+type DrainRequester interface {
+	Request(n *core.Node, deadline time.Time, done func(err error)) error
+}
+
+// A DrainRequestController watches DrainRequests, cordons and drains the nodes
+// they select, and reports progress in their status.
+type DrainRequestController struct {
+	l      *zap.Logger
+	c      dynamic.Interface
+	nodes  NodeStore
+	r      DrainRequester
+	i      cache.SharedInformer
+	dryRun bool
+
+	mu     sync.Mutex
+	active map[types.UID]bool
+}
+
+// DrainRequestControllerOption configures a DrainRequestController.
+type DrainRequestControllerOption func(c *DrainRequestController)
+
+// WithDrainRequestLogger configures a DrainRequestController to use the
+// supplied logger.
+func WithDrainRequestLogger(l *zap.Logger) DrainRequestControllerOption {
+	return func(c *DrainRequestController) {
+		c.l = l
+	}
+}
+
+// WithDrainRequestDryRun configures a DrainRequestController not to update the
+// status of DrainRequests.
+func WithDrainRequestDryRun() DrainRequestControllerOption {
+	return func(c *DrainRequestController) {
+		c.dryRun = true
+	}
+}
+
+// NewDrainRequestController returns a DrainRequestController that uses the
+// supplied DrainRequester to drain nodes.
+func NewDrainRequestController(c dynamic.Interface, nodes NodeStore, r DrainRequester, co ...DrainRequestControllerOption) *DrainRequestController {
+	dc := &DrainRequestController{
+		l:      zap.NewNop(),
+		c:      c,
+		nodes:  nodes,
+		r:      r,
+		active: make(map[types.UID]bool),
+	}
+	for _, o := range co {
+		o(dc)
+	}
+	ri := c.Resource(DrainRequestResource)
+	lw := &cache.ListWatch{
+		ListFunc:  func(o meta.ListOptions) (runtime.Object, error) { return ri.List(o) },
+		WatchFunc: func(o meta.ListOptions) (watch.Interface, error) { return ri.Watch(o) },
+	}
+	dc.i = cache.NewSharedInformer(lw, &unstructured.Unstructured{}, 30*time.Minute)
+	dc.i.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    dc.handle,
+		UpdateFunc: func(_, o interface{}) { dc.handle(o) },
+		DeleteFunc: dc.forget,
+	})
+	return dc
+}
+
+// Run the controller until the supplied channel is closed.
+func (c *DrainRequestController) Run(stop <-chan struct{}) {
+	c.i.Run(stop)
+}
+
+// Requested returns true if the supplied node is the subject of a
+// DrainRequest, and thus should not be uncordoned.
+func (c *DrainRequestController) Requested(o interface{}) bool {
+	n, ok := o.(*core.Node)
+	if !ok {
+		return false
+	}
+	for _, o := range c.i.GetStore().List() {
+		u, ok := o.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		if name, _, _ := unstructured.NestedString(u.Object, "spec", "nodeName"); name == n.GetName() {
+			return true
+		}
+		nodes, _, _ := unstructured.NestedSlice(u.Object, "status", "nodes")
+		for _, ns := range nodes {
+			if m, ok := ns.(map[string]interface{}); ok && m["nodeName"] == n.GetName() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *DrainRequestController) handle(o interface{}) {
+	u, ok := o.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	dr := &DrainRequest{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), dr); err != nil {
+		c.l.Info("Cannot decode drain request", zap.String("request", u.GetName()), zap.Error(err))
+		return
+	}
+	c.mu.Lock()
+	if terminal(dr.Status.Phase) {
+		delete(c.active, dr.GetUID())
+		c.mu.Unlock()
+		return
+	}
+	if c.active[dr.GetUID()] {
+		c.mu.Unlock()
+		return
+	}
+	c.active[dr.GetUID()] = true
+	c.mu.Unlock()
+
+	if err := c.process(dr); err != nil {
+		c.l.Info("Cannot process drain request", zap.String("request", dr.GetName()), zap.Error(err))
+		// Process the request again the next time it is updated or
+		// resynced.
+		c.mu.Lock()
+		delete(c.active, dr.GetUID())
+		c.mu.Unlock()
+	}
+}
+
+// forget the supplied deleted drain request.
+func (c *DrainRequestController) forget(o interface{}) {
+	if d, ok := o.(cache.DeletedFinalStateUnknown); ok {
+		o = d.Obj
+	}
+	u, ok := o.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	delete(c.active, u.GetUID())
+	c.mu.Unlock()
+}
+
+func (c *DrainRequestController) process(dr *DrainRequest) error {
+	log := c.l.With(zap.String("request", dr.GetName()))
+	deadline := time.Time{}
+	if dr.Spec.Deadline != nil {
+		deadline = dr.Spec.Deadline.Time
+	}
+	if !deadline.IsZero() && time.Now().After(deadline) {
+		return c.finish(dr.GetName(), DrainRequestExpired, "Deadline passed before drains were requested", nil)
+	}
+
+	nodes, err := c.selectNodes(dr.Spec)
+	if err != nil {
+		return c.finish(dr.GetName(), DrainRequestFailed, err.Error(), nil)
+	}
+	if len(nodes) == 0 {
+		return c.finish(dr.GetName(), DrainRequestFailed, "No nodes matched the request", nil)
+	}
+
+	// Nodes that previously finished draining (e.g. before draino restarted)
+	// are not drained again.
+	previous := make(map[string]DrainRequestNodeStatus)
+	for _, ns := range dr.Status.Nodes {
+		previous[ns.NodeName] = ns
+	}
+	status := make([]DrainRequestNodeStatus, 0, len(nodes))
+	for _, n := range nodes {
+		if p, ok := previous[n.GetName()]; ok && terminal(p.Phase) {
+			status = append(status, p)
+			continue
+		}
+		status = append(status, DrainRequestNodeStatus{NodeName: n.GetName(), Phase: DrainRequestPending})
+	}
+	if err := c.update(dr.GetName(), func(s *DrainRequestStatus) {
+		s.Phase, s.Message, s.Nodes = DrainRequestInProgress, "Draining nodes", status
+		if dr.Spec.Reason != "" {
+			s.Message += ": " + dr.Spec.Reason
+		}
+		summarize(s)
+	}); err != nil {
+		return errors.Wrap(err, "cannot update drain request status")
+	}
+
+	for _, n := range nodes {
+		if p, ok := previous[n.GetName()]; ok && terminal(p.Phase) {
+			continue
+		}
+		name := n.GetName()
+		log.Info("Requesting drain", zap.String("node", name), zap.String("reason", dr.Spec.Reason))
+		err := c.r.Request(n, deadline, func(err error) { c.nodeFinished(dr.GetName(), name, err) })
+		if err != nil {
+			c.nodeFinished(dr.GetName(), name, err)
+			continue
+		}
+		c.setNodePhase(dr.GetName(), name, DrainRequestInProgress, "Drain scheduled")
+	}
+	return nil
+}
+
+func (c *DrainRequestController) selectNodes(spec DrainRequestSpec) ([]*core.Node, error) {
+	if spec.NodeName != "" {
+		n, err := c.nodes.Get(spec.NodeName)
+		if err != nil {
+			return nil, err
+		}
+		return []*core.Node{n}, nil
+	}
+	if len(spec.NodeSelector) == 0 {
+		return nil, errors.New("request must specify either a node name or a node selector")
+	}
+	sel := labels.SelectorFromSet(spec.NodeSelector)
+	nodes := make([]*core.Node, 0)
+	for _, n := range c.nodes.List() {
+		if sel.Matches(labels.Set(n.GetLabels())) {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes, nil
+}
+
+func (c *DrainRequestController) nodeFinished(request, node string, err error) {
+	switch {
+	case err == nil:
+		c.setNodePhase(request, node, DrainRequestSucceeded, "Drained node")
+	case IsDeadlineExceeded(err):
+		c.setNodePhase(request, node, DrainRequestExpired, err.Error())
+	default:
+		c.setNodePhase(request, node, DrainRequestFailed, err.Error())
+	}
+}
+
+func (c *DrainRequestController) setNodePhase(request, node, phase, msg string) {
+	err := c.update(request, func(s *DrainRequestStatus) {
+		for i := range s.Nodes {
+			// A drain may finish before we record that it was scheduled.
+			if s.Nodes[i].NodeName != node || (terminal(s.Nodes[i].Phase) && !terminal(phase)) {
+				continue
+			}
+			s.Nodes[i].Phase = phase
+			s.Nodes[i].Message = msg
+		}
+		summarize(s)
+	})
+	if err != nil {
+		c.l.Info("Cannot update drain request status", zap.String("request", request), zap.String("node", node), zap.Error(err))
+	}
+}
+
+// summarize the phase of the supplied status from the phases of its nodes.
+func summarize(s *DrainRequestStatus) {
+	counts := make(map[string]int)
+	for _, ns := range s.Nodes {
+		if !terminal(ns.Phase) {
+			return
+		}
+		counts[ns.Phase]++
+	}
+	switch {
+	case counts[DrainRequestFailed] > 0:
+		s.Phase, s.Message = DrainRequestFailed, "At least one node drain failed"
+	case counts[DrainRequestExpired] > 0:
+		s.Phase, s.Message = DrainRequestExpired, "At least one node drain did not start before the deadline"
+	default:
+		s.Phase, s.Message = DrainRequestSucceeded, "Drained all nodes"
+	}
+}
+
+func (c *DrainRequestController) finish(request, phase, msg string, nodes []DrainRequestNodeStatus) error {
+	return c.update(request, func(s *DrainRequestStatus) {
+		s.Phase, s.Message, s.Nodes = phase, msg, nodes
+	})
+}
+
+// update the status of the named drain request. Updates are retried if they
+// conflict, for example with those of concurrently finishing drains.
+func (c *DrainRequestController) update(request string, fn func(s *DrainRequestStatus)) error {
+	if c.dryRun {
+		c.l.Info("Not updating drain request status in dry-run mode", zap.String("request", request))
+		return nil
+	}
+	ri := c.c.Resource(DrainRequestResource)
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		u, err := ri.Get(request, meta.GetOptions{})
+		if err != nil {
+			return err
+		}
+		dr := &DrainRequest{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), dr); err != nil {
+			return errors.Wrap(err, "cannot decode drain request")
+		}
+		fn(&dr.Status)
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(dr)
+		if err != nil {
+			return errors.Wrap(err, "cannot encode drain request")
+		}
+		// Conflicts are returned unwrapped so that they are retried.
+		_, err = ri.UpdateStatus(&unstructured.Unstructured{Object: content})
+		return err
+	})
+	return errors.Wrapf(err, "cannot update drain request %s", request)
+}
@@ -0,0 +1,230 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+const drainRequestName = "coolRequest"
+
+type fakeRequester struct {
+	results   map[string]error
+	requested []string
+}
+
+func (r *fakeRequester) Request(n *core.Node, deadline time.Time, done func(err error)) error {
+	err, ok := r.results[n.GetName()]
+	if !ok {
+		return errors.Errorf("cannot request drain of %s", n.GetName())
+	}
+	r.requested = append(r.requested, n.GetName())
+	if !deadline.IsZero() && time.Now().After(deadline) {
+		err = errors.Wrap(errDeadlineExceeded{}, "too late")
+	}
+	done(err)
+	return nil
+}
+
+func newDrainRequest(t *testing.T, spec DrainRequestSpec) *unstructured.Unstructured {
+	t.Helper()
+	dr := &DrainRequest{
+		TypeMeta:   meta.TypeMeta{APIVersion: DrainRequestResource.GroupVersion().String(), Kind: "DrainRequest"},
+		ObjectMeta: meta.ObjectMeta{Name: drainRequestName, UID: "uid"},
+		Spec:       spec,
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(dr)
+	if err != nil {
+		t.Fatalf("runtime.DefaultUnstructuredConverter.ToUnstructured(...): %v", err)
+	}
+	return &unstructured.Unstructured{Object: content}
+}
+
+func TestDrainRequestController(t *testing.T) {
+	nodes := staticNodeStore{
+		&core.Node{ObjectMeta: meta.ObjectMeta{Name: "a", Labels: map[string]string{"pool": "cool"}}},
+		&core.Node{ObjectMeta: meta.ObjectMeta{Name: "b", Labels: map[string]string{"pool": "cool"}}},
+		&core.Node{ObjectMeta: meta.ObjectMeta{Name: "c", Labels: map[string]string{"pool": "lame"}}},
+	}
+
+	cases := []struct {
+		name    string
+		spec    DrainRequestSpec
+		results map[string]error
+		want    DrainRequestStatus
+	}{
+		{
+			name:    "NodeName",
+			spec:    DrainRequestSpec{NodeName: "a", Reason: "testing"},
+			results: map[string]error{"a": nil},
+			want: DrainRequestStatus{
+				Phase:   DrainRequestSucceeded,
+				Message: "Drained all nodes",
+				Nodes:   []DrainRequestNodeStatus{{NodeName: "a", Phase: DrainRequestSucceeded, Message: "Drained node"}},
+			},
+		},
+		{
+			name:    "NodeSelectorOneFailed",
+			spec:    DrainRequestSpec{NodeSelector: map[string]string{"pool": "cool"}},
+			results: map[string]error{"a": nil, "b": errors.New("nope")},
+			want: DrainRequestStatus{
+				Phase:   DrainRequestFailed,
+				Message: "At least one node drain failed",
+				Nodes: []DrainRequestNodeStatus{
+					{NodeName: "a", Phase: DrainRequestSucceeded, Message: "Drained node"},
+					{NodeName: "b", Phase: DrainRequestFailed, Message: "nope"},
+				},
+			},
+		},
+		{
+			name:    "RequestFailed",
+			spec:    DrainRequestSpec{NodeName: "a"},
+			results: map[string]error{},
+			want: DrainRequestStatus{
+				Phase:   DrainRequestFailed,
+				Message: "At least one node drain failed",
+				Nodes:   []DrainRequestNodeStatus{{NodeName: "a", Phase: DrainRequestFailed, Message: "cannot request drain of a"}},
+			},
+		},
+		{
+			name: "DeadlinePassed",
+			spec: DrainRequestSpec{NodeName: "a", Deadline: &meta.Time{Time: time.Now().Add(-1 * time.Minute)}},
+			want: DrainRequestStatus{
+				Phase:   DrainRequestExpired,
+				Message: "Deadline passed before drains were requested",
+			},
+		},
+		{
+			name: "NoMatchingNodes",
+			spec: DrainRequestSpec{NodeSelector: map[string]string{"pool": "missing"}},
+			want: DrainRequestStatus{
+				Phase:   DrainRequestFailed,
+				Message: "No nodes matched the request",
+			},
+		},
+		{
+			name: "NoNodeSpecified",
+			spec: DrainRequestSpec{Reason: "testing"},
+			want: DrainRequestStatus{
+				Phase:   DrainRequestFailed,
+				Message: "request must specify either a node name or a node selector",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			u := newDrainRequest(t, tc.spec)
+			c := fake.NewSimpleDynamicClient(runtime.NewScheme(), u)
+			dc := NewDrainRequestController(c, nodes, &fakeRequester{results: tc.results})
+			dc.handle(u)
+
+			got, err := c.Resource(DrainRequestResource).Get(drainRequestName, meta.GetOptions{})
+			if err != nil {
+				t.Fatalf("c.Resource(...).Get(%v): %v", drainRequestName, err)
+			}
+			dr := &DrainRequest{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(got.UnstructuredContent(), dr); err != nil {
+				t.Fatalf("runtime.DefaultUnstructuredConverter.FromUnstructured(...): %v", err)
+			}
+			if diff := deep.Equal(tc.want, dr.Status); diff != nil {
+				t.Errorf("dr.Status: want != got: %v", diff)
+			}
+		})
+	}
+}
+
+func TestDrainRequestControllerIgnoresFinishedRequests(t *testing.T) {
+	u := newDrainRequest(t, DrainRequestSpec{NodeName: "a"})
+	if err := unstructured.SetNestedField(u.Object, DrainRequestSucceeded, "status", "phase"); err != nil {
+		t.Fatalf("unstructured.SetNestedField(...): %v", err)
+	}
+	c := fake.NewSimpleDynamicClient(runtime.NewScheme(), u)
+	r := &fakeRequester{}
+	dc := NewDrainRequestController(c, staticNodeStore{&core.Node{ObjectMeta: meta.ObjectMeta{Name: "a"}}}, r)
+	dc.handle(u)
+
+	for _, a := range c.Actions() {
+		if a.GetVerb() == "update" {
+			t.Errorf("finished drain request was updated: %v", a)
+		}
+	}
+}
+
+func TestDrainRequestControllerRetriesFailedRequests(t *testing.T) {
+	u := newDrainRequest(t, DrainRequestSpec{NodeName: "a"})
+	c := fake.NewSimpleDynamicClient(runtime.NewScheme(), u)
+	failed := false
+	c.PrependReactor("update", "drainrequests", func(a clienttesting.Action) (bool, runtime.Object, error) {
+		if failed {
+			return false, nil, nil
+		}
+		failed = true
+		return true, nil, errors.New("nope")
+	})
+	r := &fakeRequester{results: map[string]error{"a": nil}}
+	dc := NewDrainRequestController(c, staticNodeStore{&core.Node{ObjectMeta: meta.ObjectMeta{Name: "a"}}}, r)
+
+	dc.handle(u)
+	if len(r.requested) != 0 {
+		t.Errorf("drains requested when status could not be updated: %v", r.requested)
+	}
+	dc.handle(u)
+	if diff := deep.Equal(r.requested, []string{"a"}); diff != nil {
+		t.Errorf("r.requested: %v", diff)
+	}
+}
+
+func TestDrainRequestControllerForgetsDeletedRequests(t *testing.T) {
+	u := newDrainRequest(t, DrainRequestSpec{NodeName: "a"})
+	c := fake.NewSimpleDynamicClient(runtime.NewScheme(), u)
+	dc := NewDrainRequestController(c, staticNodeStore{&core.Node{ObjectMeta: meta.ObjectMeta{Name: "a"}}}, &fakeRequester{results: map[string]error{"a": nil}})
+
+	dc.handle(u)
+	dc.forget(cache.DeletedFinalStateUnknown{Key: drainRequestName, Obj: u})
+	if len(dc.active) != 0 {
+		t.Errorf("deleted drain requests remain active: %v", dc.active)
+	}
+}
+
+func TestDrainRequestControllerDryRun(t *testing.T) {
+	u := newDrainRequest(t, DrainRequestSpec{NodeName: "a"})
+	c := fake.NewSimpleDynamicClient(runtime.NewScheme(), u)
+	r := &fakeRequester{results: map[string]error{"a": nil}}
+	dc := NewDrainRequestController(c, staticNodeStore{&core.Node{ObjectMeta: meta.ObjectMeta{Name: "a"}}}, r, WithDrainRequestDryRun())
+	dc.handle(u)
+
+	if diff := deep.Equal(r.requested, []string{"a"}); diff != nil {
+		t.Errorf("r.requested: %v", diff)
+	}
+	for _, a := range c.Actions() {
+		if a.GetVerb() == "update" {
+			t.Errorf("drain request was updated in dry-run mode: %v", a)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+)
+
+// Default endpoint probe settings.
+const (
+	DefaultEndpointProbeTimeout          = 5 * time.Second
+	DefaultEndpointProbeFailureThreshold = 3
+
+	maxConcurrentEndpointProbes = 10
+
+	schemeHTTP  = "http"
+	schemeHTTPS = "https"
+	schemeTCP   = "tcp"
+)
+
+// EndpointTemplateData is the data available to endpoint templates.
+type EndpointTemplateData struct {
+	Name        string
+	InternalIP  string
+	ExternalIP  string
+	Hostname    string
+	InternalDNS string
+	ExternalDNS string
+}
+
+func newEndpointTemplateData(n *core.Node) EndpointTemplateData {
+	d := EndpointTemplateData{Name: n.GetName()}
+	for _, a := range n.Status.Addresses {
+		switch a.Type {
+		case core.NodeInternalIP:
+			d.InternalIP = a.Address
+		case core.NodeExternalIP:
+			d.ExternalIP = a.Address
+		case core.NodeHostName:
+			d.Hostname = a.Address
+		case core.NodeInternalDNS:
+			d.InternalDNS = a.Address
+		case core.NodeExternalDNS:
+			d.ExternalDNS = a.Address
+		}
+	}
+	return d
+}
+
+// An EndpointProber is a ConditionProber that probes an HTTP, HTTPS, or TCP
+// endpoint of each node. The endpoint is a URL template, for example
+// http://{{.InternalIP}}:8080/healthz or tcp://{{.InternalIP}}:10250. HTTP
+// probes fail unless they return a 2xx status code. TCP probes fail unless a
+// connection can be established. Nodes are considered to exhibit a problem
+// once their endpoint fails to respond successfully to a number of
+// consecutive probes.
+type EndpointProber struct {
+	endpoint  *template.Template
+	client    *http.Client
+	timeout   time.Duration
+	threshold int
+
+	mu       sync.Mutex
+	failures map[string]int
+}
+
+// EndpointProberOption configures an EndpointProber.
+type EndpointProberOption func(p *EndpointProber)
+
+// WithEndpointProbeTimeout configures how long to wait for each probe.
+func WithEndpointProbeTimeout(t time.Duration) EndpointProberOption {
+	return func(p *EndpointProber) {
+		p.timeout = t
+	}
+}
+
+// WithEndpointProbeFailureThreshold configures how many consecutive probes of
+// a node must fail before it is considered to exhibit a problem.
+func WithEndpointProbeFailureThreshold(t int) EndpointProberOption {
+	return func(p *EndpointProber) {
+		p.threshold = t
+	}
+}
+
+// NewEndpointProber returns a ConditionProber that probes the supplied
+// endpoint template of each node.
+func NewEndpointProber(endpoint string, po ...EndpointProberOption) (*EndpointProber, error) {
+	t, err := template.New("endpoint").Option("missingkey=error").Parse(endpoint)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot parse endpoint template %s", endpoint)
+	}
+	p := &EndpointProber{
+		endpoint:  t,
+		timeout:   DefaultEndpointProbeTimeout,
+		threshold: DefaultEndpointProbeFailureThreshold,
+		failures:  make(map[string]int),
+	}
+	for _, o := range po {
+		o(p)
+	}
+	p.client = &http.Client{Timeout: p.timeout}
+	return p, nil
+}
+
+// Probe the supplied nodes' endpoints.
+func (p *EndpointProber) Probe(nodes []*core.Node) (map[string]string, error) {
+	type result struct {
+		node string
+		err  error
+	}
+	results := make(chan result, len(nodes))
+	sem := make(chan struct{}, maxConcurrentEndpointProbes)
+	for _, n := range nodes {
+		go func(n *core.Node) {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results <- result{node: n.GetName(), err: p.probe(n)}
+		}(n)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	firing := make(map[string]string)
+	seen := make(map[string]bool, len(nodes))
+	for range nodes {
+		r := <-results
+		seen[r.node] = true
+		if r.err == nil {
+			delete(p.failures, r.node)
+			continue
+		}
+		p.failures[r.node]++
+		if p.failures[r.node] >= p.threshold {
+			firing[r.node] = fmt.Sprintf("%d consecutive endpoint probes failed: %v", p.failures[r.node], r.err)
+		}
+	}
+	// Forget nodes that no longer exist.
+	for n := range p.failures {
+		if !seen[n] {
+			delete(p.failures, n)
+		}
+	}
+	return firing, nil
+}
+
+func (p *EndpointProber) probe(n *core.Node) error {
+	b := &bytes.Buffer{}
+	if err := p.endpoint.Execute(b, newEndpointTemplateData(n)); err != nil {
+		return errors.Wrap(err, "cannot render endpoint template")
+	}
+	u, err := url.Parse(b.String())
+	if err != nil {
+		return errors.Wrapf(err, "cannot parse endpoint %s", b.String())
+	}
+
+	switch u.Scheme {
+	case schemeHTTP, schemeHTTPS:
+		rsp, err := p.client.Get(u.String())
+		if err != nil {
+			return errors.Wrapf(err, "cannot probe %s", u)
+		}
+		rsp.Body.Close() // nolint:gosec
+		if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+			return errors.Errorf("probe of %s returned %s", u, rsp.Status)
+		}
+		return nil
+	case schemeTCP:
+		c, err := net.DialTimeout(schemeTCP, u.Host, p.timeout)
+		if err != nil {
+			return errors.Wrapf(err, "cannot connect to %s", u.Host)
+		}
+		c.Close() // nolint:gosec
+		return nil
+	default:
+		return errors.Errorf("unsupported endpoint scheme %q", u.Scheme)
+	}
+}
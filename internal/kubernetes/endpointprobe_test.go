@@ -0,0 +1,195 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"testing"
+
+	"github.com/go-test/deep"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newAddressedNode(name, ip string) *core.Node {
+	return &core.Node{
+		ObjectMeta: meta.ObjectMeta{Name: name},
+		Status: core.NodeStatus{Addresses: []core.NodeAddress{
+			{Type: core.NodeHostName, Address: name},
+			{Type: core.NodeInternalIP, Address: ip},
+		}},
+	}
+}
+
+func TestEndpointProber(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body.Close()
+	}))
+	defer healthy.Close()
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body.Close()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unhealthy.Close()
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen(...): %v", err)
+	}
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	host := func(s *httptest.Server) string {
+		u, _ := url.Parse(s.URL)
+		return u.Host
+	}
+
+	cases := []struct {
+		name      string
+		endpoint  string
+		threshold int
+		nodes     []*core.Node
+		probes    int
+		want      []string
+		wantErr   bool
+	}{
+		{
+			name:      "HTTPHealthy",
+			endpoint:  "http://{{.InternalIP}}/healthz",
+			threshold: 1,
+			nodes:     []*core.Node{newAddressedNode("a", host(healthy))},
+			probes:    1,
+			want:      []string{},
+		},
+		{
+			name:      "HTTPUnhealthy",
+			endpoint:  "http://{{.InternalIP}}/healthz",
+			threshold: 1,
+			nodes:     []*core.Node{newAddressedNode("a", host(healthy)), newAddressedNode("b", host(unhealthy))},
+			probes:    1,
+			want:      []string{"b"},
+		},
+		{
+			name:      "HTTPUnhealthyBelowThreshold",
+			endpoint:  "http://{{.InternalIP}}/healthz",
+			threshold: 3,
+			nodes:     []*core.Node{newAddressedNode("a", host(unhealthy))},
+			probes:    2,
+			want:      []string{},
+		},
+		{
+			name:      "HTTPUnhealthyAtThreshold",
+			endpoint:  "http://{{.InternalIP}}/healthz",
+			threshold: 3,
+			nodes:     []*core.Node{newAddressedNode("a", host(unhealthy))},
+			probes:    3,
+			want:      []string{"a"},
+		},
+		{
+			name:      "TCPHealthy",
+			endpoint:  "tcp://{{.InternalIP}}",
+			threshold: 1,
+			nodes:     []*core.Node{newAddressedNode("a", host(healthy))},
+			probes:    1,
+			want:      []string{},
+		},
+		{
+			name:      "TCPUnreachable",
+			endpoint:  "tcp://{{.InternalIP}}",
+			threshold: 1,
+			nodes:     []*core.Node{newAddressedNode("a", closedAddr)},
+			probes:    1,
+			want:      []string{"a"},
+		},
+		{
+			name:      "UnsupportedScheme",
+			endpoint:  "udp://{{.InternalIP}}",
+			threshold: 1,
+			nodes:     []*core.Node{newAddressedNode("a", host(healthy))},
+			probes:    1,
+			want:      []string{"a"},
+		},
+		{
+			name:     "InvalidTemplate",
+			endpoint: "http://{{.InternalIP",
+			wantErr:  true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := NewEndpointProber(tc.endpoint, WithEndpointProbeFailureThreshold(tc.threshold))
+			if err != nil {
+				if tc.wantErr {
+					return
+				}
+				t.Fatalf("NewEndpointProber(%q): %v", tc.endpoint, err)
+			}
+			if tc.wantErr {
+				t.Fatalf("NewEndpointProber(%q): want error", tc.endpoint)
+			}
+
+			var firing map[string]string
+			for i := 0; i < tc.probes; i++ {
+				firing, err = p.Probe(tc.nodes)
+				if err != nil {
+					t.Fatalf("p.Probe(...): %v", err)
+				}
+			}
+			got := make([]string, 0, len(firing))
+			for n := range firing {
+				got = append(got, n)
+			}
+			sort.Strings(got)
+			if diff := deep.Equal(tc.want, got); diff != nil {
+				t.Errorf("p.Probe(...): want != got: %v", diff)
+			}
+		})
+	}
+}
+
+func TestEndpointProberResetsOnSuccess(t *testing.T) {
+	healthy := true
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body.Close()
+		if !healthy {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer s.Close()
+	u, _ := url.Parse(s.URL)
+	nodes := []*core.Node{newAddressedNode("a", u.Host)}
+
+	p, err := NewEndpointProber("http://{{.InternalIP}}", WithEndpointProbeFailureThreshold(2))
+	if err != nil {
+		t.Fatalf("NewEndpointProber(...): %v", err)
+	}
+	for _, h := range []bool{false, true, false} {
+		healthy = h
+		firing, err := p.Probe(nodes)
+		if err != nil {
+			t.Fatalf("p.Probe(...): %v", err)
+		}
+		if len(firing) > 0 {
+			t.Errorf("p.Probe(...): want no nodes firing, got %v", firing)
+		}
+	}
+}
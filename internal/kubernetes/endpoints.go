@@ -0,0 +1,108 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const eventReasonEvictionRefused = "EvictionRefused"
+
+// NewSoleEndpointPodFilter returns a FilterFunc that returns false if the
+// supplied pod is the only ready endpoint of any Service, so that draining its
+// node would leave the Service without endpoints. Only pods in the supplied
+// namespaces are considered, or pods in all namespaces if none are supplied.
+func NewSoleEndpointPodFilter(client kubernetes.Interface, namespaces ...string) PodFilterFunc {
+	sole := soleEndpointsIn(client, namespaces...)
+	return func(p core.Pod) (bool, error) {
+		services, err := sole(p)
+		if err != nil {
+			return false, err
+		}
+		return len(services) == 0, nil
+	}
+}
+
+// NewSoleEndpointRefusal returns a function that describes why the supplied
+// pod was not evicted if it is the only ready endpoint of any Service, for use
+// with WithEvictionRefusedEvents. Only pods in the supplied namespaces are
+// considered, or pods in all namespaces if none are supplied.
+func NewSoleEndpointRefusal(client kubernetes.Interface, namespaces ...string) func(p core.Pod) string {
+	sole := soleEndpointsIn(client, namespaces...)
+	return func(p core.Pod) string {
+		services, err := sole(p)
+		if err != nil || len(services) == 0 {
+			return ""
+		}
+		return "it is the only ready endpoint of Service " + strings.Join(services, ",")
+	}
+}
+
+// soleEndpointsIn returns a function that returns the names of the Services of
+// which the supplied pod is the only ready endpoint, if the pod is in one of
+// the supplied namespaces or no namespaces are supplied.
+func soleEndpointsIn(client kubernetes.Interface, namespaces ...string) func(p core.Pod) ([]string, error) {
+	ns := make(map[string]bool)
+	for _, n := range namespaces {
+		ns[n] = true
+	}
+	return func(p core.Pod) ([]string, error) {
+		if len(ns) > 0 && !ns[p.GetNamespace()] {
+			return nil, nil
+		}
+		return SoleEndpointOf(client, p)
+	}
+}
+
+// SoleEndpointOf returns the names of the Services of which the supplied pod
+// is the only ready endpoint.
+func SoleEndpointOf(client kubernetes.Interface, p core.Pod) ([]string, error) {
+	l, err := client.CoreV1().Endpoints(p.GetNamespace()).List(meta.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot list endpoints in namespace %s", p.GetNamespace())
+	}
+	services := []string{}
+	for _, ep := range l.Items {
+		if soleEndpoint(ep, p) {
+			services = append(services, ep.GetName())
+		}
+	}
+	sort.Strings(services)
+	return services, nil
+}
+
+// soleEndpoint returns true if the supplied pod is the only ready address of
+// the supplied endpoints.
+func soleEndpoint(ep core.Endpoints, p core.Pod) bool {
+	found, others := false, false
+	for _, s := range ep.Subsets {
+		for _, a := range s.Addresses {
+			if a.TargetRef != nil && a.TargetRef.Kind == "Pod" && a.TargetRef.Name == p.GetName() {
+				found = true
+				continue
+			}
+			others = true
+		}
+	}
+	return found && !others
+}
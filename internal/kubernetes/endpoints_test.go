@@ -0,0 +1,97 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newEndpoints(service string, pods ...string) *core.Endpoints {
+	addresses := make([]core.EndpointAddress, 0, len(pods))
+	for _, p := range pods {
+		addresses = append(addresses, core.EndpointAddress{TargetRef: &core.ObjectReference{Kind: "Pod", Namespace: ns, Name: p}})
+	}
+	return &core.Endpoints{
+		ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: service},
+		Subsets:    []core.EndpointSubset{{Addresses: addresses}},
+	}
+}
+
+func TestSoleEndpointPodFilter(t *testing.T) {
+	pod := core.Pod{ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: podName}}
+
+	cases := []struct {
+		name         string
+		endpoints    []runtime.Object
+		namespaces   []string
+		passesFilter bool
+		wantRefusal  bool
+	}{
+		{
+			name:         "NoServices",
+			passesFilter: true,
+		},
+		{
+			name:        "SoleEndpoint",
+			endpoints:   []runtime.Object{newEndpoints("web", podName)},
+			wantRefusal: true,
+		},
+		{
+			name:         "OneOfManyEndpoints",
+			endpoints:    []runtime.Object{newEndpoints("web", podName, "other")},
+			passesFilter: true,
+		},
+		{
+			name:         "OtherPodsEndpoint",
+			endpoints:    []runtime.Object{newEndpoints("web", "other")},
+			passesFilter: true,
+		},
+		{
+			name:        "SoleEndpointInProtectedNamespace",
+			endpoints:   []runtime.Object{newEndpoints("web", podName)},
+			namespaces:  []string{ns},
+			wantRefusal: true,
+		},
+		{
+			name:         "SoleEndpointInOtherNamespace",
+			endpoints:    []runtime.Object{newEndpoints("web", podName)},
+			namespaces:   []string{"otherNamespace"},
+			passesFilter: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := fake.NewSimpleClientset(tc.endpoints...)
+			passesFilter, err := NewSoleEndpointPodFilter(c, tc.namespaces...)(pod)
+			if err != nil {
+				t.Fatalf("NewSoleEndpointPodFilter(...)(%v): %v", pod.GetName(), err)
+			}
+			if passesFilter != tc.passesFilter {
+				t.Errorf("NewSoleEndpointPodFilter(...)(%v): want %v, got %v", pod.GetName(), tc.passesFilter, passesFilter)
+			}
+			if gotRefusal := NewSoleEndpointRefusal(c, tc.namespaces...)(pod) != ""; gotRefusal != tc.wantRefusal {
+				t.Errorf("NewSoleEndpointRefusal(...)(%v) described refusal: want %v, got %v", pod.GetName(), tc.wantRefusal, gotRefusal)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	core "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	eventReasonDrainEstimated = "DrainEstimated"
+
+	// estimateRetention is how long estimates are reported after their
+	// simulated drain finishes.
+	estimateRetention = 24 * time.Hour
+)
+
+// A DrainEstimate describes how long a simulated drain would have taken.
+type DrainEstimate struct {
+	Node        string    `json:"node"`
+	Started     time.Time `json:"started"`
+	Finished    time.Time `json:"finished"`
+	Pods        int       `json:"pods"`
+	Estimate    string    `json:"estimate"`
+	Timeout     string    `json:"timeout"`
+	BlockedBy   []string  `json:"blockedBy,omitempty"`
+	TooManyPods bool      `json:"tooManyPods,omitempty"`
+}
+
+// An EstimatingCordonDrainer simulates how long drains would take. It plans
+// each drain as the supplied APICordonDrainer would perform it, reports how
+// long the drain would take, then waits that long before draining the node
+// using the CordonDrainer it wraps, typically a NoopCordonDrainer. Simulated
+// drains therefore hold their place in the DrainScheduler's concurrency limits
+// for as long as real drains would, so the schedule reflects how drains
+// would be paced. Simulated drains are interrupted once Run is stopped.
+type EstimatingCordonDrainer struct {
+	CordonDrainer
+
+	l       *zap.Logger
+	e       record.EventRecorder
+	planner *APICordonDrainer
+	filters []NamedPodFilter
+	after   func(d time.Duration) <-chan time.Time
+	stop    chan struct{}
+
+	mu        sync.Mutex
+	estimates map[string]DrainEstimate
+}
+
+// EstimatingCordonDrainerOption configures an EstimatingCordonDrainer.
+type EstimatingCordonDrainerOption func(d *EstimatingCordonDrainer)
+
+// WithEstimateLogger configures an EstimatingCordonDrainer to use the
+// supplied logger.
+func WithEstimateLogger(l *zap.Logger) EstimatingCordonDrainerOption {
+	return func(d *EstimatingCordonDrainer) {
+		d.l = l
+	}
+}
+
+// NewEstimatingCordonDrainer returns a CordonDrainer that estimates the drains
+// performed by the supplied CordonDrainer, recording an event about each
+// estimate. Pods that would not be evicted are attributed to the first of the
+// supplied filters that rejects them.
+func NewEstimatingCordonDrainer(d CordonDrainer, planner *APICordonDrainer, e record.EventRecorder, filters []NamedPodFilter, eo ...EstimatingCordonDrainerOption) *EstimatingCordonDrainer {
+	ed := &EstimatingCordonDrainer{
+		CordonDrainer: d,
+		l:             zap.NewNop(),
+		e:             e,
+		planner:       planner,
+		filters:       filters,
+		after:         time.After,
+		stop:          make(chan struct{}),
+		estimates:     make(map[string]DrainEstimate),
+	}
+	for _, o := range eo {
+		o(ed)
+	}
+	return ed
+}
+
+// Drain the supplied node once the time it would take to drain has elapsed.
+func (d *EstimatingCordonDrainer) Drain(n *core.Node) error {
+	p, err := d.planner.Plan(n, d.filters...)
+	if err != nil {
+		return errors.Wrapf(err, "cannot estimate drain of node %s", n.GetName())
+	}
+	started := time.Now()
+	est := DrainEstimate{
+		Node:        n.GetName(),
+		Started:     started,
+		Finished:    started,
+		Pods:        len(p.Evict),
+		Estimate:    p.Estimate.String(),
+		Timeout:     p.Timeout.String(),
+		BlockedBy:   p.Blocked(),
+		TooManyPods: p.TooManyPods,
+	}
+	if p.TooManyPods {
+		d.record(est)
+		return d.planner.tooManyPods(len(p.Evict))
+	}
+	est.Finished = started.Add(p.Estimate)
+	d.record(est)
+
+	log := d.l.With(zap.String("node", n.GetName()), zap.Int("pods", len(p.Evict)), zap.Duration("estimate", p.Estimate), zap.Duration("timeout", p.Timeout))
+	msg := "Drain would evict %d pods and take up to %s"
+	args := []interface{}{len(p.Evict), p.Estimate}
+	if len(est.BlockedBy) > 0 {
+		log = log.With(zap.Strings("blockedBy", est.BlockedBy))
+		msg += ", once pod disruption budgets %s allow evictions"
+		args = append(args, strings.Join(est.BlockedBy, ", "))
+	}
+	log.Info("Estimated drain")
+	d.e.Eventf(n, core.EventTypeNormal, eventReasonDrainEstimated, msg, args...)
+
+	select {
+	case <-d.after(p.Estimate):
+	case <-d.stop:
+		return errors.Errorf("cannot finish simulated drain of node %s: shutting down", n.GetName())
+	}
+	return d.CordonDrainer.Drain(n)
+}
+
+// Run until the supplied channel is closed, at which point any simulated
+// drains that are waiting are interrupted.
+func (d *EstimatingCordonDrainer) Run(stop <-chan struct{}) {
+	<-stop
+	close(d.stop)
+}
+
+func (d *EstimatingCordonDrainer) record(e DrainEstimate) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.estimates[e.Node] = e
+}
+
+// Estimates returns the most recent estimate of each node whose simulated
+// drain is in progress or finished recently, in order of start time.
+func (d *EstimatingCordonDrainer) Estimates() []DrainEstimate {
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	estimates := []DrainEstimate{}
+	for name, e := range d.estimates {
+		if now.Sub(e.Finished) > estimateRetention {
+			delete(d.estimates, name)
+			continue
+		}
+		estimates = append(estimates, e)
+	}
+	sort.Slice(estimates, func(i, j int) bool {
+		if !estimates[i].Started.Equal(estimates[j].Started) {
+			return estimates[i].Started.Before(estimates[j].Started)
+		}
+		return estimates[i].Node < estimates[j].Node
+	})
+	return estimates
+}
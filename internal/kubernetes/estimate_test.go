@@ -0,0 +1,124 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestEstimatingCordonDrainer(t *testing.T) {
+	grace := int64(60)
+	objs := []runtime.Object{&core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}}
+	for _, name := range []string{"a", "b"} {
+		objs = append(objs, &core.Pod{
+			ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: name},
+			Spec:       core.PodSpec{NodeName: nodeName, TerminationGracePeriodSeconds: &grace},
+		})
+	}
+
+	cases := []struct {
+		name       string
+		maxPods    int
+		wantErr    bool
+		wantWait   time.Duration
+		wantEvents []string
+		wantPods   int
+	}{
+		{
+			name:       "Estimated",
+			wantWait:   time.Minute,
+			wantEvents: []string{"Normal DrainEstimated Drain would evict 2 pods and take up to 1m0s"},
+			wantPods:   2,
+		},
+		{
+			name:     "TooManyPods",
+			maxPods:  1,
+			wantErr:  true,
+			wantPods: 2,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := fake.NewSimpleClientset(objs...)
+			e := record.NewFakeRecorder(10)
+			planner := NewAPICordonDrainer(c, MaxGracePeriod(5*time.Minute), MaxPodsToEvict(tc.maxPods))
+			d := NewEstimatingCordonDrainer(&NoopCordonDrainer{}, planner, e, nil)
+			var waited time.Duration
+			d.after = func(d time.Duration) <-chan time.Time {
+				waited += d
+				c := make(chan time.Time, 1)
+				c <- time.Now()
+				return c
+			}
+
+			err := d.Drain(&core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}})
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("d.Drain(%v): want error %v, got %v", nodeName, tc.wantErr, err)
+			}
+			if tc.wantErr && !IsTooManyPods(err) {
+				t.Errorf("d.Drain(%v): want too many pods error, got %v", nodeName, err)
+			}
+			if waited != tc.wantWait {
+				t.Errorf("waited: want %s, got %s", tc.wantWait, waited)
+			}
+			close(e.Events)
+			var got []string
+			for ev := range e.Events {
+				got = append(got, ev)
+			}
+			if diff := deep.Equal(tc.wantEvents, got); diff != nil {
+				t.Errorf("events: want != got: %v", diff)
+			}
+
+			estimates := d.Estimates()
+			if len(estimates) != 1 {
+				t.Fatalf("d.Estimates(): want 1 estimate, got %v", estimates)
+			}
+			est := estimates[0]
+			if est.Node != nodeName || est.Pods != tc.wantPods || est.TooManyPods != tc.wantErr {
+				t.Errorf("d.Estimates()[0]: want %d pods on %s (too many %v), got %+v", tc.wantPods, nodeName, tc.wantErr, est)
+			}
+			if got := est.Finished.Sub(est.Started); got != tc.wantWait {
+				t.Errorf("estimated duration: want %s, got %s", tc.wantWait, got)
+			}
+		})
+	}
+}
+
+func TestEstimatingCordonDrainerStopped(t *testing.T) {
+	c := fake.NewSimpleClientset(&core.Pod{
+		ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: podName},
+		Spec:       core.PodSpec{NodeName: nodeName},
+	})
+	d := NewEstimatingCordonDrainer(&NoopCordonDrainer{}, NewAPICordonDrainer(c, MaxGracePeriod(time.Hour)), record.NewFakeRecorder(10), nil)
+	stop := make(chan struct{})
+	close(stop)
+	d.Run(stop)
+
+	if err := d.Drain(&core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}); err == nil {
+		t.Errorf("d.Drain(%v): want error once stopped", nodeName)
+	}
+}
@@ -18,50 +18,149 @@ package kubernetes
 
 import (
 	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/pkg/errors"
 	"go.opencensus.io/stats"
 	"go.opencensus.io/tag"
 	"go.uber.org/zap"
 	core "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
 )
 
 const (
-	// DefaultDrainBuffer is the default minimum time between node drains.
-	DefaultDrainBuffer = 10 * time.Minute
-
 	eventReasonCordonStarting  = "CordonStarting"
 	eventReasonCordonSucceeded = "CordonSucceeded"
 	eventReasonCordonFailed    = "CordonFailed"
+	eventReasonCordonDeferred  = "CordonDeferred"
 
 	eventReasonDrainScheduled = "DrainScheduled"
 	eventReasonDrainStarting  = "DrainStarting"
 	eventReasonDrainSucceeded = "DrainSucceeded"
 	eventReasonDrainFailed    = "DrainFailed"
+	eventReasonDrainExpired   = "DrainExpired"
+	eventReasonDrainSkipped   = "DrainSkipped"
+
+	eventReasonPreDrainFailed  = "PreDrainFailed"
+	eventReasonPostDrainFailed = "PostDrainFailed"
+	eventReasonNotifyFailed    = "NotifyFailed"
+	eventReasonReplaceFailed   = "ReplaceFailed"
+	eventReasonSoaking         = "Soaking"
+	eventReasonSoakCancelled   = "SoakCancelled"
+	eventReasonCoolingDown     = "DrainCoolingDown"
 
 	tagResultSucceeded = "succeeded"
 	tagResultFailed    = "failed"
+	tagResultSkipped   = "skipped"
+	tagResultAborted   = "aborted"
+	tagResultStuck     = "stuck"
+	tagResultRemoved   = "removed"
+	tagResultDeferred  = "deferred"
 )
 
 // Opencensus measurements.
 var (
 	MeasureNodesCordoned = stats.Int64("draino/nodes_cordoned", "Number of nodes cordoned.", stats.UnitDimensionless)
 	MeasureNodesDrained  = stats.Int64("draino/nodes_drained", "Number of nodes drained.", stats.UnitDimensionless)
+	MeasurePodsEvicted   = stats.Int64("draino/pods_evicted", "Number of pods evicted.", stats.UnitDimensionless)
+
+	MeasureDrainDuration   = stats.Float64("draino/drain_duration", "Seconds taken to drain a node.", "s")
+	MeasureEvictionLatency = stats.Float64("draino/eviction_latency", "Seconds taken to evict a pod, until it was deleted.", "s")
+
+	MeasureThrottledRequests = stats.Int64("draino/throttled_requests", "Number of API requests the API server throttled.", stats.UnitDimensionless)
+
+	MeasureStuckPodFinalizers  = stats.Int64("draino/stuck_pod_finalizers", "Number of finalizers that blocked deletion of evicted pods.", stats.UnitDimensionless)
+	MeasurePodDeletionTimeouts = stats.Int64("draino/pod_deletion_timeouts", "Number of evicted pods that were not deleted in time.", stats.UnitDimensionless)
+
+	MeasureCordonedNodes    = stats.Int64("draino/cordoned_nodes", "Number of nodes currently cordoned by draino.", stats.UnitDimensionless)
+	MeasureDrainFailedNodes = stats.Int64("draino/drain_failed_nodes", "Number of nodes currently cordoned by draino that it failed to drain.", stats.UnitDimensionless)
+	MeasureSinceDrained     = stats.Float64("draino/since_drained", "Seconds since draino last drained a node successfully.", "s")
+
+	MeasureUnschedulablePods  = stats.Int64("draino/unschedulable_pods", "Number of pending pods that could not be scheduled.", stats.UnitDimensionless)
+	MeasurePendingPodsTripped = stats.Int64("draino/pending_pods_breaker_tripped", "Whether too many unschedulable pending pods have paused new drains.", stats.UnitDimensionless)
 
-	TagNodeName, _ = tag.NewKey("node_name")
-	TagResult, _   = tag.NewKey("result")
+	MeasureStormDetected = stats.Int64("draino/correlated_failure_detected", "Whether a correlated failure has paused remediation until it is acknowledged.", stats.UnitDimensionless)
+
+	TagNodeName, _  = tag.NewKey("node_name")
+	TagNodePool, _  = tag.NewKey("node_pool")
+	TagCluster, _   = tag.NewKey("cluster")
+	TagShard, _     = tag.NewKey("shard")
+	TagResult, _    = tag.NewKey("result")
+	TagReason, _    = tag.NewKey("reason")
+	TagOwnerKind, _ = tag.NewKey("owner_kind")
+	TagFinalizer, _ = tag.NewKey("finalizer")
+
+	TagErrorClass, _ = tag.NewKey("error_class")
 )
 
+// A PreDrainFunc acts on a node before it is drained, for example to drain
+// connections from a load balancer running on the node.
+type PreDrainFunc func(n *core.Node) error
+
+// A PostDrainFunc acts on a node after it has been successfully drained, for
+// example to terminate the machine that backs it.
+type PostDrainFunc func(n *core.Node) error
+
 // A DrainingResourceEventHandler cordons and drains any added or updated nodes.
 type DrainingResourceEventHandler struct {
-	l *zap.Logger
-	d CordonDrainer
-	e record.EventRecorder
+	l    *zap.Logger
+	d    CordonDrainer
+	e    record.EventRecorder
+	s    *DrainScheduler
+	pre  []PreDrainFunc
+	post []PostDrainFunc
+
+	pipelines NodePipelineFunc
+	notify    []func(n *core.Node) error
+	replace   []PostDrainFunc
+	uncordon  func(n *core.Node) error
+
+	mirror *MirrorPodHandler
+	limit  *CordonLimiter
+
+	poolLabels []string
+	cluster    string
+	shard      string
+	reasons    func(n *core.Node) []string
 
-	lastDrainScheduledFor time.Time
-	buffer                time.Duration
+	cooldown  time.Duration
+	protected func(o interface{}) bool
+	filters   []func(o interface{}) bool
+	check     func(n *core.Node) error
+	lock      DrainLock
+
+	mu          sync.Mutex
+	lastDrained time.Time
+	deferred    map[string]string
+	skipped     map[string]string
+	failed      map[string]time.Time
+	locked      map[string]bool
+	draining    map[string]bool
+	uncordoned  map[string]string
+	soaking     map[string]*soak
+	stopped     bool
+}
+
+// A soak is a node that is waiting to have its drain scheduled.
+type soak struct {
+	t      *time.Timer
+	cancel func(recovered bool, reason string)
+}
+
+// A DrainCooldown describes a node that will not be cordoned or drained again
+// until its cooldown ends, because its drain failed.
+type DrainCooldown struct {
+	Node      string    `json:"node"`
+	FailedAt  time.Time `json:"failedAt"`
+	Until     time.Time `json:"until"`
+	Remaining string    `json:"remaining"`
 }
 
 // DrainingResourceEventHandlerOption configures an DrainingResourceEventHandler.
@@ -75,35 +174,312 @@ func WithLogger(l *zap.Logger) DrainingResourceEventHandlerOption {
 	}
 }
 
-// WithDrainBuffer configures the minimum time between scheduled drains.
-func WithDrainBuffer(d time.Duration) DrainingResourceEventHandlerOption {
+// WithDrainScheduler configures a DrainingResourceEventHandler to schedule
+// drains using the supplied scheduler.
+func WithDrainScheduler(s *DrainScheduler) DrainingResourceEventHandlerOption {
+	return func(h *DrainingResourceEventHandler) {
+		h.s = s
+	}
+}
+
+// WithPreDrainFuncs configures a DrainingResourceEventHandler to call the
+// supplied functions, in order, before each node is drained. Pods are evicted
+// only once they have all succeeded. A drain is considered failed if any of
+// them return an error.
+func WithPreDrainFuncs(fn ...PreDrainFunc) DrainingResourceEventHandlerOption {
+	return func(h *DrainingResourceEventHandler) {
+		h.pre = append(h.pre, fn...)
+	}
+}
+
+// WithPostDrainFuncs configures a DrainingResourceEventHandler to call the
+// supplied functions, in order, after each node is successfully drained. A
+// drain is considered failed if any of them return an error.
+func WithPostDrainFuncs(fn ...PostDrainFunc) DrainingResourceEventHandlerOption {
+	return func(h *DrainingResourceEventHandler) {
+		h.post = append(h.post, fn...)
+	}
+}
+
+// WithMirrorPodHandler configures a DrainingResourceEventHandler to report,
+// and optionally stop, the mirror pods that remain on each drained node. A
+// drain is considered failed if the mirror pods cannot be stopped.
+func WithMirrorPodHandler(m *MirrorPodHandler) DrainingResourceEventHandlerOption {
+	return func(h *DrainingResourceEventHandler) {
+		h.mirror = m
+	}
+}
+
+// WithCordonLimiter configures a DrainingResourceEventHandler to cordon nodes
+// only while the supplied limiter allows. Nodes that may not be cordoned are
+// recorded as candidates with a warning event each time their cordon is
+// deferred for a new reason, and are cordoned once they are handled again and
+// the limiter allows.
+func WithCordonLimiter(l *CordonLimiter) DrainingResourceEventHandlerOption {
+	return func(h *DrainingResourceEventHandler) {
+		h.limit = l
+	}
+}
+
+// WithNodePoolLabels configures the labels that identify a node's node pool,
+// for example LabelGKENodePool. Metrics are tagged with the value of the first
+// of these labels that a node has.
+func WithNodePoolLabels(labels ...string) DrainingResourceEventHandlerOption {
+	return func(h *DrainingResourceEventHandler) {
+		h.poolLabels = append(h.poolLabels, labels...)
+	}
+}
+
+// WithClusterName configures a DrainingResourceEventHandler to tag metrics
+// with the name of the cluster whose nodes it handles.
+func WithClusterName(name string) DrainingResourceEventHandlerOption {
+	return func(h *DrainingResourceEventHandler) {
+		h.cluster = name
+	}
+}
+
+// WithShard configures a DrainingResourceEventHandler to tag metrics with the
+// shard of nodes it handles.
+func WithShard(index int) DrainingResourceEventHandlerOption {
+	return func(h *DrainingResourceEventHandler) {
+		h.shard = strconv.Itoa(index)
+	}
+}
+
+// WithCordonReasons configures a DrainingResourceEventHandler to tag cordon
+// metrics, and describe cordon events, with the reasons the supplied function
+// returns for cordoning each node.
+func WithCordonReasons(fn func(n *core.Node) []string) DrainingResourceEventHandlerOption {
+	return func(h *DrainingResourceEventHandler) {
+		h.reasons = fn
+	}
+}
+
+// WithNodeActions configures a DrainingResourceEventHandler to take the action
+// the supplied function returns for each node whose conditions or labels cause
+// it to be cordoned, for example to only cordon nodes with a condition that
+// does not warrant evicting their pods. It is shorthand for WithNodePipelines
+// using the pipeline of each action. Nodes are otherwise cordoned, drained,
+// and passed to any post-drain functions. Requested drains always take
+// ActionTerminate.
+func WithNodeActions(fn NodeActionFunc) DrainingResourceEventHandlerOption {
+	return WithNodePipelines(func(n *core.Node) Pipeline {
+		return fn(n).Pipeline()
+	})
+}
+
+// WithNodePipelines configures a DrainingResourceEventHandler to remediate
+// each node whose conditions or labels cause it to be cordoned using the
+// pipeline the supplied function returns, for example to only cordon nodes
+// with a condition that does not warrant evicting their pods. Nodes otherwise,
+// and requested drains always, use the DefaultPipeline.
+func WithNodePipelines(fn NodePipelineFunc) DrainingResourceEventHandlerOption {
+	return func(h *DrainingResourceEventHandler) {
+		h.pipelines = fn
+	}
+}
+
+// WithNotifyFuncs configures a DrainingResourceEventHandler to call the
+// supplied functions, in order, before cordoning each node whose pipeline
+// includes StepNotify. Failures are logged, and do not stop the pipeline.
+func WithNotifyFuncs(fn ...func(n *core.Node) error) DrainingResourceEventHandlerOption {
+	return func(h *DrainingResourceEventHandler) {
+		h.notify = append(h.notify, fn...)
+	}
+}
+
+// WithReplaceFuncs configures a DrainingResourceEventHandler to call the
+// supplied functions, in order, after the post-drain functions of each node
+// whose pipeline includes StepReplace, for example to terminate the machine
+// that backs the node. A drain is considered failed if any of them return an
+// error.
+func WithReplaceFuncs(fn ...PostDrainFunc) DrainingResourceEventHandlerOption {
+	return func(h *DrainingResourceEventHandler) {
+		h.replace = append(h.replace, fn...)
+	}
+}
+
+// WithUncordonFunc configures how a DrainingResourceEventHandler uncordons
+// each drained node whose pipeline includes StepUncordon, for example using a
+// NodeUncordonHook. Such nodes remain cordoned if no function is configured.
+func WithUncordonFunc(fn func(n *core.Node) error) DrainingResourceEventHandlerOption {
+	return func(h *DrainingResourceEventHandler) {
+		h.uncordon = fn
+	}
+}
+
+// WithFailedDrainCooldown configures a DrainingResourceEventHandler not to
+// cordon or drain a node again for the supplied duration after its drain
+// fails, even if it is handled or requested again, to avoid repeatedly
+// evicting pods from a node whose drain cannot succeed.
+func WithFailedDrainCooldown(d time.Duration) DrainingResourceEventHandlerOption {
+	return func(h *DrainingResourceEventHandler) {
+		h.cooldown = d
+	}
+}
+
+// WithProtectedNodes configures a DrainingResourceEventHandler to at most
+// cordon nodes that pass the supplied filter, for example control plane nodes,
+// regardless of their pipeline. Requested drains of such nodes fail once they
+// are cordoned.
+func WithProtectedNodes(filter func(o interface{}) bool) DrainingResourceEventHandlerOption {
 	return func(h *DrainingResourceEventHandler) {
-		h.buffer = d
+		h.protected = filter
+	}
+}
+
+// WithCordonFilters configures a DrainingResourceEventHandler to only cordon
+// nodes that pass all of the supplied filters, for example --node-filter-exec
+// commands. The filters run just before each node would be cordoned, rather
+// than each time any node is updated.
+func WithCordonFilters(filter ...func(o interface{}) bool) DrainingResourceEventHandlerOption {
+	return func(h *DrainingResourceEventHandler) {
+		h.filters = append(h.filters, filter...)
+	}
+}
+
+// WithDrainCheck configures a DrainingResourceEventHandler to check each node
+// it would drain with the supplied function, for example
+// APICordonDrainer.CheckPods, before doing anything to it. Nodes for which the
+// function returns an error for which IsTooManyPods is true are neither
+// cordoned nor drained; they are reconsidered when they are next handled.
+func WithDrainCheck(fn func(n *core.Node) error) DrainingResourceEventHandlerOption {
+	return func(h *DrainingResourceEventHandler) {
+		h.check = fn
+	}
+}
+
+// WithNodeLock configures a DrainingResourceEventHandler to hold the supplied
+// lock, for example a NodeLeaseLock, from before it cordons each node until
+// the node's pipeline finishes. Nodes whose lock cannot be acquired are not
+// cordoned; they are reconsidered when they are next handled.
+func WithNodeLock(l DrainLock) DrainingResourceEventHandlerOption {
+	return func(h *DrainingResourceEventHandler) {
+		h.lock = l
 	}
 }
 
 // NewDrainingResourceEventHandler returns a new DrainingResourceEventHandler.
 func NewDrainingResourceEventHandler(d CordonDrainer, e record.EventRecorder, ho ...DrainingResourceEventHandlerOption) *DrainingResourceEventHandler {
 	h := &DrainingResourceEventHandler{
-		l:                     zap.NewNop(),
-		d:                     d,
-		e:                     e,
-		lastDrainScheduledFor: time.Now(),
-		buffer:                DefaultDrainBuffer,
+		l:           zap.NewNop(),
+		d:           d,
+		e:           e,
+		lastDrained: time.Now(),
+		deferred:    make(map[string]string),
+		skipped:     make(map[string]string),
+		failed:      make(map[string]time.Time),
+		locked:      make(map[string]bool),
+		draining:    make(map[string]bool),
+		uncordoned:  make(map[string]string),
+		soaking:     make(map[string]*soak),
 	}
 	for _, o := range ho {
 		o(h)
 	}
+	if h.s == nil {
+		h.s = NewDrainScheduler()
+	}
 	return h
 }
 
-// OnAdd cordons and drains the added node.
+// LastDrained returns the time at which the handler last drained a node
+// successfully, or the time at which it was created if it has not yet drained
+// any nodes.
+func (h *DrainingResourceEventHandler) LastDrained() time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.lastDrained
+}
+
+// Draining returns true if the supplied object is a node that the handler has
+// cordoned, or is cordoning, and has not yet finished draining.
+func (h *DrainingResourceEventHandler) Draining(o interface{}) bool {
+	n, ok := o.(*core.Node)
+	if !ok {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.draining[n.GetName()]
+}
+
+// Cooldowns returns the nodes whose cooldown after a failed drain has not yet
+// ended, in order of node name.
+func (h *DrainingResourceEventHandler) Cooldowns() []DrainCooldown {
+	now := time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	cooldowns := []DrainCooldown{}
+	for name, failed := range h.failed {
+		until := failed.Add(h.cooldown)
+		if !until.After(now) {
+			delete(h.failed, name)
+			continue
+		}
+		cooldowns = append(cooldowns, DrainCooldown{Node: name, FailedAt: failed, Until: until, Remaining: until.Sub(now).Round(time.Second).String()})
+	}
+	sort.Slice(cooldowns, func(i, j int) bool { return cooldowns[i].Node < cooldowns[j].Node })
+	return cooldowns
+}
+
+// coolingDown returns the time at which the supplied node's cooldown ends,
+// and true if it has not yet ended.
+func (h *DrainingResourceEventHandler) coolingDown(n *core.Node, now time.Time) (time.Time, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	failed, ok := h.failed[n.GetName()]
+	if !ok {
+		return time.Time{}, false
+	}
+	until := failed.Add(h.cooldown)
+	if !until.After(now) {
+		delete(h.failed, n.GetName())
+		return time.Time{}, false
+	}
+	return until, true
+}
+
+// drainFailed starts the supplied node's cooldown, if one is configured.
+func (h *DrainingResourceEventHandler) drainFailed(log *zap.Logger, nr *core.ObjectReference, n *core.Node) {
+	if h.cooldown <= 0 {
+		return
+	}
+	now := time.Now()
+	h.mu.Lock()
+	h.failed[n.GetName()] = now
+	h.mu.Unlock()
+	until := now.Add(h.cooldown)
+	log.Info("Cooling down", zap.Time("until", until))
+	h.e.Eventf(nr, core.EventTypeWarning, eventReasonCoolingDown, "Will not retry drain until %s", until.Format(time.RFC3339Nano))
+}
+
+// Run blocks until the supplied channel is closed, then cancels the soaks of
+// any nodes that are waiting to have their drain scheduled.
+func (h *DrainingResourceEventHandler) Run(stop <-chan struct{}) {
+	<-stop
+	h.mu.Lock()
+	h.stopped = true
+	soaking := h.soaking
+	h.soaking = make(map[string]*soak)
+	h.mu.Unlock()
+	for _, s := range soaking {
+		s.t.Stop()
+		s.cancel(false, "shutting down")
+	}
+}
+
+// OnAdd cordons and drains the added node, unless the node was uncordoned
+// after it was drained and its conditions have not changed since.
 func (h *DrainingResourceEventHandler) OnAdd(obj interface{}) {
 	n, ok := obj.(*core.Node)
 	if !ok {
 		return
 	}
-	h.cordonAndDrain(n)
+	if h.remediated(n) {
+		return
+	}
+	h.cordonAndDrain(n, time.Time{}, h.pipelineFor(n), nil) // nolint:gosec
 }
 
 // OnUpdate cordons and drains the updated node.
@@ -111,56 +487,500 @@ func (h *DrainingResourceEventHandler) OnUpdate(_, newObj interface{}) {
 	h.OnAdd(newObj)
 }
 
-// OnDelete does nothing. There's no point cordoning or draining deleted nodes.
-func (h *DrainingResourceEventHandler) OnDelete(_ interface{}) {
-	return
+// OnDelete is called when a node is deleted or no longer needs cordoning, for
+// example because draino just cordoned it. There's no point cordoning or
+// draining deleted nodes, but nodes that no longer match any cordon reasons
+// have recovered: their soak is cancelled, and they will be remediated again
+// if they match once more.
+func (h *DrainingResourceEventHandler) OnDelete(obj interface{}) {
+	if d, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = d.Obj
+	}
+	n, ok := obj.(*core.Node)
+	if !ok {
+		return
+	}
+	if h.reasons == nil || len(h.reasons(n)) > 0 {
+		return
+	}
+	h.mu.Lock()
+	delete(h.uncordoned, n.GetName())
+	s, ok := h.soaking[n.GetName()]
+	delete(h.soaking, n.GetName())
+	h.mu.Unlock()
+	if ok {
+		s.t.Stop()
+		s.cancel(true, "node recovered")
+	}
+}
+
+// remediated returns true if the supplied node was uncordoned after it was
+// drained, and its conditions have not changed since. Its pipeline already
+// ran; running it again would drain the node every time it was uncordoned.
+func (h *DrainingResourceEventHandler) remediated(n *core.Node) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fp, ok := h.uncordoned[n.GetName()]
+	if !ok {
+		return false
+	}
+	if fp == conditionFingerprint(n) {
+		return true
+	}
+	delete(h.uncordoned, n.GetName())
+	return false
+}
+
+// Request that the supplied node be cordoned and drained, regardless of its
+// conditions. The drain is scheduled like any other. If the supplied deadline
+// is not zero and passes before the drain starts the drain will not happen.
+// Request returns an error if the node could not be cordoned or its drain
+// could not be scheduled. Otherwise the supplied function is called with the
+// result of the drain once it has finished.
+func (h *DrainingResourceEventHandler) Request(n *core.Node, deadline time.Time, done func(err error)) error {
+	return h.cordonAndDrain(n, deadline, DefaultPipeline(), done)
+}
+
+// pipelineFor returns the pipeline with which to remediate the supplied node.
+func (h *DrainingResourceEventHandler) pipelineFor(n *core.Node) Pipeline {
+	if h.pipelines == nil {
+		return DefaultPipeline()
+	}
+	return h.pipelines(n)
 }
 
-// TODO(negz): Ideally we'd record which node condition caused us to cordon
-// and drain the node, but that information doesn't make it down to this level.
-func (h *DrainingResourceEventHandler) cordonAndDrain(n *core.Node) {
+func (h *DrainingResourceEventHandler) cordonAndDrain(n *core.Node, deadline time.Time, p Pipeline, done func(err error)) error {
+	if done == nil {
+		done = func(_ error) {}
+	}
 	log := h.l.With(zap.String("node", n.GetName()))
-	tags, _ := tag.New(context.Background(), tag.Upsert(TagNodeName, n.GetName())) // nolint:gosec
+	tags, _ := tag.New(context.Background(), upsertLimited(TagNodeName, n.GetName())) // nolint:gosec
+	if h.cluster != "" {
+		tags, _ = tag.New(tags, tag.Upsert(TagCluster, h.cluster)) // nolint:gosec
+	}
+	if h.shard != "" {
+		tags, _ = tag.New(tags, tag.Upsert(TagShard, h.shard)) // nolint:gosec
+	}
+	if pool, ok := nodePool(n, h.poolLabels); ok {
+		tags, _ = tag.New(tags, upsertLimited(TagNodePool, pool)) // nolint:gosec
+	}
 	// Events must be associated with this object reference, rather than the
 	// node itself, in order to appear under `kubectl describe node` due to the
 	// way that command is implemented.
 	// https://github.com/kubernetes/kubernetes/blob/17740a2/pkg/printers/internalversion/describe.go#L2711
 	nr := &core.ObjectReference{Kind: "Node", Name: n.GetName(), UID: types.UID(n.GetName())}
 
+	if until, ok := h.coolingDown(n, time.Now()); ok {
+		log.Debug("Not cordoning while cooling down", zap.Time("until", until))
+		return errors.Errorf("drain of node %s failed recently; cooling down until %s", n.GetName(), until.Format(time.RFC3339))
+	}
+	h.mu.Lock()
+	_, soaking := h.soaking[n.GetName()]
+	h.mu.Unlock()
+	if soaking {
+		log.Debug("Not cordoning while soaking")
+		return errors.Errorf("node %s is soaking", n.GetName())
+	}
+	for _, filter := range h.filters {
+		if !filter(n) {
+			log.Debug("Not cordoning node rejected by filter")
+			return errors.Errorf("node %s was rejected by a cordon filter", n.GetName())
+		}
+	}
+
+	protected := h.protected != nil && h.protected(n)
+	if protected {
+		p = p.Intersect(BuiltinPipelines()[PipelineCordon])
+	}
+	if h.check != nil && p.Has(StepDrain) {
+		err := h.check(n)
+		h.mu.Lock()
+		last := h.skipped[n.GetName()]
+		if IsTooManyPods(err) {
+			h.skipped[n.GetName()] = err.Error()
+		} else {
+			delete(h.skipped, n.GetName())
+		}
+		h.mu.Unlock()
+		switch {
+		case IsTooManyPods(err):
+			if err.Error() != last {
+				log.Info("Skipped drain", zap.Error(err))
+				skipped, _ := tag.New(tags, tag.Upsert(TagResult, tagResultSkipped)) // nolint:gosec
+				stats.Record(skipped, MeasureNodesDrained.M(1))
+				h.e.Eventf(nr, core.EventTypeWarning, eventReasonDrainSkipped, "Draining skipped: %v", err)
+			}
+			return errors.Wrap(err, "drain skipped")
+		case err != nil:
+			log.Info("Failed to check node before cordoning", zap.Error(err))
+			return errors.Wrap(err, "cannot check node")
+		}
+	}
+
+	cordoning := "Cordoning node"
+	if h.reasons != nil {
+		if r := strings.Join(h.reasons(n), ","); r != "" {
+			log = log.With(zap.String("reason", r))
+			tags, _ = tag.New(tags, upsertLimited(TagReason, r)) // nolint:gosec
+			cordoning = fmt.Sprintf("Cordoning node: %s", r)
+		}
+	}
+
+	unlock := func() {}
+	if h.lock != nil {
+		var err error
+		if unlock, err = h.lockNode(n); err != nil {
+			h.mu.Lock()
+			last := h.deferred[n.GetName()]
+			h.deferred[n.GetName()] = err.Error()
+			h.mu.Unlock()
+			if err.Error() != last {
+				log.Info("Deferred cordon", zap.String("deferred", err.Error()))
+				deferred, _ := tag.New(tags, tag.Upsert(TagResult, tagResultDeferred)) // nolint:gosec
+				stats.Record(deferred, MeasureNodesCordoned.M(1))
+				h.e.Eventf(nr, core.EventTypeWarning, eventReasonCordonDeferred, "Cordon deferred: %v", err)
+			}
+			return errors.Wrap(err, "cordon deferred")
+		}
+		if h.limit == nil {
+			h.mu.Lock()
+			delete(h.deferred, n.GetName())
+			h.mu.Unlock()
+		}
+		finish := done
+		done = func(err error) {
+			unlock()
+			finish(err)
+		}
+	}
+
+	if h.limit != nil {
+		ok, reason := h.limit.Reserve(n)
+		h.mu.Lock()
+		last := h.deferred[n.GetName()]
+		if ok {
+			delete(h.deferred, n.GetName())
+		} else {
+			h.deferred[n.GetName()] = reason
+		}
+		h.mu.Unlock()
+		if !ok {
+			unlock()
+			if reason != last {
+				log.Info("Deferred cordon", zap.String("deferred", reason))
+				deferred, _ := tag.New(tags, tag.Upsert(TagResult, tagResultDeferred)) // nolint:gosec
+				stats.Record(deferred, MeasureNodesCordoned.M(1))
+				h.e.Eventf(nr, core.EventTypeWarning, eventReasonCordonDeferred, "Cordon deferred: %s", reason)
+			}
+			return errors.Errorf("cordon deferred: %s", reason)
+		}
+	}
+
+	if p.Has(StepNotify) {
+		for _, fn := range h.notify {
+			if err := fn(n); err != nil {
+				log.Info("Failed to notify", zap.Error(err))
+				h.e.Eventf(nr, core.EventTypeWarning, eventReasonNotifyFailed, "Notification failed: %v", err)
+			}
+		}
+	}
+
+	// Only the call that marks the node as draining may clear it; a duplicate
+	// call must not clear the mark while the first call's drain is running.
+	h.mu.Lock()
+	marked := !h.draining[n.GetName()]
+	h.draining[n.GetName()] = true
+	h.mu.Unlock()
+	stopDraining := func() {
+		if !marked {
+			return
+		}
+		h.mu.Lock()
+		delete(h.draining, n.GetName())
+		h.mu.Unlock()
+	}
+	finish := done
+	done = func(err error) {
+		stopDraining()
+		finish(err)
+	}
+	release := func() {
+		if h.limit != nil {
+			h.limit.Release(n)
+		}
+	}
+
 	log.Debug("Cordoning")
-	h.e.Event(nr, core.EventTypeWarning, eventReasonCordonStarting, "Cordoning node")
+	h.e.Event(nr, core.EventTypeWarning, eventReasonCordonStarting, cordoning)
 	if err := h.d.Cordon(n); err != nil {
+		release()
+		stopDraining()
+		unlock()
 		log.Info("Failed to cordon", zap.Error(err))
-		tags, _ = tag.New(tags, tag.Upsert(TagResult, tagResultFailed)) // nolint:gosec
+		tags, _ = tag.New(tags, tag.Upsert(TagResult, tagResultFailed), tag.Upsert(TagErrorClass, ErrorClass(err))) // nolint:gosec
 		stats.Record(tags, MeasureNodesCordoned.M(1))
 		h.e.Eventf(nr, core.EventTypeWarning, eventReasonCordonFailed, "Cordoning failed: %v", err)
-		return
+		return err
 	}
 	log.Info("Cordoned")
 	tags, _ = tag.New(tags, tag.Upsert(TagResult, tagResultSucceeded)) // nolint:gosec
 	stats.Record(tags, MeasureNodesCordoned.M(1))
 	h.e.Event(nr, core.EventTypeWarning, eventReasonCordonSucceeded, "Cordoned node")
 
-	t := time.Now()
-	d := h.lastDrainScheduledFor.Sub(t) + h.buffer
-	h.lastDrainScheduledFor = t.Add(d)
+	if protected {
+		log.Info("Not draining protected node")
+		h.e.Event(nr, core.EventTypeWarning, eventReasonDrainSkipped, "Draining skipped: node is protected")
+		done(errors.Errorf("node %s is protected from draining", n.GetName()))
+		return nil
+	}
+	if !p.Has(StepDrain) {
+		log.Info("Not draining", zap.String("pipeline", p.String()))
+		h.e.Eventf(nr, core.EventTypeWarning, eventReasonDrainSkipped, "Draining skipped: pipeline is %s", p)
+		done(nil)
+		return nil
+	}
 
-	log.Info("Scheduled drain", zap.Time("after", h.lastDrainScheduledFor))
-	h.e.Eventf(nr, core.EventTypeWarning, eventReasonDrainScheduled, "Will drain node after %s", h.lastDrainScheduledFor.Format(time.RFC3339Nano))
-	time.AfterFunc(d, func() {
-		h.lastDrainScheduledFor = time.Now()
+	pre, post, replace := h.pre, h.post, h.replace
+	if !p.Has(StepPreHook) {
+		pre = nil
+	}
+	if !p.Has(StepPostHook) {
+		post = nil
+	}
+	if !p.Has(StepReplace) {
+		replace = nil
+	}
+	uncordon := func(msg string) error {
+		if err := h.uncordon(n); err != nil {
+			log.Info("Failed to uncordon", zap.Error(err))
+			h.e.Eventf(nr, core.EventTypeWarning, eventReasonUncordonFailed, "Uncordoning failed: %v", err)
+			return errors.Wrap(err, "uncordon failed")
+		}
+		release()
+		log.Info("Uncordoned")
+		h.e.Event(nr, core.EventTypeNormal, eventReasonUncordonSucceeded, msg)
+		return nil
+	}
+	drain := func() {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			log.Info("Drain deadline exceeded", zap.Time("deadline", deadline))
+			h.e.Eventf(nr, core.EventTypeWarning, eventReasonDrainExpired, "Drain deadline %s exceeded", deadline.Format(time.RFC3339Nano))
+			done(errors.Wrapf(errDeadlineExceeded{}, "drain deadline %s exceeded", deadline.Format(time.RFC3339Nano)))
+			return
+		}
+		for _, fn := range pre {
+			if err := fn(n); err != nil {
+				log.Info("Failed pre-drain action", zap.Error(err))
+				tags, _ = tag.New(tags, tag.Upsert(TagResult, tagResultFailed), tag.Upsert(TagErrorClass, ErrorClass(err))) // nolint:gosec
+				stats.Record(tags, MeasureNodesDrained.M(1))
+				h.e.Eventf(nr, core.EventTypeWarning, eventReasonPreDrainFailed, "Pre-drain action failed: %v", err)
+				h.drainFailed(log, nr, n)
+				done(errors.Wrap(err, "pre-drain action failed"))
+				return
+			}
+		}
 		log.Debug("Draining")
 		h.e.Event(nr, core.EventTypeWarning, eventReasonDrainStarting, "Draining node")
-		if err := h.d.Drain(n); err != nil {
+		if err := h.d.Drain(n); IsTooManyPods(err) {
+			log.Info("Skipped drain", zap.Error(err))
+			tags, _ = tag.New(tags, tag.Upsert(TagResult, tagResultSkipped)) // nolint:gosec
+			stats.Record(tags, MeasureNodesDrained.M(1))
+			h.e.Eventf(nr, core.EventTypeWarning, eventReasonDrainSkipped, "Draining skipped: %v", err)
+			done(err)
+			return
+		} else if err != nil {
 			log.Info("Failed to drain", zap.Error(err))
-			tags, _ = tag.New(tags, tag.Upsert(TagResult, tagResultFailed)) // nolint:gosec
+			tags, _ = tag.New(tags, tag.Upsert(TagResult, tagResultFailed), tag.Upsert(TagErrorClass, ErrorClass(err))) // nolint:gosec
 			stats.Record(tags, MeasureNodesDrained.M(1))
 			h.e.Eventf(nr, core.EventTypeWarning, eventReasonDrainFailed, "Draining failed: %v", err)
+			h.drainFailed(log, nr, n)
+			done(err)
 			return
 		}
+		drained := "Drained node"
+		if h.mirror != nil {
+			remaining, err := h.mirror.Handle(n)
+			if err != nil {
+				log.Info("Failed to stop mirror pods", zap.Error(err))
+				tags, _ = tag.New(tags, tag.Upsert(TagResult, tagResultFailed), tag.Upsert(TagErrorClass, ErrorClass(err))) // nolint:gosec
+				stats.Record(tags, MeasureNodesDrained.M(1))
+				h.e.Eventf(nr, core.EventTypeWarning, eventReasonDrainFailed, "Draining failed: %v", err)
+				h.drainFailed(log, nr, n)
+				done(err)
+				return
+			}
+			if len(remaining) > 0 {
+				log = log.With(zap.String("mirror_pods", podNames(remaining)))
+				drained = fmt.Sprintf("Drained node; %d mirror pods were not evicted: %s", len(remaining), podNames(remaining))
+			}
+		}
 		log.Info("Drained")
 		tags, _ = tag.New(tags, tag.Upsert(TagResult, tagResultSucceeded)) // nolint:gosec
 		stats.Record(tags, MeasureNodesDrained.M(1))
-		h.e.Event(nr, core.EventTypeWarning, eventReasonDrainSucceeded, "Drained node")
+		h.mu.Lock()
+		h.lastDrained = time.Now()
+		h.mu.Unlock()
+		h.e.Event(nr, core.EventTypeWarning, eventReasonDrainSucceeded, drained)
+		h.s.Drained(n)
+		for _, fn := range post {
+			if err := fn(n); err != nil {
+				log.Info("Failed post-drain action", zap.Error(err))
+				h.e.Eventf(nr, core.EventTypeWarning, eventReasonPostDrainFailed, "Post-drain action failed: %v", err)
+				done(errors.Wrap(err, "post-drain action failed"))
+				return
+			}
+		}
+		for _, fn := range replace {
+			if err := fn(n); err != nil {
+				log.Info("Failed to replace", zap.Error(err))
+				h.e.Eventf(nr, core.EventTypeWarning, eventReasonReplaceFailed, "Replacing failed: %v", err)
+				done(errors.Wrap(err, "replace failed"))
+				return
+			}
+		}
+		if p.Has(StepUncordon) && h.uncordon != nil {
+			if err := uncordon("Uncordoned drained node"); err != nil {
+				done(err)
+				return
+			}
+			// The node still matches until its conditions clear or change;
+			// it must not be cordoned and drained again in the meantime.
+			h.mu.Lock()
+			h.uncordoned[n.GetName()] = conditionFingerprint(n)
+			h.mu.Unlock()
+		}
+		done(nil)
+	}
+
+	schedule := func() error {
+		after, err := h.s.Schedule(n, drain)
+		if err != nil {
+			log.Debug("Not scheduling drain", zap.Error(err))
+			return err
+		}
+		log.Info("Scheduled drain", zap.Time("after", after))
+		h.e.Eventf(nr, core.EventTypeWarning, eventReasonDrainScheduled, "Will drain node after %s", after.Format(time.RFC3339Nano))
+		return nil
+	}
+	if p.Soak() <= 0 {
+		// The error is returned rather than passed to the caller's function,
+		// so only the handler's own state is cleaned up.
+		err := schedule()
+		if err != nil {
+			release()
+			stopDraining()
+			unlock()
+		}
+		return err
+	}
+	until := time.Now().Add(p.Soak())
+	log.Info("Soaking", zap.Time("until", until))
+	h.e.Eventf(nr, core.EventTypeWarning, eventReasonSoaking, "Will schedule drain after soaking until %s", until.Format(time.RFC3339Nano))
+	s := &soak{cancel: func(recovered bool, reason string) {
+		log.Info("Cancelled soak", zap.String("reason", reason))
+		h.e.Eventf(nr, core.EventTypeNormal, eventReasonSoakCancelled, "Will not drain node: %s", reason)
+		if recovered && p.Has(StepUncordon) && h.uncordon != nil {
+			if err := uncordon("Uncordoned recovered node"); err != nil {
+				done(err)
+				return
+			}
+		}
+		done(errors.Errorf("soak of node %s cancelled: %s", n.GetName(), reason))
+	}}
+	h.mu.Lock()
+	if h.stopped {
+		h.mu.Unlock()
+		s.cancel(false, "shutting down")
+		return nil
+	}
+	h.soaking[n.GetName()] = s
+	s.t = time.AfterFunc(p.Soak(), func() {
+		// Whichever of the timer and a cancellation removes the soak owns it.
+		h.mu.Lock()
+		current := h.soaking[n.GetName()] == s
+		if current {
+			delete(h.soaking, n.GetName())
+		}
+		h.mu.Unlock()
+		if !current {
+			return
+		}
+		if err := schedule(); err != nil {
+			release()
+			done(err)
+		}
 	})
+	h.mu.Unlock()
+	return nil
+}
+
+// lockNode acquires the node lock of the supplied node, returning a function
+// that releases it. Locks that are already held while the node is cordoned and
+// drained are released by whichever call acquired them, so the returned
+// function does nothing.
+func (h *DrainingResourceEventHandler) lockNode(n *core.Node) (func(), error) {
+	h.mu.Lock()
+	held := h.locked[n.GetName()]
+	h.locked[n.GetName()] = true
+	h.mu.Unlock()
+	if held {
+		return func() {}, nil
+	}
+	if err := h.lock.Acquire(n); err != nil {
+		h.mu.Lock()
+		delete(h.locked, n.GetName())
+		h.mu.Unlock()
+		return nil, err
+	}
+	return func() {
+		if err := h.lock.Release(n); err != nil {
+			h.l.Info("Failed to release node lock", zap.String("node", n.GetName()), zap.Error(err))
+		}
+		h.mu.Lock()
+		delete(h.locked, n.GetName())
+		h.mu.Unlock()
+	}, nil
+}
+
+type errDeadlineExceeded struct{}
+
+func (e errDeadlineExceeded) Error() string {
+	return "deadline exceeded"
+}
+
+func (e errDeadlineExceeded) DeadlineExceeded() {}
+
+// IsDeadlineExceeded returns true if the supplied error was caused by a drain
+// deadline passing before the drain started.
+func IsDeadlineExceeded(err error) bool {
+	err = errors.Cause(err)
+	_, ok := err.(interface {
+		DeadlineExceeded()
+	})
+	return ok
+}
+
+// ResourceEventHandlers passes each notification to all of its handlers, in
+// order.
+type ResourceEventHandlers []cache.ResourceEventHandler
+
+// OnAdd passes the added object to all handlers.
+func (hs ResourceEventHandlers) OnAdd(obj interface{}) {
+	for _, h := range hs {
+		h.OnAdd(obj)
+	}
+}
+
+// OnUpdate passes the updated object to all handlers.
+func (hs ResourceEventHandlers) OnUpdate(oldObj, newObj interface{}) {
+	for _, h := range hs {
+		h.OnUpdate(oldObj, newObj)
+	}
+}
+
+// OnDelete passes the deleted object to all handlers.
+func (hs ResourceEventHandlers) OnDelete(obj interface{}) {
+	for _, h := range hs {
+		h.OnDelete(obj)
+	}
 }
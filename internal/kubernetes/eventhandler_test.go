@@ -17,9 +17,11 @@ and limitations under the License.
 package kubernetes
 
 import (
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/pkg/errors"
 	"k8s.io/client-go/tools/record"
 
 	core "k8s.io/api/core/v1"
@@ -44,8 +46,679 @@ func TestDrainingResourceEventHandler(t *testing.T) {
 
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
-			h := NewDrainingResourceEventHandler(&NoopCordonDrainer{}, &record.FakeRecorder{}, WithDrainBuffer(0*time.Second))
+			s := NewDrainScheduler(WithDrainBuffer(0 * time.Second))
+			h := NewDrainingResourceEventHandler(&NoopCordonDrainer{}, &record.FakeRecorder{}, WithDrainScheduler(s))
 			h.OnUpdate(nil, tc.obj)
 		})
 	}
 }
+
+func TestPostDrainFuncs(t *testing.T) {
+	cases := []struct {
+		name    string
+		post    []PostDrainFunc
+		wantErr bool
+	}{
+		{
+			name: "Succeeded",
+			post: []PostDrainFunc{func(_ *core.Node) error { return nil }},
+		},
+		{
+			name:    "Failed",
+			post:    []PostDrainFunc{func(_ *core.Node) error { return errors.New("nope") }},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewDrainScheduler(WithDrainBuffer(0 * time.Second))
+			h := NewDrainingResourceEventHandler(&NoopCordonDrainer{}, record.NewFakeRecorder(10), WithDrainScheduler(s), WithPostDrainFuncs(tc.post...))
+			errs := make(chan error, 1)
+			n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}
+			if err := h.Request(n, time.Time{}, func(err error) { errs <- err }); err != nil {
+				t.Fatalf("h.Request(%v): %v", n.GetName(), err)
+			}
+			if err := <-errs; (err != nil) != tc.wantErr {
+				t.Errorf("drain error: want error %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestNodeActions(t *testing.T) {
+	cases := []struct {
+		name          string
+		action        NodeAction
+		wantDrained   bool
+		wantPostDrain bool
+	}{
+		{name: "CordonOnly", action: ActionCordon},
+		{name: "Drain", action: ActionDrain, wantDrained: true},
+		{name: "Terminate", action: ActionTerminate, wantDrained: true, wantPostDrain: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			drained, postDrained := false, false
+			s := NewDrainScheduler(WithDrainBuffer(0 * time.Second))
+			h := NewDrainingResourceEventHandler(&NoopCordonDrainer{}, record.NewFakeRecorder(10),
+				WithDrainScheduler(s),
+				WithNodeActions(func(_ *core.Node) NodeAction { return tc.action }),
+				WithPreDrainFuncs(func(_ *core.Node) error { drained = true; return nil }),
+				WithPostDrainFuncs(func(_ *core.Node) error { postDrained = true; return nil }))
+			errs := make(chan error, 1)
+			n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}
+			if err := h.cordonAndDrain(n, time.Time{}, h.pipelineFor(n), func(err error) { errs <- err }); err != nil {
+				t.Fatalf("h.cordonAndDrain(%v): %v", n.GetName(), err)
+			}
+			if err := <-errs; err != nil {
+				t.Fatalf("drain error: %v", err)
+			}
+			if drained != tc.wantDrained {
+				t.Errorf("drained: want %v, got %v", tc.wantDrained, drained)
+			}
+			if postDrained != tc.wantPostDrain {
+				t.Errorf("post-drain functions called: want %v, got %v", tc.wantPostDrain, postDrained)
+			}
+		})
+	}
+}
+
+func TestNodePipelines(t *testing.T) {
+	cases := []struct {
+		name          string
+		pipeline      string
+		wantNotified  bool
+		wantDrained   bool
+		wantPostDrain bool
+		wantReplaced  bool
+		wantUncordon  bool
+	}{
+		{name: "CordonOnly", pipeline: "cordon"},
+		{name: "NotifyAndCordon", pipeline: "notify,cordon", wantNotified: true},
+		{name: "Drain", pipeline: "cordon,pre-hook,drain", wantDrained: true},
+		{name: "Terminate", pipeline: "cordon,pre-hook,drain,post-hook,replace", wantDrained: true, wantPostDrain: true, wantReplaced: true},
+		{name: "Reset", pipeline: "cordon,soak=10ms,pre-hook,drain,uncordon", wantDrained: true, wantUncordon: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := ParsePipeline(tc.pipeline)
+			if err != nil {
+				t.Fatalf("ParsePipeline(%v): %v", tc.pipeline, err)
+			}
+			notified, drained, postDrained, replaced, uncordoned := false, false, false, false, false
+			s := NewDrainScheduler(WithDrainBuffer(0 * time.Second))
+			h := NewDrainingResourceEventHandler(&NoopCordonDrainer{}, record.NewFakeRecorder(20),
+				WithDrainScheduler(s),
+				WithNodePipelines(func(_ *core.Node) Pipeline { return p }),
+				WithNotifyFuncs(func(_ *core.Node) error { notified = true; return nil }),
+				WithPreDrainFuncs(func(_ *core.Node) error { drained = true; return nil }),
+				WithPostDrainFuncs(func(_ *core.Node) error { postDrained = true; return nil }),
+				WithReplaceFuncs(func(_ *core.Node) error { replaced = true; return nil }),
+				WithUncordonFunc(func(_ *core.Node) error { uncordoned = true; return nil }))
+			errs := make(chan error, 1)
+			n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}
+			if err := h.cordonAndDrain(n, time.Time{}, h.pipelineFor(n), func(err error) { errs <- err }); err != nil {
+				t.Fatalf("h.cordonAndDrain(%v): %v", n.GetName(), err)
+			}
+			if err := <-errs; err != nil {
+				t.Fatalf("drain error: %v", err)
+			}
+			if notified != tc.wantNotified {
+				t.Errorf("notified: want %v, got %v", tc.wantNotified, notified)
+			}
+			if drained != tc.wantDrained {
+				t.Errorf("drained: want %v, got %v", tc.wantDrained, drained)
+			}
+			if postDrained != tc.wantPostDrain {
+				t.Errorf("post-drain functions called: want %v, got %v", tc.wantPostDrain, postDrained)
+			}
+			if replaced != tc.wantReplaced {
+				t.Errorf("replaced: want %v, got %v", tc.wantReplaced, replaced)
+			}
+			if uncordoned != tc.wantUncordon {
+				t.Errorf("uncordoned: want %v, got %v", tc.wantUncordon, uncordoned)
+			}
+		})
+	}
+}
+
+func TestUncordonedNodes(t *testing.T) {
+	p, err := ParsePipeline("notify,cordon,pre-hook,drain,uncordon")
+	if err != nil {
+		t.Fatalf("ParsePipeline: %v", err)
+	}
+	reasons := []string{"KernelDeadlock"}
+	notified := 0
+	uncordoned := make(chan struct{}, 3)
+	s := NewDrainScheduler(WithDrainBuffer(0 * time.Second))
+	h := NewDrainingResourceEventHandler(&NoopCordonDrainer{}, record.NewFakeRecorder(50),
+		WithDrainScheduler(s),
+		WithCordonReasons(func(_ *core.Node) []string { return reasons }),
+		WithNodePipelines(func(_ *core.Node) Pipeline { return p }),
+		WithNotifyFuncs(func(_ *core.Node) error { notified++; return nil }),
+		WithUncordonFunc(func(_ *core.Node) error { uncordoned <- struct{}{}; return nil }))
+
+	unhealthy := &core.Node{
+		ObjectMeta: meta.ObjectMeta{Name: nodeName},
+		Status:     core.NodeStatus{Conditions: []core.NodeCondition{{Type: "KernelDeadlock", Status: core.ConditionTrue}}},
+	}
+	h.OnAdd(unhealthy)
+	<-uncordoned
+
+	// The node is uncordoned, but its conditions have not changed.
+	h.OnUpdate(nil, unhealthy)
+	if notified != 1 {
+		t.Errorf("remediations while conditions hold: want 1, got %d", notified)
+	}
+
+	// The node recovers, then matches again.
+	reasons = nil
+	h.OnDelete(unhealthy)
+	reasons = []string{"KernelDeadlock"}
+	h.OnUpdate(nil, unhealthy)
+	<-uncordoned
+	if notified != 2 {
+		t.Errorf("remediations after recovering: want 2, got %d", notified)
+	}
+
+	// The node's conditions change.
+	changed := unhealthy.DeepCopy()
+	changed.Status.Conditions = append(changed.Status.Conditions, core.NodeCondition{Type: "DiskPressure", Status: core.ConditionTrue})
+	h.OnUpdate(nil, changed)
+	<-uncordoned
+	if notified != 3 {
+		t.Errorf("remediations after conditions change: want 3, got %d", notified)
+	}
+}
+
+func TestSoakCancelled(t *testing.T) {
+	cases := []struct {
+		name         string
+		pipeline     string
+		cancel       func(h *DrainingResourceEventHandler, n *core.Node, reasons *[]string)
+		wantUncordon bool
+	}{
+		{
+			name:     "Recovered",
+			pipeline: "cordon,soak=1h,pre-hook,drain",
+			cancel: func(h *DrainingResourceEventHandler, n *core.Node, reasons *[]string) {
+				*reasons = nil
+				h.OnDelete(n)
+			},
+		},
+		{
+			name:     "RecoveredAndUncordoned",
+			pipeline: "cordon,soak=1h,pre-hook,drain,uncordon",
+			cancel: func(h *DrainingResourceEventHandler, n *core.Node, reasons *[]string) {
+				*reasons = nil
+				h.OnDelete(n)
+			},
+			wantUncordon: true,
+		},
+		{
+			name:     "StillMatching",
+			pipeline: "cordon,soak=10ms,pre-hook,drain",
+			cancel: func(h *DrainingResourceEventHandler, n *core.Node, _ *[]string) {
+				// Draino cordoned the node, so it no longer passes the
+				// schedulable filter, but it still matches.
+				h.OnDelete(n)
+			},
+		},
+		{
+			name:     "ShuttingDown",
+			pipeline: "cordon,soak=1h,pre-hook,drain,uncordon",
+			cancel: func(h *DrainingResourceEventHandler, _ *core.Node, _ *[]string) {
+				stop := make(chan struct{})
+				close(stop)
+				h.Run(stop)
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := ParsePipeline(tc.pipeline)
+			if err != nil {
+				t.Fatalf("ParsePipeline(%v): %v", tc.pipeline, err)
+			}
+			reasons := []string{"KernelDeadlock"}
+			drained, uncordoned := false, false
+			s := NewDrainScheduler(WithDrainBuffer(0 * time.Second))
+			h := NewDrainingResourceEventHandler(&NoopCordonDrainer{}, record.NewFakeRecorder(20),
+				WithDrainScheduler(s),
+				WithCordonReasons(func(_ *core.Node) []string { return reasons }),
+				WithPreDrainFuncs(func(_ *core.Node) error { drained = true; return nil }),
+				WithUncordonFunc(func(_ *core.Node) error { uncordoned = true; return nil }))
+			errs := make(chan error, 1)
+			n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}
+			if err := h.cordonAndDrain(n, time.Time{}, p, func(err error) { errs <- err }); err != nil {
+				t.Fatalf("h.cordonAndDrain(%v): %v", n.GetName(), err)
+			}
+			tc.cancel(h, n, &reasons)
+			err = <-errs
+			wantDrained := p.Soak() < time.Hour
+			if (err == nil) != wantDrained {
+				t.Errorf("drain error: want error %v, got %v", !wantDrained, err)
+			}
+			if drained != wantDrained {
+				t.Errorf("drained: want %v, got %v", wantDrained, drained)
+			}
+			if uncordoned != tc.wantUncordon {
+				t.Errorf("uncordoned: want %v, got %v", tc.wantUncordon, uncordoned)
+			}
+		})
+	}
+}
+
+func TestProtectedNodes(t *testing.T) {
+	cases := []struct {
+		name        string
+		node        *core.Node
+		wantDrained bool
+	}{
+		{
+			name:        "Worker",
+			node:        &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}},
+			wantDrained: true,
+		},
+		{
+			name: "ControlPlane",
+			node: &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName, Labels: map[string]string{LabelNodeRoleControlPlane: ""}}},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			drained, notified := false, false
+			s := NewDrainScheduler(WithDrainBuffer(0 * time.Second))
+			h := NewDrainingResourceEventHandler(&NoopCordonDrainer{}, record.NewFakeRecorder(20),
+				WithDrainScheduler(s),
+				WithProtectedNodes(NodeControlPlaneFilter),
+				WithNotifyFuncs(func(_ *core.Node) error { notified = true; return nil }),
+				WithPreDrainFuncs(func(_ *core.Node) error { drained = true; return nil }))
+			errs := make(chan error, 1)
+			if err := h.cordonAndDrain(tc.node, time.Time{}, DefaultPipeline(), func(err error) { errs <- err }); err != nil {
+				t.Fatalf("h.cordonAndDrain(%v): %v", tc.node.GetName(), err)
+			}
+			if err := <-errs; (err != nil) == tc.wantDrained {
+				t.Errorf("drain error: want error %v, got %v", !tc.wantDrained, err)
+			}
+			if !notified {
+				t.Errorf("notified: want true, got false")
+			}
+			if drained != tc.wantDrained {
+				t.Errorf("drained: want %v, got %v", tc.wantDrained, drained)
+			}
+		})
+	}
+}
+
+// heldLock is a DrainLock that records whether it is held.
+type heldLock struct {
+	err  error
+	held bool
+}
+
+func (l *heldLock) Acquire(_ *core.Node) error {
+	if l.err != nil {
+		return l.err
+	}
+	l.held = true
+	return nil
+}
+
+func (l *heldLock) Release(_ *core.Node) error {
+	l.held = false
+	return nil
+}
+
+func TestDraining(t *testing.T) {
+	var h *DrainingResourceEventHandler
+	n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}
+	drainingDuringDrain := false
+	s := NewDrainScheduler(WithDrainBuffer(0 * time.Second))
+	h = NewDrainingResourceEventHandler(&NoopCordonDrainer{}, record.NewFakeRecorder(20),
+		WithDrainScheduler(s),
+		WithPreDrainFuncs(func(n *core.Node) error { drainingDuringDrain = h.Draining(n); return nil }))
+	if h.Draining(n) {
+		t.Errorf("h.Draining(%v) before cordon: want false, got true", n.GetName())
+	}
+	errs := make(chan error, 1)
+	if err := h.Request(n, time.Time{}, func(err error) { errs <- err }); err != nil {
+		t.Fatalf("h.Request(%v): %v", n.GetName(), err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("drain error: %v", err)
+	}
+	if !drainingDuringDrain {
+		t.Errorf("h.Draining(%v) during drain: want true, got false", n.GetName())
+	}
+	if h.Draining(n) {
+		t.Errorf("h.Draining(%v) after drain: want false, got true", n.GetName())
+	}
+}
+
+func TestScheduleFailed(t *testing.T) {
+	cases := []struct {
+		name     string
+		pipeline string
+	}{
+		{name: "NoSoak", pipeline: "cordon,pre-hook,drain"},
+		{name: "Soaked", pipeline: "cordon,soak=10ms,pre-hook,drain"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := ParsePipeline(tc.pipeline)
+			if err != nil {
+				t.Fatalf("ParsePipeline(%v): %v", tc.pipeline, err)
+			}
+			limit, _ := ParseCordonLimit("1")
+			n, other := newCordonedNode(nodeName, false), newCordonedNode("other", false)
+			cl := NewCordonLimiter(staticNodeStore{n, other}, DefaultKeys(), func(_ interface{}) bool { return true }, limit)
+			l := &heldLock{}
+
+			// A stopped scheduler refuses to schedule drains.
+			s := NewDrainScheduler(WithDrainBuffer(0 * time.Second))
+			stop := make(chan struct{})
+			close(stop)
+			s.Run(stop)
+
+			h := NewDrainingResourceEventHandler(&NoopCordonDrainer{}, record.NewFakeRecorder(20),
+				WithDrainScheduler(s),
+				WithNodeLock(l),
+				WithCordonLimiter(cl))
+			errs := make(chan error, 1)
+			err = h.cordonAndDrain(n, time.Time{}, p, func(err error) { errs <- err })
+			if p.Soak() <= 0 {
+				if err == nil {
+					t.Fatalf("h.cordonAndDrain(%v): want error, got nil", n.GetName())
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("h.cordonAndDrain(%v): %v", n.GetName(), err)
+				}
+				if err := <-errs; err == nil {
+					t.Fatalf("drain error: want error, got nil")
+				}
+			}
+
+			if h.Draining(n) {
+				t.Errorf("h.Draining(%v): want false, got true", n.GetName())
+			}
+			if l.held {
+				t.Errorf("lock still held after failing to schedule drain")
+			}
+			if ok, reason := cl.Reserve(other); !ok {
+				t.Errorf("cl.Reserve(%v): reservation of %v was not released: %s", other.GetName(), n.GetName(), reason)
+			}
+		})
+	}
+}
+
+// cordonFailingDrainer fails to cordon nodes after the first.
+type cordonFailingDrainer struct {
+	NoopCordonDrainer
+	cordoned bool
+}
+
+func (d *cordonFailingDrainer) Cordon(_ *core.Node) error {
+	if d.cordoned {
+		return errors.New("nope")
+	}
+	d.cordoned = true
+	return nil
+}
+
+func TestDuplicateCordonFailed(t *testing.T) {
+	var h *DrainingResourceEventHandler
+	n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}
+	draining, release := make(chan struct{}), make(chan struct{})
+	s := NewDrainScheduler(WithDrainBuffer(0 * time.Second))
+	h = NewDrainingResourceEventHandler(&cordonFailingDrainer{}, record.NewFakeRecorder(20),
+		WithDrainScheduler(s),
+		WithPreDrainFuncs(func(_ *core.Node) error { close(draining); <-release; return nil }))
+	errs := make(chan error, 1)
+	if err := h.Request(n, time.Time{}, func(err error) { errs <- err }); err != nil {
+		t.Fatalf("h.Request(%v): %v", n.GetName(), err)
+	}
+	<-draining
+
+	if err := h.Request(n, time.Time{}, nil); err == nil {
+		t.Fatalf("duplicate h.Request(%v): want error, got nil", n.GetName())
+	}
+	if !h.Draining(n) {
+		t.Errorf("h.Draining(%v) after duplicate request failed: want true, got false", n.GetName())
+	}
+
+	close(release)
+	if err := <-errs; err != nil {
+		t.Fatalf("drain error: %v", err)
+	}
+	if h.Draining(n) {
+		t.Errorf("h.Draining(%v) after drain: want false, got true", n.GetName())
+	}
+}
+
+func TestCordonFilters(t *testing.T) {
+	cases := []struct {
+		name       string
+		filter     func(o interface{}) bool
+		wantErr    bool
+		wantFilter int
+	}{
+		{name: "Passed", filter: func(_ interface{}) bool { return true }, wantFilter: 1},
+		{name: "Rejected", filter: func(_ interface{}) bool { return false }, wantErr: true, wantFilter: 1},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			filtered := 0
+			s := NewDrainScheduler(WithDrainBuffer(0 * time.Second))
+			h := NewDrainingResourceEventHandler(&NoopCordonDrainer{}, record.NewFakeRecorder(20),
+				WithDrainScheduler(s),
+				WithCordonFilters(func(o interface{}) bool { filtered++; return tc.filter(o) }))
+			errs := make(chan error, 1)
+			n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}
+			err := h.Request(n, time.Time{}, func(err error) { errs <- err })
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("h.Request(%v): want error %v, got %v", n.GetName(), tc.wantErr, err)
+			}
+			if filtered != tc.wantFilter {
+				t.Errorf("filter calls: want %d, got %d", tc.wantFilter, filtered)
+			}
+			if tc.wantErr {
+				return
+			}
+			if err := <-errs; err != nil {
+				t.Errorf("drain error: %v", err)
+			}
+		})
+	}
+}
+
+// cordonCountingDrainer counts the nodes it cordons.
+type cordonCountingDrainer struct {
+	NoopCordonDrainer
+	cordoned int
+}
+
+func (d *cordonCountingDrainer) Cordon(_ *core.Node) error {
+	d.cordoned++
+	return nil
+}
+
+func TestDrainCheck(t *testing.T) {
+	cases := []struct {
+		name         string
+		err          error
+		wantErr      bool
+		wantCordoned int
+		wantEvents   int
+	}{
+		{name: "Passed", wantCordoned: 1},
+		{name: "TooManyPods", err: errors.Wrap(errTooManyPods{}, "cannot evict 3 pods"), wantErr: true, wantEvents: 1},
+		{name: "CheckFailed", err: errors.New("nope"), wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &cordonCountingDrainer{}
+			e := record.NewFakeRecorder(20)
+			h := NewDrainingResourceEventHandler(d, e,
+				WithDrainScheduler(NewDrainScheduler(WithDrainBuffer(0*time.Second))),
+				WithDrainCheck(func(_ *core.Node) error { return tc.err }))
+			n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}
+
+			// Nodes that fail the check repeatedly are skipped once.
+			for i := 0; i < 2; i++ {
+				errs := make(chan error, 1)
+				err := h.cordonAndDrain(n, time.Time{}, DefaultPipeline(), func(err error) { errs <- err })
+				if (err != nil) != tc.wantErr {
+					t.Fatalf("h.cordonAndDrain(%v): want error %v, got %v", n.GetName(), tc.wantErr, err)
+				}
+				if err == nil {
+					<-errs
+				}
+				if tc.wantCordoned > 0 {
+					break
+				}
+			}
+			if d.cordoned != tc.wantCordoned {
+				t.Errorf("cordoned: want %d, got %d", tc.wantCordoned, d.cordoned)
+			}
+			skipped := 0
+			for len(e.Events) > 0 {
+				if strings.Contains(<-e.Events, eventReasonDrainSkipped) {
+					skipped++
+				}
+			}
+			if skipped != tc.wantEvents {
+				t.Errorf("%s events: want %d, got %d", eventReasonDrainSkipped, tc.wantEvents, skipped)
+			}
+		})
+	}
+}
+
+func TestNodeLock(t *testing.T) {
+	cases := []struct {
+		name        string
+		lockErr     error
+		wantErr     bool
+		wantDrained bool
+	}{
+		{name: "Acquired", wantDrained: true},
+		{name: "HeldElsewhere", lockErr: errors.New("lease is held by kured"), wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := &heldLock{err: tc.lockErr}
+			heldDuringDrain := false
+			s := NewDrainScheduler(WithDrainBuffer(0 * time.Second))
+			h := NewDrainingResourceEventHandler(&NoopCordonDrainer{}, record.NewFakeRecorder(20),
+				WithDrainScheduler(s),
+				WithNodeLock(l),
+				WithPreDrainFuncs(func(_ *core.Node) error { heldDuringDrain = l.held; return nil }))
+			errs := make(chan error, 1)
+			n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}
+			err := h.cordonAndDrain(n, time.Time{}, DefaultPipeline(), func(err error) { errs <- err })
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("h.cordonAndDrain(%v): want error %v, got %v", n.GetName(), tc.wantErr, err)
+			}
+			if tc.wantErr {
+				return
+			}
+			if err := <-errs; err != nil {
+				t.Fatalf("drain error: %v", err)
+			}
+			if heldDuringDrain != tc.wantDrained {
+				t.Errorf("lock held during drain: want %v, got %v", tc.wantDrained, heldDuringDrain)
+			}
+			if l.held {
+				t.Errorf("lock still held after drain")
+			}
+		})
+	}
+}
+
+func TestFailedDrainCooldown(t *testing.T) {
+	cases := []struct {
+		name         string
+		pre          PreDrainFunc
+		wantCooldown bool
+	}{
+		{
+			name: "Succeeded",
+			pre:  func(_ *core.Node) error { return nil },
+		},
+		{
+			name:         "Failed",
+			pre:          func(_ *core.Node) error { return errors.New("nope") },
+			wantCooldown: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewDrainScheduler(WithDrainBuffer(0 * time.Second))
+			h := NewDrainingResourceEventHandler(&NoopCordonDrainer{}, record.NewFakeRecorder(10), WithDrainScheduler(s), WithPreDrainFuncs(tc.pre), WithFailedDrainCooldown(1*time.Hour))
+			errs := make(chan error, 1)
+			n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}
+			if err := h.Request(n, time.Time{}, func(err error) { errs <- err }); err != nil {
+				t.Fatalf("h.Request(%v): %v", n.GetName(), err)
+			}
+			<-errs
+
+			cooldowns := h.Cooldowns()
+			if got := len(cooldowns) > 0; got != tc.wantCooldown {
+				t.Fatalf("h.Cooldowns(): want cooldown %v, got %v", tc.wantCooldown, cooldowns)
+			}
+			if !tc.wantCooldown {
+				return
+			}
+			if cooldowns[0].Node != nodeName || cooldowns[0].Until.Sub(cooldowns[0].FailedAt) != 1*time.Hour {
+				t.Errorf("h.Cooldowns(): want %v cooling down for 1h, got %+v", nodeName, cooldowns[0])
+			}
+			if err := h.Request(n, time.Time{}, func(err error) { errs <- err }); err == nil {
+				t.Errorf("h.Request(%v): want error while cooling down", n.GetName())
+			}
+		})
+	}
+}
+
+func TestPreDrainFuncs(t *testing.T) {
+	cases := []struct {
+		name    string
+		pre     []PreDrainFunc
+		wantErr bool
+	}{
+		{
+			name: "Succeeded",
+			pre:  []PreDrainFunc{func(_ *core.Node) error { return nil }},
+		},
+		{
+			name:    "Failed",
+			pre:     []PreDrainFunc{func(_ *core.Node) error { return errors.New("nope") }},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewDrainScheduler(WithDrainBuffer(0 * time.Second))
+			h := NewDrainingResourceEventHandler(&NoopCordonDrainer{}, record.NewFakeRecorder(10), WithDrainScheduler(s), WithPreDrainFuncs(tc.pre...))
+			errs := make(chan error, 1)
+			n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}
+			if err := h.Request(n, time.Time{}, func(err error) { errs <- err }); err != nil {
+				t.Fatalf("h.Request(%v): %v", n.GetName(), err)
+			}
+			if err := <-errs; (err != nil) != tc.wantErr {
+				t.Errorf("drain error: want error %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
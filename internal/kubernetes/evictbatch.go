@@ -0,0 +1,135 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// A budgetGroup is the pods of a node that a pod disruption budget selects.
+type budgetGroup struct {
+	namespace string
+	name      string
+	pods      []core.Pod
+}
+
+// budgetGroups groups the supplied pods by the first pod disruption budget, in
+// alphabetical order, that selects them. Pods that no budget selects are
+// returned separately.
+func (d *APICordonDrainer) budgetGroups(pods []core.Pod) ([]budgetGroup, []core.Pod, error) {
+	namespaces := make(map[string][]core.Pod)
+	for _, pod := range pods {
+		namespaces[pod.GetNamespace()] = append(namespaces[pod.GetNamespace()], pod)
+	}
+	groups := []budgetGroup{}
+	unbudgeted := []core.Pod{}
+	for ns, pods := range namespaces {
+		l, err := d.c.PolicyV1beta1().PodDisruptionBudgets(ns).List(meta.ListOptions{})
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "cannot list pod disruption budgets in namespace %s", ns)
+		}
+		sort.Slice(l.Items, func(i, j int) bool { return l.Items[i].GetName() < l.Items[j].GetName() })
+		selectors := make([]labels.Selector, len(l.Items))
+		for i, pdb := range l.Items {
+			if selectors[i], err = meta.LabelSelectorAsSelector(pdb.Spec.Selector); err != nil {
+				return nil, nil, errors.Wrapf(err, "cannot parse selector of pod disruption budget %s/%s", ns, pdb.GetName())
+			}
+		}
+		byBudget := make(map[string][]core.Pod)
+	Pods:
+		for _, pod := range pods {
+			for i, s := range selectors {
+				if !s.Empty() && s.Matches(labels.Set(pod.GetLabels())) {
+					byBudget[l.Items[i].GetName()] = append(byBudget[l.Items[i].GetName()], pod)
+					continue Pods
+				}
+			}
+			unbudgeted = append(unbudgeted, pod)
+		}
+		for name, pods := range byBudget {
+			groups = append(groups, budgetGroup{namespace: ns, name: name, pods: pods})
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].namespace != groups[j].namespace {
+			return groups[i].namespace < groups[j].namespace
+		}
+		return groups[i].name < groups[j].name
+	})
+	return groups, unbudgeted, nil
+}
+
+// batchSize returns how many pods the supplied budget currently allows to be
+// disrupted, or one if it allows none or cannot be determined. Evictions of a
+// batch of one are retried until the budget allows them.
+func (d *APICordonDrainer) batchSize(g budgetGroup) int {
+	pdb, err := d.c.PolicyV1beta1().PodDisruptionBudgets(g.namespace).Get(g.name, meta.GetOptions{})
+	if err != nil || pdb.Status.PodDisruptionsAllowed < 1 {
+		return 1
+	}
+	return int(pdb.Status.PodDisruptionsAllowed)
+}
+
+// evictBatches evicts the supplied group of pods in batches sized to what
+// their pod disruption budget allows, waiting for each batch to be evicted
+// before starting the next. The result of each eviction is sent to the
+// supplied eviction channel, and the start of each batch to the supplied batch
+// channel.
+func (d *APICordonDrainer) evictBatches(ctx context.Context, g budgetGroup, t evictionTiming, abort <-chan struct{}, e chan<- eviction, batches chan<- struct{}) {
+	pods := g.pods
+	for len(pods) > 0 {
+		select {
+		case <-abort:
+			return
+		default:
+		}
+		n := d.batchSize(g)
+		if n > len(pods) {
+			n = len(pods)
+		}
+		d.l.Debug("Evicting batch of pods",
+			zap.String("namespace", g.namespace),
+			zap.String("budget", g.name),
+			zap.Int("batch", n),
+			zap.Int("remaining", len(pods)))
+		select {
+		case batches <- struct{}{}:
+		case <-abort:
+			return
+		}
+		done := make(chan eviction, n)
+		for _, p := range pods[:n] {
+			go d.evict(ctx, p, t, abort, done)
+		}
+		for i := 0; i < n; i++ {
+			ev := <-done
+			select {
+			case e <- ev:
+			case <-abort:
+				return
+			}
+		}
+		pods = pods[n:]
+	}
+}
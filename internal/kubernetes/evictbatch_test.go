@@ -0,0 +1,151 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	core "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func newBudget(name string, allowed int32, app string) *policy.PodDisruptionBudget {
+	return &policy.PodDisruptionBudget{
+		ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: name},
+		Spec:       policy.PodDisruptionBudgetSpec{Selector: &meta.LabelSelector{MatchLabels: map[string]string{"app": app}}},
+		Status:     policy.PodDisruptionBudgetStatus{PodDisruptionsAllowed: allowed},
+	}
+}
+
+func newAppPods(app string, count int) []core.Pod {
+	pods := make([]core.Pod, 0, count)
+	for i := 0; i < count; i++ {
+		pods = append(pods, core.Pod{
+			ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: app + "-" + strconv.Itoa(i), Labels: map[string]string{"app": app}},
+			Spec:       core.PodSpec{NodeName: nodeName},
+		})
+	}
+	return pods
+}
+
+func TestBudgetGroups(t *testing.T) {
+	web := newAppPods("web", 2)
+	db := newAppPods("db", 1)
+	cache := newAppPods("cache", 1)
+
+	c := fake.NewSimpleClientset(newBudget("web", 1, "web"), newBudget("db", 0, "db"))
+	d := NewAPICordonDrainer(c)
+	groups, unbudgeted, err := d.budgetGroups(append(append(append([]core.Pod{}, web...), db...), cache...))
+	if err != nil {
+		t.Fatalf("d.budgetGroups(...): %v", err)
+	}
+
+	got := map[string][]core.Pod{}
+	for _, g := range groups {
+		got[g.namespace+"/"+g.name] = g.pods
+	}
+	want := map[string][]core.Pod{ns + "/db": db, ns + "/web": web}
+	if diff := deep.Equal(want, got); diff != nil {
+		t.Errorf("d.budgetGroups(...): want != got: %v", diff)
+	}
+	if len(groups) != 2 || groups[0].name != "db" {
+		t.Errorf("d.budgetGroups(...): want groups sorted by name, got %v", groups)
+	}
+	if diff := deep.Equal(cache, unbudgeted); diff != nil {
+		t.Errorf("d.budgetGroups(...): unbudgeted want != got: %v", diff)
+	}
+}
+
+func TestDrainBatchesByBudget(t *testing.T) {
+	pods := newAppPods("web", 5)
+	objs := []runtime.Object{newBudget("web", 2, "web")}
+	for i := range pods {
+		objs = append(objs, &pods[i])
+	}
+	c := fake.NewSimpleClientset(objs...)
+
+	// Each eviction records the batch it belongs to, i.e. the number of
+	// times the budget had been read when the pod was evicted.
+	var (
+		mu      sync.Mutex
+		reads   int
+		batches = map[int]int{}
+	)
+	c.PrependReactor("get", "poddisruptionbudgets", func(_ clienttesting.Action) (bool, runtime.Object, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		reads++
+		return false, nil, nil
+	})
+	c.PrependReactor("create", "pods", func(a clienttesting.Action) (bool, runtime.Object, error) {
+		if a.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		batches[reads]++
+		return true, nil, nil
+	})
+	c.PrependReactor("get", "pods", func(_ clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewNotFound(schema.GroupResource{Resource: "pods"}, podName)
+	})
+
+	d := NewAPICordonDrainer(c, WithBudgetBatching())
+	if err := d.Drain(&core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}); err != nil {
+		t.Fatalf("d.Drain(%v): %v", nodeName, err)
+	}
+	if diff := deep.Equal(map[int]int{1: 2, 2: 2, 3: 1}, batches); diff != nil {
+		t.Errorf("evictions per batch: want != got: %v", diff)
+	}
+}
+
+func TestDrainBatchesByBudgetTimeout(t *testing.T) {
+	pods := newAppPods("web", 3)
+	objs := []runtime.Object{newBudget("web", 1, "web")}
+	for i := range pods {
+		objs = append(objs, &pods[i])
+	}
+	c := fake.NewSimpleClientset(objs...)
+
+	// Each batch of one pod takes 50ms to evict, which is within the 90ms
+	// drain timeout, but the three batches together are not.
+	c.PrependReactor("create", "pods", func(a clienttesting.Action) (bool, runtime.Object, error) {
+		if a.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		time.Sleep(50 * time.Millisecond)
+		return true, nil, nil
+	})
+	c.PrependReactor("get", "pods", func(_ clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewNotFound(schema.GroupResource{Resource: "pods"}, podName)
+	})
+
+	d := NewAPICordonDrainer(c, WithBudgetBatching(), DrainTimeout(60*time.Millisecond, 10*time.Millisecond))
+	if err := d.Drain(&core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}); err != nil {
+		t.Fatalf("d.Drain(%v): %v", nodeName, err)
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+)
+
+// DefaultExecFilterTimeout is the default time to wait for an ExecFilter's
+// executable to exit.
+const DefaultExecFilterTimeout = 10 * time.Second
+
+// execFilterRejected is the exit code with which an ExecFilter's executable
+// rejects an object.
+const execFilterRejected = 1
+
+// An ExecFilter runs a local executable with the JSON representation of a pod
+// or node on stdin. The object passes the filter if the executable exits 0,
+// and does not pass if it exits 1. An executable that exits 0 may also decide
+// by writing true or false to stdout. Any other exit code, or an executable
+// that does not exit in time, is a failure to filter the object.
+type ExecFilter struct {
+	name    string
+	path    string
+	args    []string
+	timeout time.Duration
+}
+
+// NewExecFilter returns a filter that runs the supplied command, i.e. the path
+// to an executable optionally followed by whitespace separated arguments,
+// waiting up to the supplied timeout for it to exit.
+func NewExecFilter(command string, timeout time.Duration) (*ExecFilter, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return nil, errors.New("filter command is required")
+	}
+	return &ExecFilter{path: fields[0], args: fields[1:], timeout: timeout}, nil
+}
+
+// Name returns the name of the filter, i.e. the base name of its executable.
+func (f *ExecFilter) Name() string {
+	if f.name != "" {
+		return f.name
+	}
+	return filepath.Base(f.path)
+}
+
+// FilterPod returns true if the executable passes the supplied pod.
+func (f *ExecFilter) FilterPod(p core.Pod) (bool, error) {
+	passes, err := f.run(p)
+	return passes, errors.Wrapf(err, "cannot filter pod %s/%s", p.GetNamespace(), p.GetName())
+}
+
+// FilterNode returns true if the supplied object is a node that the executable
+// passes. Nodes the executable fails to filter do not pass.
+func (f *ExecFilter) FilterNode(o interface{}) bool {
+	n, ok := o.(*core.Node)
+	if !ok {
+		return false
+	}
+	passes, err := f.run(n)
+	return err == nil && passes
+}
+
+func (f *ExecFilter) run(o interface{}) (bool, error) {
+	in, err := json.Marshal(o)
+	if err != nil {
+		return false, errors.Wrap(err, "cannot encode filter input")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, f.path, f.args...) // nolint:gosec
+	cmd.Stdin = bytes.NewReader(in)
+	out := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd.Stdout = out
+	cmd.Stderr = stderr
+
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return false, errors.Wrapf(errTimeout{}, "filter %s did not exit within %s", f.Name(), f.timeout)
+	}
+	if ee, ok := err.(*exec.ExitError); ok && exitCode(ee) == execFilterRejected {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "filter %s failed: %s", f.Name(), strings.TrimSpace(stderr.String()))
+	}
+
+	switch decision := strings.TrimSpace(out.String()); decision {
+	case "", "true":
+		return true, nil
+	case "false":
+		return false, nil
+	default:
+		return false, errors.Errorf("filter %s wrote %q to stdout; must be true or false", f.Name(), decision)
+	}
+}
+
+// exitCode returns the exit code of the supplied exit error, or -1 if the
+// process was terminated by a signal.
+func exitCode(err *exec.ExitError) int {
+	if s, ok := err.Sys().(interface{ ExitStatus() int }); ok {
+		return s.ExitStatus()
+	}
+	return -1
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newExecFilterScript writes the supplied shell script to an executable file
+// in the supplied directory, returning its path.
+func newExecFilterScript(t *testing.T, dir, name, script string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0700); err != nil {
+		t.Fatalf("cannot write filter script: %v", err)
+	}
+	return path
+}
+
+func TestExecFilterPod(t *testing.T) {
+	dir, err := ioutil.TempDir("", "execfilter")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(...): %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		name       string
+		script     string
+		timeout    time.Duration
+		wantPasses bool
+		wantErr    bool
+	}{
+		{
+			name:       "ExitZero",
+			script:     "cat > /dev/null",
+			wantPasses: true,
+		},
+		{
+			name:   "ExitOne",
+			script: "exit 1",
+		},
+		{
+			name:       "ReadsPod",
+			script:     "grep -q '\"name\":\"" + podName + "\"'",
+			wantPasses: true,
+		},
+		{
+			name:       "StdoutTrue",
+			script:     "echo true",
+			wantPasses: true,
+		},
+		{
+			name:   "StdoutFalse",
+			script: "echo false",
+		},
+		{
+			name:    "StdoutGarbage",
+			script:  "echo maybe",
+			wantErr: true,
+		},
+		{
+			name:    "ExitTwo",
+			script:  "echo broken >&2; exit 2",
+			wantErr: true,
+		},
+		{
+			name:    "Timeout",
+			script:  "exec sleep 5",
+			timeout: 100 * time.Millisecond,
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			timeout := tc.timeout
+			if timeout == 0 {
+				timeout = DefaultExecFilterTimeout
+			}
+			f, err := NewExecFilter(newExecFilterScript(t, dir, tc.name, tc.script), timeout)
+			if err != nil {
+				t.Fatalf("NewExecFilter(...): %v", err)
+			}
+			passes, err := f.FilterPod(core.Pod{ObjectMeta: meta.ObjectMeta{Name: podName, Namespace: ns}})
+			if (err != nil) != tc.wantErr {
+				t.Errorf("f.FilterPod(...): want error %v, got %v", tc.wantErr, err)
+			}
+			if passes != tc.wantPasses {
+				t.Errorf("f.FilterPod(...): want %v, got %v", tc.wantPasses, passes)
+			}
+		})
+	}
+}
+
+func TestExecFilterNode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "execfilter")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(...): %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		name   string
+		script string
+		obj    interface{}
+		want   bool
+	}{
+		{
+			name:   "Passes",
+			script: "grep -q '\"name\":\"" + nodeName + "\"'",
+			obj:    &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}},
+			want:   true,
+		},
+		{
+			name:   "Rejected",
+			script: "exit 1",
+			obj:    &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}},
+		},
+		{
+			name:   "Failed",
+			script: "exit 3",
+			obj:    &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}},
+		},
+		{
+			name:   "NotANode",
+			script: "exit 0",
+			obj:    &core.Pod{ObjectMeta: meta.ObjectMeta{Name: podName}},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := NewExecFilter(newExecFilterScript(t, dir, tc.name, tc.script), DefaultExecFilterTimeout)
+			if err != nil {
+				t.Fatalf("NewExecFilter(...): %v", err)
+			}
+			if got := f.FilterNode(tc.obj); got != tc.want {
+				t.Errorf("f.FilterNode(...): want %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestNewExecFilter(t *testing.T) {
+	f, err := NewExecFilter("/usr/local/bin/policy --strict  pods", DefaultExecFilterTimeout)
+	if err != nil {
+		t.Fatalf("NewExecFilter(...): %v", err)
+	}
+	if f.Name() != "policy" {
+		t.Errorf("f.Name(): want policy, got %v", f.Name())
+	}
+	if len(f.args) != 2 || f.args[0] != "--strict" || f.args[1] != "pods" {
+		t.Errorf("f.args: want [--strict pods], got %v", f.args)
+	}
+	if _, err := NewExecFilter("  ", DefaultExecFilterTimeout); err == nil {
+		t.Errorf("NewExecFilter(\"  \"): want error, got nil")
+	}
+}
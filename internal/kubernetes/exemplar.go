@@ -0,0 +1,153 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opencensus.io/trace"
+)
+
+// Bucket boundaries, in seconds, of the drain duration and eviction latency
+// distributions.
+var (
+	DrainDurationBuckets   = []float64{10, 30, 60, 120, 300, 600, 1200, 1800, 3600, 7200}
+	EvictionLatencyBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1200, 1800}
+)
+
+// An ExemplarMetric is a distribution metric, recorded in seconds, whose
+// values are the durations of the spans of the supplied name.
+type ExemplarMetric struct {
+	// Name of the metric, as exported, e.g. draino_drain_duration_seconds.
+	Name string
+
+	// Span whose durations the metric records, e.g. SpanDrain.
+	Span string
+
+	// Buckets are the upper bounds of the metric's buckets, in ascending
+	// order.
+	Buckets []float64
+}
+
+// An Exemplar is a sampled span whose duration fell in a bucket of a
+// distribution metric.
+type Exemplar struct {
+	Metric     string            `json:"metric"`
+	Bucket     string            `json:"le"`
+	Value      float64           `json:"value"`
+	Time       time.Time         `json:"time"`
+	TraceID    string            `json:"traceID"`
+	SpanID     string            `json:"spanID"`
+	StatusCode int32             `json:"statusCode"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// Exemplars is an OpenCensus trace exporter that links distribution metrics
+// to traces. It retains the most recently sampled span in each bucket of each
+// metric, and is an http.Handler that serves them as a JSON array, ordered by
+// metric and bucket. The metric query parameter limits the response to the
+// exemplars of one metric.
+type Exemplars struct {
+	metrics map[string]ExemplarMetric
+
+	mu        sync.Mutex
+	exemplars map[string][]*Exemplar
+}
+
+// NewExemplars returns a trace exporter that retains exemplars of the
+// supplied metrics.
+func NewExemplars(ms ...ExemplarMetric) *Exemplars {
+	e := &Exemplars{metrics: make(map[string]ExemplarMetric), exemplars: make(map[string][]*Exemplar)}
+	for _, m := range ms {
+		e.metrics[m.Span] = m
+		// The final bucket is +Inf.
+		e.exemplars[m.Name] = make([]*Exemplar, len(m.Buckets)+1)
+	}
+	return e
+}
+
+// ExportSpan retains the supplied span as the exemplar of the bucket its
+// duration fell in, if a metric records spans of its name.
+func (e *Exemplars) ExportSpan(s *trace.SpanData) {
+	m, ok := e.metrics[s.Name]
+	if !ok {
+		return
+	}
+	v := s.EndTime.Sub(s.StartTime).Seconds()
+	// Like OpenCensus, buckets exclude their upper bound.
+	i := sort.Search(len(m.Buckets), func(i int) bool { return v < m.Buckets[i] })
+	bucket := "+Inf"
+	if i < len(m.Buckets) {
+		bucket = strconv.FormatFloat(m.Buckets[i], 'g', -1, 64)
+	}
+	x := &Exemplar{
+		Metric:     m.Name,
+		Bucket:     bucket,
+		Value:      v,
+		Time:       s.EndTime,
+		TraceID:    s.TraceID.String(),
+		SpanID:     s.SpanID.String(),
+		StatusCode: s.Code,
+	}
+	if len(s.Attributes) > 0 {
+		x.Attributes = make(map[string]string, len(s.Attributes))
+		for k, a := range s.Attributes {
+			x.Attributes[k] = fmt.Sprint(a)
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.exemplars[m.Name][i] = x
+}
+
+// Retained returns the retained exemplars of the named metric, or of all
+// metrics if the name is empty.
+func (e *Exemplars) Retained(metric string) []Exemplar {
+	names := make([]string, 0, len(e.exemplars))
+	for name := range e.exemplars {
+		if metric == "" || metric == name {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	exemplars := []Exemplar{}
+	for _, name := range names {
+		for _, x := range e.exemplars[name] {
+			if x != nil {
+				exemplars = append(exemplars, *x)
+			}
+		}
+	}
+	return exemplars
+}
+
+// ServeHTTP serves the exemplars of the metric named by the request's metric
+// query parameter, or of all metrics.
+func (e *Exemplars) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.Body.Close() // nolint:gosec
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(e.Retained(r.URL.Query().Get("metric"))) // nolint:gosec
+}
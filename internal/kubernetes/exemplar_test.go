@@ -0,0 +1,142 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"go.opencensus.io/trace"
+)
+
+func TestExemplars(t *testing.T) {
+	start := time.Unix(0, 0).UTC()
+	span := func(name string, d time.Duration, id byte) *trace.SpanData {
+		return &trace.SpanData{
+			SpanContext: trace.SpanContext{TraceID: trace.TraceID{id}, SpanID: trace.SpanID{id}},
+			Name:        name,
+			StartTime:   start,
+			EndTime:     start.Add(d),
+			Attributes:  map[string]interface{}{"node": nodeName},
+		}
+	}
+	exemplar := func(metric, bucket string, d time.Duration, id byte) Exemplar {
+		s := span("", d, id)
+		return Exemplar{
+			Metric:     metric,
+			Bucket:     bucket,
+			Value:      d.Seconds(),
+			Time:       s.EndTime,
+			TraceID:    s.TraceID.String(),
+			SpanID:     s.SpanID.String(),
+			Attributes: map[string]string{"node": nodeName},
+		}
+	}
+
+	cases := []struct {
+		name   string
+		spans  []*trace.SpanData
+		metric string
+		want   []Exemplar
+	}{
+		{
+			name:  "NoSpans",
+			spans: []*trace.SpanData{},
+			want:  []Exemplar{},
+		},
+		{
+			name:  "Bucketed",
+			spans: []*trace.SpanData{span(SpanDrain, 5*time.Second, 1), span(SpanEvict, 5*time.Second, 2)},
+			want: []Exemplar{
+				exemplar("drain", "10", 5*time.Second, 1),
+				exemplar("evict", "10", 5*time.Second, 2),
+			},
+		},
+		{
+			name:  "UpperBoundExcluded",
+			spans: []*trace.SpanData{span(SpanDrain, 10*time.Second, 1)},
+			want:  []Exemplar{exemplar("drain", "60", 10*time.Second, 1)},
+		},
+		{
+			name:  "Overflow",
+			spans: []*trace.SpanData{span(SpanDrain, time.Minute, 1)},
+			want:  []Exemplar{exemplar("drain", "+Inf", time.Minute, 1)},
+		},
+		{
+			name:  "MostRecentRetained",
+			spans: []*trace.SpanData{span(SpanDrain, 5*time.Second, 1), span(SpanDrain, 6*time.Second, 2), span(SpanDrain, time.Second, 3)},
+			want:  []Exemplar{exemplar("drain", "10", time.Second, 3)},
+		},
+		{
+			name:  "OrderedByBucket",
+			spans: []*trace.SpanData{span(SpanDrain, time.Minute, 1), span(SpanDrain, time.Second, 2)},
+			want: []Exemplar{
+				exemplar("drain", "10", time.Second, 2),
+				exemplar("drain", "+Inf", time.Minute, 1),
+			},
+		},
+		{
+			name:  "OtherSpansIgnored",
+			spans: []*trace.SpanData{span(SpanCordon, time.Second, 1)},
+			want:  []Exemplar{},
+		},
+		{
+			name:   "Metric",
+			spans:  []*trace.SpanData{span(SpanDrain, 5*time.Second, 1), span(SpanEvict, 5*time.Second, 2)},
+			metric: "evict",
+			want:   []Exemplar{exemplar("evict", "10", 5*time.Second, 2)},
+		},
+		{
+			name:   "UnknownMetric",
+			spans:  []*trace.SpanData{span(SpanDrain, 5*time.Second, 1)},
+			metric: "unknown",
+			want:   []Exemplar{},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := NewExemplars(
+				ExemplarMetric{Name: "drain", Span: SpanDrain, Buckets: []float64{10, 60}},
+				ExemplarMetric{Name: "evict", Span: SpanEvict, Buckets: []float64{10}},
+			)
+			for _, s := range tc.spans {
+				e.ExportSpan(s)
+			}
+			if diff := deep.Equal(e.Retained(tc.metric), tc.want); diff != nil {
+				t.Errorf("e.Retained(%q): want != got: %v", tc.metric, diff)
+			}
+
+			rsp := httptest.NewRecorder()
+			e.ServeHTTP(rsp, httptest.NewRequest(http.MethodGet, "/exemplars?metric="+tc.metric, nil))
+			if ct := rsp.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("e.ServeHTTP(...): want Content-Type application/json, got %v", ct)
+			}
+			got := []Exemplar{}
+			if err := json.NewDecoder(rsp.Body).Decode(&got); err != nil {
+				t.Fatalf("json.NewDecoder(...).Decode(...): %v", err)
+			}
+			if diff := deep.Equal(got, tc.want); diff != nil {
+				t.Errorf("e.ServeHTTP(...): want != got: %v", diff)
+			}
+		})
+	}
+}
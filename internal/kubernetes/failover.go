@@ -0,0 +1,125 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+)
+
+// ConfigureAPIServerFailover configures the supplied client config to send
+// requests to the first of the supplied API server endpoints, failing over to
+// the next whenever a request cannot reach the current endpoint. Requests are
+// only failed over if they could not be sent, never once an API server has
+// responded to them. Endpoints are addresses such as https://10.0.0.1:6443, and
+// must all present a certificate valid for the config's TLS settings.
+func ConfigureAPIServerFailover(c *rest.Config, endpoints []string, l *zap.Logger) error {
+	if len(endpoints) == 0 {
+		return errors.New("at least one API server endpoint is required")
+	}
+	tls := rest.IsConfigTransportTLS(*c)
+	urls := make([]*url.URL, 0, len(endpoints))
+	for _, e := range endpoints {
+		u, _, err := rest.DefaultServerURL(e, "", schema.GroupVersion{}, tls)
+		if err != nil {
+			return errors.Wrapf(err, "invalid API server endpoint %q", e)
+		}
+		urls = append(urls, u)
+	}
+	c.Host = urls[0].String()
+
+	wrap := c.WrapTransport
+	c.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		if wrap != nil {
+			rt = wrap(rt)
+		}
+		return &failoverTransport{rt: rt, l: l, endpoints: urls}
+	}
+	return nil
+}
+
+// A failoverTransport sends each request to the current of several
+// equivalent API server endpoints.
+type failoverTransport struct {
+	rt        http.RoundTripper
+	l         *zap.Logger
+	endpoints []*url.URL
+
+	mu      sync.Mutex
+	current int
+}
+
+func (t *failoverTransport) active() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.current
+}
+
+func (t *failoverTransport) failover(from, to int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.current != from {
+		// Another request already failed over.
+		return
+	}
+	t.current = to
+	t.l.Info("failed over to API server", zap.String("from", t.endpoints[from].Host), zap.String("to", t.endpoints[to].Host))
+}
+
+// RoundTrip sends the supplied request to the current endpoint, or to each of
+// the others in turn if it cannot be sent.
+func (t *failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := t.active()
+	var err error
+	for i := range t.endpoints {
+		n := (start + i) % len(t.endpoints)
+		r := req.WithContext(req.Context())
+		u := *req.URL
+		u.Scheme, u.Host = t.endpoints[n].Scheme, t.endpoints[n].Host
+		r.URL, r.Host = &u, ""
+		if i > 0 && req.Body != nil {
+			if req.GetBody == nil {
+				// The body has been consumed and cannot be sent again.
+				return nil, err
+			}
+			if r.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+
+		var rsp *http.Response
+		rsp, err = t.rt.RoundTrip(r)
+		if err == nil {
+			if n != start {
+				t.failover(start, n)
+			}
+			return rsp, nil
+		}
+		if req.Context().Err() != nil {
+			// The request was cancelled; the endpoint may be fine.
+			return nil, err
+		}
+		t.l.Debug("cannot reach API server", zap.String("endpoint", t.endpoints[n].Host), zap.Error(err))
+	}
+	return nil, err
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	client "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestAPIServerFailover(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	requests := 0
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		n := &core.Node{}
+		if r.Method == http.MethodPut {
+			if err := json.NewDecoder(r.Body).Decode(n); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		} else {
+			n.SetName(nodeName)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(n) // nolint:gosec
+	}))
+	defer live.Close()
+
+	c := &rest.Config{Host: dead.URL}
+	if err := ConfigureAPIServerFailover(c, []string{dead.URL, live.URL}, zap.NewNop()); err != nil {
+		t.Fatalf("ConfigureAPIServerFailover(): %v", err)
+	}
+	cs, err := client.NewForConfig(c)
+	if err != nil {
+		t.Fatalf("client.NewForConfig(): %v", err)
+	}
+
+	n, err := cs.CoreV1().Nodes().Get(nodeName, meta.GetOptions{})
+	if err != nil {
+		t.Fatalf("cs.CoreV1().Nodes().Get(%v): %v", nodeName, err)
+	}
+	if n.GetName() != nodeName {
+		t.Errorf("n.GetName(): want %v, got %v", nodeName, n.GetName())
+	}
+
+	// Request bodies are sent to the endpoint that is failed over to.
+	n.Spec.Unschedulable = true
+	updated, err := cs.CoreV1().Nodes().Update(n)
+	if err != nil {
+		t.Fatalf("cs.CoreV1().Nodes().Update(%v): %v", nodeName, err)
+	}
+	if !updated.Spec.Unschedulable {
+		t.Errorf("updated.Spec.Unschedulable: want true, got false")
+	}
+	if requests != 2 {
+		t.Errorf("requests: want 2, got %d", requests)
+	}
+}
+
+func TestAPIServerFailoverUnreachable(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	c := &rest.Config{Host: dead.URL}
+	if err := ConfigureAPIServerFailover(c, []string{dead.URL, dead.URL}, zap.NewNop()); err != nil {
+		t.Fatalf("ConfigureAPIServerFailover(): %v", err)
+	}
+	cs, err := client.NewForConfig(c)
+	if err != nil {
+		t.Fatalf("client.NewForConfig(): %v", err)
+	}
+	if _, err := cs.CoreV1().Nodes().Get(nodeName, meta.GetOptions{}); err == nil {
+		t.Errorf("cs.CoreV1().Nodes().Get(%v): want error, got nil", nodeName)
+	}
+}
+
+func TestFailoverTransportSticks(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	defer live.Close()
+
+	c := &rest.Config{}
+	if err := ConfigureAPIServerFailover(c, []string{dead.URL, live.URL}, zap.NewNop()); err != nil {
+		t.Fatalf("ConfigureAPIServerFailover(): %v", err)
+	}
+	ft := c.WrapTransport(http.DefaultTransport).(*failoverTransport)
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest(http.MethodGet, dead.URL, nil) // nolint:gosec
+		rsp, err := ft.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("ft.RoundTrip(): %v", err)
+		}
+		rsp.Body.Close() // nolint:gosec
+		if ft.active() != 1 {
+			t.Errorf("ft.active(): want 1, got %d", ft.active())
+		}
+	}
+}
+
+func TestConfigureAPIServerFailoverInvalid(t *testing.T) {
+	if err := ConfigureAPIServerFailover(&rest.Config{}, nil, zap.NewNop()); err == nil {
+		t.Error("ConfigureAPIServerFailover(nil): want error, got nil")
+	}
+	if err := ConfigureAPIServerFailover(&rest.Config{}, []string{"https://a/b/c%zz"}, zap.NewNop()); err == nil {
+		t.Error("ConfigureAPIServerFailover(invalid): want error, got nil")
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// A Headroom is an amount of unrequested capacity, either an absolute
+// quantity or a percentage of allocatable capacity.
+type Headroom struct {
+	raw      string
+	quantity resource.Quantity
+	percent  float64
+}
+
+// ParseHeadroom parses a headroom, either a quantity such as 4Gi or a
+// percentage such as 10%.
+func ParseHeadroom(s string) (*Headroom, error) {
+	if strings.HasSuffix(s, "%") {
+		p, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil || p < 0 || p > 100 {
+			return nil, errors.Errorf("cannot parse headroom %s: percentages must be between 0%% and 100%%", s)
+		}
+		return &Headroom{raw: s, percent: p}, nil
+	}
+	q, err := resource.ParseQuantity(s)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot parse headroom %s", s)
+	}
+	return &Headroom{raw: s, quantity: q}, nil
+}
+
+// String returns the headroom as it was parsed.
+func (h *Headroom) String() string {
+	return h.raw
+}
+
+// required returns the headroom required of the supplied allocatable
+// capacity, using the supplied function to convert quantities to the same
+// unit.
+func (h *Headroom) required(allocatable int64, value func(q *resource.Quantity) int64) int64 {
+	if h.percent > 0 {
+		return int64(float64(allocatable) * h.percent / 100)
+	}
+	return value(&h.quantity)
+}
+
+func quantityMilliValue(q *resource.Quantity) int64 { return q.MilliValue() }
+func quantityValue(q *resource.Quantity) int64      { return q.Value() }
+
+// NewHeadroomGate returns a DrainGateFunc that prevents drains from starting
+// if the unrequested CPU or memory of the cluster's schedulable nodes would be
+// less than the supplied headroom once the pods on the node to be drained were
+// rescheduled. Percentages are of the schedulable nodes' allocatable capacity,
+// excluding the node to be drained. A nil headroom is not required.
+func NewHeadroomGate(pods PodStore, nodes NodeStore, cpu, memory *Headroom) DrainGateFunc {
+	return func(n *core.Node, _ time.Time) (bool, string) {
+		if !pods.HasSynced() {
+			return false, "cannot determine cluster headroom: pods have not been cached yet"
+		}
+
+		schedulable := make(map[string]bool)
+		var allocatableCPU, allocatableMemory int64
+		for _, o := range nodes.List() {
+			if o.GetName() == n.GetName() || o.Spec.Unschedulable || !nodeReady(o) {
+				continue
+			}
+			schedulable[o.GetName()] = true
+			allocatableCPU += o.Status.Allocatable.Cpu().MilliValue()
+			allocatableMemory += o.Status.Allocatable.Memory().Value()
+		}
+
+		freeCPU, freeMemory := allocatableCPU, allocatableMemory
+		for _, p := range pods.List() {
+			switch {
+			case schedulable[p.Spec.NodeName]:
+			case p.Spec.NodeName == n.GetName():
+				// Pods managed by a DaemonSet are not rescheduled
+				// elsewhere.
+				if o := meta.GetControllerOf(p); o != nil && o.Kind == kindDaemonSet {
+					continue
+				}
+			default:
+				continue
+			}
+			requestedCPU, requestedMemory := podRequests(p)
+			freeCPU -= requestedCPU
+			freeMemory -= requestedMemory
+		}
+
+		if cpu != nil {
+			if want := cpu.required(allocatableCPU, quantityMilliValue); freeCPU < want {
+				return false, fmt.Sprintf("cluster would have %s unrequested CPU, less than the required %s", resource.NewMilliQuantity(freeCPU, resource.DecimalSI), cpu)
+			}
+		}
+		if memory != nil {
+			if want := memory.required(allocatableMemory, quantityValue); freeMemory < want {
+				return false, fmt.Sprintf("cluster would have %s unrequested memory, less than the required %s", resource.NewQuantity(freeMemory, resource.BinarySI), memory)
+			}
+		}
+		return true, ""
+	}
+}
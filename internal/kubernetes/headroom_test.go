@@ -0,0 +1,122 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	core "k8s.io/api/core/v1"
+)
+
+func TestParseHeadroom(t *testing.T) {
+	cases := []struct {
+		name    string
+		s       string
+		wantErr bool
+	}{
+		{name: "Quantity", s: "4Gi"},
+		{name: "MilliQuantity", s: "500m"},
+		{name: "Percent", s: "10%"},
+		{name: "FractionalPercent", s: "2.5%"},
+		{name: "TooManyPercent", s: "101%", wantErr: true},
+		{name: "NotAPercent", s: "lots%", wantErr: true},
+		{name: "NotAQuantity", s: "lots", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			h, err := ParseHeadroom(tc.s)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("ParseHeadroom(%q): want error %v, got %v", tc.s, tc.wantErr, err)
+			}
+			if err == nil && h.String() != tc.s {
+				t.Errorf("ParseHeadroom(%q).String(): got %q", tc.s, h.String())
+			}
+		})
+	}
+}
+
+func TestHeadroomGate(t *testing.T) {
+	cases := []struct {
+		name     string
+		cpu      string
+		memory   string
+		wantOpen bool
+	}{
+		{
+			name:     "NoHeadroomRequired",
+			wantOpen: true,
+		},
+		{
+			// The other nodes have 4 CPUs allocatable, and would have 2
+			// unrequested once the drained node's pods were rescheduled.
+			name:     "EnoughCPU",
+			cpu:      "2",
+			wantOpen: true,
+		},
+		{
+			name: "NotEnoughCPU",
+			cpu:  "2500m",
+		},
+		{
+			name:     "EnoughCPUPercent",
+			cpu:      "50%",
+			wantOpen: true,
+		},
+		{
+			name: "NotEnoughCPUPercent",
+			cpu:  "51%",
+		},
+		{
+			name:     "EnoughMemory",
+			memory:   "8Gi",
+			wantOpen: true,
+		},
+		{
+			name:   "NotEnoughMemory",
+			memory: "9Gi",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			draining := newCapacityNode(nodeName, "4")
+			other := newCapacityNode(otherNodeName, "2")
+			another := newCapacityNode("anotherNode", "2")
+			cordoned := newCapacityNode("cordonedNode", "2")
+			cordoned.Spec.Unschedulable = true
+			pods := &staticPodStore{pods: []*core.Pod{
+				newRequestingPod(podName, nodeName, "1"),
+				newRequestingPod("other", otherNodeName, "1"),
+				newRequestingPod("cordoned", "cordonedNode", "1"),
+			}}
+
+			var cpu, memory *Headroom
+			if tc.cpu != "" {
+				cpu, _ = ParseHeadroom(tc.cpu)
+			}
+			if tc.memory != "" {
+				memory, _ = ParseHeadroom(tc.memory)
+			}
+			g := NewHeadroomGate(pods, staticNodeStore{draining, other, another, cordoned}, cpu, memory)
+			if open, reason := g(draining, time.Now()); open != tc.wantOpen {
+				t.Errorf("g(%v): want open %v, got %v: %s", draining.GetName(), tc.wantOpen, open, reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Default API health check settings.
+const (
+	DefaultAPITimeout      = 10 * time.Second
+	DefaultMaxWatchSilence = 5 * time.Minute
+)
+
+// An APIHealthCheck determines whether draino can still communicate with the
+// Kubernetes API. It is a ResourceEventHandler that should be added to a node
+// watch, which it considers wedged if no node events are received for a
+// sustained period. Nodes report their status frequently, so a healthy node
+// watch receives events continuously.
+type APIHealthCheck struct {
+	c          kubernetes.Interface
+	timeout    time.Duration
+	maxSilence time.Duration
+
+	mu        sync.Mutex
+	lastEvent time.Time
+}
+
+// APIHealthCheckOption configures an APIHealthCheck.
+type APIHealthCheckOption func(h *APIHealthCheck)
+
+// WithAPITimeout configures how long the health check waits for the
+// Kubernetes API to respond.
+func WithAPITimeout(t time.Duration) APIHealthCheckOption {
+	return func(h *APIHealthCheck) {
+		h.timeout = t
+	}
+}
+
+// WithMaxWatchSilence configures how long the node watch may go without
+// receiving events before it is considered unhealthy.
+func WithMaxWatchSilence(d time.Duration) APIHealthCheckOption {
+	return func(h *APIHealthCheck) {
+		h.maxSilence = d
+	}
+}
+
+// NewAPIHealthCheck returns an APIHealthCheck that checks the Kubernetes API
+// using the supplied client.
+func NewAPIHealthCheck(c kubernetes.Interface, ho ...APIHealthCheckOption) *APIHealthCheck {
+	h := &APIHealthCheck{
+		c:          c,
+		timeout:    DefaultAPITimeout,
+		maxSilence: DefaultMaxWatchSilence,
+		lastEvent:  time.Now(),
+	}
+	for _, o := range ho {
+		o(h)
+	}
+	return h
+}
+
+// OnAdd records that the node watch received an event.
+func (h *APIHealthCheck) OnAdd(_ interface{}) {
+	h.mu.Lock()
+	h.lastEvent = time.Now()
+	h.mu.Unlock()
+}
+
+// OnUpdate records that the node watch received an event.
+func (h *APIHealthCheck) OnUpdate(_, newObj interface{}) {
+	h.OnAdd(newObj)
+}
+
+// OnDelete records that the node watch received an event.
+func (h *APIHealthCheck) OnDelete(obj interface{}) {
+	h.OnAdd(obj)
+}
+
+// Check returns an error if the node watch has not received any events
+// recently, or if the Kubernetes API does not respond to a lightweight request
+// within the configured timeout.
+func (h *APIHealthCheck) Check() error {
+	h.mu.Lock()
+	last := h.lastEvent
+	h.mu.Unlock()
+	if silent := time.Since(last); silent > h.maxSilence {
+		return errors.Errorf("node watch has received no events for %s", silent.Round(time.Second))
+	}
+
+	// The client does not support cancellation, so a request that never
+	// returns is abandoned once the timeout passes.
+	result := make(chan error, 1)
+	go func() {
+		_, err := h.c.Discovery().ServerVersion()
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		return errors.Wrap(err, "cannot get Kubernetes API server version")
+	case <-time.After(h.timeout):
+		return errors.Errorf("timed out after %s waiting for Kubernetes API server", h.timeout)
+	}
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestAPIHealthCheck(t *testing.T) {
+	cases := []struct {
+		name      string
+		silent    time.Duration
+		apiDelay  time.Duration
+		wantError bool
+	}{
+		{name: "Healthy", silent: 1 * time.Minute},
+		{name: "WatchSilent", silent: 10 * time.Minute, wantError: true},
+		{name: "APITimedOut", silent: 1 * time.Minute, apiDelay: 1 * time.Second, wantError: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := fake.NewSimpleClientset()
+			c.PrependReactor("get", "version", func(_ clienttesting.Action) (bool, runtime.Object, error) {
+				time.Sleep(tc.apiDelay)
+				return false, nil, nil
+			})
+			h := NewAPIHealthCheck(c, WithAPITimeout(100*time.Millisecond))
+			h.OnUpdate(nil, nil)
+			h.lastEvent = h.lastEvent.Add(-tc.silent)
+
+			err := h.Check()
+			if tc.wantError != (err != nil) {
+				t.Errorf("h.Check(): want error %v, got %v", tc.wantError, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,339 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// Default history settings.
+const (
+	DefaultHistorySize      = 10000
+	DefaultHistoryRetention = 7 * 24 * time.Hour
+
+	historySaveInterval = time.Minute
+)
+
+// Actions recorded in the history.
+const (
+	historyActionCordon    = "cordon"
+	historyActionDrain     = "drain"
+	historyActionPreDrain  = "pre-drain"
+	historyActionPostDrain = "post-drain"
+	historyActionReplace   = "replace"
+	historyActionUncordon  = "uncordon"
+)
+
+// Outcomes of the actions recorded in the history, in addition to those of
+// the result metric tag.
+const (
+	historyOutcomeScheduled = "scheduled"
+	historyOutcomeStarted   = "started"
+	historyOutcomeExpired   = "expired"
+)
+
+type historyAction struct {
+	action  string
+	outcome string
+}
+
+// historyActions maps the reasons of the events that describe actions taken
+// on nodes to the action and its outcome.
+var historyActions = map[string]historyAction{
+	eventReasonCordonStarting:    {historyActionCordon, historyOutcomeStarted},
+	eventReasonCordonSucceeded:   {historyActionCordon, tagResultSucceeded},
+	eventReasonCordonFailed:      {historyActionCordon, tagResultFailed},
+	eventReasonCordonDeferred:    {historyActionCordon, tagResultDeferred},
+	eventReasonDrainScheduled:    {historyActionDrain, historyOutcomeScheduled},
+	eventReasonDrainStarting:     {historyActionDrain, historyOutcomeStarted},
+	eventReasonDrainSucceeded:    {historyActionDrain, tagResultSucceeded},
+	eventReasonDrainFailed:       {historyActionDrain, tagResultFailed},
+	eventReasonDrainExpired:      {historyActionDrain, historyOutcomeExpired},
+	eventReasonDrainSkipped:      {historyActionDrain, tagResultSkipped},
+	eventReasonDrainDeferred:     {historyActionDrain, tagResultDeferred},
+	eventReasonPreDrainFailed:    {historyActionPreDrain, tagResultFailed},
+	eventReasonPostDrainFailed:   {historyActionPostDrain, tagResultFailed},
+	eventReasonReplaceFailed:     {historyActionReplace, tagResultFailed},
+	eventReasonUncordonSucceeded: {historyActionUncordon, tagResultSucceeded},
+	eventReasonUncordonFailed:    {historyActionUncordon, tagResultFailed},
+}
+
+// A HistoryRecord describes an action draino took on a node, for example
+// cordoning or draining it, and its outcome.
+type HistoryRecord struct {
+	AuditRecord
+	Action  string `json:"action"`
+	Outcome string `json:"outcome"`
+}
+
+// A History retains a bounded record of the recent actions draino took on
+// nodes. It is an AuditRecorder, and an http.Handler that serves the records
+// as a JSON array, oldest first. Records may be filtered using the node,
+// cluster, action, and outcome query parameters, and the since parameter,
+// either a duration such as 12h or an RFC 3339 time. The limit parameter
+// limits the response to the most recent matching records.
+type History struct {
+	l         *zap.Logger
+	size      int
+	retention time.Duration
+	file      string
+
+	mu      sync.Mutex
+	records []HistoryRecord
+	dirty   bool
+}
+
+// A HistoryOption configures a History.
+type HistoryOption func(h *History)
+
+// WithHistoryLogger configures a History to use the supplied logger.
+func WithHistoryLogger(l *zap.Logger) HistoryOption {
+	return func(h *History) {
+		h.l = l
+	}
+}
+
+// WithHistorySize configures the maximum number of records retained. The
+// oldest records are dropped first.
+func WithHistorySize(n int) HistoryOption {
+	return func(h *History) {
+		h.size = n
+	}
+}
+
+// WithHistoryRetention configures how long records are retained.
+func WithHistoryRetention(d time.Duration) HistoryOption {
+	return func(h *History) {
+		h.retention = d
+	}
+}
+
+// WithHistoryFile configures a History to persist its records to the supplied
+// file, so that they survive a restart.
+func WithHistoryFile(path string) HistoryOption {
+	return func(h *History) {
+		h.file = path
+	}
+}
+
+// NewHistory returns a History, loading any records persisted to its file.
+func NewHistory(ho ...HistoryOption) (*History, error) {
+	h := &History{l: zap.NewNop(), size: DefaultHistorySize, retention: DefaultHistoryRetention}
+	for _, o := range ho {
+		o(h)
+	}
+	if h.file == "" {
+		return h, nil
+	}
+	f, err := os.Open(h.file)
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open history file %s", h.file)
+	}
+	defer f.Close() // nolint:gosec
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		r := HistoryRecord{}
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+			return nil, errors.Wrapf(err, "cannot decode history file %s", h.file)
+		}
+		h.records = append(h.records, r)
+	}
+	if err := s.Err(); err != nil {
+		return nil, errors.Wrapf(err, "cannot read history file %s", h.file)
+	}
+	h.trim(time.Now())
+	return h, nil
+}
+
+// Record the supplied audit record, if it describes an action on a node.
+func (h *History) Record(r AuditRecord) {
+	a, ok := historyActions[r.Reason]
+	if !ok || r.Kind != "Node" {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, HistoryRecord{AuditRecord: r, Action: a.action, Outcome: a.outcome})
+	h.dirty = true
+	h.trim(time.Now())
+}
+
+// trim records that are too old, or too numerous. It must be called with h.mu
+// held.
+func (h *History) trim(now time.Time) {
+	expired := 0
+	for _, r := range h.records {
+		if now.Sub(r.Time) > h.retention {
+			expired++
+		}
+	}
+	if expired == 0 && len(h.records) <= h.size {
+		return
+	}
+	// Records may be read outside the lock once persisted, so they are
+	// copied rather than trimmed in place.
+	kept := make([]HistoryRecord, 0, len(h.records)-expired)
+	for _, r := range h.records {
+		if now.Sub(r.Time) <= h.retention {
+			kept = append(kept, r)
+		}
+	}
+	if n := len(kept) - h.size; n > 0 {
+		kept = kept[n:]
+	}
+	h.records = kept
+	h.dirty = true
+}
+
+// A HistoryFilter selects history records. Empty fields match all records.
+type HistoryFilter struct {
+	Node    string
+	Cluster string
+	Action  string
+	Outcome string
+	Since   time.Time
+	Limit   int
+}
+
+func (f HistoryFilter) matches(r HistoryRecord) bool {
+	return (f.Node == "" || r.Name == f.Node) &&
+		(f.Cluster == "" || r.Cluster == f.Cluster) &&
+		(f.Action == "" || r.Action == f.Action) &&
+		(f.Outcome == "" || r.Outcome == f.Outcome) &&
+		!r.Time.Before(f.Since)
+}
+
+// Records returns the retained records that match the supplied filter, oldest
+// first.
+func (h *History) Records(f HistoryFilter) []HistoryRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.trim(time.Now())
+	records := []HistoryRecord{}
+	for _, r := range h.records {
+		if f.matches(r) {
+			records = append(records, r)
+		}
+	}
+	if f.Limit > 0 && len(records) > f.Limit {
+		records = records[len(records)-f.Limit:]
+	}
+	return records
+}
+
+// ServeHTTP serves the records that match the request's query parameters.
+func (h *History) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.Body.Close() // nolint:gosec
+	f, err := parseHistoryFilter(r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.Records(f)) // nolint:gosec
+}
+
+func parseHistoryFilter(r *http.Request, now time.Time) (HistoryFilter, error) {
+	q := r.URL.Query()
+	f := HistoryFilter{Node: q.Get("node"), Cluster: q.Get("cluster"), Action: q.Get("action"), Outcome: q.Get("outcome")}
+	if since := q.Get("since"); since != "" {
+		if d, err := time.ParseDuration(since); err == nil {
+			f.Since = now.Add(-d)
+		} else if f.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return f, errors.Errorf("since %q must be a duration or an RFC 3339 time", since)
+		}
+	}
+	if limit := q.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			return f, errors.Errorf("limit %q must be a non-negative integer", limit)
+		}
+		f.Limit = n
+	}
+	return f, nil
+}
+
+// Run the history until the supplied channel is closed, periodically
+// persisting its records to its file, if any. Records are persisted once more
+// when the channel is closed.
+func (h *History) Run(stop <-chan struct{}) {
+	if h.file == "" {
+		<-stop
+		return
+	}
+	wait.Until(h.persist, historySaveInterval, stop)
+	h.persist()
+}
+
+func (h *History) persist() {
+	h.mu.Lock()
+	if !h.dirty {
+		h.mu.Unlock()
+		return
+	}
+	records := h.records
+	h.dirty = false
+	h.mu.Unlock()
+
+	if err := h.save(records); err != nil {
+		h.l.Info("Failed to persist history", zap.String("file", h.file), zap.Error(err))
+		h.mu.Lock()
+		h.dirty = true
+		h.mu.Unlock()
+	}
+}
+
+// save the supplied records, replacing the file atomically so that it is
+// never partially written.
+func (h *History) save(records []HistoryRecord) error {
+	f, err := ioutil.TempFile(filepath.Dir(h.file), filepath.Base(h.file)+".")
+	if err != nil {
+		return errors.Wrap(err, "cannot create temporary file")
+	}
+	defer os.Remove(f.Name()) // nolint:gosec
+	w := bufio.NewWriter(f)
+	e := json.NewEncoder(w)
+	for _, r := range records {
+		if err := e.Encode(r); err != nil {
+			f.Close() // nolint:gosec
+			return errors.Wrap(err, "cannot encode history record")
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close() // nolint:gosec
+		return errors.Wrap(err, "cannot write history")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "cannot write history")
+	}
+	return errors.Wrap(os.Rename(f.Name(), h.file), "cannot replace history file")
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func newTestHistory(t *testing.T, ho ...HistoryOption) *History {
+	h, err := NewHistory(ho...)
+	if err != nil {
+		t.Fatalf("NewHistory(): %v", err)
+	}
+	r := NewAuditingEventRecorder(record.NewFakeRecorder(10), h, "prod")
+	a := &core.ObjectReference{Kind: "Node", Name: "a"}
+	b := &core.ObjectReference{Kind: "Node", Name: "b"}
+	r.Event(a, core.EventTypeWarning, eventReasonCordonSucceeded, "Cordoned node")
+	r.Eventf(a, core.EventTypeWarning, eventReasonDrainFailed, "Draining failed: %v", "boom")
+	r.Event(b, core.EventTypeWarning, eventReasonDrainSucceeded, "Drained node")
+	r.Event(&core.Pod{ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: podName}}, core.EventTypeNormal, eventReasonPodEvicted, "Evicted pod")
+	r.Event(a, core.EventTypeNormal, "SomethingElse", "Not an action")
+	return h
+}
+
+func TestHistory(t *testing.T) {
+	h := newTestHistory(t)
+
+	cases := []struct {
+		name string
+		f    HistoryFilter
+		want []string
+	}{
+		{name: "All", want: []string{"a cordon succeeded", "a drain failed", "b drain succeeded"}},
+		{name: "Node", f: HistoryFilter{Node: "a"}, want: []string{"a cordon succeeded", "a drain failed"}},
+		{name: "Outcome", f: HistoryFilter{Outcome: tagResultSucceeded}, want: []string{"a cordon succeeded", "b drain succeeded"}},
+		{name: "Action", f: HistoryFilter{Action: historyActionDrain}, want: []string{"a drain failed", "b drain succeeded"}},
+		{name: "NodeAndOutcome", f: HistoryFilter{Node: "a", Outcome: tagResultFailed}, want: []string{"a drain failed"}},
+		{name: "OtherCluster", f: HistoryFilter{Cluster: "dev"}, want: []string{}},
+		{name: "Since", f: HistoryFilter{Since: time.Now().Add(time.Minute)}, want: []string{}},
+		{name: "Limit", f: HistoryFilter{Limit: 2}, want: []string{"a drain failed", "b drain succeeded"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := []string{}
+			for _, r := range h.Records(tc.f) {
+				got = append(got, r.Name+" "+r.Action+" "+r.Outcome)
+			}
+			if diff := deep.Equal(tc.want, got); diff != nil {
+				t.Errorf("h.Records(%+v): want != got: %v", tc.f, diff)
+			}
+		})
+	}
+}
+
+func TestHistoryBounds(t *testing.T) {
+	h := newTestHistory(t, WithHistorySize(2))
+	if got := len(h.Records(HistoryFilter{})); got != 2 {
+		t.Errorf("len(h.Records()): want 2, got %d", got)
+	}
+
+	h = newTestHistory(t, WithHistoryRetention(time.Hour))
+	h.Record(AuditRecord{Time: time.Now().Add(-2 * time.Hour), Kind: "Node", Name: "c", Reason: eventReasonDrainSucceeded})
+	for _, r := range h.Records(HistoryFilter{}) {
+		if r.Name == "c" {
+			t.Errorf("h.Records(): want no records older than the retention period, got %+v", r)
+		}
+	}
+}
+
+func TestHistoryServeHTTP(t *testing.T) {
+	h := newTestHistory(t)
+
+	cases := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantNodes  []string
+	}{
+		{name: "All", wantStatus: http.StatusOK, wantNodes: []string{"a", "a", "b"}},
+		{name: "Filtered", query: "?node=a&outcome=failed", wantStatus: http.StatusOK, wantNodes: []string{"a"}},
+		{name: "SinceDuration", query: "?since=1h&limit=1", wantStatus: http.StatusOK, wantNodes: []string{"b"}},
+		{name: "SinceTime", query: "?since=2018-06-01T00:00:00Z", wantStatus: http.StatusOK, wantNodes: []string{"a", "a", "b"}},
+		{name: "InvalidSince", query: "?since=yesterday", wantStatus: http.StatusBadRequest},
+		{name: "InvalidLimit", query: "?limit=-1", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/history"+tc.query, nil))
+			if w.Code != tc.wantStatus {
+				t.Fatalf("h.ServeHTTP(%q): want status %d, got %d", tc.query, tc.wantStatus, w.Code)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+			records := []HistoryRecord{}
+			if err := json.NewDecoder(w.Body).Decode(&records); err != nil {
+				t.Fatalf("cannot decode response: %v", err)
+			}
+			got := []string{}
+			for _, r := range records {
+				got = append(got, r.Name)
+			}
+			if diff := deep.Equal(tc.wantNodes, got); diff != nil {
+				t.Errorf("h.ServeHTTP(%q): want != got: %v", tc.query, diff)
+			}
+		})
+	}
+}
+
+func TestHistoryPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "draino-history")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(): %v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "history.jsonl")
+
+	h := newTestHistory(t, WithHistoryFile(file))
+	stop := make(chan struct{})
+	close(stop)
+	h.Run(stop)
+
+	loaded, err := NewHistory(WithHistoryFile(file))
+	if err != nil {
+		t.Fatalf("NewHistory(): %v", err)
+	}
+	if diff := deep.Equal(h.Records(HistoryFilter{}), loaded.Records(HistoryFilter{})); diff != nil {
+		t.Errorf("loaded records: want != got: %v", diff)
+	}
+}
@@ -0,0 +1,394 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	batch "k8s.io/api/batch/v1"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Default hook settings.
+const (
+	DefaultHookTimeout = 5 * time.Minute
+
+	jobHookPollInterval        = 5 * time.Second
+	nodeDeleteHookPollInterval = 5 * time.Second
+)
+
+// Hook failure policies.
+const (
+	// HookFailurePolicyFail fails the drain if a hook fails.
+	HookFailurePolicyFail = "fail"
+
+	// HookFailurePolicyIgnore logs hook failures and carries on draining.
+	HookFailurePolicyIgnore = "ignore"
+)
+
+// IgnoreHookFailures returns a function that calls the supplied hook function,
+// logging rather than returning any error it returns.
+func IgnoreHookFailures(l *zap.Logger, fn func(n *core.Node) error) func(n *core.Node) error {
+	return func(n *core.Node) error {
+		if err := fn(n); err != nil {
+			l.Info("Ignoring failed hook", zap.String("node", n.GetName()), zap.Error(err))
+		}
+		return nil
+	}
+}
+
+// WhenConditions returns a function that calls the supplied hook function only
+// for nodes with any of the supplied node conditions true.
+func WhenConditions(conditions []string, fn func(n *core.Node) error) func(n *core.Node) error {
+	return func(n *core.Node) error {
+		for _, c := range n.Status.Conditions {
+			if c.Status != core.ConditionTrue {
+				continue
+			}
+			for _, want := range conditions {
+				if string(c.Type) == want {
+					return fn(n)
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// An HTTPHook POSTs a JSON description of a node to an HTTP endpoint. The
+// endpoint is a URL template, for example http://{{.InternalIP}}:8080/drain,
+// rendered with the node's EndpointTemplateData, which is also the body of
+// the request. The hook fails unless the endpoint returns a 2xx status code.
+type HTTPHook struct {
+	endpoint *template.Template
+	client   *http.Client
+}
+
+// NewHTTPHook returns a hook that POSTs to the supplied endpoint template,
+// waiting up to the supplied timeout for a response.
+func NewHTTPHook(endpoint string, timeout time.Duration) (*HTTPHook, error) {
+	t, err := template.New("endpoint").Option("missingkey=error").Parse(endpoint)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot parse hook endpoint template %s", endpoint)
+	}
+	return &HTTPHook{endpoint: t, client: &http.Client{Timeout: timeout}}, nil
+}
+
+// Run the hook against the supplied node.
+func (h *HTTPHook) Run(n *core.Node) error {
+	data := newEndpointTemplateData(n)
+	u := &bytes.Buffer{}
+	if err := h.endpoint.Execute(u, data); err != nil {
+		return errors.Wrap(err, "cannot render hook endpoint template")
+	}
+	body, err := json.Marshal(data)
+	if err != nil {
+		return errors.Wrap(err, "cannot encode hook request")
+	}
+	rsp, err := h.client.Post(u.String(), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrapf(err, "cannot call hook %s", u)
+	}
+	rsp.Body.Close() // nolint:gosec
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		return errors.Errorf("hook %s returned %s", u, rsp.Status)
+	}
+	return nil
+}
+
+// A JobHook runs a Kubernetes Job on a node and waits for it to complete. The
+// Job is a YAML template rendered with the node's EndpointTemplateData. Its pod
+// is bound to the node, and it is named after its template with a random
+// suffix. The hook fails if the Job fails or does not complete in time. The
+// Job and its pods are deleted once the hook finishes.
+type JobHook struct {
+	c       kubernetes.Interface
+	keys    Keys
+	job     *template.Template
+	timeout time.Duration
+	poll    time.Duration
+}
+
+// NewJobHook returns a hook that runs the supplied Job template on each node,
+// waiting up to the supplied timeout for it to complete. Jobs are labelled
+// with the name of their node using the supplied keys.
+func NewJobHook(c kubernetes.Interface, k Keys, job string, timeout time.Duration) (*JobHook, error) {
+	t, err := template.New("job").Option("missingkey=error").Parse(job)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot parse hook Job template")
+	}
+	return &JobHook{c: c, keys: k, job: t, timeout: timeout, poll: jobHookPollInterval}, nil
+}
+
+// Run the hook against the supplied node.
+func (h *JobHook) Run(n *core.Node) error {
+	b := &bytes.Buffer{}
+	if err := h.job.Execute(b, newEndpointTemplateData(n)); err != nil {
+		return errors.Wrap(err, "cannot render hook Job template")
+	}
+	j := &batch.Job{}
+	if err := yaml.Unmarshal(b.Bytes(), j); err != nil {
+		return errors.Wrap(err, "cannot decode hook Job")
+	}
+	if j.GetNamespace() == "" {
+		j.SetNamespace(meta.NamespaceDefault)
+	}
+	j.SetGenerateName(j.GetName() + "-")
+	j.SetName("")
+	if j.Labels == nil {
+		j.Labels = make(map[string]string)
+	}
+	j.Labels[h.keys.HookNode] = n.GetName()
+	j.Spec.Template.Spec.NodeName = n.GetName()
+
+	j, err := h.c.BatchV1().Jobs(j.GetNamespace()).Create(j)
+	if err != nil {
+		return errors.Wrapf(err, "cannot create hook Job for node %s", n.GetName())
+	}
+	background := meta.DeletePropagationBackground
+	defer h.c.BatchV1().Jobs(j.GetNamespace()).Delete(j.GetName(), &meta.DeleteOptions{PropagationPolicy: &background}) // nolint:errcheck
+
+	err = wait.PollImmediate(h.poll, h.timeout, func() (bool, error) {
+		fresh, err := h.c.BatchV1().Jobs(j.GetNamespace()).Get(j.GetName(), meta.GetOptions{})
+		if err != nil {
+			return false, errors.Wrapf(err, "cannot get hook Job %s/%s", j.GetNamespace(), j.GetName())
+		}
+		for _, c := range fresh.Status.Conditions {
+			if c.Status != core.ConditionTrue {
+				continue
+			}
+			switch c.Type {
+			case batch.JobComplete:
+				return true, nil
+			case batch.JobFailed:
+				return false, errors.Errorf("hook Job %s/%s failed: %s", j.GetNamespace(), j.GetName(), c.Message)
+			}
+		}
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return errors.Wrapf(errTimeout{}, "timed out waiting for hook Job %s/%s to complete", j.GetNamespace(), j.GetName())
+	}
+	return err
+}
+
+// A NodeAnnotationHook annotates a node.
+type NodeAnnotationHook struct {
+	c     kubernetes.Interface
+	key   string
+	value string
+}
+
+// NewNodeAnnotationHook returns a hook that sets the supplied annotation on
+// each node.
+func NewNodeAnnotationHook(c kubernetes.Interface, key, value string) *NodeAnnotationHook {
+	return &NodeAnnotationHook{c: c, key: key, value: value}
+}
+
+// Run the hook against the supplied node.
+func (h *NodeAnnotationHook) Run(n *core.Node) error {
+	fresh, err := h.c.CoreV1().Nodes().Get(n.GetName(), meta.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "cannot get node %s", n.GetName())
+	}
+	if fresh.Annotations == nil {
+		fresh.Annotations = make(map[string]string)
+	}
+	fresh.Annotations[h.key] = h.value
+	_, err = h.c.CoreV1().Nodes().Update(fresh)
+	return errors.Wrapf(err, "cannot annotate node %s", n.GetName())
+}
+
+// A NodeTaintHook taints a node.
+type NodeTaintHook struct {
+	c     kubernetes.Interface
+	taint core.Taint
+}
+
+// NewNodeTaintHook returns a hook that adds the supplied taint to each node,
+// replacing any taint with the same key and effect.
+func NewNodeTaintHook(c kubernetes.Interface, t core.Taint) *NodeTaintHook {
+	return &NodeTaintHook{c: c, taint: t}
+}
+
+// Run the hook against the supplied node.
+func (h *NodeTaintHook) Run(n *core.Node) error {
+	fresh, err := h.c.CoreV1().Nodes().Get(n.GetName(), meta.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "cannot get node %s", n.GetName())
+	}
+	taints := []core.Taint{h.taint}
+	for _, t := range fresh.Spec.Taints {
+		if t.Key == h.taint.Key && t.Effect == h.taint.Effect {
+			continue
+		}
+		taints = append(taints, t)
+	}
+	fresh.Spec.Taints = taints
+	_, err = h.c.CoreV1().Nodes().Update(fresh)
+	return errors.Wrapf(err, "cannot taint node %s", n.GetName())
+}
+
+// A NodeUncordonHook uncordons a node, removing the annotations draino added
+// when it cordoned the node.
+type NodeUncordonHook struct {
+	c    kubernetes.Interface
+	keys Keys
+}
+
+// NewNodeUncordonHook returns a hook that uncordons each node, removing the
+// annotations with the supplied keys that draino added when it cordoned it.
+func NewNodeUncordonHook(c kubernetes.Interface, k Keys) *NodeUncordonHook {
+	return &NodeUncordonHook{c: c, keys: k}
+}
+
+// Run the hook against the supplied node.
+func (h *NodeUncordonHook) Run(n *core.Node) error {
+	return uncordonNode(h.c, h.keys, n.GetName())
+}
+
+// ParseTaint parses a taint of the form KEY[=VALUE]:EFFECT.
+func ParseTaint(s string) (core.Taint, error) {
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return core.Taint{}, errors.Errorf("taint %q must be of the form KEY[=VALUE]:EFFECT", s)
+	}
+	t := core.Taint{Effect: core.TaintEffect(s[i+1:])}
+	switch t.Effect {
+	case core.TaintEffectNoSchedule, core.TaintEffectPreferNoSchedule, core.TaintEffectNoExecute:
+	default:
+		return core.Taint{}, errors.Errorf("taint %q has unsupported effect %q", s, t.Effect)
+	}
+	kv := strings.SplitN(s[:i], "=", 2)
+	t.Key = kv[0]
+	if len(kv) == 2 {
+		t.Value = kv[1]
+	}
+	if t.Key == "" {
+		return core.Taint{}, errors.Errorf("taint %q must have a key", s)
+	}
+	return t, nil
+}
+
+// A NodeDeleteHook deletes a node once all of its evictable pods and volume
+// attachments are gone. Mirror pods, DaemonSet pods, pods that have finished
+// running, and pods excluded by the hook's pod filter are not waited for.
+type NodeDeleteHook struct {
+	c       kubernetes.Interface
+	filter  PodFilterFunc
+	timeout time.Duration
+	poll    time.Duration
+}
+
+// NodeDeleteHookOption configures a NodeDeleteHook.
+type NodeDeleteHookOption func(h *NodeDeleteHook)
+
+// WithNodeDeletePodFilter configures a filter that excludes certain pods from
+// those that must be gone before a node is deleted. This should be the filter
+// used to exclude pods from eviction when draining, so that the hook does not
+// wait for pods that will never be evicted.
+func WithNodeDeletePodFilter(f PodFilterFunc) NodeDeleteHookOption {
+	return func(h *NodeDeleteHook) {
+		h.filter = f
+	}
+}
+
+// NewNodeDeleteHook returns a hook that deletes each node, waiting up to the
+// supplied timeout for its pods and volume attachments to be gone first.
+func NewNodeDeleteHook(c kubernetes.Interface, timeout time.Duration, ho ...NodeDeleteHookOption) *NodeDeleteHook {
+	h := &NodeDeleteHook{
+		c:       c,
+		filter:  NewPodFilters(),
+		timeout: timeout,
+		poll:    nodeDeleteHookPollInterval,
+	}
+	for _, o := range ho {
+		o(h)
+	}
+	return h
+}
+
+// Run the hook against the supplied node.
+func (h *NodeDeleteHook) Run(n *core.Node) error {
+	var remaining string
+	err := wait.PollImmediate(h.poll, h.timeout, func() (bool, error) {
+		l, err := listPods(h.c.CoreV1().Pods(meta.NamespaceAll).List, meta.ListOptions{
+			FieldSelector: fields.SelectorFromSet(fields.Set{"spec.nodeName": n.GetName()}).String(),
+		})
+		if err != nil {
+			return false, errors.Wrapf(err, "cannot get pods for node %s", n.GetName())
+		}
+		for _, p := range l.Items {
+			if !podRemains(p) {
+				continue
+			}
+			passes, err := h.filter(p)
+			if err != nil {
+				return false, errors.Wrapf(err, "cannot filter pods for node %s", n.GetName())
+			}
+			if !passes {
+				continue
+			}
+			remaining = "pod " + p.GetNamespace() + "/" + p.GetName()
+			return false, nil
+		}
+		va, err := h.c.StorageV1beta1().VolumeAttachments().List(meta.ListOptions{})
+		if err != nil {
+			return false, errors.Wrap(err, "cannot list volume attachments")
+		}
+		for _, a := range va.Items {
+			if a.Spec.NodeName != n.GetName() {
+				continue
+			}
+			remaining = "volume attachment " + a.GetName()
+			return false, nil
+		}
+		return true, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return errors.Wrapf(errTimeout{}, "timed out waiting for %s to be removed from node %s", remaining, n.GetName())
+	}
+	if err != nil {
+		return err
+	}
+	err = h.c.CoreV1().Nodes().Delete(n.GetName(), &meta.DeleteOptions{})
+	return errors.Wrapf(err, "cannot delete node %s", n.GetName())
+}
+
+// podRemains returns true if the supplied pod must be gone before its node can
+// be deleted.
+func podRemains(p core.Pod) bool {
+	if _, mirror := p.GetAnnotations()[core.MirrorPodAnnotationKey]; mirror {
+		return false
+	}
+	if c := meta.GetControllerOf(&p); c != nil && c.Kind == kindDaemonSet {
+		return false
+	}
+	return p.Status.Phase != core.PodSucceeded && p.Status.Phase != core.PodFailed
+}
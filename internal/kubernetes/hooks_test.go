@@ -0,0 +1,343 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	batch "k8s.io/api/batch/v1"
+	core "k8s.io/api/core/v1"
+	storage "k8s.io/api/storage/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestHTTPHook(t *testing.T) {
+	cases := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{name: "Succeeded", status: http.StatusOK},
+		{name: "Failed", status: http.StatusServiceUnavailable, wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got EndpointTemplateData
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/drain/"+nodeName {
+					t.Errorf("request path: want /drain/%s, got %s", nodeName, r.URL.Path)
+				}
+				if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+					t.Errorf("cannot decode request: %v", err)
+				}
+				w.WriteHeader(tc.status)
+			}))
+			defer srv.Close()
+
+			h, err := NewHTTPHook(srv.URL+"/drain/{{.Name}}", 1*time.Second)
+			if err != nil {
+				t.Fatalf("NewHTTPHook(): %v", err)
+			}
+			n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}
+			if err := h.Run(n); (err != nil) != tc.wantErr {
+				t.Errorf("h.Run(%v): want error %v, got %v", n.GetName(), tc.wantErr, err)
+			}
+			if got.Name != nodeName {
+				t.Errorf("request node name: want %s, got %s", nodeName, got.Name)
+			}
+		})
+	}
+}
+
+const hookJob = `
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: flush
+  namespace: kube-system
+spec:
+  template:
+    spec:
+      containers:
+      - name: flush
+        image: flush
+        args: ["{{.Name}}"]
+      restartPolicy: Never
+`
+
+func TestJobHook(t *testing.T) {
+	cases := []struct {
+		name      string
+		condition batch.JobConditionType
+		wantErr   bool
+		errFn     func(err error) bool
+	}{
+		{name: "Complete", condition: batch.JobComplete},
+		{name: "Failed", condition: batch.JobFailed, wantErr: true},
+		{name: "Timeout", wantErr: true, errFn: IsTimeout},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := fake.NewSimpleClientset()
+			var created *batch.Job
+			c.PrependReactor("create", "jobs", func(a clienttesting.Action) (bool, runtime.Object, error) {
+				j := a.(clienttesting.CreateAction).GetObject().(*batch.Job)
+				j.SetName(j.GetGenerateName() + "abcde")
+				if tc.condition != "" {
+					j.Status.Conditions = []batch.JobCondition{{Type: tc.condition, Status: core.ConditionTrue}}
+				}
+				created = j
+				return true, j, nil
+			})
+			c.PrependReactor("get", "jobs", func(a clienttesting.Action) (bool, runtime.Object, error) {
+				return true, created, nil
+			})
+			var deleted string
+			c.PrependReactor("delete", "jobs", func(a clienttesting.Action) (bool, runtime.Object, error) {
+				deleted = a.(clienttesting.DeleteAction).GetName()
+				return true, nil, nil
+			})
+
+			h, err := NewJobHook(c, DefaultKeys(), hookJob, 100*time.Millisecond)
+			if err != nil {
+				t.Fatalf("NewJobHook(): %v", err)
+			}
+			h.poll = 10 * time.Millisecond
+			n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}
+			err = h.Run(n)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("h.Run(%v): want error %v, got %v", n.GetName(), tc.wantErr, err)
+			}
+			if tc.errFn != nil && !tc.errFn(err) {
+				t.Errorf("h.Run(%v): unexpected error: %v", n.GetName(), err)
+			}
+
+			if created == nil {
+				t.Fatalf("h.Run(%v): no Job created", n.GetName())
+			}
+			if got := created.Spec.Template.Spec.NodeName; got != nodeName {
+				t.Errorf("Job node name: want %s, got %s", nodeName, got)
+			}
+			if got := created.Spec.Template.Spec.Containers[0].Args[0]; got != nodeName {
+				t.Errorf("Job args: want %s, got %s", nodeName, got)
+			}
+			if got := created.GetNamespace(); got != "kube-system" {
+				t.Errorf("Job namespace: want kube-system, got %s", got)
+			}
+			if deleted != created.GetName() {
+				t.Errorf("deleted Job: want %s, got %q", created.GetName(), deleted)
+			}
+		})
+	}
+}
+
+func TestNodeAnnotationHook(t *testing.T) {
+	n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}
+	c := fake.NewSimpleClientset(n)
+	if err := NewNodeAnnotationHook(c, "cool", "very").Run(n); err != nil {
+		t.Fatalf("h.Run(%v): %v", n.GetName(), err)
+	}
+	fresh, err := c.CoreV1().Nodes().Get(nodeName, meta.GetOptions{})
+	if err != nil {
+		t.Fatalf("c.CoreV1().Nodes().Get(%v): %v", nodeName, err)
+	}
+	if got := fresh.GetAnnotations()["cool"]; got != "very" {
+		t.Errorf("annotation: want very, got %q", got)
+	}
+}
+
+func TestNodeTaintHook(t *testing.T) {
+	n := &core.Node{
+		ObjectMeta: meta.ObjectMeta{Name: nodeName},
+		Spec: core.NodeSpec{Taints: []core.Taint{
+			{Key: "cool", Value: "old", Effect: core.TaintEffectNoSchedule},
+			{Key: "other", Effect: core.TaintEffectNoExecute},
+		}},
+	}
+	c := fake.NewSimpleClientset(n)
+	taint := core.Taint{Key: "cool", Value: "new", Effect: core.TaintEffectNoSchedule}
+	if err := NewNodeTaintHook(c, taint).Run(n); err != nil {
+		t.Fatalf("h.Run(%v): %v", n.GetName(), err)
+	}
+	fresh, err := c.CoreV1().Nodes().Get(nodeName, meta.GetOptions{})
+	if err != nil {
+		t.Fatalf("c.CoreV1().Nodes().Get(%v): %v", nodeName, err)
+	}
+	want := []core.Taint{taint, {Key: "other", Effect: core.TaintEffectNoExecute}}
+	if diff := deep.Equal(want, fresh.Spec.Taints); diff != nil {
+		t.Errorf("taints: want != got: %v", diff)
+	}
+}
+
+func TestNodeUncordonHook(t *testing.T) {
+	n := &core.Node{
+		ObjectMeta: meta.ObjectMeta{Name: nodeName, Annotations: map[string]string{AnnotationCordoned: "2018-01-01T00:00:00Z"}},
+		Spec:       core.NodeSpec{Unschedulable: true},
+	}
+	c := fake.NewSimpleClientset(n)
+	if err := NewNodeUncordonHook(c, DefaultKeys()).Run(n); err != nil {
+		t.Fatalf("h.Run(%v): %v", n.GetName(), err)
+	}
+	fresh, err := c.CoreV1().Nodes().Get(nodeName, meta.GetOptions{})
+	if err != nil {
+		t.Fatalf("c.CoreV1().Nodes().Get(%v): %v", nodeName, err)
+	}
+	if fresh.Spec.Unschedulable {
+		t.Errorf("node still cordoned")
+	}
+	if _, ok := fresh.GetAnnotations()[AnnotationCordoned]; ok {
+		t.Errorf("node still annotated with %s", AnnotationCordoned)
+	}
+}
+
+func TestParseTaint(t *testing.T) {
+	cases := []struct {
+		name    string
+		s       string
+		want    core.Taint
+		wantErr bool
+	}{
+		{name: "KeyValueEffect", s: "cool=very:NoSchedule", want: core.Taint{Key: "cool", Value: "very", Effect: core.TaintEffectNoSchedule}},
+		{name: "KeyEffect", s: "cool:NoExecute", want: core.Taint{Key: "cool", Effect: core.TaintEffectNoExecute}},
+		{name: "NoEffect", s: "cool=very", wantErr: true},
+		{name: "UnknownEffect", s: "cool=very:Never", wantErr: true},
+		{name: "NoKey", s: "=very:NoSchedule", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseTaint(tc.s)
+			if err != nil {
+				if tc.wantErr {
+					return
+				}
+				t.Fatalf("ParseTaint(%v): %v", tc.s, err)
+			}
+			if tc.wantErr {
+				t.Fatalf("ParseTaint(%v): want error", tc.s)
+			}
+			if diff := deep.Equal(tc.want, got); diff != nil {
+				t.Errorf("ParseTaint(%v): want != got: %v", tc.s, diff)
+			}
+		})
+	}
+}
+
+func TestWhenConditions(t *testing.T) {
+	cases := []struct {
+		name       string
+		conditions []core.NodeCondition
+		wantCalled bool
+	}{
+		{
+			name:       "ConditionTrue",
+			conditions: []core.NodeCondition{{Type: "KernelDeadlock", Status: core.ConditionTrue}},
+			wantCalled: true,
+		},
+		{
+			name:       "ConditionFalse",
+			conditions: []core.NodeCondition{{Type: "KernelDeadlock", Status: core.ConditionFalse}},
+		},
+		{
+			name:       "OtherCondition",
+			conditions: []core.NodeCondition{{Type: "DiskPressure", Status: core.ConditionTrue}},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			called := false
+			fn := WhenConditions([]string{"KernelDeadlock"}, func(_ *core.Node) error { called = true; return nil })
+			n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}, Status: core.NodeStatus{Conditions: tc.conditions}}
+			if err := fn(n); err != nil {
+				t.Fatalf("fn(%v): %v", n.GetName(), err)
+			}
+			if called != tc.wantCalled {
+				t.Errorf("hook called: want %v, got %v", tc.wantCalled, called)
+			}
+		})
+	}
+}
+
+func TestNodeDeleteHook(t *testing.T) {
+	dsPod := &core.Pod{ObjectMeta: meta.ObjectMeta{
+		Name:            "ds",
+		Namespace:       "kube-system",
+		OwnerReferences: []meta.OwnerReference{{Controller: &isController, Kind: kindDaemonSet}},
+	}}
+	cases := []struct {
+		name        string
+		objects     []runtime.Object
+		filter      PodFilterFunc
+		wantDeleted bool
+	}{
+		{
+			name:        "OnlyDaemonSetPods",
+			objects:     []runtime.Object{dsPod, &core.Pod{ObjectMeta: meta.ObjectMeta{Name: "done"}, Status: core.PodStatus{Phase: core.PodSucceeded}}},
+			wantDeleted: true,
+		},
+		{
+			name:    "PodRemains",
+			objects: []runtime.Object{dsPod, &core.Pod{ObjectMeta: meta.ObjectMeta{Name: podName}}},
+		},
+		{
+			name:        "FilteredPodRemains",
+			objects:     []runtime.Object{dsPod, &core.Pod{ObjectMeta: meta.ObjectMeta{Name: podName}}},
+			filter:      func(_ core.Pod) (bool, error) { return false, nil },
+			wantDeleted: true,
+		},
+		{
+			name:    "VolumeAttachmentRemains",
+			objects: []runtime.Object{&storage.VolumeAttachment{ObjectMeta: meta.ObjectMeta{Name: "va"}, Spec: storage.VolumeAttachmentSpec{NodeName: nodeName}}},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}
+			c := fake.NewSimpleClientset(append(tc.objects, n)...)
+			ho := []NodeDeleteHookOption{}
+			if tc.filter != nil {
+				ho = append(ho, WithNodeDeletePodFilter(tc.filter))
+			}
+			h := NewNodeDeleteHook(c, 50*time.Millisecond, ho...)
+			h.poll = 10 * time.Millisecond
+			err := h.Run(n)
+			if tc.wantDeleted && err != nil {
+				t.Fatalf("h.Run(%v): %v", n.GetName(), err)
+			}
+			if !tc.wantDeleted && !IsTimeout(err) {
+				t.Fatalf("h.Run(%v): want timeout, got %v", n.GetName(), err)
+			}
+			_, err = c.CoreV1().Nodes().Get(nodeName, meta.GetOptions{})
+			if deleted := apierrors.IsNotFound(err); deleted != tc.wantDeleted {
+				t.Errorf("node deleted: want %v, got %v", tc.wantDeleted, deleted)
+			}
+		})
+	}
+}
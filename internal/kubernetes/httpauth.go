@@ -0,0 +1,72 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"github.com/pkg/errors"
+	authentication "k8s.io/api/authentication/v1"
+	authorization "k8s.io/api/authorization/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// An APIAuthorizer authorizes requests to draino's HTTP endpoints by
+// authenticating their bearer tokens using a TokenReview, then checking the
+// authenticated user may access the requested path using a
+// SubjectAccessReview, in the same way the Kubernetes API server authorizes
+// requests for non-resource URLs such as /healthz.
+type APIAuthorizer struct {
+	c kubernetes.Interface
+}
+
+// NewAPIAuthorizer returns an APIAuthorizer that reviews tokens and access
+// using the supplied client.
+func NewAPIAuthorizer(c kubernetes.Interface) *APIAuthorizer {
+	return &APIAuthorizer{c: c}
+}
+
+// Authorize returns true if the supplied bearer token belongs to a user that
+// may perform the supplied verb, e.g. "put", on the supplied path.
+func (a *APIAuthorizer) Authorize(token, verb, path string) (bool, error) {
+	tr, err := a.c.AuthenticationV1().TokenReviews().Create(&authentication.TokenReview{
+		Spec: authentication.TokenReviewSpec{Token: token},
+	})
+	if err != nil {
+		return false, errors.Wrap(err, "cannot review token")
+	}
+	if !tr.Status.Authenticated {
+		return false, nil
+	}
+
+	u := tr.Status.User
+	extra := make(map[string]authorization.ExtraValue, len(u.Extra))
+	for k, v := range u.Extra {
+		extra[k] = authorization.ExtraValue(v)
+	}
+	sar, err := a.c.AuthorizationV1().SubjectAccessReviews().Create(&authorization.SubjectAccessReview{
+		Spec: authorization.SubjectAccessReviewSpec{
+			User:                  u.Username,
+			UID:                   u.UID,
+			Groups:                u.Groups,
+			Extra:                 extra,
+			NonResourceAttributes: &authorization.NonResourceAttributes{Verb: verb, Path: path},
+		},
+	})
+	if err != nil {
+		return false, errors.Wrapf(err, "cannot review access of user %s", u.Username)
+	}
+	return sar.Status.Allowed, nil
+}
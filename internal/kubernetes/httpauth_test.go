@@ -0,0 +1,99 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	authentication "k8s.io/api/authentication/v1"
+	authorization "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestAPIAuthorizer(t *testing.T) {
+	cases := []struct {
+		name          string
+		token         string
+		allowed       map[string]bool
+		reviewErr     error
+		wantAuthorize bool
+		wantErr       bool
+	}{
+		{
+			name:          "Allowed",
+			token:         "operator",
+			allowed:       map[string]bool{"operator": true},
+			wantAuthorize: true,
+		},
+		{
+			name:    "Forbidden",
+			token:   "viewer",
+			allowed: map[string]bool{"operator": true},
+		},
+		{
+			name:    "Unauthenticated",
+			token:   "invalid",
+			allowed: map[string]bool{"operator": true},
+		},
+		{
+			name:      "ReviewFailed",
+			token:     "operator",
+			reviewErr: errors.New("boom"),
+			wantErr:   true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := fake.NewSimpleClientset()
+			c.PrependReactor("create", "tokenreviews", func(a clienttesting.Action) (bool, runtime.Object, error) {
+				tr := a.(clienttesting.CreateAction).GetObject().(*authentication.TokenReview)
+				if tc.reviewErr != nil {
+					return true, tr, tc.reviewErr
+				}
+				if tr.Spec.Token != "invalid" {
+					tr.Status.Authenticated = true
+					tr.Status.User = authentication.UserInfo{Username: tr.Spec.Token}
+				}
+				return true, tr, nil
+			})
+			c.PrependReactor("create", "subjectaccessreviews", func(a clienttesting.Action) (bool, runtime.Object, error) {
+				sar := a.(clienttesting.CreateAction).GetObject().(*authorization.SubjectAccessReview)
+				attr := sar.Spec.NonResourceAttributes
+				sar.Status.Allowed = tc.allowed[sar.Spec.User] && attr.Verb == "put" && attr.Path == "/loglevel"
+				return true, sar, nil
+			})
+
+			ok, err := NewAPIAuthorizer(c).Authorize(tc.token, "put", "/loglevel")
+			if err != nil {
+				if tc.wantErr {
+					return
+				}
+				t.Fatalf("a.Authorize(): %v", err)
+			}
+			if tc.wantErr {
+				t.Fatalf("a.Authorize(): want error, got nil")
+			}
+			if ok != tc.wantAuthorize {
+				t.Errorf("a.Authorize(): want %v, got %v", tc.wantAuthorize, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+// DefaultKeyPrefix is the default prefix of the keys of the annotations and
+// labels draino reads and writes.
+const DefaultKeyPrefix = "draino.planet.com/"
+
+// Annotation, label, and finalizer keys, prefixed with DefaultKeyPrefix. These
+// are the keys of the default Keys.
+const (
+	// AnnotationDrainInProgress marks nodes that draino has cordoned but not
+	// yet finished draining. Its value is the time at which the node was
+	// marked.
+	AnnotationDrainInProgress = DefaultKeyPrefix + keyDrainInProgress
+
+	// AnnotationCordoned marks nodes that draino cordoned. Its value is the
+	// time at which the node was cordoned.
+	AnnotationCordoned = DefaultKeyPrefix + keyCordoned
+
+	// AnnotationDrainFailed marks nodes that draino cordoned but failed to
+	// drain. Its value is the time at which the drain failed.
+	AnnotationDrainFailed = DefaultKeyPrefix + keyDrainFailed
+
+	// AnnotationDrainProgress records how far a running drain has progressed,
+	// as a JSON DrainProgress. It is removed once the drain finishes.
+	AnnotationDrainProgress = DefaultKeyPrefix + keyDrainProgress
+
+	// AnnotationBootID and AnnotationMachineID record the boot and machine IDs
+	// a node reported when draino cordoned it, so that draino can tell when
+	// the node has since been rebooted or reimaged.
+	AnnotationBootID    = DefaultKeyPrefix + keyBootID
+	AnnotationMachineID = DefaultKeyPrefix + keyMachineID
+
+	// AnnotationCordonReason records why draino cordoned a node, as a comma
+	// separated list of the node conditions or labels that caused it to do so.
+	AnnotationCordonReason = DefaultKeyPrefix + keyCordonReason
+
+	// AnnotationForceDrain may be set to "true" on a node to allow it to be
+	// drained even though it is running more pods than MaxPodsToEvict allows.
+	AnnotationForceDrain = DefaultKeyPrefix + keyForceDrain
+
+	// AnnotationDrainBuffer may be set on a node, as an annotation or label, to
+	// override the minimum time between starting any drain and starting a
+	// drain of that node, e.g. "30m".
+	AnnotationDrainBuffer = DefaultKeyPrefix + keyDrainBuffer
+
+	// AnnotationMaxGracePeriod and AnnotationEvictionHeadroom may be set on
+	// a node, as annotations or labels, to override the maximum grace period
+	// of and eviction headroom of the pods evicted from that node, e.g. "1h".
+	AnnotationMaxGracePeriod   = DefaultKeyPrefix + keyMaxGracePeriod
+	AnnotationEvictionHeadroom = DefaultKeyPrefix + keyEvictionHeadroom
+
+	// AnnotationGracePeriod may be set on a pod to override the grace period
+	// with which it is evicted, e.g. "2m", instead of its termination grace
+	// period. It is bounded by the maximum grace period.
+	AnnotationGracePeriod = DefaultKeyPrefix + keyGracePeriod
+
+	// AnnotationSurge marks Deployments that draino scaled up before draining
+	// nodes running their pods. Its value is a JSON object of the number of
+	// replicas added for each node, e.g. {"node-a":2}.
+	AnnotationSurge = DefaultKeyPrefix + keySurge
+
+	// AnnotationSurgeReplicas records the replicas a Deployment had before
+	// draino first surged it. Draino never restores a surged Deployment to
+	// fewer replicas.
+	AnnotationSurgeReplicas = DefaultKeyPrefix + keySurgeReplicas
+
+	// LabelSurged is added to each Deployment draino has surged, for as long
+	// as it remains surged.
+	LabelSurged = DefaultKeyPrefix + keySurged
+
+	// LabelHookNode is added to each Job created by a JobHook, and each canary
+	// pod created by an Uncordoner. Its value is the name of the node the Job
+	// or pod acts on.
+	LabelHookNode = DefaultKeyPrefix + keyHookNode
+
+	// NodeMaintenanceFinalizer is the finalizer draino adds to
+	// NodeMaintenances, so that it may uncordon their node before they are
+	// removed.
+	NodeMaintenanceFinalizer = DefaultKeyPrefix + keyNodeMaintenance
+
+	// RemediationFinalizer is the finalizer draino adds to remediations, so
+	// that it may uncordon their node before they are removed.
+	RemediationFinalizer = DefaultKeyPrefix + keyRemediation
+
+	// DefaultRebootAnnotation is set on drained nodes that need rebooting, for
+	// consumption by reboot automation.
+	DefaultRebootAnnotation = DefaultKeyPrefix + keyRebootRequired
+)
+
+// Unprefixed keys.
+const (
+	keyDrainInProgress  = "drain-in-progress"
+	keyCordoned         = "cordoned"
+	keyDrainFailed      = "drain-failed"
+	keyDrainProgress    = "drain-progress"
+	keyBootID           = "boot-id"
+	keyMachineID        = "machine-id"
+	keyCordonReason     = "cordon-reason"
+	keyForceDrain       = "force-drain"
+	keyDrainBuffer      = "drain-buffer"
+	keyMaxGracePeriod   = "max-grace-period"
+	keyEvictionHeadroom = "eviction-headroom"
+	keyGracePeriod      = "grace-period"
+	keySurge            = "surge"
+	keySurgeReplicas    = "surge-replicas"
+	keySurged           = "surged"
+	keyHookNode         = "node"
+	keyNodeMaintenance  = "node-maintenance"
+	keyRemediation      = "remediation"
+	keyRebootRequired   = "reboot-required=true"
+)
+
+// Keys are the keys of the annotations, labels, and finalizers draino reads
+// and writes. Each is described by the constant holding its default value,
+// e.g. DrainInProgress by AnnotationDrainInProgress.
+type Keys struct {
+	DrainInProgress          string
+	Cordoned                 string
+	DrainFailed              string
+	DrainProgress            string
+	BootID                   string
+	MachineID                string
+	CordonReason             string
+	ForceDrain               string
+	DrainBuffer              string
+	MaxGracePeriod           string
+	EvictionHeadroom         string
+	GracePeriod              string
+	Surge                    string
+	SurgeReplicas            string
+	Surged                   string
+	HookNode                 string
+	NodeMaintenanceFinalizer string
+	RemediationFinalizer     string
+	RebootAnnotation         string
+}
+
+// NewKeys returns keys with the supplied prefix, for example so that several
+// draino deployments may manage the same nodes without colliding.
+func NewKeys(prefix string) Keys {
+	return Keys{
+		DrainInProgress:          prefix + keyDrainInProgress,
+		Cordoned:                 prefix + keyCordoned,
+		DrainFailed:              prefix + keyDrainFailed,
+		DrainProgress:            prefix + keyDrainProgress,
+		BootID:                   prefix + keyBootID,
+		MachineID:                prefix + keyMachineID,
+		CordonReason:             prefix + keyCordonReason,
+		ForceDrain:               prefix + keyForceDrain,
+		DrainBuffer:              prefix + keyDrainBuffer,
+		MaxGracePeriod:           prefix + keyMaxGracePeriod,
+		EvictionHeadroom:         prefix + keyEvictionHeadroom,
+		GracePeriod:              prefix + keyGracePeriod,
+		Surge:                    prefix + keySurge,
+		SurgeReplicas:            prefix + keySurgeReplicas,
+		Surged:                   prefix + keySurged,
+		HookNode:                 prefix + keyHookNode,
+		NodeMaintenanceFinalizer: prefix + keyNodeMaintenance,
+		RemediationFinalizer:     prefix + keyRemediation,
+		RebootAnnotation:         prefix + keyRebootRequired,
+	}
+}
+
+// DefaultKeys returns keys with the DefaultKeyPrefix.
+func DefaultKeys() Keys {
+	return NewKeys(DefaultKeyPrefix)
+}
+
+// cordonedByDraino returns true if the supplied node is cordoned and is
+// annotated as having been cordoned by draino.
+func (k Keys) cordonedByDraino(n *core.Node) bool {
+	if !n.Spec.Unschedulable {
+		return false
+	}
+	_, ok := n.GetAnnotations()[k.Cordoned]
+	return ok
+}
+
+// NewPrefixedEventRecorder returns an EventRecorder that records events using
+// the supplied EventRecorder, prefixing each event's reason with the supplied
+// prefix.
+func NewPrefixedEventRecorder(r record.EventRecorder, prefix string) record.EventRecorder {
+	if prefix == "" {
+		return r
+	}
+	return &prefixedEventRecorder{r: r, prefix: prefix}
+}
+
+type prefixedEventRecorder struct {
+	r      record.EventRecorder
+	prefix string
+}
+
+func (p *prefixedEventRecorder) Event(o runtime.Object, eventtype, reason, message string) {
+	p.r.Event(o, eventtype, p.prefix+reason, message)
+}
+
+func (p *prefixedEventRecorder) Eventf(o runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	p.r.Eventf(o, eventtype, p.prefix+reason, messageFmt, args...)
+}
+
+func (p *prefixedEventRecorder) PastEventf(o runtime.Object, timestamp meta.Time, eventtype, reason, messageFmt string, args ...interface{}) {
+	p.r.PastEventf(o, timestamp, eventtype, p.prefix+reason, messageFmt, args...)
+}
+
+func (p *prefixedEventRecorder) AnnotatedEventf(o runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	p.r.AnnotatedEventf(o, annotations, eventtype, p.prefix+reason, messageFmt, args...)
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestNewKeys(t *testing.T) {
+	k := NewKeys("example.org/")
+	cases := map[string]string{
+		k.DrainInProgress:          "example.org/drain-in-progress",
+		k.ForceDrain:               "example.org/force-drain",
+		k.HookNode:                 "example.org/node",
+		k.NodeMaintenanceFinalizer: "example.org/node-maintenance",
+		k.RemediationFinalizer:     "example.org/remediation",
+		k.RebootAnnotation:         "example.org/reboot-required=true",
+	}
+	for got, want := range cases {
+		if got != want {
+			t.Errorf("NewKeys(): want %s, got %s", want, got)
+		}
+	}
+}
+
+func TestDefaultKeys(t *testing.T) {
+	k := DefaultKeys()
+	cases := map[string]string{
+		k.DrainInProgress:          AnnotationDrainInProgress,
+		k.Cordoned:                 AnnotationCordoned,
+		k.DrainFailed:              AnnotationDrainFailed,
+		k.DrainProgress:            AnnotationDrainProgress,
+		k.BootID:                   AnnotationBootID,
+		k.MachineID:                AnnotationMachineID,
+		k.CordonReason:             AnnotationCordonReason,
+		k.ForceDrain:               AnnotationForceDrain,
+		k.DrainBuffer:              AnnotationDrainBuffer,
+		k.MaxGracePeriod:           AnnotationMaxGracePeriod,
+		k.EvictionHeadroom:         AnnotationEvictionHeadroom,
+		k.GracePeriod:              AnnotationGracePeriod,
+		k.Surge:                    AnnotationSurge,
+		k.SurgeReplicas:            AnnotationSurgeReplicas,
+		k.Surged:                   LabelSurged,
+		k.HookNode:                 LabelHookNode,
+		k.NodeMaintenanceFinalizer: NodeMaintenanceFinalizer,
+		k.RemediationFinalizer:     RemediationFinalizer,
+		k.RebootAnnotation:         DefaultRebootAnnotation,
+	}
+	for got, want := range cases {
+		if got != want {
+			t.Errorf("DefaultKeys(): want %s, got %s", want, got)
+		}
+	}
+}
+
+func TestPrefixedEventRecorder(t *testing.T) {
+	cases := []struct {
+		name   string
+		prefix string
+		want   string
+	}{
+		{name: "Prefixed", prefix: "Draino", want: "Warning DrainoCordonStarting Cordoning node"},
+		{name: "NoPrefix", want: "Warning CordonStarting Cordoning node"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := record.NewFakeRecorder(1)
+			NewPrefixedEventRecorder(r, tc.prefix).Event(&core.ObjectReference{}, core.EventTypeWarning, eventReasonCordonStarting, "Cordoning node")
+			if got := <-r.Events; got != tc.want {
+				t.Errorf("event: want %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
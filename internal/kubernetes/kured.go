@@ -0,0 +1,149 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DefaultKuredLockAnnotation is the annotation kured uses to store its lock.
+const DefaultKuredLockAnnotation = "weave.works/kured-node-lock"
+
+// kuredLockOwnerPrefix distinguishes draino's lock holders from kured's, which
+// are node names. kured releases (and uncordons) a node if it finds the lock
+// held in that node's name when it starts.
+const kuredLockOwnerPrefix = "draino/"
+
+// kuredLock is the lock format used by kured. Locks with a TTL expire once it
+// has elapsed since they were created.
+type kuredLock struct {
+	NodeID   string          `json:"nodeID"`
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+	Created  time.Time       `json:"created"`
+	TTL      time.Duration   `json:"TTL"`
+}
+
+// A KuredLock is a DrainLock compatible with the lock kured uses to ensure it
+// reboots only one node at a time. The lock is an annotation on a DaemonSet,
+// typically kured's own. Only one of kured or draino may hold the lock, so
+// neither disrupts a node while the other is disrupting a different node.
+type KuredLock struct {
+	c          kubernetes.Interface
+	namespace  string
+	name       string
+	annotation string
+	ttl        time.Duration
+}
+
+// KuredLockOption configures a KuredLock.
+type KuredLockOption func(l *KuredLock)
+
+// WithKuredLockTTL configures a KuredLock to record the supplied TTL in the
+// locks it takes, and to reclaim locks held by draino that have no TTL of
+// their own once the supplied TTL has elapsed since they were taken, for
+// example because draino was shut down mid-drain. The TTL should be longer
+// than any drain, after which kured or another draino may take the lock.
+func WithKuredLockTTL(ttl time.Duration) KuredLockOption {
+	return func(l *KuredLock) {
+		l.ttl = ttl
+	}
+}
+
+// NewKuredLock returns a DrainLock stored in the supplied annotation of the
+// supplied DaemonSet. Locks whose TTL has elapsed are reclaimed, whether held
+// by kured or draino.
+func NewKuredLock(c kubernetes.Interface, namespace, name, annotation string, ko ...KuredLockOption) *KuredLock {
+	l := &KuredLock{c: c, namespace: namespace, name: name, annotation: annotation}
+	for _, o := range ko {
+		o(l)
+	}
+	return l
+}
+
+// expired returns true if the supplied lock's TTL has elapsed.
+func (l *KuredLock) expired(current *kuredLock, now time.Time) bool {
+	ttl := current.TTL
+	if ttl <= 0 && strings.HasPrefix(current.NodeID, kuredLockOwnerPrefix) {
+		ttl = l.ttl
+	}
+	return ttl > 0 && now.After(current.Created.Add(ttl))
+}
+
+// Acquire the lock in order to drain the supplied node.
+func (l *KuredLock) Acquire(n *core.Node) error {
+	ds, err := l.c.AppsV1().DaemonSets(l.namespace).Get(l.name, meta.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "cannot get lock DaemonSet %s/%s", l.namespace, l.name)
+	}
+	owner := kuredLockOwnerPrefix + n.GetName()
+	if v, ok := ds.GetAnnotations()[l.annotation]; ok {
+		current := &kuredLock{}
+		if err := json.Unmarshal([]byte(v), current); err != nil {
+			return errors.Wrapf(err, "cannot decode lock held by DaemonSet %s/%s", l.namespace, l.name)
+		}
+		if current.NodeID == owner {
+			return nil
+		}
+		if !l.expired(current, time.Now()) {
+			return errors.Errorf("lock is held by %s", current.NodeID)
+		}
+	}
+
+	v, err := json.Marshal(kuredLock{NodeID: owner, Metadata: json.RawMessage("{}"), Created: time.Now().UTC(), TTL: l.ttl})
+	if err != nil {
+		return errors.Wrap(err, "cannot encode lock")
+	}
+	if ds.Annotations == nil {
+		ds.Annotations = make(map[string]string)
+	}
+	ds.Annotations[l.annotation] = string(v)
+
+	// Updates fail if the DaemonSet changed since we read it, so we can't
+	// take a lock that was acquired or reclaimed concurrently.
+	_, err = l.c.AppsV1().DaemonSets(l.namespace).Update(ds)
+	return errors.Wrapf(err, "cannot acquire lock held by DaemonSet %s/%s", l.namespace, l.name)
+}
+
+// Release the lock held in order to drain the supplied node. Locks held by
+// anything else are left untouched.
+func (l *KuredLock) Release(n *core.Node) error {
+	ds, err := l.c.AppsV1().DaemonSets(l.namespace).Get(l.name, meta.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "cannot get lock DaemonSet %s/%s", l.namespace, l.name)
+	}
+	v, ok := ds.GetAnnotations()[l.annotation]
+	if !ok {
+		return nil
+	}
+	current := &kuredLock{}
+	if err := json.Unmarshal([]byte(v), current); err != nil {
+		return errors.Wrapf(err, "cannot decode lock held by DaemonSet %s/%s", l.namespace, l.name)
+	}
+	if current.NodeID != kuredLockOwnerPrefix+n.GetName() {
+		return nil
+	}
+	delete(ds.Annotations, l.annotation)
+	_, err = l.c.AppsV1().DaemonSets(l.namespace).Update(ds)
+	return errors.Wrapf(err, "cannot release lock held by DaemonSet %s/%s", l.namespace, l.name)
+}
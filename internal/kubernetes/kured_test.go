@@ -0,0 +1,157 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	kuredNamespace = "kube-system"
+	kuredName      = "kured"
+)
+
+func newKuredDaemonSet(holder string, ttl time.Duration) *apps.DaemonSet {
+	ds := &apps.DaemonSet{ObjectMeta: meta.ObjectMeta{Namespace: kuredNamespace, Name: kuredName}}
+	if holder != "" {
+		ds.Annotations = map[string]string{DefaultKuredLockAnnotation: fmt.Sprintf(`{"nodeID":%q,"metadata":{"unschedulable":false},"created":"2018-10-12T18:00:00Z","TTL":%d}`, holder, ttl)}
+	}
+	return ds
+}
+
+func TestKuredLock(t *testing.T) {
+	n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}
+	owner := kuredLockOwnerPrefix + nodeName
+
+	cases := []struct {
+		name        string
+		holder      string
+		holderTTL   time.Duration
+		ttl         time.Duration
+		release     bool
+		wantErr     bool
+		wantHolder  string
+		wantRelease string
+	}{
+		{
+			name:       "AcquireFreeLock",
+			wantHolder: owner,
+		},
+		{
+			name:       "AcquireLockHeldByKured",
+			holder:     "someNode",
+			wantErr:    true,
+			wantHolder: "someNode",
+		},
+		{
+			name:       "AcquireExpiredLockHeldByKured",
+			holder:     "someNode",
+			holderTTL:  time.Hour,
+			wantHolder: owner,
+		},
+		{
+			name:       "AcquireStaleLockHeldByDraino",
+			holder:     kuredLockOwnerPrefix + "otherNode",
+			ttl:        time.Hour,
+			wantHolder: owner,
+		},
+		{
+			name:       "AcquireLockHeldByKuredWithoutTTL",
+			holder:     "someNode",
+			ttl:        time.Hour,
+			wantErr:    true,
+			wantHolder: "someNode",
+		},
+		{
+			name:       "AcquireUnexpiredLock",
+			holder:     kuredLockOwnerPrefix + "otherNode",
+			holderTTL:  24 * 365 * 100 * time.Hour,
+			ttl:        time.Hour,
+			wantErr:    true,
+			wantHolder: kuredLockOwnerPrefix + "otherNode",
+		},
+		{
+			name:       "AcquireLockAlreadyHeld",
+			holder:     owner,
+			wantHolder: owner,
+		},
+		{
+			name:       "ReleaseHeldLock",
+			holder:     owner,
+			release:    true,
+			wantHolder: "",
+		},
+		{
+			name:       "ReleaseLockHeldByKured",
+			holder:     "someNode",
+			release:    true,
+			wantHolder: "someNode",
+		},
+		{
+			name:       "ReleaseFreeLock",
+			release:    true,
+			wantHolder: "",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := fake.NewSimpleClientset(newKuredDaemonSet(tc.holder, tc.holderTTL))
+			l := NewKuredLock(c, kuredNamespace, kuredName, DefaultKuredLockAnnotation, WithKuredLockTTL(tc.ttl))
+
+			var err error
+			if tc.release {
+				err = l.Release(n)
+			} else {
+				err = l.Acquire(n)
+			}
+			if err != nil && !tc.wantErr {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if err == nil && tc.wantErr {
+				t.Errorf("want error, got nil")
+			}
+
+			ds, err := c.AppsV1().DaemonSets(kuredNamespace).Get(kuredName, meta.GetOptions{})
+			if err != nil {
+				t.Fatalf("c.AppsV1().DaemonSets(...).Get(%v): %v", kuredName, err)
+			}
+			got := ""
+			if v, ok := ds.GetAnnotations()[DefaultKuredLockAnnotation]; ok {
+				lock := &kuredLock{}
+				if err := json.Unmarshal([]byte(v), lock); err != nil {
+					t.Fatalf("json.Unmarshal(%v): %v", v, err)
+				}
+				got = lock.NodeID
+				if got == owner && tc.holder != owner && lock.TTL != tc.ttl {
+					t.Errorf("lock TTL: want %v, got %v", tc.ttl, lock.TTL)
+				}
+			}
+			if got != tc.wantHolder {
+				t.Errorf("lock holder: want %q, got %q", tc.wantHolder, got)
+			}
+		})
+	}
+}
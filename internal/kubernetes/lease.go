@@ -0,0 +1,265 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// LeaseResource is the coordination Lease resource.
+var LeaseResource = schema.GroupVersionResource{
+	Group:    "coordination.k8s.io",
+	Version:  "v1",
+	Resource: "leases",
+}
+
+// DefaultLeaseDuration is the default duration of node leases. Leases are
+// renewed well before they expire for as long as they are held.
+const DefaultLeaseDuration = 5 * time.Minute
+
+// A NodeLeaseLock is a DrainLock held in a coordination Lease named after the
+// node, in a well known namespace. Any tooling that disrupts nodes may honour
+// the same Lease in order to serialize disruptive actions on a node. A Lease
+// is free if it has no holder, or if it has not been renewed within its
+// duration. A held Lease is lost if something else takes it, or if it cannot
+// be renewed before it expires.
+type NodeLeaseLock struct {
+	l         *zap.Logger
+	c         dynamic.Interface
+	namespace string
+	holder    string
+	duration  time.Duration
+
+	mu    sync.Mutex
+	renew map[string]chan struct{}
+	lost  map[string]chan struct{}
+}
+
+// NodeLeaseLockOption configures a NodeLeaseLock.
+type NodeLeaseLockOption func(l *NodeLeaseLock)
+
+// WithLeaseLogger configures a NodeLeaseLock to use the supplied logger.
+func WithLeaseLogger(l *zap.Logger) NodeLeaseLockOption {
+	return func(ll *NodeLeaseLock) {
+		ll.l = l
+	}
+}
+
+// WithLeaseDuration configures how long each Lease is valid without being
+// renewed.
+func WithLeaseDuration(d time.Duration) NodeLeaseLockOption {
+	return func(l *NodeLeaseLock) {
+		l.duration = d
+	}
+}
+
+// NewNodeLeaseLock returns a DrainLock stored in Leases in the supplied
+// namespace, held by the supplied identity. The identity must be unique to
+// this lock, for example a pod name suffixed with a random ID, or replicas
+// sharing the identity would each consider themselves the Lease's holder.
+func NewNodeLeaseLock(c dynamic.Interface, namespace, holder string, lo ...NodeLeaseLockOption) *NodeLeaseLock {
+	l := &NodeLeaseLock{
+		l:         zap.NewNop(),
+		c:         c,
+		namespace: namespace,
+		holder:    holder,
+		duration:  DefaultLeaseDuration,
+		renew:     make(map[string]chan struct{}),
+		lost:      make(map[string]chan struct{}),
+	}
+	for _, o := range lo {
+		o(l)
+	}
+	return l
+}
+
+// Acquire the Lease of the supplied node. The Lease is renewed until it is
+// released.
+func (l *NodeLeaseLock) Acquire(n *core.Node) error {
+	ri := l.c.Resource(LeaseResource).Namespace(l.namespace)
+	now := time.Now()
+	u, err := ri.Get(n.GetName(), meta.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		u = &unstructured.Unstructured{}
+		u.SetAPIVersion(LeaseResource.GroupVersion().String())
+		u.SetKind("Lease")
+		u.SetNamespace(l.namespace)
+		u.SetName(n.GetName())
+		l.hold(u, now, true)
+		if _, err := ri.Create(u); err != nil {
+			return errors.Wrapf(err, "cannot create lease %s/%s", l.namespace, n.GetName())
+		}
+		l.renewing(n.GetName())
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "cannot get lease %s/%s", l.namespace, n.GetName())
+	}
+	holder, _, _ := unstructured.NestedString(u.Object, "spec", "holderIdentity")
+	if holder != "" && holder != l.holder && !leaseExpired(u, now) {
+		return errors.Errorf("lease %s/%s is held by %s", l.namespace, n.GetName(), holder)
+	}
+	l.hold(u, now, holder != l.holder)
+
+	// Updates fail if the Lease changed since we read it, so we can't take a
+	// Lease that was acquired concurrently.
+	if _, err := ri.Update(u); err != nil {
+		return errors.Wrapf(err, "cannot acquire lease %s/%s", l.namespace, n.GetName())
+	}
+	l.renewing(n.GetName())
+	return nil
+}
+
+// Release the Lease of the supplied node. Leases held by anything else are
+// left untouched.
+func (l *NodeLeaseLock) Release(n *core.Node) error {
+	l.mu.Lock()
+	if stop, ok := l.renew[n.GetName()]; ok {
+		close(stop)
+		delete(l.renew, n.GetName())
+	}
+	delete(l.lost, n.GetName())
+	l.mu.Unlock()
+
+	ri := l.c.Resource(LeaseResource).Namespace(l.namespace)
+	u, err := ri.Get(n.GetName(), meta.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "cannot get lease %s/%s", l.namespace, n.GetName())
+	}
+	if holder, _, _ := unstructured.NestedString(u.Object, "spec", "holderIdentity"); holder != l.holder {
+		return nil
+	}
+	unstructured.RemoveNestedField(u.Object, "spec", "holderIdentity")
+	_, err = ri.Update(u)
+	return errors.Wrapf(err, "cannot release lease %s/%s", l.namespace, n.GetName())
+}
+
+// hold sets the supplied Lease's holder to this lock, renewed at the supplied
+// time. Newly acquired Leases record their acquisition.
+func (l *NodeLeaseLock) hold(u *unstructured.Unstructured, now time.Time, acquired bool) {
+	t := now.UTC().Format(meta.RFC3339Micro)
+	_ = unstructured.SetNestedField(u.Object, l.holder, "spec", "holderIdentity")
+	_ = unstructured.SetNestedField(u.Object, int64(l.duration/time.Second), "spec", "leaseDurationSeconds")
+	_ = unstructured.SetNestedField(u.Object, t, "spec", "renewTime")
+	if !acquired {
+		return
+	}
+	_ = unstructured.SetNestedField(u.Object, t, "spec", "acquireTime")
+	transitions, _, _ := unstructured.NestedInt64(u.Object, "spec", "leaseTransitions")
+	if _, ok, _ := unstructured.NestedString(u.Object, "metadata", "resourceVersion"); ok {
+		transitions++
+	}
+	_ = unstructured.SetNestedField(u.Object, transitions, "spec", "leaseTransitions")
+}
+
+// Lost returns a channel that is closed if the Lease of the supplied node is
+// lost while it is held. The channel is never closed if the Lease is not held.
+func (l *NodeLeaseLock) Lost(n *core.Node) <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lost[n.GetName()]
+}
+
+// renewing renews the Lease of the supplied node until it is released or
+// lost.
+func (l *NodeLeaseLock) renewing(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.renew[name]; ok {
+		return
+	}
+	stop, lost := make(chan struct{}), make(chan struct{})
+	l.renew[name] = stop
+	l.lost[name] = lost
+	go func() {
+		t := time.NewTicker(l.duration / 3)
+		defer t.Stop()
+		renewed := time.Now()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				held, err := l.renewOnce(name)
+				if err == nil {
+					renewed = time.Now()
+					continue
+				}
+				if held && time.Since(renewed) < l.duration {
+					l.l.Info("Cannot renew lease", zap.String("node", name), zap.Error(err))
+					continue
+				}
+				l.l.Info("Lost lease", zap.String("node", name), zap.Error(err))
+				l.mu.Lock()
+				if l.renew[name] == stop {
+					delete(l.renew, name)
+				}
+				l.mu.Unlock()
+				close(lost)
+				return
+			}
+		}
+	}()
+}
+
+// renewOnce renews the Lease of the supplied node. It returns false if the
+// Lease is held by something else.
+func (l *NodeLeaseLock) renewOnce(name string) (bool, error) {
+	ri := l.c.Resource(LeaseResource).Namespace(l.namespace)
+	u, err := ri.Get(name, meta.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, errors.Wrapf(err, "cannot get lease %s/%s", l.namespace, name)
+	}
+	if err != nil {
+		return true, errors.Wrapf(err, "cannot get lease %s/%s", l.namespace, name)
+	}
+	if holder, _, _ := unstructured.NestedString(u.Object, "spec", "holderIdentity"); holder != l.holder {
+		return false, errors.Errorf("lease %s/%s is held by %s", l.namespace, name, holder)
+	}
+	l.hold(u, time.Now(), false)
+	_, err = ri.Update(u)
+	return true, errors.Wrapf(err, "cannot renew lease %s/%s", l.namespace, name)
+}
+
+// leaseExpired returns true if the supplied Lease was not renewed within its
+// duration as of the supplied time. Leases without a renew time or duration
+// never expire.
+func leaseExpired(u *unstructured.Unstructured, now time.Time) bool {
+	renewed, _, _ := unstructured.NestedString(u.Object, "spec", "renewTime")
+	seconds, ok, _ := unstructured.NestedInt64(u.Object, "spec", "leaseDurationSeconds")
+	if renewed == "" || !ok {
+		return false
+	}
+	t, err := time.Parse(meta.RFC3339Micro, renewed)
+	if err != nil {
+		return false
+	}
+	return now.After(t.Add(time.Duration(seconds) * time.Second))
+}
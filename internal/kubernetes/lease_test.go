@@ -0,0 +1,193 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+const (
+	leaseNamespace = "kube-system"
+	leaseHolder    = "draino"
+)
+
+func newLease(holder string, renewed time.Time) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{}
+	u.SetAPIVersion(LeaseResource.GroupVersion().String())
+	u.SetKind("Lease")
+	u.SetNamespace(leaseNamespace)
+	u.SetName(nodeName)
+	u.SetResourceVersion("1")
+	if holder != "" {
+		_ = unstructured.SetNestedField(u.Object, holder, "spec", "holderIdentity")
+	}
+	_ = unstructured.SetNestedField(u.Object, int64(60), "spec", "leaseDurationSeconds")
+	_ = unstructured.SetNestedField(u.Object, renewed.UTC().Format(meta.RFC3339Micro), "spec", "renewTime")
+	return u
+}
+
+func TestNodeLeaseLock(t *testing.T) {
+	n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}
+
+	cases := []struct {
+		name       string
+		lease      *unstructured.Unstructured
+		release    bool
+		wantErr    bool
+		wantHolder string
+	}{
+		{
+			name:       "AcquireMissingLease",
+			wantHolder: leaseHolder,
+		},
+		{
+			name:       "AcquireFreeLease",
+			lease:      newLease("", time.Now()),
+			wantHolder: leaseHolder,
+		},
+		{
+			name:       "AcquireLeaseHeldByOther",
+			lease:      newLease("kured", time.Now()),
+			wantErr:    true,
+			wantHolder: "kured",
+		},
+		{
+			name:       "AcquireExpiredLease",
+			lease:      newLease("kured", time.Now().Add(-1*time.Hour)),
+			wantHolder: leaseHolder,
+		},
+		{
+			name:       "AcquireLeaseAlreadyHeld",
+			lease:      newLease(leaseHolder, time.Now()),
+			wantHolder: leaseHolder,
+		},
+		{
+			name:       "ReleaseHeldLease",
+			lease:      newLease(leaseHolder, time.Now()),
+			release:    true,
+			wantHolder: "",
+		},
+		{
+			name:       "ReleaseLeaseHeldByOther",
+			lease:      newLease("kured", time.Now()),
+			release:    true,
+			wantHolder: "kured",
+		},
+		{
+			name:    "ReleaseMissingLease",
+			release: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			objects := []runtime.Object{}
+			if tc.lease != nil {
+				objects = append(objects, tc.lease)
+			}
+			c := fake.NewSimpleDynamicClient(runtime.NewScheme(), objects...)
+			l := NewNodeLeaseLock(c, leaseNamespace, leaseHolder)
+
+			var err error
+			if tc.release {
+				err = l.Release(n)
+			} else {
+				err = l.Acquire(n)
+				defer l.Release(n) // nolint:errcheck
+			}
+			if err != nil && !tc.wantErr {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if err == nil && tc.wantErr {
+				t.Errorf("want error, got nil")
+			}
+
+			u, err := c.Resource(LeaseResource).Namespace(leaseNamespace).Get(nodeName, meta.GetOptions{})
+			if err != nil {
+				if tc.wantHolder == "" {
+					return
+				}
+				t.Fatalf("c.Resource(...).Get(%v): %v", nodeName, err)
+			}
+			got, _, _ := unstructured.NestedString(u.Object, "spec", "holderIdentity")
+			if got != tc.wantHolder {
+				t.Errorf("lease holder: want %q, got %q", tc.wantHolder, got)
+			}
+		})
+	}
+}
+
+func TestNodeLeaseLockRenews(t *testing.T) {
+	n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}
+	c := fake.NewSimpleDynamicClient(runtime.NewScheme())
+	l := NewNodeLeaseLock(c, leaseNamespace, leaseHolder, WithLeaseDuration(30*time.Millisecond))
+
+	renewTime := func() string {
+		u, err := c.Resource(LeaseResource).Namespace(leaseNamespace).Get(nodeName, meta.GetOptions{})
+		if err != nil {
+			t.Fatalf("c.Resource(...).Get(%v): %v", nodeName, err)
+		}
+		renewed, _, _ := unstructured.NestedString(u.Object, "spec", "renewTime")
+		return renewed
+	}
+
+	if err := l.Acquire(n); err != nil {
+		t.Fatalf("l.Acquire(%v): %v", n.GetName(), err)
+	}
+	acquired := renewTime()
+	time.Sleep(50 * time.Millisecond)
+	if renewed := renewTime(); renewed == acquired {
+		t.Errorf("lease not renewed after %s", 50*time.Millisecond)
+	}
+	if err := l.Release(n); err != nil {
+		t.Fatalf("l.Release(%v): %v", n.GetName(), err)
+	}
+}
+
+func TestNodeLeaseLockLost(t *testing.T) {
+	n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}
+	c := fake.NewSimpleDynamicClient(runtime.NewScheme())
+	l := NewNodeLeaseLock(c, leaseNamespace, leaseHolder, WithLeaseDuration(30*time.Millisecond))
+
+	if err := l.Acquire(n); err != nil {
+		t.Fatalf("l.Acquire(%v): %v", n.GetName(), err)
+	}
+	defer l.Release(n) // nolint:errcheck
+
+	// Another replica sharing nothing but the Lease takes it.
+	u, err := c.Resource(LeaseResource).Namespace(leaseNamespace).Get(nodeName, meta.GetOptions{})
+	if err != nil {
+		t.Fatalf("c.Resource(...).Get(%v): %v", nodeName, err)
+	}
+	_ = unstructured.SetNestedField(u.Object, "draino-other", "spec", "holderIdentity")
+	if _, err := c.Resource(LeaseResource).Namespace(leaseNamespace).Update(u); err != nil {
+		t.Fatalf("c.Resource(...).Update(%v): %v", nodeName, err)
+	}
+
+	select {
+	case <-l.Lost(n):
+	case <-time.After(time.Second):
+		t.Errorf("lease not lost after %s", time.Second)
+	}
+}
@@ -0,0 +1,241 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+)
+
+// MachineResource is the Cluster API Machine resource.
+var MachineResource = schema.GroupVersionResource{
+	Group:    "cluster.x-k8s.io",
+	Version:  "v1beta1",
+	Resource: "machines",
+}
+
+// MachinePreDrainHook is the Cluster API pre-drain lifecycle hook annotation
+// draino adds to Machines. Cluster API will not drain or delete the node of a
+// deleting Machine until all of its pre-drain hooks have been removed.
+const MachinePreDrainHook = "pre-drain.delete.hook.machine.cluster.x-k8s.io/draino"
+
+// machinePreDrainHookOwner is the value of MachinePreDrainHook. Cluster API
+// expects hook annotations to name the controller responsible for them.
+const machinePreDrainHookOwner = "draino"
+
+// A MachineController acts as the drain provider for Cluster API Machines. It
+// registers a pre-drain hook on each Machine, then drains the node of any
+// Machine that is deleted - for example when a MachineHealthCheck remediates
+// an unhealthy Machine. Once the drain succeeds the hook is removed, allowing
+// Cluster API to proceed.
+type MachineController struct {
+	l      *zap.Logger
+	c      dynamic.Interface
+	nodes  NodeStore
+	r      DrainRequester
+	i      cache.SharedInformer
+	dryRun bool
+
+	mu     sync.Mutex
+	active map[types.UID]bool
+}
+
+// MachineControllerOption configures a MachineController.
+type MachineControllerOption func(c *MachineController)
+
+// WithMachineLogger configures a MachineController to use the supplied logger.
+func WithMachineLogger(l *zap.Logger) MachineControllerOption {
+	return func(c *MachineController) {
+		c.l = l
+	}
+}
+
+// WithMachineDryRun configures a MachineController to request drains without
+// adding or removing the pre-drain hooks of Machines.
+func WithMachineDryRun() MachineControllerOption {
+	return func(c *MachineController) {
+		c.dryRun = true
+	}
+}
+
+// NewMachineController returns a MachineController that uses the supplied
+// DrainRequester to drain the nodes of deleting Machines.
+func NewMachineController(c dynamic.Interface, nodes NodeStore, r DrainRequester, co ...MachineControllerOption) *MachineController {
+	mc := &MachineController{
+		l:      zap.NewNop(),
+		c:      c,
+		nodes:  nodes,
+		r:      r,
+		active: make(map[types.UID]bool),
+	}
+	for _, o := range co {
+		o(mc)
+	}
+	ri := c.Resource(MachineResource)
+	lw := &cache.ListWatch{
+		ListFunc:  func(o meta.ListOptions) (runtime.Object, error) { return ri.List(o) },
+		WatchFunc: func(o meta.ListOptions) (watch.Interface, error) { return ri.Watch(o) },
+	}
+	mc.i = cache.NewSharedInformer(lw, &unstructured.Unstructured{}, 30*time.Minute)
+	mc.i.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    mc.handle,
+		UpdateFunc: func(_, o interface{}) { mc.handle(o) },
+	})
+	return mc
+}
+
+// Run the controller until the supplied channel is closed.
+func (c *MachineController) Run(stop <-chan struct{}) {
+	c.i.Run(stop)
+}
+
+// Deleting returns true if the supplied node belongs to a Machine that is
+// being deleted, and thus should not be uncordoned.
+func (c *MachineController) Deleting(o interface{}) bool {
+	n, ok := o.(*core.Node)
+	if !ok {
+		return false
+	}
+	for _, o := range c.i.GetStore().List() {
+		u, ok := o.(*unstructured.Unstructured)
+		if !ok || u.GetDeletionTimestamp() == nil {
+			continue
+		}
+		if name, _, _ := unstructured.NestedString(u.Object, "status", "nodeRef", "name"); name == n.GetName() {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *MachineController) handle(o interface{}) {
+	u, ok := o.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	log := c.l.With(zap.String("namespace", u.GetNamespace()), zap.String("machine", u.GetName()))
+	_, hooked := u.GetAnnotations()[MachinePreDrainHook]
+
+	if u.GetDeletionTimestamp() == nil {
+		if hooked {
+			return
+		}
+		if err := c.setHook(u.GetNamespace(), u.GetName(), true); err != nil {
+			log.Info("Cannot add pre-drain hook", zap.Error(err))
+		}
+		return
+	}
+	if !hooked {
+		return
+	}
+
+	c.mu.Lock()
+	if c.active[u.GetUID()] {
+		c.mu.Unlock()
+		return
+	}
+	c.active[u.GetUID()] = true
+	c.mu.Unlock()
+
+	name, _, _ := unstructured.NestedString(u.Object, "status", "nodeRef", "name")
+	if name == "" {
+		log.Info("Deleting machine has no node")
+		c.release(u)
+		return
+	}
+	n, err := c.nodes.Get(name)
+	if err != nil {
+		log.Info("Cannot get node of deleting machine", zap.String("node", name), zap.Error(err))
+		c.release(u)
+		return
+	}
+
+	log.Info("Requesting drain of deleting machine", zap.String("node", name))
+	err = c.r.Request(n, time.Time{}, func(err error) {
+		if err != nil {
+			log.Info("Cannot drain node of deleting machine", zap.String("node", name), zap.Error(err))
+			c.forget(u)
+			return
+		}
+		c.release(u)
+	})
+	if err != nil {
+		// The drain may not have been scheduled because another drain of
+		// the node is in progress, which need not succeed.
+		log.Info("Cannot request drain of deleting machine", zap.String("node", name), zap.Error(err))
+		c.forget(u)
+	}
+}
+
+// forget the supplied Machine, so that its node's drain is requested again
+// when the Machine is next updated or resynced. Its pre-drain hook is held
+// until the drain succeeds.
+func (c *MachineController) forget(u *unstructured.Unstructured) {
+	c.mu.Lock()
+	delete(c.active, u.GetUID())
+	c.mu.Unlock()
+}
+
+// release the supplied Machine's pre-drain hook, allowing Cluster API to
+// proceed with its deletion.
+func (c *MachineController) release(u *unstructured.Unstructured) {
+	if err := c.setHook(u.GetNamespace(), u.GetName(), false); err != nil {
+		c.l.Info("Cannot remove pre-drain hook", zap.String("namespace", u.GetNamespace()), zap.String("machine", u.GetName()), zap.Error(err))
+	}
+	// Forget the Machine so that a failed release is retried when the
+	// Machine is next resynced.
+	c.forget(u)
+}
+
+func (c *MachineController) setHook(namespace, name string, set bool) error {
+	if c.dryRun {
+		c.l.Info("Not updating pre-drain hook in dry-run mode", zap.String("namespace", namespace), zap.String("machine", name), zap.Bool("set", set))
+		return nil
+	}
+	ri := c.c.Resource(MachineResource).Namespace(namespace)
+	u, err := ri.Get(name, meta.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "cannot get machine %s/%s", namespace, name)
+	}
+	a := u.GetAnnotations()
+	if _, ok := a[MachinePreDrainHook]; ok == set {
+		return nil
+	}
+	if a == nil {
+		a = make(map[string]string)
+	}
+	if set {
+		a[MachinePreDrainHook] = machinePreDrainHookOwner
+	} else {
+		delete(a, MachinePreDrainHook)
+	}
+	u.SetAnnotations(a)
+	_, err = ri.Update(u)
+	return errors.Wrapf(err, "cannot update machine %s/%s", namespace, name)
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+const (
+	machineNamespace = "coolNamespace"
+	machineName      = "coolMachine"
+)
+
+func newMachine(deleting bool, node string, annotations map[string]string) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{}
+	u.SetAPIVersion(MachineResource.GroupVersion().String())
+	u.SetKind("Machine")
+	u.SetNamespace(machineNamespace)
+	u.SetName(machineName)
+	u.SetUID("uid")
+	u.SetAnnotations(annotations)
+	if deleting {
+		now := meta.Now()
+		u.SetDeletionTimestamp(&now)
+	}
+	if node != "" {
+		_ = unstructured.SetNestedField(u.Object, node, "status", "nodeRef", "name")
+	}
+	return u
+}
+
+func TestMachineController(t *testing.T) {
+	nodes := staticNodeStore{&core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}}
+	hook := map[string]string{MachinePreDrainHook: machinePreDrainHookOwner}
+
+	cases := []struct {
+		name        string
+		machine     *unstructured.Unstructured
+		results     map[string]error
+		dryRun      bool
+		wantHook    bool
+		wantDrained bool
+	}{
+		{
+			name:     "RegisterHook",
+			machine:  newMachine(false, nodeName, nil),
+			wantHook: true,
+		},
+		{
+			name:     "HookAlreadyRegistered",
+			machine:  newMachine(false, nodeName, hook),
+			wantHook: true,
+		},
+		{
+			name:        "DrainDeletingMachine",
+			machine:     newMachine(true, nodeName, hook),
+			results:     map[string]error{nodeName: nil},
+			wantDrained: true,
+		},
+		{
+			name:        "DrainFailed",
+			machine:     newMachine(true, nodeName, hook),
+			results:     map[string]error{nodeName: errors.New("nope")},
+			wantHook:    true,
+			wantDrained: true,
+		},
+		{
+			name:     "DrainRequestFailed",
+			machine:  newMachine(true, nodeName, hook),
+			results:  map[string]error{},
+			wantHook: true,
+		},
+		{
+			name:    "RegisterHookDryRun",
+			machine: newMachine(false, nodeName, nil),
+			dryRun:  true,
+		},
+		{
+			name:        "DrainDeletingMachineDryRun",
+			machine:     newMachine(true, nodeName, hook),
+			results:     map[string]error{nodeName: nil},
+			dryRun:      true,
+			wantHook:    true,
+			wantDrained: true,
+		},
+		{
+			name:    "NodeMissing",
+			machine: newMachine(true, "missingNode", hook),
+		},
+		{
+			name:    "NoNodeRef",
+			machine: newMachine(true, "", hook),
+		},
+		{
+			name:    "DeletingWithoutHook",
+			machine: newMachine(true, nodeName, nil),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := fake.NewSimpleDynamicClient(runtime.NewScheme(), tc.machine)
+			r := &fakeRequester{results: tc.results}
+			o := []MachineControllerOption{}
+			if tc.dryRun {
+				o = append(o, WithMachineDryRun())
+			}
+			mc := NewMachineController(c, nodes, r, o...)
+			mc.handle(tc.machine)
+
+			got, err := c.Resource(MachineResource).Namespace(machineNamespace).Get(machineName, meta.GetOptions{})
+			if err != nil {
+				t.Fatalf("c.Resource(...).Get(%v): %v", machineName, err)
+			}
+			if _, hooked := got.GetAnnotations()[MachinePreDrainHook]; hooked != tc.wantHook {
+				t.Errorf("machine hooked: want %v, got %v", tc.wantHook, hooked)
+			}
+			if drained := len(r.requested) > 0; drained != tc.wantDrained {
+				t.Errorf("node drained: want %v, got %v", tc.wantDrained, drained)
+			}
+			// Machines are forgotten once their drain finishes, so that
+			// failed drains are requested again.
+			if len(mc.active) > 0 {
+				t.Errorf("machine still active after its drain finished")
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+const mirrorPodPollInterval = 5 * time.Second
+
+// A MirrorPodHandler reports, and optionally stops, the mirror pods that
+// remain on a node once it has been drained. Mirror pods represent static pods,
+// which the kubelet runs from manifests on the node and which cannot be
+// evicted.
+type MirrorPodHandler struct {
+	c       kubernetes.Interface
+	stop    func(n *core.Node) error
+	timeout time.Duration
+	poll    time.Duration
+}
+
+// A MirrorPodHandlerOption configures a MirrorPodHandler.
+type MirrorPodHandlerOption func(m *MirrorPodHandler)
+
+// WithMirrorPodStopper configures a MirrorPodHandler to call the supplied
+// function when mirror pods remain on a drained node, for example to tell node
+// automation to remove the node's static pod manifests. The handler then waits
+// up to the supplied timeout for the mirror pods to be gone.
+func WithMirrorPodStopper(fn func(n *core.Node) error, timeout time.Duration) MirrorPodHandlerOption {
+	return func(m *MirrorPodHandler) {
+		m.stop = fn
+		m.timeout = timeout
+	}
+}
+
+// NewMirrorPodHandler returns a MirrorPodHandler.
+func NewMirrorPodHandler(c kubernetes.Interface, mo ...MirrorPodHandlerOption) *MirrorPodHandler {
+	m := &MirrorPodHandler{c: c, timeout: DefaultHookTimeout, poll: mirrorPodPollInterval}
+	for _, o := range mo {
+		o(m)
+	}
+	return m
+}
+
+// Handle the mirror pods remaining on the supplied drained node. Handle
+// returns the mirror pods that remain on the node, or an error if they could
+// not be stopped.
+func (m *MirrorPodHandler) Handle(n *core.Node) ([]core.Pod, error) {
+	pods, err := m.mirrorPods(n.GetName())
+	if err != nil || len(pods) == 0 || m.stop == nil {
+		return pods, err
+	}
+	if err := m.stop(n); err != nil {
+		return pods, errors.Wrapf(err, "cannot stop mirror pods %s", podNames(pods))
+	}
+	err = wait.PollImmediate(m.poll, m.timeout, func() (bool, error) {
+		pods, err = m.mirrorPods(n.GetName())
+		return len(pods) == 0, err
+	})
+	if err == wait.ErrWaitTimeout {
+		return pods, errors.Wrapf(errTimeout{}, "timed out waiting for mirror pods %s to stop", podNames(pods))
+	}
+	return pods, err
+}
+
+func (m *MirrorPodHandler) mirrorPods(node string) ([]core.Pod, error) {
+	l, err := listPods(m.c.CoreV1().Pods(meta.NamespaceAll).List, meta.ListOptions{
+		FieldSelector: fields.SelectorFromSet(fields.Set{"spec.nodeName": node}).String(),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot get pods for node %s", node)
+	}
+	pods := []core.Pod{}
+	for _, p := range l.Items {
+		if ok, _ := MirrorPodFilter(p); !ok {
+			pods = append(pods, p)
+		}
+	}
+	return pods, nil
+}
+
+// podNames returns a comma separated list of the supplied pods' namespaces
+// and names.
+func podNames(pods []core.Pod) string {
+	names := make([]string, 0, len(pods))
+	for _, p := range pods {
+		names = append(names, p.GetNamespace()+"/"+p.GetName())
+	}
+	return strings.Join(names, ",")
+}
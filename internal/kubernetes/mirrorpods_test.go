@@ -0,0 +1,103 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestMirrorPodHandler(t *testing.T) {
+	mirror := &core.Pod{
+		ObjectMeta: meta.ObjectMeta{
+			Namespace:   "kube-system",
+			Name:        "etcd",
+			Annotations: map[string]string{core.MirrorPodAnnotationKey: "true"},
+		},
+		Spec: core.PodSpec{NodeName: nodeName},
+	}
+	daemon := &core.Pod{
+		ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: podName},
+		Spec:       core.PodSpec{NodeName: nodeName},
+	}
+
+	cases := []struct {
+		name        string
+		stop        func(c *fake.Clientset) func(n *core.Node) error
+		wantPods    int
+		wantErr     bool
+		wantTimeout bool
+	}{
+		{
+			name:     "Report",
+			wantPods: 1,
+		},
+		{
+			name: "Stopped",
+			stop: func(c *fake.Clientset) func(n *core.Node) error {
+				return func(n *core.Node) error {
+					return c.CoreV1().Pods(mirror.GetNamespace()).Delete(mirror.GetName(), &meta.DeleteOptions{})
+				}
+			},
+		},
+		{
+			name: "StopFailed",
+			stop: func(c *fake.Clientset) func(n *core.Node) error {
+				return func(n *core.Node) error { return errors.New("nope") }
+			},
+			wantPods: 1,
+			wantErr:  true,
+		},
+		{
+			name: "NeverStopped",
+			stop: func(c *fake.Clientset) func(n *core.Node) error {
+				return func(n *core.Node) error { return nil }
+			},
+			wantPods:    1,
+			wantErr:     true,
+			wantTimeout: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := fake.NewSimpleClientset(mirror.DeepCopy(), daemon.DeepCopy())
+			mo := []MirrorPodHandlerOption{}
+			if tc.stop != nil {
+				mo = append(mo, WithMirrorPodStopper(tc.stop(c), 100*time.Millisecond))
+			}
+			m := NewMirrorPodHandler(c, mo...)
+			m.poll = 10 * time.Millisecond
+
+			pods, err := m.Handle(&core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}})
+			if (err != nil) != tc.wantErr {
+				t.Errorf("m.Handle(): want error %v, got %v", tc.wantErr, err)
+			}
+			if IsTimeout(err) != tc.wantTimeout {
+				t.Errorf("IsTimeout(m.Handle()): want %v, got %v", tc.wantTimeout, err)
+			}
+			if len(pods) != tc.wantPods {
+				t.Errorf("m.Handle(): want %d pods, got %d", tc.wantPods, len(pods))
+			}
+		})
+	}
+}
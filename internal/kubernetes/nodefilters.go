@@ -17,6 +17,10 @@ and limitations under the License.
 package kubernetes
 
 import (
+	"hash/fnv"
+	"sort"
+	"strings"
+
 	core "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 )
@@ -38,6 +42,36 @@ func NewNodeLabelFilter(labels map[string]string) func(o interface{}) bool {
 	}
 }
 
+// NewNodeAnyLabelFilter returns a filter that returns true if the supplied
+// object is a node with any of the supplied labels.
+func NewNodeAnyLabelFilter(labels map[string]string) func(o interface{}) bool {
+	return func(o interface{}) bool {
+		n, ok := o.(*core.Node)
+		if !ok {
+			return false
+		}
+		for k, v := range labels {
+			if actual, ok := n.GetLabels()[k]; ok && actual == v {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// NewAnyFilter returns a filter that returns true if any of the supplied
+// filters return true.
+func NewAnyFilter(filters ...func(o interface{}) bool) func(o interface{}) bool {
+	return func(o interface{}) bool {
+		for _, f := range filters {
+			if f(o) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
 // NewNodeConditionFilter returns a filter that returns true if the supplied
 // object is a node with any of the supplied node conditions.
 func NewNodeConditionFilter(ct []string) func(o interface{}) bool {
@@ -70,8 +104,181 @@ func NodeSchedulableFilter(o interface{}) bool {
 	return !n.Spec.Unschedulable
 }
 
+// Policies for nodes that were cordoned by something other than draino, for
+// example by a human running kubectl cordon.
+const (
+	// CordonedNodePolicySkip ignores such nodes.
+	CordonedNodePolicySkip = "skip"
+
+	// CordonedNodePolicyDrain drains such nodes if they match, leaving them
+	// cordoned once they are drained.
+	CordonedNodePolicyDrain = "drain"
+)
+
+// NewNodeCordonedElsewhereFilter returns a filter that returns true if the
+// supplied object is an unschedulable node that draino did not cordon, per the
+// Cordoned and DrainInProgress keys.
+func NewNodeCordonedElsewhereFilter(k Keys) func(o interface{}) bool {
+	return func(o interface{}) bool {
+		n, ok := o.(*core.Node)
+		if !ok || !n.Spec.Unschedulable {
+			return false
+		}
+		if _, ok := n.GetAnnotations()[k.Cordoned]; ok {
+			return false
+		}
+		_, ok = n.GetAnnotations()[k.DrainInProgress]
+		return !ok
+	}
+}
+
+// NodeDeletingFilter returns true if the supplied object is a node that has
+// been marked for deletion.
+func NodeDeletingFilter(o interface{}) bool {
+	n, ok := o.(*core.Node)
+	if !ok {
+		return false
+	}
+	return n.GetDeletionTimestamp() != nil
+}
+
+// NewNodeDrainInProgressFilter returns a filter that returns true if the
+// supplied object is a node that draino cordoned but did not finish draining,
+// per the DrainInProgress key.
+func NewNodeDrainInProgressFilter(k Keys) func(o interface{}) bool {
+	return func(o interface{}) bool {
+		n, ok := o.(*core.Node)
+		if !ok {
+			return false
+		}
+		_, ok = n.GetAnnotations()[k.DrainInProgress]
+		return ok
+	}
+}
+
+// NodeAutoscalerDeletingFilter returns true if the supplied object is a node
+// that the cluster autoscaler is draining in order to scale it down.
+func NodeAutoscalerDeletingFilter(o interface{}) bool {
+	n, ok := o.(*core.Node)
+	if !ok {
+		return false
+	}
+	for _, t := range n.Spec.Taints {
+		if t.Key == TaintAutoscalerToBeDeleted {
+			return true
+		}
+	}
+	return false
+}
+
+// Karpenter coordination.
+const (
+	// TaintKarpenterDisruption is added by Karpenter v1beta1 to nodes it is
+	// disrupting, for example in order to consolidate them.
+	TaintKarpenterDisruption = "karpenter.sh/disruption"
+
+	// TaintKarpenterDisrupted is added by Karpenter v1 to nodes it is
+	// disrupting.
+	TaintKarpenterDisrupted = "karpenter.sh/disrupted"
+)
+
+// ForeignDrainTaints returns the keys of the taints that well known drain
+// controllers, i.e. the cluster autoscaler and Karpenter, add to nodes they
+// are draining.
+func ForeignDrainTaints() []string {
+	return []string{TaintAutoscalerToBeDeleted, TaintKarpenterDisruption, TaintKarpenterDisrupted}
+}
+
+// NewNodeForeignDrainFilter returns a filter that returns true if the supplied
+// object is a node that another controller is draining, i.e. a node with a
+// taint with any of the supplied keys, or with any of the supplied
+// annotations. Annotations are of the form KEY, or KEY=VALUE to match only
+// annotations with that value.
+func NewNodeForeignDrainFilter(taints, annotations []string) func(o interface{}) bool {
+	return func(o interface{}) bool {
+		n, ok := o.(*core.Node)
+		if !ok {
+			return false
+		}
+		for _, t := range n.Spec.Taints {
+			for _, key := range taints {
+				if t.Key == key {
+					return true
+				}
+			}
+		}
+		for _, a := range annotations {
+			kv := strings.SplitN(a, "=", 2)
+			v, ok := n.GetAnnotations()[kv[0]]
+			if ok && (len(kv) < 2 || v == kv[1]) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Control plane node role labels.
+const (
+	// LabelNodeRoleMaster is the role label of control plane nodes in
+	// clusters created by older versions of kubeadm.
+	LabelNodeRoleMaster = "node-role.kubernetes.io/master"
+
+	// LabelNodeRoleControlPlane is the role label of control plane nodes.
+	LabelNodeRoleControlPlane = "node-role.kubernetes.io/control-plane"
+
+	// LabelRole is the role label some installers, for example kops, set to
+	// master on control plane nodes.
+	LabelRole = "kubernetes.io/role"
+)
+
+// NodeControlPlaneFilter returns true if the supplied object is a node that is
+// labelled as part of the control plane, i.e. a node that may run etcd or the
+// API server.
+func NodeControlPlaneFilter(o interface{}) bool {
+	n, ok := o.(*core.Node)
+	if !ok {
+		return false
+	}
+	l := n.GetLabels()
+	if _, ok := l[LabelNodeRoleMaster]; ok {
+		return true
+	}
+	if _, ok := l[LabelNodeRoleControlPlane]; ok {
+		return true
+	}
+	return l[LabelRole] == "master"
+}
+
+// NewNodeShardFilter returns a filter that returns true if the supplied object
+// is a node that belongs to the supplied shard, out of the supplied number of
+// shards. Nodes are assigned to shards by a hash of their name, or of the value
+// of the supplied label if it is not empty. Nodes without the label belong to
+// the first shard.
+func NewNodeShardFilter(index, count int, label string) func(o interface{}) bool {
+	return func(o interface{}) bool {
+		n, ok := o.(*core.Node)
+		if !ok {
+			return false
+		}
+		key := n.GetName()
+		if label != "" {
+			v, ok := n.GetLabels()[label]
+			if !ok {
+				return index == 0
+			}
+			key = v
+		}
+		h := fnv.New32a()
+		h.Write([]byte(key)) // nolint:gosec
+		return int(h.Sum32()%uint32(count)) == index
+	}
+}
+
 // NodeProcessed tracks whether nodes have been processed before using a map.
-type NodeProcessed map[types.UID]bool
+// Nodes are tracked along with a fingerprint of their conditions, so that a
+// node whose conditions change is processed again.
+type NodeProcessed map[types.UID]string
 
 // NewNodeProcessed returns a new node processed filter.
 func NewNodeProcessed() NodeProcessed {
@@ -79,16 +286,28 @@ func NewNodeProcessed() NodeProcessed {
 }
 
 // Filter returns true if the supplied object is a node that this filter has
-// not seen before. It is not threadsafe and should always be the last filter
-// applied.
+// not seen before, or has not seen with its current set of conditions. It is
+// not threadsafe and should always be the last filter applied.
 func (processed NodeProcessed) Filter(o interface{}) bool {
 	n, ok := o.(*core.Node)
 	if !ok {
 		return false
 	}
-	if processed[n.GetUID()] {
+	fp := conditionFingerprint(n)
+	if seen, ok := processed[n.GetUID()]; ok && seen == fp {
 		return false
 	}
-	processed[n.GetUID()] = true
+	processed[n.GetUID()] = fp
 	return true
 }
+
+// conditionFingerprint returns a string that changes whenever the type or
+// status of any of the supplied node's conditions does.
+func conditionFingerprint(n *core.Node) string {
+	c := make([]string, 0, len(n.Status.Conditions))
+	for _, cond := range n.Status.Conditions {
+		c = append(c, string(cond.Type)+"="+string(cond.Status))
+	}
+	sort.Strings(c)
+	return strings.Join(c, ",")
+}
@@ -17,6 +17,7 @@ and limitations under the License.
 package kubernetes
 
 import (
+	"fmt"
 	"testing"
 
 	core "k8s.io/api/core/v1"
@@ -126,6 +127,78 @@ func TestNodeLabelFilter(t *testing.T) {
 	}
 }
 
+func TestNodeAnyLabelFilter(t *testing.T) {
+	cases := []struct {
+		name         string
+		obj          interface{}
+		labels       map[string]string
+		passesFilter bool
+	}{
+		{
+			name:         "OneOfManyLabelsMatch",
+			obj:          &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName, Labels: map[string]string{"lifecycle": "terminating"}}},
+			labels:       map[string]string{"lifecycle": "terminating", "spot": "interrupted"},
+			passesFilter: true,
+		},
+		{
+			name:         "LabelValueDiffers",
+			obj:          &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName, Labels: map[string]string{"lifecycle": "running"}}},
+			labels:       map[string]string{"lifecycle": "terminating"},
+			passesFilter: false,
+		},
+		{
+			name:         "EmptyLabelValue",
+			obj:          &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}},
+			labels:       map[string]string{"lifecycle": ""},
+			passesFilter: false,
+		},
+		{
+			name:         "NoLabels",
+			obj:          &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName, Labels: map[string]string{"lifecycle": "terminating"}}},
+			passesFilter: false,
+		},
+		{
+			name:         "NotANode",
+			obj:          &core.Pod{ObjectMeta: meta.ObjectMeta{Name: podName, Labels: map[string]string{"lifecycle": "terminating"}}},
+			labels:       map[string]string{"lifecycle": "terminating"},
+			passesFilter: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			filter := NewNodeAnyLabelFilter(tc.labels)
+			passesFilter := filter(tc.obj)
+			if passesFilter != tc.passesFilter {
+				t.Errorf("filter(tc.obj): want %v, got %v", tc.passesFilter, passesFilter)
+			}
+		})
+	}
+}
+
+func TestAnyFilter(t *testing.T) {
+	yes := func(_ interface{}) bool { return true }
+	no := func(_ interface{}) bool { return false }
+	cases := []struct {
+		name         string
+		filters      []func(o interface{}) bool
+		passesFilter bool
+	}{
+		{name: "OnePasses", filters: []func(o interface{}) bool{no, yes}, passesFilter: true},
+		{name: "NonePass", filters: []func(o interface{}) bool{no, no}, passesFilter: false},
+		{name: "NoFilters", passesFilter: false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			passesFilter := NewAnyFilter(tc.filters...)(&core.Node{})
+			if passesFilter != tc.passesFilter {
+				t.Errorf("filter(&core.Node{}): want %v, got %v", tc.passesFilter, passesFilter)
+			}
+		})
+	}
+}
+
 func TestNodeConditionFilter(t *testing.T) {
 	cases := []struct {
 		name         string
@@ -266,6 +339,281 @@ func TestNodeSchedulableFilter(t *testing.T) {
 		})
 	}
 }
+func TestNodeDeletingFilter(t *testing.T) {
+	cases := []struct {
+		name         string
+		obj          interface{}
+		passesFilter bool
+	}{
+		{
+			name:         "Deleting",
+			obj:          &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName, DeletionTimestamp: &meta.Time{}}},
+			passesFilter: true,
+		},
+		{
+			name:         "NotDeleting",
+			obj:          &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}},
+			passesFilter: false,
+		},
+		{
+			name:         "NotANode",
+			obj:          &core.Pod{ObjectMeta: meta.ObjectMeta{Name: podName, DeletionTimestamp: &meta.Time{}}},
+			passesFilter: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			passesFilter := NodeDeletingFilter(tc.obj)
+			if passesFilter != tc.passesFilter {
+				t.Errorf("NodeDeletingFilter(tc.obj): want %v, got %v", tc.passesFilter, passesFilter)
+			}
+		})
+	}
+}
+
+func TestNodeDrainInProgressFilter(t *testing.T) {
+	cases := []struct {
+		name         string
+		obj          interface{}
+		passesFilter bool
+	}{
+		{
+			name:         "InProgress",
+			obj:          &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName, Annotations: map[string]string{AnnotationDrainInProgress: "2018-01-01T00:00:00Z"}}},
+			passesFilter: true,
+		},
+		{
+			name:         "NotInProgress",
+			obj:          &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName, Annotations: map[string]string{"cool": "very"}}},
+			passesFilter: false,
+		},
+		{
+			name:         "InProgressWithOtherPrefix",
+			obj:          &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName, Annotations: map[string]string{"example.org/drain-in-progress": "2018-01-01T00:00:00Z"}}},
+			passesFilter: false,
+		},
+		{
+			name:         "NotANode",
+			obj:          &core.Pod{ObjectMeta: meta.ObjectMeta{Name: podName, Annotations: map[string]string{AnnotationDrainInProgress: "2018-01-01T00:00:00Z"}}},
+			passesFilter: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			passesFilter := NewNodeDrainInProgressFilter(DefaultKeys())(tc.obj)
+			if passesFilter != tc.passesFilter {
+				t.Errorf("NewNodeDrainInProgressFilter(DefaultKeys())(tc.obj): want %v, got %v", tc.passesFilter, passesFilter)
+			}
+		})
+	}
+}
+
+func TestNodeCordonedElsewhereFilter(t *testing.T) {
+	cases := []struct {
+		name         string
+		obj          interface{}
+		passesFilter bool
+	}{
+		{
+			name:         "CordonedByHuman",
+			obj:          &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}, Spec: core.NodeSpec{Unschedulable: true}},
+			passesFilter: true,
+		},
+		{
+			name: "CordonedByDraino",
+			obj: &core.Node{
+				ObjectMeta: meta.ObjectMeta{Name: nodeName, Annotations: map[string]string{AnnotationCordoned: "2018-10-12T18:00:00Z"}},
+				Spec:       core.NodeSpec{Unschedulable: true},
+			},
+			passesFilter: false,
+		},
+		{
+			name: "DrainInProgress",
+			obj: &core.Node{
+				ObjectMeta: meta.ObjectMeta{Name: nodeName, Annotations: map[string]string{AnnotationDrainInProgress: "2018-10-12T18:00:00Z"}},
+				Spec:       core.NodeSpec{Unschedulable: true},
+			},
+			passesFilter: false,
+		},
+		{
+			name:         "Schedulable",
+			obj:          &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}},
+			passesFilter: false,
+		},
+		{
+			name:         "NotANode",
+			obj:          &core.Pod{ObjectMeta: meta.ObjectMeta{Name: podName}},
+			passesFilter: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			passesFilter := NewNodeCordonedElsewhereFilter(DefaultKeys())(tc.obj)
+			if passesFilter != tc.passesFilter {
+				t.Errorf("NewNodeCordonedElsewhereFilter(DefaultKeys())(tc.obj): want %v, got %v", tc.passesFilter, passesFilter)
+			}
+		})
+	}
+}
+
+func TestNodeAutoscalerDeletingFilter(t *testing.T) {
+	cases := []struct {
+		name         string
+		obj          interface{}
+		passesFilter bool
+	}{
+		{
+			name: "Deleting",
+			obj: &core.Node{
+				ObjectMeta: meta.ObjectMeta{Name: nodeName},
+				Spec:       core.NodeSpec{Taints: []core.Taint{{Key: TaintAutoscalerToBeDeleted, Effect: core.TaintEffectNoSchedule}}},
+			},
+			passesFilter: true,
+		},
+		{
+			name: "OtherTaint",
+			obj: &core.Node{
+				ObjectMeta: meta.ObjectMeta{Name: nodeName},
+				Spec:       core.NodeSpec{Taints: []core.Taint{{Key: "cool", Effect: core.TaintEffectNoSchedule}}},
+			},
+			passesFilter: false,
+		},
+		{
+			name:         "NotTainted",
+			obj:          &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}},
+			passesFilter: false,
+		},
+		{
+			name:         "NotANode",
+			obj:          &core.Pod{ObjectMeta: meta.ObjectMeta{Name: podName}},
+			passesFilter: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			passesFilter := NodeAutoscalerDeletingFilter(tc.obj)
+			if passesFilter != tc.passesFilter {
+				t.Errorf("NodeAutoscalerDeletingFilter(tc.obj): want %v, got %v", tc.passesFilter, passesFilter)
+			}
+		})
+	}
+}
+
+func TestNodeForeignDrainFilter(t *testing.T) {
+	cases := []struct {
+		name         string
+		obj          interface{}
+		passesFilter bool
+	}{
+		{
+			name: "AutoscalerTaint",
+			obj: &core.Node{
+				ObjectMeta: meta.ObjectMeta{Name: nodeName},
+				Spec:       core.NodeSpec{Taints: []core.Taint{{Key: TaintAutoscalerToBeDeleted, Effect: core.TaintEffectNoSchedule}}},
+			},
+			passesFilter: true,
+		},
+		{
+			name: "KarpenterTaint",
+			obj: &core.Node{
+				ObjectMeta: meta.ObjectMeta{Name: nodeName},
+				Spec:       core.NodeSpec{Taints: []core.Taint{{Key: TaintKarpenterDisruption, Value: "disrupting", Effect: core.TaintEffectNoSchedule}}},
+			},
+			passesFilter: true,
+		},
+		{
+			name: "OtherTaint",
+			obj: &core.Node{
+				ObjectMeta: meta.ObjectMeta{Name: nodeName},
+				Spec:       core.NodeSpec{Taints: []core.Taint{{Key: "cool", Effect: core.TaintEffectNoSchedule}}},
+			},
+			passesFilter: false,
+		},
+		{
+			name:         "AnnotationKey",
+			obj:          &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName, Annotations: map[string]string{"example.org/draining": "anything"}}},
+			passesFilter: true,
+		},
+		{
+			name:         "AnnotationValue",
+			obj:          &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName, Annotations: map[string]string{"example.org/state": "draining"}}},
+			passesFilter: true,
+		},
+		{
+			name:         "AnnotationOtherValue",
+			obj:          &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName, Annotations: map[string]string{"example.org/state": "idle"}}},
+			passesFilter: false,
+		},
+		{
+			name:         "NotANode",
+			obj:          &core.Pod{ObjectMeta: meta.ObjectMeta{Name: podName}},
+			passesFilter: false,
+		},
+	}
+
+	filter := NewNodeForeignDrainFilter(ForeignDrainTaints(), []string{"example.org/draining", "example.org/state=draining"})
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			passesFilter := filter(tc.obj)
+			if passesFilter != tc.passesFilter {
+				t.Errorf("filter(tc.obj): want %v, got %v", tc.passesFilter, passesFilter)
+			}
+		})
+	}
+}
+
+func TestNodeControlPlaneFilter(t *testing.T) {
+	cases := []struct {
+		name         string
+		obj          interface{}
+		passesFilter bool
+	}{
+		{
+			name:         "Master",
+			obj:          &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName, Labels: map[string]string{LabelNodeRoleMaster: ""}}},
+			passesFilter: true,
+		},
+		{
+			name:         "ControlPlane",
+			obj:          &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName, Labels: map[string]string{LabelNodeRoleControlPlane: ""}}},
+			passesFilter: true,
+		},
+		{
+			name:         "RoleMaster",
+			obj:          &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName, Labels: map[string]string{LabelRole: "master"}}},
+			passesFilter: true,
+		},
+		{
+			name:         "RoleNode",
+			obj:          &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName, Labels: map[string]string{LabelRole: "node"}}},
+			passesFilter: false,
+		},
+		{
+			name:         "NoLabels",
+			obj:          &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}},
+			passesFilter: false,
+		},
+		{
+			name:         "NotANode",
+			obj:          &core.Pod{ObjectMeta: meta.ObjectMeta{Name: podName}},
+			passesFilter: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			passesFilter := NodeControlPlaneFilter(tc.obj)
+			if passesFilter != tc.passesFilter {
+				t.Errorf("NodeControlPlaneFilter(tc.obj): want %v, got %v", tc.passesFilter, passesFilter)
+			}
+		})
+	}
+}
+
 func TestNodeProcessedFilter(t *testing.T) {
 	cases := []struct {
 		name         string
@@ -290,6 +638,57 @@ func TestNodeProcessedFilter(t *testing.T) {
 			obj:          &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName, UID: "a"}},
 			passesFilter: false,
 		},
+		{
+			name: "NodeAlreadyProcessedWithSameConditions",
+			existing: &core.Node{
+				ObjectMeta: meta.ObjectMeta{Name: nodeName, UID: "a"},
+				Status: core.NodeStatus{Conditions: []core.NodeCondition{
+					{Type: core.NodeReady, Status: core.ConditionTrue},
+					{Type: "KernelDeadlock", Status: core.ConditionTrue},
+				}},
+			},
+			obj: &core.Node{
+				ObjectMeta: meta.ObjectMeta{Name: nodeName, UID: "a"},
+				Status: core.NodeStatus{Conditions: []core.NodeCondition{
+					{Type: "KernelDeadlock", Status: core.ConditionTrue, Reason: "StillDeadlocked"},
+					{Type: core.NodeReady, Status: core.ConditionTrue},
+				}},
+			},
+			passesFilter: false,
+		},
+		{
+			name: "NodeAlreadyProcessedWithNewCondition",
+			existing: &core.Node{
+				ObjectMeta: meta.ObjectMeta{Name: nodeName, UID: "a"},
+				Status: core.NodeStatus{Conditions: []core.NodeCondition{
+					{Type: core.NodeReady, Status: core.ConditionTrue},
+				}},
+			},
+			obj: &core.Node{
+				ObjectMeta: meta.ObjectMeta{Name: nodeName, UID: "a"},
+				Status: core.NodeStatus{Conditions: []core.NodeCondition{
+					{Type: core.NodeReady, Status: core.ConditionTrue},
+					{Type: "KernelDeadlock", Status: core.ConditionTrue},
+				}},
+			},
+			passesFilter: true,
+		},
+		{
+			name: "NodeAlreadyProcessedWithChangedCondition",
+			existing: &core.Node{
+				ObjectMeta: meta.ObjectMeta{Name: nodeName, UID: "a"},
+				Status: core.NodeStatus{Conditions: []core.NodeCondition{
+					{Type: core.NodeReady, Status: core.ConditionTrue},
+				}},
+			},
+			obj: &core.Node{
+				ObjectMeta: meta.ObjectMeta{Name: nodeName, UID: "a"},
+				Status: core.NodeStatus{Conditions: []core.NodeCondition{
+					{Type: core.NodeReady, Status: core.ConditionUnknown},
+				}},
+			},
+			passesFilter: true,
+		},
 	}
 
 	for _, tc := range cases {
@@ -303,3 +702,56 @@ func TestNodeProcessedFilter(t *testing.T) {
 		})
 	}
 }
+
+func TestNodeShardFilter(t *testing.T) {
+	nodes := make([]*core.Node, 0, 100)
+	for i := 0; i < 100; i++ {
+		nodes = append(nodes, &core.Node{ObjectMeta: meta.ObjectMeta{
+			Name:   fmt.Sprintf("node-%d", i),
+			Labels: map[string]string{"pool": fmt.Sprintf("pool-%d", i%10)},
+		}})
+	}
+	nodes = append(nodes, &core.Node{ObjectMeta: meta.ObjectMeta{Name: "unlabelled"}})
+
+	cases := []struct {
+		name  string
+		count int
+		label string
+	}{
+		{name: "ByName", count: 3},
+		{name: "ByLabel", count: 3, label: "pool"},
+		{name: "OneShard", count: 1},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			filters := make([]func(o interface{}) bool, tc.count)
+			for i := range filters {
+				filters[i] = NewNodeShardFilter(i, tc.count, tc.label)
+			}
+			shards := make(map[string]int)
+			for _, n := range nodes {
+				owners := 0
+				for i, f := range filters {
+					if f(n) {
+						owners++
+						if tc.label != "" {
+							// Nodes with the same label value share a shard.
+							k := n.GetLabels()["pool"]
+							if s, ok := shards[k]; ok && s != i {
+								t.Errorf("nodes with label pool=%s are in shards %d and %d", k, s, i)
+							}
+							shards[k] = i
+						}
+					}
+				}
+				if owners != 1 {
+					t.Errorf("node %s belongs to %d shards, want 1", n.GetName(), owners)
+				}
+			}
+			if NewNodeShardFilter(0, tc.count, tc.label)(&core.Pod{ObjectMeta: meta.ObjectMeta{Name: podName}}) {
+				t.Errorf("pod passed shard filter")
+			}
+		})
+	}
+}
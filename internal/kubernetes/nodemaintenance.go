@@ -0,0 +1,136 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// NodeMaintenanceResource is the medik8s NodeMaintenance resource.
+var NodeMaintenanceResource = schema.GroupVersionResource{
+	Group:    "nodemaintenance.medik8s.io",
+	Version:  "v1beta1",
+	Resource: "nodemaintenances",
+}
+
+// NodeMaintenance phases.
+const (
+	NodeMaintenanceRunning   = "Running"
+	NodeMaintenanceSucceeded = "Succeeded"
+	NodeMaintenanceFailed    = "Failed"
+)
+
+// A NodeMaintenanceController cordons and drains the node of each
+// NodeMaintenance, reporting progress in its status, and uncordons the node
+// once the NodeMaintenance is deleted.
+type NodeMaintenanceController struct {
+	*nodeResourceController
+}
+
+// NodeMaintenanceControllerOption configures a NodeMaintenanceController.
+type NodeMaintenanceControllerOption func(c *NodeMaintenanceController)
+
+// WithNodeMaintenanceLogger configures a NodeMaintenanceController to use the
+// supplied logger.
+func WithNodeMaintenanceLogger(l *zap.Logger) NodeMaintenanceControllerOption {
+	return func(c *NodeMaintenanceController) {
+		c.l = l
+	}
+}
+
+// WithNodeMaintenanceGroup configures a NodeMaintenanceController to watch
+// NodeMaintenances in the supplied API group, for example
+// nodemaintenance.kubevirt.io.
+func WithNodeMaintenanceGroup(group string) NodeMaintenanceControllerOption {
+	return func(c *NodeMaintenanceController) {
+		c.gvr.Group = group
+	}
+}
+
+// WithNodeMaintenanceDryRun configures a NodeMaintenanceController to request
+// drains without updating the finalizers or status of NodeMaintenances, or
+// uncordoning their nodes.
+func WithNodeMaintenanceDryRun() NodeMaintenanceControllerOption {
+	return func(c *NodeMaintenanceController) {
+		c.dryRun = true
+	}
+}
+
+// WithNodeMaintenanceKeys configures the annotation and finalizer keys a
+// NodeMaintenanceController reads and writes.
+func WithNodeMaintenanceKeys(k Keys) NodeMaintenanceControllerOption {
+	return func(c *NodeMaintenanceController) {
+		c.keys = k
+	}
+}
+
+// NewNodeMaintenanceController returns a NodeMaintenanceController that uses
+// the supplied DrainRequester to drain nodes.
+func NewNodeMaintenanceController(c dynamic.Interface, k kubernetes.Interface, nodes NodeStore, r DrainRequester, co ...NodeMaintenanceControllerOption) *NodeMaintenanceController {
+	mc := &NodeMaintenanceController{newNodeResourceController(c, k, nodes, r, "maintenance", nodeMaintenance{})}
+	mc.gvr = NodeMaintenanceResource
+	for _, o := range co {
+		o(mc)
+	}
+	mc.finalizer = mc.keys.NodeMaintenanceFinalizer
+	mc.watch()
+	return mc
+}
+
+// InMaintenance returns true if the supplied node is the subject of a
+// NodeMaintenance, and thus should not be uncordoned.
+func (c *NodeMaintenanceController) InMaintenance(o interface{}) bool {
+	return c.forNode(o)
+}
+
+// nodeMaintenance is the nodeResource of NodeMaintenances.
+type nodeMaintenance struct{}
+
+func (nodeMaintenance) node(u *unstructured.Unstructured) (string, error) {
+	name, _, _ := unstructured.NestedString(u.Object, "spec", "nodeName")
+	if name == "" {
+		return "", errors.New("node maintenance must specify spec.nodeName")
+	}
+	return name, nil
+}
+
+func (nodeMaintenance) finished(u *unstructured.Unstructured) bool {
+	phase, _, _ := unstructured.NestedString(u.Object, "status", "phase")
+	return phase == NodeMaintenanceSucceeded || phase == NodeMaintenanceFailed
+}
+
+func (nodeMaintenance) draining(u *unstructured.Unstructured) {
+	setNodeMaintenancePhase(u, NodeMaintenanceRunning, "")
+}
+
+func (nodeMaintenance) drained(u *unstructured.Unstructured, err error) {
+	if err != nil {
+		setNodeMaintenancePhase(u, NodeMaintenanceFailed, err.Error())
+		return
+	}
+	setNodeMaintenancePhase(u, NodeMaintenanceSucceeded, "")
+}
+
+func setNodeMaintenancePhase(u *unstructured.Unstructured, phase, lastError string) {
+	_ = unstructured.SetNestedField(u.Object, phase, "status", "phase")
+	_ = unstructured.SetNestedField(u.Object, lastError, "status", "lastError")
+}
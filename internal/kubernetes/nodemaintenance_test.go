@@ -0,0 +1,218 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const nodeMaintenanceName = "coolMaintenance"
+
+func newNodeMaintenance(deleting bool, node, phase string, finalizers ...string) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{}
+	u.SetAPIVersion(NodeMaintenanceResource.GroupVersion().String())
+	u.SetKind("NodeMaintenance")
+	u.SetName(nodeMaintenanceName)
+	u.SetUID("uid")
+	u.SetFinalizers(finalizers)
+	if deleting {
+		now := meta.Now()
+		u.SetDeletionTimestamp(&now)
+	}
+	if node != "" {
+		_ = unstructured.SetNestedField(u.Object, node, "spec", "nodeName")
+	}
+	if phase != "" {
+		_ = unstructured.SetNestedField(u.Object, phase, "status", "phase")
+	}
+	return u
+}
+
+func TestNodeMaintenanceController(t *testing.T) {
+	cordoned := &core.Node{
+		ObjectMeta: meta.ObjectMeta{Name: nodeName, Annotations: map[string]string{AnnotationCordoned: "2018-01-01T00:00:00Z"}},
+		Spec:       core.NodeSpec{Unschedulable: true},
+	}
+	nodes := staticNodeStore{cordoned}
+
+	cases := []struct {
+		name            string
+		maintenance     *unstructured.Unstructured
+		node            *core.Node
+		dryRun          bool
+		prefix          string
+		results         map[string]error
+		wantDrained     bool
+		wantPhase       string
+		wantFinalizer   bool
+		wantUncordoned  bool
+		wantMaintenance bool
+	}{
+		{
+			name:            "Drained",
+			maintenance:     newNodeMaintenance(false, nodeName, ""),
+			results:         map[string]error{nodeName: nil},
+			wantDrained:     true,
+			wantPhase:       NodeMaintenanceSucceeded,
+			wantFinalizer:   true,
+			wantMaintenance: true,
+		},
+		{
+			name:            "DrainFailed",
+			maintenance:     newNodeMaintenance(false, nodeName, "", NodeMaintenanceFinalizer),
+			results:         map[string]error{nodeName: errors.New("nope")},
+			wantDrained:     true,
+			wantPhase:       NodeMaintenanceFailed,
+			wantFinalizer:   true,
+			wantMaintenance: true,
+		},
+		{
+			name:          "NodeMissing",
+			maintenance:   newNodeMaintenance(false, "missingNode", ""),
+			wantPhase:     NodeMaintenanceFailed,
+			wantFinalizer: true,
+		},
+		{
+			name:            "AlreadySucceeded",
+			maintenance:     newNodeMaintenance(false, nodeName, NodeMaintenanceSucceeded, NodeMaintenanceFinalizer),
+			wantPhase:       NodeMaintenanceSucceeded,
+			wantFinalizer:   true,
+			wantMaintenance: true,
+		},
+		{
+			name:            "Deleting",
+			maintenance:     newNodeMaintenance(true, nodeName, NodeMaintenanceSucceeded, NodeMaintenanceFinalizer),
+			wantPhase:       NodeMaintenanceSucceeded,
+			wantUncordoned:  true,
+			wantMaintenance: true,
+		},
+		{
+			name:        "DeletingCordonedByOthers",
+			maintenance: newNodeMaintenance(true, nodeName, NodeMaintenanceSucceeded, NodeMaintenanceFinalizer),
+			node: &core.Node{
+				ObjectMeta: meta.ObjectMeta{Name: nodeName},
+				Spec:       core.NodeSpec{Unschedulable: true},
+			},
+			wantPhase:       NodeMaintenanceSucceeded,
+			wantMaintenance: true,
+		},
+		{
+			name:        "DeletingCordonedForCondition",
+			maintenance: newNodeMaintenance(true, nodeName, NodeMaintenanceSucceeded, NodeMaintenanceFinalizer),
+			node: &core.Node{
+				ObjectMeta: meta.ObjectMeta{Name: nodeName, Annotations: map[string]string{
+					AnnotationCordoned:     "2018-01-01T00:00:00Z",
+					AnnotationCordonReason: "KernelPanic",
+				}},
+				Spec: core.NodeSpec{Unschedulable: true},
+			},
+			wantPhase:       NodeMaintenanceSucceeded,
+			wantMaintenance: true,
+		},
+		{
+			name:            "DryRun",
+			maintenance:     newNodeMaintenance(false, nodeName, ""),
+			dryRun:          true,
+			results:         map[string]error{nodeName: nil},
+			wantDrained:     true,
+			wantMaintenance: true,
+		},
+		{
+			name:            "DeletingDryRun",
+			maintenance:     newNodeMaintenance(true, nodeName, NodeMaintenanceSucceeded, NodeMaintenanceFinalizer),
+			dryRun:          true,
+			wantPhase:       NodeMaintenanceSucceeded,
+			wantFinalizer:   true,
+			wantMaintenance: true,
+		},
+		{
+			name:            "DrainedWithKeyPrefix",
+			maintenance:     newNodeMaintenance(false, nodeName, ""),
+			prefix:          "example.org/",
+			results:         map[string]error{nodeName: nil},
+			wantDrained:     true,
+			wantPhase:       NodeMaintenanceSucceeded,
+			wantFinalizer:   true,
+			wantMaintenance: true,
+		},
+		{
+			name:            "DeletingWithoutFinalizer",
+			maintenance:     newNodeMaintenance(true, nodeName, NodeMaintenanceSucceeded),
+			wantPhase:       NodeMaintenanceSucceeded,
+			wantMaintenance: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), tc.maintenance)
+			node := cordoned
+			if tc.node != nil {
+				node = tc.node
+			}
+			k := fake.NewSimpleClientset(node.DeepCopy())
+			r := &fakeRequester{results: tc.results}
+			o := []NodeMaintenanceControllerOption{}
+			if tc.dryRun {
+				o = append(o, WithNodeMaintenanceDryRun())
+			}
+			keys := DefaultKeys()
+			if tc.prefix != "" {
+				keys = NewKeys(tc.prefix)
+				o = append(o, WithNodeMaintenanceKeys(keys))
+			}
+			mc := NewNodeMaintenanceController(c, k, nodes, r, o...)
+			mc.handle(tc.maintenance)
+
+			got, err := c.Resource(NodeMaintenanceResource).Get(nodeMaintenanceName, meta.GetOptions{})
+			if err != nil {
+				t.Fatalf("c.Resource(...).Get(%v): %v", nodeMaintenanceName, err)
+			}
+			if phase, _, _ := unstructured.NestedString(got.Object, "status", "phase"); phase != tc.wantPhase {
+				t.Errorf("phase: want %q, got %q", tc.wantPhase, phase)
+			}
+			if f := hasString(got.GetFinalizers(), keys.NodeMaintenanceFinalizer); f != tc.wantFinalizer {
+				t.Errorf("finalizer: want %v, got %v", tc.wantFinalizer, f)
+			}
+			if drained := len(r.requested) > 0; drained != tc.wantDrained {
+				t.Errorf("node drained: want %v, got %v", tc.wantDrained, drained)
+			}
+			n, err := k.CoreV1().Nodes().Get(nodeName, meta.GetOptions{})
+			if err != nil {
+				t.Fatalf("k.CoreV1().Nodes().Get(%v): %v", nodeName, err)
+			}
+			if uncordoned := !n.Spec.Unschedulable; uncordoned != tc.wantUncordoned {
+				t.Errorf("node uncordoned: want %v, got %v", tc.wantUncordoned, uncordoned)
+			}
+
+			if err := mc.i.GetStore().Add(tc.maintenance); err != nil {
+				t.Fatalf("mc.i.GetStore().Add(): %v", err)
+			}
+			if m := mc.InMaintenance(cordoned); m != tc.wantMaintenance {
+				t.Errorf("mc.InMaintenance(): want %v, got %v", tc.wantMaintenance, m)
+			}
+		})
+	}
+}
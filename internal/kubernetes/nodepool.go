@@ -0,0 +1,65 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"fmt"
+	"time"
+
+	core "k8s.io/api/core/v1"
+)
+
+// LabelGKENodePool is the label GKE adds to nodes. Its value is the name of the
+// node's node pool.
+const LabelGKENodePool = "cloud.google.com/gke-nodepool"
+
+// nodePool returns the value of the first of the supplied node pool labels
+// that the supplied node has, and false if it has none of them.
+func nodePool(n *core.Node, labels []string) (string, bool) {
+	for _, k := range labels {
+		if v, ok := n.GetLabels()[k]; ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// NewNodePoolUpgradeGate returns a DrainGateFunc that prevents drains of nodes
+// in node pools that appear to be mid-upgrade, i.e. whose nodes are running
+// more than one kubelet version. Node pools are identified by the supplied
+// label. Surge upgrades cordon, drain, and replace nodes themselves using
+// the pool's surge capacity, so draining more nodes in the pool at the same
+// time would disrupt more workloads than the upgrade was configured to.
+func NewNodePoolUpgradeGate(nodes NodeStore, label string) DrainGateFunc {
+	return func(n *core.Node, _ time.Time) (bool, string) {
+		pool, ok := n.GetLabels()[label]
+		if !ok {
+			return true, ""
+		}
+		versions := make(map[string]bool)
+		for _, o := range nodes.List() {
+			if o.GetLabels()[label] != pool {
+				continue
+			}
+			versions[o.Status.NodeInfo.KubeletVersion] = true
+		}
+		if len(versions) > 1 {
+			return false, fmt.Sprintf("node pool %s is being upgraded", pool)
+		}
+		return true, ""
+	}
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newVersionedNode(name, pool, version string) *core.Node {
+	return &core.Node{
+		ObjectMeta: meta.ObjectMeta{Name: name, Labels: map[string]string{LabelGKENodePool: pool}},
+		Status:     core.NodeStatus{NodeInfo: core.NodeSystemInfo{KubeletVersion: version}},
+	}
+}
+
+func TestNodePoolUpgradeGate(t *testing.T) {
+	nodes := staticNodeStore{
+		newVersionedNode("a", "cool", "v1.11.2"),
+		newVersionedNode("b", "cool", "v1.11.2"),
+		newVersionedNode("c", "lame", "v1.11.2"),
+		newVersionedNode("d", "lame", "v1.11.3"),
+	}
+
+	cases := []struct {
+		name     string
+		node     *core.Node
+		wantOpen bool
+	}{
+		{
+			name:     "PoolNotUpgrading",
+			node:     nodes[0],
+			wantOpen: true,
+		},
+		{
+			name:     "PoolUpgrading",
+			node:     nodes[2],
+			wantOpen: false,
+		},
+		{
+			name:     "NotInAPool",
+			node:     &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}},
+			wantOpen: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewNodePoolUpgradeGate(nodes, LabelGKENodePool)
+			if open, reason := g(tc.node, time.Now()); open != tc.wantOpen {
+				t.Errorf("g(%v): want open %v, got %v (%s)", tc.node.GetName(), tc.wantOpen, open, reason)
+			}
+		})
+	}
+}
+
+func TestNodePool(t *testing.T) {
+	labels := []string{LabelGKENodePool, "eks.amazonaws.com/nodegroup"}
+	cases := []struct {
+		name   string
+		labels map[string]string
+		want   string
+		wantOK bool
+	}{
+		{
+			name:   "GKE",
+			labels: map[string]string{LabelGKENodePool: "cool"},
+			want:   "cool",
+			wantOK: true,
+		},
+		{
+			name:   "EKS",
+			labels: map[string]string{"eks.amazonaws.com/nodegroup": "cool"},
+			want:   "cool",
+			wantOK: true,
+		},
+		{
+			name:   "NoPool",
+			labels: map[string]string{"cool": "very"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := nodePool(&core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName, Labels: tc.labels}}, labels)
+			if ok != tc.wantOK || got != tc.want {
+				t.Errorf("nodePool(...): want %q, %v, got %q, %v", tc.want, tc.wantOK, got, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+)
+
+// A nodeResource is a kind of custom resource that asks for its node to be
+// drained until it is deleted.
+type nodeResource interface {
+	// node returns the name of the node the supplied resource is for.
+	node(u *unstructured.Unstructured) (string, error)
+
+	// finished returns true if the supplied resource's drain has finished.
+	finished(u *unstructured.Unstructured) bool
+
+	// draining records in the supplied resource's status that its node is
+	// being drained.
+	draining(u *unstructured.Unstructured)
+
+	// drained records the result of the supplied resource's drain in its
+	// status.
+	drained(u *unstructured.Unstructured, err error)
+}
+
+// A nodeResourceController cordons and drains the node of each of a kind of
+// custom resource, and uncordons the node once the resource is deleted. It
+// adds a finalizer to each resource, so that it may uncordon the node before
+// the resource is removed.
+type nodeResourceController struct {
+	l         *zap.Logger
+	c         dynamic.Interface
+	k         kubernetes.Interface
+	nodes     NodeStore
+	r         DrainRequester
+	gvr       schema.GroupVersionResource
+	keys      Keys
+	kind      string
+	finalizer string
+	res       nodeResource
+	i         cache.SharedInformer
+	dryRun    bool
+
+	mu     sync.Mutex
+	active map[types.UID]bool
+}
+
+func newNodeResourceController(c dynamic.Interface, k kubernetes.Interface, nodes NodeStore, r DrainRequester, kind string, res nodeResource) *nodeResourceController {
+	return &nodeResourceController{
+		l:      zap.NewNop(),
+		c:      c,
+		k:      k,
+		nodes:  nodes,
+		r:      r,
+		keys:   DefaultKeys(),
+		kind:   kind,
+		res:    res,
+		active: make(map[types.UID]bool),
+	}
+}
+
+// watch the controller's resource. It must be called once the controller is
+// configured.
+func (c *nodeResourceController) watch() {
+	ri := c.c.Resource(c.gvr)
+	lw := &cache.ListWatch{
+		ListFunc:  func(o meta.ListOptions) (runtime.Object, error) { return ri.List(o) },
+		WatchFunc: func(o meta.ListOptions) (watch.Interface, error) { return ri.Watch(o) },
+	}
+	c.i = cache.NewSharedInformer(lw, &unstructured.Unstructured{}, 30*time.Minute)
+	c.i.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.handle,
+		UpdateFunc: func(_, o interface{}) { c.handle(o) },
+		DeleteFunc: c.forget,
+	})
+}
+
+// Run the controller until the supplied channel is closed.
+func (c *nodeResourceController) Run(stop <-chan struct{}) {
+	c.i.Run(stop)
+}
+
+// forNode returns true if the supplied node is the subject of a resource.
+func (c *nodeResourceController) forNode(o interface{}) bool {
+	n, ok := o.(*core.Node)
+	if !ok {
+		return false
+	}
+	for _, o := range c.i.GetStore().List() {
+		u, ok := o.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		if node, _ := c.res.node(u); node == n.GetName() {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *nodeResourceController) logger(u *unstructured.Unstructured) *zap.Logger {
+	if u.GetNamespace() == "" {
+		return c.l.With(zap.String(c.kind, u.GetName()))
+	}
+	return c.l.With(zap.String("namespace", u.GetNamespace()), zap.String(c.kind, u.GetName()))
+}
+
+func (c *nodeResourceController) handle(o interface{}) {
+	u, ok := o.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	node, nodeErr := c.res.node(u)
+	log := c.logger(u).With(zap.String("node", node))
+
+	if u.GetDeletionTimestamp() != nil {
+		if !hasString(u.GetFinalizers(), c.finalizer) {
+			return
+		}
+		if c.dryRun {
+			log.Info("Not uncordoning node in dry-run mode")
+			return
+		}
+		if err := c.end(node); err != nil {
+			log.Info("Cannot uncordon node", zap.Error(err))
+			return
+		}
+		if err := c.setFinalizer(u, false); err != nil {
+			log.Info("Cannot remove finalizer", zap.Error(err))
+		}
+		return
+	}
+	if !hasString(u.GetFinalizers(), c.finalizer) {
+		if err := c.setFinalizer(u, true); err != nil {
+			log.Info("Cannot add finalizer", zap.Error(err))
+			return
+		}
+	}
+	if c.res.finished(u) {
+		return
+	}
+
+	c.mu.Lock()
+	if c.active[u.GetUID()] {
+		c.mu.Unlock()
+		return
+	}
+	c.active[u.GetUID()] = true
+	c.mu.Unlock()
+
+	if nodeErr != nil {
+		c.finish(u, nodeErr)
+		return
+	}
+	n, err := c.nodes.Get(node)
+	if err != nil {
+		c.finish(u, err)
+		return
+	}
+	c.updateStatus(u, c.res.draining)
+
+	log.Info("Requesting drain", zap.String("reason", drainReason(u)))
+	if err := c.r.Request(n, time.Time{}, func(err error) { c.finish(u, err) }); err != nil {
+		c.finish(u, err)
+	}
+}
+
+// drainReason returns the reason the supplied resource gives for its drain, if
+// any.
+func drainReason(u *unstructured.Unstructured) string {
+	r, _, _ := unstructured.NestedString(u.Object, "spec", "reason")
+	return r
+}
+
+// forget the supplied deleted resource.
+func (c *nodeResourceController) forget(o interface{}) {
+	if d, ok := o.(cache.DeletedFinalStateUnknown); ok {
+		o = d.Obj
+	}
+	u, ok := o.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	delete(c.active, u.GetUID())
+	c.mu.Unlock()
+}
+
+// finish records the result of the supplied resource's drain.
+func (c *nodeResourceController) finish(u *unstructured.Unstructured, err error) {
+	if err != nil {
+		c.logger(u).Info("Cannot drain node", zap.Error(err))
+	}
+	c.updateStatus(u, func(fresh *unstructured.Unstructured) { c.res.drained(fresh, err) })
+	c.mu.Lock()
+	delete(c.active, u.GetUID())
+	c.mu.Unlock()
+}
+
+// end the drain of the named node by uncordoning it. Nodes that no longer
+// exist need not be uncordoned. Nor are nodes draino did not cordon, or
+// cordoned because they matched a condition or label rather than because their
+// drain was requested; those are left to whoever cordoned them.
+func (c *nodeResourceController) end(node string) error {
+	if node == "" {
+		return nil
+	}
+	n, err := c.k.CoreV1().Nodes().Get(node, meta.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "cannot get node %s", node)
+	}
+	if !c.keys.cordonedByDraino(n) || n.GetAnnotations()[c.keys.CordonReason] != "" {
+		c.l.Info("Not uncordoning node that was not cordoned for its drain", zap.String("node", node))
+		return nil
+	}
+	if err := uncordonNode(c.k, c.keys, node); err != nil && !apierrors.IsNotFound(errors.Cause(err)) {
+		return err
+	}
+	c.l.Info("Uncordoned node", zap.String("node", node), zap.String("resource", c.kind))
+	return nil
+}
+
+// updateStatus updates the status of the supplied resource using the supplied
+// function. Updates are retried if they conflict.
+func (c *nodeResourceController) updateStatus(u *unstructured.Unstructured, fn func(fresh *unstructured.Unstructured)) {
+	log := c.logger(u)
+	if c.dryRun {
+		log.Info("Not updating status in dry-run mode")
+		return
+	}
+	ri := c.c.Resource(c.gvr).Namespace(u.GetNamespace())
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		fresh, err := ri.Get(u.GetName(), meta.GetOptions{})
+		if err != nil {
+			return err
+		}
+		fn(fresh)
+		_, err = ri.UpdateStatus(fresh)
+		return err
+	})
+	if err != nil {
+		log.Info("Cannot update status", zap.Error(err))
+	}
+}
+
+func (c *nodeResourceController) setFinalizer(u *unstructured.Unstructured, set bool) error {
+	if c.dryRun {
+		c.logger(u).Info("Not updating finalizers in dry-run mode")
+		return nil
+	}
+	ri := c.c.Resource(c.gvr).Namespace(u.GetNamespace())
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		fresh, err := ri.Get(u.GetName(), meta.GetOptions{})
+		if err != nil {
+			return err
+		}
+		f := fresh.GetFinalizers()
+		if hasString(f, c.finalizer) == set {
+			return nil
+		}
+		if set {
+			f = append(f, c.finalizer)
+		} else {
+			f = removeString(f, c.finalizer)
+		}
+		fresh.SetFinalizers(f)
+		_, err = ri.Update(fresh)
+		return err
+	})
+	return errors.Wrapf(err, "cannot update finalizers of %s %s", c.kind, u.GetName())
+}
+
+func hasString(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(s []string, v string) []string {
+	out := make([]string, 0, len(s))
+	for _, e := range s {
+		if e != v {
+			out = append(out, e)
+		}
+	}
+	return out
+}
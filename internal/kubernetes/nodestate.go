@@ -0,0 +1,173 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// DefaultNodeStateInterval is the default time between recordings of node
+// state.
+const DefaultNodeStateInterval = 1 * time.Minute
+
+// A NodeStateRecorder periodically records how many nodes are currently
+// cordoned by draino, and how many of those draino failed to drain, as
+// determined by their annotations. It may also record how long it has been
+// since draino last drained a node successfully.
+type NodeStateRecorder struct {
+	nodes      NodeStore
+	filter     func(o interface{}) bool
+	interval   time.Duration
+	poolLabels []string
+	cluster    string
+	shard      string
+	drained    func() time.Time
+	keys       Keys
+
+	// pools that have been recorded, so that their gauges are reset once
+	// they no longer have any cordoned nodes.
+	pools map[string]bool
+}
+
+// NodeStateRecorderOption configures a NodeStateRecorder.
+type NodeStateRecorderOption func(r *NodeStateRecorder)
+
+// WithNodeStateInterval configures the time between recordings.
+func WithNodeStateInterval(i time.Duration) NodeStateRecorderOption {
+	return func(r *NodeStateRecorder) {
+		r.interval = i
+	}
+}
+
+// WithNodeStatePoolLabels configures the labels used to determine the node
+// pool with which each recording is tagged.
+func WithNodeStatePoolLabels(labels ...string) NodeStateRecorderOption {
+	return func(r *NodeStateRecorder) {
+		r.poolLabels = append(r.poolLabels, labels...)
+	}
+}
+
+// WithNodeStateClusterName configures the cluster name with which recordings
+// are tagged.
+func WithNodeStateClusterName(name string) NodeStateRecorderOption {
+	return func(r *NodeStateRecorder) {
+		r.cluster = name
+	}
+}
+
+// WithNodeStateShard configures the shard index with which recordings are
+// tagged.
+func WithNodeStateShard(index int) NodeStateRecorderOption {
+	return func(r *NodeStateRecorder) {
+		r.shard = strconv.Itoa(index)
+	}
+}
+
+// WithLastDrained configures a NodeStateRecorder to record the time elapsed
+// since that returned by the supplied function, typically the LastDrained
+// method of a DrainingResourceEventHandler.
+func WithLastDrained(fn func() time.Time) NodeStateRecorderOption {
+	return func(r *NodeStateRecorder) {
+		r.drained = fn
+	}
+}
+
+// WithNodeStateKeys configures the annotation keys used to determine which
+// nodes draino cordoned.
+func WithNodeStateKeys(k Keys) NodeStateRecorderOption {
+	return func(r *NodeStateRecorder) {
+		r.keys = k
+	}
+}
+
+// NewNodeStateRecorder returns a NodeStateRecorder that records the state of
+// the nodes in the supplied store for which the supplied filter returns true.
+func NewNodeStateRecorder(nodes NodeStore, filter func(o interface{}) bool, ro ...NodeStateRecorderOption) *NodeStateRecorder {
+	r := &NodeStateRecorder{
+		nodes:    nodes,
+		filter:   filter,
+		interval: DefaultNodeStateInterval,
+		pools:    make(map[string]bool),
+		keys:     DefaultKeys(),
+	}
+	for _, o := range ro {
+		o(r)
+	}
+	return r
+}
+
+// Run the recorder until the supplied channel is closed.
+func (r *NodeStateRecorder) Run(stop <-chan struct{}) {
+	wait.Until(r.record, r.interval, stop)
+}
+
+type nodeState struct {
+	cordoned    int64
+	drainFailed int64
+}
+
+func (r *NodeStateRecorder) record() {
+	r.recordNodes()
+	if r.drained != nil {
+		stats.Record(r.tags(), MeasureSinceDrained.M(time.Since(r.drained()).Seconds()))
+	}
+}
+
+func (r *NodeStateRecorder) tags() context.Context {
+	tags := context.Background()
+	if r.cluster != "" {
+		tags, _ = tag.New(tags, tag.Upsert(TagCluster, r.cluster)) // nolint:gosec
+	}
+	if r.shard != "" {
+		tags, _ = tag.New(tags, tag.Upsert(TagShard, r.shard)) // nolint:gosec
+	}
+	return tags
+}
+
+func (r *NodeStateRecorder) recordNodes() {
+	states := make(map[string]*nodeState)
+	for pool := range r.pools {
+		states[pool] = &nodeState{}
+	}
+	for _, n := range r.nodes.List() {
+		if !r.filter(n) || !r.keys.cordonedByDraino(n) {
+			continue
+		}
+		pool, _ := nodePool(n, r.poolLabels)
+		pool = tagValues.value(TagNodePool, pool)
+		s, ok := states[pool]
+		if !ok {
+			s = &nodeState{}
+			states[pool] = s
+		}
+		s.cordoned++
+		if _, failed := n.GetAnnotations()[r.keys.DrainFailed]; failed {
+			s.drainFailed++
+		}
+	}
+	for pool, s := range states {
+		r.pools[pool] = true
+		tags, _ := tag.New(r.tags(), tag.Upsert(TagNodePool, pool)) // nolint:gosec
+		stats.Record(tags, MeasureCordonedNodes.M(s.cordoned), MeasureDrainFailedNodes.M(s.drainFailed))
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNodeStateRecorder(t *testing.T) {
+	cordoned := &view.View{Name: "test_cordoned_nodes", Measure: MeasureCordonedNodes, Aggregation: view.LastValue(), TagKeys: []tag.Key{TagNodePool}}
+	failed := &view.View{Name: "test_drain_failed_nodes", Measure: MeasureDrainFailedNodes, Aggregation: view.LastValue(), TagKeys: []tag.Key{TagNodePool}}
+	since := &view.View{Name: "test_since_drained", Measure: MeasureSinceDrained, Aggregation: view.LastValue()}
+	if err := view.Register(cordoned, failed, since); err != nil {
+		t.Fatalf("view.Register(): %v", err)
+	}
+	defer view.Unregister(cordoned, failed, since)
+
+	node := func(name, pool string, unschedulable bool, annotations ...string) *core.Node {
+		n := &core.Node{
+			ObjectMeta: meta.ObjectMeta{Name: name, Labels: map[string]string{LabelGKENodePool: pool}, Annotations: map[string]string{}},
+			Spec:       core.NodeSpec{Unschedulable: unschedulable},
+		}
+		for _, k := range annotations {
+			n.Annotations[k] = "2018-01-01T00:00:00Z"
+		}
+		return n
+	}
+
+	nodes := staticNodeStore{
+		node("a", "pool-a", true, AnnotationCordoned),
+		node("b", "pool-a", true, AnnotationCordoned, AnnotationDrainFailed),
+		node("c", "pool-a", true),
+		node("d", "pool-a", false, AnnotationCordoned),
+		node("e", "pool-b", true, AnnotationCordoned, AnnotationDrainFailed),
+		node("f", "pool-c", true, AnnotationCordoned),
+	}
+	excluded := func(o interface{}) bool { return o.(*core.Node).GetName() != "f" }
+	drained := func() time.Time { return time.Now().Add(-1 * time.Hour) }
+	r := NewNodeStateRecorder(nodes, excluded, WithNodeStatePoolLabels(LabelGKENodePool), WithLastDrained(drained))
+	r.record()
+
+	// Once pool-b has no cordoned nodes it should be recorded as zero.
+	r.nodes = nodes[:4]
+	r.record()
+
+	cases := []struct {
+		view string
+		want map[string]float64
+	}{
+		{view: cordoned.Name, want: map[string]float64{"pool-a": 2, "pool-b": 0}},
+		{view: failed.Name, want: map[string]float64{"pool-a": 1, "pool-b": 0}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.view, func(t *testing.T) {
+			rows, err := view.RetrieveData(tc.view)
+			if err != nil {
+				t.Fatalf("view.RetrieveData(%v): %v", tc.view, err)
+			}
+			got := make(map[string]float64)
+			for _, row := range rows {
+				for _, tg := range row.Tags {
+					got[tg.Value] = row.Data.(*view.LastValueData).Value
+				}
+			}
+			if diff := deep.Equal(tc.want, got); diff != nil {
+				t.Errorf("view.RetrieveData(%v): want != got: %v", tc.view, diff)
+			}
+		})
+	}
+
+	rows, err := view.RetrieveData(since.Name)
+	if err != nil {
+		t.Fatalf("view.RetrieveData(%v): %v", since.Name, err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("view.RetrieveData(%v): want 1 row, got %d", since.Name, len(rows))
+	}
+	if got := rows[0].Data.(*view.LastValueData).Value; got < 3600 || got > 3660 {
+		t.Errorf("view.RetrieveData(%v): want ~3600 seconds, got %v", since.Name, got)
+	}
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// DecodeObjects decodes the Kubernetes objects in the supplied stream of YAML
+// or JSON documents, for example the output of `kubectl get -o yaml`. Lists of
+// objects are flattened.
+func DecodeObjects(r io.Reader) ([]runtime.Object, error) {
+	objects := []runtime.Object{}
+	d := scheme.Codecs.UniversalDeserializer()
+	yr := yaml.NewYAMLReader(bufio.NewReader(r))
+	for {
+		doc, err := yr.Read()
+		if err == io.EOF {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot read document")
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+		o, _, err := d.Decode(doc, nil, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot decode object")
+		}
+		l, ok := o.(*core.List)
+		if !ok {
+			objects = append(objects, o)
+			continue
+		}
+		for i, item := range l.Items {
+			o, _, err := d.Decode(item.Raw, nil, nil)
+			if err != nil {
+				return nil, errors.Wrapf(err, "cannot decode list item %d", i)
+			}
+			objects = append(objects, o)
+		}
+	}
+}
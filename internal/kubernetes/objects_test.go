@@ -0,0 +1,92 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-test/deep"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDecodeObjects(t *testing.T) {
+	cases := []struct {
+		name    string
+		docs    string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "List",
+			docs: `
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Node
+  metadata: {name: coolNode}
+- apiVersion: v1
+  kind: Pod
+  metadata: {name: coolPod, namespace: coolNamespace}
+`,
+			want: []string{"*v1.Node coolNode", "*v1.Pod coolPod"},
+		},
+		{
+			name: "Documents",
+			docs: `
+apiVersion: v1
+kind: Node
+metadata: {name: coolNode}
+---
+---
+apiVersion: policy/v1beta1
+kind: PodDisruptionBudget
+metadata: {name: coolPDB, namespace: coolNamespace}
+`,
+			want: []string{"*v1.Node coolNode", "*v1beta1.PodDisruptionBudget coolPDB"},
+		},
+		{
+			name:    "UnknownKind",
+			docs:    "apiVersion: v1\nkind: Nonsense\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			objects, err := DecodeObjects(strings.NewReader(tc.docs))
+			if err != nil {
+				if tc.wantErr {
+					return
+				}
+				t.Fatalf("DecodeObjects(): %v", err)
+			}
+			if tc.wantErr {
+				t.Fatalf("DecodeObjects(): want error, got nil")
+			}
+			got := []string{}
+			for _, o := range objects {
+				got = append(got, fmt.Sprintf("%T %s", o, o.(meta.Object).GetName()))
+			}
+			if diff := deep.Equal(tc.want, got); diff != nil {
+				t.Errorf("DecodeObjects(): want != got: %v", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+)
+
+// ConfigureReadOnly configures the supplied client config to refuse to send
+// any request that could change the state of the cluster, i.e. any request
+// other than a GET, HEAD, or OPTIONS request. Refused requests fail with an
+// error without being sent.
+func ConfigureReadOnly(c *rest.Config, l *zap.Logger) {
+	wrap := c.WrapTransport
+	c.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		if wrap != nil {
+			rt = wrap(rt)
+		}
+		return &readOnlyTransport{rt: rt, l: l}
+	}
+}
+
+type readOnlyTransport struct {
+	rt http.RoundTripper
+	l  *zap.Logger
+}
+
+func (t *readOnlyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return t.rt.RoundTrip(req)
+	}
+	if req.Body != nil {
+		req.Body.Close() // nolint:gosec
+	}
+	t.l.Debug("refused to send request in read only mode", zap.String("method", req.Method), zap.String("path", req.URL.Path))
+	return nil, errors.Errorf("refused to %s %s in read only mode", req.Method, req.URL.Path)
+}
+
+// NewLoggingEventRecorder returns a record.EventRecorder that logs events
+// rather than recording them.
+func NewLoggingEventRecorder(l *zap.Logger) record.EventRecorder {
+	return &loggingEventRecorder{l: l}
+}
+
+type loggingEventRecorder struct {
+	l *zap.Logger
+}
+
+func (r *loggingEventRecorder) log(o runtime.Object, t time.Time, eventtype, reason, message string) {
+	f := []zap.Field{zap.Time("time", t), zap.String("type", eventtype), zap.String("reason", reason), zap.String("message", message)}
+	switch obj := o.(type) {
+	case *core.ObjectReference:
+		f = append(f, zap.String("kind", obj.Kind), zap.String("namespace", obj.Namespace), zap.String("name", obj.Name))
+	case meta.Object:
+		if kinds, _, err := scheme.Scheme.ObjectKinds(o); err == nil {
+			f = append(f, zap.String("kind", kinds[0].Kind))
+		}
+		f = append(f, zap.String("namespace", obj.GetNamespace()), zap.String("name", obj.GetName()))
+	}
+	r.l.Info("event", f...)
+}
+
+func (r *loggingEventRecorder) Event(o runtime.Object, eventtype, reason, message string) {
+	r.log(o, time.Now(), eventtype, reason, message)
+}
+
+func (r *loggingEventRecorder) Eventf(o runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.log(o, time.Now(), eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+func (r *loggingEventRecorder) PastEventf(o runtime.Object, timestamp meta.Time, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.log(o, timestamp.Time, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+func (r *loggingEventRecorder) AnnotatedEventf(o runtime.Object, _ map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.log(o, time.Now(), eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	client "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestReadOnly(t *testing.T) {
+	requests := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}) // nolint:gosec
+	}))
+	defer s.Close()
+
+	c := &rest.Config{Host: s.URL}
+	ConfigureReadOnly(c, zap.NewNop())
+	cs, err := client.NewForConfig(c)
+	if err != nil {
+		t.Fatalf("client.NewForConfig(): %v", err)
+	}
+
+	n, err := cs.CoreV1().Nodes().Get(nodeName, meta.GetOptions{})
+	if err != nil {
+		t.Fatalf("cs.CoreV1().Nodes().Get(%v): %v", nodeName, err)
+	}
+	if _, err := cs.CoreV1().Nodes().Update(n); err == nil {
+		t.Errorf("cs.CoreV1().Nodes().Update(%v): want error, got nil", nodeName)
+	}
+	if err := cs.CoreV1().Nodes().Delete(nodeName, &meta.DeleteOptions{}); err == nil {
+		t.Errorf("cs.CoreV1().Nodes().Delete(%v): want error, got nil", nodeName)
+	}
+	if requests != 1 {
+		t.Errorf("requests: want 1, got %d", requests)
+	}
+}
+
+func TestLoggingEventRecorder(t *testing.T) {
+	b := &bytes.Buffer{}
+	l := zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(b), zap.InfoLevel))
+	r := NewLoggingEventRecorder(l)
+
+	r.Eventf(&core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}, core.EventTypeWarning, eventReasonCordonStarting, "Cordoning node %s", nodeName)
+	l.Sync() // nolint:gosec
+
+	got := map[string]interface{}{}
+	if err := json.NewDecoder(strings.NewReader(b.String())).Decode(&got); err != nil {
+		t.Fatalf("cannot decode log entry %q: %v", b.String(), err)
+	}
+	want := map[string]string{
+		"msg":     "event",
+		"type":    core.EventTypeWarning,
+		"reason":  eventReasonCordonStarting,
+		"message": "Cordoning node " + nodeName,
+		"kind":    "Node",
+		"name":    nodeName,
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("log entry %s: want %q, got %q", k, v, got[k])
+		}
+	}
+}
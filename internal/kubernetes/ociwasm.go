@@ -0,0 +1,414 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// OCIWasmPrefix is the prefix of references to OCI artifacts that hold
+// WebAssembly modules.
+const OCIWasmPrefix = "oci://"
+
+// DefaultOCIWasmPollInterval is the default time between checks of an OCI
+// artifact for new modules.
+const DefaultOCIWasmPollInterval = 1 * time.Minute
+
+const (
+	ociRequestTimeout = 30 * time.Second
+	ociDefaultTag     = "latest"
+
+	// ociMaxModuleSize limits the size of each module, which draino holds in
+	// memory as well as on disk.
+	ociMaxModuleSize = 64 << 20
+
+	ociMediaTypeManifest       = "application/vnd.oci.image.manifest.v1+json"
+	ociMediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+
+	ociAnnotationTitle = "org.opencontainers.image.title"
+)
+
+// ociWasmMediaTypes are the media types of the layers of an OCI artifact that
+// are WebAssembly modules.
+var ociWasmMediaTypes = map[string]bool{
+	"application/vnd.wasm.content.layer.v1+wasm":        true,
+	"application/vnd.module.wasm.content.layer.v1+wasm": true,
+	"application/wasm": true,
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+// OCIWasmModules supplies the WebAssembly modules stored as the layers of an
+// OCI artifact, for example one pushed by oras. Each layer of a WebAssembly
+// media type is a module, named by its title annotation. The artifact's
+// manifest is polled, so that modules pushed to a tag are fetched once without
+// restarting draino. Registries are accessed anonymously over HTTPS.
+type OCIWasmModules struct {
+	l          *zap.Logger
+	client     *http.Client
+	interval   time.Duration
+	ref        string
+	registry   string
+	repository string
+	reference  string
+
+	// token is a bearer token issued by the registry. It is used only by the
+	// goroutine that polls the registry.
+	token string
+
+	mu      sync.RWMutex
+	synced  bool
+	digest  string
+	modules map[string][]byte
+	err     error
+}
+
+// OCIWasmModulesOption configures an OCIWasmModules.
+type OCIWasmModulesOption func(s *OCIWasmModules)
+
+// WithOCIWasmLogger configures an OCIWasmModules to use the supplied logger.
+func WithOCIWasmLogger(l *zap.Logger) OCIWasmModulesOption {
+	return func(s *OCIWasmModules) {
+		s.l = l
+	}
+}
+
+// WithOCIWasmPollInterval configures the time between checks of the artifact
+// for new modules.
+func WithOCIWasmPollInterval(i time.Duration) OCIWasmModulesOption {
+	return func(s *OCIWasmModules) {
+		s.interval = i
+	}
+}
+
+// NewOCIWasmModules returns a WasmModuleSource that fetches modules from the
+// supplied OCI artifact reference, of the form
+// oci://REGISTRY/REPOSITORY[:TAG|@DIGEST]. The tag defaults to latest. The
+// source must be run in order to poll the artifact.
+func NewOCIWasmModules(ref string, o ...OCIWasmModulesOption) (*OCIWasmModules, error) {
+	registry, repository, reference, err := parseOCIReference(ref)
+	if err != nil {
+		return nil, err
+	}
+	s := &OCIWasmModules{
+		l:          zap.NewNop(),
+		client:     &http.Client{Timeout: ociRequestTimeout},
+		interval:   DefaultOCIWasmPollInterval,
+		ref:        ref,
+		registry:   registry,
+		repository: repository,
+		reference:  reference,
+	}
+	for _, so := range o {
+		so(s)
+	}
+	return s, nil
+}
+
+// parseOCIReference returns the registry, repository, and tag or digest of the
+// supplied oci:// reference.
+func parseOCIReference(ref string) (registry, repository, reference string, err error) {
+	if !strings.HasPrefix(ref, OCIWasmPrefix) {
+		return "", "", "", errors.Errorf("OCI artifact %q must be of the form %sREGISTRY/REPOSITORY[:TAG|@DIGEST]", ref, OCIWasmPrefix)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(ref, OCIWasmPrefix), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", "", errors.Errorf("OCI artifact %q must be of the form %sREGISTRY/REPOSITORY[:TAG|@DIGEST]", ref, OCIWasmPrefix)
+	}
+	registry, repository, reference = parts[0], parts[1], ociDefaultTag
+	switch {
+	case strings.Contains(repository, "@"):
+		i := strings.Index(repository, "@")
+		repository, reference = repository[:i], repository[i+1:]
+		if !strings.HasPrefix(reference, "sha256:") {
+			return "", "", "", errors.Errorf("OCI artifact %q must be referenced by a sha256 digest", ref)
+		}
+	case strings.LastIndex(repository, ":") > strings.LastIndex(repository, "/"):
+		i := strings.LastIndex(repository, ":")
+		repository, reference = repository[:i], repository[i+1:]
+	}
+	if repository == "" || reference == "" {
+		return "", "", "", errors.Errorf("OCI artifact %q must be of the form %sREGISTRY/REPOSITORY[:TAG|@DIGEST]", ref, OCIWasmPrefix)
+	}
+	return registry, repository, reference, nil
+}
+
+// Name returns the reference of the artifact.
+func (s *OCIWasmModules) Name() string {
+	return s.ref
+}
+
+// Run polls the artifact until the supplied channel is closed.
+func (s *OCIWasmModules) Run(stop <-chan struct{}) {
+	wait.Until(func() {
+		err := s.fetch()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.synced = true
+		if err != nil {
+			s.l.Info("Failed to fetch WebAssembly modules", zap.String("artifact", s.ref), zap.Error(err))
+			// Keep running the modules already fetched, if any.
+			if s.modules == nil {
+				s.err = err
+			}
+		}
+	}, s.interval, stop)
+}
+
+// HasSynced returns true once the artifact has been fetched, or failed to be.
+func (s *OCIWasmModules) HasSynced() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.synced
+}
+
+// Modules returns the modules most recently fetched. Their version is the
+// digest of the artifact's manifest.
+func (s *OCIWasmModules) Modules() (interface{}, map[string][]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.synced {
+		return nil, nil, errors.Errorf("OCI artifact %s has not been fetched yet", s.ref)
+	}
+	if s.modules == nil {
+		return nil, nil, errors.Wrapf(s.err, "cannot fetch OCI artifact %s", s.ref)
+	}
+	return s.digest, s.modules, nil
+}
+
+// fetch the artifact's manifest, and its modules if the manifest changed.
+func (s *OCIWasmModules) fetch() error {
+	body, err := s.get("manifests/"+s.reference, ociMediaTypeManifest+", "+ociMediaTypeDockerManifest)
+	if err != nil {
+		return errors.Wrap(err, "cannot get manifest")
+	}
+	sum := sha256.Sum256(body)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	if strings.HasPrefix(s.reference, "sha256:") && digest != s.reference {
+		return errors.Errorf("manifest has digest %s, not %s", digest, s.reference)
+	}
+
+	s.mu.RLock()
+	current := s.digest == digest
+	s.mu.RUnlock()
+	if current {
+		return nil
+	}
+
+	m := &ociManifest{}
+	if err := json.Unmarshal(body, m); err != nil {
+		return errors.Wrap(err, "cannot decode manifest")
+	}
+	if m.MediaType != "" && m.MediaType != ociMediaTypeManifest && m.MediaType != ociMediaTypeDockerManifest {
+		return errors.Errorf("manifest is of unsupported media type %s", m.MediaType)
+	}
+	modules := make(map[string][]byte)
+	for _, l := range m.Layers {
+		if !ociWasmMediaTypes[l.MediaType] {
+			continue
+		}
+		name := ociModuleName(l)
+		if _, ok := modules[name]; ok {
+			return errors.Errorf("artifact contains more than one module named %s", name)
+		}
+		if l.Size > ociMaxModuleSize {
+			return errors.Errorf("module %s is larger than %d bytes", name, ociMaxModuleSize)
+		}
+		b, err := s.get("blobs/"+l.Digest, "")
+		if err != nil {
+			return errors.Wrapf(err, "cannot get module %s", name)
+		}
+		sum := sha256.Sum256(b)
+		if got := "sha256:" + hex.EncodeToString(sum[:]); got != l.Digest {
+			return errors.Errorf("module %s has digest %s, not %s", name, got, l.Digest)
+		}
+		modules[name] = b
+	}
+	if len(modules) == 0 {
+		return errors.New("artifact contains no WebAssembly layers")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.digest, s.modules, s.err = digest, modules, nil
+	s.l.Info("Fetched WebAssembly modules", zap.String("artifact", s.ref), zap.String("digest", digest), zap.Int("modules", len(modules)))
+	return nil
+}
+
+// ociModuleName returns the name of the module stored in the supplied layer,
+// i.e. its title, or its digest if it has none.
+func ociModuleName(l ociDescriptor) string {
+	name := path.Base(l.Annotations[ociAnnotationTitle])
+	if name == "." || name == "/" {
+		name = strings.TrimPrefix(l.Digest, "sha256:")
+	}
+	if !strings.HasSuffix(name, wasmModuleSuffix) {
+		name += wasmModuleSuffix
+	}
+	return name
+}
+
+// get the supplied path of the repository, authenticating anonymously if the
+// registry challenges for a bearer token.
+func (s *OCIWasmModules) get(p, accept string) ([]byte, error) {
+	u := fmt.Sprintf("https://%s/v2/%s/%s", s.registry, s.repository, p)
+	rsp, err := s.do(u, accept)
+	if err != nil {
+		return nil, err
+	}
+	if rsp.StatusCode == http.StatusUnauthorized {
+		challenge := rsp.Header.Get("WWW-Authenticate")
+		rsp.Body.Close() // nolint:gosec
+		if err := s.authenticate(challenge); err != nil {
+			return nil, errors.Wrap(err, "cannot authenticate")
+		}
+		if rsp, err = s.do(u, accept); err != nil {
+			return nil, err
+		}
+	}
+	defer rsp.Body.Close() // nolint:gosec
+	if rsp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("registry returned %s", rsp.Status)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(rsp.Body, ociMaxModuleSize+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read response")
+	}
+	if len(b) > ociMaxModuleSize {
+		return nil, errors.Errorf("response is larger than %d bytes", ociMaxModuleSize)
+	}
+	return b, nil
+}
+
+func (s *OCIWasmModules) do(u, accept string) (*http.Response, error) {
+	r, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create request")
+	}
+	if accept != "" {
+		r.Header.Set("Accept", accept)
+	}
+	if s.token != "" {
+		r.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	rsp, err := s.client.Do(r)
+	return rsp, errors.Wrapf(err, "cannot get %s", u)
+}
+
+// authenticate requests an anonymous bearer token as described by the supplied
+// WWW-Authenticate challenge.
+func (s *OCIWasmModules) authenticate(challenge string) error {
+	params, ok := parseBearerChallenge(challenge)
+	if !ok || params["realm"] == "" {
+		return errors.Errorf("registry requires unsupported authentication %q", challenge)
+	}
+	q := url.Values{}
+	for _, k := range []string{"service", "scope"} {
+		if v, ok := params[k]; ok {
+			q.Set(k, v)
+		}
+	}
+	u := params["realm"]
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	rsp, err := s.client.Get(u)
+	if err != nil {
+		return errors.Wrap(err, "cannot request token")
+	}
+	defer rsp.Body.Close() // nolint:gosec
+	if rsp.StatusCode != http.StatusOK {
+		return errors.Errorf("token service returned %s", rsp.Status)
+	}
+	t := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(rsp.Body).Decode(&t); err != nil {
+		return errors.Wrap(err, "cannot decode token")
+	}
+	s.token = t.Token
+	if s.token == "" {
+		s.token = t.AccessToken
+	}
+	if s.token == "" {
+		return errors.New("token service returned no token")
+	}
+	return nil
+}
+
+// parseBearerChallenge returns the parameters of the supplied Bearer
+// WWW-Authenticate challenge, e.g. Bearer realm="https://r/token",scope="x".
+func parseBearerChallenge(challenge string) (map[string]string, bool) {
+	const scheme = "bearer "
+	if len(challenge) < len(scheme) || !strings.EqualFold(challenge[:len(scheme)], scheme) {
+		return nil, false
+	}
+	params := make(map[string]string)
+	rest := strings.TrimSpace(challenge[len(scheme):])
+	for rest != "" {
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			return nil, false
+		}
+		k := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = strings.TrimSpace(rest[eq+1:])
+		var v string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				return nil, false
+			}
+			v, rest = rest[1:end+1], rest[end+2:]
+		} else {
+			end := strings.Index(rest, ",")
+			if end < 0 {
+				end = len(rest)
+			}
+			v, rest = rest[:end], rest[end:]
+		}
+		params[k] = v
+		rest = strings.TrimPrefix(strings.TrimSpace(rest), ",")
+		rest = strings.TrimSpace(rest)
+	}
+	return params, true
+}
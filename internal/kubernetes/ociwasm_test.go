@@ -0,0 +1,374 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const ociToken = "t0k3n"
+
+func digestOf(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// registry is a fake OCI registry serving a single repository. It requires an
+// anonymous bearer token if auth is true.
+type registry struct {
+	t    *testing.T
+	auth bool
+
+	mu        sync.Mutex
+	manifests map[string][]byte
+	blobs     map[string][]byte
+}
+
+func newRegistry(t *testing.T, auth bool) *registry {
+	return &registry{t: t, auth: auth, manifests: make(map[string][]byte), blobs: make(map[string][]byte)}
+}
+
+// push the supplied layers to the supplied tag, returning the digest of the
+// manifest.
+func (r *registry) push(tag string, layers ...ociDescriptor) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, err := json.Marshal(ociManifest{MediaType: ociMediaTypeManifest, Layers: layers})
+	if err != nil {
+		r.t.Fatalf("json.Marshal(...): %v", err)
+	}
+	r.manifests[tag] = m
+	r.manifests[digestOf(m)] = m
+	return digestOf(m)
+}
+
+// layer stores the supplied blob, returning a layer descriptor of it.
+func (r *registry) layer(mediaType, title string, b []byte) ociDescriptor {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.blobs[digestOf(b)] = b
+	l := ociDescriptor{MediaType: mediaType, Digest: digestOf(b), Size: int64(len(b))}
+	if title != "" {
+		l.Annotations = map[string]string{ociAnnotationTitle: title}
+	}
+	return l
+}
+
+func (r *registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if req.URL.Path == "/token" {
+		if req.URL.Query().Get("scope") != "repository:filters:pull" {
+			http.Error(w, "bad scope", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": ociToken}) // nolint:gosec
+		return
+	}
+	if r.auth && req.Header.Get("Authorization") != "Bearer "+ociToken {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="https://`+req.Host+`/token",service="registry",scope="repository:filters:pull"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case strings.HasPrefix(req.URL.Path, "/v2/filters/manifests/"):
+		m, ok := r.manifests[strings.TrimPrefix(req.URL.Path, "/v2/filters/manifests/")]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", ociMediaTypeManifest)
+		w.Write(m) // nolint:gosec
+	case strings.HasPrefix(req.URL.Path, "/v2/filters/blobs/"):
+		b, ok := r.blobs[strings.TrimPrefix(req.URL.Path, "/v2/filters/blobs/")]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write(b) // nolint:gosec
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func newOCIWasmModules(t *testing.T, srv *httptest.Server, ref string) *OCIWasmModules {
+	t.Helper()
+	s, err := NewOCIWasmModules(OCIWasmPrefix + srv.Listener.Addr().String() + "/filters" + ref)
+	if err != nil {
+		t.Fatalf("NewOCIWasmModules(...): %v", err)
+	}
+	s.client = srv.Client()
+	return s
+}
+
+func TestParseOCIReference(t *testing.T) {
+	cases := []struct {
+		ref            string
+		wantRegistry   string
+		wantRepository string
+		wantReference  string
+		wantErr        bool
+	}{
+		{ref: "oci://ghcr.io/org/filters:v1", wantRegistry: "ghcr.io", wantRepository: "org/filters", wantReference: "v1"},
+		{ref: "oci://ghcr.io/org/filters", wantRegistry: "ghcr.io", wantRepository: "org/filters", wantReference: "latest"},
+		{ref: "oci://localhost:5000/filters", wantRegistry: "localhost:5000", wantRepository: "filters", wantReference: "latest"},
+		{ref: "oci://localhost:5000/filters@sha256:abc", wantRegistry: "localhost:5000", wantRepository: "filters", wantReference: "sha256:abc"},
+		{ref: "oci://ghcr.io/filters@md5:abc", wantErr: true},
+		{ref: "oci://ghcr.io/filters:", wantErr: true},
+		{ref: "oci://ghcr.io", wantErr: true},
+		{ref: "ghcr.io/filters", wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.ref, func(t *testing.T) {
+			registry, repository, reference, err := parseOCIReference(tc.ref)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseOCIReference(%q): want error %v, got %v", tc.ref, tc.wantErr, err)
+			}
+			if registry != tc.wantRegistry || repository != tc.wantRepository || reference != tc.wantReference {
+				t.Errorf("parseOCIReference(%q): want %v, %v, %v, got %v, %v, %v", tc.ref,
+					tc.wantRegistry, tc.wantRepository, tc.wantReference, registry, repository, reference)
+			}
+		})
+	}
+}
+
+func TestParseBearerChallenge(t *testing.T) {
+	cases := []struct {
+		challenge string
+		want      map[string]string
+		wantOK    bool
+	}{
+		{
+			challenge: `Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:a/b:pull"`,
+			want:      map[string]string{"realm": "https://auth.example.com/token", "service": "registry.example.com", "scope": "repository:a/b:pull"},
+			wantOK:    true,
+		},
+		{
+			challenge: `bearer realm=https://auth.example.com/token, service="a,b"`,
+			want:      map[string]string{"realm": "https://auth.example.com/token", "service": "a,b"},
+			wantOK:    true,
+		},
+		{challenge: `Basic realm="registry"`},
+		{challenge: `Bearer realm="unterminated`},
+		{challenge: ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.challenge, func(t *testing.T) {
+			got, ok := parseBearerChallenge(tc.challenge)
+			if ok != tc.wantOK {
+				t.Fatalf("parseBearerChallenge(%q): want ok %v, got %v", tc.challenge, tc.wantOK, ok)
+			}
+			if diff := deep.Equal(got, tc.want); diff != nil {
+				t.Errorf("parseBearerChallenge(%q): want != got: %v", tc.challenge, diff)
+			}
+		})
+	}
+}
+
+func TestOCIWasmModules(t *testing.T) {
+	cases := []struct {
+		name        string
+		auth        bool
+		push        func(r *registry) string
+		ref         func(digest string) string
+		wantModules map[string][]byte
+		wantErr     bool
+	}{
+		{
+			name: "Tag",
+			push: func(r *registry) string {
+				return r.push("v1",
+					r.layer("application/vnd.oci.image.config.v1+json", "", []byte("{}")),
+					r.layer("application/vnd.wasm.content.layer.v1+wasm", "a.wasm", []byte("a")),
+					r.layer("application/vnd.module.wasm.content.layer.v1+wasm", "b.wasm", []byte("b")),
+				)
+			},
+			ref:         func(_ string) string { return ":v1" },
+			wantModules: map[string][]byte{"a.wasm": []byte("a"), "b.wasm": []byte("b")},
+		},
+		{
+			name: "Authenticated",
+			auth: true,
+			push: func(r *registry) string {
+				return r.push("latest", r.layer("application/wasm", "a.wasm", []byte("a")))
+			},
+			ref:         func(_ string) string { return "" },
+			wantModules: map[string][]byte{"a.wasm": []byte("a")},
+		},
+		{
+			name: "Digest",
+			push: func(r *registry) string {
+				return r.push("v1", r.layer("application/wasm", "a.wasm", []byte("a")))
+			},
+			ref:         func(digest string) string { return "@" + digest },
+			wantModules: map[string][]byte{"a.wasm": []byte("a")},
+		},
+		{
+			name: "NamedByDigest",
+			push: func(r *registry) string {
+				return r.push("v1", r.layer("application/wasm", "", []byte("a")))
+			},
+			ref:         func(_ string) string { return ":v1" },
+			wantModules: map[string][]byte{strings.TrimPrefix(digestOf([]byte("a")), "sha256:") + ".wasm": []byte("a")},
+		},
+		{
+			name: "NoWasmLayers",
+			push: func(r *registry) string {
+				return r.push("v1", r.layer("application/vnd.oci.image.layer.v1.tar", "a.tar", []byte("a")))
+			},
+			ref:     func(_ string) string { return ":v1" },
+			wantErr: true,
+		},
+		{
+			name: "DuplicateModules",
+			push: func(r *registry) string {
+				return r.push("v1",
+					r.layer("application/wasm", "a.wasm", []byte("a")),
+					r.layer("application/wasm", "a.wasm", []byte("b")),
+				)
+			},
+			ref:     func(_ string) string { return ":v1" },
+			wantErr: true,
+		},
+		{
+			name: "CorruptBlob",
+			push: func(r *registry) string {
+				l := r.layer("application/wasm", "a.wasm", []byte("a"))
+				r.blobs[l.Digest] = []byte("corrupt")
+				return r.push("v1", l)
+			},
+			ref:     func(_ string) string { return ":v1" },
+			wantErr: true,
+		},
+		{
+			name:    "TagDoesNotExist",
+			push:    func(_ *registry) string { return "" },
+			ref:     func(_ string) string { return ":v1" },
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newRegistry(t, tc.auth)
+			srv := httptest.NewTLSServer(r)
+			defer srv.Close()
+			digest := tc.push(r)
+			s := newOCIWasmModules(t, srv, tc.ref(digest))
+
+			err := s.fetch()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("s.fetch(): want error %v, got %v", tc.wantErr, err)
+			}
+			if tc.wantErr {
+				return
+			}
+			s.synced = true
+			version, modules, err := s.Modules()
+			if err != nil {
+				t.Fatalf("s.Modules(): %v", err)
+			}
+			if version != digest {
+				t.Errorf("s.Modules(): want version %v, got %v", digest, version)
+			}
+			if diff := deep.Equal(modules, tc.wantModules); diff != nil {
+				t.Errorf("s.Modules(): want != got: %v", diff)
+			}
+		})
+	}
+}
+
+func TestOCIWasmModulesPushed(t *testing.T) {
+	r := newRegistry(t, false)
+	srv := httptest.NewTLSServer(r)
+	defer srv.Close()
+	first := r.push("latest", r.layer("application/wasm", "a.wasm", []byte("a")))
+
+	s := newOCIWasmModules(t, srv, "")
+	s.interval = 10 * time.Millisecond
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.Run(stop)
+	err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return s.HasSynced(), nil
+	})
+	if err != nil {
+		t.Fatal("s.HasSynced(): artifact was not fetched")
+	}
+	if version, _, err := s.Modules(); err != nil || version != first {
+		t.Fatalf("s.Modules(): want version %v, got %v, %v", first, version, err)
+	}
+
+	second := r.push("latest", r.layer("application/wasm", "a.wasm", []byte("b")))
+	err = wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		version, _, err := s.Modules()
+		return err == nil && version == second, nil
+	})
+	if err != nil {
+		t.Fatalf("s.Modules(): want version %v once pushed", second)
+	}
+
+	// The modules already fetched are kept if the artifact cannot be fetched.
+	r.mu.Lock()
+	delete(r.manifests, "latest")
+	r.mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	version, modules, err := s.Modules()
+	if err != nil {
+		t.Fatalf("s.Modules(): %v", err)
+	}
+	if version != second {
+		t.Errorf("s.Modules(): want version %v, got %v", second, version)
+	}
+	if diff := deep.Equal(modules, map[string][]byte{"a.wasm": []byte("b")}); diff != nil {
+		t.Errorf("s.Modules(): want != got: %v", diff)
+	}
+}
+
+func TestOCIWasmModulesNeverFetched(t *testing.T) {
+	r := newRegistry(t, false)
+	srv := httptest.NewTLSServer(r)
+	defer srv.Close()
+
+	s := newOCIWasmModules(t, srv, "")
+	if _, _, err := s.Modules(); err == nil {
+		t.Error("s.Modules(): want error before the artifact is fetched")
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.Run(stop)
+	err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return s.HasSynced(), nil
+	})
+	if err != nil {
+		t.Fatal("s.HasSynced(): artifact fetch was not attempted")
+	}
+	if _, _, err := s.Modules(); err == nil {
+		t.Error("s.Modules(): want error for an artifact that does not exist")
+	}
+}
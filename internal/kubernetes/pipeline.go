@@ -0,0 +1,259 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+)
+
+// DefaultNotifyTimeout is the default maximum time to wait for each
+// notification. Notifications delay cordoning, so it is shorter than
+// DefaultHookTimeout.
+const DefaultNotifyTimeout = 10 * time.Second
+
+// Pipeline steps.
+const (
+	// StepNotify calls any notify functions, for example a webhook, before
+	// the node is cordoned.
+	StepNotify = "notify"
+
+	// StepCordon cordons the node. Every pipeline includes it.
+	StepCordon = "cordon"
+
+	// StepSoak waits for a duration after the node is cordoned before its
+	// drain is scheduled.
+	StepSoak = "soak"
+
+	// StepPreHook calls any pre-drain functions.
+	StepPreHook = "pre-hook"
+
+	// StepDrain drains the node.
+	StepDrain = "drain"
+
+	// StepPostHook calls any post-drain functions.
+	StepPostHook = "post-hook"
+
+	// StepReplace calls any replace functions, for example to terminate the
+	// machine that backs the node so that it is replaced.
+	StepReplace = "replace"
+
+	// StepUncordon uncordons the node once it has been drained.
+	StepUncordon = "uncordon"
+)
+
+// steps are the pipeline steps in the order in which they run.
+var steps = []string{StepNotify, StepCordon, StepSoak, StepPreHook, StepDrain, StepPostHook, StepReplace, StepUncordon}
+
+// Built in pipelines, named after the NodeAction each takes.
+var (
+	PipelineCordon    = ActionCordon.String()
+	PipelineDrain     = ActionDrain.String()
+	PipelineTerminate = ActionTerminate.String()
+)
+
+// A Pipeline is the sequence of steps draino takes to remediate a node. Steps
+// always run in the order notify, cordon, soak, pre-hook, drain, post-hook,
+// replace, and uncordon; a pipeline determines which of them run.
+type Pipeline struct {
+	steps map[string]bool
+	soak  time.Duration
+}
+
+func newPipeline(s ...string) Pipeline {
+	p := Pipeline{steps: make(map[string]bool, len(s))}
+	for _, step := range s {
+		p.steps[step] = true
+	}
+	return p
+}
+
+// DefaultPipeline returns the pipeline draino uses unless configured
+// otherwise, i.e. that of ActionTerminate: notify, cordon, pre-hook, drain,
+// post-hook, and replace.
+func DefaultPipeline() Pipeline {
+	return ActionTerminate.Pipeline()
+}
+
+// BuiltinPipelines returns the pipelines of each NodeAction, by name.
+func BuiltinPipelines() map[string]Pipeline {
+	pipelines := make(map[string]Pipeline, len(actionNames))
+	for a, name := range actionNames {
+		pipelines[name] = a.Pipeline()
+	}
+	return pipelines
+}
+
+// ParsePipeline parses a comma separated list of pipeline steps, for example
+// cordon,soak=10m,drain,uncordon. Steps must be listed in the order in which
+// they run. The soak step must specify a duration. Every pipeline must include
+// the cordon step, and pipelines that include the pre-hook, post-hook,
+// replace, or uncordon steps must include the drain step.
+func ParsePipeline(s string) (Pipeline, error) {
+	p := Pipeline{steps: make(map[string]bool)}
+	last := -1
+	for _, step := range strings.Split(s, ",") {
+		step = strings.TrimSpace(step)
+		if strings.HasPrefix(step, StepSoak+"=") {
+			d, err := time.ParseDuration(strings.TrimPrefix(step, StepSoak+"="))
+			if err != nil {
+				return Pipeline{}, errors.Wrapf(err, "cannot parse soak duration of pipeline %q", s)
+			}
+			if d <= 0 {
+				return Pipeline{}, errors.Errorf("soak duration of pipeline %q must be positive", s)
+			}
+			step, p.soak = StepSoak, d
+		} else if step == StepSoak {
+			return Pipeline{}, errors.Errorf("soak step of pipeline %q must be of the form soak=DURATION", s)
+		}
+		i := stepIndex(step)
+		if i < 0 {
+			return Pipeline{}, errors.Errorf("unknown step %q in pipeline %q; must be one of %s", step, s, strings.Join(steps, ", "))
+		}
+		if i <= last {
+			return Pipeline{}, errors.Errorf("step %q of pipeline %q is out of order; steps must be listed in the order %s", step, s, strings.Join(steps, ", "))
+		}
+		last = i
+		p.steps[step] = true
+	}
+	if !p.Has(StepCordon) {
+		return Pipeline{}, errors.Errorf("pipeline %q must include the %s step", s, StepCordon)
+	}
+	if !p.Has(StepDrain) {
+		for _, step := range []string{StepPreHook, StepPostHook, StepReplace, StepUncordon} {
+			if p.Has(step) {
+				return Pipeline{}, errors.Errorf("pipeline %q must include the %s step to include the %s step", s, StepDrain, step)
+			}
+		}
+	}
+	return p, nil
+}
+
+func stepIndex(step string) int {
+	for i, s := range steps {
+		if s == step {
+			return i
+		}
+	}
+	return -1
+}
+
+// NewPipelines returns the built in pipelines along with the supplied
+// pipeline definitions, by name. Built in pipelines may not be redefined.
+func NewPipelines(definitions map[string]string) (map[string]Pipeline, error) {
+	pipelines := BuiltinPipelines()
+	for name, def := range definitions {
+		if _, ok := pipelines[name]; ok {
+			return nil, errors.Errorf("cannot redefine built in pipeline %s", name)
+		}
+		p, err := ParsePipeline(def)
+		if err != nil {
+			return nil, err
+		}
+		pipelines[name] = p
+	}
+	return pipelines, nil
+}
+
+// Has returns true if the pipeline includes the supplied step.
+func (p Pipeline) Has(step string) bool {
+	return p.steps[step]
+}
+
+// Soak returns how long the pipeline waits after cordoning a node before its
+// drain is scheduled.
+func (p Pipeline) Soak() time.Duration {
+	return p.soak
+}
+
+// Union returns a pipeline that includes the steps of both pipelines, soaking
+// for the longer of their soak durations.
+func (p Pipeline) Union(o Pipeline) Pipeline {
+	u := Pipeline{steps: make(map[string]bool, len(p.steps)+len(o.steps)), soak: p.soak}
+	for step := range p.steps {
+		u.steps[step] = true
+	}
+	for step := range o.steps {
+		u.steps[step] = true
+	}
+	if o.soak > u.soak {
+		u.soak = o.soak
+	}
+	return u
+}
+
+// Intersect returns a pipeline that includes only the steps included by both
+// pipelines, soaking for the shorter of their soak durations.
+func (p Pipeline) Intersect(o Pipeline) Pipeline {
+	i := Pipeline{steps: make(map[string]bool, len(p.steps))}
+	for step := range p.steps {
+		if o.steps[step] {
+			i.steps[step] = true
+		}
+	}
+	if i.steps[StepSoak] {
+		i.soak = p.soak
+		if o.soak < i.soak {
+			i.soak = o.soak
+		}
+	}
+	return i
+}
+
+func (p Pipeline) String() string {
+	s := make([]string, 0, len(p.steps))
+	for _, step := range steps {
+		if !p.steps[step] {
+			continue
+		}
+		if step == StepSoak {
+			step = StepSoak + "=" + p.soak.String()
+		}
+		s = append(s, step)
+	}
+	return strings.Join(s, ",")
+}
+
+// A NodePipelineFunc returns the pipeline draino should use to remediate the
+// supplied node.
+type NodePipelineFunc func(n *core.Node) Pipeline
+
+// NewReasonPipelineFunc returns a NodePipelineFunc that chooses a pipeline for
+// each node according to the reasons the supplied function returns for
+// cordoning it, for example those returned by NewCordonReasonFunc. Nodes use
+// the union of the pipelines of all of their reasons. Reasons that are not
+// assigned a pipeline, and nodes with no reasons, use the DefaultPipeline.
+func NewReasonPipelineFunc(reasons func(n *core.Node) []string, pipelines map[string]Pipeline) NodePipelineFunc {
+	return func(n *core.Node) Pipeline {
+		rs := reasons(n)
+		if len(rs) == 0 {
+			return DefaultPipeline()
+		}
+		u := newPipeline()
+		for _, r := range rs {
+			p, ok := pipelines[r]
+			if !ok {
+				p = DefaultPipeline()
+			}
+			u = u.Union(p)
+		}
+		return u
+	}
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+
+	core "k8s.io/api/core/v1"
+)
+
+func TestParsePipeline(t *testing.T) {
+	cases := []struct {
+		name    string
+		s       string
+		want    string
+		wantErr bool
+	}{
+		{name: "CordonOnly", s: "cordon", want: "cordon"},
+		{name: "Full", s: "notify,cordon,soak=10m,pre-hook,drain,post-hook,replace,uncordon", want: "notify,cordon,soak=10m0s,pre-hook,drain,post-hook,replace,uncordon"},
+		{name: "Spaces", s: "cordon, drain, uncordon", want: "cordon,drain,uncordon"},
+		{name: "UnknownStep", s: "cordon,reboot", wantErr: true},
+		{name: "OutOfOrder", s: "drain,cordon", wantErr: true},
+		{name: "Duplicate", s: "cordon,cordon", wantErr: true},
+		{name: "NoCordon", s: "notify", wantErr: true},
+		{name: "HookWithoutDrain", s: "cordon,post-hook", wantErr: true},
+		{name: "UncordonWithoutDrain", s: "cordon,uncordon", wantErr: true},
+		{name: "SoakWithoutDuration", s: "cordon,soak,drain", wantErr: true},
+		{name: "NegativeSoak", s: "cordon,soak=-1m,drain", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParsePipeline(tc.s)
+			if err != nil {
+				if tc.wantErr {
+					return
+				}
+				t.Fatalf("ParsePipeline(%v): %v", tc.s, err)
+			}
+			if tc.wantErr {
+				t.Fatalf("ParsePipeline(%v): want error", tc.s)
+			}
+			if got.String() != tc.want {
+				t.Errorf("ParsePipeline(%v): want %v, got %v", tc.s, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestNewPipelines(t *testing.T) {
+	cases := []struct {
+		name        string
+		definitions map[string]string
+		wantErr     bool
+	}{
+		{name: "Valid", definitions: map[string]string{"reset": "cordon,drain,uncordon"}},
+		{name: "Invalid", definitions: map[string]string{"reset": "drain"}, wantErr: true},
+		{name: "RedefinesBuiltin", definitions: map[string]string{PipelineCordon: "cordon,drain"}, wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := NewPipelines(tc.definitions)
+			if err != nil {
+				if tc.wantErr {
+					return
+				}
+				t.Fatalf("NewPipelines(%v): %v", tc.definitions, err)
+			}
+			if tc.wantErr {
+				t.Fatalf("NewPipelines(%v): want error", tc.definitions)
+			}
+			for _, name := range []string{PipelineCordon, PipelineDrain, PipelineTerminate, "reset"} {
+				if _, ok := got[name]; !ok {
+					t.Errorf("NewPipelines(%v): missing pipeline %s", tc.definitions, name)
+				}
+			}
+		})
+	}
+}
+
+func TestReasonPipelineFunc(t *testing.T) {
+	pipelines := BuiltinPipelines()
+	reset, err := ParsePipeline("cordon,soak=5m,drain,uncordon")
+	if err != nil {
+		t.Fatalf("ParsePipeline(): %v", err)
+	}
+	pipelines["DiskPressure"] = pipelines[PipelineCordon]
+	pipelines["MemoryPressure"] = pipelines[PipelineDrain]
+	pipelines["KernelDeadlock"] = pipelines[PipelineTerminate]
+	pipelines["FrequentKubeletRestart"] = reset
+
+	cases := []struct {
+		name    string
+		reasons []string
+		want    string
+	}{
+		{name: "CordonOnly", reasons: []string{"DiskPressure"}, want: "notify,cordon"},
+		{name: "Drain", reasons: []string{"MemoryPressure"}, want: "notify,cordon,pre-hook,drain"},
+		{name: "Union", reasons: []string{"DiskPressure", "FrequentKubeletRestart", "MemoryPressure"}, want: "notify,cordon,soak=5m0s,pre-hook,drain,uncordon"},
+		{name: "Unassigned", reasons: []string{"DiskPressure", "ReadonlyFilesystem"}, want: DefaultPipeline().String()},
+		{name: "NoReasons", want: DefaultPipeline().String()},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fn := NewReasonPipelineFunc(func(_ *core.Node) []string { return tc.reasons }, pipelines)
+			if got := fn(&core.Node{}); got.String() != tc.want {
+				t.Errorf("fn(%v): want %v, got %v", tc.reasons, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestPipelineIntersect(t *testing.T) {
+	cases := []struct {
+		name string
+		a    string
+		b    string
+		want string
+	}{
+		{name: "CordonOnly", a: "notify,cordon,pre-hook,drain", b: "notify,cordon", want: "notify,cordon"},
+		{name: "Disjoint", a: "cordon,drain,uncordon", b: "notify,cordon", want: "cordon"},
+		{name: "ShorterSoak", a: "cordon,soak=5m,drain", b: "cordon,soak=1m,drain,uncordon", want: "cordon,soak=1m0s,drain"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			a, err := ParsePipeline(tc.a)
+			if err != nil {
+				t.Fatalf("ParsePipeline(%v): %v", tc.a, err)
+			}
+			b, err := ParsePipeline(tc.b)
+			if err != nil {
+				t.Fatalf("ParsePipeline(%v): %v", tc.b, err)
+			}
+			if got := a.Intersect(b); got.String() != tc.want {
+				t.Errorf("%v.Intersect(%v): want %v, got %v", a, b, tc.want, got)
+			}
+		})
+	}
+}
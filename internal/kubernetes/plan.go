@@ -0,0 +1,200 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// defaultTerminationGracePeriod is the termination grace period of pods that
+// do not specify one.
+const defaultTerminationGracePeriod = 30 * time.Second
+
+// A NamedPodFilter is a PodFilterFunc with a name that describes why the pods
+// it filters are not evicted, for example "local storage".
+type NamedPodFilter struct {
+	Name   string
+	Filter PodFilterFunc
+}
+
+// A SkippedPod is a pod that would not be evicted.
+type SkippedPod struct {
+	Pod    core.Pod
+	Reason string
+}
+
+// A Budget is a PodDisruptionBudget that covers pods that would be evicted.
+type Budget struct {
+	Namespace          string
+	Name               string
+	DisruptionsAllowed int32
+	Pods               int
+}
+
+// A DrainPlan describes how a node would be drained.
+type DrainPlan struct {
+	Node string
+
+	// Evict and Skip are the pods that would and would not be evicted.
+	Evict []core.Pod
+	Skip  []SkippedPod
+
+	// Orphaned records, by namespace and name, the pods whose controller no
+	// longer exists. It is nil if no pods are orphaned.
+	Orphaned map[string]bool
+
+	// Budgets that cover the pods that would be evicted.
+	Budgets []Budget
+
+	// TooManyPods is true if the drain would be skipped because it would
+	// evict more pods than MaxPodsToEvict allows.
+	TooManyPods bool
+
+	// Estimate is how long the evicted pods could take to terminate, and
+	// Timeout how long draino would wait for them.
+	Estimate time.Duration
+	Timeout  time.Duration
+}
+
+// Plan how the supplied node would be drained, without draining it. Pods that
+// would not be evicted are attributed to the first of the supplied filters
+// that rejects them, which should be those the drainer was configured with.
+func (d *APICordonDrainer) Plan(n *core.Node, filters ...NamedPodFilter) (*DrainPlan, error) {
+	l, err := listPods(d.c.CoreV1().Pods(meta.NamespaceAll).List, meta.ListOptions{
+		FieldSelector: fields.SelectorFromSet(fields.Set{"spec.nodeName": n.GetName()}).String(),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot get pods for node %s", n.GetName())
+	}
+
+	p := &DrainPlan{Node: n.GetName()}
+	for _, pod := range l.Items {
+		passes, err := d.filter(pod)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot filter pod %s/%s", pod.GetNamespace(), pod.GetName())
+		}
+		if orphaned, err := IsOrphaned(d.c, pod); err == nil && orphaned {
+			if p.Orphaned == nil {
+				p.Orphaned = make(map[string]bool)
+			}
+			p.Orphaned[pod.GetNamespace()+"/"+pod.GetName()] = true
+		}
+		if passes {
+			p.Evict = append(p.Evict, pod)
+			continue
+		}
+		reason := "filtered"
+		for _, f := range filters {
+			if ok, err := f.Filter(pod); err == nil && !ok {
+				reason = f.Name
+				break
+			}
+		}
+		p.Skip = append(p.Skip, SkippedPod{Pod: pod, Reason: reason})
+	}
+	p.TooManyPods = d.maxPods > 0 && len(p.Evict) > d.maxPods && n.GetAnnotations()[d.keys.ForceDrain] != "true"
+	t := d.timingFor(n)
+	p.Timeout = d.drainTimeout(t, p.Evict)
+
+	p.Budgets, err = d.budgets(p.Evict)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot determine disruption budgets")
+	}
+
+	// Pods are evicted in parallel, so the drain takes as long as the pod
+	// that takes longest to terminate, unless a disruption budget allows
+	// fewer of the pods it covers to be evicted at once. Those pods are
+	// evicted in waves, assuming each wave's replacements become ready as
+	// soon as it terminates. Budgets that allow no disruptions block the
+	// drain for an unknowable time, so are not estimated.
+	var longest time.Duration
+	for _, pod := range p.Evict {
+		if grace := t.gracePeriodFor(pod); grace > longest {
+			longest = grace
+		}
+	}
+	p.Estimate = longest
+	for _, b := range p.Budgets {
+		allowed := int(b.DisruptionsAllowed)
+		if allowed <= 0 || b.Pods <= allowed {
+			continue
+		}
+		waves := (b.Pods + allowed - 1) / allowed
+		if e := time.Duration(waves) * longest; e > p.Estimate {
+			p.Estimate = e
+		}
+	}
+	if p.Estimate > p.Timeout {
+		p.Estimate = p.Timeout
+	}
+	return p, nil
+}
+
+// Blocked returns the budgets that allow none of the pods they cover to be
+// evicted, formatted as NAMESPACE/NAME.
+func (p *DrainPlan) Blocked() []string {
+	var blocked []string
+	for _, b := range p.Budgets {
+		if b.DisruptionsAllowed <= 0 {
+			blocked = append(blocked, b.Namespace+"/"+b.Name)
+		}
+	}
+	return blocked
+}
+
+func (d *APICordonDrainer) budgets(pods []core.Pod) ([]Budget, error) {
+	namespaces := make(map[string][]core.Pod)
+	for _, pod := range pods {
+		namespaces[pod.GetNamespace()] = append(namespaces[pod.GetNamespace()], pod)
+	}
+	budgets := []Budget{}
+	for ns, pods := range namespaces {
+		l, err := d.c.PolicyV1beta1().PodDisruptionBudgets(ns).List(meta.ListOptions{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot list pod disruption budgets in namespace %s", ns)
+		}
+		for _, pdb := range l.Items {
+			s, err := meta.LabelSelectorAsSelector(pdb.Spec.Selector)
+			if err != nil {
+				return nil, errors.Wrapf(err, "cannot parse selector of pod disruption budget %s/%s", ns, pdb.GetName())
+			}
+			b := Budget{Namespace: ns, Name: pdb.GetName(), DisruptionsAllowed: pdb.Status.PodDisruptionsAllowed}
+			for _, pod := range pods {
+				if !s.Empty() && s.Matches(labels.Set(pod.GetLabels())) {
+					b.Pods++
+				}
+			}
+			if b.Pods > 0 {
+				budgets = append(budgets, b)
+			}
+		}
+	}
+	sort.Slice(budgets, func(i, j int) bool {
+		if budgets[i].Namespace != budgets[j].Namespace {
+			return budgets[i].Namespace < budgets[j].Namespace
+		}
+		return budgets[i].Name < budgets[j].Name
+	})
+	return budgets, nil
+}
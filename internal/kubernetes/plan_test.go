@@ -0,0 +1,193 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1beta1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPlan(t *testing.T) {
+	grace := int64(600)
+	isController := true
+	web := core.Pod{
+		ObjectMeta: meta.ObjectMeta{
+			Namespace:       ns,
+			Name:            "web",
+			Labels:          map[string]string{"app": "web"},
+			OwnerReferences: []meta.OwnerReference{{Kind: "ReplicaSet", Name: "web", Controller: &isController}},
+		},
+		Spec: core.PodSpec{NodeName: nodeName, TerminationGracePeriodSeconds: &grace},
+	}
+	db := core.Pod{
+		ObjectMeta: meta.ObjectMeta{
+			Namespace:       ns,
+			Name:            "db",
+			Labels:          map[string]string{"app": "db"},
+			OwnerReferences: []meta.OwnerReference{{Kind: "StatefulSet", Name: "db", Controller: &isController}},
+		},
+		Spec: core.PodSpec{NodeName: nodeName},
+	}
+	scratch := core.Pod{
+		ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: "scratch"},
+		Spec:       core.PodSpec{NodeName: nodeName, Volumes: []core.Volume{{VolumeSource: core.VolumeSource{EmptyDir: &core.EmptyDirVolumeSource{}}}}},
+	}
+	webRS := &apps.ReplicaSet{ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: "web"}}
+	dbSTS := &apps.StatefulSet{ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: "db"}}
+	pdb := &policy.PodDisruptionBudget{
+		ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: "db"},
+		Spec:       policy.PodDisruptionBudgetSpec{Selector: &meta.LabelSelector{MatchLabels: map[string]string{"app": "db"}}},
+	}
+
+	cases := []struct {
+		name        string
+		maxPods     int
+		annotations map[string]string
+		controllers []runtime.Object
+		want        *DrainPlan
+	}{
+		{
+			name:        "Drained",
+			controllers: []runtime.Object{webRS, dbSTS},
+			want: &DrainPlan{
+				Node:     nodeName,
+				Evict:    []core.Pod{db, web},
+				Skip:     []SkippedPod{{Pod: scratch, Reason: "uses local storage"}},
+				Budgets:  []Budget{{Namespace: ns, Name: "db", Pods: 1}},
+				Estimate: 5 * time.Minute,
+				Timeout:  5*time.Minute + DefaultEvictionOverhead,
+			},
+		},
+		{
+			name:        "TooManyPods",
+			maxPods:     1,
+			controllers: []runtime.Object{webRS, dbSTS},
+			want: &DrainPlan{
+				Node:        nodeName,
+				Evict:       []core.Pod{db, web},
+				Skip:        []SkippedPod{{Pod: scratch, Reason: "uses local storage"}},
+				Budgets:     []Budget{{Namespace: ns, Name: "db", Pods: 1}},
+				TooManyPods: true,
+				Estimate:    5 * time.Minute,
+				Timeout:     5*time.Minute + DefaultEvictionOverhead,
+			},
+		},
+		{
+			name:        "NodeOverridesTiming",
+			annotations: map[string]string{AnnotationMaxGracePeriod: "1m", AnnotationEvictionHeadroom: "5m"},
+			controllers: []runtime.Object{webRS, dbSTS},
+			want: &DrainPlan{
+				Node:     nodeName,
+				Evict:    []core.Pod{db, web},
+				Skip:     []SkippedPod{{Pod: scratch, Reason: "uses local storage"}},
+				Budgets:  []Budget{{Namespace: ns, Name: "db", Pods: 1}},
+				Estimate: 1 * time.Minute,
+				Timeout:  6 * time.Minute,
+			},
+		},
+		{
+			name:        "Orphaned",
+			controllers: []runtime.Object{dbSTS},
+			want: &DrainPlan{
+				Node:     nodeName,
+				Evict:    []core.Pod{db, web},
+				Skip:     []SkippedPod{{Pod: scratch, Reason: "uses local storage"}},
+				Orphaned: map[string]bool{ns + "/web": true},
+				Budgets:  []Budget{{Namespace: ns, Name: "db", Pods: 1}},
+				Estimate: 5 * time.Minute,
+				Timeout:  5*time.Minute + DefaultEvictionOverhead,
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			objs := append([]runtime.Object{&core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}, &db, &scratch, &web, pdb}, tc.controllers...)
+			c := fake.NewSimpleClientset(objs...)
+			filters := []NamedPodFilter{
+				{Name: "mirror pod", Filter: MirrorPodFilter},
+				{Name: "uses local storage", Filter: LocalStoragePodFilter},
+			}
+			d := NewAPICordonDrainer(c,
+				MaxGracePeriod(5*time.Minute),
+				MaxPodsToEvict(tc.maxPods),
+				WithPodFilter(NewPodFilters(MirrorPodFilter, LocalStoragePodFilter)))
+
+			got, err := d.Plan(&core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName, Annotations: tc.annotations}}, filters...)
+			if err != nil {
+				t.Fatalf("d.Plan(): %v", err)
+			}
+			if diff := deep.Equal(tc.want, got); diff != nil {
+				t.Errorf("d.Plan(): want != got: %v", diff)
+			}
+		})
+	}
+}
+
+func TestPlanEstimatesBudgetWaves(t *testing.T) {
+	grace := int64(60)
+	pods := []runtime.Object{&core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}}
+	for _, name := range []string{"a", "b", "c"} {
+		pods = append(pods, &core.Pod{
+			ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: name, Labels: map[string]string{"app": "web"}},
+			Spec:       core.PodSpec{NodeName: nodeName, TerminationGracePeriodSeconds: &grace},
+		})
+	}
+
+	cases := []struct {
+		name         string
+		allowed      int32
+		wantEstimate time.Duration
+		wantBlocked  []string
+	}{
+		{name: "AllowsAll", allowed: 3, wantEstimate: time.Minute},
+		{name: "AllowsOne", allowed: 1, wantEstimate: 3 * time.Minute},
+		{name: "AllowsTwo", allowed: 2, wantEstimate: 2 * time.Minute},
+		{name: "AllowsNone", allowed: 0, wantEstimate: time.Minute, wantBlocked: []string{ns + "/web"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			pdb := &policy.PodDisruptionBudget{
+				ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: "web"},
+				Spec:       policy.PodDisruptionBudgetSpec{Selector: &meta.LabelSelector{MatchLabels: map[string]string{"app": "web"}}},
+				Status:     policy.PodDisruptionBudgetStatus{PodDisruptionsAllowed: tc.allowed},
+			}
+			c := fake.NewSimpleClientset(append(pods, pdb)...)
+			d := NewAPICordonDrainer(c, MaxGracePeriod(5*time.Minute), DrainTimeout(time.Minute, time.Minute))
+
+			p, err := d.Plan(&core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}})
+			if err != nil {
+				t.Fatalf("d.Plan(): %v", err)
+			}
+			if p.Estimate != tc.wantEstimate {
+				t.Errorf("p.Estimate: want %s, got %s", tc.wantEstimate, p.Estimate)
+			}
+			if diff := deep.Equal(tc.wantBlocked, p.Blocked()); diff != nil {
+				t.Errorf("p.Blocked(): want != got: %v", diff)
+			}
+		})
+	}
+}
@@ -81,6 +81,64 @@ func NewDaemonSetPodFilter(client kubernetes.Interface) PodFilterFunc {
 	}
 }
 
+// Orphaned pod policies.
+const (
+	// OrphanedPodPolicyEvict evicts pods whose controller no longer exists.
+	OrphanedPodPolicyEvict = "evict"
+
+	// OrphanedPodPolicyProtect protects pods whose controller no longer
+	// exists from eviction.
+	OrphanedPodPolicyProtect = "protect"
+)
+
+// NewOrphanedPodFilter returns a FilterFunc that returns true if the supplied
+// pod is not orphaned, i.e. if it has no controller or its controller exists.
+func NewOrphanedPodFilter(client kubernetes.Interface) PodFilterFunc {
+	return func(p core.Pod) (bool, error) {
+		orphaned, err := IsOrphaned(client, p)
+		if err != nil {
+			return false, err
+		}
+		return !orphaned, nil
+	}
+}
+
+// IsOrphaned returns true if the supplied pod's controller no longer exists,
+// or has been replaced by a different object of the same name. Pods without
+// a controller, and pods whose controller is not a ReplicaSet, StatefulSet,
+// DaemonSet, Job, or ReplicationController, are never orphaned.
+func IsOrphaned(client kubernetes.Interface, p core.Pod) (bool, error) {
+	c := meta.GetControllerOf(&p)
+	if c == nil {
+		return false, nil
+	}
+	var (
+		o   meta.Object
+		err error
+	)
+	switch c.Kind {
+	case "ReplicaSet":
+		o, err = client.AppsV1().ReplicaSets(p.GetNamespace()).Get(c.Name, meta.GetOptions{})
+	case "StatefulSet":
+		o, err = client.AppsV1().StatefulSets(p.GetNamespace()).Get(c.Name, meta.GetOptions{})
+	case kindDaemonSet:
+		o, err = client.AppsV1().DaemonSets(p.GetNamespace()).Get(c.Name, meta.GetOptions{})
+	case "Job":
+		o, err = client.BatchV1().Jobs(p.GetNamespace()).Get(c.Name, meta.GetOptions{})
+	case "ReplicationController":
+		o, err = client.CoreV1().ReplicationControllers(p.GetNamespace()).Get(c.Name, meta.GetOptions{})
+	default:
+		return false, nil
+	}
+	if apierrors.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "cannot get %s %s/%s", c.Kind, p.GetNamespace(), c.Name)
+	}
+	return c.UID != "" && o.GetUID() != c.UID, nil
+}
+
 // UnprotectedPodFilter returns a FilterFunc that returns true if the
 // supplied pod does not have any of the user-specified annotations for
 // protection from eviction
@@ -20,6 +20,7 @@ import (
 	"testing"
 
 	"github.com/pkg/errors"
+	apps "k8s.io/api/apps/v1"
 	core "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -168,6 +169,102 @@ func TestPodFilters(t *testing.T) {
 			})),
 			passesFilter: true,
 		},
+		{
+			name: "OrphanedFromReplicaSet",
+			pod: core.Pod{
+				ObjectMeta: meta.ObjectMeta{
+					Name: podName,
+					OwnerReferences: []meta.OwnerReference{meta.OwnerReference{
+						Controller: &isController,
+						Kind:       "ReplicaSet",
+						Name:       "coolReplicaSet",
+						UID:        "a",
+					}},
+				},
+			},
+			filter: NewOrphanedPodFilter(newFakeClientSet(reactor{
+				verb:     "get",
+				resource: "replicasets",
+				err:      apierrors.NewNotFound(schema.GroupResource{Resource: "replicasets"}, "coolReplicaSet"),
+			})),
+			passesFilter: false,
+		},
+		{
+			name: "ReplicaSetExists",
+			pod: core.Pod{
+				ObjectMeta: meta.ObjectMeta{
+					Name: podName,
+					OwnerReferences: []meta.OwnerReference{meta.OwnerReference{
+						Controller: &isController,
+						Kind:       "ReplicaSet",
+						Name:       "coolReplicaSet",
+						UID:        "a",
+					}},
+				},
+			},
+			filter: NewOrphanedPodFilter(newFakeClientSet(reactor{
+				verb:     "get",
+				resource: "replicasets",
+				ret:      &apps.ReplicaSet{ObjectMeta: meta.ObjectMeta{Name: "coolReplicaSet", UID: "a"}},
+			})),
+			passesFilter: true,
+		},
+		{
+			name: "ReplicaSetReplaced",
+			pod: core.Pod{
+				ObjectMeta: meta.ObjectMeta{
+					Name: podName,
+					OwnerReferences: []meta.OwnerReference{meta.OwnerReference{
+						Controller: &isController,
+						Kind:       "ReplicaSet",
+						Name:       "coolReplicaSet",
+						UID:        "a",
+					}},
+				},
+			},
+			filter: NewOrphanedPodFilter(newFakeClientSet(reactor{
+				verb:     "get",
+				resource: "replicasets",
+				ret:      &apps.ReplicaSet{ObjectMeta: meta.ObjectMeta{Name: "coolReplicaSet", UID: "b"}},
+			})),
+			passesFilter: false,
+		},
+		{
+			name: "ErrorGettingStatefulSet",
+			pod: core.Pod{
+				ObjectMeta: meta.ObjectMeta{
+					Name: podName,
+					OwnerReferences: []meta.OwnerReference{meta.OwnerReference{
+						Controller: &isController,
+						Kind:       "StatefulSet",
+						Name:       "coolStatefulSet",
+						UID:        "a",
+					}},
+				},
+			},
+			filter: NewOrphanedPodFilter(newFakeClientSet(reactor{
+				verb:     "get",
+				resource: "statefulsets",
+				err:      errExploded,
+			})),
+			passesFilter: false,
+			errFn:        func(err error) bool { return errors.Cause(err) == errExploded },
+		},
+		{
+			name: "OrphanedFromUnknownKind",
+			pod: core.Pod{
+				ObjectMeta: meta.ObjectMeta{
+					Name: podName,
+					OwnerReferences: []meta.OwnerReference{meta.OwnerReference{
+						Controller: &isController,
+						Kind:       "CoolController",
+						Name:       "coolController",
+					}},
+				},
+			},
+			filter:       NewOrphanedPodFilter(newFakeClientSet()),
+			passesFilter: true,
+		},
 		{
 			name: "NotPartOfDaemonSet",
 			pod: core.Pod{
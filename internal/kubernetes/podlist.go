@@ -0,0 +1,49 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// podListPageSize is the maximum number of pods requested at once, so that
+// listing the pods of a large cluster does not require the API server to
+// return them all in a single response.
+const podListPageSize = 500
+
+// listPods lists the pods matching the supplied options using the supplied
+// list function, for example that of a PodInterface, a page at a time. The
+// pages are combined into a single list. An error is returned if the list
+// changes so much while it is being paged through that the API server expires
+// it, in which case the list should simply be retried.
+func listPods(list func(o meta.ListOptions) (*core.PodList, error), o meta.ListOptions) (*core.PodList, error) {
+	o.Limit = podListPageSize
+	all := &core.PodList{}
+	for {
+		l, err := list(o)
+		if err != nil {
+			return nil, err
+		}
+		all.Items = append(all.Items, l.Items...)
+		all.ResourceVersion = l.ResourceVersion
+		if l.Continue == "" {
+			return all, nil
+		}
+		o.Continue = l.Continue
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestListPods(t *testing.T) {
+	pods := make([]core.Pod, 2*podListPageSize+1)
+	for i := range pods {
+		pods[i] = core.Pod{ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: strconv.Itoa(i)}}
+	}
+	// pages serves pods a page at a time, using the index of the next pod as
+	// the continue token.
+	pages := func(o meta.ListOptions) (*core.PodList, error) {
+		if o.FieldSelector != "spec.nodeName=coolNode" {
+			return nil, errors.Errorf("incorrect field selector: %v", o.FieldSelector)
+		}
+		start := 0
+		if o.Continue != "" {
+			start, _ = strconv.Atoi(o.Continue)
+		}
+		end := start + int(o.Limit)
+		l := &core.PodList{ListMeta: meta.ListMeta{ResourceVersion: "42"}}
+		if end < len(pods) {
+			l.Continue = strconv.Itoa(end)
+		} else {
+			end = len(pods)
+		}
+		l.Items = pods[start:end]
+		return l, nil
+	}
+
+	cases := []struct {
+		name    string
+		list    func(o meta.ListOptions) (*core.PodList, error)
+		want    *core.PodList
+		wantErr bool
+	}{
+		{
+			name: "Paged",
+			list: pages,
+			want: &core.PodList{ListMeta: meta.ListMeta{ResourceVersion: "42"}, Items: pods},
+		},
+		{
+			name: "ErrorListingPage",
+			list: func(o meta.ListOptions) (*core.PodList, error) {
+				if o.Continue != "" {
+					return nil, errExploded
+				}
+				return pages(o)
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := listPods(tc.list, meta.ListOptions{FieldSelector: "spec.nodeName=" + nodeName})
+			if err != nil {
+				if tc.wantErr {
+					return
+				}
+				t.Fatalf("listPods(...): %v", err)
+			}
+			if tc.wantErr {
+				t.Fatal("listPods(...): want error, got nil")
+			}
+			if diff := deep.Equal(tc.want, got); diff != nil {
+				t.Errorf("listPods(...): want != got: %v", diff)
+			}
+		})
+	}
+}
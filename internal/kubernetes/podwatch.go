@@ -0,0 +1,225 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// PodResource is the core pod resource.
+var PodResource = schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+
+// A TopologySpreadConstraint requires that a pod's replicas be spread evenly
+// across the topology domains of the nodes they are scheduled to. Pods' spread
+// constraints are read from the API server, though the version of the
+// Kubernetes API draino is built against does not define them.
+type TopologySpreadConstraint struct {
+	MaxSkew           int32               `json:"maxSkew"`
+	TopologyKey       string              `json:"topologyKey"`
+	WhenUnsatisfiable string              `json:"whenUnsatisfiable"`
+	LabelSelector     *meta.LabelSelector `json:"labelSelector,omitempty"`
+}
+
+// WhenUnsatisfiable policies of topology spread constraints.
+const (
+	TopologySpreadDoNotSchedule  = "DoNotSchedule"
+	TopologySpreadScheduleAnyway = "ScheduleAnyway"
+)
+
+// A PodStore is a cache of pods that have not terminated.
+type PodStore interface {
+	// HasSynced returns true once the store has been populated.
+	HasSynced() bool
+
+	// List all pods.
+	List() []*core.Pod
+
+	// TopologySpreadConstraints returns the topology spread constraints of
+	// the supplied pod.
+	TopologySpreadConstraints(p *core.Pod) []TopologySpreadConstraint
+}
+
+// A PodWatch is a cache of pods that have not terminated.
+type PodWatch struct {
+	cache.SharedInformer
+
+	mu     sync.RWMutex
+	spread map[types.UID][]TopologySpreadConstraint
+}
+
+// NewPodWatch creates a watch on the pods of all namespaces that have not
+// terminated. Pods are watched using the supplied dynamic client so that their
+// topology spread constraints may be cached too.
+func NewPodWatch(c dynamic.Interface) *PodWatch {
+	ri := c.Resource(PodResource)
+	running := fields.AndSelectors(
+		fields.OneTermNotEqualSelector("status.phase", string(core.PodSucceeded)),
+		fields.OneTermNotEqualSelector("status.phase", string(core.PodFailed)),
+	).String()
+	return newPodWatch(&cache.ListWatch{
+		ListFunc: func(o meta.ListOptions) (runtime.Object, error) {
+			o.FieldSelector = running
+			return ri.List(o)
+		},
+		WatchFunc: func(o meta.ListOptions) (watch.Interface, error) {
+			o.FieldSelector = running
+			return ri.Watch(o)
+		},
+	})
+}
+
+// NewTypedPodWatch creates a watch on the pods of all namespaces that have not
+// terminated using the supplied client, for use where no dynamic client is
+// available, e.g. when evaluating a file of objects. The typed client cannot
+// read topology spread constraints, so none are cached.
+func NewTypedPodWatch(c kubernetes.Interface) *PodWatch {
+	running := fields.AndSelectors(
+		fields.OneTermNotEqualSelector("status.phase", string(core.PodSucceeded)),
+		fields.OneTermNotEqualSelector("status.phase", string(core.PodFailed)),
+	).String()
+	lw := &cache.ListWatch{
+		ListFunc: func(o meta.ListOptions) (runtime.Object, error) {
+			o.FieldSelector = running
+			return c.CoreV1().Pods(meta.NamespaceAll).List(o)
+		},
+		WatchFunc: func(o meta.ListOptions) (watch.Interface, error) {
+			o.FieldSelector = running
+			return c.CoreV1().Pods(meta.NamespaceAll).Watch(o)
+		},
+	}
+	return &PodWatch{
+		SharedInformer: cache.NewSharedInformer(lw, &core.Pod{}, 30*time.Minute),
+		spread:         make(map[types.UID][]TopologySpreadConstraint),
+	}
+}
+
+// newPodWatch creates a watch on the unstructured pods listed and watched by
+// the supplied ListWatch.
+func newPodWatch(lw *cache.ListWatch) *PodWatch {
+	w := &PodWatch{spread: make(map[types.UID][]TopologySpreadConstraint)}
+	dlw := &cache.ListWatch{
+		ListFunc: func(o meta.ListOptions) (runtime.Object, error) {
+			obj, err := lw.List(o)
+			if err != nil {
+				return nil, err
+			}
+			ul, ok := obj.(*unstructured.UnstructuredList)
+			if !ok {
+				return nil, errors.Errorf("cannot decode pods of unexpected type %T", obj)
+			}
+			spread := make(map[types.UID][]TopologySpreadConstraint)
+			l := &core.PodList{ListMeta: meta.ListMeta{ResourceVersion: ul.GetResourceVersion(), Continue: ul.GetContinue()}}
+			for i := range ul.Items {
+				p, sc, err := decodePod(&ul.Items[i])
+				if err != nil {
+					return nil, err
+				}
+				if len(sc) > 0 {
+					spread[p.GetUID()] = sc
+				}
+				l.Items = append(l.Items, *p)
+			}
+			w.mu.Lock()
+			w.spread = spread
+			w.mu.Unlock()
+			return l, nil
+		},
+		WatchFunc: func(o meta.ListOptions) (watch.Interface, error) {
+			uw, err := lw.Watch(o)
+			if err != nil {
+				return nil, err
+			}
+			return watch.Filter(uw, func(e watch.Event) (watch.Event, bool) {
+				u, ok := e.Object.(*unstructured.Unstructured)
+				if !ok || u.GetKind() != "Pod" {
+					return e, true
+				}
+				p, sc, err := decodePod(u)
+				if err != nil {
+					return e, false
+				}
+				w.mu.Lock()
+				if e.Type == watch.Deleted || len(sc) == 0 {
+					delete(w.spread, p.GetUID())
+				} else {
+					w.spread[p.GetUID()] = sc
+				}
+				w.mu.Unlock()
+				e.Object = p
+				return e, true
+			}), nil
+		},
+	}
+	w.SharedInformer = cache.NewSharedInformer(dlw, &core.Pod{}, 30*time.Minute)
+	return w
+}
+
+// decodePod decodes the supplied pod and its topology spread constraints.
+func decodePod(u *unstructured.Unstructured) (*core.Pod, []TopologySpreadConstraint, error) {
+	p := &core.Pod{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), p); err != nil {
+		return nil, nil, errors.Wrapf(err, "cannot decode pod %s/%s", u.GetNamespace(), u.GetName())
+	}
+	raw, ok, _ := unstructured.NestedSlice(u.Object, "spec", "topologySpreadConstraints")
+	if !ok {
+		return p, nil, nil
+	}
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	var sc []TopologySpreadConstraint
+	if err := json.Unmarshal(b, &sc); err != nil {
+		return nil, nil, errors.Wrapf(err, "cannot decode topology spread constraints of pod %s/%s", u.GetNamespace(), u.GetName())
+	}
+	return p, sc, nil
+}
+
+// List all pods.
+func (w *PodWatch) List() []*core.Pod {
+	l := w.GetStore().List()
+	pods := make([]*core.Pod, 0, len(l))
+	for _, o := range l {
+		if p, ok := o.(*core.Pod); ok {
+			pods = append(pods, p)
+		}
+	}
+	return pods
+}
+
+// TopologySpreadConstraints returns the topology spread constraints of the
+// supplied pod.
+func (w *PodWatch) TopologySpreadConstraints(p *core.Pod) []TopologySpreadConstraint {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.spread[p.GetUID()]
+}
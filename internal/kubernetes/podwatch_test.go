@@ -0,0 +1,128 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+// staticPodStore is a PodStore containing a fixed set of pods, and the
+// topology spread constraints of each pod by name.
+type staticPodStore struct {
+	pods   []*core.Pod
+	spread map[string][]TopologySpreadConstraint
+}
+
+func (s *staticPodStore) HasSynced() bool { return true }
+
+func (s *staticPodStore) List() []*core.Pod { return s.pods }
+
+func (s *staticPodStore) TopologySpreadConstraints(p *core.Pod) []TopologySpreadConstraint {
+	return s.spread[p.GetName()]
+}
+
+func TestPodWatch(t *testing.T) {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata":   map[string]interface{}{"namespace": ns, "name": podName, "uid": "coolUID"},
+		"spec": map[string]interface{}{
+			"nodeName": nodeName,
+			"topologySpreadConstraints": []interface{}{map[string]interface{}{
+				"maxSkew":           int64(1),
+				"topologyKey":       "zone",
+				"whenUnsatisfiable": TopologySpreadDoNotSchedule,
+				"labelSelector":     map[string]interface{}{"matchLabels": map[string]interface{}{"app": "cool"}},
+			}},
+		},
+	}}
+	fw := watch.NewFake()
+	w := newPodWatch(&cache.ListWatch{
+		ListFunc: func(o meta.ListOptions) (runtime.Object, error) {
+			return &unstructured.UnstructuredList{Items: []unstructured.Unstructured{*u.DeepCopy()}}, nil
+		},
+		WatchFunc: func(o meta.ListOptions) (watch.Interface, error) { return fw, nil },
+	})
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go w.Run(stop)
+	if err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) { return w.HasSynced(), nil }); err != nil {
+		t.Fatalf("pods were not cached: %v", err)
+	}
+
+	l := w.List()
+	if len(l) != 1 || l[0].GetName() != podName || l[0].Spec.NodeName != nodeName {
+		t.Fatalf("w.List(): want pod %s on node %s, got %v", podName, nodeName, l)
+	}
+	want := []TopologySpreadConstraint{{
+		MaxSkew:           1,
+		TopologyKey:       "zone",
+		WhenUnsatisfiable: TopologySpreadDoNotSchedule,
+		LabelSelector:     &meta.LabelSelector{MatchLabels: map[string]string{"app": "cool"}},
+	}}
+	if diff := deep.Equal(w.TopologySpreadConstraints(l[0]), want); diff != nil {
+		t.Errorf("w.TopologySpreadConstraints(%v): %v", podName, diff)
+	}
+
+	// Constraints are forgotten once a pod no longer has them.
+	unstructured.RemoveNestedField(u.Object, "spec", "topologySpreadConstraints")
+	u.SetResourceVersion("2")
+	fw.Modify(u)
+	if err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		l := w.List()
+		return len(l) == 1 && l[0].GetResourceVersion() == "2", nil
+	}); err != nil {
+		t.Fatalf("modified pod was not cached: %v", err)
+	}
+	if got := w.TopologySpreadConstraints(l[0]); got != nil {
+		t.Errorf("w.TopologySpreadConstraints(%v): want none, got %v", podName, got)
+	}
+}
+
+func TestTypedPodWatch(t *testing.T) {
+	c := fake.NewSimpleClientset(&core.Pod{
+		ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: podName},
+		Spec:       core.PodSpec{NodeName: nodeName},
+	})
+	w := NewTypedPodWatch(c)
+	stop := make(chan struct{})
+	defer close(stop)
+	go w.Run(stop)
+	if !cache.WaitForCacheSync(stop, w.HasSynced) {
+		t.Fatal("cache.WaitForCacheSync(): pods were not cached")
+	}
+
+	l := w.List()
+	if len(l) != 1 || l[0].GetName() != podName || l[0].Spec.NodeName != nodeName {
+		t.Fatalf("w.List(): want pod %s on node %s, got %v", podName, nodeName, l)
+	}
+	if sc := w.TopologySpreadConstraints(l[0]); len(sc) != 0 {
+		t.Errorf("w.TopologySpreadConstraints(%v): want none, got %v", podName, sc)
+	}
+}
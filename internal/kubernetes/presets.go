@@ -0,0 +1,50 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+// A ConditionPreset is a set of condition expressions and priorities suited
+// to a particular source of node conditions.
+type ConditionPreset struct {
+	// Expressions that should cause a node to be cordoned and drained, in
+	// the format understood by ParseConditionExpression.
+	Expressions []string
+
+	// Priorities with which to drain nodes with each condition.
+	Priorities map[string]DrainPriority
+}
+
+// NodeProblemDetectorPreset covers the permanent problems reported by the
+// default Node Problem Detector configuration. Problems that may be transient
+// must persist for a while before a node is drained.
+var NodeProblemDetectorPreset = ConditionPreset{
+	Expressions: []string{
+		"KernelDeadlock",
+		"ReadonlyFilesystem",
+		"CorruptDockerOverlay2,5m",
+		"FrequentKubeletRestart,30m",
+		"FrequentDockerRestart,30m",
+		"FrequentContainerdRestart,30m",
+	},
+	Priorities: map[string]DrainPriority{
+		"KernelDeadlock":            PriorityCritical,
+		"ReadonlyFilesystem":        PriorityHigh,
+		"CorruptDockerOverlay2":     PriorityHigh,
+		"FrequentKubeletRestart":    PriorityNormal,
+		"FrequentDockerRestart":     PriorityNormal,
+		"FrequentContainerdRestart": PriorityNormal,
+	},
+}
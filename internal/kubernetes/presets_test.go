@@ -0,0 +1,31 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+)
+
+func TestNodeProblemDetectorPreset(t *testing.T) {
+	for _, e := range NodeProblemDetectorPreset.Expressions {
+		t.Run(e, func(t *testing.T) {
+			if _, err := ParseConditionExpression(e); err != nil {
+				t.Errorf("ParseConditionExpression(%q): %v", e, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+)
+
+// A DrainPriority determines the order in which scheduled drains start.
+// Drains with a higher priority start before those with a lower priority.
+type DrainPriority int
+
+// Drain priorities.
+const (
+	PriorityLow DrainPriority = iota
+	PriorityNormal
+	PriorityHigh
+	PriorityCritical
+)
+
+var priorityNames = map[DrainPriority]string{
+	PriorityLow:      "low",
+	PriorityNormal:   "normal",
+	PriorityHigh:     "high",
+	PriorityCritical: "critical",
+}
+
+func (p DrainPriority) String() string {
+	return priorityNames[p]
+}
+
+// ParseDrainPriority parses a drain priority, i.e. one of low, normal, high,
+// or critical.
+func ParseDrainPriority(s string) (DrainPriority, error) {
+	for p, name := range priorityNames {
+		if strings.EqualFold(s, name) {
+			return p, nil
+		}
+	}
+	return PriorityNormal, errors.Errorf("unknown drain priority %q", s)
+}
+
+// A NodePriorityFunc returns the priority with which the supplied node should
+// be drained.
+type NodePriorityFunc func(n *core.Node) DrainPriority
+
+// NewConditionPriorityFunc returns a NodePriorityFunc that prioritises nodes
+// according to their node conditions. Nodes are given the highest priority of
+// any of their true conditions, or normal priority if none of their true
+// conditions are assigned a priority.
+func NewConditionPriorityFunc(priorities map[string]DrainPriority) NodePriorityFunc {
+	return func(n *core.Node) DrainPriority {
+		priority, found := PriorityLow, false
+		for _, c := range n.Status.Conditions {
+			if c.Status != core.ConditionTrue {
+				continue
+			}
+			p, ok := priorities[string(c.Type)]
+			if !ok {
+				continue
+			}
+			if !found || p > priority {
+				priority, found = p, true
+			}
+		}
+		if !found {
+			return PriorityNormal
+		}
+		return priority
+	}
+}
+
+// NewDrainInProgressPriorityFunc returns a NodePriorityFunc that gives critical
+// priority to nodes whose drain was interrupted, per the supplied keys'
+// DrainInProgress key, so that they are drained before any others. Other nodes
+// are prioritised by the supplied NodePriorityFunc.
+func NewDrainInProgressPriorityFunc(k Keys, fn NodePriorityFunc) NodePriorityFunc {
+	inProgress := NewNodeDrainInProgressFilter(k)
+	return func(n *core.Node) DrainPriority {
+		if inProgress(n) {
+			return PriorityCritical
+		}
+		return fn(n)
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseDrainPriority(t *testing.T) {
+	cases := []struct {
+		name    string
+		s       string
+		want    DrainPriority
+		wantErr bool
+	}{
+		{name: "Low", s: "low", want: PriorityLow},
+		{name: "Normal", s: "normal", want: PriorityNormal},
+		{name: "High", s: "High", want: PriorityHigh},
+		{name: "Critical", s: "CRITICAL", want: PriorityCritical},
+		{name: "Unknown", s: "urgent", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseDrainPriority(tc.s)
+			if err != nil {
+				if tc.wantErr {
+					return
+				}
+				t.Fatalf("ParseDrainPriority(%v): %v", tc.s, err)
+			}
+			if tc.wantErr {
+				t.Fatalf("ParseDrainPriority(%v): want error", tc.s)
+			}
+			if got != tc.want {
+				t.Errorf("ParseDrainPriority(%v): want %v, got %v", tc.s, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestDrainInProgressPriorityFunc(t *testing.T) {
+	cases := []struct {
+		name string
+		node *core.Node
+		want DrainPriority
+	}{
+		{
+			name: "InProgress",
+			node: &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName, Annotations: map[string]string{AnnotationDrainInProgress: "2018-01-01T00:00:00Z"}}},
+			want: PriorityCritical,
+		},
+		{
+			name: "NotInProgress",
+			node: &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}},
+			want: PriorityLow,
+		},
+	}
+
+	fn := NewDrainInProgressPriorityFunc(DefaultKeys(), func(_ *core.Node) DrainPriority { return PriorityLow })
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := fn(tc.node); got != tc.want {
+				t.Errorf("fn(%v): want %v, got %v", tc.node.GetName(), tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// DefaultDrainProgressInterval is the default time between updates of a
+// node's AnnotationDrainProgress while it is drained.
+const DefaultDrainProgressInterval = 30 * time.Second
+
+// Drain phases.
+const (
+	// DrainPhaseEvicting indicates that pods are being evicted from the node.
+	DrainPhaseEvicting = "Evicting"
+)
+
+// DrainProgress describes how far a drain has progressed.
+type DrainProgress struct {
+	Phase         string    `json:"phase"`
+	Pods          int       `json:"pods"`
+	PodsRemaining int       `json:"podsRemaining"`
+	WaitingOn     string    `json:"waitingOn,omitempty"`
+	Started       time.Time `json:"started"`
+	Elapsed       string    `json:"elapsed"`
+}
+
+// drainProgress tracks the progress of a drain. It is safe for concurrent
+// use.
+type drainProgress struct {
+	mu      sync.Mutex
+	started time.Time
+	pods    []core.Pod
+	evicted map[types.UID]bool
+}
+
+func newDrainProgress(pods []core.Pod, started time.Time) *drainProgress {
+	return &drainProgress{started: started, pods: pods, evicted: make(map[types.UID]bool, len(pods))}
+}
+
+// podEvicted records that the supplied pod has been evicted.
+func (p *drainProgress) podEvicted(pod core.Pod) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.evicted[pod.GetUID()] = true
+}
+
+// progress returns the progress of the drain as of the supplied time. The
+// drain is waiting on the first of its pods, in the order they were listed,
+// that has not been evicted.
+func (p *drainProgress) progress(now time.Time) DrainProgress {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	dp := DrainProgress{
+		Phase:         DrainPhaseEvicting,
+		Pods:          len(p.pods),
+		PodsRemaining: len(p.pods) - len(p.evicted),
+		Started:       p.started.UTC(),
+		Elapsed:       now.Sub(p.started).Round(time.Second).String(),
+	}
+	for _, pod := range p.pods {
+		if !p.evicted[pod.GetUID()] {
+			dp.WaitingOn = pod.GetNamespace() + "/" + pod.GetName()
+			break
+		}
+	}
+	return dp
+}
+
+// reportProgress records the supplied drain's progress in the supplied node's
+// AnnotationDrainProgress every progress interval until the supplied channel
+// is closed, at which point the annotation is removed.
+func (d *APICordonDrainer) reportProgress(n *core.Node, p *drainProgress, stop <-chan struct{}) {
+	log := d.l.With(zap.String("node", n.GetName()))
+	t := time.NewTicker(d.progressInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			if err := d.removeAnnotation(n, d.keys.DrainProgress); err != nil {
+				log.Info("Cannot remove drain progress", zap.Error(err))
+			}
+			return
+		case <-t.C:
+			if err := d.writeProgress(n, p.progress(time.Now())); err != nil {
+				log.Info("Cannot record drain progress", zap.Error(err))
+			}
+		}
+	}
+}
+
+func (d *APICordonDrainer) writeProgress(n *core.Node, dp DrainProgress) error {
+	v, err := json.Marshal(dp)
+	if err != nil {
+		return errors.Wrap(err, "cannot encode drain progress")
+	}
+	fresh, err := d.c.CoreV1().Nodes().Get(n.GetName(), meta.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "cannot get node %s", n.GetName())
+	}
+	if fresh.Annotations == nil {
+		fresh.Annotations = make(map[string]string)
+	}
+	fresh.Annotations[d.keys.DrainProgress] = string(v)
+	_, err = d.c.CoreV1().Nodes().Update(fresh)
+	return errors.Wrapf(err, "cannot annotate node %s", fresh.GetName())
+}
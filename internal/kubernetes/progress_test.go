@@ -0,0 +1,122 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestDrainProgress(t *testing.T) {
+	started := time.Date(2018, 10, 12, 18, 0, 0, 0, time.UTC)
+	pods := []core.Pod{
+		{ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: "a", UID: "a"}},
+		{ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: "b", UID: "b"}},
+		{ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: "c", UID: "c"}},
+	}
+
+	cases := []struct {
+		name    string
+		evicted []core.Pod
+		want    DrainProgress
+	}{
+		{
+			name: "NoneEvicted",
+			want: DrainProgress{Phase: DrainPhaseEvicting, Pods: 3, PodsRemaining: 3, WaitingOn: ns + "/a", Started: started, Elapsed: "1m30s"},
+		},
+		{
+			name:    "FirstEvicted",
+			evicted: pods[:1],
+			want:    DrainProgress{Phase: DrainPhaseEvicting, Pods: 3, PodsRemaining: 2, WaitingOn: ns + "/b", Started: started, Elapsed: "1m30s"},
+		},
+		{
+			name:    "AllEvicted",
+			evicted: pods,
+			want:    DrainProgress{Phase: DrainPhaseEvicting, Pods: 3, PodsRemaining: 0, Started: started, Elapsed: "1m30s"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newDrainProgress(pods, started)
+			for _, pod := range tc.evicted {
+				p.podEvicted(pod)
+			}
+			if diff := deep.Equal(tc.want, p.progress(started.Add(90*time.Second))); diff != nil {
+				t.Errorf("p.progress(): want != got: %v", diff)
+			}
+		})
+	}
+}
+
+func TestDrainReportsProgress(t *testing.T) {
+	n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}
+	pod := &core.Pod{ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: podName, UID: "uid"}, Spec: core.PodSpec{NodeName: nodeName}}
+	c := fake.NewSimpleClientset(n, pod)
+	c.PrependReactor("create", "pods", func(a clienttesting.Action) (bool, runtime.Object, error) {
+		return a.GetSubresource() == "eviction", nil, nil
+	})
+	c.PrependReactor("get", "pods", func(_ clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewNotFound(schema.GroupResource{Resource: "pods"}, podName)
+	})
+
+	d := NewAPICordonDrainer(c, WithDrainProgress(1*time.Hour))
+	if err := d.Drain(n); err != nil {
+		t.Fatalf("d.Drain(%v): %v", n.GetName(), err)
+	}
+
+	var got []DrainProgress
+	for _, a := range c.Actions() {
+		u, ok := a.(clienttesting.UpdateAction)
+		if !ok || a.GetVerb() != "update" || a.GetResource().Resource != "nodes" {
+			continue
+		}
+		v, ok := u.GetObject().(*core.Node).GetAnnotations()[AnnotationDrainProgress]
+		if !ok {
+			continue
+		}
+		dp := DrainProgress{}
+		if err := json.Unmarshal([]byte(v), &dp); err != nil {
+			t.Fatalf("json.Unmarshal(%v): %v", v, err)
+		}
+		got = append(got, dp)
+	}
+	if len(got) != 1 {
+		t.Fatalf("progress updates: want 1, got %d", len(got))
+	}
+	if got[0].Pods != 1 || got[0].WaitingOn != ns+"/"+podName {
+		t.Errorf("progress: want 1 pod waiting on %s/%s, got %+v", ns, podName, got[0])
+	}
+
+	fresh, err := c.CoreV1().Nodes().Get(nodeName, meta.GetOptions{})
+	if err != nil {
+		t.Fatalf("c.CoreV1().Nodes().Get(%v): %v", nodeName, err)
+	}
+	if _, ok := fresh.GetAnnotations()[AnnotationDrainProgress]; ok {
+		t.Errorf("node still annotated with drain progress after drain")
+	}
+}
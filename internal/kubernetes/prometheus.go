@@ -0,0 +1,114 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+)
+
+const (
+	// DefaultPrometheusNodeLabel is the default label of Prometheus series
+	// that identifies the node to which a series pertains.
+	DefaultPrometheusNodeLabel = "node"
+
+	prometheusQueryPath     = "/api/v1/query"
+	prometheusQueryTimeout  = 30 * time.Second
+	prometheusStatusSuccess = "success"
+	prometheusResultVector  = "vector"
+)
+
+// A PrometheusProber is a ConditionProber that evaluates a PromQL expression.
+// Nodes are considered to exhibit a problem if the expression returns any
+// series with a node label matching the node's name.
+type PrometheusProber struct {
+	client    *http.Client
+	url       string
+	query     string
+	nodeLabel string
+}
+
+// NewPrometheusProber returns a ConditionProber that evaluates the supplied
+// instant query against the Prometheus server at the supplied URL. The value of
+// the supplied label of each series returned by the query is mapped to a node
+// name. Any port suffix (e.g. of an instance label) is ignored.
+func NewPrometheusProber(prometheusURL, query, nodeLabel string) *PrometheusProber {
+	return &PrometheusProber{
+		client:    &http.Client{Timeout: prometheusQueryTimeout},
+		url:       strings.TrimSuffix(prometheusURL, "/"),
+		query:     query,
+		nodeLabel: nodeLabel,
+	}
+}
+
+type prometheusResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Metric map[string]string `json:"metric"`
+			Value  []interface{}     `json:"value"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// Probe the supplied nodes by evaluating the prober's query.
+func (p *PrometheusProber) Probe(_ []*core.Node) (map[string]string, error) {
+	u := p.url + prometheusQueryPath + "?" + url.Values{"query": []string{p.query}}.Encode()
+	rsp, err := p.client.Get(u)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot query Prometheus")
+	}
+	defer rsp.Body.Close()
+
+	r := &prometheusResponse{}
+	if err := json.NewDecoder(rsp.Body).Decode(r); err != nil {
+		return nil, errors.Wrapf(err, "cannot decode Prometheus response with status %s", rsp.Status)
+	}
+	if r.Status != prometheusStatusSuccess {
+		return nil, errors.Errorf("Prometheus query failed: %s", r.Error)
+	}
+	if r.Data.ResultType != prometheusResultVector {
+		return nil, errors.Errorf("Prometheus query returned %s, not %s", r.Data.ResultType, prometheusResultVector)
+	}
+
+	firing := make(map[string]string)
+	for _, s := range r.Data.Result {
+		node, ok := s.Metric[p.nodeLabel]
+		if !ok {
+			continue
+		}
+		if host, _, err := net.SplitHostPort(node); err == nil {
+			node = host
+		}
+		value := ""
+		if len(s.Value) == 2 {
+			value = fmt.Sprintf("%v", s.Value[1])
+		}
+		firing[node] = fmt.Sprintf("Prometheus query %s returned %s", p.query, value)
+	}
+	return firing, nil
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func TestPrometheusProber(t *testing.T) {
+	cases := []struct {
+		name    string
+		label   string
+		body    string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:  "SeriesFiring",
+			label: "node",
+			body: `{"status":"success","data":{"resultType":"vector","result":[
+				{"metric":{"node":"a"},"value":[1539000000,"3"]},
+				{"metric":{"node":"b"},"value":[1539000000,"1"]},
+				{"metric":{"job":"node-exporter"},"value":[1539000000,"1"]}
+			]}}`,
+			want: []string{"a", "b"},
+		},
+		{
+			name:  "InstanceLabelWithPort",
+			label: "instance",
+			body: `{"status":"success","data":{"resultType":"vector","result":[
+				{"metric":{"instance":"a:9100"},"value":[1539000000,"1"]}
+			]}}`,
+			want: []string{"a"},
+		},
+		{
+			name:  "NothingFiring",
+			label: "node",
+			body:  `{"status":"success","data":{"resultType":"vector","result":[]}}`,
+			want:  []string{},
+		},
+		{
+			name:    "QueryError",
+			label:   "node",
+			body:    `{"status":"error","error":"parse error"}`,
+			wantErr: true,
+		},
+		{
+			name:    "NotAVector",
+			label:   "node",
+			body:    `{"status":"success","data":{"resultType":"matrix","result":[]}}`,
+			wantErr: true,
+		},
+		{
+			name:    "NotJSON",
+			label:   "node",
+			body:    `<html>nope</html>`,
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got := r.URL.Query().Get("query"); got != "up == 0" {
+					t.Errorf("query: want %v, got %v", "up == 0", got)
+				}
+				w.Write([]byte(tc.body)) // nolint:gosec
+			}))
+			defer srv.Close()
+
+			p := NewPrometheusProber(srv.URL+"/", "up == 0", tc.label)
+			firing, err := p.Probe(nil)
+			if err != nil {
+				if tc.wantErr {
+					return
+				}
+				t.Fatalf("p.Probe(): %v", err)
+			}
+			if tc.wantErr {
+				t.Fatalf("p.Probe(): want error")
+			}
+			got := make([]string, 0, len(firing))
+			for n := range firing {
+				got = append(got, n)
+			}
+			sort.Strings(got)
+			if diff := deep.Equal(tc.want, got); diff != nil {
+				t.Errorf("p.Probe(): want != got %v", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// DefaultNodeQueueInterval is the default minimum time between notifications
+// about the same node. Kubelets update their node's status every ten seconds
+// by default.
+const DefaultNodeQueueInterval = 10 * time.Second
+
+// A NodeQueue is a cache.ResourceEventHandler that queues notifications about
+// nodes and passes them to another handler from a single worker. Notifications
+// about a node that arrive while it is queued, or within the minimum interval
+// of the last notification passed on about it, are coalesced into a single
+// notification about the node's latest state. This prevents bursts of updates,
+// such as status heartbeats, repeatedly running filters and recording events
+// about the same node.
+type NodeQueue struct {
+	l        *zap.Logger
+	h        cache.ResourceEventHandler
+	q        workqueue.DelayingInterface
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending map[string]*queuedNode
+	last    map[string]time.Time
+}
+
+// A queuedNode is the notifications about a node that have not yet been
+// passed on.
+type queuedNode struct {
+	// old is the node as of the last notification passed on about it, or nil
+	// if it was added since.
+	old interface{}
+
+	// new is the latest state of the node, or nil if it has been deleted.
+	new interface{}
+
+	// deleted is the last known state of the node if it was deleted.
+	deleted interface{}
+}
+
+// NodeQueueOption configures a NodeQueue.
+type NodeQueueOption func(q *NodeQueue)
+
+// WithNodeQueueLogger configures a NodeQueue to log coalesced notifications.
+func WithNodeQueueLogger(l *zap.Logger) NodeQueueOption {
+	return func(q *NodeQueue) {
+		q.l = l
+	}
+}
+
+// WithNodeQueueInterval configures the minimum time between notifications
+// passed on about the same node. Notifications are still coalesced while a
+// node is queued if the interval is zero.
+func WithNodeQueueInterval(d time.Duration) NodeQueueOption {
+	return func(q *NodeQueue) {
+		q.interval = d
+	}
+}
+
+// NewNodeQueue returns a NodeQueue that passes notifications to the supplied
+// handler once it is run.
+func NewNodeQueue(h cache.ResourceEventHandler, o ...NodeQueueOption) *NodeQueue {
+	q := &NodeQueue{
+		l:        zap.NewNop(),
+		h:        h,
+		q:        workqueue.NewNamedDelayingQueue("nodes"),
+		interval: DefaultNodeQueueInterval,
+		pending:  make(map[string]*queuedNode),
+		last:     make(map[string]time.Time),
+	}
+	for _, fn := range o {
+		fn(q)
+	}
+	return q
+}
+
+// OnAdd queues a notification that the supplied node was added.
+func (q *NodeQueue) OnAdd(obj interface{}) {
+	q.enqueue(obj, func(n *queuedNode) { n.new = obj })
+}
+
+// OnUpdate queues a notification that the supplied node was updated.
+func (q *NodeQueue) OnUpdate(oldObj, newObj interface{}) {
+	q.enqueue(newObj, func(n *queuedNode) {
+		if n.new == nil && n.deleted == nil {
+			n.old = oldObj
+		}
+		n.new = newObj
+	})
+}
+
+// OnDelete queues a notification that the supplied node was deleted.
+// Notifications about the node that were queued before its deletion are
+// discarded.
+func (q *NodeQueue) OnDelete(obj interface{}) {
+	q.enqueue(obj, func(n *queuedNode) {
+		n.old, n.new, n.deleted = nil, nil, obj
+	})
+}
+
+// enqueue applies the supplied change to the queued notifications about the
+// supplied node, and queues the node if it is not already queued.
+func (q *NodeQueue) enqueue(obj interface{}, change func(n *queuedNode)) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n, queued := q.pending[key]
+	if !queued {
+		n = &queuedNode{}
+		q.pending[key] = n
+	}
+	change(n)
+	if queued {
+		q.l.Debug("Coalesced node notification", zap.String("node", key))
+		return
+	}
+	if wait := q.interval - time.Since(q.last[key]); wait > 0 {
+		q.q.AddAfter(key, wait)
+		return
+	}
+	q.q.Add(key)
+}
+
+// Run passes queued notifications to the handler until the supplied channel
+// is closed.
+func (q *NodeQueue) Run(stop <-chan struct{}) {
+	go func() {
+		<-stop
+		q.q.ShutDown()
+	}()
+	for q.next() {
+	}
+}
+
+// next passes the notifications about the next queued node to the handler. It
+// returns false once the queue has been shut down.
+func (q *NodeQueue) next() bool {
+	item, shutdown := q.q.Get()
+	if shutdown {
+		return false
+	}
+	defer q.q.Done(item)
+	key := item.(string)
+
+	q.mu.Lock()
+	n, ok := q.pending[key]
+	delete(q.pending, key)
+	if ok && n.new == nil {
+		delete(q.last, key)
+	} else {
+		q.last[key] = time.Now()
+	}
+	q.mu.Unlock()
+	if !ok {
+		return true
+	}
+
+	if n.deleted != nil {
+		q.h.OnDelete(n.deleted)
+	}
+	switch {
+	case n.new == nil:
+	case n.old == nil:
+		q.h.OnAdd(n.new)
+	default:
+		q.h.OnUpdate(n.old, n.new)
+	}
+	return true
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// recordingHandler records the notifications it receives.
+type recordingHandler struct {
+	got []string
+}
+
+func describeNode(o interface{}) string {
+	n := o.(*core.Node)
+	return n.GetName() + "@" + n.GetResourceVersion()
+}
+
+func (r *recordingHandler) OnAdd(obj interface{}) {
+	r.got = append(r.got, "add "+describeNode(obj))
+}
+
+func (r *recordingHandler) OnUpdate(oldObj, newObj interface{}) {
+	r.got = append(r.got, fmt.Sprintf("update %s %s", describeNode(oldObj), describeNode(newObj)))
+}
+
+func (r *recordingHandler) OnDelete(obj interface{}) {
+	r.got = append(r.got, "delete "+describeNode(obj))
+}
+
+func TestNodeQueue(t *testing.T) {
+	node := func(name, version string) *core.Node {
+		return &core.Node{ObjectMeta: meta.ObjectMeta{Name: name, ResourceVersion: version}}
+	}
+	cases := []struct {
+		name    string
+		notify  func(q *NodeQueue)
+		want    []string
+		batches int
+	}{
+		{
+			name: "CoalescedUpdates",
+			notify: func(q *NodeQueue) {
+				q.OnUpdate(node(nodeName, "1"), node(nodeName, "2"))
+				q.OnUpdate(node(nodeName, "2"), node(nodeName, "3"))
+				q.OnUpdate(node(nodeName, "3"), node(nodeName, "4"))
+			},
+			want:    []string{"update coolNode@1 coolNode@4"},
+			batches: 1,
+		},
+		{
+			name: "AddThenUpdates",
+			notify: func(q *NodeQueue) {
+				q.OnAdd(node(nodeName, "1"))
+				q.OnUpdate(node(nodeName, "1"), node(nodeName, "2"))
+			},
+			want:    []string{"add coolNode@2"},
+			batches: 1,
+		},
+		{
+			name: "UpdateThenDelete",
+			notify: func(q *NodeQueue) {
+				q.OnUpdate(node(nodeName, "1"), node(nodeName, "2"))
+				q.OnDelete(node(nodeName, "2"))
+			},
+			want:    []string{"delete coolNode@2"},
+			batches: 1,
+		},
+		{
+			name: "DeleteThenAdd",
+			notify: func(q *NodeQueue) {
+				q.OnDelete(node(nodeName, "1"))
+				q.OnAdd(node(nodeName, "2"))
+			},
+			want:    []string{"delete coolNode@1", "add coolNode@2"},
+			batches: 1,
+		},
+		{
+			name: "SeveralNodes",
+			notify: func(q *NodeQueue) {
+				q.OnAdd(node("a", "1"))
+				q.OnAdd(node("b", "1"))
+				q.OnUpdate(node("a", "1"), node("a", "2"))
+			},
+			want:    []string{"add a@2", "add b@1"},
+			batches: 2,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &recordingHandler{}
+			q := NewNodeQueue(h, WithNodeQueueInterval(0))
+			tc.notify(q)
+			for i := 0; i < tc.batches; i++ {
+				if !q.next() {
+					t.Fatal("q.next(): queue shut down")
+				}
+			}
+			if q.q.Len() != 0 {
+				t.Errorf("q.q.Len(): want 0, got %d", q.q.Len())
+			}
+			if diff := deep.Equal(tc.want, h.got); diff != nil {
+				t.Errorf("notifications: want != got: %v", diff)
+			}
+		})
+	}
+}
+
+func TestNodeQueueInterval(t *testing.T) {
+	interval := 200 * time.Millisecond
+	h := &recordingHandler{}
+	q := NewNodeQueue(h, WithNodeQueueInterval(interval))
+
+	q.OnAdd(&core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName, ResourceVersion: "1"}})
+	q.next()
+
+	// Updates within the interval of the last notification are delayed until
+	// it has elapsed, and coalesced.
+	start := time.Now()
+	q.OnUpdate(&core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName, ResourceVersion: "1"}}, &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName, ResourceVersion: "2"}})
+	q.OnUpdate(&core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName, ResourceVersion: "2"}}, &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName, ResourceVersion: "3"}})
+	q.next()
+	if elapsed := time.Since(start); elapsed < interval/2 {
+		t.Errorf("update passed on after %s; want at least %s", elapsed, interval/2)
+	}
+
+	want := []string{"add coolNode@1", "update coolNode@1 coolNode@3"}
+	if diff := deep.Equal(want, h.got); diff != nil {
+		t.Errorf("notifications: want != got: %v", diff)
+	}
+
+	stop := make(chan struct{})
+	close(stop)
+	q.Run(stop)
+}
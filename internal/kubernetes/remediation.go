@@ -0,0 +1,198 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DrainRemediationResource is draino's own external remediation resource.
+var DrainRemediationResource = schema.GroupVersionResource{
+	Group:    "draino.planetlabs.com",
+	Version:  "v1alpha1",
+	Resource: "drainremediations",
+}
+
+// AnnotationRemediationNode names the node a remediation is for. Remediations
+// without this annotation are named after their node.
+const AnnotationRemediationNode = "remediation.medik8s.io/node-name"
+
+// Remediation condition types, as expected by node health check controllers.
+const (
+	RemediationProcessing = "Processing"
+	RemediationSucceeded  = "Succeeded"
+)
+
+// A RemediationController acts as an external remediation backend for node
+// health check controllers, which create a remediation custom resource for
+// each unhealthy node. The controller cordons and drains the node of each
+// remediation, reporting progress in its status conditions, and uncordons the
+// node once the health check controller deletes the remediation.
+type RemediationController struct {
+	*nodeResourceController
+}
+
+// RemediationControllerOption configures a RemediationController.
+type RemediationControllerOption func(c *RemediationController)
+
+// WithRemediationLogger configures a RemediationController to use the
+// supplied logger.
+func WithRemediationLogger(l *zap.Logger) RemediationControllerOption {
+	return func(c *RemediationController) {
+		c.l = l
+	}
+}
+
+// WithRemediationResource configures a RemediationController to watch the
+// supplied remediation resource, rather than DrainRemediationResource.
+func WithRemediationResource(r schema.GroupVersionResource) RemediationControllerOption {
+	return func(c *RemediationController) {
+		c.gvr = r
+	}
+}
+
+// WithRemediationDryRun configures a RemediationController to request drains
+// without updating the finalizers or status conditions of remediations, or
+// uncordoning their nodes.
+func WithRemediationDryRun() RemediationControllerOption {
+	return func(c *RemediationController) {
+		c.dryRun = true
+	}
+}
+
+// WithRemediationKeys configures the annotation and finalizer keys a
+// RemediationController reads and writes.
+func WithRemediationKeys(k Keys) RemediationControllerOption {
+	return func(c *RemediationController) {
+		c.keys = k
+	}
+}
+
+// NewRemediationController returns a RemediationController that uses the
+// supplied DrainRequester to drain nodes.
+func NewRemediationController(c dynamic.Interface, k kubernetes.Interface, nodes NodeStore, r DrainRequester, co ...RemediationControllerOption) *RemediationController {
+	rc := &RemediationController{newNodeResourceController(c, k, nodes, r, "remediation", remediation{})}
+	rc.gvr = DrainRemediationResource
+	for _, o := range co {
+		o(rc)
+	}
+	rc.finalizer = rc.keys.RemediationFinalizer
+	rc.watch()
+	return rc
+}
+
+// Remediating returns true if the supplied node is the subject of a
+// remediation, and thus should not be uncordoned.
+func (c *RemediationController) Remediating(o interface{}) bool {
+	return c.forNode(o)
+}
+
+// remediation is the nodeResource of remediations.
+type remediation struct{}
+
+// node returns the node of the supplied remediation, which is named after its
+// node unless annotated with AnnotationRemediationNode.
+func (remediation) node(u *unstructured.Unstructured) (string, error) {
+	if n := u.GetAnnotations()[AnnotationRemediationNode]; n != "" {
+		return n, nil
+	}
+	return u.GetName(), nil
+}
+
+func (remediation) finished(u *unstructured.Unstructured) bool {
+	_, done := condition(u, RemediationSucceeded)
+	return done
+}
+
+func (remediation) draining(u *unstructured.Unstructured) {
+	setConditions(u, remediationCondition{Type: RemediationProcessing, Status: core.ConditionTrue, Reason: "Draining", Message: "Cordoning and draining node"})
+}
+
+func (remediation) drained(u *unstructured.Unstructured, err error) {
+	processing := remediationCondition{Type: RemediationProcessing, Status: core.ConditionFalse, Reason: "Drained", Message: "Drained node"}
+	succeeded := remediationCondition{Type: RemediationSucceeded, Status: core.ConditionTrue, Reason: "Drained", Message: "Drained node"}
+	if err != nil {
+		processing.Reason, processing.Message = "DrainFailed", err.Error()
+		succeeded.Status, succeeded.Reason, succeeded.Message = core.ConditionFalse, "DrainFailed", err.Error()
+	}
+	setConditions(u, processing, succeeded)
+}
+
+type remediationCondition struct {
+	Type    string
+	Status  core.ConditionStatus
+	Reason  string
+	Message string
+}
+
+// condition returns the status of the supplied remediation's condition of the
+// supplied type, and whether it exists.
+func condition(u *unstructured.Unstructured, t string) (string, bool) {
+	conditions, _, _ := unstructured.NestedSlice(u.Object, "status", "conditions")
+	for _, o := range conditions {
+		m, ok := o.(map[string]interface{})
+		if !ok || m["type"] != t {
+			continue
+		}
+		s, _ := m["status"].(string)
+		return s, true
+	}
+	return "", false
+}
+
+func setConditions(u *unstructured.Unstructured, conditions ...remediationCondition) {
+	existing, _, _ := unstructured.NestedSlice(u.Object, "status", "conditions")
+	for _, rc := range conditions {
+		existing = setCondition(existing, rc)
+	}
+	_ = unstructured.SetNestedSlice(u.Object, existing, "status", "conditions")
+}
+
+// setCondition replaces the condition of the supplied type, preserving its
+// last transition time if its status is unchanged.
+func setCondition(conditions []interface{}, rc remediationCondition) []interface{} {
+	now := meta.Now().UTC().Format(time.RFC3339)
+	want := map[string]interface{}{
+		"type":               rc.Type,
+		"status":             string(rc.Status),
+		"reason":             rc.Reason,
+		"message":            rc.Message,
+		"lastTransitionTime": now,
+	}
+	for i, o := range conditions {
+		m, ok := o.(map[string]interface{})
+		if !ok || m["type"] != rc.Type {
+			continue
+		}
+		if m["status"] == want["status"] {
+			if t, ok := m["lastTransitionTime"]; ok {
+				want["lastTransitionTime"] = t
+			}
+		}
+		conditions[i] = want
+		return conditions
+	}
+	return append(conditions, want)
+}
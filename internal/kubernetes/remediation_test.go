@@ -0,0 +1,218 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const remediationNamespace = "coolNamespace"
+
+func newRemediation(deleting bool, name string, annotations map[string]string, finalizers ...string) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{}
+	u.SetAPIVersion(DrainRemediationResource.GroupVersion().String())
+	u.SetKind("DrainRemediation")
+	u.SetNamespace(remediationNamespace)
+	u.SetName(name)
+	u.SetUID("uid")
+	u.SetAnnotations(annotations)
+	u.SetFinalizers(finalizers)
+	if deleting {
+		now := meta.Now()
+		u.SetDeletionTimestamp(&now)
+	}
+	return u
+}
+
+func withCondition(u *unstructured.Unstructured, t, status string) *unstructured.Unstructured {
+	_ = unstructured.SetNestedSlice(u.Object, []interface{}{map[string]interface{}{"type": t, "status": status}}, "status", "conditions")
+	return u
+}
+
+func TestRemediationController(t *testing.T) {
+	cordoned := &core.Node{
+		ObjectMeta: meta.ObjectMeta{Name: nodeName, Annotations: map[string]string{AnnotationCordoned: "2018-01-01T00:00:00Z"}},
+		Spec:       core.NodeSpec{Unschedulable: true},
+	}
+	nodes := staticNodeStore{cordoned}
+
+	cases := []struct {
+		name            string
+		remediation     *unstructured.Unstructured
+		node            *core.Node
+		dryRun          bool
+		prefix          string
+		results         map[string]error
+		wantDrained     bool
+		wantProcessing  string
+		wantSucceeded   string
+		wantFinalizer   bool
+		wantUncordoned  bool
+		wantRemediating bool
+	}{
+		{
+			name:            "Drained",
+			remediation:     newRemediation(false, nodeName, nil),
+			results:         map[string]error{nodeName: nil},
+			wantDrained:     true,
+			wantProcessing:  string(core.ConditionFalse),
+			wantSucceeded:   string(core.ConditionTrue),
+			wantFinalizer:   true,
+			wantRemediating: true,
+		},
+		{
+			name:            "NodeAnnotation",
+			remediation:     newRemediation(false, "coolRemediation", map[string]string{AnnotationRemediationNode: nodeName}),
+			results:         map[string]error{nodeName: nil},
+			wantDrained:     true,
+			wantProcessing:  string(core.ConditionFalse),
+			wantSucceeded:   string(core.ConditionTrue),
+			wantFinalizer:   true,
+			wantRemediating: true,
+		},
+		{
+			name:            "DrainFailed",
+			remediation:     newRemediation(false, nodeName, nil, RemediationFinalizer),
+			results:         map[string]error{nodeName: errors.New("nope")},
+			wantDrained:     true,
+			wantProcessing:  string(core.ConditionFalse),
+			wantSucceeded:   string(core.ConditionFalse),
+			wantFinalizer:   true,
+			wantRemediating: true,
+		},
+		{
+			name:           "NodeMissing",
+			remediation:    newRemediation(false, "missingNode", nil),
+			wantProcessing: string(core.ConditionFalse),
+			wantSucceeded:  string(core.ConditionFalse),
+			wantFinalizer:  true,
+		},
+		{
+			name:            "AlreadySucceeded",
+			remediation:     withCondition(newRemediation(false, nodeName, nil, RemediationFinalizer), RemediationSucceeded, string(core.ConditionTrue)),
+			wantSucceeded:   string(core.ConditionTrue),
+			wantFinalizer:   true,
+			wantRemediating: true,
+		},
+		{
+			name:            "Deleting",
+			remediation:     withCondition(newRemediation(true, nodeName, nil, RemediationFinalizer), RemediationSucceeded, string(core.ConditionTrue)),
+			wantSucceeded:   string(core.ConditionTrue),
+			wantUncordoned:  true,
+			wantRemediating: true,
+		},
+		{
+			name:        "DeletingCordonedByOthers",
+			remediation: withCondition(newRemediation(true, nodeName, nil, RemediationFinalizer), RemediationSucceeded, string(core.ConditionTrue)),
+			node: &core.Node{
+				ObjectMeta: meta.ObjectMeta{Name: nodeName},
+				Spec:       core.NodeSpec{Unschedulable: true},
+			},
+			wantSucceeded:   string(core.ConditionTrue),
+			wantRemediating: true,
+		},
+		{
+			name:            "DryRun",
+			remediation:     newRemediation(false, nodeName, nil),
+			dryRun:          true,
+			results:         map[string]error{nodeName: nil},
+			wantDrained:     true,
+			wantRemediating: true,
+		},
+		{
+			name:            "DrainedWithKeyPrefix",
+			remediation:     newRemediation(false, nodeName, nil),
+			prefix:          "example.org/",
+			results:         map[string]error{nodeName: nil},
+			wantDrained:     true,
+			wantProcessing:  string(core.ConditionFalse),
+			wantSucceeded:   string(core.ConditionTrue),
+			wantFinalizer:   true,
+			wantRemediating: true,
+		},
+		{
+			name:            "DeletingDryRun",
+			remediation:     withCondition(newRemediation(true, nodeName, nil, RemediationFinalizer), RemediationSucceeded, string(core.ConditionTrue)),
+			dryRun:          true,
+			wantSucceeded:   string(core.ConditionTrue),
+			wantFinalizer:   true,
+			wantRemediating: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), tc.remediation)
+			node := cordoned
+			if tc.node != nil {
+				node = tc.node
+			}
+			k := fake.NewSimpleClientset(node.DeepCopy())
+			r := &fakeRequester{results: tc.results}
+			o := []RemediationControllerOption{}
+			if tc.dryRun {
+				o = append(o, WithRemediationDryRun())
+			}
+			keys := DefaultKeys()
+			if tc.prefix != "" {
+				keys = NewKeys(tc.prefix)
+				o = append(o, WithRemediationKeys(keys))
+			}
+			rc := NewRemediationController(c, k, nodes, r, o...)
+			rc.handle(tc.remediation)
+
+			got, err := c.Resource(DrainRemediationResource).Namespace(remediationNamespace).Get(tc.remediation.GetName(), meta.GetOptions{})
+			if err != nil {
+				t.Fatalf("c.Resource(...).Get(%v): %v", tc.remediation.GetName(), err)
+			}
+			if s, _ := condition(got, RemediationProcessing); s != tc.wantProcessing {
+				t.Errorf("%s condition: want %q, got %q", RemediationProcessing, tc.wantProcessing, s)
+			}
+			if s, _ := condition(got, RemediationSucceeded); s != tc.wantSucceeded {
+				t.Errorf("%s condition: want %q, got %q", RemediationSucceeded, tc.wantSucceeded, s)
+			}
+			if f := hasString(got.GetFinalizers(), keys.RemediationFinalizer); f != tc.wantFinalizer {
+				t.Errorf("finalizer: want %v, got %v", tc.wantFinalizer, f)
+			}
+			if drained := len(r.requested) > 0; drained != tc.wantDrained {
+				t.Errorf("node drained: want %v, got %v", tc.wantDrained, drained)
+			}
+			n, err := k.CoreV1().Nodes().Get(nodeName, meta.GetOptions{})
+			if err != nil {
+				t.Fatalf("k.CoreV1().Nodes().Get(%v): %v", nodeName, err)
+			}
+			if uncordoned := !n.Spec.Unschedulable; uncordoned != tc.wantUncordoned {
+				t.Errorf("node uncordoned: want %v, got %v", tc.wantUncordoned, uncordoned)
+			}
+
+			if err := rc.i.GetStore().Add(tc.remediation); err != nil {
+				t.Fatalf("rc.i.GetStore().Add(): %v", err)
+			}
+			if m := rc.Remediating(cordoned); m != tc.wantRemediating {
+				t.Errorf("rc.Remediating(): want %v, got %v", tc.wantRemediating, m)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Last ready replica policies.
+const (
+	// LastReadyReplicaPolicyEvict evicts pods regardless of whether they are
+	// the last ready replica of their controller.
+	LastReadyReplicaPolicyEvict = "evict"
+
+	// LastReadyReplicaPolicyDefer waits to evict the last ready replica of a
+	// controller until another of its replicas is ready.
+	LastReadyReplicaPolicyDefer = "defer"
+
+	// LastReadyReplicaPolicySkip never evicts the last ready replica of a
+	// controller.
+	LastReadyReplicaPolicySkip = "skip"
+)
+
+// NewLastReadyReplicaPodFilter returns a FilterFunc that returns false if the
+// supplied pod is the last ready replica of its workload, per the supplied
+// PodStore.
+func NewLastReadyReplicaPodFilter(client kubernetes.Interface, pods PodStore) PodFilterFunc {
+	return func(p core.Pod) (bool, error) {
+		last, err := IsLastReadyReplica(client, pods, p)
+		if err != nil {
+			return false, err
+		}
+		return !last, nil
+	}
+}
+
+// IsLastReadyReplica returns true if the supplied pod is ready, and no other
+// pod of the same workload is ready on another node, per the supplied
+// PodStore. Other replicas on the same node are evicted along with the pod. A
+// pod's workload is its controller, unless that is a ReplicaSet controlled by
+// a Deployment; all pods selected by the Deployment count, so that replicas of
+// its old and new ReplicaSets protect each other during a rollout. This
+// protects workloads that are not covered by a pod disruption budget. Pods
+// without a controller and pods managed by a DaemonSet, which serve only their
+// own node, are never the last ready replica.
+func IsLastReadyReplica(client kubernetes.Interface, pods PodStore, p core.Pod) (bool, error) {
+	replica, err := replicaOf(client, p)
+	if err != nil || replica == nil {
+		return false, err
+	}
+	return lastReadyReplica(pods, p, replica)
+}
+
+// replicaOf returns a function that returns true if the supplied pod is a
+// replica of the supplied pod's workload, or nil if the supplied pod can never
+// be the last ready replica of its workload.
+func replicaOf(client kubernetes.Interface, p core.Pod) (func(r *core.Pod) bool, error) {
+	c := meta.GetControllerOf(&p)
+	if c == nil || c.Kind == kindDaemonSet {
+		return nil, nil
+	}
+	same := func(r *core.Pod) bool {
+		return r.GetNamespace() == p.GetNamespace() && sameController(c, meta.GetControllerOf(r))
+	}
+	if c.Kind != kindReplicaSet {
+		return same, nil
+	}
+	rs, err := client.AppsV1().ReplicaSets(p.GetNamespace()).Get(c.Name, meta.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return same, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot get ReplicaSet %s/%s", p.GetNamespace(), c.Name)
+	}
+	o := meta.GetControllerOf(rs)
+	if o == nil || o.Kind != kindDeployment {
+		return same, nil
+	}
+	d, err := client.AppsV1().Deployments(p.GetNamespace()).Get(o.Name, meta.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return same, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot get Deployment %s/%s", p.GetNamespace(), o.Name)
+	}
+	sel, err := meta.LabelSelectorAsSelector(d.Spec.Selector)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot parse selector of Deployment %s/%s", p.GetNamespace(), o.Name)
+	}
+	return func(r *core.Pod) bool {
+		return r.GetNamespace() == p.GetNamespace() && sel.Matches(labels.Set(r.GetLabels()))
+	}, nil
+}
+
+// lastReadyReplica returns true if the supplied pod is ready, and none of the
+// supplied PodStore's pods for which the supplied function returns true are
+// ready on another node.
+func lastReadyReplica(pods PodStore, p core.Pod, replica func(r *core.Pod) bool) (bool, error) {
+	if !podReady(&p) {
+		return false, nil
+	}
+	if !pods.HasSynced() {
+		return false, errors.New("pods have not been cached yet")
+	}
+	for _, r := range pods.List() {
+		if r.GetNamespace() == p.GetNamespace() && r.GetName() == p.GetName() {
+			continue
+		}
+		if r.Spec.NodeName == p.Spec.NodeName || !replica(r) {
+			continue
+		}
+		if podReady(r) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// sameController returns true if the supplied owner references refer to the
+// same controller.
+func sameController(a, b *meta.OwnerReference) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	if a.UID != "" && b.UID != "" {
+		return a.UID == b.UID
+	}
+	return a.Kind == b.Kind && a.Name == b.Name
+}
+
+// podReady returns true if the supplied pod is ready and not terminating.
+func podReady(p *core.Pod) bool {
+	if p.GetDeletionTimestamp() != nil {
+		return false
+	}
+	for _, c := range p.Status.Conditions {
+		if c.Type == core.PodReady {
+			return c.Status == core.ConditionTrue
+		}
+	}
+	return false
+}
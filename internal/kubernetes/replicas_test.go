@@ -0,0 +1,149 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newReplica(name, kind, controller string, ready bool) *core.Pod {
+	status := core.ConditionFalse
+	if ready {
+		status = core.ConditionTrue
+	}
+	p := &core.Pod{
+		ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: name, Labels: map[string]string{"app": "cool"}},
+		Spec:       core.PodSpec{NodeName: nodeName},
+		Status:     core.PodStatus{Conditions: []core.PodCondition{{Type: core.PodReady, Status: status}}},
+	}
+	if controller != "" {
+		p.OwnerReferences = []meta.OwnerReference{{Controller: &isController, Kind: kind, Name: controller}}
+	}
+	return p
+}
+
+func onNode(p *core.Pod, node string) *core.Pod {
+	p.Spec.NodeName = node
+	return p
+}
+
+func TestIsLastReadyReplica(t *testing.T) {
+	deployment := &apps.Deployment{
+		ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: deploymentName},
+		Spec:       apps.DeploymentSpec{Selector: &meta.LabelSelector{MatchLabels: map[string]string{"app": "cool"}}},
+	}
+	replicaSet := func(name string) *apps.ReplicaSet {
+		return &apps.ReplicaSet{ObjectMeta: meta.ObjectMeta{
+			Namespace:       ns,
+			Name:            name,
+			OwnerReferences: []meta.OwnerReference{{Controller: &isController, Kind: kindDeployment, Name: deploymentName}},
+		}}
+	}
+
+	cases := []struct {
+		name    string
+		pod     *core.Pod
+		others  []*core.Pod
+		objects []runtime.Object
+		want    bool
+	}{
+		{
+			name: "OnlyReplica",
+			pod:  newReplica(podName, kindReplicaSet, "coolReplicaSet", true),
+			want: true,
+		},
+		{
+			name:   "OtherReplicaReady",
+			pod:    newReplica(podName, kindReplicaSet, "coolReplicaSet", true),
+			others: []*core.Pod{onNode(newReplica("other", kindReplicaSet, "coolReplicaSet", true), "otherNode")},
+		},
+		{
+			name:   "OtherReplicaNotReady",
+			pod:    newReplica(podName, kindReplicaSet, "coolReplicaSet", true),
+			others: []*core.Pod{onNode(newReplica("other", kindReplicaSet, "coolReplicaSet", false), "otherNode")},
+			want:   true,
+		},
+		{
+			name:   "OtherReplicaOnSameNode",
+			pod:    newReplica(podName, kindReplicaSet, "coolReplicaSet", true),
+			others: []*core.Pod{newReplica("other", kindReplicaSet, "coolReplicaSet", true)},
+			want:   true,
+		},
+		{
+			name:   "OtherControllerReady",
+			pod:    newReplica(podName, kindReplicaSet, "coolReplicaSet", true),
+			others: []*core.Pod{onNode(newReplica("other", kindReplicaSet, "otherReplicaSet", true), "otherNode")},
+			want:   true,
+		},
+		{
+			name:    "OtherReplicaSetOfDeploymentReady",
+			pod:     newReplica(podName, kindReplicaSet, "coolReplicaSet", true),
+			others:  []*core.Pod{onNode(newReplica("other", kindReplicaSet, "otherReplicaSet", true), "otherNode")},
+			objects: []runtime.Object{deployment, replicaSet("coolReplicaSet"), replicaSet("otherReplicaSet")},
+		},
+		{
+			name: "OtherReplicaSetOfDeploymentNotReady",
+			pod:  newReplica(podName, kindReplicaSet, "coolReplicaSet", true),
+			others: []*core.Pod{
+				onNode(newReplica("other", kindReplicaSet, "otherReplicaSet", false), "otherNode"),
+				newReplica("another", kindReplicaSet, "otherReplicaSet", true),
+			},
+			objects: []runtime.Object{deployment, replicaSet("coolReplicaSet"), replicaSet("otherReplicaSet")},
+			want:    true,
+		},
+		{
+			name: "NotReady",
+			pod:  newReplica(podName, kindReplicaSet, "coolReplicaSet", false),
+		},
+		{
+			name: "NoController",
+			pod:  newReplica(podName, "", "", true),
+		},
+		{
+			name: "DaemonSet",
+			pod:  newReplica(podName, kindDaemonSet, daemonsetName, true),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := fake.NewSimpleClientset(tc.objects...)
+			pods := &staticPodStore{pods: append(tc.others, tc.pod)}
+			got, err := IsLastReadyReplica(c, pods, *tc.pod)
+			if err != nil {
+				t.Fatalf("IsLastReadyReplica(%v): %v", tc.pod.GetName(), err)
+			}
+			if got != tc.want {
+				t.Errorf("IsLastReadyReplica(%v): want %v, got %v", tc.pod.GetName(), tc.want, got)
+			}
+
+			passes, err := NewLastReadyReplicaPodFilter(c, pods)(*tc.pod)
+			if err != nil {
+				t.Fatalf("NewLastReadyReplicaPodFilter(c, pods)(%v): %v", tc.pod.GetName(), err)
+			}
+			if passes == tc.want {
+				t.Errorf("NewLastReadyReplicaPodFilter(c, pods)(%v): want %v, got %v", tc.pod.GetName(), !tc.want, passes)
+			}
+		})
+	}
+}
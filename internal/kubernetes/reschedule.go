@@ -0,0 +1,352 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+const eventReasonDrainDeferred = "DrainDeferred"
+
+// labelHostname is the well known label whose value is a node's hostname,
+// and thus the topology key that distinguishes nodes.
+const labelHostname = "kubernetes.io/hostname"
+
+// NewReschedulingGate returns a DrainGateFunc that prevents drains from
+// starting unless the pods that the supplied filter would evict from the node
+// fit on the remaining schedulable nodes, considering their resource requests,
+// node selectors, required node affinity, tolerations, required pod
+// anti-affinity between nodes, and topology spread constraints that must be
+// satisfied. A warning event is recorded on the node each time its drain is
+// deferred for a new reason, unless the supplied recorder is nil.
+func NewReschedulingGate(pods PodStore, nodes NodeStore, filter PodFilterFunc, e record.EventRecorder) DrainGateFunc {
+	var (
+		mu       sync.Mutex
+		deferred = make(map[string]string)
+	)
+	return func(n *core.Node, _ time.Time) (bool, string) {
+		reason := ""
+		if err := reschedulable(pods, nodes, filter, n); err != nil {
+			reason = err.Error()
+		}
+
+		mu.Lock()
+		last := deferred[n.GetName()]
+		if reason == "" {
+			delete(deferred, n.GetName())
+		} else {
+			deferred[n.GetName()] = reason
+		}
+		mu.Unlock()
+
+		if reason != "" && reason != last && e != nil {
+			nr := &core.ObjectReference{Kind: "Node", Name: n.GetName(), UID: types.UID(n.GetName())}
+			e.Eventf(nr, core.EventTypeWarning, eventReasonDrainDeferred, "Drain deferred: %s", reason)
+		}
+		return reason == "", reason
+	}
+}
+
+// A capacity is the unrequested capacity of a node.
+type capacity struct {
+	node   *core.Node
+	cpu    int64
+	memory int64
+	pods   int64
+	placed []*core.Pod
+}
+
+func (c *capacity) fits(p *core.Pod) bool {
+	cpu, memory := podRequests(p)
+	return cpu <= c.cpu && memory <= c.memory && c.pods > 0
+}
+
+func (c *capacity) place(p *core.Pod) {
+	cpu, memory := podRequests(p)
+	c.cpu -= cpu
+	c.memory -= memory
+	c.pods--
+	c.placed = append(c.placed, p)
+}
+
+// reschedulable returns an error describing the first pod that the supplied
+// filter would evict from the supplied node that could not be rescheduled on
+// another schedulable node. Pods managed by a DaemonSet are not rescheduled
+// elsewhere, and so are not considered.
+func reschedulable(pods PodStore, nodes NodeStore, filter PodFilterFunc, n *core.Node) error {
+	if !pods.HasSynced() {
+		return errors.New("pods have not been cached yet")
+	}
+
+	byNode := make(map[string][]*core.Pod)
+	for _, p := range pods.List() {
+		byNode[p.Spec.NodeName] = append(byNode[p.Spec.NodeName], p)
+	}
+
+	evict := []*core.Pod{}
+	for _, p := range byNode[n.GetName()] {
+		if o := meta.GetControllerOf(p); o != nil && o.Kind == kindDaemonSet {
+			continue
+		}
+		passes, err := filter(*p)
+		if err != nil {
+			return errors.Wrapf(err, "cannot filter pod %s/%s", p.GetNamespace(), p.GetName())
+		}
+		if passes {
+			evict = append(evict, p)
+		}
+	}
+	if len(evict) == 0 {
+		return nil
+	}
+
+	candidates := []*capacity{}
+	for _, o := range nodes.List() {
+		if o.GetName() == n.GetName() || o.Spec.Unschedulable || !nodeReady(o) {
+			continue
+		}
+		cp := &capacity{
+			node:   o,
+			cpu:    o.Status.Allocatable.Cpu().MilliValue(),
+			memory: o.Status.Allocatable.Memory().Value(),
+			pods:   o.Status.Allocatable.Pods().Value(),
+			placed: byNode[o.GetName()],
+		}
+		for _, p := range byNode[o.GetName()] {
+			cpu, memory := podRequests(p)
+			cp.cpu -= cpu
+			cp.memory -= memory
+			cp.pods--
+		}
+		candidates = append(candidates, cp)
+	}
+
+	// Place the largest pods first, as a scheduler filling the cluster
+	// would find it hardest to place them.
+	sort.SliceStable(evict, func(i, j int) bool {
+		ci, mi := podRequests(evict[i])
+		cj, mj := podRequests(evict[j])
+		if ci != cj {
+			return ci > cj
+		}
+		return mi > mj
+	})
+	for _, p := range evict {
+		spread := pods.TopologySpreadConstraints(p)
+		placed := false
+		for _, cp := range candidates {
+			if !schedulableOn(p, cp) || !spreadable(p, spread, cp, candidates) {
+				continue
+			}
+			cp.place(p)
+			placed = true
+			break
+		}
+		if !placed {
+			return errors.Errorf("pod %s/%s would not fit on any other schedulable node", p.GetNamespace(), p.GetName())
+		}
+	}
+	return nil
+}
+
+// schedulableOn returns true if the supplied pod could be scheduled to the
+// supplied node's remaining capacity.
+func schedulableOn(p *core.Pod, cp *capacity) bool {
+	return cp.fits(p) && matchesNodeSelector(p, cp.node) && toleratesTaints(p, cp.node) && !antiAffine(p, cp)
+}
+
+// podRequests returns the CPU, in millicores, and memory, in bytes, that the
+// supplied pod requests.
+func podRequests(p *core.Pod) (int64, int64) {
+	var cpu, memory int64
+	for _, c := range p.Spec.Containers {
+		cpu += c.Resources.Requests.Cpu().MilliValue()
+		memory += c.Resources.Requests.Memory().Value()
+	}
+	// Init containers run one at a time, before the pod's containers.
+	for _, c := range p.Spec.InitContainers {
+		if v := c.Resources.Requests.Cpu().MilliValue(); v > cpu {
+			cpu = v
+		}
+		if v := c.Resources.Requests.Memory().Value(); v > memory {
+			memory = v
+		}
+	}
+	return cpu, memory
+}
+
+func matchesNodeSelector(p *core.Pod, n *core.Node) bool {
+	for k, v := range p.Spec.NodeSelector {
+		if n.GetLabels()[k] != v {
+			return false
+		}
+	}
+	if p.Spec.Affinity == nil || p.Spec.Affinity.NodeAffinity == nil || p.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return true
+	}
+	// Node selector terms are ORed, and their requirements ANDed.
+	for _, t := range p.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		matches := true
+		for _, r := range t.MatchExpressions {
+			if !matchesRequirement(r, n.GetLabels()) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesRequirement(r core.NodeSelectorRequirement, nodeLabels map[string]string) bool {
+	v, ok := nodeLabels[r.Key]
+	switch r.Operator {
+	case core.NodeSelectorOpIn:
+		return ok && hasString(r.Values, v)
+	case core.NodeSelectorOpNotIn:
+		return !ok || !hasString(r.Values, v)
+	case core.NodeSelectorOpExists:
+		return ok
+	case core.NodeSelectorOpDoesNotExist:
+		return !ok
+	case core.NodeSelectorOpGt, core.NodeSelectorOpLt:
+		if !ok || len(r.Values) != 1 {
+			return false
+		}
+		have, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return false
+		}
+		want, err := strconv.ParseInt(r.Values[0], 10, 64)
+		if err != nil {
+			return false
+		}
+		if r.Operator == core.NodeSelectorOpGt {
+			return have > want
+		}
+		return have < want
+	}
+	return false
+}
+
+func toleratesTaints(p *core.Pod, n *core.Node) bool {
+	for i := range n.Spec.Taints {
+		t := &n.Spec.Taints[i]
+		if t.Effect == core.TaintEffectPreferNoSchedule {
+			continue
+		}
+		tolerated := false
+		for j := range p.Spec.Tolerations {
+			if p.Spec.Tolerations[j].ToleratesTaint(t) {
+				tolerated = true
+				break
+			}
+		}
+		if !tolerated {
+			return false
+		}
+	}
+	return true
+}
+
+// antiAffine returns true if the supplied pod requires that it not be
+// scheduled to the same node as any pod already placed on the supplied node.
+// Only anti-affinity between nodes, i.e. with the labelHostname topology key,
+// is considered.
+func antiAffine(p *core.Pod, cp *capacity) bool {
+	if p.Spec.Affinity == nil || p.Spec.Affinity.PodAntiAffinity == nil {
+		return false
+	}
+	for _, t := range p.Spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution {
+		if t.TopologyKey != labelHostname || t.LabelSelector == nil {
+			continue
+		}
+		selector, err := meta.LabelSelectorAsSelector(t.LabelSelector)
+		if err != nil {
+			continue
+		}
+		namespaces := t.Namespaces
+		if len(namespaces) == 0 {
+			namespaces = []string{p.GetNamespace()}
+		}
+		for _, o := range cp.placed {
+			if hasString(namespaces, o.GetNamespace()) && selector.Matches(labels.Set(o.GetLabels())) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// spreadable returns true if scheduling the supplied pod to the supplied
+// node's remaining capacity would satisfy those of the supplied topology spread
+// constraints that must be satisfied, given the pods placed on each of the
+// supplied candidate nodes.
+func spreadable(p *core.Pod, constraints []TopologySpreadConstraint, cp *capacity, candidates []*capacity) bool {
+	for _, c := range constraints {
+		if c.WhenUnsatisfiable != TopologySpreadDoNotSchedule || c.LabelSelector == nil {
+			continue
+		}
+		domain, ok := cp.node.GetLabels()[c.TopologyKey]
+		if !ok {
+			return false
+		}
+		selector, err := meta.LabelSelectorAsSelector(c.LabelSelector)
+		if err != nil {
+			continue
+		}
+		// Only nodes the pod could be scheduled to, and that have the
+		// topology key, are domains the pod's replicas are spread across.
+		count := make(map[string]int32)
+		for _, o := range candidates {
+			d, ok := o.node.GetLabels()[c.TopologyKey]
+			if !ok || !matchesNodeSelector(p, o.node) {
+				continue
+			}
+			if _, ok := count[d]; !ok {
+				count[d] = 0
+			}
+			for _, placed := range o.placed {
+				if placed.GetNamespace() == p.GetNamespace() && selector.Matches(labels.Set(placed.GetLabels())) {
+					count[d]++
+				}
+			}
+		}
+		min := count[domain]
+		for _, v := range count {
+			if v < min {
+				min = v
+			}
+		}
+		if count[domain]+1-min > c.MaxSkew {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,212 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const otherNodeName = "otherNode"
+
+func newCapacityNode(name, cpu string) *core.Node {
+	return &core.Node{
+		ObjectMeta: meta.ObjectMeta{Name: name, Labels: map[string]string{labelHostname: name}},
+		Status: core.NodeStatus{
+			Allocatable: core.ResourceList{
+				core.ResourceCPU:    resource.MustParse(cpu),
+				core.ResourceMemory: resource.MustParse("4Gi"),
+				core.ResourcePods:   resource.MustParse("110"),
+			},
+			Conditions: []core.NodeCondition{{Type: core.NodeReady, Status: core.ConditionTrue}},
+		},
+	}
+}
+
+func newRequestingPod(name, node, cpu string) *core.Pod {
+	return &core.Pod{
+		ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: name, Labels: map[string]string{"app": name}},
+		Spec: core.PodSpec{
+			NodeName: node,
+			Containers: []core.Container{{Resources: core.ResourceRequirements{
+				Requests: core.ResourceList{core.ResourceCPU: resource.MustParse(cpu)},
+			}}},
+		},
+		Status: core.PodStatus{Phase: core.PodRunning},
+	}
+}
+
+func TestReschedulingGate(t *testing.T) {
+	draining := newCapacityNode(nodeName, "4")
+	spread := func(when string) []TopologySpreadConstraint {
+		return []TopologySpreadConstraint{{
+			MaxSkew:           1,
+			TopologyKey:       "zone",
+			WhenUnsatisfiable: when,
+			LabelSelector: &meta.LabelSelector{MatchExpressions: []meta.LabelSelectorRequirement{
+				{Key: "app", Operator: meta.LabelSelectorOpIn, Values: []string{podName, "replica"}},
+			}},
+		}}
+	}
+
+	cases := []struct {
+		name     string
+		other    func(n *core.Node)
+		pod      func(p *core.Pod)
+		running  []*core.Pod
+		spread   []TopologySpreadConstraint
+		zoned    bool
+		wantOpen bool
+	}{
+		{
+			name:     "Fits",
+			wantOpen: true,
+		},
+		{
+			name:    "TooBig",
+			running: []*core.Pod{newRequestingPod("big", otherNodeName, "1500m")},
+		},
+		{
+			name:  "OtherNodeUnschedulable",
+			other: func(n *core.Node) { n.Spec.Unschedulable = true },
+		},
+		{
+			name: "NodeSelectorMismatch",
+			pod:  func(p *core.Pod) { p.Spec.NodeSelector = map[string]string{"pool": "cool"} },
+		},
+		{
+			name:     "NodeSelectorMatch",
+			other:    func(n *core.Node) { n.Labels["pool"] = "cool" },
+			pod:      func(p *core.Pod) { p.Spec.NodeSelector = map[string]string{"pool": "cool"} },
+			wantOpen: true,
+		},
+		{
+			name: "NodeAffinityMismatch",
+			pod: func(p *core.Pod) {
+				p.Spec.Affinity = &core.Affinity{NodeAffinity: &core.NodeAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: &core.NodeSelector{NodeSelectorTerms: []core.NodeSelectorTerm{{
+						MatchExpressions: []core.NodeSelectorRequirement{{Key: "pool", Operator: core.NodeSelectorOpIn, Values: []string{"cool"}}},
+					}}},
+				}}
+			},
+		},
+		{
+			name: "UntoleratedTaint",
+			other: func(n *core.Node) {
+				n.Spec.Taints = []core.Taint{{Key: "dedicated", Effect: core.TaintEffectNoSchedule}}
+			},
+		},
+		{
+			name: "ToleratedTaint",
+			other: func(n *core.Node) {
+				n.Spec.Taints = []core.Taint{{Key: "dedicated", Effect: core.TaintEffectNoSchedule}}
+			},
+			pod: func(p *core.Pod) {
+				p.Spec.Tolerations = []core.Toleration{{Key: "dedicated", Operator: core.TolerationOpExists}}
+			},
+			wantOpen: true,
+		},
+		{
+			name:    "AntiAffinity",
+			running: []*core.Pod{newRequestingPod("replica", otherNodeName, "100m")},
+			pod: func(p *core.Pod) {
+				p.Spec.Affinity = &core.Affinity{PodAntiAffinity: &core.PodAntiAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: []core.PodAffinityTerm{{
+						LabelSelector: &meta.LabelSelector{MatchLabels: map[string]string{"app": "replica"}},
+						TopologyKey:   labelHostname,
+					}},
+				}}
+			},
+		},
+		{
+			name: "DaemonSetPod",
+			pod: func(p *core.Pod) {
+				p.Spec.Containers[0].Resources.Requests[core.ResourceCPU] = resource.MustParse("8")
+				p.OwnerReferences = []meta.OwnerReference{{Controller: &isController, Kind: kindDaemonSet, Name: daemonsetName}}
+			},
+			wantOpen: true,
+		},
+		{
+			// The pod does not fit in the other zone, and would skew its
+			// zone by two replicas.
+			name:    "TopologySpreadUnsatisfiable",
+			running: []*core.Pod{newRequestingPod("replica", otherNodeName, "100m")},
+			spread:  spread(TopologySpreadDoNotSchedule),
+			zoned:   true,
+		},
+		{
+			name:     "TopologySpreadSatisfiable",
+			spread:   spread(TopologySpreadDoNotSchedule),
+			zoned:    true,
+			wantOpen: true,
+		},
+		{
+			name:     "TopologySpreadScheduleAnyway",
+			running:  []*core.Pod{newRequestingPod("replica", otherNodeName, "100m")},
+			spread:   spread(TopologySpreadScheduleAnyway),
+			zoned:    true,
+			wantOpen: true,
+		},
+		{
+			name:   "TopologySpreadNodeWithoutKey",
+			spread: spread(TopologySpreadDoNotSchedule),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			other := newCapacityNode(otherNodeName, "2")
+			if tc.other != nil {
+				tc.other(other)
+			}
+			nodes := staticNodeStore{draining, other}
+			if tc.zoned {
+				other.Labels["zone"] = "a"
+				zoned := newCapacityNode("zonedNode", "500m")
+				zoned.Labels["zone"] = "b"
+				nodes = append(nodes, zoned)
+			}
+			pod := newRequestingPod(podName, nodeName, "1")
+			if tc.pod != nil {
+				tc.pod(pod)
+			}
+			pods := &staticPodStore{pods: append(tc.running, pod), spread: map[string][]TopologySpreadConstraint{podName: tc.spread}}
+			e := record.NewFakeRecorder(2)
+			g := NewReschedulingGate(pods, nodes, NewPodFilters(), e)
+
+			for i := 0; i < 2; i++ {
+				open, reason := g(draining, time.Now())
+				if open != tc.wantOpen {
+					t.Errorf("g(%v): want open %v, got %v: %s", draining.GetName(), tc.wantOpen, open, reason)
+				}
+			}
+			// Drains deferred repeatedly for the same reason are recorded once.
+			wantEvents := 1
+			if tc.wantOpen {
+				wantEvents = 0
+			}
+			if len(e.Events) != wantEvents {
+				t.Errorf("events: want %d, got %d", wantEvents, len(e.Events))
+			}
+		})
+	}
+}
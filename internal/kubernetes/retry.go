@@ -0,0 +1,120 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// Default API retry settings.
+const (
+	DefaultAPIRetries    = 4
+	DefaultAPIRetryDelay = 500 * time.Millisecond
+)
+
+// Error classes, with which failure metrics are tagged.
+const (
+	// ErrorClassTimeout errors are requests that timed out.
+	ErrorClassTimeout = "timeout"
+
+	// ErrorClassServer errors are internal errors of the API server, or of
+	// a webhook it called.
+	ErrorClassServer = "server"
+
+	// ErrorClassConflict errors are updates of objects that were modified
+	// since they were read.
+	ErrorClassConflict = "conflict"
+
+	// ErrorClassThrottled errors are requests the API server throttled.
+	ErrorClassThrottled = "throttled"
+
+	// ErrorClassFatal errors are those that retrying will not resolve, for
+	// example because an object does not exist or draino is forbidden from
+	// reading it.
+	ErrorClassFatal = "fatal"
+)
+
+// ErrorClass returns the class of the supplied error.
+func ErrorClass(err error) string {
+	err = errors.Cause(err)
+	switch {
+	case apiThrottled(err):
+		return ErrorClassThrottled
+	case apierrors.IsConflict(err):
+		return ErrorClassConflict
+	case apierrors.IsTimeout(err), apierrors.IsServerTimeout(err), IsTimeout(err):
+		return ErrorClassTimeout
+	case apierrors.IsInternalError(err), apierrors.IsServiceUnavailable(err), apierrors.IsUnexpectedServerError(err):
+		return ErrorClassServer
+	}
+	if s, ok := err.(apierrors.APIStatus); ok && s.Status().Code >= 500 {
+		return ErrorClassServer
+	}
+	if n, ok := err.(net.Error); ok && n.Timeout() {
+		return ErrorClassTimeout
+	}
+	return ErrorClassFatal
+}
+
+// Retryable returns true if the supplied error may not recur if the request
+// that caused it is retried, i.e. if it is a timeout, server error, or
+// conflict. Throttled requests are retried separately.
+func Retryable(err error) bool {
+	switch ErrorClass(err) {
+	case ErrorClassTimeout, ErrorClassServer, ErrorClassConflict:
+		return true
+	default:
+		return false
+	}
+}
+
+// WithAPIRetries configures an APICordonDrainer to retry API requests that fail
+// with a Retryable error up to the supplied number of times, doubling the
+// supplied delay, with jitter, after each attempt. Requests are not retried if
+// retries is zero.
+func WithAPIRetries(retries int, delay time.Duration) APICordonDrainerOption {
+	return func(d *APICordonDrainer) {
+		d.retries = retries
+		d.retryDelay = delay
+	}
+}
+
+// retry calls the supplied function until it succeeds, returns an error that
+// is not Retryable, or the drainer's retries are exhausted. Functions that
+// update an object should read it afresh each time they are called, so that
+// conflicts may be resolved by retrying.
+func (d *APICordonDrainer) retry(op func() error) error {
+	delay := d.retryDelay
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || attempt > d.retries || !Retryable(err) {
+			return err
+		}
+		d.l.Debug("Retrying API request",
+			zap.Error(err),
+			zap.String("error_class", ErrorClass(err)),
+			zap.Int("attempt", attempt))
+		time.Sleep(wait.Jitter(delay, 1.0))
+		delay *= 2
+	}
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestErrorClass(t *testing.T) {
+	nodes := schema.GroupResource{Resource: "nodes"}
+	cases := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "Conflict", err: apierrors.NewConflict(nodes, nodeName, errExploded), want: ErrorClassConflict},
+		{name: "ServerTimeout", err: apierrors.NewServerTimeout(nodes, "update", 1), want: ErrorClassTimeout},
+		{name: "Timeout", err: apierrors.NewTimeoutError("slow", 1), want: ErrorClassTimeout},
+		{name: "NetworkTimeout", err: &net.OpError{Op: "dial", Err: context.DeadlineExceeded}, want: ErrorClassTimeout},
+		{name: "DrainTimeout", err: errors.Wrap(errTimeout{}, "timed out waiting for evictions to complete"), want: ErrorClassTimeout},
+		{name: "InternalError", err: apierrors.NewInternalError(errExploded), want: ErrorClassServer},
+		{name: "ServiceUnavailable", err: apierrors.NewServiceUnavailable("nope"), want: ErrorClassServer},
+		{name: "BadGateway", err: apierrors.NewGenericServerResponse(502, "get", nodes, nodeName, "", 0, true), want: ErrorClassServer},
+		{name: "Throttled", err: apierrors.NewGenericServerResponse(429, "get", nodes, nodeName, "", 1, true), want: ErrorClassThrottled},
+		{name: "NotFound", err: apierrors.NewNotFound(nodes, nodeName), want: ErrorClassFatal},
+		{name: "Forbidden", err: apierrors.NewForbidden(nodes, nodeName, errExploded), want: ErrorClassFatal},
+		{name: "Wrapped", err: errors.Wrap(apierrors.NewConflict(nodes, nodeName, errExploded), "cannot cordon node"), want: ErrorClassConflict},
+		{name: "Other", err: errExploded, want: ErrorClassFatal},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ErrorClass(tc.err); got != tc.want {
+				t.Errorf("ErrorClass(%v): want %v, got %v", tc.err, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCordonRetries(t *testing.T) {
+	nodes := schema.GroupResource{Resource: "nodes"}
+	cases := []struct {
+		name        string
+		errs        []error
+		retries     int
+		wantErr     bool
+		wantUpdates int
+	}{
+		{
+			name:        "RetryableErrors",
+			errs:        []error{apierrors.NewConflict(nodes, nodeName, errExploded), apierrors.NewInternalError(errExploded)},
+			retries:     2,
+			wantUpdates: 3,
+		},
+		{
+			name:        "RetriesExhausted",
+			errs:        []error{apierrors.NewConflict(nodes, nodeName, errExploded), apierrors.NewConflict(nodes, nodeName, errExploded)},
+			retries:     1,
+			wantErr:     true,
+			wantUpdates: 2,
+		},
+		{
+			name:        "FatalError",
+			errs:        []error{apierrors.NewForbidden(nodes, nodeName, errExploded)},
+			retries:     2,
+			wantErr:     true,
+			wantUpdates: 1,
+		},
+		{
+			name:        "NoRetries",
+			errs:        []error{apierrors.NewConflict(nodes, nodeName, errExploded)},
+			wantErr:     true,
+			wantUpdates: 1,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := fake.NewSimpleClientset(&core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}})
+			updates := 0
+			c.PrependReactor("update", "nodes", func(a clienttesting.Action) (bool, runtime.Object, error) {
+				updates++
+				if updates <= len(tc.errs) {
+					return true, nil, tc.errs[updates-1]
+				}
+				return false, nil, nil
+			})
+			d := NewAPICordonDrainer(c, WithAPIRetries(tc.retries, time.Millisecond))
+			err := d.Cordon(&core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}})
+			if (err != nil) != tc.wantErr {
+				t.Errorf("d.Cordon(%v): want error %v, got %v", nodeName, tc.wantErr, err)
+			}
+			if updates != tc.wantUpdates {
+				t.Errorf("updates: want %d, got %d", tc.wantUpdates, updates)
+			}
+		})
+	}
+}
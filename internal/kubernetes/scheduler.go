@@ -0,0 +1,865 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+	core "k8s.io/api/core/v1"
+)
+
+// DefaultDrainBuffer is the default minimum time between node drains.
+const DefaultDrainBuffer = 10 * time.Minute
+
+// DefaultShutdownGracePeriod is the default time to wait for running drains to
+// finish when shutting down.
+const DefaultShutdownGracePeriod = 1 * time.Minute
+
+const (
+	groupAllNodes = "*"
+
+	// How often to reconsider drains that a DrainGateFunc prevented from
+	// starting.
+	gateRecheckInterval = 1 * time.Minute
+)
+
+// A DrainGateFunc returns true if a drain of the supplied node may start at
+// the supplied time. Otherwise it returns false and the reason the drain may
+// not start.
+type DrainGateFunc func(n *core.Node, now time.Time) (bool, string)
+
+// A DrainLock must be held while a node is drained, for example to coordinate
+// with other tools that disrupt nodes.
+type DrainLock interface {
+	// Acquire the lock in order to drain the supplied node. Returns an error
+	// if the lock could not be acquired.
+	Acquire(n *core.Node) error
+
+	// Release the lock held in order to drain the supplied node.
+	Release(n *core.Node) error
+}
+
+// A NodeGroupFunc returns the group of the supplied node, and false if the
+// node belongs to no group.
+type NodeGroupFunc func(n *core.Node) (string, bool)
+
+// A ConcurrencyLimit limits how many nodes may be drained at once. Nodes are
+// grouped by the value of the supplied label key, or by the supplied group
+// function. A limit with neither applies to all nodes.
+type ConcurrencyLimit struct {
+	// LabelKey groups nodes by the value of this label. Nodes that do not
+	// have the label are not subject to the limit.
+	LabelKey string
+
+	// Group nodes using this function, rather than by label. Nodes that
+	// belong to no group are not subject to the limit.
+	Group NodeGroupFunc
+
+	// Max is the maximum number of concurrent drains per group.
+	Max int
+}
+
+// group returns the group of the supplied node for this limit, and false if
+// the node is not subject to this limit.
+func (l ConcurrencyLimit) group(n *core.Node) (string, bool) {
+	if l.Group != nil {
+		return l.Group(n)
+	}
+	if l.LabelKey == "" {
+		return groupAllNodes, true
+	}
+	v, ok := n.GetLabels()[l.LabelKey]
+	if !ok {
+		return "", false
+	}
+	return l.LabelKey + "=" + v, true
+}
+
+// A DrainScheduler decides when scheduled node drains may start. It ensures a
+// minimum time between starting each drain, that drains start no faster than
+// an optional rate limit allows, and that no more than the configured number
+// of drains run concurrently within each group of nodes.
+type DrainScheduler struct {
+	l        *zap.Logger
+	keys     Keys
+	buffer   time.Duration
+	jitter   time.Duration
+	bufferBy string
+	coolBy   string
+	cooldown time.Duration
+	random   *rand.Rand
+	limits   []ConcurrencyLimit
+	rate     *rate.Limiter
+	gates    []DrainGateFunc
+	recheck  time.Duration
+	priority NodePriorityFunc
+	score    NodeScoreFunc
+	urgent   []func(o interface{}) bool
+	lock     DrainLock
+
+	nodes       NodeStore
+	unavailable []ConcurrencyLimit
+	grace       time.Duration
+
+	mu          sync.Mutex
+	queue       []*scheduledDrain
+	scheduled   map[string]bool
+	draining    map[string]bool
+	running     []map[string]int
+	created     time.Time
+	lastStarted time.Time
+	bufferStart map[string]time.Time
+	drainedAt   map[string]time.Time
+	extra       time.Duration
+	timer       *time.Timer
+	stopped     bool
+	idle        chan struct{}
+
+	// locking is true while the drain lock is being acquired or released
+	// for a queued drain.
+	locking bool
+}
+
+type scheduledDrain struct {
+	node     *core.Node
+	groups   map[int]string
+	priority DrainPriority
+	score    float64
+	drain    func()
+	blocked  string
+	urgent   bool
+
+	// locked is true once the drain lock has been acquired for the drain.
+	locked bool
+}
+
+// DrainSchedulerOption configures a DrainScheduler.
+type DrainSchedulerOption func(s *DrainScheduler)
+
+// WithSchedulerLogger configures a DrainScheduler to use the supplied logger.
+func WithSchedulerLogger(l *zap.Logger) DrainSchedulerOption {
+	return func(s *DrainScheduler) {
+		s.l = l
+	}
+}
+
+// WithSchedulerKeys configures the keys of the annotations and labels with
+// which nodes may override the drain buffer.
+func WithSchedulerKeys(k Keys) DrainSchedulerOption {
+	return func(s *DrainScheduler) {
+		s.keys = k
+	}
+}
+
+// WithDrainBuffer configures the minimum time between starting drains.
+func WithDrainBuffer(d time.Duration) DrainSchedulerOption {
+	return func(s *DrainScheduler) {
+		s.buffer = d
+	}
+}
+
+// WithDrainBufferJitter adds a random duration of up to the supplied jitter to
+// each minimum time between drains, so that independent drain schedulers do not
+// start their drains in lockstep.
+func WithDrainBufferJitter(j time.Duration) DrainSchedulerOption {
+	return func(s *DrainScheduler) {
+		s.jitter = j
+	}
+}
+
+// WithDrainBufferPerLabel applies the minimum time between drains separately to
+// each group of nodes with the same value of the supplied label, rather than to
+// all nodes. Nodes without the label form their own group.
+func WithDrainBufferPerLabel(key string) DrainSchedulerOption {
+	return func(s *DrainScheduler) {
+		s.bufferBy = key
+	}
+}
+
+// WithGroupCooldown configures a cooldown after each successful drain of a node
+// with the supplied label, during which drains of other nodes with the same
+// value of the label may not start, giving the workloads of the group time to
+// restabilise. The cooldown is independent of the drain buffer, and starts
+// when Drained is called rather than when the drain starts. Nodes without the
+// label are not subject to the cooldown.
+func WithGroupCooldown(key string, d time.Duration) DrainSchedulerOption {
+	return func(s *DrainScheduler) {
+		s.coolBy = key
+		s.cooldown = d
+	}
+}
+
+// WithConcurrencyLimits configures limits on how many nodes may be drained
+// concurrently. A drain starts only once it satisfies all limits.
+func WithConcurrencyLimits(l ...ConcurrencyLimit) DrainSchedulerOption {
+	return func(s *DrainScheduler) {
+		s.limits = append(s.limits, l...)
+	}
+}
+
+// WithDrainRateLimit configures a token bucket rate limit on starting drains.
+// Up to burst drains may start in quick succession (subject to the drain
+// buffer), after which drains are permitted to start at the supplied rate.
+func WithDrainRateLimit(r rate.Limit, burst int) DrainSchedulerOption {
+	return func(s *DrainScheduler) {
+		s.rate = rate.NewLimiter(r, burst)
+	}
+}
+
+// WithDrainGates configures functions that may prevent drains from starting,
+// for example during blackout windows. A drain starts only once all gates are
+// open. Drains prevented from starting are reconsidered periodically.
+func WithDrainGates(g ...DrainGateFunc) DrainSchedulerOption {
+	return func(s *DrainScheduler) {
+		s.gates = append(s.gates, g...)
+	}
+}
+
+// WithNodePriority configures how drains are prioritised. Queued drains start
+// in priority order, so when limits, gates, or the buffer constrain how many
+// drains may start the highest priority drains start first. Drains of equal
+// priority start in the order they were scheduled.
+func WithNodePriority(fn NodePriorityFunc) DrainSchedulerOption {
+	return func(s *DrainScheduler) {
+		s.priority = fn
+	}
+}
+
+// WithNodeScore configures how drains of equal priority are ordered. Queued
+// drains of equal priority start in descending order of the score their node
+// had when they were scheduled, and in the order they were scheduled if their
+// scores are equal.
+func WithNodeScore(fn NodeScoreFunc) DrainSchedulerOption {
+	return func(s *DrainScheduler) {
+		s.score = fn
+	}
+}
+
+// WithUrgentDrains configures filters that identify urgent drains, for example
+// of nodes that are about to be deleted. Urgent drains start as soon as they
+// are scheduled, regardless of the buffer, gates, rate limit, and concurrency
+// limits. They still count towards concurrency limits while running.
+func WithUrgentDrains(filters ...func(o interface{}) bool) DrainSchedulerOption {
+	return func(s *DrainScheduler) {
+		s.urgent = append(s.urgent, filters...)
+	}
+}
+
+// WithDrainLock configures a lock that must be held while each drain runs. A
+// drain starts only once it has acquired the lock. The lock is acquired and
+// released without blocking the scheduler, one drain at a time. Drains that
+// could not acquire the lock are reconsidered periodically. Urgent drains do
+// not wait for the lock.
+func WithDrainLock(l DrainLock) DrainSchedulerOption {
+	return func(s *DrainScheduler) {
+		s.lock = l
+	}
+}
+
+// WithMaxUnavailable configures limits on how many nodes may be unavailable at
+// once. Nodes are grouped as they are for concurrency limits. A node is
+// unavailable while it is being drained, or if the supplied store reports that
+// it is not ready. Drains of nodes that are already not ready are always
+// permitted. Drains prevented from starting are reconsidered periodically.
+func WithMaxUnavailable(nodes NodeStore, l ...ConcurrencyLimit) DrainSchedulerOption {
+	return func(s *DrainScheduler) {
+		s.nodes = nodes
+		s.unavailable = append(s.unavailable, l...)
+	}
+}
+
+// WithShutdownGracePeriod configures how long to wait for running drains to
+// finish when the scheduler is stopped.
+func WithShutdownGracePeriod(d time.Duration) DrainSchedulerOption {
+	return func(s *DrainScheduler) {
+		s.grace = d
+	}
+}
+
+// NewDrainScheduler returns a new DrainScheduler.
+func NewDrainScheduler(so ...DrainSchedulerOption) *DrainScheduler {
+	s := &DrainScheduler{
+		l:           zap.NewNop(),
+		keys:        DefaultKeys(),
+		buffer:      DefaultDrainBuffer,
+		recheck:     gateRecheckInterval,
+		grace:       DefaultShutdownGracePeriod,
+		priority:    func(_ *core.Node) DrainPriority { return PriorityNormal },
+		score:       func(_ *core.Node) float64 { return 0 },
+		scheduled:   make(map[string]bool),
+		draining:    make(map[string]bool),
+		created:     time.Now(),
+		bufferStart: make(map[string]time.Time),
+		drainedAt:   make(map[string]time.Time),
+		random:      rand.New(rand.NewSource(time.Now().UnixNano())), // nolint:gosec
+	}
+	s.lastStarted = s.created
+	for _, o := range so {
+		o(s)
+	}
+	s.running = make([]map[string]int, len(s.limits))
+	for i := range s.limits {
+		s.running[i] = make(map[string]int)
+	}
+	s.extra = s.nextJitter()
+	return s
+}
+
+// nextJitter returns a random duration to add to the buffer before the next
+// drain starts. It must be called with s.mu held.
+func (s *DrainScheduler) nextJitter() time.Duration {
+	if s.jitter <= 0 {
+		return 0
+	}
+	return time.Duration(s.random.Int63n(int64(s.jitter)))
+}
+
+// lastStartedFor returns the time at which the most recent drain that counts
+// towards the buffer of the supplied node started. It must be called with s.mu
+// held.
+func (s *DrainScheduler) lastStartedFor(n *core.Node) time.Time {
+	if s.bufferBy == "" {
+		return s.lastStarted
+	}
+	if t, ok := s.bufferStart[n.GetLabels()[s.bufferBy]]; ok {
+		return t
+	}
+	return s.created
+}
+
+// Drained records that the supplied node was successfully drained, starting
+// the cooldown of its group, if any.
+func (s *DrainScheduler) Drained(n *core.Node) {
+	if s.coolBy == "" || s.cooldown <= 0 {
+		return
+	}
+	g, ok := n.GetLabels()[s.coolBy]
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.drainedAt[g] = time.Now()
+}
+
+// cooledDownAt returns the time at which the cooldown of the supplied node's
+// group ends, and true if it is subject to a cooldown. It must be called with
+// s.mu held.
+func (s *DrainScheduler) cooledDownAt(n *core.Node) (time.Time, bool) {
+	if s.coolBy == "" || s.cooldown <= 0 {
+		return time.Time{}, false
+	}
+	drained, ok := s.drainedAt[n.GetLabels()[s.coolBy]]
+	if !ok {
+		return time.Time{}, false
+	}
+	return drained.Add(s.cooldown), true
+}
+
+// bufferFor returns the minimum time between the most recently started drain
+// and a drain of the supplied node. Nodes may override the buffer using the
+// DrainBuffer annotation or label.
+func (s *DrainScheduler) bufferFor(n *core.Node) time.Duration {
+	v, ok := n.GetAnnotations()[s.keys.DrainBuffer]
+	if !ok {
+		v, ok = n.GetLabels()[s.keys.DrainBuffer]
+	}
+	if !ok {
+		return s.buffer
+	}
+	b, err := time.ParseDuration(v)
+	if err != nil || b < 0 {
+		s.l.Debug("Ignoring invalid drain buffer override", zap.String("node", n.GetName()), zap.String("buffer", v))
+		return s.buffer
+	}
+	return b
+}
+
+// Schedule a drain of the supplied node. The supplied drain function will be
+// called once the drain is permitted to start, and must return once the drain
+// has finished. Schedule returns the earliest time at which the drain could
+// start, or an error if a drain of the node is already scheduled. Urgent drains
+// start immediately.
+func (s *DrainScheduler) Schedule(n *core.Node, drain func()) (time.Time, error) {
+	// Scoring may call the Kubernetes API, so nodes are scored before the
+	// lock is taken.
+	score := s.score(n)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped {
+		return time.Time{}, errors.Errorf("cannot schedule drain of node %s: scheduler is stopped", n.GetName())
+	}
+	if s.scheduled[n.GetName()] {
+		return time.Time{}, errors.Errorf("drain of node %s is already scheduled", n.GetName())
+	}
+	d := &scheduledDrain{
+		node:     n,
+		groups:   make(map[int]string),
+		priority: s.priority(n),
+		score:    score,
+		drain:    drain,
+	}
+	for _, u := range s.urgent {
+		if u(n) {
+			d.urgent = true
+			break
+		}
+	}
+	for i, l := range s.limits {
+		if g, ok := l.group(n); ok {
+			d.groups[i] = g
+		}
+	}
+	s.scheduled[n.GetName()] = true
+	if d.urgent {
+		now := time.Now()
+		s.start(d, now)
+		return now, nil
+	}
+	s.enqueue(d)
+	after := time.Now()
+	for _, sd := range s.simulate(after) {
+		if sd.Node == n.GetName() {
+			after = sd.EarliestStart
+			break
+		}
+	}
+	s.dispatch()
+	return after, nil
+}
+
+// A ScheduledDrain is a drain that is waiting to start.
+type ScheduledDrain struct {
+	Node          string    `json:"node"`
+	Priority      string    `json:"priority"`
+	EarliestStart time.Time `json:"earliestStart"`
+	Blocked       string    `json:"blocked,omitempty"`
+}
+
+// Scheduled returns the drains waiting to start, in the order they will start
+// in, with the earliest time at which each could start given the drain buffer
+// of its group, any cooldown, and the drains queued ahead of it. Blocked is the
+// reason a drain cannot start at that time, or was most recently prevented
+// from starting, if any. Drains blocked by a concurrency limit or gate start
+// once it permits them, at a time that cannot be predicted.
+func (s *DrainScheduler) Scheduled() []ScheduledDrain {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.simulate(now)
+}
+
+// simulate starting the queued drains in queue order, returning the earliest
+// time at which each could start. Drains that a concurrency limit or gate
+// would prevent from starting at that time do not delay the drains queued
+// behind them. It must be called with s.mu held.
+func (s *DrainScheduler) simulate(now time.Time) []ScheduledDrain {
+	last := make(map[string]time.Time)
+	extra := s.extra
+	running := make([]map[string]int, len(s.running))
+	for i, r := range s.running {
+		running[i] = make(map[string]int, len(r))
+		for g, c := range r {
+			running[i][g] = c
+		}
+	}
+	scheduled := make([]ScheduledDrain, 0, len(s.queue))
+	for _, d := range s.queue {
+		group := s.bufferGroup(d.node)
+		prev, ok := last[group]
+		if !ok {
+			prev = s.lastStartedFor(d.node)
+		}
+		after := prev.Add(s.bufferFor(d.node) + extra)
+		if cooled, ok := s.cooledDownAt(d.node); ok && cooled.After(after) {
+			after = cooled
+		}
+		if after.Before(now) {
+			after = now
+		}
+		sd := ScheduledDrain{Node: d.node.GetName(), Priority: d.priority.String(), EarliestStart: after, Blocked: d.blocked}
+		if reason, ok := s.simulatePermitted(d, running, after); !ok {
+			sd.Blocked = reason
+			scheduled = append(scheduled, sd)
+			continue
+		}
+		for i, g := range d.groups {
+			running[i][g]++
+		}
+		last[group] = after
+		// The jitter of drains after the next is not yet known.
+		extra = 0
+		scheduled = append(scheduled, sd)
+	}
+	return scheduled
+}
+
+// simulatePermitted returns true if the supplied concurrency limit counts and
+// gates would permit the supplied drain to start at the supplied time, or
+// false and the reason they would not. It must be called with s.mu held.
+func (s *DrainScheduler) simulatePermitted(d *scheduledDrain, running []map[string]int, at time.Time) (string, bool) {
+	for i, g := range d.groups {
+		if running[i][g] >= s.limits[i].Max {
+			return fmt.Sprintf("%d drains in group %s are running or will start first", running[i][g], g), false
+		}
+	}
+	for _, g := range s.gates {
+		if open, reason := g(d.node, at); !open {
+			return reason, false
+		}
+	}
+	return "", true
+}
+
+// bufferGroup returns the group of nodes that share the supplied node's drain
+// buffer.
+func (s *DrainScheduler) bufferGroup(n *core.Node) string {
+	if s.bufferBy == "" {
+		return groupAllNodes
+	}
+	return n.GetLabels()[s.bufferBy]
+}
+
+// enqueue the supplied drain after all queued drains of higher priority, or
+// of equal priority and equal or higher score, returning its position in the
+// queue. It must be called with s.mu held.
+func (s *DrainScheduler) enqueue(d *scheduledDrain) int {
+	i := len(s.queue)
+	for i > 0 && s.queue[i-1].outrankedBy(d) {
+		i--
+	}
+	s.queue = append(s.queue, nil)
+	copy(s.queue[i+1:], s.queue[i:])
+	s.queue[i] = d
+	return i
+}
+
+// outrankedBy returns true if the supplied drain should start before this one.
+func (d *scheduledDrain) outrankedBy(o *scheduledDrain) bool {
+	if d.priority != o.priority {
+		return d.priority < o.priority
+	}
+	return d.score < o.score
+}
+
+// dispatch starts as many queued drains as the buffer, gates, rate limit, and
+// concurrency limits allow. It must be called with s.mu held.
+func (s *DrainScheduler) dispatch() {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if s.stopped {
+		s.releaseQueued()
+		return
+	}
+	gated := false
+	var wake time.Time
+	defer func() {
+		if s.timer != nil {
+			return
+		}
+		if recheck := time.Now().Add(s.recheck); gated && (wake.IsZero() || recheck.Before(wake)) {
+			wake = recheck
+		}
+		if !wake.IsZero() {
+			s.timer = time.AfterFunc(time.Until(wake), s.redispatch)
+		}
+	}()
+	for i := 0; i < len(s.queue); {
+		now := time.Now()
+		d := s.queue[i]
+		if next := s.lastStartedFor(d.node).Add(s.bufferFor(d.node) + s.extra); now.Before(next) {
+			// Nodes that override the buffer, or that belong to another
+			// buffer group, may still be permitted.
+			if wake.IsZero() || next.Before(wake) {
+				wake = next
+			}
+			i++
+			continue
+		}
+		if next, ok := s.cooledDownAt(d.node); ok && now.Before(next) {
+			s.block(d, fmt.Sprintf("cooling down until %s after draining a node with %s=%s", next.Format(time.RFC3339), s.coolBy, d.node.GetLabels()[s.coolBy]))
+			if wake.IsZero() || next.Before(wake) {
+				wake = next
+			}
+			i++
+			continue
+		}
+		if !s.permitted(d) {
+			// Drains of nodes in other groups may still be permitted.
+			i++
+			continue
+		}
+		if !s.open(d, now) || !s.available(d) || !s.acquire(d) {
+			gated = true
+			i++
+			continue
+		}
+		d.blocked = ""
+		if delay := s.reserve(now); delay > 0 {
+			// The drain keeps the lock while it waits for the rate
+			// limiter.
+			s.timer = time.AfterFunc(delay, s.redispatch)
+			return
+		}
+		s.queue = append(s.queue[:i], s.queue[i+1:]...)
+		s.start(d, now)
+	}
+	s.releaseQueued()
+}
+
+func (s *DrainScheduler) redispatch() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dispatch()
+}
+
+// open returns true if all gates permit the supplied drain to start.
+func (s *DrainScheduler) open(d *scheduledDrain, now time.Time) bool {
+	for _, g := range s.gates {
+		open, reason := g(d.node, now)
+		if open {
+			continue
+		}
+		s.block(d, reason)
+		return false
+	}
+	return true
+}
+
+// available returns true if the supplied drain would not cause more nodes to
+// be unavailable than any max unavailable limit allows.
+func (s *DrainScheduler) available(d *scheduledDrain) bool {
+	if len(s.unavailable) == 0 {
+		return true
+	}
+	candidate := d.node
+	if fresh, err := s.nodes.Get(d.node.GetName()); err == nil {
+		candidate = fresh
+	}
+	if !nodeReady(candidate) {
+		return true
+	}
+	nodes := s.nodes.List()
+	for _, l := range s.unavailable {
+		g, ok := l.group(candidate)
+		if !ok {
+			continue
+		}
+		unavailable := 0
+		for _, n := range nodes {
+			if n.GetName() == candidate.GetName() {
+				continue
+			}
+			if ng, ok := l.group(n); !ok || ng != g {
+				continue
+			}
+			if s.draining[n.GetName()] || !nodeReady(n) {
+				unavailable++
+			}
+		}
+		if unavailable >= l.Max {
+			s.block(d, fmt.Sprintf("%d nodes in group %s are unavailable", unavailable, g))
+			return false
+		}
+	}
+	return true
+}
+
+// nodeReady returns true if the supplied node's Ready condition is true.
+func nodeReady(n *core.Node) bool {
+	for _, c := range n.Status.Conditions {
+		if c.Type == core.NodeReady {
+			return c.Status == core.ConditionTrue
+		}
+	}
+	return false
+}
+
+// acquire returns true if the drain lock, if any, is held for the supplied
+// drain. Acquiring the lock may call the Kubernetes API, so it is acquired in
+// the background, dispatching again once it has been. It must be called with
+// s.mu held.
+func (s *DrainScheduler) acquire(d *scheduledDrain) bool {
+	if s.lock == nil || d.locked {
+		return true
+	}
+	if s.locking {
+		return false
+	}
+	s.locking = true
+	go func() {
+		err := s.lock.Acquire(d.node)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.locking = false
+		if err != nil {
+			s.block(d, err.Error())
+		} else {
+			d.locked = true
+		}
+		s.dispatch()
+	}()
+	return false
+}
+
+// releaseQueued releases the drain lock in the background if it is held for
+// a drain that did not start, for example because the scheduler stopped or a
+// gate closed while the lock was being acquired. It must be called with s.mu
+// held.
+func (s *DrainScheduler) releaseQueued() {
+	if s.locking {
+		return
+	}
+	locked := make([]*scheduledDrain, 0, 1)
+	for _, d := range s.queue {
+		if d.locked {
+			d.locked = false
+			locked = append(locked, d)
+		}
+	}
+	if len(locked) == 0 {
+		return
+	}
+	s.locking = true
+	go func() {
+		for _, d := range locked {
+			s.release(d)
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.locking = false
+		s.dispatch()
+	}()
+}
+
+// release the drain lock, if any, held for the supplied drain.
+func (s *DrainScheduler) release(d *scheduledDrain) {
+	if s.lock == nil || d.urgent {
+		return
+	}
+	if err := s.lock.Release(d.node); err != nil {
+		s.l.Info("Failed to release drain lock", zap.String("node", d.node.GetName()), zap.Error(err))
+	}
+}
+
+// block records that the supplied drain was prevented from starting, logging
+// the reason whenever it changes.
+func (s *DrainScheduler) block(d *scheduledDrain, reason string) {
+	if reason != d.blocked {
+		s.l.Info("Drain prevented from starting", zap.String("node", d.node.GetName()), zap.String("reason", reason))
+		d.blocked = reason
+	}
+}
+
+// reserve a token from the rate limiter, if any. It returns how long to wait
+// until a token will be available if one is not available now.
+func (s *DrainScheduler) reserve(now time.Time) time.Duration {
+	if s.rate == nil {
+		return 0
+	}
+	r := s.rate.ReserveN(now, 1)
+	delay := r.DelayFrom(now)
+	if delay > 0 {
+		r.CancelAt(now)
+	}
+	return delay
+}
+
+func (s *DrainScheduler) permitted(d *scheduledDrain) bool {
+	for i, g := range d.groups {
+		if s.running[i][g] >= s.limits[i].Max {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *DrainScheduler) start(d *scheduledDrain, now time.Time) {
+	s.lastStarted = now
+	if s.bufferBy != "" {
+		s.bufferStart[d.node.GetLabels()[s.bufferBy]] = now
+	}
+	s.extra = s.nextJitter()
+	s.draining[d.node.GetName()] = true
+	for i, g := range d.groups {
+		s.running[i][g]++
+	}
+	go func() {
+		d.drain()
+		s.release(d)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for i, g := range d.groups {
+			s.running[i][g]--
+		}
+		delete(s.scheduled, d.node.GetName())
+		delete(s.draining, d.node.GetName())
+		if s.idle != nil && len(s.draining) == 0 {
+			close(s.idle)
+			s.idle = nil
+		}
+		s.dispatch()
+	}()
+}
+
+// Run the scheduler until the supplied channel is closed. Once it is closed no
+// more drains will start, and Run waits up to the shutdown grace period for
+// running drains to finish before returning.
+func (s *DrainScheduler) Run(stop <-chan struct{}) {
+	<-stop
+
+	s.mu.Lock()
+	s.stopped = true
+	s.dispatch()
+	running := make([]string, 0, len(s.draining))
+	for name := range s.draining {
+		running = append(running, name)
+	}
+	if len(running) == 0 {
+		s.mu.Unlock()
+		return
+	}
+	idle := make(chan struct{})
+	s.idle = idle
+	s.mu.Unlock()
+
+	s.l.Info("Waiting for running drains to finish", zap.Strings("nodes", running), zap.Duration("grace", s.grace))
+	select {
+	case <-idle:
+		s.l.Info("Running drains finished")
+	case <-time.After(s.grace):
+		s.mu.Lock()
+		abandoned := make([]string, 0, len(s.draining))
+		for name := range s.draining {
+			abandoned = append(abandoned, name)
+		}
+		s.mu.Unlock()
+		s.l.Info("Abandoning running drains", zap.Strings("nodes", abandoned))
+	}
+}
@@ -0,0 +1,871 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"golang.org/x/time/rate"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const labelNodePool = "nodepool"
+
+func newPoolNode(name, pool string) *core.Node {
+	return &core.Node{ObjectMeta: meta.ObjectMeta{Name: name, Labels: map[string]string{labelNodePool: pool}}}
+}
+
+// concurrencyTracker records the maximum number of concurrent drains per key.
+type concurrencyTracker struct {
+	mu      sync.Mutex
+	current map[string]int
+	max     map[string]int
+}
+
+func (t *concurrencyTracker) drain(key string, wg *sync.WaitGroup) func() {
+	return func() {
+		defer wg.Done()
+		t.mu.Lock()
+		t.current[key]++
+		if t.current[key] > t.max[key] {
+			t.max[key] = t.current[key]
+		}
+		t.mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		t.mu.Lock()
+		t.current[key]--
+		t.mu.Unlock()
+	}
+}
+
+func TestDrainSchedulerConcurrencyLimits(t *testing.T) {
+	cases := []struct {
+		name    string
+		limits  []ConcurrencyLimit
+		nodes   []*core.Node
+		wantMax map[string]int
+	}{
+		{
+			name:   "ClusterWideLimit",
+			limits: []ConcurrencyLimit{{Max: 2}},
+			nodes: []*core.Node{
+				newPoolNode("a", "cool"),
+				newPoolNode("b", "cool"),
+				newPoolNode("c", "lame"),
+				newPoolNode("d", "lame"),
+			},
+			wantMax: map[string]int{groupAllNodes: 2},
+		},
+		{
+			name:   "PerLabelLimit",
+			limits: []ConcurrencyLimit{{LabelKey: labelNodePool, Max: 1}},
+			nodes: []*core.Node{
+				newPoolNode("a", "cool"),
+				newPoolNode("b", "cool"),
+				newPoolNode("c", "lame"),
+				newPoolNode("d", "lame"),
+			},
+			wantMax: map[string]int{"cool": 1, "lame": 1},
+		},
+		{
+			name: "GroupFuncLimit",
+			limits: []ConcurrencyLimit{{
+				Group: func(n *core.Node) (string, bool) { return n.GetLabels()[labelNodePool], true },
+				Max:   1,
+			}},
+			nodes: []*core.Node{
+				newPoolNode("a", "cool"),
+				newPoolNode("b", "cool"),
+				newPoolNode("c", "lame"),
+				newPoolNode("d", "lame"),
+			},
+			wantMax: map[string]int{"cool": 1, "lame": 1},
+		},
+		{
+			name:   "PerLabelAndClusterWideLimits",
+			limits: []ConcurrencyLimit{{LabelKey: labelNodePool, Max: 2}, {Max: 1}},
+			nodes: []*core.Node{
+				newPoolNode("a", "cool"),
+				newPoolNode("b", "cool"),
+				newPoolNode("c", "lame"),
+			},
+			wantMax: map[string]int{groupAllNodes: 1},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewDrainScheduler(WithDrainBuffer(0*time.Second), WithConcurrencyLimits(tc.limits...))
+			tr := &concurrencyTracker{current: make(map[string]int), max: make(map[string]int)}
+			wg := &sync.WaitGroup{}
+			for _, n := range tc.nodes {
+				key := n.GetLabels()[labelNodePool]
+				if _, ok := tc.wantMax[groupAllNodes]; ok {
+					key = groupAllNodes
+				}
+				wg.Add(1)
+				if _, err := s.Schedule(n, tr.drain(key, wg)); err != nil {
+					t.Fatalf("s.Schedule(%v): %v", n.GetName(), err)
+				}
+			}
+			wg.Wait()
+			for k, want := range tc.wantMax {
+				if got := tr.max[k]; got != want {
+					t.Errorf("maximum concurrent drains of %v: want %v, got %v", k, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestDrainSchedulerBuffer(t *testing.T) {
+	buffer := 50 * time.Millisecond
+	s := NewDrainScheduler(WithDrainBuffer(buffer))
+	s.lastStarted = time.Now().Add(-buffer)
+
+	started := make(chan time.Time, 2)
+	for i := 0; i < 2; i++ {
+		n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: fmt.Sprintf("node-%d", i)}}
+		if _, err := s.Schedule(n, func() { started <- time.Now() }); err != nil {
+			t.Fatalf("s.Schedule(%v): %v", n.GetName(), err)
+		}
+	}
+	first, second := <-started, <-started
+	if got := second.Sub(first); got < buffer {
+		t.Errorf("time between drains: want at least %v, got %v", buffer, got)
+	}
+}
+
+func TestDrainSchedulerBufferOverride(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		labels      map[string]string
+		wantStarted bool
+	}{
+		{
+			name:        "Annotation",
+			annotations: map[string]string{AnnotationDrainBuffer: "10ms"},
+			wantStarted: true,
+		},
+		{
+			name:        "Label",
+			labels:      map[string]string{AnnotationDrainBuffer: "10ms"},
+			wantStarted: true,
+		},
+		{
+			name:        "Invalid",
+			annotations: map[string]string{AnnotationDrainBuffer: "soon"},
+			wantStarted: false,
+		},
+		{
+			name:        "NoOverride",
+			wantStarted: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewDrainScheduler(WithDrainBuffer(1 * time.Hour))
+			n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName, Annotations: tc.annotations, Labels: tc.labels}}
+			started := make(chan struct{})
+			if _, err := s.Schedule(n, func() { close(started) }); err != nil {
+				t.Fatalf("s.Schedule(%v): %v", n.GetName(), err)
+			}
+			select {
+			case <-started:
+				if !tc.wantStarted {
+					t.Errorf("drain started: want drain to wait for the default buffer")
+				}
+			case <-time.After(200 * time.Millisecond):
+				if tc.wantStarted {
+					t.Errorf("drain did not start: want drain to start after its overridden buffer")
+				}
+			}
+		})
+	}
+}
+
+func TestDrainSchedulerBufferPerLabel(t *testing.T) {
+	s := NewDrainScheduler(WithDrainBuffer(1*time.Hour), WithDrainBufferPerLabel(labelNodePool))
+	s.created = time.Now().Add(-1 * time.Hour)
+
+	started := make(chan string, 3)
+	for _, n := range []*core.Node{newPoolNode("a-0", "a"), newPoolNode("a-1", "a"), newPoolNode("b-0", "b")} {
+		n := n
+		if _, err := s.Schedule(n, func() { started <- n.GetName() }); err != nil {
+			t.Fatalf("s.Schedule(%v): %v", n.GetName(), err)
+		}
+	}
+
+	// The first drain in each pool starts immediately, while the second drain
+	// in pool a waits for the buffer.
+	got := map[string]bool{}
+	timeout := time.After(1 * time.Second)
+	for len(got) < 2 {
+		select {
+		case name := <-started:
+			got[name] = true
+		case <-timeout:
+			t.Fatalf("drains did not start: got %v", got)
+		}
+	}
+	select {
+	case name := <-started:
+		got[name] = true
+	case <-time.After(100 * time.Millisecond):
+	}
+	if diff := deep.Equal(map[string]bool{"a-0": true, "b-0": true}, got); diff != nil {
+		t.Errorf("started drains: want != got: %v", diff)
+	}
+}
+
+func TestDrainSchedulerGroupCooldown(t *testing.T) {
+	s := NewDrainScheduler(WithDrainBuffer(0), WithGroupCooldown(labelNodePool, 1*time.Hour))
+
+	drained := make(chan struct{})
+	first := newPoolNode("a-0", "a")
+	if _, err := s.Schedule(first, func() { s.Drained(first); close(drained) }); err != nil {
+		t.Fatalf("s.Schedule(%v): %v", first.GetName(), err)
+	}
+	<-drained
+
+	started := make(chan string, 2)
+	for _, n := range []*core.Node{newPoolNode("a-1", "a"), newPoolNode("b-0", "b")} {
+		n := n
+		if _, err := s.Schedule(n, func() { started <- n.GetName() }); err != nil {
+			t.Fatalf("s.Schedule(%v): %v", n.GetName(), err)
+		}
+	}
+
+	// Pool a is cooling down after a-0 was drained, while pool b is not.
+	got := map[string]bool{}
+	timeout := time.After(200 * time.Millisecond)
+	for done := false; !done; {
+		select {
+		case name := <-started:
+			got[name] = true
+		case <-timeout:
+			done = true
+		}
+	}
+	if diff := deep.Equal(map[string]bool{"b-0": true}, got); diff != nil {
+		t.Errorf("started drains: want != got: %v", diff)
+	}
+}
+
+func TestDrainSchedulerBufferJitter(t *testing.T) {
+	buffer, jitter := 20*time.Millisecond, 40*time.Millisecond
+	s := NewDrainScheduler(WithDrainBuffer(buffer), WithDrainBufferJitter(jitter))
+	s.lastStarted = time.Now().Add(-buffer - jitter)
+
+	started := make(chan time.Time, 2)
+	for i := 0; i < 2; i++ {
+		n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: fmt.Sprintf("node-%d", i)}}
+		if _, err := s.Schedule(n, func() { started <- time.Now() }); err != nil {
+			t.Fatalf("s.Schedule(%v): %v", n.GetName(), err)
+		}
+	}
+	first, second := <-started, <-started
+	if got := second.Sub(first); got < buffer {
+		t.Errorf("time between drains: want at least %v, got %v", buffer, got)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if got := s.extra; got < 0 || got >= jitter {
+		t.Errorf("s.extra: want between 0 and %v, got %v", jitter, got)
+	}
+}
+
+func TestDrainSchedulerRateLimit(t *testing.T) {
+	interval := 50 * time.Millisecond
+	s := NewDrainScheduler(WithDrainBuffer(0*time.Second), WithDrainRateLimit(rate.Every(interval), 2))
+
+	started := make(chan time.Time, 3)
+	for i := 0; i < 3; i++ {
+		n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: fmt.Sprintf("node-%d", i)}}
+		if _, err := s.Schedule(n, func() { started <- time.Now() }); err != nil {
+			t.Fatalf("s.Schedule(%v): %v", n.GetName(), err)
+		}
+	}
+	first, second, third := <-started, <-started, <-started
+
+	// The first two drains fit within the burst, the third must wait.
+	if got := second.Sub(first); got >= interval {
+		t.Errorf("time between burst drains: want less than %v, got %v", interval, got)
+	}
+	if got := third.Sub(first); got < interval/2 {
+		t.Errorf("time before rate limited drain: want at least %v, got %v", interval/2, got)
+	}
+}
+
+func TestDrainSchedulerAlreadyScheduled(t *testing.T) {
+	s := NewDrainScheduler()
+	n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}
+	if _, err := s.Schedule(n, func() {}); err != nil {
+		t.Fatalf("s.Schedule(%v): %v", n.GetName(), err)
+	}
+	if _, err := s.Schedule(n, func() {}); err == nil {
+		t.Errorf("s.Schedule(%v): want error scheduling node twice", n.GetName())
+	}
+}
+
+func TestDrainSchedulerGates(t *testing.T) {
+	var mu sync.Mutex
+	closed := map[string]bool{"a": true}
+	gate := func(n *core.Node, _ time.Time) (bool, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		return !closed[n.GetName()], "closed for testing"
+	}
+
+	s := NewDrainScheduler(WithDrainBuffer(0*time.Second), WithDrainGates(gate))
+	s.recheck = 10 * time.Millisecond
+
+	started := make(chan string, 2)
+	for _, name := range []string{"a", "b"} {
+		n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: name}}
+		if _, err := s.Schedule(n, func() { started <- n.GetName() }); err != nil {
+			t.Fatalf("s.Schedule(%v): %v", n.GetName(), err)
+		}
+	}
+
+	// Node b is not gated, and should be drained despite node a being queued
+	// ahead of it.
+	if got := <-started; got != "b" {
+		t.Errorf("first drained node: want b, got %v", got)
+	}
+
+	mu.Lock()
+	closed["a"] = false
+	mu.Unlock()
+
+	select {
+	case got := <-started:
+		if got != "a" {
+			t.Errorf("second drained node: want a, got %v", got)
+		}
+	case <-time.After(1 * time.Second):
+		t.Errorf("node a was not drained after its gate opened")
+	}
+}
+
+func TestDrainSchedulerPriority(t *testing.T) {
+	priorities := map[string]DrainPriority{"Critical": PriorityCritical, "Meh": PriorityLow}
+	s := NewDrainScheduler(WithDrainBuffer(0*time.Second), WithConcurrencyLimits(ConcurrencyLimit{Max: 1}), WithNodePriority(NewConditionPriorityFunc(priorities)))
+
+	// Block the scheduler with a drain that does not finish until we've
+	// queued all the others.
+	release := make(chan struct{})
+	if _, err := s.Schedule(&core.Node{ObjectMeta: meta.ObjectMeta{Name: "blocker"}}, func() { <-release }); err != nil {
+		t.Fatalf("s.Schedule(blocker): %v", err)
+	}
+
+	nodes := []*core.Node{
+		&core.Node{ObjectMeta: meta.ObjectMeta{Name: "low"}, Status: core.NodeStatus{Conditions: []core.NodeCondition{
+			{Type: "Meh", Status: core.ConditionTrue},
+		}}},
+		&core.Node{ObjectMeta: meta.ObjectMeta{Name: "normal"}},
+		&core.Node{ObjectMeta: meta.ObjectMeta{Name: "critical"}, Status: core.NodeStatus{Conditions: []core.NodeCondition{
+			{Type: "Meh", Status: core.ConditionTrue},
+			{Type: "Critical", Status: core.ConditionTrue},
+		}}},
+		&core.Node{ObjectMeta: meta.ObjectMeta{Name: "alsonormal"}, Status: core.NodeStatus{Conditions: []core.NodeCondition{
+			{Type: "Critical", Status: core.ConditionFalse},
+		}}},
+	}
+	started := make(chan string, len(nodes))
+	for _, n := range nodes {
+		n := n
+		if _, err := s.Schedule(n, func() { started <- n.GetName() }); err != nil {
+			t.Fatalf("s.Schedule(%v): %v", n.GetName(), err)
+		}
+	}
+	close(release)
+
+	want := []string{"critical", "normal", "alsonormal", "low"}
+	for _, w := range want {
+		if got := <-started; got != w {
+			t.Errorf("drained node: want %v, got %v", w, got)
+		}
+	}
+}
+
+func TestDrainSchedulerScore(t *testing.T) {
+	scores := map[string]float64{"low": 0.1, "high": 0.9, "alsolow": 0.1}
+	priorities := map[string]DrainPriority{"Critical": PriorityCritical}
+	s := NewDrainScheduler(WithDrainBuffer(0*time.Second), WithConcurrencyLimits(ConcurrencyLimit{Max: 1}),
+		WithNodePriority(NewConditionPriorityFunc(priorities)),
+		WithNodeScore(func(n *core.Node) float64 { return scores[n.GetName()] }))
+
+	release := make(chan struct{})
+	if _, err := s.Schedule(&core.Node{ObjectMeta: meta.ObjectMeta{Name: "blocker"}}, func() { <-release }); err != nil {
+		t.Fatalf("s.Schedule(blocker): %v", err)
+	}
+
+	// Priority outranks score.
+	nodes := []*core.Node{
+		&core.Node{ObjectMeta: meta.ObjectMeta{Name: "low"}},
+		&core.Node{ObjectMeta: meta.ObjectMeta{Name: "high"}},
+		&core.Node{ObjectMeta: meta.ObjectMeta{Name: "critical"}, Status: core.NodeStatus{Conditions: []core.NodeCondition{
+			{Type: "Critical", Status: core.ConditionTrue},
+		}}},
+		&core.Node{ObjectMeta: meta.ObjectMeta{Name: "alsolow"}},
+	}
+	started := make(chan string, len(nodes))
+	for _, n := range nodes {
+		n := n
+		if _, err := s.Schedule(n, func() { started <- n.GetName() }); err != nil {
+			t.Fatalf("s.Schedule(%v): %v", n.GetName(), err)
+		}
+	}
+	close(release)
+
+	want := []string{"critical", "high", "low", "alsolow"}
+	for _, w := range want {
+		if got := <-started; got != w {
+			t.Errorf("drained node: want %v, got %v", w, got)
+		}
+	}
+}
+
+func TestDrainSchedulerUrgentDrains(t *testing.T) {
+	s := NewDrainScheduler(WithDrainBuffer(1*time.Hour), WithConcurrencyLimits(ConcurrencyLimit{Max: 1}), WithUrgentDrains(NodeDeletingFilter))
+
+	// Block the scheduler with a drain that does not finish until the urgent
+	// drain has started.
+	release := make(chan struct{})
+	defer close(release)
+	s.lastStarted = time.Now().Add(-1 * time.Hour)
+	if _, err := s.Schedule(&core.Node{ObjectMeta: meta.ObjectMeta{Name: "blocker"}}, func() { <-release }); err != nil {
+		t.Fatalf("s.Schedule(blocker): %v", err)
+	}
+
+	started := make(chan string, 2)
+	nodes := []*core.Node{
+		&core.Node{ObjectMeta: meta.ObjectMeta{Name: "routine"}},
+		&core.Node{ObjectMeta: meta.ObjectMeta{Name: "deleting", DeletionTimestamp: &meta.Time{Time: time.Now()}}},
+	}
+	for _, n := range nodes {
+		n := n
+		if _, err := s.Schedule(n, func() { started <- n.GetName() }); err != nil {
+			t.Fatalf("s.Schedule(%v): %v", n.GetName(), err)
+		}
+	}
+
+	select {
+	case got := <-started:
+		if got != "deleting" {
+			t.Errorf("drained node: want deleting, got %v", got)
+		}
+	case <-time.After(1 * time.Second):
+		t.Errorf("urgent drain did not start")
+	}
+}
+
+func TestDrainSchedulerShutdown(t *testing.T) {
+	cases := []struct {
+		name         string
+		drain        time.Duration
+		grace        time.Duration
+		wantFinished bool
+	}{
+		{
+			name:         "RunningDrainFinishes",
+			drain:        50 * time.Millisecond,
+			grace:        1 * time.Second,
+			wantFinished: true,
+		},
+		{
+			name:  "RunningDrainAbandoned",
+			drain: 1 * time.Second,
+			grace: 50 * time.Millisecond,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewDrainScheduler(WithDrainBuffer(1*time.Hour), WithShutdownGracePeriod(tc.grace))
+			s.lastStarted = time.Now().Add(-1 * time.Hour)
+
+			running := make(chan struct{})
+			finished := make(chan struct{})
+			if _, err := s.Schedule(&core.Node{ObjectMeta: meta.ObjectMeta{Name: "running"}}, func() {
+				close(running)
+				time.Sleep(tc.drain)
+				close(finished)
+			}); err != nil {
+				t.Fatalf("s.Schedule(running): %v", err)
+			}
+			queued := make(chan struct{})
+			if _, err := s.Schedule(&core.Node{ObjectMeta: meta.ObjectMeta{Name: "queued"}}, func() { close(queued) }); err != nil {
+				t.Fatalf("s.Schedule(queued): %v", err)
+			}
+			<-running
+
+			stop := make(chan struct{})
+			close(stop)
+			s.Run(stop)
+
+			select {
+			case <-finished:
+				if !tc.wantFinished {
+					t.Errorf("running drain finished: want abandoned")
+				}
+			default:
+				if tc.wantFinished {
+					t.Errorf("running drain abandoned: want finished")
+				}
+			}
+			select {
+			case <-queued:
+				t.Errorf("queued drain started after shutdown")
+			default:
+			}
+			if _, err := s.Schedule(&core.Node{ObjectMeta: meta.ObjectMeta{Name: "late"}}, func() {}); err == nil {
+				t.Errorf("s.Schedule(late): want error scheduling after shutdown")
+			}
+		})
+	}
+}
+
+type fakeLock struct {
+	mu     sync.Mutex
+	holder string
+}
+
+func (l *fakeLock) Acquire(n *core.Node) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holder != "" && l.holder != n.GetName() {
+		return fmt.Errorf("lock is held by %s", l.holder)
+	}
+	l.holder = n.GetName()
+	return nil
+}
+
+func (l *fakeLock) Release(n *core.Node) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holder == n.GetName() {
+		l.holder = ""
+	}
+	return nil
+}
+
+func TestDrainSchedulerLock(t *testing.T) {
+	l := &fakeLock{holder: "kured"}
+	s := NewDrainScheduler(WithDrainBuffer(0*time.Second), WithDrainLock(l))
+	s.recheck = 10 * time.Millisecond
+
+	started := make(chan string, 2)
+	release := make(chan struct{})
+	for _, name := range []string{"a", "b"} {
+		n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: name}}
+		if _, err := s.Schedule(n, func() { started <- n.GetName(); <-release }); err != nil {
+			t.Fatalf("s.Schedule(%v): %v", n.GetName(), err)
+		}
+	}
+
+	select {
+	case got := <-started:
+		t.Fatalf("node %v was drained while another holder held the lock", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	l.Release(&core.Node{ObjectMeta: meta.ObjectMeta{Name: "kured"}})
+	if got := <-started; got != "a" {
+		t.Errorf("first drained node: want a, got %v", got)
+	}
+	select {
+	case got := <-started:
+		t.Fatalf("node %v was drained while node a held the lock", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case got := <-started:
+		if got != "b" {
+			t.Errorf("second drained node: want b, got %v", got)
+		}
+	case <-time.After(1 * time.Second):
+		t.Errorf("node b was not drained after node a released the lock")
+	}
+}
+
+// A blockingLock is a fakeLock whose acquisition blocks until it proceeds.
+type blockingLock struct {
+	fakeLock
+	acquiring chan struct{}
+	proceed   chan struct{}
+}
+
+func (l *blockingLock) Acquire(n *core.Node) error {
+	l.acquiring <- struct{}{}
+	<-l.proceed
+	return l.fakeLock.Acquire(n)
+}
+
+func TestDrainSchedulerLockDoesNotBlock(t *testing.T) {
+	l := &blockingLock{acquiring: make(chan struct{}, 1), proceed: make(chan struct{})}
+	s := NewDrainScheduler(WithDrainBuffer(0*time.Second), WithDrainLock(l))
+
+	started := make(chan struct{})
+	if _, err := s.Schedule(&core.Node{ObjectMeta: meta.ObjectMeta{Name: "a"}}, func() { close(started) }); err != nil {
+		t.Fatalf("s.Schedule(a): %v", err)
+	}
+	<-l.acquiring
+
+	scheduled := make(chan []ScheduledDrain)
+	go func() { scheduled <- s.Scheduled() }()
+	select {
+	case got := <-scheduled:
+		if len(got) != 1 || got[0].Node != "a" {
+			t.Errorf("s.Scheduled(): want drain of a, got %v", got)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatalf("s.Scheduled() blocked while the drain lock was being acquired")
+	}
+
+	close(l.proceed)
+	select {
+	case <-started:
+	case <-time.After(1 * time.Second):
+		t.Errorf("node a was not drained after acquiring the lock")
+	}
+}
+
+func TestDrainSchedulerStopReleasesLock(t *testing.T) {
+	l := &blockingLock{acquiring: make(chan struct{}, 1), proceed: make(chan struct{})}
+	s := NewDrainScheduler(WithDrainBuffer(0*time.Second), WithDrainLock(l))
+
+	if _, err := s.Schedule(&core.Node{ObjectMeta: meta.ObjectMeta{Name: "a"}}, func() { t.Errorf("node a was drained after the scheduler stopped") }); err != nil {
+		t.Fatalf("s.Schedule(a): %v", err)
+	}
+	<-l.acquiring
+	stop := make(chan struct{})
+	close(stop)
+	s.Run(stop)
+	close(l.proceed)
+
+	holder := ""
+	err := wait.PollImmediate(10*time.Millisecond, 1*time.Second, func() (bool, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		holder = l.holder
+		return holder == "", nil
+	})
+	if err != nil {
+		t.Errorf("lock still held by %v after the scheduler stopped", holder)
+	}
+}
+
+func newReadyPoolNode(name, pool string, ready core.ConditionStatus) *core.Node {
+	n := newPoolNode(name, pool)
+	n.Status.Conditions = []core.NodeCondition{{Type: core.NodeReady, Status: ready}}
+	return n
+}
+
+func TestDrainSchedulerMaxUnavailable(t *testing.T) {
+	cases := []struct {
+		name        string
+		nodes       staticNodeStore
+		schedule    []string
+		wantDrained map[string]bool
+		wantMax     int
+	}{
+		{
+			name: "AllReady",
+			nodes: staticNodeStore{
+				newReadyPoolNode("a", "cool", core.ConditionTrue),
+				newReadyPoolNode("b", "cool", core.ConditionTrue),
+				newReadyPoolNode("c", "cool", core.ConditionTrue),
+			},
+			schedule:    []string{"a", "b"},
+			wantDrained: map[string]bool{"a": true, "b": true},
+			wantMax:     1,
+		},
+		{
+			name: "OneNotReady",
+			nodes: staticNodeStore{
+				newReadyPoolNode("a", "cool", core.ConditionTrue),
+				newReadyPoolNode("b", "cool", core.ConditionFalse),
+				newReadyPoolNode("c", "lame", core.ConditionTrue),
+			},
+			schedule:    []string{"a", "b", "c"},
+			wantDrained: map[string]bool{"b": true, "c": true},
+			wantMax:     1,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewDrainScheduler(WithDrainBuffer(0*time.Second), WithMaxUnavailable(tc.nodes, ConcurrencyLimit{LabelKey: labelNodePool, Max: 1}))
+			s.recheck = 10 * time.Millisecond
+			tr := &concurrencyTracker{current: make(map[string]int), max: make(map[string]int)}
+
+			drained := make(chan string, len(tc.schedule))
+			wg := &sync.WaitGroup{}
+			for _, name := range tc.schedule {
+				n, _ := tc.nodes.Get(name) // nolint:gosec
+				drain := tr.drain(n.GetLabels()[labelNodePool], wg)
+				wg.Add(1)
+				if _, err := s.Schedule(n, func() { drained <- n.GetName(); drain() }); err != nil {
+					t.Fatalf("s.Schedule(%v): %v", n.GetName(), err)
+				}
+			}
+
+			// Wait for the drains we expect, then a little longer to
+			// catch any we don't.
+			got := make(map[string]bool)
+			timeout := time.After(1 * time.Second)
+			for len(got) < len(tc.wantDrained) {
+				select {
+				case name := <-drained:
+					got[name] = true
+				case <-timeout:
+					t.Fatalf("drained nodes: want %v, got %v", tc.wantDrained, got)
+				}
+			}
+			select {
+			case name := <-drained:
+				t.Errorf("node %v was drained while too many nodes were unavailable", name)
+			case <-time.After(50 * time.Millisecond):
+			}
+			if diff := deep.Equal(tc.wantDrained, got); diff != nil {
+				t.Errorf("drained nodes: want != got: %v", diff)
+			}
+
+			tr.mu.Lock()
+			defer tr.mu.Unlock()
+			for k, max := range tr.max {
+				if max > tc.wantMax {
+					t.Errorf("maximum concurrent drains of %v: want %v, got %v", k, tc.wantMax, max)
+				}
+			}
+		})
+	}
+}
+
+func TestDrainSchedulerScheduled(t *testing.T) {
+	// No drain starts until the buffer has elapsed since the scheduler was
+	// created.
+	priorities := map[string]DrainPriority{"Critical": PriorityCritical}
+	s := NewDrainScheduler(WithDrainBuffer(time.Hour), WithNodePriority(NewConditionPriorityFunc(priorities)))
+
+	nodes := []*core.Node{
+		&core.Node{ObjectMeta: meta.ObjectMeta{Name: "normal"}},
+		&core.Node{ObjectMeta: meta.ObjectMeta{Name: "critical"}, Status: core.NodeStatus{Conditions: []core.NodeCondition{
+			{Type: "Critical", Status: core.ConditionTrue},
+		}}},
+	}
+	for _, n := range nodes {
+		if _, err := s.Schedule(n, func() {}); err != nil {
+			t.Fatalf("s.Schedule(%v): %v", n.GetName(), err)
+		}
+	}
+
+	got := s.Scheduled()
+	want := []struct {
+		node     string
+		priority string
+	}{
+		{node: "critical", priority: PriorityCritical.String()},
+		{node: "normal", priority: PriorityNormal.String()},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("s.Scheduled(): want %d drains, got %v", len(want), got)
+	}
+	for i, w := range want {
+		if got[i].Node != w.node || got[i].Priority != w.priority {
+			t.Errorf("s.Scheduled()[%d]: want %s (%s), got %s (%s)", i, w.node, w.priority, got[i].Node, got[i].Priority)
+		}
+	}
+	if d := got[1].EarliestStart.Sub(got[0].EarliestStart); d != time.Hour {
+		t.Errorf("time between scheduled drains: want %s, got %s", time.Hour, d)
+	}
+}
+
+func TestDrainSchedulerScheduledGroups(t *testing.T) {
+	gate := func(n *core.Node, _ time.Time) (bool, string) {
+		if n.GetName() == "gated" {
+			return false, "closed"
+		}
+		return true, ""
+	}
+	s := NewDrainScheduler(WithDrainBuffer(time.Hour), WithDrainBufferPerLabel(labelNodePool), WithDrainGates(gate))
+
+	fast := newPoolNode("fast", "c")
+	fast.SetAnnotations(map[string]string{AnnotationDrainBuffer: "1m"})
+	nodes := []*core.Node{newPoolNode("a1", "a"), newPoolNode("b1", "b"), newPoolNode("a2", "a"), fast, newPoolNode("gated", "d")}
+	for _, n := range nodes {
+		if _, err := s.Schedule(n, func() {}); err != nil {
+			t.Fatalf("s.Schedule(%v): %v", n.GetName(), err)
+		}
+	}
+
+	got := make(map[string]ScheduledDrain)
+	for _, sd := range s.Scheduled() {
+		got[sd.Node] = sd
+	}
+	if len(got) != len(nodes) {
+		t.Fatalf("s.Scheduled(): want %d drains, got %v", len(nodes), got)
+	}
+	if !got["a1"].EarliestStart.Equal(got["b1"].EarliestStart) {
+		t.Errorf("drains in different buffer groups: want equal start times, got %s and %s", got["a1"].EarliestStart, got["b1"].EarliestStart)
+	}
+	if d := got["a2"].EarliestStart.Sub(got["a1"].EarliestStart); d != time.Hour {
+		t.Errorf("time between drains in the same buffer group: want %s, got %s", time.Hour, d)
+	}
+	if d := got["a1"].EarliestStart.Sub(got["fast"].EarliestStart); d != 59*time.Minute {
+		t.Errorf("time between overridden and default buffers: want %s, got %s", 59*time.Minute, d)
+	}
+	if got["gated"].Blocked != "closed" {
+		t.Errorf("gated drain: want blocked by %q, got %q", "closed", got["gated"].Blocked)
+	}
+}
+
+func TestDrainSchedulerScheduledConcurrency(t *testing.T) {
+	s := NewDrainScheduler(WithDrainBuffer(0), WithConcurrencyLimits(ConcurrencyLimit{Max: 1}))
+
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	if _, err := s.Schedule(newPoolNode("running", "a"), func() { close(started); <-release }); err != nil {
+		t.Fatalf("s.Schedule(%v): %v", "running", err)
+	}
+	<-started
+	if _, err := s.Schedule(newPoolNode("waiting", "a"), func() {}); err != nil {
+		t.Fatalf("s.Schedule(%v): %v", "waiting", err)
+	}
+
+	got := s.Scheduled()
+	if len(got) != 1 {
+		t.Fatalf("s.Scheduled(): want 1 drain, got %v", got)
+	}
+	if got[0].Node != "waiting" || got[0].Blocked == "" {
+		t.Errorf("s.Scheduled()[0]: want waiting drain blocked by the concurrency limit, got %+v", got[0])
+	}
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Node scorers.
+const (
+	ScorerSeverity             = "severity"
+	ScorerEmptiestFirst        = "emptiest-first"
+	ScorerOldestConditionFirst = "oldest-condition-first"
+)
+
+// A PodCensus describes the pods running on a node.
+type PodCensus struct {
+	// Pods is the number of pods running on the node.
+	Pods int
+
+	// Evictable is the number of those pods that would be evicted if the
+	// node were drained.
+	Evictable int
+}
+
+// A Scorer scores nodes for draining. Of drains with the same priority, those
+// of nodes with higher scores start first. Scores should be between 0 and 1
+// so that several scorers may be weighed against each other.
+type Scorer interface {
+	// Score the supplied node, given the conditions that made it eligible
+	// for draining and a census of its pods.
+	Score(n *core.Node, conditions []core.NodeCondition, census PodCensus) float64
+}
+
+// A ScorerFunc is a function that satisfies Scorer.
+type ScorerFunc func(n *core.Node, conditions []core.NodeCondition, census PodCensus) float64
+
+// Score the supplied node.
+func (fn ScorerFunc) Score(n *core.Node, conditions []core.NodeCondition, census PodCensus) float64 {
+	return fn(n, conditions, census)
+}
+
+// A WeightedScorer is a Scorer whose scores are multiplied by a weight.
+type WeightedScorer struct {
+	Scorer Scorer
+	Weight float64
+}
+
+// NewScorer returns the named default scorer. The severity scorer uses the
+// supplied condition priorities.
+func NewScorer(name string, priorities map[string]DrainPriority) (Scorer, error) {
+	switch name {
+	case ScorerSeverity:
+		return NewSeverityScorer(priorities), nil
+	case ScorerEmptiestFirst:
+		return ScorerFunc(EmptiestFirstScorer), nil
+	case ScorerOldestConditionFirst:
+		return ScorerFunc(OldestConditionFirstScorer), nil
+	}
+	return nil, errors.Errorf("unknown node scorer %q", name)
+}
+
+// NewSeverityScorer returns a Scorer that scores nodes by the highest of the
+// supplied priorities of the conditions that made them eligible for draining,
+// from 0 for low priority to 1 for critical priority. Conditions that are not
+// assigned a priority are of normal priority.
+func NewSeverityScorer(priorities map[string]DrainPriority) Scorer {
+	return ScorerFunc(func(_ *core.Node, conditions []core.NodeCondition, _ PodCensus) float64 {
+		severity, found := PriorityLow, false
+		for _, c := range conditions {
+			p, ok := priorities[string(c.Type)]
+			if !ok {
+				p = PriorityNormal
+			}
+			if !found || p > severity {
+				severity, found = p, true
+			}
+		}
+		if !found {
+			severity = PriorityNormal
+		}
+		return float64(severity) / float64(PriorityCritical)
+	})
+}
+
+// EmptiestFirstScorer scores nodes that would require evicting fewer pods
+// more highly, from 1 for nodes with no pods to evict towards 0.
+func EmptiestFirstScorer(_ *core.Node, _ []core.NodeCondition, census PodCensus) float64 {
+	return 1 / float64(1+census.Evictable)
+}
+
+// OldestConditionFirstScorer scores nodes whose oldest condition that made
+// them eligible for draining transitioned longer ago more highly, from 0 for
+// nodes that just became eligible towards 1. A condition that transitioned an
+// hour ago scores 0.5.
+func OldestConditionFirstScorer(_ *core.Node, conditions []core.NodeCondition, _ PodCensus) float64 {
+	var oldest time.Duration
+	for _, c := range conditions {
+		if age := now().Sub(c.LastTransitionTime.Time); age > oldest {
+			oldest = age
+		}
+	}
+	return float64(oldest) / float64(oldest+time.Hour)
+}
+
+// A NodeScoreFunc returns the score with which the supplied node should be
+// drained.
+type NodeScoreFunc func(n *core.Node) float64
+
+// NewNodeScoreFunc returns a NodeScoreFunc that scores nodes by the weighted
+// sum of the supplied scorers' scores. Scorers are supplied the node
+// conditions that the supplied reasons function returns, typically the
+// conditions that made the node eligible for draining, and a census of the
+// node's pods, of which those that pass the supplied filter would be evicted.
+// Nodes whose pods cannot be listed or filtered score 0.
+func NewNodeScoreFunc(c kubernetes.Interface, filter PodFilterFunc, reasons func(n *core.Node) []string, scorers ...WeightedScorer) NodeScoreFunc {
+	return func(n *core.Node) float64 {
+		census, err := podCensus(c, filter, n)
+		if err != nil {
+			return 0
+		}
+		conditions := []core.NodeCondition{}
+		for _, r := range reasons(n) {
+			for _, nc := range n.Status.Conditions {
+				if string(nc.Type) == r {
+					conditions = append(conditions, nc)
+				}
+			}
+		}
+		score := 0.0
+		for _, s := range scorers {
+			score += s.Weight * s.Scorer.Score(n, conditions, census)
+		}
+		return score
+	}
+}
+
+func podCensus(c kubernetes.Interface, filter PodFilterFunc, n *core.Node) (PodCensus, error) {
+	census := PodCensus{}
+	l, err := listPods(c.CoreV1().Pods(meta.NamespaceAll).List, meta.ListOptions{
+		FieldSelector: fields.SelectorFromSet(fields.Set{"spec.nodeName": n.GetName()}).String(),
+	})
+	if err != nil {
+		return census, errors.Wrapf(err, "cannot list pods of node %s", n.GetName())
+	}
+	for _, p := range l.Items {
+		if p.Status.Phase == core.PodSucceeded || p.Status.Phase == core.PodFailed {
+			continue
+		}
+		census.Pods++
+		passes, err := filter(p)
+		if err != nil {
+			return census, errors.Wrapf(err, "cannot filter pod %s/%s", p.GetNamespace(), p.GetName())
+		}
+		if passes {
+			census.Evictable++
+		}
+	}
+	return census, nil
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestScorers(t *testing.T) {
+	priorities := map[string]DrainPriority{"KernelDeadlock": PriorityCritical, "Meh": PriorityLow}
+	hourAgo := meta.NewTime(time.Now().Add(-time.Hour))
+
+	cases := []struct {
+		name       string
+		scorer     string
+		conditions []core.NodeCondition
+		census     PodCensus
+		want       float64
+	}{
+		{
+			name:       "SeverityCritical",
+			scorer:     ScorerSeverity,
+			conditions: []core.NodeCondition{{Type: "Meh"}, {Type: "KernelDeadlock"}},
+			want:       1,
+		},
+		{
+			name:       "SeverityLow",
+			scorer:     ScorerSeverity,
+			conditions: []core.NodeCondition{{Type: "Meh"}},
+			want:       0,
+		},
+		{
+			name:   "SeverityNoConditions",
+			scorer: ScorerSeverity,
+			want:   float64(PriorityNormal) / float64(PriorityCritical),
+		},
+		{
+			name:   "EmptiestFirstEmpty",
+			scorer: ScorerEmptiestFirst,
+			census: PodCensus{Pods: 2},
+			want:   1,
+		},
+		{
+			name:   "EmptiestFirstThreePods",
+			scorer: ScorerEmptiestFirst,
+			census: PodCensus{Pods: 5, Evictable: 3},
+			want:   0.25,
+		},
+		{
+			name:       "OldestConditionFirstHourAgo",
+			scorer:     ScorerOldestConditionFirst,
+			conditions: []core.NodeCondition{{Type: "Meh", LastTransitionTime: meta.NewTime(time.Now())}, {Type: "KernelDeadlock", LastTransitionTime: hourAgo}},
+			want:       0.5,
+		},
+		{
+			name:   "OldestConditionFirstNoConditions",
+			scorer: ScorerOldestConditionFirst,
+			want:   0,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := NewScorer(tc.scorer, priorities)
+			if err != nil {
+				t.Fatalf("NewScorer(%q): %v", tc.scorer, err)
+			}
+			got := s.Score(&core.Node{}, tc.conditions, tc.census)
+			if math.Abs(got-tc.want) > 0.01 {
+				t.Errorf("s.Score(...): want %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestNewScorerUnknown(t *testing.T) {
+	if _, err := NewScorer("best-first", nil); err == nil {
+		t.Errorf("NewScorer(%q): want error, got nil", "best-first")
+	}
+}
+
+func TestNodeScoreFunc(t *testing.T) {
+	n := &core.Node{
+		ObjectMeta: meta.ObjectMeta{Name: nodeName},
+		Status: core.NodeStatus{Conditions: []core.NodeCondition{
+			{Type: "KernelDeadlock", Status: core.ConditionTrue},
+			{Type: "Ignored", Status: core.ConditionTrue},
+		}},
+	}
+	pods := []runtime.Object{
+		&core.Pod{ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: "a"}, Spec: core.PodSpec{NodeName: nodeName}},
+		&core.Pod{ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: "b"}, Spec: core.PodSpec{NodeName: nodeName}},
+		&core.Pod{ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: "done"}, Spec: core.PodSpec{NodeName: nodeName}, Status: core.PodStatus{Phase: core.PodSucceeded}},
+	}
+	c := fake.NewSimpleClientset(pods...)
+	reasons := func(_ *core.Node) []string { return []string{"KernelDeadlock"} }
+
+	var gotConditions []core.NodeCondition
+	var gotCensus PodCensus
+	spy := ScorerFunc(func(_ *core.Node, conditions []core.NodeCondition, census PodCensus) float64 {
+		gotConditions, gotCensus = conditions, census
+		return 0.5
+	})
+	unprotected := func(p core.Pod) (bool, error) { return p.GetName() != "a", nil }
+
+	score := NewNodeScoreFunc(c, unprotected, reasons, WeightedScorer{Scorer: spy, Weight: 2}, WeightedScorer{Scorer: spy, Weight: 1})(n)
+	if score != 1.5 {
+		t.Errorf("score: want 1.5, got %v", score)
+	}
+	if len(gotConditions) != 1 || gotConditions[0].Type != "KernelDeadlock" {
+		t.Errorf("conditions: want [KernelDeadlock], got %v", gotConditions)
+	}
+	if want := (PodCensus{Pods: 2, Evictable: 1}); gotCensus != want {
+		t.Errorf("census: want %+v, got %+v", want, gotCensus)
+	}
+}
@@ -0,0 +1,231 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"go.uber.org/zap"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+const eventReasonCorrelatedFailure = "CorrelatedFailure"
+
+// DefaultStormWindow is the default window within which nodes must develop
+// the same condition to be considered a correlated failure.
+const DefaultStormWindow = 10 * time.Minute
+
+// StormStatus is the state of a StormDetector.
+type StormStatus struct {
+	Detected       bool      `json:"detected"`
+	Condition      string    `json:"condition,omitempty"`
+	AffectedNodes  int       `json:"affectedNodes,omitempty"`
+	MatchingNodes  int       `json:"matchingNodes,omitempty"`
+	DetectedAt     time.Time `json:"detectedAt"`
+	AcknowledgedAt time.Time `json:"acknowledgedAt"`
+}
+
+// A StormDetector detects correlated failures, in which more than a threshold
+// percentage of matching nodes develop the same condition within a window.
+// Such failures are more likely to be systemic, for example a bad
+// configuration rollout, than problems with individual nodes, so the detector
+// pauses per-node remediation until an operator acknowledges the failure.
+type StormDetector struct {
+	l         *zap.Logger
+	e         record.EventRecorder
+	nodes     NodeStore
+	matching  func(o interface{}) bool
+	reasons   func(n *core.Node) []string
+	threshold float64
+	window    time.Duration
+	cluster   string
+
+	mu     sync.Mutex
+	status StormStatus
+}
+
+// StormDetectorOption configures a StormDetector.
+type StormDetectorOption func(d *StormDetector)
+
+// WithStormLogger configures a StormDetector to use the supplied logger.
+func WithStormLogger(l *zap.Logger) StormDetectorOption {
+	return func(d *StormDetector) {
+		d.l = l
+	}
+}
+
+// WithStormWindow configures the window within which nodes must develop the
+// same condition to be considered a correlated failure.
+func WithStormWindow(w time.Duration) StormDetectorOption {
+	return func(d *StormDetector) {
+		d.window = w
+	}
+}
+
+// WithStormClusterName configures the cluster name with which the detector's
+// metrics are tagged.
+func WithStormClusterName(name string) StormDetectorOption {
+	return func(d *StormDetector) {
+		d.cluster = name
+	}
+}
+
+// NewStormDetector returns a StormDetector that considers the nodes in the
+// supplied store for which the supplied filter returns true. A failure is
+// detected when more than the supplied percentage of them became subject to
+// the same node condition, as returned by the supplied reasons function,
+// within the window. A warning event is recorded on each affected node when
+// a failure is detected.
+func NewStormDetector(e record.EventRecorder, nodes NodeStore, matching func(o interface{}) bool, reasons func(n *core.Node) []string, threshold float64, so ...StormDetectorOption) *StormDetector {
+	d := &StormDetector{
+		l:         zap.NewNop(),
+		e:         e,
+		nodes:     nodes,
+		matching:  matching,
+		reasons:   reasons,
+		threshold: threshold,
+		window:    DefaultStormWindow,
+	}
+	for _, o := range so {
+		o(d)
+	}
+	return d
+}
+
+// Status returns the detector's current state.
+func (d *StormDetector) Status() StormStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.status
+}
+
+// Acknowledge a detected failure, resuming per-node remediation. Nodes that
+// developed conditions before the acknowledgement do not count towards
+// further failures.
+func (d *StormDetector) Acknowledge() {
+	d.mu.Lock()
+	was := d.status
+	d.status = StormStatus{AcknowledgedAt: time.Now()}
+	d.mu.Unlock()
+
+	if was.Detected {
+		d.l.Info("Correlated failure acknowledged", zap.String("condition", was.Condition))
+	}
+	d.record(false)
+}
+
+// Filter returns false while a correlated failure is detected, and true
+// otherwise. It checks for a correlated failure each time it is called, and
+// so should filter the nodes that would be cordoned.
+func (d *StormDetector) Filter(_ interface{}) bool {
+	d.mu.Lock()
+	if d.status.Detected {
+		d.mu.Unlock()
+		return false
+	}
+	s, affected := d.detect(time.Now())
+	if s.Detected {
+		d.status = s
+	}
+	d.mu.Unlock()
+
+	if !s.Detected {
+		return true
+	}
+	d.l.Error("Correlated failure detected; pausing remediation until it is acknowledged",
+		zap.String("condition", s.Condition),
+		zap.Int("affected_nodes", s.AffectedNodes),
+		zap.Int("matching_nodes", s.MatchingNodes))
+	for _, n := range affected {
+		nr := &core.ObjectReference{Kind: "Node", Name: n.GetName(), UID: types.UID(n.GetName())}
+		d.e.Eventf(nr, core.EventTypeWarning, eventReasonCorrelatedFailure,
+			"Remediation paused: %d of %d matching nodes developed %s within %s", s.AffectedNodes, s.MatchingNodes, s.Condition, d.window)
+	}
+	d.record(true)
+	return false
+}
+
+// Gate is a DrainGateFunc that prevents drains from starting while a
+// correlated failure is detected.
+func (d *StormDetector) Gate(_ *core.Node, _ time.Time) (bool, string) {
+	s := d.Status()
+	if !s.Detected {
+		return true, ""
+	}
+	return false, fmt.Sprintf("correlated failure: %d of %d matching nodes developed %s", s.AffectedNodes, s.MatchingNodes, s.Condition)
+}
+
+// detect returns the state of the matching nodes as of the supplied time, and
+// the nodes affected by any correlated failure. The caller must hold d.mu.
+func (d *StormDetector) detect(t time.Time) (StormStatus, []*core.Node) {
+	since := t.Add(-d.window)
+	if d.status.AcknowledgedAt.After(since) {
+		since = d.status.AcknowledgedAt
+	}
+
+	matching := 0
+	affected := make(map[string][]*core.Node)
+	for _, n := range d.nodes.List() {
+		if !d.matching(n) {
+			continue
+		}
+		matching++
+		for _, r := range d.reasons(n) {
+			for _, c := range n.Status.Conditions {
+				if string(c.Type) == r && c.LastTransitionTime.Time.After(since) {
+					affected[r] = append(affected[r], n)
+				}
+			}
+		}
+	}
+
+	// Conditions are considered in order so that the same one is reported
+	// for the same nodes.
+	conditions := make([]string, 0, len(affected))
+	for c := range affected {
+		conditions = append(conditions, c)
+	}
+	sort.Strings(conditions)
+	s := StormStatus{MatchingNodes: matching, AcknowledgedAt: d.status.AcknowledgedAt}
+	for _, c := range conditions {
+		if float64(len(affected[c]))*100 > d.threshold*float64(matching) {
+			s.Detected, s.Condition, s.AffectedNodes, s.DetectedAt = true, c, len(affected[c]), t
+			return s, affected[c]
+		}
+	}
+	return s, nil
+}
+
+func (d *StormDetector) record(detected bool) {
+	tags := context.Background()
+	if d.cluster != "" {
+		tags, _ = tag.New(tags, tag.Upsert(TagCluster, d.cluster)) // nolint:gosec
+	}
+	v := int64(0)
+	if detected {
+		v = 1
+	}
+	stats.Record(tags, MeasureStormDetected.M(v))
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func newConditionedNodes(n int, condition core.NodeConditionType, since time.Duration) []*core.Node {
+	nodes := make([]*core.Node, 0, n)
+	for i := 0; i < n; i++ {
+		nodes = append(nodes, &core.Node{
+			ObjectMeta: meta.ObjectMeta{Name: fmt.Sprintf("%s-%d", condition, i)},
+			Status: core.NodeStatus{Conditions: []core.NodeCondition{{
+				Type:               condition,
+				Status:             core.ConditionTrue,
+				LastTransitionTime: meta.NewTime(time.Now().Add(-since)),
+			}}},
+		})
+	}
+	return nodes
+}
+
+func TestStormDetector(t *testing.T) {
+	cases := []struct {
+		name         string
+		nodes        []*core.Node
+		wantDetected bool
+	}{
+		{
+			name:  "NoConditions",
+			nodes: newConditionedNodes(10, "Healthy", time.Minute),
+		},
+		{
+			name:  "UnderThreshold",
+			nodes: append(newConditionedNodes(2, "KernelDeadlock", time.Minute), newConditionedNodes(8, "Healthy", time.Minute)...),
+		},
+		{
+			name:         "OverThreshold",
+			nodes:        append(newConditionedNodes(4, "KernelDeadlock", time.Minute), newConditionedNodes(6, "Healthy", time.Minute)...),
+			wantDetected: true,
+		},
+		{
+			name:  "DifferentConditions",
+			nodes: append(append(newConditionedNodes(3, "KernelDeadlock", time.Minute), newConditionedNodes(3, "ReadonlyFilesystem", time.Minute)...), newConditionedNodes(4, "Healthy", time.Minute)...),
+		},
+		{
+			name:  "OutsideWindow",
+			nodes: append(newConditionedNodes(4, "KernelDeadlock", time.Hour), newConditionedNodes(6, "Healthy", time.Minute)...),
+		},
+	}
+
+	e, _ := ParseConditionExpression("KernelDeadlock OR ReadonlyFilesystem")
+	reasons := NewCordonReasonFunc([]ConditionExpression{e}, nil)
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := record.NewFakeRecorder(10)
+			d := NewStormDetector(r, staticNodeStore(tc.nodes), func(_ interface{}) bool { return true }, reasons, 30)
+			if passes := d.Filter(tc.nodes[0]); passes == tc.wantDetected {
+				t.Errorf("d.Filter(...): want %v, got %v", !tc.wantDetected, passes)
+			}
+			if got := d.Status().Detected; got != tc.wantDetected {
+				t.Errorf("d.Status().Detected: want %v, got %v", tc.wantDetected, got)
+			}
+			if open, _ := d.Gate(tc.nodes[0], time.Now()); open == tc.wantDetected {
+				t.Errorf("d.Gate(...): want open %v, got %v", !tc.wantDetected, open)
+			}
+			wantEvents := 0
+			if tc.wantDetected {
+				wantEvents = d.Status().AffectedNodes
+			}
+			if len(r.Events) != wantEvents {
+				t.Errorf("events: want %d, got %d", wantEvents, len(r.Events))
+			}
+		})
+	}
+}
+
+func TestStormDetectorAcknowledge(t *testing.T) {
+	nodes := append(newConditionedNodes(4, "KernelDeadlock", time.Minute), newConditionedNodes(6, "Healthy", time.Minute)...)
+	e, _ := ParseConditionExpression("KernelDeadlock")
+	d := NewStormDetector(record.NewFakeRecorder(10), staticNodeStore(nodes), func(_ interface{}) bool { return true }, NewCordonReasonFunc([]ConditionExpression{e}, nil), 30)
+
+	if d.Filter(nodes[0]) {
+		t.Fatalf("d.Filter(...): want false, got true")
+	}
+	d.Acknowledge()
+
+	// Nodes that developed the condition before the acknowledgement no
+	// longer count towards a correlated failure.
+	if !d.Filter(nodes[0]) {
+		t.Errorf("d.Filter(...) after acknowledgement: want true, got false")
+	}
+	if d.Status().Detected {
+		t.Errorf("d.Status().Detected after acknowledgement: want false, got true")
+	}
+}
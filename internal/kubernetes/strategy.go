@@ -0,0 +1,145 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Built in CordonDrainer strategies.
+const (
+	DrainerAPI     = "api"
+	DrainerNoop    = "noop"
+	DrainerWebhook = "webhook"
+)
+
+// A DrainerConfig configures the CordonDrainer a CordonDrainerFactory returns.
+// Strategies use only the fields that apply to them.
+type DrainerConfig struct {
+	// Client of the cluster whose nodes are cordoned and drained.
+	Client kubernetes.Interface
+
+	// APIOptions configure the api strategy.
+	APIOptions []APICordonDrainerOption
+
+	// Webhook is the endpoint template of the webhook strategy.
+	Webhook string
+
+	// WebhookTimeout is how long the webhook strategy waits for each
+	// request.
+	WebhookTimeout time.Duration
+}
+
+// A CordonDrainerFactory returns a CordonDrainer configured by the supplied
+// config.
+type CordonDrainerFactory func(cfg DrainerConfig) (CordonDrainer, error)
+
+var (
+	drainersMu sync.RWMutex
+	drainers   = map[string]CordonDrainerFactory{
+		DrainerAPI: func(cfg DrainerConfig) (CordonDrainer, error) {
+			return NewAPICordonDrainer(cfg.Client, cfg.APIOptions...), nil
+		},
+		DrainerNoop: func(_ DrainerConfig) (CordonDrainer, error) {
+			return &NoopCordonDrainer{}, nil
+		},
+		DrainerWebhook: func(cfg DrainerConfig) (CordonDrainer, error) {
+			return NewWebhookCordonDrainer(cfg.Webhook, cfg.WebhookTimeout)
+		},
+	}
+)
+
+// RegisterCordonDrainer registers a CordonDrainer strategy under the supplied
+// name, replacing any strategy already registered under that name. Strategies
+// should be registered before any flags are parsed, typically by the init
+// function of a file added to the draino command.
+func RegisterCordonDrainer(name string, f CordonDrainerFactory) {
+	drainersMu.Lock()
+	defer drainersMu.Unlock()
+	drainers[name] = f
+}
+
+// CordonDrainers returns the names of the registered CordonDrainer
+// strategies, in alphabetical order.
+func CordonDrainers() []string {
+	drainersMu.RLock()
+	defer drainersMu.RUnlock()
+	names := make([]string, 0, len(drainers))
+	for name := range drainers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewCordonDrainer returns a CordonDrainer of the named strategy, configured
+// by the supplied config.
+func NewCordonDrainer(name string, cfg DrainerConfig) (CordonDrainer, error) {
+	drainersMu.RLock()
+	f, ok := drainers[name]
+	drainersMu.RUnlock()
+	if !ok {
+		return nil, errors.Errorf("unknown drainer %q; must be one of %s", name, strings.Join(CordonDrainers(), ", "))
+	}
+	d, err := f(cfg)
+	return d, errors.Wrapf(err, "cannot create %s drainer", name)
+}
+
+// A WebhookCordonDrainer delegates cordoning and draining nodes to an external
+// system. It POSTs a JSON description of each node to the /cordon and /drain
+// paths of an endpoint template, for example http://remediator:8080, rendered
+// with the node's EndpointTemplateData. A node is cordoned or drained once the
+// corresponding request returns a 2xx status code.
+type WebhookCordonDrainer struct {
+	cordon *HTTPHook
+	drain  *HTTPHook
+}
+
+// NewWebhookCordonDrainer returns a CordonDrainer that POSTs to the supplied
+// endpoint template, waiting up to the supplied timeout for each response.
+func NewWebhookCordonDrainer(endpoint string, timeout time.Duration) (*WebhookCordonDrainer, error) {
+	if endpoint == "" {
+		return nil, errors.New("webhook endpoint is required")
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	cordon, err := NewHTTPHook(endpoint+"/cordon", timeout)
+	if err != nil {
+		return nil, err
+	}
+	drain, err := NewHTTPHook(endpoint+"/drain", timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &WebhookCordonDrainer{cordon: cordon, drain: drain}, nil
+}
+
+// Cordon the supplied node.
+func (d *WebhookCordonDrainer) Cordon(n *core.Node) error {
+	return errors.Wrap(d.cordon.Run(n), "cannot cordon node")
+}
+
+// Drain the supplied node.
+func (d *WebhookCordonDrainer) Drain(n *core.Node) error {
+	return errors.Wrap(d.drain.Run(n), "cannot drain node")
+}
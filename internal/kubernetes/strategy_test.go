@@ -0,0 +1,89 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNewCordonDrainer(t *testing.T) {
+	RegisterCordonDrainer("custom", func(_ DrainerConfig) (CordonDrainer, error) { return &NoopCordonDrainer{}, nil })
+
+	cases := []struct {
+		name    string
+		drainer string
+		cfg     DrainerConfig
+		wantErr bool
+	}{
+		{name: "API", drainer: DrainerAPI, cfg: DrainerConfig{Client: fake.NewSimpleClientset()}},
+		{name: "Noop", drainer: DrainerNoop},
+		{name: "Webhook", drainer: DrainerWebhook, cfg: DrainerConfig{Webhook: "http://example.org", WebhookTimeout: time.Second}},
+		{name: "WebhookWithoutEndpoint", drainer: DrainerWebhook, wantErr: true},
+		{name: "Custom", drainer: "custom"},
+		{name: "Unknown", drainer: "magic", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewCordonDrainer(tc.drainer, tc.cfg)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("NewCordonDrainer(%q): want error %v, got %v", tc.drainer, tc.wantErr, err)
+			}
+		})
+	}
+
+	want := []string{"api", "custom", "noop", "webhook"}
+	if diff := deep.Equal(want, CordonDrainers()); diff != nil {
+		t.Errorf("CordonDrainers(): want != got: %v", diff)
+	}
+}
+
+func TestWebhookCordonDrainer(t *testing.T) {
+	paths := []string{}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if r.URL.Path == "/nodes/"+nodeName+"/drain" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer s.Close()
+
+	d, err := NewWebhookCordonDrainer(s.URL+"/nodes/{{.Name}}/", time.Second)
+	if err != nil {
+		t.Fatalf("NewWebhookCordonDrainer(...): %v", err)
+	}
+	n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}
+	if err := d.Cordon(n); err != nil {
+		t.Errorf("d.Cordon(%v): %v", n.GetName(), err)
+	}
+	if err := d.Drain(n); err == nil {
+		t.Errorf("d.Drain(%v): want error, got nil", n.GetName())
+	}
+
+	want := []string{"/nodes/" + nodeName + "/cordon", "/nodes/" + nodeName + "/drain"}
+	if diff := deep.Equal(want, paths); diff != nil {
+		t.Errorf("paths: want != got: %v", diff)
+	}
+}
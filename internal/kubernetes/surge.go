@@ -0,0 +1,432 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+)
+
+// Default surge settings.
+const (
+	DefaultSurgeTimeout = 5 * time.Minute
+
+	surgePollInterval      = 5 * time.Second
+	surgeReconcileInterval = 30 * time.Second
+
+	kindReplicaSet = "ReplicaSet"
+	kindDeployment = "Deployment"
+)
+
+// A SurgingCordonDrainer scales up the Deployments with pods on each node
+// before draining it, so that those Deployments do not lose available replicas
+// while the node is drained, then scales them back down once the drain has
+// finished, whether or not it succeeded.
+//
+// Each surged Deployment is labelled with LabelSurged and annotated with
+// AnnotationSurge, recording how many replicas were added for each node, so
+// that Deployments surged for several nodes at once are restored correctly.
+// Changes to a Deployment's replicas made by anything else during the drain,
+// for example a horizontal pod autoscaler, are preserved, but a Deployment is
+// never restored to fewer replicas than it had before it was first surged.
+type SurgingCordonDrainer struct {
+	CordonDrainer
+
+	l       *zap.Logger
+	c       kubernetes.Interface
+	keys    Keys
+	filter  PodFilterFunc
+	timeout time.Duration
+	poll    time.Duration
+
+	nodes      NodeStore
+	synced     cache.InformerSynced
+	owned      func(o interface{}) bool
+	reconciled chan struct{}
+}
+
+// SurgingCordonDrainerOption configures a SurgingCordonDrainer.
+type SurgingCordonDrainerOption func(d *SurgingCordonDrainer)
+
+// WithSurgeLogger configures a SurgingCordonDrainer to use the supplied
+// logger.
+func WithSurgeLogger(l *zap.Logger) SurgingCordonDrainerOption {
+	return func(d *SurgingCordonDrainer) {
+		d.l = l
+	}
+}
+
+// WithSurgeKeys configures the annotation and label keys a
+// SurgingCordonDrainer reads and writes.
+func WithSurgeKeys(k Keys) SurgingCordonDrainerOption {
+	return func(d *SurgingCordonDrainer) {
+		d.keys = k
+	}
+}
+
+// WithSurgePodFilter configures a SurgingCordonDrainer to surge only the
+// Deployments of pods that pass the supplied filter, which should be the
+// filter that determines which pods are evicted.
+func WithSurgePodFilter(f PodFilterFunc) SurgingCordonDrainerOption {
+	return func(d *SurgingCordonDrainer) {
+		d.filter = f
+	}
+}
+
+// WithSurgeTimeout configures how long a SurgingCordonDrainer waits for the
+// surged replicas to become available. Nodes are drained once the timeout
+// elapses, whether or not they have. A timeout of zero does not wait.
+func WithSurgeTimeout(t time.Duration) SurgingCordonDrainerOption {
+	return func(d *SurgingCordonDrainer) {
+		d.timeout = t
+	}
+}
+
+// WithSurgeReconciliation configures a SurgingCordonDrainer to restore
+// Deployments left surged, for example because draino restarted mid-drain,
+// when it is run. Surges are restored for nodes that no longer exist and for
+// nodes that pass the supplied filter, which should select the nodes this
+// draino drains. No node is drained until these surges have been restored.
+func WithSurgeReconciliation(nodes NodeStore, synced cache.InformerSynced, owned func(o interface{}) bool) SurgingCordonDrainerOption {
+	return func(d *SurgingCordonDrainer) {
+		d.nodes = nodes
+		d.synced = synced
+		d.owned = owned
+		d.reconciled = make(chan struct{})
+	}
+}
+
+// NewSurgingCordonDrainer returns a CordonDrainer that surges Deployments
+// around each drain performed by the supplied CordonDrainer.
+func NewSurgingCordonDrainer(d CordonDrainer, c kubernetes.Interface, so ...SurgingCordonDrainerOption) *SurgingCordonDrainer {
+	s := &SurgingCordonDrainer{
+		CordonDrainer: d,
+		l:             zap.NewNop(),
+		c:             c,
+		keys:          DefaultKeys(),
+		filter:        NewPodFilters(),
+		timeout:       DefaultSurgeTimeout,
+		poll:          surgePollInterval,
+	}
+	for _, o := range so {
+		o(s)
+	}
+	return s
+}
+
+// Run the drainer until the supplied channel is closed, restoring stale
+// surges if the drainer was configured to reconcile them.
+func (d *SurgingCordonDrainer) Run(stop <-chan struct{}) {
+	if d.reconciled == nil {
+		return
+	}
+	defer close(d.reconciled)
+	if !cache.WaitForCacheSync(stop, d.synced) {
+		return
+	}
+	wait.PollImmediateUntil(surgeReconcileInterval, func() (bool, error) { // nolint:errcheck
+		if err := d.reconcile(); err != nil {
+			d.l.Info("Failed to restore stale surged Deployments", zap.Error(err))
+			return false, nil
+		}
+		return true, nil
+	}, stop)
+}
+
+// Drain the supplied node, surging its Deployments for the duration of the
+// drain.
+func (d *SurgingCordonDrainer) Drain(n *core.Node) error {
+	if d.reconciled != nil {
+		<-d.reconciled
+	}
+	surged, err := d.surge(n)
+	if err != nil {
+		if rerr := d.restore(n); rerr != nil {
+			d.l.Info("Failed to restore surged Deployments", zap.String("node", n.GetName()), zap.Error(rerr))
+		}
+		return errors.Wrapf(err, "cannot surge Deployments with pods on node %s", n.GetName())
+	}
+	d.await(n, surged)
+	err = d.CordonDrainer.Drain(n)
+	if rerr := d.restore(n); rerr != nil && err == nil {
+		return errors.Wrapf(rerr, "cannot restore Deployments surged for node %s", n.GetName())
+	}
+	return err
+}
+
+// deployments returns how many replicas of each Deployment are running on the
+// supplied node.
+func (d *SurgingCordonDrainer) deployments(n *core.Node) (map[types.NamespacedName]int, error) {
+	l, err := listPods(d.c.CoreV1().Pods(meta.NamespaceAll).List, meta.ListOptions{
+		FieldSelector: fields.SelectorFromSet(fields.Set{"spec.nodeName": n.GetName()}).String(),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot get pods for node %s", n.GetName())
+	}
+	replicas := make(map[types.NamespacedName]int)
+	for _, p := range l.Items {
+		c := meta.GetControllerOf(&p)
+		if c == nil || c.Kind != kindReplicaSet {
+			continue
+		}
+		passes, err := d.filter(p)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot filter pods")
+		}
+		if !passes {
+			continue
+		}
+		rs, err := d.c.AppsV1().ReplicaSets(p.GetNamespace()).Get(c.Name, meta.GetOptions{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot get ReplicaSet %s/%s", p.GetNamespace(), c.Name)
+		}
+		if o := meta.GetControllerOf(rs); o != nil && o.Kind == kindDeployment {
+			replicas[types.NamespacedName{Namespace: p.GetNamespace(), Name: o.Name}]++
+		}
+	}
+	return replicas, nil
+}
+
+// surge scales up each Deployment with pods on the supplied node by the number
+// of its pods on the node. It returns the surged Deployments.
+func (d *SurgingCordonDrainer) surge(n *core.Node) ([]types.NamespacedName, error) {
+	replicas, err := d.deployments(n)
+	if err != nil {
+		return nil, err
+	}
+	surged := make([]types.NamespacedName, 0, len(replicas))
+	for key := range replicas {
+		surged = append(surged, key)
+	}
+	sort.Slice(surged, func(i, j int) bool { return surged[i].String() < surged[j].String() })
+	for _, key := range surged {
+		add := replicas[key]
+		updated, err := d.update(key, func(dp *apps.Deployment, surges map[string]int) bool {
+			if _, ok := surges[n.GetName()]; ok {
+				return false
+			}
+			r := int32(1)
+			if dp.Spec.Replicas != nil {
+				r = *dp.Spec.Replicas
+			}
+			if len(surges) == 0 {
+				if dp.Annotations == nil {
+					dp.Annotations = make(map[string]string)
+				}
+				dp.Annotations[d.keys.SurgeReplicas] = strconv.Itoa(int(r))
+			}
+			surges[n.GetName()] = add
+			r += int32(add)
+			dp.Spec.Replicas = &r
+			return true
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot surge Deployment %s", key)
+		}
+		if updated {
+			d.l.Info("Surged Deployment", zap.String("node", n.GetName()), zap.String("deployment", key.String()), zap.Int("surge", add))
+		}
+	}
+	return surged, nil
+}
+
+// await waits for the supplied Deployments to have as many available replicas
+// as they desire, or for the surge timeout to elapse.
+func (d *SurgingCordonDrainer) await(n *core.Node, surged []types.NamespacedName) {
+	if d.timeout <= 0 {
+		return
+	}
+	for _, key := range surged {
+		err := wait.PollImmediate(d.poll, d.timeout, func() (bool, error) {
+			dp, err := d.c.AppsV1().Deployments(key.Namespace).Get(key.Name, meta.GetOptions{})
+			if err != nil {
+				return false, errors.Wrapf(err, "cannot get Deployment %s", key)
+			}
+			return dp.Spec.Replicas == nil || dp.Status.AvailableReplicas >= *dp.Spec.Replicas, nil
+		})
+		if err != nil {
+			d.l.Info("Draining before surged replicas are available", zap.String("node", n.GetName()), zap.String("deployment", key.String()), zap.Error(err))
+		}
+	}
+}
+
+// restore scales down each Deployment surged for the supplied node by the
+// number of replicas it was surged by.
+func (d *SurgingCordonDrainer) restore(n *core.Node) error {
+	l, err := d.surged()
+	if err != nil {
+		return err
+	}
+	for _, dp := range l {
+		if err := d.restoreDeployment(types.NamespacedName{Namespace: dp.GetNamespace(), Name: dp.GetName()}, n.GetName()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reconcile restores the surges of surged Deployments for nodes that no
+// longer exist, or that this drainer owns and is thus not draining.
+func (d *SurgingCordonDrainer) reconcile() error {
+	l, err := d.surged()
+	if err != nil {
+		return err
+	}
+	for _, dp := range l {
+		surges, err := d.deploymentSurges(&dp)
+		if err != nil {
+			return err
+		}
+		for node := range surges {
+			if n, err := d.nodes.Get(node); err == nil && !d.owned(n) {
+				continue
+			}
+			if err := d.restoreDeployment(types.NamespacedName{Namespace: dp.GetNamespace(), Name: dp.GetName()}, node); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// surged returns all Deployments labelled as surged.
+func (d *SurgingCordonDrainer) surged() ([]apps.Deployment, error) {
+	l, err := d.c.AppsV1().Deployments(meta.NamespaceAll).List(meta.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{d.keys.Surged: "true"}).String(),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot list surged Deployments")
+	}
+	return l.Items, nil
+}
+
+// restoreDeployment scales down the supplied Deployment by the number of
+// replicas it was surged by for the supplied node, but not below the number
+// of replicas it had before it was first surged.
+func (d *SurgingCordonDrainer) restoreDeployment(key types.NamespacedName, node string) error {
+	var surge int
+	updated, err := d.update(key, func(dp *apps.Deployment, surges map[string]int) bool {
+		var ok bool
+		if surge, ok = surges[node]; !ok {
+			return false
+		}
+		delete(surges, node)
+		if dp.Spec.Replicas != nil {
+			r := *dp.Spec.Replicas - int32(surge)
+			if original, err := strconv.Atoi(dp.GetAnnotations()[d.keys.SurgeReplicas]); err == nil && r < int32(original) {
+				r = int32(original)
+			}
+			if r < 0 {
+				r = 0
+			}
+			dp.Spec.Replicas = &r
+		}
+		return true
+	})
+	if err != nil {
+		return errors.Wrapf(err, "cannot restore Deployment %s", key)
+	}
+	if updated {
+		d.l.Info("Restored Deployment", zap.String("node", node), zap.String("deployment", key.String()), zap.Int("surge", surge))
+	}
+	return nil
+}
+
+// update gets the supplied Deployment and applies the supplied function to it
+// and its surges, updating the Deployment if the function returns true. The
+// update is retried if the Deployment changed in the meantime. update returns
+// whether the Deployment was updated.
+func (d *SurgingCordonDrainer) update(key types.NamespacedName, fn func(dp *apps.Deployment, surges map[string]int) bool) (bool, error) {
+	updated := false
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		updated = false
+		dp, err := d.c.AppsV1().Deployments(key.Namespace).Get(key.Name, meta.GetOptions{})
+		if err != nil {
+			return err
+		}
+		surges, err := d.deploymentSurges(dp)
+		if err != nil {
+			return err
+		}
+		if !fn(dp, surges) {
+			return nil
+		}
+		if err := d.setDeploymentSurges(dp, surges); err != nil {
+			return err
+		}
+		if _, err := d.c.AppsV1().Deployments(key.Namespace).Update(dp); err != nil {
+			return err
+		}
+		updated = true
+		return nil
+	})
+	return updated, err
+}
+
+// deploymentSurges returns the number of replicas the supplied Deployment was
+// surged by for each node, per its Surge annotation.
+func (d *SurgingCordonDrainer) deploymentSurges(dp *apps.Deployment) (map[string]int, error) {
+	surges := make(map[string]int)
+	v, ok := dp.GetAnnotations()[d.keys.Surge]
+	if !ok {
+		return surges, nil
+	}
+	if err := json.Unmarshal([]byte(v), &surges); err != nil {
+		return nil, errors.Wrapf(err, "cannot decode surges of Deployment %s/%s", dp.GetNamespace(), dp.GetName())
+	}
+	return surges, nil
+}
+
+// setDeploymentSurges records the supplied surges in the Surge annotation of
+// the supplied Deployment and labels it Surged, or removes both, and the
+// SurgeReplicas annotation, if there are none.
+func (d *SurgingCordonDrainer) setDeploymentSurges(dp *apps.Deployment, surges map[string]int) error {
+	if len(surges) == 0 {
+		delete(dp.Annotations, d.keys.Surge)
+		delete(dp.Annotations, d.keys.SurgeReplicas)
+		delete(dp.Labels, d.keys.Surged)
+		return nil
+	}
+	v, err := json.Marshal(surges)
+	if err != nil {
+		return errors.Wrapf(err, "cannot encode surges of Deployment %s/%s", dp.GetNamespace(), dp.GetName())
+	}
+	if dp.Annotations == nil {
+		dp.Annotations = make(map[string]string)
+	}
+	dp.Annotations[d.keys.Surge] = string(v)
+	if dp.Labels == nil {
+		dp.Labels = make(map[string]string)
+	}
+	dp.Labels[d.keys.Surged] = "true"
+	return nil
+}
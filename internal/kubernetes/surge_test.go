@@ -0,0 +1,213 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/pkg/errors"
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// replicasDrainer records the replicas of a Deployment when it drains a node,
+// then changes them by the supplied delta.
+type replicasDrainer struct {
+	NoopCordonDrainer
+	c     kubernetes.Interface
+	got   int32
+	delta int32
+	err   error
+}
+
+func (d *replicasDrainer) Drain(n *core.Node) error {
+	dp, err := d.c.AppsV1().Deployments(ns).Get(deploymentName, meta.GetOptions{})
+	if err != nil {
+		return err
+	}
+	d.got = *dp.Spec.Replicas
+	if d.delta != 0 {
+		r := d.got + d.delta
+		dp.Spec.Replicas = &r
+		if _, err := d.c.AppsV1().Deployments(ns).Update(dp); err != nil {
+			return err
+		}
+	}
+	return d.err
+}
+
+func TestSurgingCordonDrainer(t *testing.T) {
+	replicas := int32(3)
+	cases := []struct {
+		name            string
+		annotations     map[string]string
+		labels          map[string]string
+		drainErr        error
+		delta           int32
+		wantDuring      int32
+		wantAfter       int32
+		wantAnnotations map[string]string
+		wantLabels      map[string]string
+	}{
+		{
+			name:            "Surged",
+			wantDuring:      5,
+			wantAfter:       3,
+			wantAnnotations: map[string]string{},
+			wantLabels:      map[string]string{},
+		},
+		{
+			name:            "DrainFailed",
+			drainErr:        errors.New("nope"),
+			wantDuring:      5,
+			wantAfter:       3,
+			wantAnnotations: map[string]string{},
+			wantLabels:      map[string]string{},
+		},
+		{
+			name:            "AlreadySurgedForAnotherNode",
+			annotations:     map[string]string{AnnotationSurge: `{"otherNode":1}`},
+			labels:          map[string]string{LabelSurged: "true"},
+			wantDuring:      5,
+			wantAfter:       3,
+			wantAnnotations: map[string]string{AnnotationSurge: `{"otherNode":1}`},
+			wantLabels:      map[string]string{LabelSurged: "true"},
+		},
+		{
+			name:            "ScaledUpDuringDrain",
+			delta:           2,
+			wantDuring:      5,
+			wantAfter:       5,
+			wantAnnotations: map[string]string{},
+			wantLabels:      map[string]string{},
+		},
+		{
+			name:            "ScaledDownDuringDrain",
+			delta:           -3,
+			wantDuring:      5,
+			wantAfter:       3,
+			wantAnnotations: map[string]string{},
+			wantLabels:      map[string]string{},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dp := &apps.Deployment{
+				ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: deploymentName, Annotations: tc.annotations, Labels: tc.labels},
+				Spec:       apps.DeploymentSpec{Replicas: &replicas},
+				Status:     apps.DeploymentStatus{AvailableReplicas: 10},
+			}
+			rs := &apps.ReplicaSet{ObjectMeta: meta.ObjectMeta{
+				Namespace:       ns,
+				Name:            "coolReplicaSet",
+				OwnerReferences: []meta.OwnerReference{{Controller: &isController, Kind: kindDeployment, Name: deploymentName}},
+			}}
+			objs := []runtime.Object{dp, rs}
+			for _, name := range []string{"a", "b"} {
+				objs = append(objs, &core.Pod{
+					ObjectMeta: meta.ObjectMeta{
+						Namespace:       ns,
+						Name:            name,
+						OwnerReferences: []meta.OwnerReference{{Controller: &isController, Kind: kindReplicaSet, Name: rs.GetName()}},
+					},
+					Spec: core.PodSpec{NodeName: nodeName},
+				})
+			}
+			// Pods that are not evicted do not surge their Deployment.
+			objs = append(objs, &core.Pod{
+				ObjectMeta: meta.ObjectMeta{
+					Namespace:       ns,
+					Name:            "protected",
+					Annotations:     map[string]string{"protected": "true"},
+					OwnerReferences: []meta.OwnerReference{{Controller: &isController, Kind: kindReplicaSet, Name: rs.GetName()}},
+				},
+				Spec: core.PodSpec{NodeName: nodeName},
+			})
+			c := fake.NewSimpleClientset(objs...)
+
+			inner := &replicasDrainer{c: c, delta: tc.delta, err: tc.drainErr}
+			d := NewSurgingCordonDrainer(inner, c, WithSurgePodFilter(UnprotectedPodFilter("protected=true")))
+			n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}
+			if err := d.Drain(n); errors.Cause(err) != tc.drainErr {
+				t.Errorf("d.Drain(%v): want error %v, got %v", n.GetName(), tc.drainErr, err)
+			}
+			if inner.got != tc.wantDuring {
+				t.Errorf("replicas during drain: want %v, got %v", tc.wantDuring, inner.got)
+			}
+
+			got, err := c.AppsV1().Deployments(ns).Get(deploymentName, meta.GetOptions{})
+			if err != nil {
+				t.Fatalf("cannot get Deployment: %v", err)
+			}
+			if *got.Spec.Replicas != tc.wantAfter {
+				t.Errorf("replicas after drain: want %v, got %v", tc.wantAfter, *got.Spec.Replicas)
+			}
+			if diff := deep.Equal(got.GetAnnotations(), tc.wantAnnotations); diff != nil {
+				t.Errorf("annotations after drain: %v", diff)
+			}
+			if diff := deep.Equal(got.GetLabels(), tc.wantLabels); diff != nil {
+				t.Errorf("labels after drain: %v", diff)
+			}
+		})
+	}
+}
+
+func TestSurgeReconciliation(t *testing.T) {
+	replicas := int32(6)
+	dp := &apps.Deployment{
+		ObjectMeta: meta.ObjectMeta{
+			Namespace:   ns,
+			Name:        deploymentName,
+			Annotations: map[string]string{AnnotationSurge: `{"gone":1,"ours":2,"theirs":3}`, AnnotationSurgeReplicas: "0"},
+			Labels:      map[string]string{LabelSurged: "true"},
+		},
+		Spec: apps.DeploymentSpec{Replicas: &replicas},
+	}
+	c := fake.NewSimpleClientset(dp)
+	nodes := staticNodeStore{
+		&core.Node{ObjectMeta: meta.ObjectMeta{Name: "ours", Labels: map[string]string{"shard": "ours"}}},
+		&core.Node{ObjectMeta: meta.ObjectMeta{Name: "theirs"}},
+	}
+	owned := NewNodeLabelFilter(map[string]string{"shard": "ours"})
+	d := NewSurgingCordonDrainer(&replicasDrainer{c: c}, c, WithSurgeReconciliation(nodes, func() bool { return true }, owned))
+
+	stop := make(chan struct{})
+	defer close(stop)
+	d.Run(stop)
+
+	got, err := c.AppsV1().Deployments(ns).Get(deploymentName, meta.GetOptions{})
+	if err != nil {
+		t.Fatalf("cannot get Deployment: %v", err)
+	}
+	if *got.Spec.Replicas != 3 {
+		t.Errorf("replicas after reconciliation: want 3, got %v", *got.Spec.Replicas)
+	}
+	if v := got.GetAnnotations()[AnnotationSurge]; v != `{"theirs":3}` {
+		t.Errorf("surges after reconciliation: want {\"theirs\":3}, got %v", v)
+	}
+
+	// Nodes are drained once stale surges have been restored.
+	if err := d.Drain(&core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}); err != nil {
+		t.Errorf("d.Drain(%v): %v", nodeName, err)
+	}
+}
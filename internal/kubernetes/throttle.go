@@ -0,0 +1,142 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opencensus.io/stats"
+	"golang.org/x/time/rate"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultThrottledRetryDelay is how long to wait before retrying a request the
+// API server throttled without suggesting a delay.
+const DefaultThrottledRetryDelay = 5 * time.Second
+
+// apiThrottled returns true if the supplied error indicates that the API
+// server throttled a request, for example due to API Priority and Fairness or
+// its maximum in-flight request limit. Evictions that a pod disruption budget
+// refuses also return 429 Too Many Requests, but are not throttled requests.
+func apiThrottled(err error) bool {
+	if !apierrors.IsTooManyRequests(err) {
+		return false
+	}
+	s, ok := err.(apierrors.APIStatus)
+	if !ok {
+		return false
+	}
+	st := s.Status()
+	if st.Details != nil {
+		for _, c := range st.Details.Causes {
+			// The API server throttles requests with a plain text
+			// response, rather than a Status.
+			if c.Type == meta.CauseTypeUnexpectedServerResponse {
+				return true
+			}
+		}
+	}
+	return strings.Contains(strings.ToLower(st.Message), "too many requests")
+}
+
+// throttledDelay returns how long to wait before retrying a request the API
+// server throttled with the supplied error.
+func throttledDelay(err error) time.Duration {
+	if s, ok := apierrors.SuggestsClientDelay(err); ok && s > 0 {
+		return time.Duration(s) * time.Second
+	}
+	return DefaultThrottledRetryDelay
+}
+
+// recordThrottled records that the API server throttled a request.
+func recordThrottled() {
+	stats.Record(context.Background(), MeasureThrottledRequests.M(1))
+}
+
+// An evictionThrottle limits the rate at which evictions are issued. The rate
+// is halved each time the API server throttles an eviction, and recovers by a
+// tenth of the maximum rate with each eviction that is not throttled.
+type evictionThrottle struct {
+	max rate.Limit
+	min rate.Limit
+
+	mu    sync.Mutex
+	limit rate.Limit
+	l     *rate.Limiter
+}
+
+func newEvictionThrottle(max float64) *evictionThrottle {
+	burst := int(max)
+	if burst < 1 {
+		burst = 1
+	}
+	return &evictionThrottle{
+		max:   rate.Limit(max),
+		min:   rate.Limit(max / 32),
+		limit: rate.Limit(max),
+		l:     rate.NewLimiter(rate.Limit(max), burst),
+	}
+}
+
+// wait blocks until the throttle permits an eviction. It returns false if
+// aborted while waiting.
+func (t *evictionThrottle) wait(abort <-chan struct{}) bool {
+	r := t.l.Reserve()
+	select {
+	case <-abort:
+		r.Cancel()
+		return false
+	case <-time.After(r.Delay()):
+		return true
+	}
+}
+
+// throttled slows evictions because the API server throttled one.
+func (t *evictionThrottle) throttled() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.limit /= 2
+	if t.limit < t.min {
+		t.limit = t.min
+	}
+	t.l.SetLimit(t.limit)
+}
+
+// succeeded speeds evictions up because the API server did not throttle one.
+func (t *evictionThrottle) succeeded() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.limit == t.max {
+		return
+	}
+	t.limit += t.max / 10
+	if t.limit > t.max {
+		t.limit = t.max
+	}
+	t.l.SetLimit(t.limit)
+}
+
+// current returns the current maximum rate of evictions per second.
+func (t *evictionThrottle) current() rate.Limit {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.limit
+}
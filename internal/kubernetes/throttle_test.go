@@ -0,0 +1,140 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestAPIThrottled(t *testing.T) {
+	pods := schema.GroupResource{Resource: "pods"}
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "Throttled",
+			err:  apierrors.NewGenericServerResponse(429, "create", pods, podName, "Too many requests, please try again later.", 1, true),
+			want: true,
+		},
+		{
+			name: "DisruptionBudget",
+			err:  apierrors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 10),
+		},
+		{
+			name: "NotFound",
+			err:  apierrors.NewNotFound(pods, podName),
+		},
+		{
+			name: "NoError",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := apiThrottled(tc.err); got != tc.want {
+				t.Errorf("apiThrottled(%v): want %v, got %v", tc.err, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestEvictionThrottle(t *testing.T) {
+	th := newEvictionThrottle(64)
+	steps := []struct {
+		throttled bool
+		want      rate.Limit
+	}{
+		{throttled: true, want: 32},
+		{throttled: true, want: 16},
+		{throttled: false, want: 22.4},
+		{throttled: true, want: 11.2},
+		{throttled: true, want: 5.6},
+		{throttled: true, want: 2.8},
+		{throttled: true, want: 2},
+		{throttled: true, want: 2},
+	}
+	for i, s := range steps {
+		if s.throttled {
+			th.throttled()
+		} else {
+			th.succeeded()
+		}
+		if got := th.current(); got < s.want-0.001 || got > s.want+0.001 {
+			t.Errorf("step %d: want rate %v, got %v", i, s.want, got)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		th.succeeded()
+	}
+	if got := th.current(); got != 64 {
+		t.Errorf("after recovering: want rate 64, got %v", got)
+	}
+}
+
+func TestDrainAdaptsToThrottling(t *testing.T) {
+	pod := &core.Pod{
+		ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: podName},
+		Spec:       core.PodSpec{NodeName: nodeName},
+	}
+	c := fake.NewSimpleClientset(pod)
+	evictions := 0
+	c.PrependReactor("create", "pods", func(a clienttesting.Action) (bool, runtime.Object, error) {
+		if a.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		// The first eviction is throttled by the API server.
+		evictions++
+		if evictions == 1 {
+			return true, nil, apierrors.NewGenericServerResponse(429, "create", schema.GroupResource{Resource: "pods"}, podName, "Too many requests, please try again later.", 1, true)
+		}
+		return true, nil, nil
+	})
+	c.PrependReactor("get", "pods", func(a clienttesting.Action) (bool, runtime.Object, error) {
+		if evictions < 2 {
+			return false, nil, nil
+		}
+		return true, nil, apierrors.NewNotFound(schema.GroupResource{Resource: "pods"}, podName)
+	})
+
+	d := NewAPICordonDrainer(c, WithAdaptiveEvictionRate(10))
+	start := time.Now()
+	if err := d.Drain(&core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}); err != nil {
+		t.Fatalf("d.Drain(%v): %v", nodeName, err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("drain took %s; want at least the suggested retry delay of 1s", elapsed)
+	}
+	if evictions != 2 {
+		t.Errorf("evictions: want 2, got %d", evictions)
+	}
+	// The rate halves when throttled, then recovers by a tenth of the maximum.
+	if got := d.throttle.current(); got != 6 {
+		t.Errorf("d.throttle.current(): want 6, got %v", got)
+	}
+}
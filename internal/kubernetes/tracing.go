@@ -0,0 +1,104 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"context"
+	"sort"
+
+	"go.opencensus.io/trace"
+	"go.uber.org/zap"
+)
+
+// Names of the spans an APICordonDrainer records. Each eviction span is a
+// child of the drain span of its node.
+const (
+	SpanCordon = "draino/cordon"
+	SpanDrain  = "draino/drain"
+	SpanEvict  = "draino/evict"
+)
+
+// Span attribute keys.
+const (
+	attributeNode      = "node"
+	attributeNamespace = "namespace"
+	attributePod       = "pod"
+)
+
+// endSpan sets the status of the supplied span from the supplied error, and
+// ends it.
+func endSpan(s *trace.Span, err error) {
+	switch {
+	case err == nil:
+	case err == errEvictionAborted:
+		s.SetStatus(trace.Status{Code: trace.StatusCodeAborted, Message: err.Error()})
+	case IsTimeout(err):
+		s.SetStatus(trace.Status{Code: trace.StatusCodeDeadlineExceeded, Message: err.Error()})
+	default:
+		s.SetStatus(trace.Status{Code: trace.StatusCodeUnknown, Message: err.Error()})
+	}
+	s.End()
+}
+
+// traceFields returns log fields identifying the span of the supplied context,
+// if it is sampled, so that log entries may be correlated with traces.
+func traceFields(ctx context.Context) []zap.Field {
+	s := trace.FromContext(ctx)
+	if s == nil || !s.SpanContext().IsSampled() {
+		return nil
+	}
+	sc := s.SpanContext()
+	return []zap.Field{zap.String("trace_id", sc.TraceID.String()), zap.String("span_id", sc.SpanID.String())}
+}
+
+// A SpanLogger is an OpenCensus trace exporter that logs each sampled span.
+type SpanLogger struct {
+	l *zap.Logger
+}
+
+// NewSpanLogger returns a trace exporter that logs spans to the supplied
+// logger.
+func NewSpanLogger(l *zap.Logger) *SpanLogger {
+	return &SpanLogger{l: l}
+}
+
+// ExportSpan logs the supplied span.
+func (e *SpanLogger) ExportSpan(s *trace.SpanData) {
+	f := []zap.Field{
+		zap.String("span", s.Name),
+		zap.String("trace_id", s.TraceID.String()),
+		zap.String("span_id", s.SpanID.String()),
+		zap.Time("start", s.StartTime),
+		zap.Duration("duration", s.EndTime.Sub(s.StartTime)),
+		zap.Int32("status_code", s.Code),
+	}
+	if s.ParentSpanID != (trace.SpanID{}) {
+		f = append(f, zap.String("parent_span_id", s.ParentSpanID.String()))
+	}
+	if s.Message != "" {
+		f = append(f, zap.String("status", s.Message))
+	}
+	keys := make([]string, 0, len(s.Attributes))
+	for k := range s.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		f = append(f, zap.Any(k, s.Attributes[k]))
+	}
+	e.l.Info("Span", f...)
+}
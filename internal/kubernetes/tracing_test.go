@@ -0,0 +1,159 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []*trace.SpanData
+}
+
+func (r *spanRecorder) ExportSpan(s *trace.SpanData) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, s)
+}
+
+func (r *spanRecorder) named(name string) []*trace.SpanData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var named []*trace.SpanData
+	for _, s := range r.spans {
+		if s.Name == name {
+			named = append(named, s)
+		}
+	}
+	return named
+}
+
+func TestDrainSpans(t *testing.T) {
+	cases := []struct {
+		name      string
+		reactions []reactor
+		wantErr   bool
+		wantCode  int32
+	}{
+		{
+			name: "EvictOnePod",
+			reactions: []reactor{
+				reactor{
+					verb:     "list",
+					resource: "pods",
+					ret: &core.PodList{Items: []core.Pod{
+						core.Pod{ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: podName}, Spec: core.PodSpec{NodeName: nodeName}},
+					}},
+				},
+				reactor{
+					verb:        "create",
+					resource:    "pods",
+					subresource: "eviction",
+				},
+				reactor{
+					verb:     "get",
+					resource: "pods",
+					err:      apierrors.NewNotFound(schema.GroupResource{Resource: "pods"}, podName),
+				},
+			},
+		},
+		{
+			name: "ErrorEvictingPod",
+			reactions: []reactor{
+				reactor{
+					verb:     "list",
+					resource: "pods",
+					ret: &core.PodList{Items: []core.Pod{
+						core.Pod{ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: podName}, Spec: core.PodSpec{NodeName: nodeName}},
+					}},
+				},
+				reactor{
+					verb:        "create",
+					resource:    "pods",
+					subresource: "eviction",
+					err:         errors.New("nope"),
+				},
+			},
+			wantErr:  true,
+			wantCode: trace.StatusCodeUnknown,
+		},
+	}
+
+	trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample()})
+	defer trace.ApplyConfig(trace.Config{DefaultSampler: trace.NeverSample()})
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &spanRecorder{}
+			trace.RegisterExporter(r)
+			defer trace.UnregisterExporter(r)
+
+			d := NewAPICordonDrainer(newFakeClientSet(tc.reactions...))
+			if err := d.Drain(&core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}); (err != nil) != tc.wantErr {
+				t.Fatalf("d.Drain(%v): want error %v, got %v", nodeName, tc.wantErr, err)
+			}
+
+			drains, evictions := r.named(SpanDrain), r.named(SpanEvict)
+			if len(drains) != 1 || len(evictions) != 1 {
+				t.Fatalf("spans: want 1 %s and 1 %s, got %d and %d", SpanDrain, SpanEvict, len(drains), len(evictions))
+			}
+			drain, evict := drains[0], evictions[0]
+			if drain.Attributes[attributeNode] != nodeName {
+				t.Errorf("%s %s attribute: want %v, got %v", SpanDrain, attributeNode, nodeName, drain.Attributes[attributeNode])
+			}
+			if evict.Attributes[attributePod] != podName {
+				t.Errorf("%s %s attribute: want %v, got %v", SpanEvict, attributePod, podName, evict.Attributes[attributePod])
+			}
+			if evict.TraceID != drain.TraceID || evict.ParentSpanID != drain.SpanID {
+				t.Errorf("%s: want child of %s", SpanEvict, SpanDrain)
+			}
+			if drain.Code != tc.wantCode || evict.Code != tc.wantCode {
+				t.Errorf("status codes: want %d, got %d and %d", tc.wantCode, drain.Code, evict.Code)
+			}
+		})
+	}
+}
+
+func TestCordonSpan(t *testing.T) {
+	trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample()})
+	defer trace.ApplyConfig(trace.Config{DefaultSampler: trace.NeverSample()})
+	r := &spanRecorder{}
+	trace.RegisterExporter(r)
+	defer trace.UnregisterExporter(r)
+
+	c := newFakeClientSet(reactor{verb: "get", resource: "nodes", err: errors.New("nope")})
+	d := NewAPICordonDrainer(c)
+	if err := d.Cordon(&core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}); err == nil {
+		t.Fatalf("d.Cordon(%v): want error", nodeName)
+	}
+	spans := r.named(SpanCordon)
+	if len(spans) != 1 {
+		t.Fatalf("spans: want 1 %s, got %d", SpanCordon, len(spans))
+	}
+	if spans[0].Code != trace.StatusCodeUnknown {
+		t.Errorf("status code: want %d, got %d", trace.StatusCodeUnknown, spans[0].Code)
+	}
+}
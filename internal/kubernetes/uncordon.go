@@ -0,0 +1,331 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+)
+
+// Default uncordon settings.
+const (
+	DefaultUncordonInterval      = 1 * time.Minute
+	DefaultUncordonHealthyPeriod = 10 * time.Minute
+	DefaultCanaryTimeout         = 2 * time.Minute
+
+	canaryPollInterval = 5 * time.Second
+
+	eventReasonUncordonSucceeded  = "UncordonSucceeded"
+	eventReasonUncordonFailed     = "UncordonFailed"
+	eventReasonRebalanceRequested = "RebalanceRequested"
+	eventReasonRebalanceFailed    = "RebalanceFailed"
+)
+
+// An Uncordoner periodically uncordons nodes that draino cordoned once they
+// have recovered, i.e. once they have been ready and have not matched any of
+// the conditions that would cause draino to cordon them for a sustained
+// period. Nodes may optionally be required to run a canary pod to completion
+// before they are uncordoned, and rebalancing of workloads onto nodes may
+// optionally be requested once they are uncordoned.
+type Uncordoner struct {
+	l         *zap.Logger
+	c         kubernetes.Interface
+	e         record.EventRecorder
+	nodes     NodeStore
+	unhealthy func(o interface{}) bool
+	keys      Keys
+	interval  time.Duration
+	period    time.Duration
+
+	requireReboot bool
+	clear         []string
+
+	canaryImage     string
+	canaryNamespace string
+	canaryTimeout   time.Duration
+	canaryPoll      time.Duration
+
+	rebalance    bool
+	rebalanceFns []func(n *core.Node) error
+
+	since map[string]time.Time
+}
+
+// UncordonerOption configures an Uncordoner.
+type UncordonerOption func(u *Uncordoner)
+
+// WithUncordonerLogger configures an Uncordoner to use the supplied logger.
+func WithUncordonerLogger(l *zap.Logger) UncordonerOption {
+	return func(u *Uncordoner) {
+		u.l = l
+	}
+}
+
+// WithUncordonInterval configures the time between checks for recovered nodes.
+func WithUncordonInterval(i time.Duration) UncordonerOption {
+	return func(u *Uncordoner) {
+		u.interval = i
+	}
+}
+
+// WithHealthyPeriod configures how long a node must be healthy before it is
+// uncordoned.
+func WithHealthyPeriod(p time.Duration) UncordonerOption {
+	return func(u *Uncordoner) {
+		u.period = p
+	}
+}
+
+// WithRebootRequired prevents an Uncordoner from uncordoning nodes unless they
+// have been rebooted or reimaged since they were cordoned, i.e. unless their
+// boot or machine ID has changed.
+func WithRebootRequired() UncordonerOption {
+	return func(u *Uncordoner) {
+		u.requireReboot = true
+	}
+}
+
+// WithClearedAnnotations configures an Uncordoner to remove the supplied
+// annotations, for example those added by post-drain actions, from nodes when
+// it uncordons them.
+func WithClearedAnnotations(keys ...string) UncordonerOption {
+	return func(u *Uncordoner) {
+		u.clear = append(u.clear, keys...)
+	}
+}
+
+// WithCanaryPod requires healthy nodes to run a pod using the supplied image to
+// completion, in the supplied namespace and within the supplied timeout,
+// before they are uncordoned.
+func WithCanaryPod(image, namespace string, timeout time.Duration) UncordonerOption {
+	return func(u *Uncordoner) {
+		u.canaryImage = image
+		u.canaryNamespace = namespace
+		u.canaryTimeout = timeout
+	}
+}
+
+// WithRebalance configures an Uncordoner to request that workloads be
+// rebalanced onto each node it uncordons, by running the supplied functions,
+// for example a webhook or a Job that runs the descheduler, then emitting a
+// RebalanceRequested event about the node. Workloads evicted from a drained
+// node otherwise remain packed onto its neighbours after it recovers.
+func WithRebalance(fn ...func(n *core.Node) error) UncordonerOption {
+	return func(u *Uncordoner) {
+		u.rebalance = true
+		u.rebalanceFns = append(u.rebalanceFns, fn...)
+	}
+}
+
+// WithUncordonerKeys configures the annotation and label keys an Uncordoner
+// reads and writes.
+func WithUncordonerKeys(k Keys) UncordonerOption {
+	return func(u *Uncordoner) {
+		u.keys = k
+	}
+}
+
+// NewUncordoner returns an Uncordoner that uncordons nodes for which the
+// supplied unhealthy filter has returned false for a sustained period.
+func NewUncordoner(c kubernetes.Interface, e record.EventRecorder, nodes NodeStore, unhealthy func(o interface{}) bool, uo ...UncordonerOption) *Uncordoner {
+	u := &Uncordoner{
+		l:               zap.NewNop(),
+		c:               c,
+		e:               e,
+		nodes:           nodes,
+		unhealthy:       unhealthy,
+		keys:            DefaultKeys(),
+		interval:        DefaultUncordonInterval,
+		period:          DefaultUncordonHealthyPeriod,
+		canaryNamespace: meta.NamespaceDefault,
+		canaryTimeout:   DefaultCanaryTimeout,
+		canaryPoll:      canaryPollInterval,
+		since:           make(map[string]time.Time),
+	}
+	for _, o := range uo {
+		o(u)
+	}
+	return u
+}
+
+// Run the uncordoner until the supplied channel is closed.
+func (u *Uncordoner) Run(stop <-chan struct{}) {
+	wait.Until(func() { u.uncordonRecovered(time.Now()) }, u.interval, stop)
+}
+
+func (u *Uncordoner) uncordonRecovered(now time.Time) {
+	seen := make(map[string]bool)
+	for _, n := range u.nodes.List() {
+		if !u.cordonedByDraino(n) {
+			continue
+		}
+		seen[n.GetName()] = true
+		if u.requireReboot && !u.keys.rebooted(n) {
+			continue
+		}
+		if !nodeReady(n) || u.unhealthy(n) {
+			delete(u.since, n.GetName())
+			continue
+		}
+		since, ok := u.since[n.GetName()]
+		if !ok {
+			u.since[n.GetName()] = now
+			continue
+		}
+		if now.Sub(since) < u.period {
+			continue
+		}
+
+		log := u.l.With(zap.String("node", n.GetName()))
+		nr := &core.ObjectReference{Kind: "Node", Name: n.GetName(), UID: types.UID(n.GetName())}
+		if err := u.canary(n); err != nil {
+			log.Info("Failed canary", zap.Error(err))
+			u.e.Eventf(nr, core.EventTypeWarning, eventReasonUncordonFailed, "Canary pod failed: %v", err)
+			delete(u.since, n.GetName())
+			continue
+		}
+		if err := u.uncordon(n); err != nil {
+			log.Info("Failed to uncordon", zap.Error(err))
+			u.e.Eventf(nr, core.EventTypeWarning, eventReasonUncordonFailed, "Uncordoning failed: %v", err)
+			continue
+		}
+		log.Info("Uncordoned", zap.Bool("rebooted", u.keys.rebooted(n)))
+		u.e.Event(nr, core.EventTypeNormal, eventReasonUncordonSucceeded, "Uncordoned recovered node")
+		delete(u.since, n.GetName())
+		if u.rebalance {
+			u.requestRebalance(log, nr, n)
+		}
+	}
+	for name := range u.since {
+		if !seen[name] {
+			delete(u.since, name)
+		}
+	}
+}
+
+// requestRebalance runs the configured rebalance functions for the supplied
+// node, stopping at the first that fails, then emits a RebalanceRequested
+// event about the node. Failures are not retried; the node remains uncordoned.
+func (u *Uncordoner) requestRebalance(log *zap.Logger, nr *core.ObjectReference, n *core.Node) {
+	for _, fn := range u.rebalanceFns {
+		if err := fn(n); err != nil {
+			log.Info("Failed to request rebalance", zap.Error(err))
+			u.e.Eventf(nr, core.EventTypeWarning, eventReasonRebalanceFailed, "Rebalance request failed: %v", err)
+			return
+		}
+	}
+	log.Info("Requested rebalance")
+	u.e.Event(nr, core.EventTypeNormal, eventReasonRebalanceRequested, "Requested rebalance of workloads onto uncordoned node")
+}
+
+// cordonedByDraino returns true if the supplied node was cordoned by draino
+// because it matched a condition or label, and is not being drained. Nodes
+// that draino cordoned for any other reason, for example because their drain
+// was requested, have no cordon reason, and nodes whose drain was forced stay
+// cordoned until whoever forced it uncordons them.
+func (u *Uncordoner) cordonedByDraino(n *core.Node) bool {
+	if !u.keys.cordonedByDraino(n) || n.GetDeletionTimestamp() != nil {
+		return false
+	}
+	if n.GetAnnotations()[u.keys.CordonReason] == "" || n.GetAnnotations()[u.keys.ForceDrain] == "true" {
+		return false
+	}
+	_, draining := n.GetAnnotations()[u.keys.DrainInProgress]
+	return !draining
+}
+
+// rebooted returns true if the supplied node's boot or machine ID differs from
+// that recorded when it was cordoned.
+func (k Keys) rebooted(n *core.Node) bool {
+	changed := func(key, id string) bool {
+		recorded, ok := n.GetAnnotations()[key]
+		return ok && id != "" && id != recorded
+	}
+	return changed(k.BootID, n.Status.NodeInfo.BootID) || changed(k.MachineID, n.Status.NodeInfo.MachineID)
+}
+
+// canary runs a canary pod on the supplied node, if configured, and waits for
+// it to succeed.
+func (u *Uncordoner) canary(n *core.Node) error {
+	if u.canaryImage == "" {
+		return nil
+	}
+	p := &core.Pod{
+		ObjectMeta: meta.ObjectMeta{
+			GenerateName: "draino-canary-",
+			Namespace:    u.canaryNamespace,
+			Labels:       map[string]string{u.keys.HookNode: n.GetName()},
+		},
+		Spec: core.PodSpec{
+			NodeName:      n.GetName(),
+			RestartPolicy: core.RestartPolicyNever,
+			Containers:    []core.Container{{Name: "canary", Image: u.canaryImage}},
+			Tolerations:   []core.Toleration{{Operator: core.TolerationOpExists}},
+		},
+	}
+	p, err := u.c.CoreV1().Pods(u.canaryNamespace).Create(p)
+	if err != nil {
+		return errors.Wrapf(err, "cannot create canary pod for node %s", n.GetName())
+	}
+	defer u.c.CoreV1().Pods(p.GetNamespace()).Delete(p.GetName(), &meta.DeleteOptions{}) // nolint:errcheck
+
+	err = wait.PollImmediate(u.canaryPoll, u.canaryTimeout, func() (bool, error) {
+		fresh, err := u.c.CoreV1().Pods(p.GetNamespace()).Get(p.GetName(), meta.GetOptions{})
+		if err != nil {
+			return false, errors.Wrapf(err, "cannot get canary pod %s/%s", p.GetNamespace(), p.GetName())
+		}
+		switch fresh.Status.Phase {
+		case core.PodSucceeded:
+			return true, nil
+		case core.PodFailed:
+			return false, errors.Errorf("canary pod %s/%s failed: %s", p.GetNamespace(), p.GetName(), fresh.Status.Message)
+		}
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return errors.Wrapf(errTimeout{}, "timed out waiting for canary pod %s/%s to succeed", p.GetNamespace(), p.GetName())
+	}
+	return err
+}
+
+func (u *Uncordoner) uncordon(n *core.Node) error {
+	return uncordonNode(u.c, u.keys, n.GetName(), u.clear...)
+}
+
+// uncordonNode marks the named node schedulable, and removes the annotations
+// draino added when it cordoned the node, per the supplied keys, along with
+// any supplied annotation keys.
+func uncordonNode(c kubernetes.Interface, k Keys, name string, clear ...string) error {
+	fresh, err := c.CoreV1().Nodes().Get(name, meta.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "cannot get node %s", name)
+	}
+	fresh.Spec.Unschedulable = false
+	for _, key := range append([]string{k.Cordoned, k.DrainFailed, k.CordonReason, k.BootID, k.MachineID}, clear...) {
+		delete(fresh.Annotations, key)
+	}
+	_, err = c.CoreV1().Nodes().Update(fresh)
+	return errors.Wrapf(err, "cannot uncordon node %s", name)
+}
@@ -0,0 +1,296 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestUncordoner(t *testing.T) {
+	ready := core.NodeStatus{Conditions: []core.NodeCondition{{Type: core.NodeReady, Status: core.ConditionTrue}}}
+	rebootKey := DefaultKeyPrefix + "reboot-required"
+	cordoned := meta.ObjectMeta{Name: nodeName, Annotations: map[string]string{AnnotationCordoned: "2018-01-01T00:00:00Z", AnnotationCordonReason: "KernelDeadlock"}}
+
+	cases := []struct {
+		name           string
+		node           *core.Node
+		unhealthy      bool
+		requireReboot  bool
+		elapsed        time.Duration
+		canary         core.PodPhase
+		wantUncordoned bool
+	}{
+		{
+			name:           "Recovered",
+			node:           &core.Node{ObjectMeta: cordoned, Spec: core.NodeSpec{Unschedulable: true}, Status: ready},
+			elapsed:        2 * time.Minute,
+			wantUncordoned: true,
+		},
+		{
+			name:    "NotHealthyForLongEnough",
+			node:    &core.Node{ObjectMeta: cordoned, Spec: core.NodeSpec{Unschedulable: true}, Status: ready},
+			elapsed: 30 * time.Second,
+		},
+		{
+			name:      "StillUnhealthy",
+			node:      &core.Node{ObjectMeta: cordoned, Spec: core.NodeSpec{Unschedulable: true}, Status: ready},
+			unhealthy: true,
+			elapsed:   2 * time.Minute,
+		},
+		{
+			name:    "NotReady",
+			node:    &core.Node{ObjectMeta: cordoned, Spec: core.NodeSpec{Unschedulable: true}},
+			elapsed: 2 * time.Minute,
+		},
+		{
+			name:    "NotCordonedByDraino",
+			node:    &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}, Spec: core.NodeSpec{Unschedulable: true}, Status: ready},
+			elapsed: 2 * time.Minute,
+		},
+		{
+			name: "CordonedWithoutReason",
+			node: &core.Node{
+				ObjectMeta: meta.ObjectMeta{Name: nodeName, Annotations: map[string]string{AnnotationCordoned: "2018-01-01T00:00:00Z"}},
+				Spec:       core.NodeSpec{Unschedulable: true},
+				Status:     ready,
+			},
+			elapsed: 2 * time.Minute,
+		},
+		{
+			name: "DrainForced",
+			node: &core.Node{
+				ObjectMeta: meta.ObjectMeta{Name: nodeName, Annotations: map[string]string{
+					AnnotationCordoned:     "2018-01-01T00:00:00Z",
+					AnnotationCordonReason: "KernelDeadlock",
+					AnnotationForceDrain:   "true",
+				}},
+				Spec:   core.NodeSpec{Unschedulable: true},
+				Status: ready,
+			},
+			elapsed: 2 * time.Minute,
+		},
+		{
+			name: "DrainInProgress",
+			node: &core.Node{
+				ObjectMeta: meta.ObjectMeta{Name: nodeName, Annotations: map[string]string{
+					AnnotationCordoned:        "2018-01-01T00:00:00Z",
+					AnnotationDrainInProgress: "2018-01-01T00:00:00Z",
+				}},
+				Spec:   core.NodeSpec{Unschedulable: true},
+				Status: ready,
+			},
+			elapsed: 2 * time.Minute,
+		},
+		{
+			name:           "CanarySucceeded",
+			node:           &core.Node{ObjectMeta: cordoned, Spec: core.NodeSpec{Unschedulable: true}, Status: ready},
+			elapsed:        2 * time.Minute,
+			canary:         core.PodSucceeded,
+			wantUncordoned: true,
+		},
+		{
+			name:    "CanaryFailed",
+			node:    &core.Node{ObjectMeta: cordoned, Spec: core.NodeSpec{Unschedulable: true}, Status: ready},
+			elapsed: 2 * time.Minute,
+			canary:  core.PodFailed,
+		},
+		{
+			name:    "CanaryTimedOut",
+			node:    &core.Node{ObjectMeta: cordoned, Spec: core.NodeSpec{Unschedulable: true}, Status: ready},
+			elapsed: 2 * time.Minute,
+			canary:  core.PodPending,
+		},
+		{
+			name: "Rebooted",
+			node: &core.Node{
+				ObjectMeta: meta.ObjectMeta{Name: nodeName, Annotations: map[string]string{
+					AnnotationCordoned:     "2018-01-01T00:00:00Z",
+					AnnotationCordonReason: "KernelDeadlock",
+					AnnotationBootID:       "old",
+					rebootKey:              "true",
+				}},
+				Spec:   core.NodeSpec{Unschedulable: true},
+				Status: core.NodeStatus{Conditions: ready.Conditions, NodeInfo: core.NodeSystemInfo{BootID: "new"}},
+			},
+			requireReboot:  true,
+			elapsed:        2 * time.Minute,
+			wantUncordoned: true,
+		},
+		{
+			name: "Reimaged",
+			node: &core.Node{
+				ObjectMeta: meta.ObjectMeta{Name: nodeName, Annotations: map[string]string{
+					AnnotationCordoned:     "2018-01-01T00:00:00Z",
+					AnnotationCordonReason: "KernelDeadlock",
+					AnnotationMachineID:    "old",
+				}},
+				Spec:   core.NodeSpec{Unschedulable: true},
+				Status: core.NodeStatus{Conditions: ready.Conditions, NodeInfo: core.NodeSystemInfo{MachineID: "new"}},
+			},
+			requireReboot:  true,
+			elapsed:        2 * time.Minute,
+			wantUncordoned: true,
+		},
+		{
+			name: "NotRebooted",
+			node: &core.Node{
+				ObjectMeta: meta.ObjectMeta{Name: nodeName, Annotations: map[string]string{
+					AnnotationCordoned: "2018-01-01T00:00:00Z",
+					AnnotationBootID:   "old",
+				}},
+				Spec:   core.NodeSpec{Unschedulable: true},
+				Status: core.NodeStatus{Conditions: ready.Conditions, NodeInfo: core.NodeSystemInfo{BootID: "old"}},
+			},
+			requireReboot: true,
+			elapsed:       2 * time.Minute,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := fake.NewSimpleClientset(tc.node.DeepCopy())
+			var created *core.Pod
+			c.PrependReactor("create", "pods", func(a clienttesting.Action) (bool, runtime.Object, error) {
+				p := a.(clienttesting.CreateAction).GetObject().(*core.Pod)
+				p.SetName(p.GetGenerateName() + "abcde")
+				p.Status.Phase = tc.canary
+				created = p
+				return true, p, nil
+			})
+			c.PrependReactor("get", "pods", func(a clienttesting.Action) (bool, runtime.Object, error) {
+				return true, created, nil
+			})
+
+			o := []UncordonerOption{WithHealthyPeriod(1 * time.Minute), WithClearedAnnotations(rebootKey)}
+			if tc.requireReboot {
+				o = append(o, WithRebootRequired())
+			}
+			if tc.canary != "" {
+				o = append(o, WithCanaryPod("busybox", meta.NamespaceDefault, 100*time.Millisecond))
+			}
+			u := NewUncordoner(c, record.NewFakeRecorder(10), staticNodeStore{tc.node}, func(_ interface{}) bool { return tc.unhealthy }, o...)
+			u.canaryPoll = 10 * time.Millisecond
+
+			now := time.Now()
+			u.uncordonRecovered(now)
+			u.uncordonRecovered(now.Add(tc.elapsed))
+
+			fresh, err := c.CoreV1().Nodes().Get(nodeName, meta.GetOptions{})
+			if err != nil {
+				t.Fatalf("c.CoreV1().Nodes().Get(%v): %v", nodeName, err)
+			}
+			if uncordoned := !fresh.Spec.Unschedulable; uncordoned != tc.wantUncordoned {
+				t.Errorf("node uncordoned: want %v, got %v", tc.wantUncordoned, uncordoned)
+			}
+			if !tc.wantUncordoned {
+				return
+			}
+			for _, key := range []string{AnnotationCordoned, AnnotationBootID, AnnotationMachineID, rebootKey} {
+				if _, annotated := fresh.GetAnnotations()[key]; annotated {
+					t.Errorf("node still annotated with %s after uncordon", key)
+				}
+			}
+		})
+	}
+}
+
+func TestUncordonerRebalance(t *testing.T) {
+	ready := core.NodeStatus{Conditions: []core.NodeCondition{{Type: core.NodeReady, Status: core.ConditionTrue}}}
+	node := &core.Node{
+		ObjectMeta: meta.ObjectMeta{Name: nodeName, Annotations: map[string]string{AnnotationCordoned: "2018-01-01T00:00:00Z", AnnotationCordonReason: "KernelDeadlock"}},
+		Spec:       core.NodeSpec{Unschedulable: true},
+		Status:     ready,
+	}
+
+	cases := []struct {
+		name       string
+		fns        []func(n *core.Node) error
+		wantCalls  int
+		wantReason string
+	}{
+		{
+			name:       "EventOnly",
+			wantReason: eventReasonRebalanceRequested,
+		},
+		{
+			name: "ActionsSucceeded",
+			fns: []func(n *core.Node) error{
+				func(_ *core.Node) error { return nil },
+				func(_ *core.Node) error { return nil },
+			},
+			wantCalls:  2,
+			wantReason: eventReasonRebalanceRequested,
+		},
+		{
+			name: "ActionFailed",
+			fns: []func(n *core.Node) error{
+				func(_ *core.Node) error { return errors.New("boom") },
+				func(_ *core.Node) error { return nil },
+			},
+			wantCalls:  1,
+			wantReason: eventReasonRebalanceFailed,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			fns := make([]func(n *core.Node) error, 0, len(tc.fns))
+			for _, fn := range tc.fns {
+				fn := fn
+				fns = append(fns, func(n *core.Node) error {
+					calls++
+					if n.GetName() != nodeName {
+						t.Errorf("rebalance node: want %v, got %v", nodeName, n.GetName())
+					}
+					return fn(n)
+				})
+			}
+
+			e := record.NewFakeRecorder(10)
+			c := fake.NewSimpleClientset(node.DeepCopy())
+			u := NewUncordoner(c, e, staticNodeStore{node}, func(_ interface{}) bool { return false }, WithHealthyPeriod(1*time.Minute), WithRebalance(fns...))
+
+			now := time.Now()
+			u.uncordonRecovered(now)
+			u.uncordonRecovered(now.Add(2 * time.Minute))
+
+			if calls != tc.wantCalls {
+				t.Errorf("rebalance calls: want %v, got %v", tc.wantCalls, calls)
+			}
+			close(e.Events)
+			var reasons []string
+			for ev := range e.Events {
+				reasons = append(reasons, strings.Fields(ev)[1])
+			}
+			if diff := deep.Equal([]string{eventReasonUncordonSucceeded, tc.wantReason}, reasons); diff != nil {
+				t.Errorf("event reasons: want != got: %v", diff)
+			}
+		})
+	}
+}
@@ -34,6 +34,19 @@ const Component = "draino"
 // dependencies on glog.
 // https://godoc.org/k8s.io/client-go/tools/clientcmd#BuildConfigFromFlags
 func BuildConfigFromFlags(apiserver, kubecfg string) (*rest.Config, error) {
+	return BuildConfigFromContext(apiserver, kubecfg, "")
+}
+
+// BuildConfigFromContext is BuildConfigFromFlags, but uses the supplied
+// kubeconfig context rather than the current context. The kubeconfig file is
+// found using the usual loading rules if none is supplied.
+func BuildConfigFromContext(apiserver, kubecfg, context string) (*rest.Config, error) {
+	if context != "" {
+		rules := clientcmd.NewDefaultClientConfigLoadingRules()
+		rules.ExplicitPath = kubecfg
+		return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules,
+			&clientcmd.ConfigOverrides{ClusterInfo: api.Cluster{Server: apiserver}, CurrentContext: context}).ClientConfig()
+	}
 	if kubecfg != "" || apiserver != "" {
 		return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
 			&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubecfg},
@@ -42,9 +55,77 @@ func BuildConfigFromFlags(apiserver, kubecfg string) (*rest.Config, error) {
 	return rest.InClusterConfig()
 }
 
+// An EventRecorderOption configures the event recorder returned by
+// NewEventRecorder.
+type EventRecorderOption func(o *eventRecorderOptions)
+
+type eventRecorderOptions struct {
+	source    core.EventSource
+	namespace string
+}
+
+// WithEventSourceComponent configures the component from which recorded events
+// originate. Defaults to Component.
+func WithEventSourceComponent(component string) EventRecorderOption {
+	return func(o *eventRecorderOptions) {
+		o.source.Component = component
+	}
+}
+
+// WithEventSourceHost configures the host from which recorded events
+// originate.
+func WithEventSourceHost(host string) EventRecorderOption {
+	return func(o *eventRecorderOptions) {
+		o.source.Host = host
+	}
+}
+
+// WithEventNamespace configures the namespace in which events are recorded.
+// By default events about nodes are recorded in the default namespace. Events
+// recorded in any other namespace must claim that the object they pertain to
+// is in that namespace, so they are not shown by `kubectl describe node`.
+func WithEventNamespace(namespace string) EventRecorderOption {
+	return func(o *eventRecorderOptions) {
+		o.namespace = namespace
+	}
+}
+
 // NewEventRecorder returns a new record.EventRecorder for the given client.
-func NewEventRecorder(c kubernetes.Interface) record.EventRecorder {
+func NewEventRecorder(c kubernetes.Interface, ro ...EventRecorderOption) record.EventRecorder {
+	o := &eventRecorderOptions{source: core.EventSource{Component: Component}}
+	for _, fn := range ro {
+		fn(o)
+	}
+	var sink record.EventSink = &typedcore.EventSinkImpl{Interface: typedcore.New(c.CoreV1().RESTClient()).Events("")}
+	if o.namespace != "" {
+		sink = &namespacedEventSink{sink: sink, namespace: o.namespace}
+	}
 	b := record.NewBroadcaster()
-	b.StartRecordingToSink(&typedcore.EventSinkImpl{Interface: typedcore.New(c.CoreV1().RESTClient()).Events("")})
-	return b.NewRecorder(scheme.Scheme, core.EventSource{Component: Component})
+	b.StartRecordingToSink(sink)
+	return b.NewRecorder(scheme.Scheme, o.source)
+}
+
+// A namespacedEventSink records all events in the same namespace.
+type namespacedEventSink struct {
+	sink      record.EventSink
+	namespace string
+}
+
+func (s *namespacedEventSink) in(e *core.Event) *core.Event {
+	e = e.DeepCopy()
+	e.Namespace = s.namespace
+	e.InvolvedObject.Namespace = s.namespace
+	return e
+}
+
+func (s *namespacedEventSink) Create(e *core.Event) (*core.Event, error) {
+	return s.sink.Create(s.in(e))
+}
+
+func (s *namespacedEventSink) Update(e *core.Event) (*core.Event, error) {
+	return s.sink.Update(s.in(e))
+}
+
+func (s *namespacedEventSink) Patch(e *core.Event, data []byte) (*core.Event, error) {
+	return s.sink.Patch(s.in(e), data)
 }
@@ -0,0 +1,64 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type recordingEventSink struct {
+	events []*core.Event
+}
+
+func (s *recordingEventSink) Create(e *core.Event) (*core.Event, error) {
+	s.events = append(s.events, e)
+	return e, nil
+}
+
+func (s *recordingEventSink) Update(e *core.Event) (*core.Event, error) {
+	s.events = append(s.events, e)
+	return e, nil
+}
+
+func (s *recordingEventSink) Patch(e *core.Event, _ []byte) (*core.Event, error) {
+	s.events = append(s.events, e)
+	return e, nil
+}
+
+func TestNamespacedEventSink(t *testing.T) {
+	r := &recordingEventSink{}
+	s := &namespacedEventSink{sink: r, namespace: "draino"}
+	e := &core.Event{ObjectMeta: meta.ObjectMeta{Namespace: meta.NamespaceDefault}, InvolvedObject: core.ObjectReference{Kind: "Node", Name: nodeName}}
+
+	if _, err := s.Create(e); err != nil {
+		t.Fatalf("s.Create(): %v", err)
+	}
+	if _, err := s.Patch(e, nil); err != nil {
+		t.Fatalf("s.Patch(): %v", err)
+	}
+	for _, got := range r.events {
+		if got.GetNamespace() != "draino" || got.InvolvedObject.Namespace != "draino" {
+			t.Errorf("event namespaces: want draino, got %s and %s", got.GetNamespace(), got.InvolvedObject.Namespace)
+		}
+	}
+	if e.GetNamespace() != meta.NamespaceDefault {
+		t.Errorf("original event namespace: want %s, got %s", meta.NamespaceDefault, e.GetNamespace())
+	}
+}
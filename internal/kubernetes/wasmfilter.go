@@ -0,0 +1,281 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// DefaultWasmRuntime is the default command with which WasmFilter modules are
+// run.
+const DefaultWasmRuntime = "wazero run"
+
+// wasmModuleSuffix is the suffix of the names of WasmFilter modules.
+const wasmModuleSuffix = ".wasm"
+
+// A WasmModuleSource supplies the WebAssembly modules run by a WasmFilter.
+type WasmModuleSource interface {
+	// Name of the source, e.g. the namespace and name of its ConfigMap.
+	Name() string
+
+	// Run the source until the supplied channel is closed.
+	Run(stop <-chan struct{})
+
+	// HasSynced returns true once the source has fetched its modules.
+	HasSynced() bool
+
+	// Modules returns the current modules, keyed by name, and their version.
+	// The version is a comparable value that changes whenever the modules do.
+	Modules() (version interface{}, modules map[string][]byte, err error)
+}
+
+// A WasmFilter runs the WebAssembly modules of a WasmModuleSource under a WASI
+// runtime such as wazero. Modules implement the same interface as the
+// executables run by an ExecFilter: they are passed the JSON representation of
+// a pod or node on stdin, and decide by their exit code and stdout. An object
+// passes the filter only if it passes every module. Modules run in the order of
+// their names, and may be added, updated, or removed at their source without
+// restarting draino.
+type WasmFilter struct {
+	source  WasmModuleSource
+	runtime []string
+	timeout time.Duration
+	dir     string
+
+	mu         sync.RWMutex
+	loaded     interface{}
+	generation int
+	modules    []*ExecFilter
+}
+
+// NewWasmFilter returns a filter that runs the modules of the supplied source
+// with the supplied runtime command, i.e. the path to an executable optionally
+// followed by whitespace separated arguments, to which the path of each module
+// is appended. It waits up to the supplied timeout for each module to exit. The
+// filter must be run in order to run its source.
+func NewWasmFilter(s WasmModuleSource, runtimeCommand string, timeout time.Duration) (*WasmFilter, error) {
+	command := strings.Fields(runtimeCommand)
+	if len(command) == 0 {
+		return nil, errors.New("WebAssembly runtime command is required")
+	}
+	// Fail now rather than on the first pod or node filtered.
+	if _, err := exec.LookPath(command[0]); err != nil {
+		return nil, errors.Wrapf(err, "cannot find WebAssembly runtime %s", command[0])
+	}
+	dir, err := ioutil.TempDir("", "draino-wasm-")
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create WebAssembly module directory")
+	}
+	return &WasmFilter{source: s, runtime: command, timeout: timeout, dir: dir}, nil
+}
+
+// Name returns the name of the filter, i.e. of its source.
+func (f *WasmFilter) Name() string {
+	return f.source.Name()
+}
+
+// Run the filter's source until the supplied channel is closed, then remove
+// the modules written to disk.
+func (f *WasmFilter) Run(stop <-chan struct{}) {
+	f.source.Run(stop)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	os.RemoveAll(f.dir) // nolint:gosec
+}
+
+// HasSynced returns true once the filter's source has fetched its modules.
+func (f *WasmFilter) HasSynced() bool {
+	return f.source.HasSynced()
+}
+
+// FilterPod returns true if every module passes the supplied pod.
+func (f *WasmFilter) FilterPod(p core.Pod) (bool, error) {
+	passes, err := f.run(func(m *ExecFilter) (bool, error) { return m.run(p) })
+	return passes, errors.Wrapf(err, "cannot filter pod %s/%s", p.GetNamespace(), p.GetName())
+}
+
+// FilterNode returns true if the supplied object is a node that every module
+// passes. Nodes the modules fail to filter do not pass.
+func (f *WasmFilter) FilterNode(o interface{}) bool {
+	n, ok := o.(*core.Node)
+	if !ok {
+		return false
+	}
+	passes, err := f.run(func(m *ExecFilter) (bool, error) { return m.run(n) })
+	return err == nil && passes
+}
+
+// run the supplied function with each module, holding the modules on disk until
+// it returns.
+func (f *WasmFilter) run(fn func(m *ExecFilter) (bool, error)) (bool, error) {
+	if err := f.load(); err != nil {
+		return false, err
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, m := range f.modules {
+		passes, err := fn(m)
+		if err != nil || !passes {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// load writes the source's current modules to disk, if they have not already
+// been written.
+func (f *WasmFilter) load() error {
+	version, modules, err := f.source.Modules()
+	if err != nil {
+		return err
+	}
+
+	f.mu.RLock()
+	current := f.loaded == version
+	f.mu.RUnlock()
+	if current {
+		return nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.loaded == version {
+		return nil
+	}
+
+	// Sort by name so that modules run in a predictable order.
+	names := make([]string, 0, len(modules))
+	for name := range modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Each version of the modules is written to its own directory, so that
+	// the modules already loaded are left intact if writing fails.
+	dir := filepath.Join(f.dir, strconv.Itoa(f.generation+1))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.Wrap(err, "cannot create WebAssembly module directory")
+	}
+	loaded := make([]*ExecFilter, 0, len(names))
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, modules[name], 0600); err != nil {
+			return errors.Wrapf(err, "cannot write module %s of %s", name, f.Name())
+		}
+		args := append(append([]string{}, f.runtime[1:]...), path)
+		loaded = append(loaded, &ExecFilter{name: name, path: f.runtime[0], args: args, timeout: f.timeout})
+	}
+	if f.loaded != nil {
+		os.RemoveAll(filepath.Join(f.dir, strconv.Itoa(f.generation))) // nolint:gosec
+	}
+	f.loaded, f.modules = version, loaded
+	f.generation++
+	return nil
+}
+
+// ConfigMapWasmModules supplies the WebAssembly modules stored in the binary
+// data of a ConfigMap. Each key of the ConfigMap ending in .wasm is a module.
+// The ConfigMap is watched, so modules may be read without calling the API
+// server.
+type ConfigMapWasmModules struct {
+	i         cache.SharedInformer
+	namespace string
+	name      string
+}
+
+// NewConfigMapWasmModules returns a WasmModuleSource that reads modules from
+// the supplied ConfigMap. The source must be run in order to watch the
+// ConfigMap.
+func NewConfigMapWasmModules(c kubernetes.Interface, namespace, name string) *ConfigMapWasmModules {
+	named := fields.OneTermEqualSelector("metadata.name", name).String()
+	lw := &cache.ListWatch{
+		ListFunc: func(o meta.ListOptions) (runtime.Object, error) {
+			o.FieldSelector = named
+			return c.CoreV1().ConfigMaps(namespace).List(o)
+		},
+		WatchFunc: func(o meta.ListOptions) (watch.Interface, error) {
+			o.FieldSelector = named
+			return c.CoreV1().ConfigMaps(namespace).Watch(o)
+		},
+	}
+	return &ConfigMapWasmModules{
+		i:         cache.NewSharedInformer(lw, &core.ConfigMap{}, 30*time.Minute),
+		namespace: namespace,
+		name:      name,
+	}
+}
+
+// Name returns the namespace and name of the ConfigMap.
+func (s *ConfigMapWasmModules) Name() string {
+	return s.namespace + "/" + s.name
+}
+
+// Run the watch on the ConfigMap until the supplied channel is closed.
+func (s *ConfigMapWasmModules) Run(stop <-chan struct{}) {
+	s.i.Run(stop)
+}
+
+// HasSynced returns true once the ConfigMap has been cached.
+func (s *ConfigMapWasmModules) HasSynced() bool {
+	return s.i.HasSynced()
+}
+
+// Modules returns the modules of the cached ConfigMap. Their version is the
+// cached ConfigMap itself, which the cache replaces each time it changes.
+func (s *ConfigMapWasmModules) Modules() (interface{}, map[string][]byte, error) {
+	if !s.i.HasSynced() {
+		return nil, nil, errors.Errorf("ConfigMap %s has not been cached yet", s.Name())
+	}
+	o, exists, err := s.i.GetStore().GetByKey(s.Name())
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "cannot get ConfigMap %s", s.Name())
+	}
+	if !exists {
+		return nil, nil, errors.Errorf("ConfigMap %s does not exist", s.Name())
+	}
+	cm, ok := o.(*core.ConfigMap)
+	if !ok {
+		return nil, nil, errors.Errorf("cannot decode ConfigMap %s of unexpected type %T", s.Name(), o)
+	}
+	modules := make(map[string][]byte)
+	for k, v := range cm.BinaryData {
+		if strings.HasSuffix(k, wasmModuleSuffix) {
+			modules[k] = v
+		}
+	}
+	if len(modules) == 0 {
+		return nil, nil, errors.Errorf("ConfigMap %s contains no %s modules in its binaryData", s.Name(), wasmModuleSuffix)
+	}
+	return cm, modules, nil
+}
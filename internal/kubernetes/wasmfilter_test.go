@@ -0,0 +1,175 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+// wasmRuntimeScript stands in for a WebAssembly runtime. It passes objects
+// named in the module it is asked to run, and rejects all others.
+const wasmRuntimeScript = `grep -q "\"name\":\"$(cat "$1")\""`
+
+func TestWasmFilterPod(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wasmfilter")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(...): %v", err)
+	}
+	defer os.RemoveAll(dir)
+	rt := newExecFilterScript(t, dir, "runtime", wasmRuntimeScript)
+
+	cases := []struct {
+		name       string
+		configMap  *core.ConfigMap
+		unsynced   bool
+		wantPasses bool
+		wantErr    bool
+	}{
+		{
+			name: "Passes",
+			configMap: &core.ConfigMap{
+				ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: "filters"},
+				BinaryData: map[string][]byte{"a.wasm": []byte(podName), "b.wasm": []byte(podName)},
+			},
+			wantPasses: true,
+		},
+		{
+			name: "RejectedByOneModule",
+			configMap: &core.ConfigMap{
+				ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: "filters"},
+				BinaryData: map[string][]byte{"a.wasm": []byte(podName), "b.wasm": []byte("other")},
+			},
+		},
+		{
+			name: "OtherKeysIgnored",
+			configMap: &core.ConfigMap{
+				ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: "filters"},
+				BinaryData: map[string][]byte{"a.wasm": []byte(podName), "README": []byte("other")},
+			},
+			wantPasses: true,
+		},
+		{
+			name: "NoModules",
+			configMap: &core.ConfigMap{
+				ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: "filters"},
+				Data:       map[string]string{"a.wasm": podName},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "ConfigMapDoesNotExist",
+			wantErr: true,
+		},
+		{
+			name: "ConfigMapNotCached",
+			configMap: &core.ConfigMap{
+				ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: "filters"},
+				BinaryData: map[string][]byte{"a.wasm": []byte(podName)},
+			},
+			unsynced: true,
+			wantErr:  true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			objects := []runtime.Object{}
+			if tc.configMap != nil {
+				objects = append(objects, tc.configMap)
+			}
+			f, err := NewWasmFilter(NewConfigMapWasmModules(fake.NewSimpleClientset(objects...), ns, "filters"), rt, DefaultExecFilterTimeout)
+			if err != nil {
+				t.Fatalf("NewWasmFilter(...): %v", err)
+			}
+			stop := make(chan struct{})
+			defer close(stop)
+			if !tc.unsynced {
+				go f.Run(stop)
+				if !cache.WaitForCacheSync(stop, f.HasSynced) {
+					t.Fatal("cache.WaitForCacheSync(): ConfigMap was not cached")
+				}
+			}
+			p := core.Pod{ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: podName}}
+			passes, err := f.FilterPod(p)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("f.FilterPod(%v): want error %v, got %v", p.GetName(), tc.wantErr, err)
+			}
+			if passes != tc.wantPasses {
+				t.Errorf("f.FilterPod(%v): want %v, got %v", p.GetName(), tc.wantPasses, passes)
+			}
+		})
+	}
+}
+
+func TestWasmFilterNodeUpdated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wasmfilter")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(...): %v", err)
+	}
+	defer os.RemoveAll(dir)
+	rt := newExecFilterScript(t, dir, "runtime", wasmRuntimeScript)
+
+	cm := &core.ConfigMap{
+		ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: "filters"},
+		BinaryData: map[string][]byte{"a.wasm": []byte(nodeName)},
+	}
+	c := fake.NewSimpleClientset(cm)
+	f, err := NewWasmFilter(NewConfigMapWasmModules(c, ns, "filters"), rt, DefaultExecFilterTimeout)
+	if err != nil {
+		t.Fatalf("NewWasmFilter(...): %v", err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go f.Run(stop)
+	if !cache.WaitForCacheSync(stop, f.HasSynced) {
+		t.Fatal("cache.WaitForCacheSync(): ConfigMap was not cached")
+	}
+
+	n := &core.Node{ObjectMeta: meta.ObjectMeta{Name: nodeName}}
+	if !f.FilterNode(n) {
+		t.Fatalf("f.FilterNode(%v): want true, got false", n.GetName())
+	}
+
+	updated := cm.DeepCopy()
+	updated.BinaryData["a.wasm"] = []byte("other")
+	if _, err := c.CoreV1().ConfigMaps(ns).Update(updated); err != nil {
+		t.Fatalf("c.CoreV1().ConfigMaps(%v).Update(...): %v", ns, err)
+	}
+	err = wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return !f.FilterNode(n), nil
+	})
+	if err != nil {
+		t.Errorf("f.FilterNode(%v): want false once the module is updated, got true", n.GetName())
+	}
+}
+
+func TestWasmRuntimeNotFound(t *testing.T) {
+	s := NewConfigMapWasmModules(fake.NewSimpleClientset(), ns, "filters")
+	if _, err := NewWasmFilter(s, "/nonexistent/wazero run", DefaultExecFilterTimeout); err == nil {
+		t.Error("NewWasmFilter(...): want error for a runtime that does not exist")
+	}
+}
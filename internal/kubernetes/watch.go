@@ -36,6 +36,9 @@ const (
 type NodeStore interface {
 	// Get an node by name. Returns an error if the node does not exist.
 	Get(name string) (*core.Node, error)
+
+	// List all nodes.
+	List() []*core.Node
 }
 
 // An NodeWatch is a cache of node resources that notifies registered
@@ -47,10 +50,23 @@ type NodeWatch struct {
 // NewNodeWatch creates a watch on node resources. Nodes are cached and the
 // provided ResourceEventHandlers are called when the cache changes.
 func NewNodeWatch(c kubernetes.Interface, rs ...cache.ResourceEventHandler) *NodeWatch {
+	return NewTransformedNodeWatch(c, nil, rs...)
+}
+
+// A NodeTransform modifies a node before it is cached.
+type NodeTransform func(n *core.Node)
+
+// NewTransformedNodeWatch creates a watch on node resources that applies the
+// supplied transform, if any, to each node before it is cached and the
+// provided ResourceEventHandlers are called.
+func NewTransformedNodeWatch(c kubernetes.Interface, t NodeTransform, rs ...cache.ResourceEventHandler) *NodeWatch {
 	lw := &cache.ListWatch{
 		ListFunc:  func(o meta.ListOptions) (runtime.Object, error) { return c.CoreV1().Nodes().List(o) },
 		WatchFunc: func(o meta.ListOptions) (watch.Interface, error) { return c.CoreV1().Nodes().Watch(o) },
 	}
+	if t != nil {
+		lw = transformNodes(lw, t)
+	}
 	i := cache.NewSharedInformer(lw, &core.Node{}, 30*time.Minute)
 	for _, r := range rs {
 		i.AddEventHandler(r)
@@ -58,6 +74,51 @@ func NewNodeWatch(c kubernetes.Interface, rs ...cache.ResourceEventHandler) *Nod
 	return &NodeWatch{i}
 }
 
+// transformNodes returns a ListWatch that applies the supplied transform to
+// copies of the nodes listed and watched by the supplied ListWatch. The
+// originals may be shared with other readers, so they are never modified.
+func transformNodes(lw *cache.ListWatch, t NodeTransform) *cache.ListWatch {
+	return &cache.ListWatch{
+		ListFunc: func(o meta.ListOptions) (runtime.Object, error) {
+			obj, err := lw.List(o)
+			if l, ok := obj.(*core.NodeList); ok {
+				l = l.DeepCopy()
+				for i := range l.Items {
+					t(&l.Items[i])
+				}
+				return l, err
+			}
+			return obj, err
+		},
+		WatchFunc: func(o meta.ListOptions) (watch.Interface, error) {
+			w, err := lw.Watch(o)
+			if err != nil {
+				return nil, err
+			}
+			return watch.Filter(w, func(e watch.Event) (watch.Event, bool) {
+				if n, ok := e.Object.(*core.Node); ok {
+					n = n.DeepCopy()
+					t(n)
+					e.Object = n
+				}
+				return e, true
+			}), nil
+		},
+	}
+}
+
+// StripNode is a NodeTransform that removes the parts of a node draino does
+// not use, and that account for much of its size: the container images and
+// volumes its status lists, and the configuration kubectl apply last applied
+// to it. Draino reads nodes afresh before updating them, so stripped fields
+// are never written back.
+func StripNode(n *core.Node) {
+	n.Status.Images = nil
+	n.Status.VolumesInUse = nil
+	n.Status.VolumesAttached = nil
+	delete(n.Annotations, core.LastAppliedConfigAnnotation)
+}
+
 // Get an node by name. Returns an error if the node does not exist.
 func (w *NodeWatch) Get(name string) (*core.Node, error) {
 	o, exists, err := w.GetStore().GetByKey(name)
@@ -69,3 +130,15 @@ func (w *NodeWatch) Get(name string) (*core.Node, error) {
 	}
 	return o.(*core.Node), nil
 }
+
+// List all nodes.
+func (w *NodeWatch) List() []*core.Node {
+	l := w.GetStore().List()
+	nodes := make([]*core.Node, 0, len(l))
+	for _, o := range l {
+		if n, ok := o.(*core.Node); ok {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
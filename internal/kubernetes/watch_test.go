@@ -18,10 +18,14 @@ package kubernetes
 
 import (
 	"testing"
+	"time"
 
 	"github.com/go-test/deep"
 	"github.com/pkg/errors"
 	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/tools/cache"
 )
 
@@ -33,11 +37,12 @@ type getByKeyFunc func(key string) (interface{}, bool, error)
 
 type predictableInformer struct {
 	cache.SharedInformer
-	fn getByKeyFunc
+	fn     getByKeyFunc
+	listFn func() []interface{}
 }
 
 func (i *predictableInformer) GetStore() cache.Store {
-	return &cache.FakeCustomStore{GetByKeyFunc: i.fn}
+	return &cache.FakeCustomStore{GetByKeyFunc: i.fn, ListFunc: i.listFn}
 }
 
 func TestNodeWatcher(t *testing.T) {
@@ -88,3 +93,66 @@ func TestNodeWatcher(t *testing.T) {
 		})
 	}
 }
+
+func TestNodeWatcherList(t *testing.T) {
+	i := &predictableInformer{listFn: func() []interface{} {
+		return []interface{}{&core.Node{}, &core.Pod{}, &core.Node{}}
+	}}
+	w := &NodeWatch{i}
+	want := []*core.Node{&core.Node{}, &core.Node{}}
+	if diff := deep.Equal(want, w.List()); diff != nil {
+		t.Errorf("w.List(): want != got %v", diff)
+	}
+}
+
+func TestTransformedNodeWatch(t *testing.T) {
+	node := func(name string) *core.Node {
+		return &core.Node{
+			ObjectMeta: meta.ObjectMeta{
+				Name: name,
+				Annotations: map[string]string{
+					core.LastAppliedConfigAnnotation: "{}",
+					"cool":                           "very",
+				},
+			},
+			Status: core.NodeStatus{
+				NodeInfo:     core.NodeSystemInfo{BootID: "boot"},
+				Images:       []core.ContainerImage{{Names: []string{"cool/image"}, SizeBytes: 42}},
+				VolumesInUse: []core.UniqueVolumeName{"cool/volume"},
+			},
+		}
+	}
+	want := func(name string) *core.Node {
+		return &core.Node{
+			ObjectMeta: meta.ObjectMeta{Name: name, Annotations: map[string]string{"cool": "very"}},
+			Status:     core.NodeStatus{NodeInfo: core.NodeSystemInfo{BootID: "boot"}},
+		}
+	}
+
+	c := fake.NewSimpleClientset(node("listed"))
+	w := NewTransformedNodeWatch(c, StripNode)
+	stop := make(chan struct{})
+	defer close(stop)
+	go w.Run(stop)
+	if !cache.WaitForCacheSync(stop, w.HasSynced) {
+		t.Fatal("cache.WaitForCacheSync(...): cache did not sync")
+	}
+	if _, err := c.CoreV1().Nodes().Create(node("watched")); err != nil {
+		t.Fatalf("c.CoreV1().Nodes().Create(...): %v", err)
+	}
+
+	for _, name := range []string{"listed", "watched"} {
+		var got *core.Node
+		err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+			var err error
+			got, err = w.Get(name)
+			return err == nil, nil
+		})
+		if err != nil {
+			t.Fatalf("w.Get(%v): node was not cached", name)
+		}
+		if diff := deep.Equal(want(name), got); diff != nil {
+			t.Errorf("w.Get(%v): want != got %v", name, diff)
+		}
+	}
+}
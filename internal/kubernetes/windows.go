@@ -0,0 +1,298 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	minutesPerDay   = 24 * 60
+	windowSeparator = " - "
+
+	// dateTimeLayout is used to parse date range windows that are not in
+	// RFC 3339 format, in the window's timezone.
+	dateTimeLayout = "2006-01-02T15:04:05"
+)
+
+// A TimeWindow is a period of time.
+type TimeWindow interface {
+	// Contains returns true if the supplied time falls within the window.
+	Contains(t time.Time) bool
+
+	// String returns a human readable description of the window.
+	String() string
+}
+
+// A WeeklyWindow is a period of time that recurs every week, for example
+// from Friday at 16:00 until Monday at 08:00 in a particular timezone.
+type WeeklyWindow struct {
+	spec string
+	loc  *time.Location
+
+	// Start and end of the window, in minutes since midnight on Sunday.
+	start int
+	end   int
+}
+
+// Contains returns true if the supplied time falls within the window.
+func (w *WeeklyWindow) Contains(t time.Time) bool {
+	t = t.In(w.loc)
+	m := int(t.Weekday())*minutesPerDay + t.Hour()*60 + t.Minute()
+	if w.start <= w.end {
+		return m >= w.start && m < w.end
+	}
+	// The window wraps around the end of the week.
+	return m >= w.start || m < w.end
+}
+
+func (w *WeeklyWindow) String() string {
+	return w.spec
+}
+
+// A DateRangeWindow is a one-off period of time.
+type DateRangeWindow struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Contains returns true if the supplied time falls within the window.
+func (w *DateRangeWindow) Contains(t time.Time) bool {
+	return !t.Before(w.Start) && t.Before(w.End)
+}
+
+func (w *DateRangeWindow) String() string {
+	return w.Start.Format(time.RFC3339) + windowSeparator + w.End.Format(time.RFC3339)
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ParseTimeWindow parses either a weekly window of the form
+// "Fri 16:00 - Mon 08:00", or a date range window of the form
+// "2018-12-24T00:00:00Z - 2019-01-02T00:00:00Z". Windows may end with an IANA
+// timezone name, e.g. "Mon 02:00 - Mon 05:00 America/New_York", in which case
+// the window's times are interpreted in that timezone. Windows that do not
+// specify a timezone are interpreted in the supplied location. Date ranges
+// that specify neither a timezone nor an RFC 3339 offset are also
+// interpreted in the supplied location.
+func ParseTimeWindow(spec string, loc *time.Location) (TimeWindow, error) {
+	parts := strings.Split(spec, windowSeparator)
+	if len(parts) != 2 {
+		return nil, errors.Errorf("cannot parse time window %q: must be of the form START - END [TIMEZONE]", spec)
+	}
+	from, to := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+
+	// Times always contain a colon, while timezone names never do.
+	if f := strings.Fields(to); len(f) > 1 && !strings.Contains(f[len(f)-1], ":") {
+		l, err := time.LoadLocation(f[len(f)-1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot load timezone of time window %q", spec)
+		}
+		loc = l
+		to = strings.Join(f[:len(f)-1], " ")
+	}
+
+	if start, err := parseDateTime(from, loc); err == nil {
+		end, err := parseDateTime(to, loc)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot parse end of time window %q", spec)
+		}
+		if !end.After(start) {
+			return nil, errors.Errorf("cannot parse time window %q: end must be after start", spec)
+		}
+		return &DateRangeWindow{Start: start, End: end}, nil
+	}
+
+	start, err := parseWeekMinute(from)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot parse start of time window %q", spec)
+	}
+	end, err := parseWeekMinute(to)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot parse end of time window %q", spec)
+	}
+	if start == end {
+		return nil, errors.Errorf("cannot parse time window %q: start and end must differ", spec)
+	}
+	return &WeeklyWindow{spec: spec, loc: loc, start: start, end: end}, nil
+}
+
+// parseDateTime parses an RFC 3339 time, or a time without an offset in the
+// supplied location.
+func parseDateTime(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(dateTimeLayout, s, loc)
+}
+
+// parseWeekMinute parses strings like "Fri 16:00" into minutes since midnight
+// on Sunday.
+func parseWeekMinute(s string) (int, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return 0, errors.Errorf("%q must be of the form DAY HH:MM", s)
+	}
+	day := strings.ToLower(fields[0])
+	if len(day) > 3 {
+		day = day[:3]
+	}
+	d, ok := weekdays[day]
+	if !ok {
+		return 0, errors.Errorf("%q is not a day of the week", fields[0])
+	}
+	t, err := time.Parse("15:04", fields[1])
+	if err != nil {
+		return 0, errors.Wrapf(err, "cannot parse time of day %q", fields[1])
+	}
+	return int(d)*minutesPerDay + t.Hour()*60 + t.Minute(), nil
+}
+
+// A TimeWindowSource returns a set of time windows.
+type TimeWindowSource interface {
+	// Windows returns the current set of time windows.
+	Windows() ([]TimeWindow, error)
+}
+
+// StaticTimeWindows is a fixed set of time windows.
+type StaticTimeWindows []TimeWindow
+
+// Windows returns the fixed set of time windows.
+func (w StaticTimeWindows) Windows() ([]TimeWindow, error) {
+	return w, nil
+}
+
+// ConfigMapTimeWindows reads time windows from the data of a ConfigMap. Each
+// value in the ConfigMap's data must be a time window parseable by
+// ParseTimeWindow. Keys are ignored, and may be used to describe the window.
+// The ConfigMap is watched, so that windows may be read without calling the
+// API server.
+type ConfigMapTimeWindows struct {
+	i         cache.SharedInformer
+	namespace string
+	name      string
+	loc       *time.Location
+}
+
+// NewConfigMapTimeWindows returns a TimeWindowSource that reads windows from
+// the supplied ConfigMap. Windows that do not specify a timezone are
+// interpreted in the supplied location. The source must be run in order to
+// watch the ConfigMap.
+func NewConfigMapTimeWindows(c kubernetes.Interface, namespace, name string, loc *time.Location) *ConfigMapTimeWindows {
+	named := fields.OneTermEqualSelector("metadata.name", name).String()
+	lw := &cache.ListWatch{
+		ListFunc: func(o meta.ListOptions) (runtime.Object, error) {
+			o.FieldSelector = named
+			return c.CoreV1().ConfigMaps(namespace).List(o)
+		},
+		WatchFunc: func(o meta.ListOptions) (watch.Interface, error) {
+			o.FieldSelector = named
+			return c.CoreV1().ConfigMaps(namespace).Watch(o)
+		},
+	}
+	return &ConfigMapTimeWindows{
+		i:         cache.NewSharedInformer(lw, &core.ConfigMap{}, 30*time.Minute),
+		namespace: namespace,
+		name:      name,
+		loc:       loc,
+	}
+}
+
+// Run the watch on the ConfigMap until the supplied channel is closed.
+func (s *ConfigMapTimeWindows) Run(stop <-chan struct{}) {
+	s.i.Run(stop)
+}
+
+// HasSynced returns true once the ConfigMap has been cached.
+func (s *ConfigMapTimeWindows) HasSynced() bool {
+	return s.i.HasSynced()
+}
+
+// Windows returns the time windows currently described by the ConfigMap.
+func (s *ConfigMapTimeWindows) Windows() ([]TimeWindow, error) {
+	if !s.i.HasSynced() {
+		return nil, errors.Errorf("ConfigMap %s/%s has not been cached yet", s.namespace, s.name)
+	}
+	o, exists, err := s.i.GetStore().GetByKey(s.namespace + "/" + s.name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot get ConfigMap %s/%s", s.namespace, s.name)
+	}
+	if !exists {
+		return nil, errors.Errorf("ConfigMap %s/%s does not exist", s.namespace, s.name)
+	}
+	cm, ok := o.(*core.ConfigMap)
+	if !ok {
+		return nil, errors.Errorf("cannot decode ConfigMap %s/%s of unexpected type %T", s.namespace, s.name, o)
+	}
+
+	// Sort by key so that windows are returned in a predictable order.
+	keys := make([]string, 0, len(cm.Data))
+	for k := range cm.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	w := make([]TimeWindow, 0, len(keys))
+	for _, k := range keys {
+		tw, err := ParseTimeWindow(cm.Data[k], s.loc)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot parse time window %s in ConfigMap %s/%s", k, s.namespace, s.name)
+		}
+		w = append(w, tw)
+	}
+	return w, nil
+}
+
+// NewBlackoutGate returns a DrainGateFunc that prevents drains from starting
+// during any of the windows returned by the supplied sources. Drains are also
+// prevented if the windows cannot be determined.
+func NewBlackoutGate(sources ...TimeWindowSource) DrainGateFunc {
+	return func(_ *core.Node, now time.Time) (bool, string) {
+		for _, s := range sources {
+			windows, err := s.Windows()
+			if err != nil {
+				return false, fmt.Sprintf("cannot determine blackout windows: %v", err)
+			}
+			for _, w := range windows {
+				if w.Contains(now) {
+					return false, fmt.Sprintf("within blackout window %s", w)
+				}
+			}
+		}
+		return true, ""
+	}
+}
@@ -0,0 +1,244 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func mustParseTime(t *testing.T, s string) time.Time {
+	t.Helper()
+	tm, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t.Fatalf("time.Parse(%v): %v", s, err)
+	}
+	return tm
+}
+
+func TestTimeWindow(t *testing.T) {
+	cases := []struct {
+		name     string
+		spec     string
+		time     string
+		contains bool
+		wantErr  bool
+	}{
+		{
+			// 2018-10-19 is a Friday.
+			name:     "WithinWeekendWindow",
+			spec:     "Fri 16:00 - Mon 08:00",
+			time:     "2018-10-20T12:00:00Z",
+			contains: true,
+		},
+		{
+			name:     "StartOfWeekendWindow",
+			spec:     "Fri 16:00 - Mon 08:00",
+			time:     "2018-10-19T16:00:00Z",
+			contains: true,
+		},
+		{
+			name:     "EndOfWeekendWindow",
+			spec:     "Fri 16:00 - Mon 08:00",
+			time:     "2018-10-22T08:00:00Z",
+			contains: false,
+		},
+		{
+			name:     "OutsideWeekendWindow",
+			spec:     "Fri 16:00 - Mon 08:00",
+			time:     "2018-10-17T12:00:00Z",
+			contains: false,
+		},
+		{
+			name:     "WithinWeekdayWindow",
+			spec:     "Wednesday 09:00 - Wednesday 17:30",
+			time:     "2018-10-17T17:29:00Z",
+			contains: true,
+		},
+		{
+			name:     "WithinDateRange",
+			spec:     "2018-12-24T00:00:00Z - 2019-01-02T00:00:00Z",
+			time:     "2018-12-31T23:59:59Z",
+			contains: true,
+		},
+		{
+			name:     "OutsideDateRange",
+			spec:     "2018-12-24T00:00:00Z - 2019-01-02T00:00:00Z",
+			time:     "2019-01-02T00:00:00Z",
+			contains: false,
+		},
+		{
+			// 03:00 in New York is 07:00 UTC during daylight saving time.
+			name:     "WithinWindowInTimezone",
+			spec:     "Wed 02:00 - Wed 05:00 America/New_York",
+			time:     "2018-10-17T07:00:00Z",
+			contains: true,
+		},
+		{
+			name:     "OutsideWindowInTimezone",
+			spec:     "Wed 02:00 - Wed 05:00 America/New_York",
+			time:     "2018-10-17T03:00:00Z",
+			contains: false,
+		},
+		{
+			name:     "WithinDateRangeInTimezone",
+			spec:     "2018-12-24T00:00:00 - 2018-12-26T00:00:00 Europe/Berlin",
+			time:     "2018-12-23T23:30:00Z",
+			contains: true,
+		},
+		{
+			name:    "UnknownTimezone",
+			spec:    "Fri 16:00 - Mon 08:00 Mars/Olympus_Mons",
+			wantErr: true,
+		},
+		{
+			name:    "MissingSeparator",
+			spec:    "Fri 16:00",
+			wantErr: true,
+		},
+		{
+			name:    "NotADay",
+			spec:    "Caturday 16:00 - Mon 08:00",
+			wantErr: true,
+		},
+		{
+			name:    "NotATime",
+			spec:    "Fri 25:00 - Mon 08:00",
+			wantErr: true,
+		},
+		{
+			name:    "DateRangeEndsBeforeStart",
+			spec:    "2019-01-02T00:00:00Z - 2018-12-24T00:00:00Z",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w, err := ParseTimeWindow(tc.spec, time.UTC)
+			if err != nil {
+				if tc.wantErr {
+					return
+				}
+				t.Fatalf("ParseTimeWindow(%v): %v", tc.spec, err)
+			}
+			if tc.wantErr {
+				t.Fatalf("ParseTimeWindow(%v): want error", tc.spec)
+			}
+			tm := mustParseTime(t, tc.time)
+			if got := w.Contains(tm); got != tc.contains {
+				t.Errorf("w.Contains(%v): want %v, got %v", tm, tc.contains, got)
+			}
+		})
+	}
+}
+
+func TestBlackoutGate(t *testing.T) {
+	cases := []struct {
+		name      string
+		configMap *core.ConfigMap
+		unsynced  bool
+		time      string
+		open      bool
+	}{
+		{
+			name: "OutsideConfigMapWindows",
+			configMap: &core.ConfigMap{
+				ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: "blackouts"},
+				Data:       map[string]string{"holidays": "2018-12-24T00:00:00Z - 2019-01-02T00:00:00Z"},
+			},
+			time: "2018-10-17T12:00:00Z",
+			open: true,
+		},
+		{
+			name: "WithinConfigMapWindow",
+			configMap: &core.ConfigMap{
+				ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: "blackouts"},
+				Data:       map[string]string{"holidays": "2018-12-24T00:00:00Z - 2019-01-02T00:00:00Z"},
+			},
+			time: "2018-12-25T12:00:00Z",
+			open: false,
+		},
+		{
+			name:      "WithinStaticWindow",
+			configMap: &core.ConfigMap{ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: "blackouts"}},
+			time:      "2018-10-20T12:00:00Z",
+			open:      false,
+		},
+		{
+			name:      "OtherConfigMapIgnored",
+			configMap: &core.ConfigMap{ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: "other"}},
+			time:      "2018-10-17T12:00:00Z",
+			open:      false,
+		},
+		{
+			name: "ConfigMapDoesNotExist",
+			time: "2018-10-17T12:00:00Z",
+			open: false,
+		},
+		{
+			name:      "ConfigMapNotCached",
+			configMap: &core.ConfigMap{ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: "blackouts"}},
+			unsynced:  true,
+			time:      "2018-10-17T12:00:00Z",
+			open:      false,
+		},
+		{
+			name: "InvalidConfigMapWindow",
+			configMap: &core.ConfigMap{
+				ObjectMeta: meta.ObjectMeta{Namespace: ns, Name: "blackouts"},
+				Data:       map[string]string{"holidays": "soon - later"},
+			},
+			time: "2018-10-17T12:00:00Z",
+			open: false,
+		},
+	}
+
+	weekend, err := ParseTimeWindow("Fri 16:00 - Mon 08:00", time.UTC)
+	if err != nil {
+		t.Fatalf("ParseTimeWindow(): %v", err)
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			objects := []runtime.Object{}
+			if tc.configMap != nil {
+				objects = append(objects, tc.configMap)
+			}
+			cmw := NewConfigMapTimeWindows(fake.NewSimpleClientset(objects...), ns, "blackouts", time.UTC)
+			if !tc.unsynced {
+				stop := make(chan struct{})
+				defer close(stop)
+				go cmw.Run(stop)
+				if !cache.WaitForCacheSync(stop, cmw.HasSynced) {
+					t.Fatal("cache.WaitForCacheSync(): ConfigMap was not cached")
+				}
+			}
+			g := NewBlackoutGate(StaticTimeWindows{weekend}, cmw)
+			tm := mustParseTime(t, tc.time)
+			if open, reason := g(&core.Node{}, tm); open != tc.open {
+				t.Errorf("g(%v): want open %v, got %v (%v)", tm, tc.open, open, reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,301 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	goplugin "github.com/hashicorp/go-plugin"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	core "k8s.io/api/core/v1"
+
+	"github.com/planetlabs/draino/plugin/proto"
+)
+
+// Default plugin timeouts.
+const (
+	DefaultStartTimeout = 10 * time.Second
+	DefaultCallTimeout  = 30 * time.Second
+	DefaultRestartDelay = 10 * time.Second
+)
+
+// A Client is a plugin started by draino.
+type Client struct {
+	l            *zap.Logger
+	path         string
+	args         []string
+	startTimeout time.Duration
+	callTimeout  time.Duration
+	restartDelay time.Duration
+	caps         Capabilities
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	client *goplugin.Client
+	rpc    proto.PluginClient
+	exited <-chan struct{}
+}
+
+// A ClientOption configures a Client.
+type ClientOption func(c *Client)
+
+// WithLogger configures a Client to log restarts of its plugin using the
+// supplied logger.
+func WithLogger(l *zap.Logger) ClientOption {
+	return func(c *Client) {
+		c.l = l
+	}
+}
+
+// WithStartTimeout configures how long to wait for a plugin to complete its
+// handshake.
+func WithStartTimeout(t time.Duration) ClientOption {
+	return func(c *Client) {
+		c.startTimeout = t
+	}
+}
+
+// WithCallTimeout configures how long to wait for a plugin to filter a pod or
+// node, or to cordon a node. Drains are not subject to the timeout.
+func WithCallTimeout(t time.Duration) ClientOption {
+	return func(c *Client) {
+		c.callTimeout = t
+	}
+}
+
+// WithRestartDelay configures how long to wait before restarting a plugin that
+// exited, or that could not be restarted.
+func WithRestartDelay(d time.Duration) ClientOption {
+	return func(c *Client) {
+		c.restartDelay = d
+	}
+}
+
+// Start the plugin at the supplied path, with the supplied arguments.
+func Start(path string, args []string, co ...ClientOption) (*Client, error) {
+	c := &Client{
+		l:            zap.NewNop(),
+		path:         path,
+		args:         args,
+		startTimeout: DefaultStartTimeout,
+		callTimeout:  DefaultCallTimeout,
+		restartDelay: DefaultRestartDelay,
+	}
+	for _, o := range co {
+		o(c)
+	}
+	if err := c.start(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// start the plugin process, complete its handshake, and connect to it.
+func (c *Client) start() error {
+	cmd := exec.Command(c.path, c.args...) // nolint:gosec
+	client := goplugin.NewClient(&goplugin.ClientConfig{
+		HandshakeConfig:  Handshake,
+		Plugins:          goplugin.PluginSet{name: &grpcPlugin{}},
+		Cmd:              cmd,
+		AllowedProtocols: []goplugin.Protocol{goplugin.ProtocolGRPC},
+		StartTimeout:     c.startTimeout,
+		Stderr:           os.Stderr,
+		// Restarts and failures are logged by the Client, and the plugin's
+		// stderr is passed through as is.
+		Logger: hclog.New(&hclog.LoggerOptions{Output: ioutil.Discard}),
+	})
+	cp, err := client.Client()
+	if err != nil {
+		client.Kill()
+		return errors.Wrapf(err, "cannot start plugin %s", c.path)
+	}
+	raw, err := cp.Dispense(name)
+	if err != nil {
+		client.Kill()
+		return errors.Wrapf(err, "cannot load plugin %s", c.path)
+	}
+	d, ok := raw.(*dispensed)
+	if !ok {
+		client.Kill()
+		return errors.Errorf("cannot load plugin %s: unexpected type %T", c.path, raw)
+	}
+
+	c.mu.Lock()
+	c.cmd, c.client, c.rpc, c.exited = cmd, client, d.PluginClient, d.done
+	c.mu.Unlock()
+
+	// A restarted plugin keeps the capabilities with which draino was
+	// configured when it first started.
+	ctx, cancel := callContext(c.callTimeout)
+	defer cancel()
+	r, err := d.Capabilities(ctx, &proto.Empty{})
+	if err != nil {
+		c.Kill()
+		return errors.Wrapf(err, "cannot determine capabilities of plugin %s", c.path)
+	}
+	if c.caps == (Capabilities{}) {
+		c.caps = Capabilities{PodFilter: r.GetPodFilter(), NodeFilter: r.GetNodeFilter(), Drainer: r.GetDrainer()}
+	}
+	return nil
+}
+
+// Name returns the name of the plugin, i.e. the base name of its path.
+func (c *Client) Name() string {
+	return filepath.Base(c.path)
+}
+
+// Capabilities returns the extensions the plugin implements.
+func (c *Client) Capabilities() Capabilities {
+	return c.caps
+}
+
+// FilterPod returns true if the plugin determines that the supplied pod
+// should be evicted. It satisfies kubernetes.PodFilterFunc.
+func (c *Client) FilterPod(p core.Pod) (bool, error) {
+	passes, err := c.filterPod(p)
+	return passes, errors.Wrapf(err, "plugin %s cannot filter pod %s/%s", c.Name(), p.GetNamespace(), p.GetName())
+}
+
+func (c *Client) filterPod(p core.Pod) (bool, error) {
+	j, err := json.Marshal(p)
+	if err != nil {
+		return false, errors.Wrap(err, "cannot encode pod")
+	}
+	ctx, cancel := callContext(c.callTimeout)
+	defer cancel()
+	r, err := c.plugin().FilterPod(ctx, &proto.PodRequest{Pod: j})
+	if err != nil {
+		return false, err
+	}
+	return r.GetPasses(), nil
+}
+
+// FilterNode returns true if the supplied object is a node that the plugin
+// determines is eligible for cordoning and draining. Nodes the plugin cannot
+// filter are not eligible.
+func (c *Client) FilterNode(o interface{}) bool {
+	n, ok := o.(*core.Node)
+	if !ok {
+		return false
+	}
+	req, err := nodeRequest(n)
+	if err != nil {
+		return false
+	}
+	ctx, cancel := callContext(c.callTimeout)
+	defer cancel()
+	r, err := c.plugin().FilterNode(ctx, req)
+	if err != nil {
+		return false
+	}
+	return r.GetPasses()
+}
+
+// Cordon the supplied node using the plugin.
+func (c *Client) Cordon(n *core.Node) error {
+	return errors.Wrapf(c.call(n, c.callTimeout, c.plugin().Cordon), "plugin %s cannot cordon node", c.Name())
+}
+
+// Drain the supplied node using the plugin.
+func (c *Client) Drain(n *core.Node) error {
+	return errors.Wrapf(c.call(n, 0, c.plugin().Drain), "plugin %s cannot drain node", c.Name())
+}
+
+// A nodeCall is a method of the Plugin service that is passed a node.
+type nodeCall func(ctx context.Context, req *proto.NodeRequest, o ...grpc.CallOption) (*proto.Empty, error)
+
+// call the supplied method with the supplied node, waiting up to the supplied
+// timeout, if any, for it to return.
+func (c *Client) call(n *core.Node, timeout time.Duration, fn nodeCall) error {
+	req, err := nodeRequest(n)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := callContext(timeout)
+	defer cancel()
+	_, err = fn(ctx, req)
+	return err
+}
+
+func nodeRequest(n *core.Node) (*proto.NodeRequest, error) {
+	j, err := json.Marshal(n)
+	return &proto.NodeRequest{Node: j}, errors.Wrap(err, "cannot encode node")
+}
+
+// callContext returns a context that is cancelled after the supplied
+// timeout, if any.
+func callContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout == 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// plugin returns the connection to the current plugin process.
+func (c *Client) plugin() proto.PluginClient {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rpc
+}
+
+// Run the plugin until the supplied channel is closed, then stop it. A plugin
+// that exits is restarted; calls fail until it has been.
+func (c *Client) Run(stop <-chan struct{}) {
+	for {
+		c.mu.Lock()
+		exited := c.exited
+		c.mu.Unlock()
+		select {
+		case <-stop:
+			c.Kill()
+			return
+		case <-exited:
+		}
+		c.l.Info("Plugin exited; restarting", zap.String("plugin", c.Name()))
+		for {
+			select {
+			case <-stop:
+				c.Kill()
+				return
+			case <-time.After(c.restartDelay):
+			}
+			err := c.start()
+			if err == nil {
+				break
+			}
+			c.l.Info("Failed to restart plugin", zap.String("plugin", c.Name()), zap.Error(err))
+		}
+	}
+}
+
+// Kill the plugin.
+func (c *Client) Kill() {
+	c.mu.Lock()
+	client := c.client
+	c.mu.Unlock()
+	client.Kill()
+}
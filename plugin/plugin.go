@@ -0,0 +1,126 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+// Package plugin implements draino's plugin protocol, which allows binaries
+// built outside of draino to filter pods and nodes, and to cordon and drain
+// nodes.
+//
+// The protocol is that of HashiCorp's go-plugin, using gRPC. A plugin is an
+// executable that draino starts at startup with the environment variable
+// MagicCookieKey set to MagicCookieValue. The plugin listens on a local
+// address and writes a single handshake line to stdout of the form:
+//
+//	CORE-PROTOCOL-VERSION|PROTOCOL-VERSION|NETWORK|ADDRESS|grpc
+//
+// for example 1|2|tcp|127.0.0.1:4321|grpc. Draino then connects to the address
+// and calls the plugin's draino.plugin.Plugin gRPC service, which is defined
+// in proto/plugin.proto. Like any go-plugin gRPC plugin the plugin must also
+// serve the grpc.health.v1.Health service, reporting the "plugin" service as
+// serving. A plugin that exits is restarted. Anything the plugin writes to
+// stderr is passed through to draino's stderr.
+//
+// Plugins written in Go may simply call Serve with an implementation of any
+// of PodFilter, NodeFilter, and Drainer.
+package plugin
+
+import (
+	"context"
+
+	goplugin "github.com/hashicorp/go-plugin"
+	"google.golang.org/grpc"
+	core "k8s.io/api/core/v1"
+
+	"github.com/planetlabs/draino/plugin/proto"
+)
+
+// The plugin handshake.
+const (
+	// ProtocolVersion is the version of the plugin protocol. Draino refuses
+	// to load plugins that speak a different version. Version 1 plugins
+	// spoke JSON-RPC rather than gRPC.
+	ProtocolVersion = 2
+
+	// MagicCookieKey and MagicCookieValue are set in the environment of a
+	// plugin started by draino. They are a basic check that the plugin is
+	// being run by draino, not a user, rather than a security measure.
+	MagicCookieKey   = "DRAINO_PLUGIN_MAGIC_COOKIE"
+	MagicCookieValue = "f9b0d7e3c2a14c36b1a6e8d5f0c47a21"
+
+	// name is the name under which plugins serve their extensions.
+	name = "draino"
+)
+
+// Handshake is the go-plugin handshake of draino plugins.
+var Handshake = goplugin.HandshakeConfig{
+	ProtocolVersion:  ProtocolVersion,
+	MagicCookieKey:   MagicCookieKey,
+	MagicCookieValue: MagicCookieValue,
+}
+
+// A PodFilter plugin determines whether pods should be evicted.
+type PodFilter interface {
+	// FilterPod returns true if the supplied pod should be evicted.
+	FilterPod(p *core.Pod) (bool, error)
+}
+
+// A NodeFilter plugin determines whether nodes are eligible for cordoning and
+// draining.
+type NodeFilter interface {
+	// FilterNode returns true if the supplied node is eligible.
+	FilterNode(n *core.Node) (bool, error)
+}
+
+// A Drainer plugin cordons and drains nodes.
+type Drainer interface {
+	// Cordon the supplied node.
+	Cordon(n *core.Node) error
+
+	// Drain the supplied node, returning once it has been drained.
+	Drain(n *core.Node) error
+}
+
+// Capabilities describes which extensions a plugin implements.
+type Capabilities struct {
+	PodFilter  bool
+	NodeFilter bool
+	Drainer    bool
+}
+
+// grpcPlugin is the go-plugin plugin through which draino plugins are served
+// and dispensed. Only gRPC is supported.
+type grpcPlugin struct {
+	goplugin.NetRPCUnsupportedPlugin
+
+	// impl is the implementation this plugin serves, if any.
+	impl interface{}
+}
+
+func (p *grpcPlugin) GRPCServer(_ *goplugin.GRPCBroker, s *grpc.Server) error {
+	proto.RegisterPluginServer(s, &server{impl: p.impl})
+	return nil
+}
+
+// GRPCClient returns a dispensed plugin. Its done channel is closed once the
+// plugin exits.
+func (p *grpcPlugin) GRPCClient(ctx context.Context, _ *goplugin.GRPCBroker, c *grpc.ClientConn) (interface{}, error) {
+	return &dispensed{PluginClient: proto.NewPluginClient(c), done: ctx.Done()}, nil
+}
+
+// dispensed is a connection to a running plugin.
+type dispensed struct {
+	proto.PluginClient
+	done <-chan struct{}
+}
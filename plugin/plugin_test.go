@@ -0,0 +1,169 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type testPlugin struct{}
+
+func (testPlugin) FilterPod(p *core.Pod) (bool, error) {
+	if p.GetName() == "explode" {
+		return false, errors.New("boom")
+	}
+	return p.GetName() != "protected", nil
+}
+
+func (testPlugin) FilterNode(n *core.Node) (bool, error) {
+	return n.GetLabels()["eligible"] == "true", nil
+}
+
+func (testPlugin) Cordon(_ *core.Node) error { return nil }
+
+func (testPlugin) Drain(_ *core.Node) error { return errors.New("nope") }
+
+// TestMain serves testPlugin when the test binary is started as a plugin.
+func TestMain(m *testing.M) {
+	if os.Getenv(MagicCookieKey) == MagicCookieValue {
+		Serve(testPlugin{})
+		return
+	}
+	os.Exit(m.Run())
+}
+
+func TestPlugin(t *testing.T) {
+	c, err := Start(os.Args[0], nil)
+	if err != nil {
+		t.Fatalf("Start(...): %v", err)
+	}
+	defer c.Kill()
+
+	if diff := deep.Equal(Capabilities{PodFilter: true, NodeFilter: true, Drainer: true}, c.Capabilities()); diff != nil {
+		t.Errorf("c.Capabilities(): want != got: %v", diff)
+	}
+
+	pods := []struct {
+		name       string
+		wantPasses bool
+		wantErr    bool
+	}{
+		{name: "evictable", wantPasses: true},
+		{name: "protected"},
+		{name: "explode", wantErr: true},
+	}
+	for _, p := range pods {
+		passes, err := c.FilterPod(core.Pod{ObjectMeta: meta.ObjectMeta{Name: p.name}})
+		if (err != nil) != p.wantErr {
+			t.Errorf("c.FilterPod(%v): want error %v, got %v", p.name, p.wantErr, err)
+		}
+		if passes != p.wantPasses {
+			t.Errorf("c.FilterPod(%v): want %v, got %v", p.name, p.wantPasses, passes)
+		}
+	}
+
+	eligible := &core.Node{ObjectMeta: meta.ObjectMeta{Name: "eligible", Labels: map[string]string{"eligible": "true"}}}
+	if !c.FilterNode(eligible) {
+		t.Errorf("c.FilterNode(%v): want true, got false", eligible.GetName())
+	}
+	if c.FilterNode(&core.Node{ObjectMeta: meta.ObjectMeta{Name: "ineligible"}}) {
+		t.Errorf("c.FilterNode(ineligible): want false, got true")
+	}
+	if c.FilterNode(&core.Pod{}) {
+		t.Errorf("c.FilterNode(pod): want false, got true")
+	}
+
+	if err := c.Cordon(eligible); err != nil {
+		t.Errorf("c.Cordon(%v): %v", eligible.GetName(), err)
+	}
+	if err := c.Drain(eligible); err == nil {
+		t.Errorf("c.Drain(%v): want error, got nil", eligible.GetName())
+	}
+}
+
+func TestPluginRestart(t *testing.T) {
+	c, err := Start(os.Args[0], nil, WithRestartDelay(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Start(...): %v", err)
+	}
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		c.Run(stop)
+		close(stopped)
+	}()
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+
+	c.mu.Lock()
+	c.cmd.Process.Kill() // nolint:gosec
+	exited := c.exited
+	c.mu.Unlock()
+	<-exited
+
+	eligible := &core.Node{ObjectMeta: meta.ObjectMeta{Name: "eligible", Labels: map[string]string{"eligible": "true"}}}
+	deadline := time.Now().Add(5 * time.Second)
+	for !c.FilterNode(eligible) {
+		if time.Now().After(deadline) {
+			t.Fatalf("plugin was not restarted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestIncompatiblePlugin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plugin")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(...): %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		name      string
+		handshake string
+	}{
+		{name: "JSONRPC", handshake: "1|tcp|127.0.0.1:4321|jsonrpc"},
+		{name: "WrongVersion", handshake: "1|1|tcp|127.0.0.1:4321|grpc"},
+		{name: "NetRPC", handshake: "1|2|tcp|127.0.0.1:4321|netrpc"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(dir, tc.name)
+			script := fmt.Sprintf("#!/bin/sh\necho '%s'\nsleep 10\n", tc.handshake)
+			if err := ioutil.WriteFile(path, []byte(script), 0700); err != nil {
+				t.Fatalf("ioutil.WriteFile(%v): %v", path, err)
+			}
+			if c, err := Start(path, nil); err == nil {
+				c.Kill()
+				t.Errorf("Start(%v): want error, got nil", tc.handshake)
+			}
+		})
+	}
+}
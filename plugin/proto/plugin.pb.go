@@ -0,0 +1,473 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: plugin.proto
+
+package proto
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+import (
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
+
+type Empty struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Empty) Reset()         { *m = Empty{} }
+func (m *Empty) String() string { return proto.CompactTextString(m) }
+func (*Empty) ProtoMessage()    {}
+func (*Empty) Descriptor() ([]byte, []int) {
+	return fileDescriptor_plugin_4c502dd99265fbbd, []int{0}
+}
+func (m *Empty) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Empty.Unmarshal(m, b)
+}
+func (m *Empty) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Empty.Marshal(b, m, deterministic)
+}
+func (dst *Empty) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Empty.Merge(dst, src)
+}
+func (m *Empty) XXX_Size() int {
+	return xxx_messageInfo_Empty.Size(m)
+}
+func (m *Empty) XXX_DiscardUnknown() {
+	xxx_messageInfo_Empty.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Empty proto.InternalMessageInfo
+
+type CapabilitiesResponse struct {
+	PodFilter            bool     `protobuf:"varint,1,opt,name=pod_filter,json=podFilter" json:"pod_filter,omitempty"`
+	NodeFilter           bool     `protobuf:"varint,2,opt,name=node_filter,json=nodeFilter" json:"node_filter,omitempty"`
+	Drainer              bool     `protobuf:"varint,3,opt,name=drainer" json:"drainer,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *CapabilitiesResponse) Reset()         { *m = CapabilitiesResponse{} }
+func (m *CapabilitiesResponse) String() string { return proto.CompactTextString(m) }
+func (*CapabilitiesResponse) ProtoMessage()    {}
+func (*CapabilitiesResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_plugin_4c502dd99265fbbd, []int{1}
+}
+func (m *CapabilitiesResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CapabilitiesResponse.Unmarshal(m, b)
+}
+func (m *CapabilitiesResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CapabilitiesResponse.Marshal(b, m, deterministic)
+}
+func (dst *CapabilitiesResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CapabilitiesResponse.Merge(dst, src)
+}
+func (m *CapabilitiesResponse) XXX_Size() int {
+	return xxx_messageInfo_CapabilitiesResponse.Size(m)
+}
+func (m *CapabilitiesResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_CapabilitiesResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CapabilitiesResponse proto.InternalMessageInfo
+
+func (m *CapabilitiesResponse) GetPodFilter() bool {
+	if m != nil {
+		return m.PodFilter
+	}
+	return false
+}
+
+func (m *CapabilitiesResponse) GetNodeFilter() bool {
+	if m != nil {
+		return m.NodeFilter
+	}
+	return false
+}
+
+func (m *CapabilitiesResponse) GetDrainer() bool {
+	if m != nil {
+		return m.Drainer
+	}
+	return false
+}
+
+type PodRequest struct {
+	// The JSON representation of a pod.
+	Pod                  []byte   `protobuf:"bytes,1,opt,name=pod,proto3" json:"pod,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PodRequest) Reset()         { *m = PodRequest{} }
+func (m *PodRequest) String() string { return proto.CompactTextString(m) }
+func (*PodRequest) ProtoMessage()    {}
+func (*PodRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_plugin_4c502dd99265fbbd, []int{2}
+}
+func (m *PodRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PodRequest.Unmarshal(m, b)
+}
+func (m *PodRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PodRequest.Marshal(b, m, deterministic)
+}
+func (dst *PodRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PodRequest.Merge(dst, src)
+}
+func (m *PodRequest) XXX_Size() int {
+	return xxx_messageInfo_PodRequest.Size(m)
+}
+func (m *PodRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PodRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PodRequest proto.InternalMessageInfo
+
+func (m *PodRequest) GetPod() []byte {
+	if m != nil {
+		return m.Pod
+	}
+	return nil
+}
+
+type NodeRequest struct {
+	// The JSON representation of a node.
+	Node                 []byte   `protobuf:"bytes,1,opt,name=node,proto3" json:"node,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *NodeRequest) Reset()         { *m = NodeRequest{} }
+func (m *NodeRequest) String() string { return proto.CompactTextString(m) }
+func (*NodeRequest) ProtoMessage()    {}
+func (*NodeRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_plugin_4c502dd99265fbbd, []int{3}
+}
+func (m *NodeRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_NodeRequest.Unmarshal(m, b)
+}
+func (m *NodeRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_NodeRequest.Marshal(b, m, deterministic)
+}
+func (dst *NodeRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_NodeRequest.Merge(dst, src)
+}
+func (m *NodeRequest) XXX_Size() int {
+	return xxx_messageInfo_NodeRequest.Size(m)
+}
+func (m *NodeRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_NodeRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_NodeRequest proto.InternalMessageInfo
+
+func (m *NodeRequest) GetNode() []byte {
+	if m != nil {
+		return m.Node
+	}
+	return nil
+}
+
+type FilterResponse struct {
+	Passes               bool     `protobuf:"varint,1,opt,name=passes" json:"passes,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *FilterResponse) Reset()         { *m = FilterResponse{} }
+func (m *FilterResponse) String() string { return proto.CompactTextString(m) }
+func (*FilterResponse) ProtoMessage()    {}
+func (*FilterResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_plugin_4c502dd99265fbbd, []int{4}
+}
+func (m *FilterResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_FilterResponse.Unmarshal(m, b)
+}
+func (m *FilterResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_FilterResponse.Marshal(b, m, deterministic)
+}
+func (dst *FilterResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FilterResponse.Merge(dst, src)
+}
+func (m *FilterResponse) XXX_Size() int {
+	return xxx_messageInfo_FilterResponse.Size(m)
+}
+func (m *FilterResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_FilterResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_FilterResponse proto.InternalMessageInfo
+
+func (m *FilterResponse) GetPasses() bool {
+	if m != nil {
+		return m.Passes
+	}
+	return false
+}
+
+func init() {
+	proto.RegisterType((*Empty)(nil), "draino.plugin.Empty")
+	proto.RegisterType((*CapabilitiesResponse)(nil), "draino.plugin.CapabilitiesResponse")
+	proto.RegisterType((*PodRequest)(nil), "draino.plugin.PodRequest")
+	proto.RegisterType((*NodeRequest)(nil), "draino.plugin.NodeRequest")
+	proto.RegisterType((*FilterResponse)(nil), "draino.plugin.FilterResponse")
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// Client API for Plugin service
+
+type PluginClient interface {
+	// Capabilities returns the extensions the plugin implements.
+	Capabilities(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*CapabilitiesResponse, error)
+	// FilterPod determines whether a pod should be evicted.
+	FilterPod(ctx context.Context, in *PodRequest, opts ...grpc.CallOption) (*FilterResponse, error)
+	// FilterNode determines whether a node is eligible for cordoning and
+	// draining.
+	FilterNode(ctx context.Context, in *NodeRequest, opts ...grpc.CallOption) (*FilterResponse, error)
+	// Cordon a node.
+	Cordon(ctx context.Context, in *NodeRequest, opts ...grpc.CallOption) (*Empty, error)
+	// Drain a node, returning once it has been drained.
+	Drain(ctx context.Context, in *NodeRequest, opts ...grpc.CallOption) (*Empty, error)
+}
+
+type pluginClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewPluginClient(cc *grpc.ClientConn) PluginClient {
+	return &pluginClient{cc}
+}
+
+func (c *pluginClient) Capabilities(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*CapabilitiesResponse, error) {
+	out := new(CapabilitiesResponse)
+	err := grpc.Invoke(ctx, "/draino.plugin.Plugin/Capabilities", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginClient) FilterPod(ctx context.Context, in *PodRequest, opts ...grpc.CallOption) (*FilterResponse, error) {
+	out := new(FilterResponse)
+	err := grpc.Invoke(ctx, "/draino.plugin.Plugin/FilterPod", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginClient) FilterNode(ctx context.Context, in *NodeRequest, opts ...grpc.CallOption) (*FilterResponse, error) {
+	out := new(FilterResponse)
+	err := grpc.Invoke(ctx, "/draino.plugin.Plugin/FilterNode", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginClient) Cordon(ctx context.Context, in *NodeRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := grpc.Invoke(ctx, "/draino.plugin.Plugin/Cordon", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginClient) Drain(ctx context.Context, in *NodeRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := grpc.Invoke(ctx, "/draino.plugin.Plugin/Drain", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for Plugin service
+
+type PluginServer interface {
+	// Capabilities returns the extensions the plugin implements.
+	Capabilities(context.Context, *Empty) (*CapabilitiesResponse, error)
+	// FilterPod determines whether a pod should be evicted.
+	FilterPod(context.Context, *PodRequest) (*FilterResponse, error)
+	// FilterNode determines whether a node is eligible for cordoning and
+	// draining.
+	FilterNode(context.Context, *NodeRequest) (*FilterResponse, error)
+	// Cordon a node.
+	Cordon(context.Context, *NodeRequest) (*Empty, error)
+	// Drain a node, returning once it has been drained.
+	Drain(context.Context, *NodeRequest) (*Empty, error)
+}
+
+func RegisterPluginServer(s *grpc.Server, srv PluginServer) {
+	s.RegisterService(&_Plugin_serviceDesc, srv)
+}
+
+func _Plugin_Capabilities_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).Capabilities(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/draino.plugin.Plugin/Capabilities",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).Capabilities(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Plugin_FilterPod_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PodRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).FilterPod(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/draino.plugin.Plugin/FilterPod",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).FilterPod(ctx, req.(*PodRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Plugin_FilterNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).FilterNode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/draino.plugin.Plugin/FilterNode",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).FilterNode(ctx, req.(*NodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Plugin_Cordon_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).Cordon(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/draino.plugin.Plugin/Cordon",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).Cordon(ctx, req.(*NodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Plugin_Drain_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).Drain(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/draino.plugin.Plugin/Drain",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).Drain(ctx, req.(*NodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Plugin_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "draino.plugin.Plugin",
+	HandlerType: (*PluginServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Capabilities",
+			Handler:    _Plugin_Capabilities_Handler,
+		},
+		{
+			MethodName: "FilterPod",
+			Handler:    _Plugin_FilterPod_Handler,
+		},
+		{
+			MethodName: "FilterNode",
+			Handler:    _Plugin_FilterNode_Handler,
+		},
+		{
+			MethodName: "Cordon",
+			Handler:    _Plugin_Cordon_Handler,
+		},
+		{
+			MethodName: "Drain",
+			Handler:    _Plugin_Drain_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "plugin.proto",
+}
+
+func init() { proto.RegisterFile("plugin.proto", fileDescriptor_plugin_4c502dd99265fbbd) }
+
+var fileDescriptor_plugin_4c502dd99265fbbd = []byte{
+	// 295 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x52, 0x4f, 0x4f, 0xbb, 0x40,
+	0x14, 0x4c, 0xdb, 0x1f, 0xf0, 0xeb, 0x14, 0x8d, 0xd9, 0x34, 0x06, 0x49, 0xea, 0x1f, 0xbc, 0xf4,
+	0xc4, 0x41, 0x4f, 0x7a, 0xb4, 0x56, 0xe3, 0xc5, 0x10, 0x8e, 0x5e, 0x0c, 0xcd, 0xae, 0x66, 0x13,
+	0xe4, 0xad, 0x2c, 0x3d, 0xf8, 0x55, 0xfd, 0x34, 0x86, 0x07, 0x68, 0x25, 0x8d, 0xc6, 0x13, 0xbb,
+	0x33, 0xb3, 0x6f, 0xde, 0x4c, 0x80, 0x6f, 0xf2, 0xf5, 0xb3, 0x2e, 0x62, 0x53, 0x52, 0x45, 0x62,
+	0x47, 0x96, 0x99, 0x2e, 0x28, 0x6e, 0xc0, 0xc8, 0x83, 0xb3, 0x7c, 0x31, 0xd5, 0x5b, 0x64, 0x30,
+	0x5d, 0x64, 0x26, 0x5b, 0xe9, 0x5c, 0x57, 0x5a, 0xd9, 0x54, 0x59, 0x43, 0x85, 0x55, 0x62, 0x06,
+	0x18, 0x92, 0x8f, 0x4f, 0x3a, 0xaf, 0x54, 0x19, 0x0c, 0x8e, 0x07, 0xf3, 0xff, 0xe9, 0xd8, 0x90,
+	0xbc, 0x61, 0x40, 0x1c, 0x61, 0x52, 0x90, 0x54, 0x1d, 0x3f, 0x64, 0x1e, 0x35, 0xd4, 0x0a, 0x02,
+	0x78, 0xec, 0xa8, 0xca, 0x60, 0xc4, 0x64, 0x77, 0x8d, 0x0e, 0x81, 0x84, 0x64, 0xaa, 0x5e, 0xd7,
+	0xca, 0x56, 0x62, 0x0f, 0x23, 0x43, 0x92, 0x0d, 0xfc, 0xb4, 0x3e, 0x46, 0x27, 0x98, 0xdc, 0x93,
+	0x54, 0x9d, 0x40, 0xe0, 0x5f, 0x3d, 0xb6, 0x55, 0xf0, 0x39, 0x9a, 0x63, 0xb7, 0xb1, 0xf9, 0x5c,
+	0x77, 0x1f, 0xae, 0xc9, 0xac, 0x55, 0xb6, 0x5d, 0xb5, 0xbd, 0x9d, 0xbd, 0x0f, 0xe1, 0x26, 0x1c,
+	0x59, 0xdc, 0xc1, 0xdf, 0x4c, 0x2a, 0xa6, 0xf1, 0xb7, 0x4a, 0x62, 0xee, 0x23, 0x3c, 0xed, 0xa1,
+	0x5b, 0xcb, 0x59, 0x62, 0xdc, 0xf8, 0x27, 0x24, 0xc5, 0x41, 0xef, 0xc5, 0x57, 0xb8, 0x70, 0xd6,
+	0xa3, 0x7a, 0x4b, 0xdf, 0x02, 0x0d, 0x52, 0xe7, 0x15, 0x61, 0x4f, 0xbc, 0x51, 0xc2, 0x6f, 0x83,
+	0x2e, 0xe1, 0x2e, 0xa8, 0x94, 0x54, 0xfc, 0x38, 0x64, 0x6b, 0x60, 0x71, 0x01, 0xe7, 0xba, 0x86,
+	0xff, 0xfe, 0xf4, 0xca, 0x7b, 0x70, 0xf8, 0xe7, 0x5a, 0xb9, 0xfc, 0x39, 0xff, 0x18, 0x00, 0x6e,
+	0x8d, 0xd9, 0x6d, 0x73, 0x02, 0x00, 0x00,
+}
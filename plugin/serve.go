@@ -0,0 +1,120 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"github.com/hashicorp/go-hclog"
+	goplugin "github.com/hashicorp/go-plugin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	core "k8s.io/api/core/v1"
+
+	"github.com/planetlabs/draino/plugin/proto"
+)
+
+// Serve the supplied implementation of any of PodFilter, NodeFilter, and
+// Drainer as a plugin. Serve returns once draino stops the plugin. Binaries
+// that are not run by draino print an explanation and exit.
+func Serve(impl interface{}) {
+	goplugin.Serve(&goplugin.ServeConfig{
+		HandshakeConfig: Handshake,
+		Plugins:         goplugin.PluginSet{name: &grpcPlugin{impl: impl}},
+		GRPCServer:      goplugin.DefaultGRPCServer,
+		Logger:          hclog.New(&hclog.LoggerOptions{Level: hclog.Error, Output: os.Stderr}),
+	})
+}
+
+// server adapts an implementation to the methods of the Plugin service.
+type server struct {
+	impl interface{}
+}
+
+func (s *server) Capabilities(_ context.Context, _ *proto.Empty) (*proto.CapabilitiesResponse, error) {
+	r := &proto.CapabilitiesResponse{}
+	_, r.PodFilter = s.impl.(PodFilter)
+	_, r.NodeFilter = s.impl.(NodeFilter)
+	_, r.Drainer = s.impl.(Drainer)
+	return r, nil
+}
+
+func (s *server) FilterPod(_ context.Context, req *proto.PodRequest) (*proto.FilterResponse, error) {
+	f, ok := s.impl.(PodFilter)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "plugin does not filter pods")
+	}
+	p := &core.Pod{}
+	if err := json.Unmarshal(req.GetPod(), p); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "cannot decode pod: %v", err)
+	}
+	passes, err := f.FilterPod(p)
+	if err != nil {
+		return nil, err
+	}
+	return &proto.FilterResponse{Passes: passes}, nil
+}
+
+func (s *server) FilterNode(_ context.Context, req *proto.NodeRequest) (*proto.FilterResponse, error) {
+	f, ok := s.impl.(NodeFilter)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "plugin does not filter nodes")
+	}
+	n, err := decodeNode(req)
+	if err != nil {
+		return nil, err
+	}
+	passes, err := f.FilterNode(n)
+	if err != nil {
+		return nil, err
+	}
+	return &proto.FilterResponse{Passes: passes}, nil
+}
+
+func (s *server) Cordon(_ context.Context, req *proto.NodeRequest) (*proto.Empty, error) {
+	d, ok := s.impl.(Drainer)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "plugin does not cordon nodes")
+	}
+	n, err := decodeNode(req)
+	if err != nil {
+		return nil, err
+	}
+	return &proto.Empty{}, d.Cordon(n)
+}
+
+func (s *server) Drain(_ context.Context, req *proto.NodeRequest) (*proto.Empty, error) {
+	d, ok := s.impl.(Drainer)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "plugin does not drain nodes")
+	}
+	n, err := decodeNode(req)
+	if err != nil {
+		return nil, err
+	}
+	return &proto.Empty{}, d.Drain(n)
+}
+
+func decodeNode(req *proto.NodeRequest) (*core.Node, error) {
+	n := &core.Node{}
+	if err := json.Unmarshal(req.GetNode(), n); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "cannot decode node: %v", err)
+	}
+	return n, nil
+}